@@ -0,0 +1,171 @@
+package e2e
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLLMCache checks the response cache on an instance of its own, the
+// cache being opt-in: a repeated request is answered from the cache there,
+// while a shared instance, without [llm.cache], calls the provider again.
+func TestLLMCache(t *testing.T) {
+	h := NewHarnessConfig(t, registeredConfig+`
+[llm.cache]
+enabled = true
+ttl_hours = 24
+`)
+	defer h.Stop()
+	dba := NewDBAssert(h.NodesDB, h.FlowsDB, h.MetricsDB)
+	defer dba.Close()
+
+	userToken, _ := registerRole(t, h, dba, "cache_user", "user")
+	opToken, _ := registerRole(t, h, dba, "cache_operator", "operator")
+
+	var calls atomic.Int32
+	endpoint := fakeOpenAI(t, func(prompt string) string {
+		calls.Add(1)
+		return "answer to " + prompt
+	})
+
+	type dispatchResult struct {
+		Results []struct {
+			Content string `json:"content"`
+			Cached  bool   `json:"cached"`
+			Error   string `json:"error"`
+		} `json:"results"`
+	}
+	// dispatch sends the same request to the fake provider registered as
+	// provider on h and returns whether it was answered from the cache.
+	dispatch := func(t *testing.T, h *TestHarness, token, provider string) bool {
+		t.Helper()
+		var result dispatchResult
+		resp, err := h.JSON("POST", "/api/inference/dispatch", map[string]interface{}{
+			"prompt": "is this answer cached?",
+			"models": []string{provider + "/cache-1"},
+		}, token, &result)
+		if err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(result.Results) != 1 || result.Results[0].Error != "" {
+			t.Fatalf("dispatch results = %+v, want one answer", result.Results)
+		}
+		return result.Results[0].Cached
+	}
+
+	t.Run("Status", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var status struct {
+			Enabled  bool    `json:"enabled"`
+			TTLHours float64 `json:"ttl_hours"`
+			Entries  int     `json:"entries"`
+			Hits     int     `json:"hits"`
+			SavedUSD float64 `json:"saved_usd"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/cache", nil, "", &status)
+		if err != nil {
+			t.Fatalf("cache status: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if !status.Enabled || status.TTLHours != 24 {
+			t.Errorf("cache status = %+v, want enabled with 24h TTL from config", status)
+		}
+	})
+
+	t.Run("PurgeRequiresOperator", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("DELETE", "/api/llm/cache", nil, userToken)
+		if err != nil {
+			t.Fatalf("purge cache: %v", err)
+		}
+		defer resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+	})
+
+	t.Run("PurgeAll", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var result struct {
+			Status  string `json:"status"`
+			Deleted int    `json:"deleted"`
+		}
+		resp, err := h.JSON("DELETE", "/api/llm/cache?all=1", nil, opToken, &result)
+		if err != nil {
+			t.Fatalf("purge cache: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if result.Status != "purged" {
+			t.Errorf("status = %q, want purged", result.Status)
+		}
+
+		var status struct {
+			Entries int `json:"entries"`
+		}
+		resp, err = h.JSON("GET", "/api/llm/cache", nil, "", &status)
+		if err != nil {
+			t.Fatalf("cache status: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if status.Entries != 0 {
+			t.Errorf("entries after purge = %d, want 0", status.Entries)
+		}
+	})
+
+	t.Run("RepeatedRequestServedFromCache", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if offlineLLM() {
+			t.Skip("the provider is a fake endpoint")
+		}
+
+		registerLocalProvider(t, h, opToken, "cache_local", endpoint.URL+"/v1", "cache-1")
+		calls.Store(0)
+		if dispatch(t, h, opToken, "cache_local") {
+			t.Error("first request answered from the cache")
+		}
+		if !dispatch(t, h, opToken, "cache_local") {
+			t.Error("repeated request not answered from the cache")
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("provider called %d times, want 1", n)
+		}
+	})
+
+	t.Run("DisabledByDefault", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if offlineLLM() {
+			t.Skip("the provider is a fake endpoint")
+		}
+
+		shared, sharedDBA := ensureRegisteredHarness(t)
+		var status struct {
+			Enabled bool `json:"enabled"`
+		}
+		resp, err := shared.JSON("GET", "/api/llm/cache", nil, "", &status)
+		if err != nil {
+			t.Fatalf("cache status: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if status.Enabled {
+			t.Error("cache enabled without [llm.cache] in the config")
+		}
+
+		sharedOp, _ := registerRole(t, shared, sharedDBA, "cache_default_operator", "operator")
+		registerLocalProvider(t, shared, sharedOp, "cache_default_local", endpoint.URL+"/v1", "cache-1")
+		calls.Store(0)
+		if dispatch(t, shared, sharedOp, "cache_default_local") || dispatch(t, shared, sharedOp, "cache_default_local") {
+			t.Error("request answered from a disabled cache")
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("provider called %d times, want 2: each request reaches it", n)
+		}
+	})
+}
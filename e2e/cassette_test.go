@@ -0,0 +1,196 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLLMOffline runs dedicated instances in scripted and replay mode, so
+// flows and dispatch are exercised without API keys or network.
+func TestLLMOffline(t *testing.T) {
+	dir := t.TempDir()
+
+	llmMode := func(t *testing.T, h *TestHarness) string {
+		t.Helper()
+		var health struct {
+			Mode  string `json:"mode"`
+			Total int    `json:"total"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/health", nil, "", &health)
+		if err != nil {
+			t.Fatalf("llm health: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if health.Total == 0 {
+			t.Errorf("expected stand-in providers in %s mode", health.Mode)
+		}
+		return health.Mode
+	}
+
+	type dispatchResult struct {
+		Results []struct {
+			Model    string `json:"model"`
+			Provider string `json:"provider"`
+			Content  string `json:"content"`
+			Error    string `json:"error"`
+		} `json:"results"`
+	}
+	dispatch := func(t *testing.T, h *TestHarness, token, prompt string) dispatchResult {
+		t.Helper()
+		var result dispatchResult
+		resp, err := h.JSON("POST", "/api/inference/dispatch", map[string]interface{}{
+			"prompt": prompt,
+			"models": []string{"gemini/gemini-2.0-flash"},
+		}, token, &result)
+		if err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(result.Results) != 1 {
+			t.Fatalf("results = %d, want 1", len(result.Results))
+		}
+		return result
+	}
+
+	t.Run("ScriptedChallenge", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		script := filepath.Join(dir, "script.toml")
+		if err := os.WriteFile(script, []byte(`
+default = "scripted default answer"
+
+[steps]
+judge = '{"score": 72, "verdict": "the claim holds under scrutiny", "flags": ["single_source"]}'
+decompose = 'Assertions: {"assertions": ["Offline mode needs no API key", "Scripted steps answer by name"]}'
+`), 0o644); err != nil {
+			t.Fatalf("writing script: %v", err)
+		}
+
+		h := NewHarnessWith(t, HarnessOptions{LLMMode: "scripted", Script: script})
+		defer h.Stop()
+
+		if mode := llmMode(t, h); mode != "scripted" {
+			t.Errorf("mode = %q, want scripted", mode)
+		}
+
+		token, _ := h.Register(t, "offline_scripted", "offline-scripted-1234")
+		questionID := h.AskQuestion(t, token, "Does offline scripted mode run a whole flow?", []string{"offline"})
+
+		var created map[string]interface{}
+		resp, err := h.JSON("POST", "/api/challenge/"+questionID, map[string]interface{}{
+			"flow_name": "confrontation",
+		}, token, &created)
+		if err != nil {
+			t.Fatalf("create challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		challengeID, _ := created["id"].(string)
+
+		var result struct {
+			Score      float64 `json:"score"`
+			Summary    string  `json:"summary"`
+			FlowResult struct {
+				Steps []struct {
+					Name     string
+					Response *struct {
+						Content string          `json:"content"`
+						JSON    json.RawMessage `json:"json"`
+					}
+				}
+			} `json:"flow_result"`
+			Moderation *struct {
+				Flags string `json:"flags"`
+			} `json:"moderation"`
+		}
+		resp, err = h.JSON("POST", "/api/challenge/"+challengeID+"/run", nil, token, &result)
+		if err != nil {
+			t.Fatalf("run challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+
+		steps := result.FlowResult.Steps
+		if len(steps) == 0 {
+			t.Fatal("expected flow steps")
+		}
+		for _, s := range steps {
+			if s.Response == nil {
+				t.Errorf("step %s has no response", s.Name)
+				continue
+			}
+			if s.Name == "judge" {
+				if len(s.Response.JSON) == 0 {
+					t.Error("judge step has no structured output")
+				}
+				continue
+			}
+			if want := "scripted default answer"; s.Response.Content != want {
+				t.Errorf("step %s content = %q, want %q", s.Name, s.Response.Content, want)
+			}
+		}
+
+		// The judge's verdict is typed data: score and summary come from it.
+		if result.Score != 72 {
+			t.Errorf("score = %v, want 72", result.Score)
+		}
+		if result.Summary != "the claim holds under scrutiny" {
+			t.Errorf("summary = %q, want the judge's verdict", result.Summary)
+		}
+		if result.Moderation == nil || !strings.Contains(result.Moderation.Flags, "single_source") {
+			t.Errorf("moderation = %+v, want the judge's flags", result.Moderation)
+		}
+
+		// Decomposition extracts the scripted JSON from surrounding prose.
+		claimID := h.AnswerNode(t, token, questionID, "Offline runs are reproducible without keys", "claim")
+		var decomposed struct {
+			Assertions []string `json:"assertions"`
+		}
+		resp, err = h.JSON("POST", "/api/node/"+claimID+"/decompose", nil, token, &decomposed)
+		if err != nil {
+			t.Fatalf("decompose: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(decomposed.Assertions) != 2 || decomposed.Assertions[0] != "Offline mode needs no API key" {
+			t.Errorf("assertions = %q, want the scripted pair", decomposed.Assertions)
+		}
+	})
+
+	t.Run("ReplayCassette", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		entry, _ := json.Marshal(map[string]interface{}{
+			"provider": "gemini",
+			"model":    "gemini-2.0-flash",
+			"messages": []map[string]string{{"role": "user", "content": "What is the boiling point of water at sea level?"}},
+			"content":  "100 °C (recorded)",
+		})
+		cassette := filepath.Join(dir, "replay.cassette.jsonl")
+		if err := os.WriteFile(cassette, append(entry, '\n'), 0o644); err != nil {
+			t.Fatalf("writing cassette: %v", err)
+		}
+
+		h := NewHarnessWith(t, HarnessOptions{LLMMode: "replay", Cassette: cassette})
+		defer h.Stop()
+
+		if mode := llmMode(t, h); mode != "replay" {
+			t.Errorf("mode = %q, want replay", mode)
+		}
+		token, _ := h.Register(t, "offline_replay", "offline-replay-1234")
+
+		hit := dispatch(t, h, token, "What is the boiling point of water at sea level?")
+		if r := hit.Results[0]; r.Content != "100 °C (recorded)" || r.Error != "" {
+			t.Errorf("replayed result = %+v, want recorded content", r)
+		}
+
+		miss := dispatch(t, h, token, "A prompt that was never recorded")
+		if r := miss.Results[0]; !strings.Contains(r.Error, "no recorded response") {
+			t.Errorf("unrecorded prompt error = %q, want cassette miss", r.Error)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestChallengeWorkflows checks that the built-in challenge flows are
+// seeded as workflows and that running a challenge goes through the
+// workflow engine: the challenge links its workflow run, failed calls are
+// retried, the audit log records the run and model grants apply.
+func TestChallengeWorkflows(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("the target model is a fake endpoint")
+	}
+
+	// The endpoint fails the first call for a "flaky" question, once.
+	var (
+		mu     sync.Mutex
+		failed = map[string]bool{}
+	)
+	endpoint := fakeOpenAIWith(t, func(_ *http.Request, prompt string) (string, int) {
+		mu.Lock()
+		flaky := strings.Contains(prompt, "flaky") && !failed[prompt]
+		failed[prompt] = true
+		mu.Unlock()
+		if flaky {
+			return "temporarily overloaded", http.StatusInternalServerError
+		}
+		return "a sourced answer", http.StatusOK
+	})
+
+	token, userID := registerRole(t, h, dba, "chalwf_user", "user")
+	opToken, _ := registerRole(t, h, dba, "chalwf_operator", "operator")
+
+	registerLocalProvider(t, h, opToken, "chalwf_local", endpoint.URL+"/v1", "chalwf-1")
+
+	type challengeRun struct {
+		ChallengeID   string
+		RunID         string
+		FlowID        string
+		WorkflowRunID string
+		FirstError    string
+	}
+	runChallenge := func(t *testing.T, question string) challengeRun {
+		t.Helper()
+		questionID := h.AskQuestion(t, token, question, nil)
+		var created struct {
+			ID string `json:"id"`
+		}
+		resp, err := h.JSON("POST", "/api/challenge/"+questionID, map[string]interface{}{
+			"flow_name":       "confrontation",
+			"target_provider": "chalwf_local",
+			"target_model":    "chalwf-1",
+		}, token, &created)
+		if err != nil {
+			t.Fatalf("create challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+
+		var result struct {
+			FlowResult struct {
+				FlowID string
+				RunID  string
+				Steps  []struct {
+					Name  string
+					Error interface{}
+				}
+			} `json:"flow_result"`
+		}
+		resp, err = h.JSON("POST", "/api/challenge/"+created.ID+"/run", nil, token, &result)
+		if err != nil {
+			t.Fatalf("run challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(result.FlowResult.Steps) == 0 || result.FlowResult.Steps[0].Name != "respond" {
+			t.Fatalf("steps = %+v, want respond first", result.FlowResult.Steps)
+		}
+
+		var stored struct {
+			WorkflowRunID string `json:"workflow_run_id"`
+		}
+		resp, err = h.JSON("GET", "/api/challenge/"+created.ID, nil, "", &stored)
+		if err != nil {
+			t.Fatalf("get challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+
+		run := challengeRun{
+			ChallengeID:   created.ID,
+			RunID:         result.FlowResult.RunID,
+			FlowID:        result.FlowResult.FlowID,
+			WorkflowRunID: stored.WorkflowRunID,
+		}
+		if e := result.FlowResult.Steps[0].Error; e != nil {
+			run.FirstError, _ = dba.QueryFlowScalar(t, `SELECT COALESCE(error,'') FROM flow_steps WHERE flow_id = ? AND step_index = 0`, run.FlowID).(string)
+		}
+		return run
+	}
+	auditCount := func(t *testing.T, runID, event string) int64 {
+		t.Helper()
+		n, _ := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log WHERE run_id = ? AND event_type = ?`, runID, event).(int64)
+		return n
+	}
+
+	var confrontationID string
+
+	t.Run("FlowsSeededAsWorkflows", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var workflows []struct {
+			WorkflowID   string `json:"workflow_id"`
+			Name         string `json:"name"`
+			WorkflowType string `json:"workflow_type"`
+			Status       string `json:"status"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows", nil, opToken, &workflows)
+		if err != nil {
+			t.Fatalf("list workflows: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		challenges := map[string]string{}
+		for _, wf := range workflows {
+			if wf.WorkflowType == "challenge" {
+				challenges[wf.Name] = wf.Status
+				if wf.Name == "confrontation" {
+					confrontationID = wf.WorkflowID
+				}
+			}
+		}
+		for _, name := range []string{"confrontation", "red_team", "fidelity_benchmark", "adversarial_detection", "deep_dive", "safety_scoring"} {
+			if challenges[name] != "active" {
+				t.Errorf("challenge workflow %s: status %q, want active", name, challenges[name])
+			}
+		}
+
+		var wf struct {
+			Steps []struct {
+				StepName   string `json:"step_name"`
+				Provider   string `json:"provider"`
+				ConfigJSON string `json:"config_json"`
+			} `json:"steps"`
+		}
+		resp, err = h.JSON("GET", "/api/workflows/"+confrontationID, nil, opToken, &wf)
+		if err != nil {
+			t.Fatalf("get workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(wf.Steps) != 4 {
+			t.Fatalf("%d steps, want the flow's 4", len(wf.Steps))
+		}
+		if s := wf.Steps[0]; s.StepName != "respond" || s.Provider != "$TARGET" || !strings.Contains(s.ConfigJSON, "get_tree") {
+			t.Errorf("first step = %+v, want respond on $TARGET with its tools", s)
+		}
+		if s := wf.Steps[3]; s.StepName != "judge" || !strings.Contains(s.ConfigJSON, `"schema":"judge"`) {
+			t.Errorf("last step = %+v, want the judge with its schema", s)
+		}
+	})
+
+	t.Run("ChallengeLinksWorkflowRun", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		run := runChallenge(t, "Challenge workflows: does a challenge run as a workflow?")
+		if run.FirstError != "" {
+			t.Fatalf("respond step failed: %s", run.FirstError)
+		}
+		if run.WorkflowRunID == "" || run.WorkflowRunID != run.RunID {
+			t.Fatalf("challenge workflow_run_id = %q, flow result run = %q, want the same run", run.WorkflowRunID, run.RunID)
+		}
+
+		var wfRun struct {
+			WorkflowID  string `json:"workflow_id"`
+			InitiatedBy string `json:"initiated_by"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows/runs/"+run.RunID, nil, token, &wfRun)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if confrontationID != "" && wfRun.WorkflowID != confrontationID {
+			t.Errorf("run workflow = %s, want confrontation %s", wfRun.WorkflowID, confrontationID)
+		}
+		if wfRun.InitiatedBy != userID {
+			t.Errorf("run initiated by %s, want the challenger %s", wfRun.InitiatedBy, userID)
+		}
+
+		var stepRuns []struct {
+			StepName     string  `json:"step_name"`
+			Status       string  `json:"status"`
+			ProviderUsed *string `json:"provider_used"`
+		}
+		resp, err = h.JSON("GET", "/api/workflows/runs/"+run.RunID+"/steps", nil, token, &stepRuns)
+		if err != nil {
+			t.Fatalf("get step runs: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(stepRuns) == 0 || stepRuns[0].StepName != "respond" || stepRuns[0].Status != "completed" ||
+			stepRuns[0].ProviderUsed == nil || *stepRuns[0].ProviderUsed != "chalwf_local" {
+			t.Errorf("step runs = %+v, want respond completed on chalwf_local", stepRuns)
+		}
+
+		if n := auditCount(t, run.RunID, "run_started"); n != 1 {
+			t.Errorf("%d run_started audit entries, want 1", n)
+		}
+		if n := auditCount(t, run.RunID, "step_completed"); n < 1 {
+			t.Error("no step_completed audit entry for the run")
+		}
+		if rows := dba.QueryFlowSteps(t, run.FlowID); len(rows) == 0 {
+			t.Error("no flow_steps trace under the challenge's flow_id")
+		}
+	})
+
+	t.Run("FailedCallRetried", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		run := runChallenge(t, "Challenge workflows: does a flaky provider get a second chance?")
+		if run.FirstError != "" {
+			t.Fatalf("respond step failed despite the retry: %s", run.FirstError)
+		}
+		retried := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log a
+			JOIN workflow_step_runs s ON s.step_run_id = a.step_run_id
+			WHERE a.run_id = ? AND a.event_type = 'step_retried' AND s.step_order = 1`, run.RunID)
+		if n, _ := retried.(int64); n != 1 {
+			t.Errorf("respond step retried %v times, want 1", retried)
+		}
+	})
+
+	t.Run("Abuse_ModelGrantDenied", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var grant struct {
+			GrantID string `json:"grant_id"`
+		}
+		resp, err := h.JSON("POST", "/api/model-grants", map[string]interface{}{
+			"grantee_type": "user",
+			"grantee_id":   userID,
+			"model_id":     "chalwf-1",
+			"step_type":    "llm",
+			"effect":       "deny",
+		}, opToken, &grant)
+		if err != nil {
+			t.Fatalf("create grant: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		defer func() {
+			resp, err := h.Do("DELETE", "/api/model-grants/"+grant.GrantID, nil, opToken)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+
+		run := runChallenge(t, "Challenge workflows: can a denied model still answer?")
+		if !strings.Contains(run.FirstError, "model grant denied") {
+			t.Errorf("respond error = %q, want the model grant denial", run.FirstError)
+		}
+		reason := dba.QueryFlowScalar(t, `SELECT COALESCE(json_extract(event_data_json, '$.reason'),'') FROM workflow_audit_log WHERE run_id = ? AND event_type = 'step_failed'`, run.RunID)
+		if reason != "model_grant_denied" {
+			t.Errorf("step_failed reason = %v, want model_grant_denied", reason)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package e2e
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLLMCosts(t *testing.T) {
+	h, dba := ensureHarness(t)
+
+	userToken, userID := registerRole(t, h, dba, "cost_user", "user")
+	opToken, _ := registerRole(t, h, dba, "cost_operator", "operator")
+
+	type price struct {
+		Provider      string  `json:"provider"`
+		ModelName     string  `json:"model_name"`
+		InputPerMTok  float64 `json:"input_per_mtok"`
+		OutputPerMTok float64 `json:"output_per_mtok"`
+		Source        string  `json:"source"`
+	}
+	listPrices := func(t *testing.T, provider string) []price {
+		t.Helper()
+		var result struct {
+			Prices []price `json:"prices"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/prices?provider="+provider, nil, "", &result)
+		if err != nil {
+			t.Fatalf("list prices: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		return result.Prices
+	}
+
+	t.Run("SeededPrices", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		found := false
+		for _, p := range listPrices(t, "gemini") {
+			if p.ModelName == "gemini-2.0-flash" {
+				found = true
+				if p.InputPerMTok <= 0 || p.OutputPerMTok <= 0 {
+					t.Errorf("seed price not positive: %+v", p)
+				}
+			}
+		}
+		if !found {
+			t.Error("expected seeded price for gemini/gemini-2.0-flash")
+		}
+	})
+
+	t.Run("SetPriceRequiresOperator", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/llm/prices", map[string]interface{}{
+			"provider": "gemini", "model_name": "e2e-cost-model", "input_per_mtok": 1, "output_per_mtok": 2,
+		}, userToken)
+		if err != nil {
+			t.Fatalf("set price: %v", err)
+		}
+		defer resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+	})
+
+	t.Run("SetPriceAndEstimate", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/llm/prices", map[string]interface{}{
+			"provider": "gemini", "model_name": "e2e-cost-model", "input_per_mtok": 1, "output_per_mtok": 2,
+		}, opToken)
+		if err != nil {
+			t.Fatalf("set price: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+
+		var source string
+		for _, p := range listPrices(t, "gemini") {
+			if p.ModelName == "e2e-cost-model" {
+				source = p.Source
+			}
+		}
+		if source != "operator" {
+			t.Errorf("price source = %q, want operator", source)
+		}
+
+		var est struct {
+			Estimates []struct {
+				Model   string  `json:"model"`
+				CostUSD float64 `json:"cost_usd"`
+				Priced  bool    `json:"priced"`
+			} `json:"estimates"`
+			TotalUSD float64 `json:"total_usd"`
+		}
+		// 10 calls × (1000 in × $1 + 500 out × $2) per million tokens = $0.02
+		resp, err = h.JSON("POST", "/api/llm/estimate", map[string]interface{}{
+			"models":        []string{"gemini/e2e-cost-model-v2", "nowhere/unpriced"},
+			"calls":         10,
+			"prompt_tokens": 1000,
+			"output_tokens": 500,
+		}, userToken, &est)
+		if err != nil {
+			t.Fatalf("estimate: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+
+		if len(est.Estimates) != 2 {
+			t.Fatalf("estimates = %d, want 2", len(est.Estimates))
+		}
+		if !est.Estimates[0].Priced || math.Abs(est.Estimates[0].CostUSD-0.02) > 1e-9 {
+			t.Errorf("prefix-matched estimate = %+v, want $0.02", est.Estimates[0])
+		}
+		if est.Estimates[1].Priced || est.Estimates[1].CostUSD != 0 {
+			t.Errorf("unpriced estimate = %+v, want priced=false cost=0", est.Estimates[1])
+		}
+		if math.Abs(est.TotalUSD-0.02) > 1e-9 {
+			t.Errorf("total_usd = %v, want 0.02", est.TotalUSD)
+		}
+
+		resp, err = h.Do("DELETE", "/api/llm/prices/gemini/e2e-cost-model", nil, opToken)
+		if err != nil {
+			t.Fatalf("delete price: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+	})
+
+	t.Run("UserBudget", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/llm/budgets", map[string]interface{}{
+			"scope": "user", "scope_id": userID, "limit_usd": 0.5,
+		}, userToken)
+		if err != nil {
+			t.Fatalf("set budget: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+
+		var budget struct {
+			BudgetID string  `json:"budget_id"`
+			LimitUSD float64 `json:"limit_usd"`
+		}
+		resp, err = h.JSON("POST", "/api/llm/budgets", map[string]interface{}{
+			"scope": "user", "scope_id": userID, "limit_usd": 0.5,
+		}, opToken, &budget)
+		if err != nil {
+			t.Fatalf("set budget: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if budget.BudgetID == "" || budget.LimitUSD != 0.5 {
+			t.Fatalf("budget = %+v", budget)
+		}
+
+		var spend struct {
+			UserID  string `json:"user_id"`
+			Budgets []struct {
+				Scope        string  `json:"scope"`
+				ScopeID      string  `json:"scope_id"`
+				LimitUSD     float64 `json:"limit_usd"`
+				RemainingUSD float64 `json:"remaining_usd"`
+			} `json:"budgets"`
+		}
+		resp, err = h.JSON("GET", "/api/llm/spend", nil, userToken, &spend)
+		if err != nil {
+			t.Fatalf("spend: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if spend.UserID != userID {
+			t.Errorf("spend user_id = %q, want own id %q", spend.UserID, userID)
+		}
+		found := false
+		for _, b := range spend.Budgets {
+			if b.Scope == "user" && b.ScopeID == userID {
+				found = true
+				if b.LimitUSD != 0.5 || b.RemainingUSD != 0.5 {
+					t.Errorf("user budget status = %+v, want limit=remaining=0.5", b)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected user budget in spend report, got %+v", spend.Budgets)
+		}
+
+		resp, err = h.Do("DELETE", "/api/llm/budgets/"+budget.BudgetID, nil, opToken)
+		if err != nil {
+			t.Fatalf("delete budget: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+	})
+
+	t.Run("InvalidBudgetScope", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/llm/budgets", map[string]interface{}{
+			"scope": "galaxy", "limit_usd": 1,
+		}, opToken)
+		if err != nil {
+			t.Fatalf("set budget: %v", err)
+		}
+		defer resp.Body.Close()
+		RequireStatus(t, resp, http.StatusBadRequest)
+	})
+}
@@ -257,6 +257,22 @@ func (d *DBAssert) QueryScalar(t *testing.T, query string, args ...interface{})
 	return result
 }
 
+// QueryFlowScalar runs a single-value query on flows.db and returns it.
+func (d *DBAssert) QueryFlowScalar(t *testing.T, query string, args ...interface{}) interface{} {
+	t.Helper()
+	db, err := d.flows()
+	if err != nil {
+		t.Fatalf("opening flows.db: %v", err)
+	}
+
+	var result interface{}
+	err = db.QueryRow(query, args...).Scan(&result)
+	if err != nil {
+		t.Fatalf("flows scalar query: %v", err)
+	}
+	return result
+}
+
 // QueryScalarInt runs a single integer query on nodes.db.
 func (d *DBAssert) QueryScalarInt(t *testing.T, query string, args ...interface{}) int {
 	t.Helper()
@@ -14,6 +14,23 @@ var (
 	harnessOnce sync.Once
 )
 
+// registeredHarness and registeredDBA are shared the same way by the tests
+// that route to providers they register (see ensureRegisteredHarness).
+var (
+	registeredHarness *TestHarness
+	registeredDBA     *DBAssert
+	registeredOnce    sync.Once
+)
+
+// registeredConfig turns self-registered providers into LLM backends,
+// which the base config leaves off.
+const registeredConfig = `
+[llm.registered]
+enabled = true
+stale_minutes = 10
+sync_seconds = 5
+`
+
 // ensureHarness starts the harness on first call. All subsequent calls
 // return the existing harness. The first caller's testing.T is used
 // for server lifecycle management.
@@ -33,6 +50,25 @@ func ensureHarness(t *testing.T) (*TestHarness, *DBAssert) {
 	return harness, dba
 }
 
+// ensureRegisteredHarness is ensureHarness for an instance with
+// registered providers enabled: tests that serve an endpoint and register
+// it as a provider run there, apart from the rest of the suite.
+func ensureRegisteredHarness(t *testing.T) (*TestHarness, *DBAssert) {
+	t.Helper()
+	registeredOnce.Do(func() {
+		registeredHarness = NewHarnessConfig(t, registeredConfig)
+		registeredDBA = NewDBAssert(registeredHarness.NodesDB, registeredHarness.FlowsDB, registeredHarness.MetricsDB)
+
+		wd, _ := os.Getwd()
+		InitGlobalResults(wd)
+	})
+
+	if registeredHarness == nil {
+		t.Fatal("registered harness initialization failed")
+	}
+	return registeredHarness, registeredDBA
+}
+
 // TestMain configures the test binary and ensures proper cleanup.
 func TestMain(m *testing.M) {
 	exitCode := m.Run()
@@ -44,6 +80,12 @@ func TestMain(m *testing.M) {
 	if harness != nil {
 		harness.Stop()
 	}
+	if registeredDBA != nil {
+		registeredDBA.Close()
+	}
+	if registeredHarness != nil {
+		registeredHarness.Stop()
+	}
 	CloseGlobalResults()
 
 	os.Exit(exitCode)
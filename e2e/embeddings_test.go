@@ -0,0 +1,231 @@
+package e2e
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestEmbeddings registers an OpenAI-compatible endpoint whose /embeddings
+// hashes words into a small vector (texts sharing words end up close),
+// backfills the vector index with it and checks nearest-neighbour search
+// and embedding dedup against what was indexed.
+func TestEmbeddings(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("offline stand-ins cannot embed")
+	}
+
+	var embedCalls atomic.Int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		embedCalls.Add(1)
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		data := make([]map[string]interface{}, len(req.Input))
+		for i, text := range req.Input {
+			// Reverse order: the client must place vectors by index.
+			data[len(req.Input)-1-i] = map[string]interface{}{"index": i, "embedding": wordVector(text)}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"model": req.Model,
+			"data":  data,
+			"usage": map[string]int{"prompt_tokens": 4 * len(req.Input)},
+		})
+	}))
+	defer endpoint.Close()
+
+	const model = "emb_local/embed-1"
+	ownerToken, _ := registerRole(t, h, dba, "embed_owner", "user")
+	opToken, _ := registerRole(t, h, dba, "embed_operator", "operator")
+
+	registerLocalProvider(t, h, opToken, "emb_local", endpoint.URL+"/v1", "embed-1")
+
+	solarA := h.AskQuestion(t, ownerToken, "Do rooftop photovoltaic panels lose efficiency during cold winter months?", []string{"energy"})
+	solarB := h.AskQuestion(t, ownerToken, "Why does photovoltaic panel efficiency change in winter months?", []string{"energy"})
+	castle := h.AskQuestion(t, ownerToken, "Which medieval castles used concentric curtain walls?", []string{"history"})
+
+	type hit struct {
+		OwnerID string  `json:"owner_id"`
+		Score   float64 `json:"score"`
+	}
+	search := func(t *testing.T, body map[string]interface{}) []hit {
+		t.Helper()
+		body["model"] = model
+		var out struct {
+			Embedder string `json:"embedder"`
+			Results  []hit  `json:"results"`
+		}
+		resp, err := h.JSON("POST", "/api/embeddings/search", body, "", &out)
+		if err != nil {
+			t.Fatalf("embedding search: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if out.Embedder != model {
+			t.Errorf("embedder = %q, want %q", out.Embedder, model)
+		}
+		return out.Results
+	}
+
+	t.Run("BackfillIndexesPublicNodes", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var res struct {
+			Embedder string `json:"embedder"`
+			Nodes    int    `json:"nodes"`
+		}
+		resp, err := h.JSON("POST", "/api/embeddings/backfill", map[string]interface{}{"model": model, "limit": 1000}, opToken, &res)
+		if err != nil {
+			t.Fatalf("backfill: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if res.Embedder != model || res.Nodes < 3 {
+			t.Errorf("backfill = %+v, want at least the 3 new questions under %s", res, model)
+		}
+		for _, id := range []string{solarA, solarB, castle} {
+			if n := dba.QueryScalarInt(t, `SELECT COUNT(*) FROM embeddings WHERE owner_type = 'node' AND owner_id = ? AND embedder = ?`, id, model); n != 1 {
+				t.Errorf("node %s has %d vectors, want 1", id, n)
+			}
+		}
+
+		calls := embedCalls.Load()
+		resp, err = h.JSON("POST", "/api/embeddings/backfill", map[string]interface{}{"model": model}, opToken, &res)
+		if err != nil {
+			t.Fatalf("second backfill: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if res.Nodes != 0 || embedCalls.Load() != calls {
+			t.Errorf("second backfill embedded %d nodes (%d calls), want nothing left to do", res.Nodes, embedCalls.Load()-calls)
+		}
+	})
+
+	t.Run("SearchByText", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		results := search(t, map[string]interface{}{"text": "photovoltaic efficiency in winter", "roots_only": true, "k": 5})
+		if len(results) < 2 {
+			t.Fatalf("results = %+v, want the two solar questions", results)
+		}
+		top := map[string]bool{results[0].OwnerID: true, results[1].OwnerID: true}
+		if !top[solarA] || !top[solarB] {
+			t.Errorf("top two = %+v, want %s and %s", results[:2], solarA, solarB)
+		}
+		for _, r := range results {
+			if r.OwnerID == castle && r.Score >= results[1].Score {
+				t.Errorf("castle question scored %.3f, not below the solar questions", r.Score)
+			}
+		}
+	})
+
+	t.Run("SearchAroundNode", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		results := search(t, map[string]interface{}{"owner_id": solarA, "roots_only": true, "k": 3})
+		if len(results) == 0 || results[0].OwnerID != solarB {
+			t.Fatalf("results = %+v, want %s first", results, solarB)
+		}
+		for _, r := range results {
+			if r.OwnerID == solarA {
+				t.Error("the query node is listed as its own neighbour")
+			}
+		}
+	})
+
+	t.Run("EmbeddingDedup", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var out struct {
+			Matches []struct {
+				NodeID string `json:"node_id"`
+				Method string `json:"method"`
+			} `json:"matches"`
+		}
+		resp, err := h.JSON("POST", "/api/dedup/check", map[string]interface{}{
+			"body":      "Do rooftop photovoltaic panels lose efficiency in cold winter months?",
+			"method":    "embedding",
+			"model":     model,
+			"threshold": 0.7,
+		}, "", &out)
+		if err != nil {
+			t.Fatalf("dedup check: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		found := false
+		for _, m := range out.Matches {
+			if m.NodeID == solarA && m.Method == "embedding" {
+				found = true
+			}
+			if m.NodeID == castle {
+				t.Error("unrelated question reported as a duplicate")
+			}
+		}
+		if !found {
+			t.Errorf("matches = %+v, want %s by embedding", out.Matches, solarA)
+		}
+	})
+
+	t.Run("Abuse_BackfillNotOperator", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/embeddings/backfill", map[string]interface{}{"model": model}, ownerToken)
+		if err != nil {
+			t.Fatalf("backfill: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+	})
+
+	t.Run("UnknownEmbedder", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/embeddings/search", map[string]interface{}{
+			"text": "anything", "model": "no_such_provider/x",
+		}, "")
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusServiceUnavailable)
+
+		resp, err = h.Do("POST", "/api/embeddings/search", map[string]interface{}{"text": "anything", "kind": "tree"}, "")
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusBadRequest)
+	})
+}
+
+// wordVector hashes the words of text into 64 buckets.
+func wordVector(text string) []float32 {
+	v := make([]float32, 64)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	}) {
+		if len(w) < 3 {
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(w))
+		v[h.Sum32()%64]++
+	}
+	return v
+}
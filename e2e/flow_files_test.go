@@ -0,0 +1,224 @@
+package e2e
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFlowFiles writes TOML flow files into the harness flows directory and
+// checks that they are picked up without a restart, validated, and merged
+// with the built-in flows.
+func TestFlowFiles(t *testing.T) {
+	h, dba := ensureHarness(t)
+
+	dir := filepath.Join(h.DataDir, "flows")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("creating flows dir: %v", err)
+	}
+	write := func(t *testing.T, file, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			t.Fatalf("writing %s: %v", file, err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, f := range []string{"devils_advocate.toml", "confrontation.toml", "broken.toml"} {
+			os.Remove(filepath.Join(dir, f))
+		}
+		// Leave the built-ins alone for the tests that follow.
+		waitFlows(t, h, func(flows map[string]flowListing) bool { return len(flows) == 6 })
+	})
+
+	token, _ := registerRole(t, h, dba, "flowfiles_user", "user")
+	opToken, _ := registerRole(t, h, dba, "flowfiles_operator", "operator")
+
+	const devilsAdvocate = `description = "Argue against the claim, then judge"
+
+[[steps]]
+name = "oppose"
+role = "attacker"
+provider = "$TARGET"
+model = "$TARGET"
+prompt = "Argue against this claim (node {{.NodeID}}):\n\n{{.Body}}"
+
+[[steps]]
+name = "judge"
+role = "judge"
+prompt = "Claim:\n{{.Body}}\n\nObjection:\n{{.Step.oppose}}\n\nScore 0-100."
+schema = "judge"
+`
+
+	t.Run("NewFlowLoaded", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		write(t, "devils_advocate.toml", devilsAdvocate)
+		flows := waitFlows(t, h, func(flows map[string]flowListing) bool { _, ok := flows["devils_advocate"]; return ok })
+		if f := flows["devils_advocate"]; f.Source != "devils_advocate.toml" || f.StepCount != 2 {
+			t.Errorf("devils_advocate = %+v, want 2 steps from devils_advocate.toml", f)
+		}
+		if f := flows["confrontation"]; f.Source != "builtin" {
+			t.Errorf("confrontation source = %q, want builtin", f.Source)
+		}
+
+		question := h.AskQuestion(t, token, "Flow files: is remote work more productive than office work?", nil)
+		resp, err := h.Do("POST", "/api/challenge/"+question, map[string]interface{}{"flow_name": "devils_advocate"}, token)
+		if err != nil {
+			t.Fatalf("create challenge: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusCreated)
+	})
+
+	t.Run("InvalidEditKeepsLastVersion", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		// The judge now reads a step that does not exist.
+		write(t, "devils_advocate.toml", strings.Replace(devilsAdvocate, "{{.Step.oppose}}", "{{.Step.rebut}}", 1))
+		status := waitFlowStatus(t, h, opToken, "devils_advocate.toml", func(f flowFileStatus) bool { return f.Error != "" })
+		if !strings.Contains(status.Error, "rebut") {
+			t.Errorf("error = %q, want it to name the bad reference", status.Error)
+		}
+		if status.Flow != "devils_advocate" {
+			t.Errorf("flow in use = %q, want the last valid devils_advocate", status.Flow)
+		}
+		if _, ok := listFlows(t, h)["devils_advocate"]; !ok {
+			t.Error("devils_advocate dropped after an invalid edit")
+		}
+	})
+
+	t.Run("OverrideBuiltin", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		write(t, "confrontation.toml", `description = "Shorter confrontation"
+
+[[steps]]
+name = "judge"
+role = "judge"
+prompt = "Judge this claim: {{.Body}}"
+schema = "judge"
+`)
+		flows := waitFlows(t, h, func(flows map[string]flowListing) bool { return flows["confrontation"].Source == "confrontation.toml" })
+		if f := flows["confrontation"]; f.StepCount != 1 {
+			t.Errorf("confrontation has %d steps, want the file's 1", f.StepCount)
+		}
+		if len(flows) != 7 {
+			t.Errorf("%d flows listed, want the 6 built-ins and devils_advocate", len(flows))
+		}
+	})
+
+	t.Run("Abuse_InvalidFileRejected", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		write(t, "broken.toml", `[[steps]]
+name = "only"
+role = "oracle"
+promt = "typo in the key"
+`)
+		status := waitFlowStatus(t, h, opToken, "broken.toml", func(f flowFileStatus) bool { return f.Error != "" })
+		for _, want := range []string{"promt", "role"} {
+			if !strings.Contains(status.Error, want) {
+				t.Errorf("error = %q, want it to mention %q", status.Error, want)
+			}
+		}
+		if _, ok := listFlows(t, h)["broken"]; ok {
+			t.Error("invalid flow file listed")
+		}
+
+		question := h.AskQuestion(t, token, "Flow files: does a rejected flow accept challenges?", nil)
+		resp, err := h.Do("POST", "/api/challenge/"+question, map[string]interface{}{"flow_name": "broken"}, token)
+		if err != nil {
+			t.Fatalf("create challenge: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("Abuse_StatusRequiresOperator", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("GET", "/api/flows/status", nil, token)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+	})
+}
+
+type flowListing struct {
+	Name      string `json:"name"`
+	StepCount int    `json:"step_count"`
+	Source    string `json:"source"`
+}
+
+type flowFileStatus struct {
+	File  string `json:"file"`
+	Flow  string `json:"flow"`
+	Error string `json:"error"`
+}
+
+func listFlows(t *testing.T, h *TestHarness) map[string]flowListing {
+	t.Helper()
+	var flows []flowListing
+	resp, err := h.JSON("GET", "/api/flows", nil, "", &flows)
+	if err != nil {
+		t.Fatalf("list flows: %v", err)
+	}
+	RequireStatus(t, resp, http.StatusOK)
+	out := make(map[string]flowListing, len(flows))
+	for _, f := range flows {
+		out[f.Name] = f
+	}
+	return out
+}
+
+// waitFlows polls /api/flows until done accepts the listing (the harness
+// reloads flow files every second).
+func waitFlows(t *testing.T, h *TestHarness, done func(map[string]flowListing) bool) map[string]flowListing {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		flows := listFlows(t, h)
+		if done(flows) {
+			return flows
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("flow files not reloaded: %+v", flows)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// waitFlowStatus polls /api/flows/status until file's entry satisfies done.
+func waitFlowStatus(t *testing.T, h *TestHarness, token, file string, done func(flowFileStatus) bool) flowFileStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var status struct {
+			Files []flowFileStatus `json:"files"`
+		}
+		resp, err := h.JSON("GET", "/api/flows/status", nil, token, &status)
+		if err != nil {
+			t.Fatalf("flow status: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		for _, f := range status.Files {
+			if f.File == file && done(f) {
+				return f
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: no matching status in %+v", file, status.Files)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,298 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestFlowRounds runs a debate flow file whose judge loops back to the
+// attacker until it declares convergence, and checks the rounds, the
+// multi-turn messages, the early exit edge and the flow_steps trace.
+func TestFlowRounds(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("the judge's convergence is scripted by the fake endpoint")
+	}
+
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	var (
+		mu          sync.Mutex
+		judgeCalls  = map[string]int{}
+		attackTurns = map[string][][]message{}
+	)
+	// scenario is the word of the claim that drives the fake judge.
+	scenario := func(text string) string {
+		for _, s := range []string{"converging", "stubborn", "hopeless"} {
+			if strings.Contains(text, s) {
+				return s
+			}
+		}
+		return ""
+	}
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string    `json:"model"`
+			Messages []message `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		last := req.Messages[len(req.Messages)-1].Content
+
+		mu.Lock()
+		var content string
+		switch {
+		case strings.Contains(last, "Converged?"):
+			s := scenario(last)
+			judgeCalls[s]++
+			n := judgeCalls[s]
+			converged := s == "converging" && n == 2
+			score := 50
+			if s == "hopeless" {
+				score = 5
+			}
+			content = fmt.Sprintf(`{"converged": %t, "score": %d, "verdict": "judged %d"}`, converged, score, n)
+		case strings.Contains(last, "ATTACK"):
+			s := scenario(req.Messages[0].Content)
+			attackTurns[s] = append(attackTurns[s], req.Messages)
+			content = fmt.Sprintf("attack %d", len(attackTurns[s]))
+		case strings.Contains(last, "DEFEND"):
+			content = "defence"
+		default:
+			content = "summary"
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"model": req.Model,
+			"choices": []map[string]interface{}{{
+				"message":       map[string]string{"role": "assistant", "content": content},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{"prompt_tokens": 10, "completion_tokens": 5},
+		})
+	}))
+	defer endpoint.Close()
+
+	token, _ := registerRole(t, h, dba, "rounds_user", "user")
+	opToken, _ := registerRole(t, h, dba, "rounds_operator", "operator")
+
+	registerLocalProvider(t, h, opToken, "rounds_local", endpoint.URL+"/v1", "debater-1")
+
+	dir := filepath.Join(h.DataDir, "flows")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("creating flows dir: %v", err)
+	}
+	write := func(t *testing.T, file, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			t.Fatalf("writing %s: %v", file, err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, f := range []string{"debate.toml", "bad_edges.toml"} {
+			os.Remove(filepath.Join(dir, f))
+		}
+		waitFlows(t, h, func(flows map[string]flowListing) bool { return len(flows) == 6 })
+	})
+
+	write(t, "debate.toml", `description = "Debate until the judge sees convergence"
+max_rounds = 3
+
+[[steps]]
+name = "attack"
+role = "attacker"
+provider = "$TARGET"
+model = "$TARGET"
+history = true
+prompt = "ATTACK {{if eq .Round 1}}this claim: {{.Body}}{{else}}again, round {{.Round}}{{end}}"
+
+[[steps]]
+name = "defend"
+role = "defender"
+provider = "$TARGET"
+model = "$TARGET"
+history = true
+prompt = "DEFEND the claim against: {{.Step.attack}}"
+
+[[steps]]
+name = "judge"
+role = "judge"
+provider = "$TARGET"
+model = "$TARGET"
+prompt = "Claim: {{.Body}}\n\nAttack: {{.Step.attack}}\n\nDefence: {{.Step.defend}}\n\nConverged?"
+schema = '{"type":"object","required":["converged","score","verdict"],"properties":{"converged":{"type":"boolean"},"score":{"type":"number"},"verdict":{"type":"string"}}}'
+
+[[steps.next]]
+field = "score"
+below = 10
+goto = "end"
+
+[[steps.next]]
+field = "converged"
+equals = false
+goto = "attack"
+
+[[steps]]
+name = "summary"
+role = "synthesizer"
+provider = "$TARGET"
+model = "$TARGET"
+prompt = "Summarize: {{.Step.judge}}"
+`)
+	waitFlows(t, h, func(flows map[string]flowListing) bool { _, ok := flows["debate"]; return ok })
+
+	type stepResult struct {
+		Name  string
+		Round int
+		Error interface{}
+	}
+	runDebate := func(t *testing.T, claim string) (string, []stepResult) {
+		t.Helper()
+		question := h.AskQuestion(t, token, claim, nil)
+		var created struct {
+			ID string `json:"id"`
+		}
+		resp, err := h.JSON("POST", "/api/challenge/"+question, map[string]interface{}{
+			"flow_name":       "debate",
+			"target_provider": "rounds_local",
+			"target_model":    "debater-1",
+		}, token, &created)
+		if err != nil {
+			t.Fatalf("create challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+
+		var result struct {
+			FlowResult struct {
+				FlowID string
+				Steps  []stepResult
+			} `json:"flow_result"`
+		}
+		resp, err = h.JSON("POST", "/api/challenge/"+created.ID+"/run", nil, token, &result)
+		if err != nil {
+			t.Fatalf("run challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		return result.FlowResult.FlowID, result.FlowResult.Steps
+	}
+	trace := func(steps []stepResult) string {
+		parts := make([]string, len(steps))
+		for i, s := range steps {
+			parts[i] = fmt.Sprintf("%s/%d", s.Name, s.Round)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	t.Run("LoopsUntilConvergence", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		flowID, steps := runDebate(t, "Rounds: a converging debate about four-day work weeks")
+		want := "attack/1 defend/1 judge/1 attack/2 defend/2 judge/2 summary/2"
+		if got := trace(steps); got != want {
+			t.Fatalf("steps = %s, want %s", got, want)
+		}
+
+		rows := dba.QueryFlowSteps(t, flowID)
+		if len(rows) != 7 {
+			t.Fatalf("%d flow_steps rows, want 7", len(rows))
+		}
+		indexes := dba.QueryFlowScalar(t, `SELECT COUNT(DISTINCT step_index) FROM flow_steps WHERE flow_id = ?`, flowID)
+		if n, _ := indexes.(int64); n != 7 {
+			t.Errorf("%v distinct step indexes, want 7", indexes)
+		}
+		second := dba.QueryFlowScalar(t, `SELECT COALESCE(step_name,'') || '/' || COALESCE(round,0) FROM flow_steps WHERE flow_id = ? AND step_index = 3`, flowID)
+		if second != "attack/2" {
+			t.Errorf("step_index 3 = %v, want attack/2", second)
+		}
+	})
+
+	t.Run("AttackerSeesConversation", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		mu.Lock()
+		turns := attackTurns["converging"]
+		mu.Unlock()
+		if len(turns) != 2 {
+			t.Fatalf("%d attack calls, want 2", len(turns))
+		}
+		roles := func(ms []message) string {
+			r := make([]string, len(ms))
+			for i, m := range ms {
+				r[i] = m.Role
+			}
+			return strings.Join(r, ",")
+		}
+		if got := roles(turns[0]); got != "user" {
+			t.Errorf("round 1 roles = %s, want user", got)
+		}
+		round2 := turns[1]
+		if got := roles(round2); got != "user,assistant,user" {
+			t.Fatalf("round 2 roles = %s, want user,assistant,user", got)
+		}
+		if round2[1].Content != "attack 1" {
+			t.Errorf("assistant turn = %q, want the first attack", round2[1].Content)
+		}
+		last := round2[2].Content
+		for _, want := range []string{"[defend]\ndefence", "[judge]", "ATTACK again, round 2"} {
+			if !strings.Contains(last, want) {
+				t.Errorf("last user turn = %q, want it to contain %q", last, want)
+			}
+		}
+	})
+
+	t.Run("RoundLimit", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		_, steps := runDebate(t, "Rounds: a stubborn debate about daylight saving time")
+		want := "attack/1 defend/1 judge/1 attack/2 defend/2 judge/2 attack/3 defend/3 judge/3 summary/3"
+		if got := trace(steps); got != want {
+			t.Errorf("steps = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("EdgeEndsFlow", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		_, steps := runDebate(t, "Rounds: a hopeless debate about tabs and spaces")
+		if got := trace(steps); got != "attack/1 defend/1 judge/1" {
+			t.Errorf("steps = %s, want the flow to end after the first judge", got)
+		}
+	})
+
+	t.Run("Abuse_BadEdgesRejected", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		write(t, "bad_edges.toml", `[[steps]]
+name = "only"
+role = "judge"
+prompt = "{{.Body}}"
+
+[[steps.next]]
+field = "converged"
+equals = false
+goto = "nowhere"
+`)
+		status := waitFlowStatus(t, h, opToken, "bad_edges.toml", func(f flowFileStatus) bool { return f.Error != "" })
+		for _, want := range []string{"nowhere", "schema"} {
+			if !strings.Contains(status.Error, want) {
+				t.Errorf("error = %q, want it to mention %q", status.Error, want)
+			}
+		}
+	})
+}
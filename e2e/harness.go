@@ -29,11 +29,43 @@ type TestHarness struct {
 	cmd    *exec.Cmd
 	client *http.Client
 	port   int
+
+	binary     string
+	configPath string
+	workDir    string
+}
+
+// HarnessOptions selects how the spawned instance reaches LLM providers.
+type HarnessOptions struct {
+	LLMMode  string // live (default), record, replay or scripted
+	Cassette string // cassette file for record / replay
+	Script   string // script file for scripted mode
+	Config   string // TOML appended to the instance's config.toml
 }
 
 // NewHarness builds a config, starts horostracker serve, and waits for health.
+// E2E_LLM_MODE, E2E_LLM_CASSETTE and E2E_LLM_SCRIPT select the LLM mode, so
+// the LLM suites can be recorded once with real keys and replayed offline.
 func NewHarness(t *testing.T) *TestHarness {
 	t.Helper()
+	return NewHarnessConfig(t, "")
+}
+
+// NewHarnessConfig is NewHarness with config appended to the instance's
+// config.toml, for tests of features the base config leaves off.
+func NewHarnessConfig(t *testing.T, config string) *TestHarness {
+	t.Helper()
+	return NewHarnessWith(t, HarnessOptions{
+		LLMMode:  os.Getenv("E2E_LLM_MODE"),
+		Cassette: os.Getenv("E2E_LLM_CASSETTE"),
+		Script:   os.Getenv("E2E_LLM_SCRIPT"),
+		Config:   config,
+	})
+}
+
+// NewHarnessWith starts an instance with explicit options.
+func NewHarnessWith(t *testing.T, opts HarnessOptions) *TestHarness {
+	t.Helper()
 
 	// Find free port
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
@@ -92,13 +124,26 @@ verify_signatures = true
 peer_instances = []
 
 [llm]
+mode = %q
+cassette = %q
+script = %q
 gemini_api_key = %q
 mistral_api_key = ""
 openrouter_api_key = ""
 groq_api_key = ""
 anthropic_api_key = %q
 huggingface_api_key = ""
-`, port, nodesDB, flowsDB, metricsDB, geminiKey, anthropicKey)
+
+[llm.embeddings]
+enabled = true
+interval_seconds = 2
+
+[llm.flows]
+dir = %q
+reload_seconds = 1
+`, port, nodesDB, flowsDB, metricsDB, opts.LLMMode, opts.Cassette, opts.Script, geminiKey, anthropicKey, filepath.Join(dataDir, "flows"))
+
+	config += opts.Config
 
 	configPath := filepath.Join(dataDir, "config.toml")
 	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
@@ -112,24 +157,13 @@ huggingface_api_key = ""
 		t.Fatalf("binary not found at %s — run: cd horostracker && CGO_ENABLED=0 go build -o horostracker .", binary)
 	}
 
-	// Start subprocess
 	parentDir, _ := filepath.Abs(filepath.Join(wd, ".."))
-	cmd := exec.Command(binary, "serve", "--config", configPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = parentDir
-
-	if err := cmd.Start(); err != nil {
-		t.Fatalf("starting horostracker: %v", err)
-	}
-
 	h := &TestHarness{
 		BaseURL:   fmt.Sprintf("https://127.0.0.1:%d", port),
 		DataDir:   dataDir,
 		NodesDB:   nodesDB,
 		FlowsDB:   flowsDB,
 		MetricsDB: metricsDB,
-		cmd:       cmd,
 		port:      port,
 		client: &http.Client{
 			Timeout: 60 * time.Second,
@@ -137,7 +171,29 @@ huggingface_api_key = ""
 				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 			},
 		},
+		binary:     binary,
+		configPath: configPath,
+		workDir:    parentDir,
+	}
+	if !h.start(t) {
+		h.Stop()
+		t.Fatalf("horostracker did not become ready within 15s on port %d", port)
 	}
+	return h
+}
+
+// start spawns the subprocess and waits for health.
+func (h *TestHarness) start(t *testing.T) bool {
+	t.Helper()
+	cmd := exec.Command(h.binary, "serve", "--config", h.configPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = h.workDir
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting horostracker: %v", err)
+	}
+	h.cmd = cmd
 
 	// Health check
 	deadline := time.Now().Add(15 * time.Second)
@@ -147,8 +203,8 @@ huggingface_api_key = ""
 		if err == nil {
 			resp.Body.Close()
 			if resp.StatusCode == http.StatusOK {
-				t.Logf("horostracker ready on port %d", port)
-				return h
+				t.Logf("horostracker ready on port %d", h.port)
+				return true
 			}
 		}
 		time.Sleep(backoff)
@@ -157,9 +213,27 @@ huggingface_api_key = ""
 		}
 	}
 
-	h.Stop()
-	t.Fatalf("horostracker did not become ready within 15s on port %d", port)
-	return nil
+	return false
+}
+
+// Crash kills the subprocess without warning, as a crash or power loss
+// would, and keeps the data directory for Restart.
+func (h *TestHarness) Crash() {
+	if h.cmd == nil || h.cmd.Process == nil {
+		return
+	}
+	h.cmd.Process.Kill()
+	h.cmd.Wait()
+	h.cmd = nil
+}
+
+// Restart starts a crashed instance again on the same config, port and
+// data directory, and waits for health.
+func (h *TestHarness) Restart(t *testing.T) {
+	t.Helper()
+	if !h.start(t) {
+		t.Fatalf("horostracker did not come back within 15s on port %d", h.port)
+	}
 }
 
 // Stop sends SIGTERM, waits 5s, then SIGKILL. Cleans up the data directory.
@@ -308,19 +382,26 @@ func bodyString(resp *http.Response) string {
 	return string(data)
 }
 
-// HasLLM returns true if at least one LLM API key is configured.
+// HasLLM returns true if at least one LLM API key is configured, or the
+// instance answers offline from a cassette or script.
 func HasLLM() bool {
-	return os.Getenv("ANTHROPIC_API_KEY") != "" || os.Getenv("GEMINI_API_KEY") != ""
+	return os.Getenv("ANTHROPIC_API_KEY") != "" || os.Getenv("GEMINI_API_KEY") != "" || offlineLLM()
 }
 
-// HasAnthropic returns true if the Anthropic API key is set.
+// HasAnthropic returns true if the Anthropic API key is set (or offline).
 func HasAnthropic() bool {
-	return os.Getenv("ANTHROPIC_API_KEY") != ""
+	return os.Getenv("ANTHROPIC_API_KEY") != "" || offlineLLM()
 }
 
-// HasGemini returns true if the Gemini API key is set.
+// HasGemini returns true if the Gemini API key is set (or offline).
 func HasGemini() bool {
-	return os.Getenv("GEMINI_API_KEY") != ""
+	return os.Getenv("GEMINI_API_KEY") != "" || offlineLLM()
+}
+
+// offlineLLM reports whether the harness runs in replay or scripted mode.
+func offlineLLM() bool {
+	mode := os.Getenv("E2E_LLM_MODE")
+	return mode == "replay" || mode == "scripted"
 }
 
 // AskQuestion is a helper that creates a question node and returns its ID.
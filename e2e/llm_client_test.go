@@ -0,0 +1,139 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLLMClient(t *testing.T) {
+	h, dba := ensureHarness(t)
+
+	userToken, _ := registerRole(t, h, dba, "llmc_user", "user")
+	opToken, _ := registerRole(t, h, dba, "llmc_operator", "operator")
+
+	t.Run("HealthSnapshot", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var result struct {
+			Providers []map[string]interface{} `json:"providers"`
+			Healthy   int                      `json:"healthy"`
+			Total     int                      `json:"total"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/health", nil, "", &result)
+		if err != nil {
+			t.Fatalf("llm health: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+
+		if result.Total != len(result.Providers) {
+			t.Errorf("total = %d, providers = %d", result.Total, len(result.Providers))
+		}
+		if HasLLM() && result.Total == 0 {
+			t.Error("expected at least one provider when API keys are set")
+		}
+		for _, p := range result.Providers {
+			switch p["state"] {
+			case "closed", "open", "half_open":
+			default:
+				t.Errorf("provider %v: unexpected state %v", p["provider"], p["state"])
+			}
+			if _, leaked := p["last_error"]; leaked {
+				t.Errorf("provider %v: raw error text must not be exposed", p["provider"])
+			}
+		}
+	})
+
+	t.Run("ResetRequiresOperator", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/llm/health/gemini/reset", nil, userToken)
+		if err != nil {
+			t.Fatalf("reset breaker: %v", err)
+		}
+		defer resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+	})
+
+	t.Run("ResetUnknownProvider", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/llm/health/no-such-provider/reset", nil, opToken)
+		if err != nil {
+			t.Fatalf("reset breaker: %v", err)
+		}
+		defer resp.Body.Close()
+		RequireStatus(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("NoLimitsByDefault", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var result struct {
+			Count int `json:"count"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/limits", nil, "", &result)
+		if err != nil {
+			t.Fatalf("llm limits: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if result.Count != 0 {
+			t.Errorf("%d limits without [[llm.limits]] in the config, want 0", result.Count)
+		}
+	})
+
+	// Quotas are opt-in: this instance is the only one with a limit.
+	t.Run("LimitsFromConfig", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		h := NewHarnessConfig(t, `
+[[llm.limits]]
+provider = "gemini"
+requests_per_minute = 600
+max_in_flight = 8
+`)
+		defer h.Stop()
+
+		var result struct {
+			Limits []struct {
+				Provider string `json:"provider"`
+				Model    string `json:"model"`
+				Limit    struct {
+					RequestsPerMinute int `json:"requests_per_minute"`
+					TokensPerMinute   int `json:"tokens_per_minute"`
+					MaxInFlight       int `json:"max_in_flight"`
+				} `json:"limit"`
+				InFlight int `json:"in_flight"`
+				Waiting  int `json:"waiting"`
+			} `json:"limits"`
+			Count int `json:"count"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/limits", nil, "", &result)
+		if err != nil {
+			t.Fatalf("llm limits: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+
+		found := false
+		for _, l := range result.Limits {
+			if l.Provider != "gemini" || l.Model != "" {
+				continue
+			}
+			found = true
+			if l.Limit.RequestsPerMinute != 600 || l.Limit.MaxInFlight != 8 {
+				t.Errorf("gemini limit = %+v, want rpm=600 max_in_flight=8", l.Limit)
+			}
+			if l.Limit.TokensPerMinute != 0 {
+				t.Errorf("tokens_per_minute = %d, want 0 (unlimited)", l.Limit.TokensPerMinute)
+			}
+		}
+		if !found {
+			t.Errorf("expected gemini limit from the test's config, got %+v", result.Limits)
+		}
+	})
+}
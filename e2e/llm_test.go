@@ -1,8 +1,8 @@
 package e2e
 
 import (
+	"encoding/json"
 	"net/http"
-	"os"
 	"strings"
 	"testing"
 	"time"
@@ -36,7 +36,7 @@ func TestLLM(t *testing.T) {
 	})
 
 	t.Run("BotAnswerClaude", func(t *testing.T) {
-		if os.Getenv("ANTHROPIC_API_KEY") == "" {
+		if !HasAnthropic() {
 			t.Skip("ANTHROPIC_API_KEY not set")
 		}
 		start := time.Now()
@@ -75,7 +75,7 @@ func TestLLM(t *testing.T) {
 	})
 
 	t.Run("BotAnswerGemini", func(t *testing.T) {
-		if os.Getenv("GEMINI_API_KEY") == "" {
+		if !HasGemini() {
 			t.Skip("GEMINI_API_KEY not set")
 		}
 		start := time.Now()
@@ -130,6 +130,53 @@ func TestLLM(t *testing.T) {
 		}
 	})
 
+	t.Run("ResolutionStream", func(t *testing.T) {
+		if !HasLLM() {
+			t.Skip("no LLM API keys set")
+		}
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		token, _ := h.Register(t, "llm_stream_user", "streampass1234")
+		questionID := h.AskQuestion(t, token, "Is nuclear power a viable path to decarbonization?", nil)
+		h.AnswerNode(t, token, questionID, "Nuclear provides stable low-carbon baseload power", "claim")
+
+		data, resp, err := h.RawBody("POST", "/api/resolution/"+questionID+"?stream=1", map[string]interface{}{}, token)
+		if err != nil {
+			t.Fatalf("resolution stream: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", resp.StatusCode, truncate(string(data), 500))
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Errorf("Content-Type = %q, want text/event-stream", ct)
+		}
+
+		body := string(data)
+		if !strings.Contains(body, "event: delta") {
+			t.Error("expected at least one delta event")
+		}
+		_, donePart, ok := strings.Cut(body, "event: done\ndata: ")
+		if !ok {
+			t.Fatalf("expected done event, got: %s", truncate(body, 500))
+		}
+		var done struct {
+			Generation struct {
+				Content string `json:"content"`
+			} `json:"generation"`
+		}
+		if err := json.Unmarshal([]byte(strings.SplitN(donePart, "\n", 2)[0]), &done); err != nil {
+			t.Fatalf("decoding done event: %v", err)
+		}
+
+		// flow_steps holds the full streamed response, not a fragment
+		stored := dba.QueryFlowScalar(t, `SELECT response_raw FROM flow_steps
+			WHERE node_id = ? AND flow_id LIKE 'res_%' ORDER BY created_at DESC LIMIT 1`, questionID)
+		if s, _ := stored.(string); s == "" || s != done.Generation.Content {
+			t.Errorf("flow_steps response_raw does not match streamed content (%d vs %d bytes)", len(s), len(done.Generation.Content))
+		}
+	})
+
 	t.Run("GetResolutions", func(t *testing.T) {
 		if !HasLLM() {
 			t.Skip("no LLM API keys set")
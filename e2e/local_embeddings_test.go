@@ -0,0 +1,179 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLocalEmbeddings exercises the built-in embedder: no provider is
+// registered and no key is needed, so it also runs in offline modes.
+func TestLocalEmbeddings(t *testing.T) {
+	h, dba := ensureHarness(t)
+
+	ownerToken, _ := registerRole(t, h, dba, "local_embed_owner", "user")
+	opToken, _ := registerRole(t, h, dba, "local_embed_operator", "operator")
+
+	beeA := h.AskQuestion(t, ownerToken, "How do honeybee colonies choose a new nest site when swarming?", []string{"biology"})
+	beeB := h.AskQuestion(t, ownerToken, "Why do swarming honeybee colonies scout several nest sites?", []string{"biology"})
+	tides := h.AskQuestion(t, ownerToken, "What causes spring tides and neap tides along coastlines?", []string{"geography"})
+
+	var embedder string
+
+	t.Run("StatusListsLocalEmbedder", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var status struct {
+			Enabled   bool              `json:"enabled"`
+			Providers map[string]string `json:"providers"`
+		}
+		resp, err := h.JSON("GET", "/api/embeddings/status?model=local", nil, "", &status)
+		if err != nil {
+			t.Fatalf("status: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if !status.Enabled || status.Providers["local"] == "" {
+			t.Errorf("status = %+v, want the local embedder listed", status)
+		}
+	})
+
+	t.Run("BackfillWithoutProvider", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var res struct {
+			Embedder string `json:"embedder"`
+			Nodes    int    `json:"nodes"`
+		}
+		resp, err := h.JSON("POST", "/api/embeddings/backfill", map[string]interface{}{"model": "local", "limit": 5000}, opToken, &res)
+		if err != nil {
+			t.Fatalf("backfill: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if !strings.HasPrefix(res.Embedder, "local/") {
+			t.Fatalf("embedder = %q, want local/<model>", res.Embedder)
+		}
+		embedder = res.Embedder
+		for _, id := range []string{beeA, beeB, tides} {
+			if n := dba.QueryScalarInt(t, `SELECT COUNT(*) FROM embeddings WHERE owner_type = 'node' AND owner_id = ? AND embedder = ?`, id, embedder); n != 1 {
+				t.Errorf("node %s has %d local vectors, want 1", id, n)
+			}
+		}
+	})
+
+	t.Run("SearchByText", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if embedder == "" {
+			t.Skip("backfill failed")
+		}
+
+		var out struct {
+			Embedder string `json:"embedder"`
+			Results  []struct {
+				OwnerID string  `json:"owner_id"`
+				Score   float64 `json:"score"`
+			} `json:"results"`
+		}
+		resp, err := h.JSON("POST", "/api/embeddings/search", map[string]interface{}{
+			"text": "how honeybee swarms pick a nest site", "model": "local", "roots_only": true, "k": 5,
+		}, "", &out)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if out.Embedder != embedder {
+			t.Errorf("embedder = %q, want %q", out.Embedder, embedder)
+		}
+		if len(out.Results) < 2 {
+			t.Fatalf("results = %+v, want the two honeybee questions", out.Results)
+		}
+		top := map[string]bool{out.Results[0].OwnerID: true, out.Results[1].OwnerID: true}
+		if !top[beeA] || !top[beeB] {
+			t.Errorf("top two = %+v, want %s and %s", out.Results[:2], beeA, beeB)
+		}
+		for _, r := range out.Results {
+			if r.OwnerID == tides && r.Score >= out.Results[1].Score {
+				t.Errorf("tides question scored %.3f, not below the honeybee questions", r.Score)
+			}
+		}
+	})
+
+	t.Run("DedupWithoutProvider", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if embedder == "" {
+			t.Skip("backfill failed")
+		}
+
+		var out struct {
+			Matches []struct {
+				NodeID string `json:"node_id"`
+				Method string `json:"method"`
+			} `json:"matches"`
+		}
+		resp, err := h.JSON("POST", "/api/dedup/check", map[string]interface{}{
+			"body":      "How do honeybee colonies choose their new nest site when they swarm?",
+			"method":    "embedding",
+			"model":     "local",
+			"threshold": 0.6,
+		}, "", &out)
+		if err != nil {
+			t.Fatalf("dedup check: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		found := false
+		for _, m := range out.Matches {
+			if m.NodeID == beeA && m.Method == "embedding" {
+				found = true
+			}
+			if m.NodeID == tides {
+				t.Error("unrelated question reported as a duplicate")
+			}
+		}
+		if !found {
+			t.Errorf("matches = %+v, want %s by embedding", out.Matches, beeA)
+		}
+	})
+
+	t.Run("VectorsNotMixed", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if embedder == "" {
+			t.Skip("backfill failed")
+		}
+
+		// Asking for a remote embedder must not fall back to local vectors.
+		resp, err := h.Do("POST", "/api/embeddings/search", map[string]interface{}{
+			"text": "honeybee nest site", "model": "no_such_provider/embed",
+		}, "")
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusServiceUnavailable)
+
+		if n := dba.QueryScalarInt(t, `SELECT COUNT(*) FROM embeddings WHERE owner_type = 'node' AND owner_id = ? AND embedder LIKE 'local/%' AND embedder != ?`, beeA, embedder); n != 0 {
+			t.Errorf("node %s has %d vectors from another local embedder", beeA, n)
+		}
+	})
+
+	t.Run("Abuse_RegisterLocalName", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/providers/register", map[string]interface{}{
+			"name":      "local",
+			"endpoint":  "http://127.0.0.1:1/v1",
+			"api_style": "openai",
+			"models":    []string{"embed-1"},
+		}, opToken)
+		if err != nil {
+			t.Fatalf("register: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,117 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	h, _ := ensureHarness(t)
+
+	t.Run("HTTPRouteStats", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		// Distinct node IDs must aggregate under the one route pattern.
+		for _, id := range []string{"metrics-missing-1", "metrics-missing-2", "metrics-missing-3"} {
+			resp, err := h.Do("GET", "/api/node/"+id, nil, "")
+			if err != nil {
+				t.Fatalf("get node: %v", err)
+			}
+			resp.Body.Close()
+		}
+
+		var result struct {
+			Hours  int `json:"hours"`
+			Routes []struct {
+				Method       string  `json:"method"`
+				Route        string  `json:"route"`
+				Calls        int     `json:"calls"`
+				ErrorRate    float64 `json:"error_rate"`
+				P50Ms        int     `json:"p50_ms"`
+				P95Ms        int     `json:"p95_ms"`
+				ClientErrors int     `json:"client_errors"`
+			} `json:"routes"`
+		}
+		resp, err := h.JSON("GET", "/api/metrics/http?hours=1", nil, "", &result)
+		if err != nil {
+			t.Fatalf("http metrics: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if result.Hours != 1 {
+			t.Errorf("hours = %d, want 1", result.Hours)
+		}
+
+		found := false
+		for _, rs := range result.Routes {
+			if rs.Route == "/api/node/metrics-missing-1" {
+				t.Errorf("raw path recorded instead of route pattern: %+v", rs)
+			}
+			if rs.Method == "GET" && rs.Route == "/api/node/{id}" {
+				found = true
+				if rs.Calls < 3 {
+					t.Errorf("calls = %d, want >= 3", rs.Calls)
+				}
+				if rs.P95Ms < rs.P50Ms {
+					t.Errorf("p95 %d < p50 %d", rs.P95Ms, rs.P50Ms)
+				}
+				if rs.ClientErrors < 3 {
+					t.Errorf("client_errors = %d, want >= 3 (404s)", rs.ClientErrors)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected GET /api/node/{id} in route stats, got %+v", result.Routes)
+		}
+	})
+
+	t.Run("LLMAndMCPStats", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		for _, path := range []string{"/api/metrics/llm", "/api/metrics/mcp"} {
+			var result map[string]interface{}
+			resp, err := h.JSON("GET", path, nil, "", &result)
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			RequireStatus(t, resp, http.StatusOK)
+			if result["hours"] != float64(24) {
+				t.Errorf("%s hours = %v, want default 24", path, result["hours"])
+			}
+		}
+	})
+
+	t.Run("DailyRollup", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var result struct {
+			Stats []struct {
+				Date   string  `json:"date"`
+				Metric string  `json:"metric"`
+				Value  float64 `json:"value"`
+			} `json:"stats"`
+		}
+		resp, err := h.JSON("GET", "/api/metrics/daily?days=1&prefix=http.", nil, "", &result)
+		if err != nil {
+			t.Fatalf("daily metrics: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+
+		today := time.Now().UTC().Format("2006-01-02")
+		var requests float64
+		for _, s := range result.Stats {
+			if len(s.Metric) < 5 || s.Metric[:5] != "http." {
+				t.Errorf("metric %q does not match prefix http.", s.Metric)
+			}
+			if s.Date == today && s.Metric == "http.requests" {
+				requests = s.Value
+			}
+		}
+		if requests < 1 {
+			t.Errorf("http.requests for %s = %v, want >= 1", today, requests)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestModelDiscovery registers a provider whose model listing carries
+// OpenRouter-style metadata and checks that a refresh records context
+// sizes, capabilities and prices, that the probe retires a dead model, and
+// that steps needing a capability their model lacks are rejected.
+func TestModelDiscovery(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("offline stand-ins have no model listing and answer every model")
+	}
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/models") {
+			_, _ = w.Write([]byte(`{"data": [
+				{"id": "cap-1", "context_length": 8192,
+				 "top_provider": {"max_completion_tokens": 2048},
+				 "architecture": {"input_modalities": ["text"]},
+				 "supported_parameters": ["temperature", "response_format"],
+				 "pricing": {"prompt": "0.0000005", "completion": "0.0000015"}},
+				{"id": "cap-2", "context_length": 131072,
+				 "architecture": {"input_modalities": ["text", "image"]},
+				 "supported_parameters": ["tools", "response_format"]},
+				{"id": "dead-1", "context_length": 4096},
+				{"id": "unlisted-1", "context_length": 4096}
+			]}`))
+			return
+		}
+		var req struct {
+			Model string `json:"model"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "dead-1" {
+			http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"model": req.Model,
+			"choices": []map[string]interface{}{{
+				"message":       map[string]string{"role": "assistant", "content": "pong"},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{"prompt_tokens": 1, "completion_tokens": 1},
+		})
+	}))
+	defer endpoint.Close()
+
+	opToken, _ := registerRole(t, h, dba, "discovery_operator", "operator")
+
+	registerLocalProvider(t, h, opToken, "caps_local", endpoint.URL+"/v1", "cap-1", "cap-2", "dead-1")
+
+	var report struct {
+		Discovered int      `json:"discovered"`
+		Probed     int      `json:"probed"`
+		Failed     []string `json:"failed"`
+	}
+	resp, err := h.JSON("POST", "/api/models/refresh", nil, opToken, &report)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	RequireStatus(t, resp, http.StatusOK)
+
+	type model struct {
+		ModelID          string `json:"model_id"`
+		ContextWindow    *int   `json:"context_window"`
+		IsAvailable      bool   `json:"is_available"`
+		CapabilitiesJSON string `json:"capabilities_json"`
+	}
+	catalogue := map[string]model{}
+	var models []model
+	resp, err = h.JSON("GET", "/api/models?provider=caps_local&available=false", nil, "", &models)
+	if err != nil {
+		t.Fatalf("list models: %v", err)
+	}
+	RequireStatus(t, resp, http.StatusOK)
+	for _, m := range models {
+		catalogue[m.ModelID] = m
+	}
+
+	t.Run("ListingEnrichesRegisteredModels", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		m, ok := catalogue["caps_local/cap-1"]
+		if !ok {
+			t.Fatalf("catalogue = %+v, want caps_local/cap-1", catalogue)
+		}
+		if m.ContextWindow == nil || *m.ContextWindow != 8192 {
+			t.Errorf("context_window = %v, want 8192", m.ContextWindow)
+		}
+		var caps struct {
+			MaxOutputTokens int      `json:"max_output_tokens"`
+			Modalities      []string `json:"modalities"`
+			JSON            *bool    `json:"json"`
+			Tools           *bool    `json:"tools"`
+		}
+		_ = json.Unmarshal([]byte(m.CapabilitiesJSON), &caps)
+		if caps.MaxOutputTokens != 2048 || caps.JSON == nil || !*caps.JSON || caps.Tools == nil || *caps.Tools {
+			t.Errorf("capabilities = %s, want 2048 output tokens, json, no tools", m.CapabilitiesJSON)
+		}
+		if _, ok := catalogue["caps_local/unlisted-1"]; ok {
+			t.Error("a model outside the registration was added to the catalogue")
+		}
+	})
+
+	t.Run("ListingPricesModels", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var out struct {
+			Prices []struct {
+				ModelName     string  `json:"model_name"`
+				InputPerMTok  float64 `json:"input_per_mtok"`
+				OutputPerMTok float64 `json:"output_per_mtok"`
+				Source        string  `json:"source"`
+			} `json:"prices"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/prices?provider=caps_local", nil, "", &out)
+		if err != nil {
+			t.Fatalf("prices: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		found := false
+		for _, p := range out.Prices {
+			if p.ModelName == "cap-1" {
+				found = true
+				if math.Abs(p.InputPerMTok-0.5) > 1e-9 || math.Abs(p.OutputPerMTok-1.5) > 1e-9 {
+					t.Errorf("cap-1 priced %.4f/%.4f, want 0.5/1.5", p.InputPerMTok, p.OutputPerMTok)
+				}
+				if p.Source != "discovered" {
+					t.Errorf("cap-1 price source = %q, want discovered (a seed price is reset at startup)", p.Source)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("prices = %+v, want cap-1", out.Prices)
+		}
+	})
+
+	t.Run("ProbeRetiresDeadModel", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		if report.Probed < 3 {
+			t.Errorf("probed %d models, want the 3 registered", report.Probed)
+		}
+		if dead := catalogue["caps_local/dead-1"]; dead.IsAvailable {
+			t.Error("dead-1 still available after a failed probe")
+		}
+		if live := catalogue["caps_local/cap-2"]; !live.IsAvailable {
+			t.Error("cap-2 unavailable after a successful probe")
+		}
+		// Other tests' providers may share the instance: only ours count.
+		var failed []string
+		for _, m := range report.Failed {
+			if strings.HasPrefix(m, "caps_local/") {
+				failed = append(failed, m)
+			}
+		}
+		if len(failed) != 1 || failed[0] != "caps_local/dead-1" {
+			t.Errorf("failed = %v, want [caps_local/dead-1]", report.Failed)
+		}
+	})
+
+	t.Run("StepNeedsCapability", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var wfResult map[string]interface{}
+		resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+			"name":          "discovery_caps_wf",
+			"workflow_type": "analyse",
+		}, opToken, &wfResult)
+		if err != nil {
+			t.Fatalf("creating workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		wfID := wfResult["workflow"].(map[string]interface{})["workflow_id"].(string)
+
+		steps := 0
+		step := func(model, config string) int {
+			t.Helper()
+			steps++
+			resp, err := h.Do("POST", "/api/workflows/"+wfID+"/steps", map[string]interface{}{
+				"step_order":      steps,
+				"step_name":       fmt.Sprintf("tooled_%d", steps),
+				"step_type":       "llm",
+				"model":           model,
+				"prompt_template": "Summarize {{.Body}}",
+				"config_json":     config,
+			}, opToken)
+			if err != nil {
+				t.Fatalf("create step: %v", err)
+			}
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		if code := step("caps_local/cap-1", `{"tools":["get_tree"]}`); code != http.StatusBadRequest {
+			t.Errorf("tools step on a model without tools: status %d, want 400", code)
+		}
+		if code := step("caps_local/cap-1", `{"requires":["image"]}`); code != http.StatusBadRequest {
+			t.Errorf("image step on a text-only model: status %d, want 400", code)
+		}
+		if code := step("caps_local/cap-2", `{"tools":["get_tree"],"requires":["image"]}`); code != http.StatusCreated {
+			t.Errorf("tools step on a model with tools: status %d, want 201", code)
+		}
+	})
+
+	t.Run("Abuse_UnapprovedNotCalled", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var hits atomic.Int32
+		revoked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			http.Error(w, `{"error": "should not be called"}`, http.StatusInternalServerError)
+		}))
+		defer revoked.Close()
+
+		id := registerLocalProvider(t, h, opToken, "revoked_local", revoked.URL+"/v1", "revoked-1")
+		nodes, err := dba.nodes()
+		if err != nil {
+			t.Fatalf("opening nodes.db: %v", err)
+		}
+		// The approval is withdrawn behind the server's back: discovery must
+		// notice it before the next sync retires the provider.
+		if _, err := nodes.Exec(`UPDATE providers SET approved_at = NULL, approved_by = NULL WHERE id = ?`, id); err != nil {
+			t.Fatalf("withdrawing approval: %v", err)
+		}
+		resp, err := h.Do("POST", "/api/models/refresh", nil, opToken)
+		if err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+		if n := hits.Load(); n != 0 {
+			t.Errorf("unapproved provider called %d times by discovery", n)
+		}
+	})
+}
@@ -0,0 +1,324 @@
+package e2e
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRegisteredProviders registers an OpenAI-compatible endpoint served by
+// the test process and checks that it becomes a routable LLM backend, then
+// stops serving once deactivated. A provider's own registration waits for an
+// operator's approval, and endpoints outside the address policy are refused.
+func TestRegisteredProviders(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+
+	var (
+		mu      sync.Mutex
+		prompts []string
+	)
+	echo := fakeOpenAI(t, func(prompt string) string {
+		mu.Lock()
+		prompts = append(prompts, prompt)
+		mu.Unlock()
+		return "echo: " + prompt
+	})
+
+	ownerToken, _ := registerRole(t, h, dba, "provider_owner", "provider")
+	otherToken, _ := registerRole(t, h, dba, "provider_other", "user")
+	opToken, _ := registerRole(t, h, dba, "provider_operator", "operator")
+
+	var registered struct {
+		ID string `json:"id"`
+	}
+	registered.ID = registerLocalProvider(t, h, opToken, "echo_local", echo.URL+"/v1", "echo-1")
+
+	isLive := func(t *testing.T, id string) (approved, live bool) {
+		t.Helper()
+		var prov struct {
+			Approved bool `json:"approved"`
+			Live     bool `json:"live"`
+		}
+		resp, err := h.JSON("GET", "/api/providers/"+id, nil, "", &prov)
+		if err != nil {
+			t.Fatalf("get provider: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		return prov.Approved, prov.Live
+	}
+
+	inHealth := func(t *testing.T) bool {
+		t.Helper()
+		var health struct {
+			Providers []struct {
+				Provider string `json:"provider"`
+			} `json:"providers"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/health", nil, "", &health)
+		if err != nil {
+			t.Fatalf("llm health: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		for _, p := range health.Providers {
+			if p.Provider == "echo_local" {
+				return true
+			}
+		}
+		return false
+	}
+
+	type dispatchResult struct {
+		Results []struct {
+			Provider string `json:"provider"`
+			Content  string `json:"content"`
+			Error    string `json:"error"`
+		} `json:"results"`
+	}
+	dispatch := func(t *testing.T) dispatchResult {
+		t.Helper()
+		var result dispatchResult
+		resp, err := h.JSON("POST", "/api/inference/dispatch", map[string]interface{}{
+			"prompt": "ping registered provider",
+			"models": []string{"echo_local/echo-1"},
+		}, ownerToken, &result)
+		if err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(result.Results) != 1 {
+			t.Fatalf("results = %d, want 1", len(result.Results))
+		}
+		return result
+	}
+
+	t.Run("LiveAfterRegistration", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		if !inHealth(t) {
+			t.Fatal("registered provider missing from /api/llm/health")
+		}
+
+		if _, live := isLive(t, registered.ID); !live {
+			t.Error("provider live = false, want true")
+		}
+	})
+
+	t.Run("ProviderRegistrationNeedsApproval", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		// A documentation address: public, never called by this test.
+		var pending struct {
+			ID       string `json:"id"`
+			Approved bool   `json:"approved"`
+		}
+		resp, err := h.JSON("POST", "/api/providers/register", map[string]interface{}{
+			"name":      "pending_remote",
+			"endpoint":  "http://203.0.113.10/v1",
+			"api_style": "openai",
+			"models":    []string{"remote-1"},
+		}, ownerToken, &pending)
+		if err != nil {
+			t.Fatalf("register provider: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		if pending.Approved {
+			t.Fatal("a provider's own registration came back approved")
+		}
+		if approved, live := isLive(t, pending.ID); approved || live {
+			t.Fatalf("unapproved provider: approved=%v live=%v, want neither", approved, live)
+		}
+
+		resp, err = h.Do("POST", "/api/providers/"+pending.ID+"/approve", nil, ownerToken)
+		if err != nil {
+			t.Fatalf("approve: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+
+		resp, err = h.Do("POST", "/api/providers/"+pending.ID+"/approve", nil, opToken)
+		if err != nil {
+			t.Fatalf("approve: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+		if approved, live := isLive(t, pending.ID); !approved || !live {
+			t.Errorf("approved provider: approved=%v live=%v, want both", approved, live)
+		}
+
+		resp, err = h.Do("PATCH", "/api/providers/"+pending.ID, map[string]interface{}{"is_active": false}, ownerToken)
+		if err != nil {
+			t.Fatalf("update provider: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+	})
+
+	t.Run("Abuse_Registration", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		cases := []struct {
+			name     string
+			token    string
+			endpoint string
+			private  bool
+			want     int
+		}{
+			{"anonymous", "", "http://203.0.113.11/v1", false, http.StatusUnauthorized},
+			{"plain user", otherToken, "http://203.0.113.12/v1", false, http.StatusForbidden},
+			{"provider allowing private", ownerToken, echo.URL + "/v1", true, http.StatusForbidden},
+			{"provider on loopback", ownerToken, echo.URL + "/v1", false, http.StatusBadRequest},
+			{"provider on localhost", ownerToken, "http://localhost:9/v1", false, http.StatusBadRequest},
+			{"provider on metadata address", ownerToken, "http://169.254.169.254/latest", false, http.StatusBadRequest},
+			{"provider on private network", ownerToken, "http://10.0.0.7:8000/v1", false, http.StatusBadRequest},
+			{"provider on IPv6 loopback", ownerToken, "http://[::1]:8000/v1", false, http.StatusBadRequest},
+			{"operator on loopback without permission", opToken, echo.URL + "/v1", false, http.StatusBadRequest},
+			{"file scheme", opToken, "file:///etc/passwd", true, http.StatusBadRequest},
+			{"gopher scheme", opToken, "gopher://203.0.113.13/", true, http.StatusBadRequest},
+			{"no endpoint", opToken, "", false, http.StatusBadRequest},
+		}
+		for i, c := range cases {
+			resp, err := h.Do("POST", "/api/providers/register", map[string]interface{}{
+				"name":          fmt.Sprintf("abuse_endpoint_%d", i),
+				"endpoint":      c.endpoint,
+				"api_style":     "openai",
+				"models":        []string{"m-1"},
+				"allow_private": c.private,
+			}, c.token)
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != c.want {
+				t.Errorf("%s: status %d, want %d", c.name, resp.StatusCode, c.want)
+			}
+		}
+		if n := dba.QueryScalarInt(t, `SELECT COUNT(*) FROM providers WHERE name LIKE 'abuse_endpoint_%'`); n != 0 {
+			t.Errorf("%d refused registrations were stored", n)
+		}
+	})
+
+	t.Run("DispatchRoutesToEndpoint", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		r := dispatch(t).Results[0]
+		if r.Error != "" {
+			t.Fatalf("dispatch error: %s", r.Error)
+		}
+		if r.Provider != "echo_local" {
+			t.Errorf("provider = %q, want echo_local", r.Provider)
+		}
+		if !offlineLLM() && r.Content != "echo: ping registered provider" {
+			t.Errorf("content = %q, want the endpoint's echo", r.Content)
+		}
+	})
+
+	// Registered providers are not in the fallback chain: a new question,
+	// decomposed by every configured provider, is never sent to them.
+	t.Run("NoTrafficFromAsk", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		body := "Which endpoints get to read this question? " + registered.ID
+		var asked struct {
+			Decompositions []struct {
+				Provider string `json:"provider"`
+			} `json:"decompositions"`
+		}
+		resp, err := h.JSON("POST", "/api/ask", map[string]interface{}{"body": body}, otherToken, &asked)
+		if err != nil {
+			t.Fatalf("ask: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		for _, d := range asked.Decompositions {
+			if d.Provider == "echo_local" {
+				t.Errorf("question decomposed by registered provider echo_local: %+v", asked.Decompositions)
+			}
+		}
+
+		// The bot and the embedder work after the response.
+		time.Sleep(time.Second)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, p := range prompts {
+			if strings.Contains(p, registered.ID) {
+				t.Errorf("registered provider received the question: %q", p)
+			}
+		}
+	})
+
+	t.Run("ModelsOwnedByProvider", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var models []struct {
+			ModelID     string  `json:"model_id"`
+			IsAvailable bool    `json:"is_available"`
+			OwnerID     *string `json:"owner_id"`
+		}
+		resp, err := h.JSON("GET", "/api/models?provider=echo_local", nil, "", &models)
+		if err != nil {
+			t.Fatalf("list models: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(models) != 1 || models[0].ModelID != "echo_local/echo-1" {
+			t.Fatalf("models = %+v, want echo_local/echo-1", models)
+		}
+		if models[0].OwnerID == nil || *models[0].OwnerID != registered.ID {
+			t.Errorf("owner_id = %v, want %s", models[0].OwnerID, registered.ID)
+		}
+	})
+
+	t.Run("Abuse_UpdateByOtherUser", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("PATCH", "/api/providers/"+registered.ID, map[string]interface{}{
+			"is_active": false,
+		}, otherToken)
+		if err != nil {
+			t.Fatalf("update provider: %v", err)
+		}
+		defer resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+	})
+
+	t.Run("DeactivateRetires", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("PATCH", "/api/providers/"+registered.ID, map[string]interface{}{
+			"is_active": false,
+		}, opToken)
+		if err != nil {
+			t.Fatalf("update provider: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+
+		if inHealth(t) {
+			t.Error("deactivated provider still in /api/llm/health")
+		}
+		if r := dispatch(t).Results[0]; strings.HasPrefix(r.Content, "echo:") || r.Provider == "echo_local" {
+			t.Errorf("deactivated provider still answered: %+v", r)
+		}
+
+		var models []struct {
+			IsAvailable bool `json:"is_available"`
+		}
+		resp, err = h.JSON("GET", "/api/models?provider=echo_local&available=false", nil, "", &models)
+		if err != nil {
+			t.Fatalf("list models: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(models) != 1 || models[0].IsAvailable {
+			t.Errorf("models after deactivation = %+v, want one unavailable", models)
+		}
+	})
+}
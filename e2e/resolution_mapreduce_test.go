@@ -0,0 +1,204 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestResolutionMapReduce gives a registered model a 2000-token context
+// window and checks that a tree too large for it is summarized part by
+// part, that no call exceeds the window, and that node ids survive every
+// round up to the final Resolution and its flow_steps trace.
+func TestResolutionMapReduce(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("offline stand-ins ignore context windows")
+	}
+
+	const window = 2000
+	idRef := regexp.MustCompile(`\[id:([^\]]+)\]`)
+	var oversized atomic.Int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model     string `json:"model"`
+			MaxTokens int    `json:"max_tokens"`
+			Messages  []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		var system, prompt string
+		tokens := req.MaxTokens
+		for _, m := range req.Messages {
+			tokens += utf8.RuneCountInString(m.Content) / 4
+			if m.Role == "system" {
+				system = m.Content
+			} else {
+				prompt = m.Content
+			}
+		}
+		// Other features (question decomposition) may call any provider.
+		resolution := strings.HasPrefix(system, "Tu résumes") || strings.HasPrefix(system, "Tu fusionnes") ||
+			strings.HasPrefix(system, "Tu es un générateur de Résolution")
+		if tokens > window {
+			if resolution {
+				oversized.Add(1)
+			}
+			http.Error(w, `{"error":{"message":"context length exceeded"}}`, http.StatusBadRequest)
+			return
+		}
+
+		// Echo every node id of the prompt: part summaries with enough
+		// filler to force a merge round, merges and Resolution terse.
+		seen := map[string]bool{}
+		var lines []string
+		for _, m := range idRef.FindAllStringSubmatch(prompt, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				line := "- point [id:" + m[1] + "]"
+				if strings.HasPrefix(system, "Tu résumes") {
+					line += " " + strings.Repeat("detail ", 100)
+				}
+				lines = append(lines, line)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"model": req.Model,
+			"choices": []map[string]interface{}{{
+				"message":       map[string]string{"role": "assistant", "content": strings.Join(lines, "\n")},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{"prompt_tokens": 100, "completion_tokens": 10},
+		})
+	}))
+	defer endpoint.Close()
+
+	token, _ := registerRole(t, h, dba, "mapreduce_user", "user")
+	providerToken, _ := registerRole(t, h, dba, "mapreduce_provider", "provider")
+	opToken, _ := registerRole(t, h, dba, "mapreduce_operator", "operator")
+
+	// The catalogue row carries the window; registration keeps it.
+	resp, err := h.Do("POST", "/api/models", map[string]interface{}{
+		"model_id":       "ctx_local/tiny",
+		"provider":       "ctx_local",
+		"model_name":     "tiny",
+		"context_window": window,
+	}, providerToken)
+	if err != nil {
+		t.Fatalf("create model: %v", err)
+	}
+	resp.Body.Close()
+	RequireStatus(t, resp, http.StatusCreated)
+
+	registerLocalProvider(t, h, opToken, "ctx_local", endpoint.URL+"/v1", "tiny")
+
+	type generation struct {
+		Strategy      string `json:"strategy"`
+		ContextWindow int    `json:"context_window"`
+		FlowID        string `json:"flow_id"`
+		Content       string `json:"content"`
+		Plan          []struct {
+			NodeIDs []string `json:"node_ids"`
+		} `json:"plan"`
+	}
+	resolve := func(t *testing.T, questionID string) generation {
+		t.Helper()
+		var out struct {
+			Generation generation `json:"generation"`
+		}
+		resp, err := h.JSON("POST", "/api/resolution/"+questionID, map[string]interface{}{
+			"provider": "ctx_local",
+			"model":    "tiny",
+		}, token, &out)
+		if err != nil {
+			t.Fatalf("resolution: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		return out.Generation
+	}
+
+	t.Run("LargeTreeIsSummarizedInParts", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		question := h.AskQuestion(t, token, "Is nuclear power the cheapest low-carbon source of baseload electricity?", []string{"energy"})
+		ids := []string{question}
+		for i := 0; i < 6; i++ {
+			body := fmt.Sprintf("Argument %d: %s", i, strings.Repeat("construction costs, capacity factors and financing terms differ by country. ", 15))
+			ids = append(ids, h.AnswerNode(t, token, question, body, "claim"))
+		}
+		// One node alone larger than a part: its body is split, keeping its id.
+		ids = append(ids, h.AnswerNode(t, token, ids[1], strings.Repeat("A very long piece of evidence about levelized costs. ", 200), "piece"))
+
+		gen := resolve(t, question)
+		if gen.Strategy != "map_reduce" || gen.ContextWindow != window {
+			t.Fatalf("strategy = %q, window = %d, want map_reduce with %d", gen.Strategy, gen.ContextWindow, window)
+		}
+		if n := oversized.Load(); n != 0 {
+			t.Errorf("%d calls exceeded the context window", n)
+		}
+		if len(gen.Plan) < 2 {
+			t.Errorf("plan has %d parts, want several", len(gen.Plan))
+		}
+		planned := map[string]bool{}
+		for _, part := range gen.Plan {
+			for _, id := range part.NodeIDs {
+				planned[id] = true
+			}
+		}
+		for _, id := range ids {
+			if !planned[id] {
+				t.Errorf("node %s is not covered by the plan", id)
+			}
+			if !strings.Contains(gen.Content, "[id:"+id+"]") {
+				t.Errorf("node %s is not cited in the Resolution", id)
+			}
+		}
+
+		steps := dba.CountFlowSteps(t, gen.FlowID)
+		if steps < len(gen.Plan)+2 {
+			t.Errorf("flow %s has %d steps, want the %d parts, a merge and the Resolution", gen.FlowID, steps, len(gen.Plan))
+		}
+		if n := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM flow_steps WHERE flow_id = ? AND system_prompt LIKE 'Tu fusionnes%'`, gen.FlowID); n.(int64) == 0 {
+			t.Error("no merge step recorded")
+		}
+		last := dba.QueryFlowScalar(t, `SELECT context_ids FROM flow_steps WHERE flow_id = ? ORDER BY step_index DESC LIMIT 1`, gen.FlowID)
+		var covered []string
+		_ = json.Unmarshal([]byte(fmt.Sprint(last)), &covered)
+		if len(covered) != len(ids) {
+			t.Errorf("final step covers %d nodes, want %d", len(covered), len(ids))
+		}
+	})
+
+	t.Run("SmallTreeInOneCall", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		question := h.AskQuestion(t, token, "Do heat pumps work below minus twenty degrees?", []string{"energy"})
+		answer := h.AnswerNode(t, token, question, "Cold-climate models keep a useful coefficient of performance.", "claim")
+
+		gen := resolve(t, question)
+		if gen.Strategy != "single" || len(gen.Plan) != 0 {
+			t.Errorf("strategy = %q with %d parts, want single", gen.Strategy, len(gen.Plan))
+		}
+		if steps := dba.CountFlowSteps(t, gen.FlowID); steps != 1 {
+			t.Errorf("flow has %d steps, want 1", steps)
+		}
+		for _, id := range []string{question, answer} {
+			if !strings.Contains(gen.Content, "[id:"+id+"]") {
+				t.Errorf("node %s is not cited in the Resolution", id)
+			}
+		}
+	})
+}
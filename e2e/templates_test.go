@@ -0,0 +1,118 @@
+package e2e
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPromptTemplates checks that workflow step templates are validated
+// when the step is saved: conditionals and the template functions are
+// accepted, while syntax errors, unknown functions, misspelled fields and
+// references to steps that do not run earlier are rejected with the error.
+func TestPromptTemplates(t *testing.T) {
+	h, dba := ensureHarness(t)
+
+	opToken, _ := registerRole(t, h, dba, "tmpl_operator", "operator")
+
+	var wfResult map[string]interface{}
+	resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+		"name":          "templates_wf",
+		"workflow_type": "analyse",
+	}, opToken, &wfResult)
+	if err != nil {
+		t.Fatalf("creating workflow: %v", err)
+	}
+	RequireStatus(t, resp, http.StatusCreated)
+	wfID := wfResult["workflow"].(map[string]interface{})["workflow_id"].(string)
+
+	// saveStep creates a step, or updates stepID, and returns the status
+	// with the error message of a rejection.
+	saveStep := func(t *testing.T, stepID string, order int, name, prompt, system string) (int, string, string) {
+		t.Helper()
+		method, path := "POST", "/api/workflows/"+wfID+"/steps"
+		if stepID != "" {
+			method, path = "PUT", path+"/"+stepID
+		}
+		resp, err := h.Do(method, path, map[string]interface{}{
+			"step_order":      order,
+			"step_name":       name,
+			"step_type":       "llm",
+			"prompt_template": prompt,
+			"system_prompt":   system,
+		}, opToken)
+		if err != nil {
+			t.Fatalf("saving step %s: %v", name, err)
+		}
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(resp.Body)
+		var out struct {
+			StepID string `json:"step_id"`
+			Error  string `json:"error"`
+		}
+		_ = json.Unmarshal(raw, &out)
+		return resp.StatusCode, out.StepID, out.Error
+	}
+
+	code, draftID, msg := saveStep(t, "", 1, "draft", "Draft an answer to: {{.Body}}", "")
+	if code != http.StatusCreated {
+		t.Fatalf("draft step: status %d (%s), want 201", code, msg)
+	}
+
+	t.Run("ConditionalsAndFunctionsAccepted", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		prompt := `{{if .PrePrompt}}{{.PrePrompt}}
+
+{{end}}Claim: {{.Body | truncate 2000}}
+
+Draft:
+{{.Step.draft | indent 2}}
+
+Notes: {{index .Step "notes" | default "none"}} {{json .Step}}`
+		code, _, msg := saveStep(t, "", 2, "review", prompt, `{{.PrePrompt | default "You are a careful reviewer."}}`)
+		if code != http.StatusCreated {
+			t.Errorf("status %d (%s), want 201", code, msg)
+		}
+	})
+
+	t.Run("Abuse_LaterStepRejected", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		// review runs after draft, so draft cannot read it.
+		code, _, msg := saveStep(t, draftID, 1, "draft", "Draft using {{.Step.review}}", "")
+		if code != http.StatusBadRequest {
+			t.Fatalf("status %d, want 400", code)
+		}
+		if !strings.Contains(msg, "review") {
+			t.Errorf("error = %q, want it to name the step", msg)
+		}
+	})
+
+	t.Run("Abuse_BadTemplatesRejected", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		for i, tc := range []struct{ prompt, system, want string }{
+			{"{{if .Body}}unclosed", "", "unexpected EOF"},
+			{"{{.Body | shout}}", "", "shout"},
+			{"{{.Bdy}}", "", "Bdy"},
+			{"{{.Step.nowhere}}", "", "nowhere"},
+			{"Fine: {{.Body}}", "{{.NodeId}}", "NodeId"},
+		} {
+			code, _, msg := saveStep(t, "", 3, "bad_"+string(rune('a'+i)), tc.prompt, tc.system)
+			if code != http.StatusBadRequest {
+				t.Errorf("%q / %q: status %d, want 400", tc.prompt, tc.system, code)
+				continue
+			}
+			if !strings.Contains(msg, tc.want) {
+				t.Errorf("%q / %q: error = %q, want it to mention %q", tc.prompt, tc.system, msg, tc.want)
+			}
+		}
+	})
+}
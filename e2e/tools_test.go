@@ -0,0 +1,175 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestToolCalling routes the confrontation flow's respond step to a
+// registered OpenAI-compatible endpoint that calls get_tree before
+// answering, and checks the round-trip reaches the model and flow_steps.
+func TestToolCalling(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("offline stand-ins never call tools")
+	}
+
+	nodeRef := regexp.MustCompile(`\(node ([^)]+)\)`)
+	var calls int
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+			Tools []struct {
+				Function struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			} `json:"tools"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		var prompt, toolResult string
+		for _, m := range req.Messages {
+			switch m.Role {
+			case "user":
+				prompt = m.Content
+			case "tool":
+				toolResult = m.Content
+			}
+		}
+		message := map[string]interface{}{"role": "assistant"}
+		finish := "stop"
+		switch {
+		case len(req.Tools) == 0:
+			message["content"] = "no tools offered"
+		case toolResult != "" && !strings.Contains(prompt, "never stop"):
+			message["content"] = "tree says: " + toolResult
+		default:
+			nodeID := ""
+			if m := nodeRef.FindStringSubmatch(prompt); m != nil {
+				nodeID = m[1]
+			}
+			calls++
+			message["content"] = ""
+			message["tool_calls"] = []map[string]interface{}{{
+				"id":   fmt.Sprintf("call_%d", calls),
+				"type": "function",
+				"function": map[string]string{
+					"name":      "get_tree",
+					"arguments": fmt.Sprintf(`{"node_id": %q}`, nodeID),
+				},
+			}}
+			finish = "tool_calls"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"model":   req.Model,
+			"choices": []map[string]interface{}{{"message": message, "finish_reason": finish}},
+			"usage":   map[string]int{"prompt_tokens": 10, "completion_tokens": 5},
+		})
+	}))
+	defer endpoint.Close()
+
+	token, _ := registerRole(t, h, dba, "tool_caller", "user")
+	opToken, _ := registerRole(t, h, dba, "tool_operator", "operator")
+	registerLocalProvider(t, h, opToken, "tools_local", endpoint.URL+"/v1", "tool-1")
+
+	type stepResult struct {
+		Name     string
+		Response *struct {
+			Content    string `json:"content"`
+			TokensIn   int    `json:"tokens_in"`
+			ToolRounds []struct {
+				Tool   string `json:"tool"`
+				Result string `json:"result"`
+				Error  string `json:"error"`
+			} `json:"tool_rounds"`
+		}
+	}
+	runChallenge := func(t *testing.T, questionID string) (string, stepResult) {
+		t.Helper()
+		var created struct {
+			ID string `json:"id"`
+		}
+		resp, err := h.JSON("POST", "/api/challenge/"+questionID, map[string]interface{}{
+			"flow_name":       "confrontation",
+			"target_provider": "tools_local",
+			"target_model":    "tool-1",
+		}, token, &created)
+		if err != nil {
+			t.Fatalf("create challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+
+		var result struct {
+			FlowResult struct {
+				FlowID string
+				Steps  []stepResult
+			} `json:"flow_result"`
+		}
+		resp, err = h.JSON("POST", "/api/challenge/"+created.ID+"/run", nil, token, &result)
+		if err != nil {
+			t.Fatalf("run challenge: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(result.FlowResult.Steps) == 0 || result.FlowResult.Steps[0].Name != "respond" {
+			t.Fatalf("steps = %+v, want respond first", result.FlowResult.Steps)
+		}
+		return result.FlowResult.FlowID, result.FlowResult.Steps[0]
+	}
+
+	t.Run("RespondStepCallsGetTree", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		questionID := h.AskQuestion(t, token, "Can a model read the proof tree through tools?", []string{"tools"})
+		flowID, step := runChallenge(t, questionID)
+		if step.Response == nil {
+			stored := dba.QueryFlowScalar(t, `SELECT COALESCE(error,'') FROM flow_steps WHERE flow_id = ? AND step_index = 0`, flowID)
+			t.Fatalf("respond step failed: %v", stored)
+		}
+		if !strings.HasPrefix(step.Response.Content, "tree says: ") || !strings.Contains(step.Response.Content, "proof tree through tools") {
+			t.Errorf("content = %q, want the get_tree result", step.Response.Content)
+		}
+		if len(step.Response.ToolRounds) != 1 || step.Response.ToolRounds[0].Tool != "get_tree" || step.Response.ToolRounds[0].Error != "" {
+			t.Errorf("tool rounds = %+v, want one successful get_tree", step.Response.ToolRounds)
+		}
+		if step.Response.TokensIn != 20 {
+			t.Errorf("tokens_in = %d, want both turns summed (20)", step.Response.TokensIn)
+		}
+
+		persisted, _ := dba.QueryFlowScalar(t, `SELECT COALESCE(tool_calls,'') FROM flow_steps WHERE flow_id = ? AND step_index = 0`, flowID).(string)
+		if !strings.Contains(persisted, `"tool":"get_tree"`) {
+			t.Errorf("flow_steps.tool_calls = %q, want the get_tree round-trip", persisted)
+		}
+	})
+
+	t.Run("IterationGuard", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		questionID := h.AskQuestion(t, token, "Does the tool loop never stop on its own?", []string{"tools"})
+		flowID, step := runChallenge(t, questionID)
+		if step.Response == nil || len(step.Response.ToolRounds) != 5 {
+			t.Errorf("respond step = %+v, want the partial response with 5 tool rounds", step.Response)
+		}
+
+		stored, _ := dba.QueryFlowScalar(t, `SELECT COALESCE(error,'') FROM flow_steps WHERE flow_id = ? AND step_index = 0`, flowID).(string)
+		if !strings.Contains(stored, "tool call limit reached") {
+			t.Errorf("flow_steps.error = %q, want the tool call limit", stored)
+		}
+		rounds := dba.QueryFlowScalar(t, `SELECT json_array_length(tool_calls) FROM flow_steps WHERE flow_id = ? AND step_index = 0`, flowID)
+		if n, _ := rounds.(int64); n != 5 {
+			t.Errorf("persisted tool rounds = %v, want 5", rounds)
+		}
+	})
+}
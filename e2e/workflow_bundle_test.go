@@ -0,0 +1,394 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWorkflowBundle exports a workflow as a signed bundle, in JSON and in
+// TOML, imports it back as a new workflow pending validation, and checks
+// that tampered, untrusted and disallowed bundles are refused.
+func TestWorkflowBundle(t *testing.T) {
+	h, dba := ensureHarness(t)
+
+	userToken, _ := registerRole(t, h, dba, "bundle_user", "user")
+	opToken, _ := registerRole(t, h, dba, "bundle_operator", "operator")
+	providerToken, _ := registerRole(t, h, dba, "bundle_provider", "provider")
+
+	// raw sends body as it is and returns the response body.
+	raw := func(t *testing.T, method, path string, body []byte, token string) ([]byte, *http.Response) {
+		t.Helper()
+		req, err := http.NewRequest(method, h.BaseURL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := h.client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return data, resp
+	}
+	newWorkflow := func(t *testing.T, token, name string, steps []map[string]interface{}) string {
+		t.Helper()
+		var created struct {
+			Workflow struct {
+				WorkflowID string `json:"workflow_id"`
+			} `json:"workflow"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+			"name":          name,
+			"workflow_type": "critique",
+			"description":   "Bundled critique",
+		}, token, &created)
+		if err != nil {
+			t.Fatalf("create workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		for _, step := range steps {
+			resp, err := h.Do("POST", "/api/workflows/"+created.Workflow.WorkflowID+"/steps", step, token)
+			if err != nil {
+				t.Fatalf("add step: %v", err)
+			}
+			resp.Body.Close()
+			RequireStatus(t, resp, http.StatusCreated)
+		}
+		return created.Workflow.WorkflowID
+	}
+	type importResult struct {
+		Workflow struct {
+			WorkflowID string `json:"workflow_id"`
+			Name       string `json:"name"`
+			Status     string `json:"status"`
+			OwnerID    string `json:"owner_id"`
+			Steps      []struct {
+				StepID         string   `json:"step_id"`
+				StepName       string   `json:"step_name"`
+				CriteriaListID *string  `json:"criteria_list_id"`
+				DependsOn      []string `json:"depends_on"`
+			} `json:"steps"`
+		} `json:"workflow"`
+		CriteriaLists []struct {
+			Name   string `json:"name"`
+			ListID string `json:"list_id"`
+			Reused bool   `json:"reused"`
+		} `json:"criteria_lists"`
+		Signer   string   `json:"signer"`
+		Warnings []string `json:"warnings"`
+	}
+	importBundle := func(t *testing.T, bundle []byte, query, token string, want int) importResult {
+		t.Helper()
+		data, resp := raw(t, "POST", "/api/workflows/import"+query, bundle, token)
+		RequireStatus(t, resp, want)
+		var res importResult
+		if want == http.StatusCreated {
+			if err := json.Unmarshal(data, &res); err != nil {
+				t.Fatalf("decode import: %v (%s)", err, data)
+			}
+		}
+		return res
+	}
+
+	var cl struct {
+		CriteriaList struct {
+			ListID string `json:"list_id"`
+		} `json:"criteria_list"`
+	}
+	resp, err := h.JSON("POST", "/api/criteria-lists", map[string]interface{}{
+		"name":        "bundle_criteria",
+		"description": "Criteria travelling with a bundle",
+		"items":       []string{"Sourced", "Falsifiable"},
+	}, opToken, &cl)
+	if err != nil {
+		t.Fatalf("create criteria list: %v", err)
+	}
+	RequireStatus(t, resp, http.StatusCreated)
+	listID := cl.CriteriaList.ListID
+
+	wfID := newWorkflow(t, opToken, "bundle_source", []map[string]interface{}{
+		{
+			"step_order":      1,
+			"step_name":       "critique",
+			"step_type":       "llm",
+			"model":           "bundle-unknown-1",
+			"prompt_template": "Critique {{.Body}}",
+		},
+		{
+			"step_order":       2,
+			"step_name":        "assess",
+			"step_type":        "check",
+			"model":            "bundle-unknown-1",
+			"prompt_template":  "Assess {{.Body}}",
+			"criteria_list_id": listID,
+		},
+		{
+			"step_order":      2,
+			"step_name":       "summary",
+			"step_type":       "llm",
+			"model":           "bundle-unknown-1",
+			"prompt_template": "Summarise {{.Body}}",
+			"depends_on":      []string{},
+		},
+	})
+
+	var jsonBundle []byte
+	var signature struct {
+		PublicKey string `json:"public_key"`
+	}
+
+	t.Run("ExportJSON", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		data, resp := raw(t, "GET", "/api/workflows/"+wfID+"/bundle", nil, opToken)
+		RequireStatus(t, resp, http.StatusOK)
+		jsonBundle = data
+
+		var b struct {
+			Format   string `json:"format"`
+			Workflow struct {
+				Name    string `json:"name"`
+				Version int    `json:"version"`
+			} `json:"workflow"`
+			Steps []struct {
+				StepName     string `json:"step_name"`
+				CriteriaList string `json:"criteria_list"`
+			} `json:"steps"`
+			CriteriaLists []struct {
+				Name  string   `json:"name"`
+				Items []string `json:"items"`
+			} `json:"criteria_lists"`
+			Models []struct {
+				Model        string   `json:"model"`
+				StepTypes    []string `json:"step_types"`
+				Capabilities []string `json:"capabilities"`
+			} `json:"models"`
+			Signature *struct {
+				Algorithm string `json:"algorithm"`
+				PublicKey string `json:"public_key"`
+			} `json:"signature"`
+		}
+		if err := json.Unmarshal(data, &b); err != nil {
+			t.Fatalf("decode bundle: %v", err)
+		}
+		if b.Format != "horostracker.workflow-bundle" || b.Workflow.Name != "bundle_source" || b.Workflow.Version != 1 {
+			t.Errorf("bundle = %s %q v%d, want the workflow's version 1", b.Format, b.Workflow.Name, b.Workflow.Version)
+		}
+		if len(b.Steps) != 3 || b.Steps[1].CriteriaList != "bundle_criteria" {
+			t.Errorf("steps = %+v, want 3 with assess referencing bundle_criteria by name", b.Steps)
+		}
+		if len(b.CriteriaLists) != 1 || len(b.CriteriaLists[0].Items) != 2 {
+			t.Errorf("criteria_lists = %+v, want bundle_criteria with its 2 items", b.CriteriaLists)
+		}
+		if len(b.Models) != 1 || strings.Join(b.Models[0].StepTypes, ",") != "check,llm" || !ContainsString(b.Models[0].Capabilities, "json") {
+			t.Errorf("models = %+v, want bundle-unknown-1 for check and llm steps, needing json", b.Models)
+		}
+		if b.Signature == nil || b.Signature.Algorithm != "ed25519" {
+			t.Fatalf("signature = %+v, want an ed25519 signature", b.Signature)
+		}
+		signature.PublicKey = b.Signature.PublicKey
+
+		var identity map[string]interface{}
+		resp, err := h.JSON("GET", "/api/federation/identity", nil, "", &identity)
+		if err != nil {
+			t.Fatalf("identity: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if identity["bundle_public_key"] != signature.PublicKey {
+			t.Errorf("bundle_public_key = %v, want the signing key %s", identity["bundle_public_key"], signature.PublicKey)
+		}
+	})
+
+	t.Run("ImportJSON", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if jsonBundle == nil {
+			t.Skip("no bundle exported")
+		}
+
+		res := importBundle(t, jsonBundle, "?name=bundle_copy", opToken, http.StatusCreated)
+		wf := res.Workflow
+		if wf.Name != "bundle_copy" || wf.Status != "pending_validation" || wf.WorkflowID == wfID {
+			t.Errorf("imported %s %q (%s), want a new bundle_copy pending validation", wf.WorkflowID, wf.Name, wf.Status)
+		}
+		if res.Signer != signature.PublicKey {
+			t.Errorf("signer = %s, want %s", res.Signer, signature.PublicKey)
+		}
+		if len(res.CriteriaLists) != 1 || !res.CriteriaLists[0].Reused || res.CriteriaLists[0].ListID != listID {
+			t.Errorf("criteria_lists = %+v, want bundle_criteria reused", res.CriteriaLists)
+		}
+		if len(res.Warnings) == 0 || !strings.Contains(res.Warnings[0], "bundle-unknown-1") {
+			t.Errorf("warnings = %v, want the unknown model reported", res.Warnings)
+		}
+
+		var got struct {
+			Status string `json:"status"`
+			Steps  []struct {
+				StepID         string   `json:"step_id"`
+				StepName       string   `json:"step_name"`
+				CriteriaListID *string  `json:"criteria_list_id"`
+				DependsOn      []string `json:"depends_on"`
+			} `json:"steps"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows/"+wf.WorkflowID, nil, opToken, &got)
+		if err != nil {
+			t.Fatalf("get workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(got.Steps) != 3 {
+			t.Fatalf("%d steps, want 3", len(got.Steps))
+		}
+		for _, s := range got.Steps {
+			switch s.StepName {
+			case "assess":
+				if s.CriteriaListID == nil || *s.CriteriaListID != listID {
+					t.Errorf("assess criteria_list_id = %v, want %s", s.CriteriaListID, listID)
+				}
+			case "summary":
+				if s.DependsOn == nil || len(s.DependsOn) != 0 {
+					t.Errorf("summary depends_on = %#v, want empty", s.DependsOn)
+				}
+			case "critique":
+				if s.DependsOn != nil {
+					t.Errorf("critique depends_on = %#v, want nil", s.DependsOn)
+				}
+			}
+		}
+		n, _ := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log WHERE event_type = 'bundle_imported' AND json_extract(event_data_json, '$.workflow_id') = ?`, wf.WorkflowID).(int64)
+		if n != 1 {
+			t.Errorf("%d bundle_imported audit entries, want 1", n)
+		}
+
+		// Once its items differ, the local list is no longer reused.
+		resp, err = h.Do("PUT", "/api/criteria-lists/"+listID, map[string]interface{}{
+			"name":        "bundle_criteria",
+			"description": "Criteria travelling with a bundle",
+			"items":       []string{"Sourced"},
+		}, opToken)
+		if err != nil {
+			t.Fatalf("update criteria list: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+		res = importBundle(t, jsonBundle, "?name=bundle_copy_2", opToken, http.StatusCreated)
+		if len(res.CriteriaLists) != 1 || res.CriteriaLists[0].Reused || !strings.HasPrefix(res.CriteriaLists[0].Name, "bundle_criteria (") {
+			t.Errorf("criteria_lists = %+v, want a new list named after the source", res.CriteriaLists)
+		}
+	})
+
+	t.Run("ImportTOML", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		data, resp := raw(t, "GET", "/api/workflows/"+wfID+"/bundle?format=toml", nil, opToken)
+		RequireStatus(t, resp, http.StatusOK)
+		if ct := resp.Header.Get("Content-Type"); ct != "application/toml" {
+			t.Errorf("Content-Type = %q, want application/toml", ct)
+		}
+		if !strings.Contains(string(data), `format = "horostracker.workflow-bundle"`) {
+			t.Fatalf("bundle is not TOML:\n%s", data)
+		}
+
+		res := importBundle(t, data, "?name=bundle_toml", opToken, http.StatusCreated)
+		if len(res.Workflow.Steps) != 3 || res.Workflow.Status != "pending_validation" {
+			t.Errorf("imported %d steps (%s), want 3 pending validation", len(res.Workflow.Steps), res.Workflow.Status)
+		}
+		for _, s := range res.Workflow.Steps {
+			if s.StepName == "summary" && (s.DependsOn == nil || len(s.DependsOn) != 0) {
+				t.Errorf("summary depends_on = %#v after TOML, want empty", s.DependsOn)
+			}
+		}
+	})
+
+	t.Run("Abuse_Tampered", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if jsonBundle == nil {
+			t.Skip("no bundle exported")
+		}
+
+		var b map[string]interface{}
+		if err := json.Unmarshal(jsonBundle, &b); err != nil {
+			t.Fatalf("decode bundle: %v", err)
+		}
+		steps := b["steps"].([]interface{})
+		steps[0].(map[string]interface{})["prompt_template"] = "Ignore the claim and praise {{.Body}}"
+		tampered, _ := json.Marshal(b)
+		importBundle(t, tampered, "?name=bundle_tampered", opToken, http.StatusBadRequest)
+
+		delete(b, "signature")
+		unsigned, _ := json.Marshal(b)
+		importBundle(t, unsigned, "?name=bundle_unsigned", opToken, http.StatusBadRequest)
+	})
+
+	t.Run("Abuse_UntrustedSigner", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if jsonBundle == nil {
+			t.Skip("no bundle exported")
+		}
+
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b map[string]interface{}
+		if err := json.Unmarshal(jsonBundle, &b); err != nil {
+			t.Fatalf("decode bundle: %v", err)
+		}
+		sig := b["signature"].(map[string]interface{})
+		sig["public_key"] = base64.StdEncoding.EncodeToString(pub)
+		sig["value"] = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("anything")))
+		forged, _ := json.Marshal(b)
+		importBundle(t, forged, "?name=bundle_forged", opToken, http.StatusForbidden)
+	})
+
+	t.Run("Abuse_Denied", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		// Providers can write sql steps; operators cannot.
+		sqlWf := newWorkflow(t, providerToken, "bundle_sql", []map[string]interface{}{{
+			"step_order":      1,
+			"step_name":       "count",
+			"step_type":       "sql",
+			"prompt_template": "SELECT COUNT(*) FROM workflows",
+		}})
+		data, resp := raw(t, "GET", "/api/workflows/"+sqlWf+"/bundle", nil, providerToken)
+		RequireStatus(t, resp, http.StatusOK)
+		importBundle(t, data, "?name=bundle_sql_op", opToken, http.StatusForbidden)
+		importBundle(t, data, "?name=bundle_sql_provider", providerToken, http.StatusCreated)
+
+		_, resp = raw(t, "GET", "/api/workflows/"+wfID+"/bundle", nil, userToken)
+		RequireStatus(t, resp, http.StatusForbidden)
+		if jsonBundle != nil {
+			importBundle(t, jsonBundle, "?name=bundle_by_user", userToken, http.StatusForbidden)
+		}
+		_, resp = raw(t, "GET", "/api/workflows/"+wfID+"/nothing", nil, opToken)
+		RequireStatus(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("Abuse_NameConflict", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if jsonBundle == nil {
+			t.Skip("no bundle exported")
+		}
+
+		importBundle(t, jsonBundle, "", opToken, http.StatusConflict)
+		importBundle(t, []byte("not a bundle"), "", opToken, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,277 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWorkflowCancel checks that a step's timeout_ms aborts its provider
+// call and fails it as a timeout, and that runs and batches can be
+// cancelled while their calls are in flight.
+func TestWorkflowCancel(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("the steps' model is a fake endpoint")
+	}
+
+	// Every call hangs until its client gives up, then reports which
+	// prompt it was serving.
+	arrived := make(chan string, 16)
+	aborted := make(chan string, 16)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Messages) == 0 {
+			http.Error(w, `{"error": "no messages"}`, http.StatusBadRequest)
+			return
+		}
+		last := req.Messages[len(req.Messages)-1].Content
+		arrived <- last
+		select {
+		case <-r.Context().Done():
+			aborted <- last
+		case <-time.After(20 * time.Second):
+			http.Error(w, `{"error": "test gave up"}`, http.StatusInternalServerError)
+		}
+	}))
+	defer endpoint.Close()
+
+	userToken, _ := registerRole(t, h, dba, "cancel_user", "user")
+	opToken, _ := registerRole(t, h, dba, "cancel_operator", "operator")
+
+	// The timeout test leaves calls hanging at its provider; the other
+	// tests use a provider of their own.
+	for _, name := range []string{"cancel_slow", "cancel_local"} {
+		registerLocalProvider(t, h, opToken, name, endpoint.URL+"/v1", name+"-1")
+	}
+
+	newWorkflow := func(t *testing.T, name, provider, prompt string, timeoutMs int) string {
+		t.Helper()
+		var created struct {
+			Workflow struct {
+				WorkflowID string `json:"workflow_id"`
+			} `json:"workflow"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+			"name":          name,
+			"workflow_type": "critique",
+			"description":   "A single slow step",
+		}, opToken, &created)
+		if err != nil {
+			t.Fatalf("create workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		wfID := created.Workflow.WorkflowID
+
+		resp, err = h.Do("POST", "/api/workflows/"+wfID+"/steps", map[string]interface{}{
+			"step_order":      1,
+			"step_name":       "slow",
+			"step_type":       "llm",
+			"provider":        provider,
+			"model":           provider + "-1",
+			"prompt_template": prompt,
+			"timeout_ms":      timeoutMs,
+			"retry_max":       1,
+		}, opToken)
+		if err != nil {
+			t.Fatalf("add step: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusCreated)
+		for _, action := range []string{"submit", "activate"} {
+			resp, err := h.Do("POST", "/api/workflows/"+wfID+"/"+action, nil, opToken)
+			if err != nil {
+				t.Fatalf("%s: %v", action, err)
+			}
+			resp.Body.Close()
+			RequireStatus(t, resp, http.StatusOK)
+		}
+		return wfID
+	}
+	startRun := func(t *testing.T, wfID, body string) string {
+		t.Helper()
+		var started struct {
+			RunID string `json:"run_id"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/run", map[string]interface{}{"body": body}, opToken, &started)
+		if err != nil {
+			t.Fatalf("run workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusAccepted)
+		return started.RunID
+	}
+	// waitCall waits for the endpoint to report prompt on ch.
+	waitCall := func(t *testing.T, ch chan string, prompt, what string) {
+		t.Helper()
+		deadline := time.After(10 * time.Second)
+		for {
+			select {
+			case p := <-ch:
+				if p == prompt {
+					return
+				}
+			case <-deadline:
+				t.Fatalf("call %q never %s", prompt, what)
+			}
+		}
+	}
+	finished := func(r workflowRunStatus) bool {
+		return r.Status != "pending" && r.Status != "running"
+	}
+	post := func(t *testing.T, path, token string) *http.Response {
+		t.Helper()
+		resp, err := h.Do("POST", path, nil, token)
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("StepTimeout", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		wfID := newWorkflow(t, "cancel_timeout", "cancel_slow", "SLOW {{.Body}}", 300)
+		runID := startRun(t, wfID, "timeout")
+		waitCall(t, aborted, "SLOW timeout", "aborted by the step timeout")
+
+		run := waitWorkflowRun(t, h, opToken, runID, finished)
+		if run.Status != "failed" || run.Error == nil || !strings.Contains(*run.Error, "step timed out after 300ms") {
+			t.Fatalf("run = %s (error %v), want failed on the step timeout", run.Status, run.Error)
+		}
+		reason := dba.QueryFlowScalar(t, `SELECT COALESCE(json_extract(event_data_json, '$.reason'),'') FROM workflow_audit_log WHERE run_id = ? AND event_type = 'step_failed'`, runID)
+		if reason != "timeout" {
+			t.Errorf("step_failed reason = %v, want timeout", reason)
+		}
+
+		// Every retry hit the step timeout: the caller's deadline, not a
+		// provider failure, so the breaker holds none of them against it.
+		var health struct {
+			Providers []struct {
+				Provider            string `json:"provider"`
+				State               string `json:"state"`
+				ConsecutiveFailures int    `json:"consecutive_failures"`
+			} `json:"providers"`
+		}
+		resp, err := h.JSON("GET", "/api/llm/health", nil, "", &health)
+		if err != nil {
+			t.Fatalf("llm health: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		for _, p := range health.Providers {
+			if p.Provider == "cancel_slow" && (p.State != "closed" || p.ConsecutiveFailures != 0) {
+				t.Errorf("cancel_slow breaker = %s with %d failures, want closed with none", p.State, p.ConsecutiveFailures)
+			}
+		}
+	})
+
+	t.Run("CancelRun", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		wfID := newWorkflow(t, "cancel_run", "cancel_local", "SLOW {{.Body}}", 20000)
+		runID := startRun(t, wfID, "cancelrun")
+		waitCall(t, arrived, "SLOW cancelrun", "arrived")
+
+		RequireStatus(t, post(t, "/api/workflows/runs/"+runID+"/cancel", userToken), http.StatusForbidden)
+
+		RequireStatus(t, post(t, "/api/workflows/runs/"+runID+"/cancel", opToken), http.StatusAccepted)
+		waitCall(t, aborted, "SLOW cancelrun", "aborted by the cancellation")
+		run := waitWorkflowRun(t, h, opToken, runID, finished)
+		if run.Status != "cancelled" {
+			t.Fatalf("run = %s (error %v), want cancelled", run.Status, run.Error)
+		}
+		reason := dba.QueryFlowScalar(t, `SELECT COALESCE(json_extract(event_data_json, '$.reason'),'') FROM workflow_audit_log WHERE run_id = ? AND event_type = 'step_failed'`, runID)
+		if reason != "cancelled" {
+			t.Errorf("step_failed reason = %v, want cancelled", reason)
+		}
+		n, _ := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log WHERE run_id = ? AND event_type = 'run_cancelled'`, runID).(int64)
+		if n != 1 {
+			t.Errorf("%d run_cancelled audit entries, want 1", n)
+		}
+
+		RequireStatus(t, post(t, "/api/workflows/runs/"+runID+"/cancel", opToken), http.StatusConflict)
+		RequireStatus(t, post(t, "/api/workflows/runs/no-such-run/cancel", opToken), http.StatusNotFound)
+	})
+
+	t.Run("CancelBatch", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		wfA := newWorkflow(t, "cancel_batch_a", "cancel_local", "SLOW batch-a {{.Body}}", 20000)
+		wfB := newWorkflow(t, "cancel_batch_b", "cancel_local", "SLOW batch-b {{.Body}}", 20000)
+
+		var batch struct {
+			BatchID string   `json:"batch_id"`
+			RunIDs  []string `json:"run_ids"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/batch", map[string]interface{}{
+			"workflow_ids": []string{wfA, wfB},
+			"body":         "claim",
+		}, opToken, &batch)
+		if err != nil {
+			t.Fatalf("batch run: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusAccepted)
+		if len(batch.RunIDs) != 2 {
+			t.Fatalf("run_ids = %v, want 2", batch.RunIDs)
+		}
+		waitCall(t, arrived, "SLOW batch-a claim", "arrived")
+
+		var listed struct {
+			Runs []struct {
+				RunID string `json:"run_id"`
+			} `json:"runs"`
+		}
+		resp, err = h.JSON("GET", "/api/workflows/batch/"+batch.BatchID, nil, opToken, &listed)
+		if err != nil {
+			t.Fatalf("get batch: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if len(listed.Runs) != 2 {
+			t.Errorf("batch lists %d runs, want 2", len(listed.Runs))
+		}
+
+		var cancelled struct {
+			Cancelled int `json:"cancelled"`
+		}
+		resp, err = h.JSON("POST", "/api/workflows/batch/"+batch.BatchID+"/cancel", nil, opToken, &cancelled)
+		if err != nil {
+			t.Fatalf("cancel batch: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusAccepted)
+		if cancelled.Cancelled != 2 {
+			t.Errorf("cancelled = %d, want both runs", cancelled.Cancelled)
+		}
+		for _, id := range batch.RunIDs {
+			if run := waitWorkflowRun(t, h, opToken, id, finished); run.Status != "cancelled" {
+				t.Errorf("run %s = %s, want cancelled", id, run.Status)
+			}
+		}
+	})
+
+	t.Run("Abuse_BatchUnknownWorkflow", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/workflows/batch", map[string]interface{}{
+			"workflow_ids": []string{"no-such-workflow"},
+			"body":         "claim",
+		}, opToken)
+		if err != nil {
+			t.Fatalf("batch run: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusNotFound)
+		RequireStatus(t, post(t, "/api/workflows/batch/no-such-batch/cancel", opToken), http.StatusNotFound)
+	})
+}
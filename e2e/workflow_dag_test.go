@@ -0,0 +1,298 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// workflowRunStatus is the part of GET /api/workflows/runs/{id} the tests
+// follow.
+type workflowRunStatus struct {
+	Status         string  `json:"status"`
+	CompletedSteps int     `json:"completed_steps"`
+	ResultJSON     *string `json:"result_json"`
+	Error          *string `json:"error"`
+}
+
+// waitWorkflowRun polls a workflow run until done accepts it.
+func waitWorkflowRun(t *testing.T, h *TestHarness, token, runID string, done func(workflowRunStatus) bool) workflowRunStatus {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		var run workflowRunStatus
+		resp, err := h.JSON("GET", "/api/workflows/runs/"+runID, nil, token, &run)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if done(run) {
+			return run
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run %s: still %+v", runID, run)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// TestWorkflowDAG builds a workflow whose two branches fan in to a verdict
+// through depends_on, and checks the plan preview, the rejection of bad
+// edges, that the branches run in parallel and that the fan-in step gets
+// its inputs by name.
+func TestWorkflowDAG(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("the steps' model is a fake endpoint")
+	}
+
+	// The branch calls wait for each other: if they do not arrive together
+	// they were not scheduled in parallel.
+	var (
+		mu       sync.Mutex
+		prompts  = map[string]string{}
+		branches int
+		both     = make(chan struct{})
+		parallel = true
+	)
+	endpoint := fakeOpenAI(t, func(prompt string) string {
+		kind, _, _ := strings.Cut(prompt, " ")
+
+		var content string
+		switch kind {
+		case "PRO", "CON":
+			mu.Lock()
+			if branches++; branches == 2 {
+				close(both)
+			}
+			mu.Unlock()
+			select {
+			case <-both:
+			case <-time.After(3 * time.Second):
+				mu.Lock()
+				parallel = false
+				mu.Unlock()
+			}
+			content = strings.ToLower(kind) + " argument"
+		case "VERDICT":
+			content = "balanced verdict"
+		default:
+			content = "summary"
+		}
+		mu.Lock()
+		prompts[kind] = prompt
+		mu.Unlock()
+		return content
+	})
+
+	opToken, _ := registerRole(t, h, dba, "dag_operator", "operator")
+
+	registerLocalProvider(t, h, opToken, "dag_local", endpoint.URL+"/v1", "dag-1")
+
+	var created struct {
+		Workflow struct {
+			WorkflowID string `json:"workflow_id"`
+		} `json:"workflow"`
+	}
+	resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+		"name":          "dag_pro_con",
+		"workflow_type": "critique",
+		"description":   "Two branches fanning in to a verdict",
+	}, opToken, &created)
+	if err != nil {
+		t.Fatalf("create workflow: %v", err)
+	}
+	RequireStatus(t, resp, http.StatusCreated)
+	wfID := created.Workflow.WorkflowID
+
+	addStep := func(t *testing.T, step map[string]interface{}) (int, string, string) {
+		t.Helper()
+		step["step_type"] = "llm"
+		step["provider"] = "dag_local"
+		step["model"] = "dag-1"
+		var out struct {
+			StepID string `json:"step_id"`
+			Error  string `json:"error"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/steps", step, opToken, &out)
+		if err != nil {
+			t.Fatalf("add step %v: %v", step["step_name"], err)
+		}
+		return resp.StatusCode, out.StepID, out.Error
+	}
+	stepIDs := map[string]string{}
+	for _, step := range []map[string]interface{}{
+		{"step_order": 1, "step_name": "pro", "depends_on": []string{}, "prompt_template": "PRO {{.Body}}"},
+		{"step_order": 1, "step_name": "con", "depends_on": []string{}, "prompt_template": "CON {{.Body}}"},
+		{"step_order": 2, "step_name": "verdict", "depends_on": []string{"pro", "con"},
+			"prompt_template": "VERDICT for={{.Inputs.pro}} against={{.Inputs.con}}"},
+		{"step_order": 3, "step_name": "summary", "prompt_template": "SUMMARY {{.PreviousResponse}} after {{.Step.pro}} fan={{.Step.fan_results}}"},
+	} {
+		status, id, msg := addStep(t, step)
+		if status != http.StatusCreated {
+			t.Fatalf("add step %v: %d %s", step["step_name"], status, msg)
+		}
+		stepIDs[step["step_name"].(string)] = id
+	}
+
+	t.Run("PlanPreview", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var plan struct {
+			Levels int `json:"levels"`
+			Steps  []struct {
+				StepName  string   `json:"step_name"`
+				DependsOn []string `json:"depends_on"`
+				Level     int      `json:"level"`
+			} `json:"steps"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows/"+wfID+"/steps/plan", nil, opToken, &plan)
+		if err != nil {
+			t.Fatalf("get plan: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if plan.Levels != 3 || len(plan.Steps) != 4 {
+			t.Fatalf("plan = %+v, want 4 steps on 3 levels", plan)
+		}
+		got := map[string]string{}
+		for i, s := range plan.Steps {
+			deps := append([]string(nil), s.DependsOn...)
+			sort.Strings(deps)
+			got[s.StepName] = strings.Join(deps, ",") + "@" + string(rune('0'+s.Level))
+			if i < 2 && s.Level != 0 {
+				t.Errorf("step %d is %s on level %d, want the branches first", i, s.StepName, s.Level)
+			}
+		}
+		want := map[string]string{"pro": "@0", "con": "@0", "verdict": "con,pro@1", "summary": "verdict@2"}
+		for name, w := range want {
+			if got[name] != w {
+				t.Errorf("%s = %q, want %q (dependencies@level)", name, got[name], w)
+			}
+		}
+	})
+
+	t.Run("Abuse_CycleRejected", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var out struct {
+			Error string `json:"error"`
+		}
+		resp, err := h.JSON("PUT", "/api/workflows/"+wfID+"/steps/"+stepIDs["pro"], map[string]interface{}{
+			"step_order": 1, "step_name": "pro", "step_type": "llm",
+			"provider": "dag_local", "model": "dag-1",
+			"depends_on":      []string{"summary"},
+			"prompt_template": "PRO {{.Body}}",
+		}, opToken, &out)
+		if err != nil {
+			t.Fatalf("update step: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusBadRequest)
+		if !strings.Contains(out.Error, "dependency cycle") || !strings.Contains(out.Error, "summary") {
+			t.Errorf("error = %q, want the cycle through summary", out.Error)
+		}
+	})
+
+	t.Run("Abuse_UnknownDependency", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		status, _, msg := addStep(t, map[string]interface{}{
+			"step_order": 4, "step_name": "orphan", "depends_on": []string{"nowhere"},
+			"prompt_template": "{{.Body}}",
+		})
+		if status != http.StatusBadRequest || !strings.Contains(msg, `"nowhere"`) {
+			t.Errorf("got %d %q, want 400 naming the unknown step", status, msg)
+		}
+	})
+
+	t.Run("Abuse_InputOfOtherStep", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		status, _, msg := addStep(t, map[string]interface{}{
+			"step_order": 2, "step_name": "one_sided", "depends_on": []string{"pro"},
+			"prompt_template": "Against: {{.Inputs.con}}",
+		})
+		if status != http.StatusBadRequest || !strings.Contains(msg, "con") {
+			t.Errorf("got %d %q, want 400 for an input the step does not wait for", status, msg)
+		}
+	})
+
+	t.Run("Abuse_DeleteDependency", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("DELETE", "/api/workflows/"+wfID+"/steps/"+stepIDs["con"], nil, opToken)
+		if err != nil {
+			t.Fatalf("delete step: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("FanInRun", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		for _, action := range []string{"submit", "activate"} {
+			resp, err := h.Do("POST", "/api/workflows/"+wfID+"/"+action, nil, opToken)
+			if err != nil {
+				t.Fatalf("%s: %v", action, err)
+			}
+			resp.Body.Close()
+			RequireStatus(t, resp, http.StatusOK)
+		}
+
+		var started struct {
+			RunID string `json:"run_id"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/run", map[string]interface{}{
+			"body": "Cities should ban cars from their centres",
+		}, opToken, &started)
+		if err != nil {
+			t.Fatalf("run workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusAccepted)
+		if started.RunID == "" {
+			t.Fatal("no run_id in the run response")
+		}
+
+		run := waitWorkflowRun(t, h, opToken, started.RunID, func(r workflowRunStatus) bool {
+			return r.Status != "pending" && r.Status != "running"
+		})
+		if run.Status != "completed" || run.CompletedSteps != 4 {
+			t.Fatalf("run = %s with %d steps (error %v), want completed with 4", run.Status, run.CompletedSteps, run.Error)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if !parallel {
+			t.Error("pro and con did not run in parallel")
+		}
+		if p := prompts["VERDICT"]; p != "VERDICT for=pro argument against=con argument" {
+			t.Errorf("verdict prompt = %q, want both inputs by name", p)
+		}
+		// .Step.fan_results still names the pro/con group two levels up,
+		// as templates written before depends_on expect.
+		if p := prompts["SUMMARY"]; p != `SUMMARY balanced verdict after pro argument fan={"con":"con argument","pro":"pro argument"}` {
+			t.Errorf("summary prompt = %q, want the verdict, the upstream pro output and the fan-out group", p)
+		}
+		var result map[string]string
+		if run.ResultJSON != nil {
+			_ = json.Unmarshal([]byte(*run.ResultJSON), &result)
+		}
+		if result["verdict"] != "balanced verdict" || result["con"] != "con argument" {
+			t.Errorf("result = %v, want every step's output by name", result)
+		}
+		fanIn := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log WHERE run_id = ? AND event_type = 'fan_in_completed'`, started.RunID)
+		if n, _ := fanIn.(int64); n != 1 {
+			t.Errorf("%v fan_in_completed audit entries, want 1 for the verdict", fanIn)
+		}
+	})
+}
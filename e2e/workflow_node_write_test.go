@@ -0,0 +1,389 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWorkflowNodeWrite runs a critique workflow whose node_write step
+// publishes the critique's objections under the run's node: as a dry run
+// first, then for real, and checks the nodes' model, metadata, tags and
+// sources, that a retried step does not write twice, and that roles and
+// malformed outputs are refused.
+func TestWorkflowNodeWrite(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("the steps' model is a fake endpoint")
+	}
+
+	const critique = `{"nodes": [
+		{"body": "The growth figure is cited without its source.", "tags": ["objection"]},
+		{"node_type": "piece", "body": "The annual report gives 12%, not 21%.", "parent": 0,
+		 "sources": [{"url": "https://example.org/report", "title": "Annual report"}]}
+	]}`
+	endpoint := fakeOpenAI(t, func(string) string { return critique })
+
+	userToken, userID := registerRole(t, h, dba, "nodewrite_user", "user")
+	opToken, opID := registerRole(t, h, dba, "nodewrite_operator", "operator")
+	providerToken, _ := registerRole(t, h, dba, "nodewrite_provider", "provider")
+
+	registerLocalProvider(t, h, opToken, "nodewrite_local", endpoint.URL+"/v1", "nodewrite-1")
+
+	newWorkflow := func(t *testing.T, token, name string) string {
+		t.Helper()
+		var created struct {
+			Workflow struct {
+				WorkflowID string `json:"workflow_id"`
+			} `json:"workflow"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+			"name":          name,
+			"workflow_type": "critique",
+			"description":   "Publishes its objections",
+		}, token, &created)
+		if err != nil {
+			t.Fatalf("create workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		return created.Workflow.WorkflowID
+	}
+	addStep := func(t *testing.T, wfID, token string, step map[string]interface{}, want int) string {
+		t.Helper()
+		var out struct {
+			StepID string `json:"step_id"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/steps", step, token, &out)
+		if err != nil {
+			t.Fatalf("add step: %v", err)
+		}
+		RequireStatus(t, resp, want)
+		return out.StepID
+	}
+	activate := func(t *testing.T, wfID string) {
+		t.Helper()
+		for _, action := range []string{"submit", "activate"} {
+			resp, err := h.Do("POST", "/api/workflows/"+wfID+"/"+action, nil, opToken)
+			if err != nil {
+				t.Fatalf("%s: %v", action, err)
+			}
+			resp.Body.Close()
+			RequireStatus(t, resp, http.StatusOK)
+		}
+	}
+	finished := func(r workflowRunStatus) bool { return r.Status != "pending" && r.Status != "running" }
+	run := func(t *testing.T, wfID, token string, body map[string]interface{}) (string, workflowRunStatus) {
+		t.Helper()
+		var started struct {
+			RunID  string `json:"run_id"`
+			DryRun bool   `json:"dry_run"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/run", body, token, &started)
+		if err != nil {
+			t.Fatalf("run workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusAccepted)
+		if started.DryRun != (body["dry_run"] == true) {
+			t.Errorf("dry_run = %v, want %v", started.DryRun, body["dry_run"])
+		}
+		return started.RunID, waitWorkflowRun(t, h, opToken, started.RunID, finished)
+	}
+	type writtenNode struct {
+		Index    int    `json:"index"`
+		NodeID   string `json:"node_id"`
+		ParentID string `json:"parent_id"`
+		NodeType string `json:"node_type"`
+		Parent   *int   `json:"parent"`
+		ModelID  string `json:"model_id"`
+		Existing bool   `json:"existing"`
+	}
+	type nodeWriteOutput struct {
+		DryRun bool          `json:"dry_run"`
+		Nodes  []writtenNode `json:"nodes"`
+	}
+	// publishOutput returns the output of the run's latest completed publish step.
+	publishOutput := func(t *testing.T, runID string) nodeWriteOutput {
+		t.Helper()
+		var steps []struct {
+			StepName   string  `json:"step_name"`
+			Status     string  `json:"status"`
+			OutputJSON *string `json:"output_json"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows/runs/"+runID+"/steps", nil, opToken, &steps)
+		if err != nil {
+			t.Fatalf("step runs: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		var out nodeWriteOutput
+		for _, s := range steps {
+			if s.StepName == "publish" && s.Status == "completed" && s.OutputJSON != nil {
+				out = nodeWriteOutput{}
+				if err := json.Unmarshal([]byte(*s.OutputJSON), &out); err != nil {
+					t.Fatalf("decode publish output: %v (%s)", err, *s.OutputJSON)
+				}
+			}
+		}
+		return out
+	}
+	childCount := func(t *testing.T, nodeID string) int {
+		t.Helper()
+		return dba.QueryScalarInt(t, `SELECT child_count FROM nodes WHERE id = ?`, nodeID)
+	}
+
+	wfID := newWorkflow(t, opToken, "nodewrite_critique")
+	addStep(t, wfID, opToken, map[string]interface{}{
+		"step_order": 1, "step_name": "critique", "step_type": "llm",
+		"provider": "nodewrite_local", "model": "nodewrite-1",
+		"prompt_template": "List the objections to: {{.Body}}",
+		"config_json":     `{"schema": "nodes"}`,
+	}, http.StatusCreated)
+	publishID := addStep(t, wfID, opToken, map[string]interface{}{
+		"step_order": 2, "step_name": "publish", "step_type": "node_write",
+		"config_json": `{"tags": ["workflow_review"]}`,
+	}, http.StatusCreated)
+	activate(t, wfID)
+
+	questionID := h.AskQuestion(t, userToken, "Did the company's revenue grow by 21% last year?", nil)
+	var runID string
+
+	t.Run("DryRun", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		dryID, status := run(t, wfID, opToken, map[string]interface{}{"node_id": questionID, "body": "Revenue grew by 21%.", "dry_run": true})
+		if status.Status != "completed" {
+			t.Fatalf("dry run = %s (%v), want completed", status.Status, status.Error)
+		}
+		out := publishOutput(t, dryID)
+		if !out.DryRun || len(out.Nodes) != 2 {
+			t.Fatalf("publish output = %+v, want 2 nodes in a dry run", out)
+		}
+		if n := out.Nodes[0]; n.NodeID != "" || n.ParentID != questionID || n.ModelID != "nodewrite-1" {
+			t.Errorf("node 0 = %+v, want unwritten under %s from nodewrite-1", n, questionID)
+		}
+		if n := out.Nodes[1]; n.NodeType != "piece" || n.Parent == nil || *n.Parent != 0 || n.ParentID != "" {
+			t.Errorf("node 1 = %+v, want an unwritten piece under node 0", n)
+		}
+		if n := childCount(t, questionID); n != 0 {
+			t.Errorf("question has %d children after a dry run, want 0", n)
+		}
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var status workflowRunStatus
+		runID, status = run(t, wfID, opToken, map[string]interface{}{"node_id": questionID, "body": "Revenue grew by 21%."})
+		if status.Status != "completed" {
+			t.Fatalf("run = %s (%v), want completed", status.Status, status.Error)
+		}
+		out := publishOutput(t, runID)
+		if out.DryRun || len(out.Nodes) != 2 || out.Nodes[0].NodeID == "" || out.Nodes[1].ParentID != out.Nodes[0].NodeID {
+			t.Fatalf("publish output = %+v, want the piece written under the claim", out)
+		}
+		claimID, pieceID := out.Nodes[0].NodeID, out.Nodes[1].NodeID
+
+		claim := h.GetNode(t, claimID)
+		if claim["parent_id"] != questionID || claim["node_type"] != "claim" || claim["model_id"] != "nodewrite-1" || claim["author_id"] != opID {
+			t.Errorf("claim = %v, want a claim by the operator from nodewrite-1 under the question", claim)
+		}
+		var meta map[string]interface{}
+		_ = json.Unmarshal([]byte(claim["metadata"].(string)), &meta)
+		if meta["workflow_run_id"] != runID || meta["workflow_step_id"] != publishID || meta["workflow_id"] != wfID {
+			t.Errorf("metadata = %v, want links to run %s and step %s", meta, runID, publishID)
+		}
+		if n := dba.QueryScalarInt(t, `SELECT COUNT(*) FROM tags WHERE node_id = ? AND tag IN ('objection', 'workflow_review')`, claimID); n != 2 {
+			t.Errorf("claim has %d of its 2 tags", n)
+		}
+		piece := h.GetNode(t, pieceID)
+		if piece["parent_id"] != claimID || piece["node_type"] != "piece" {
+			t.Errorf("piece = %v, want a piece under the claim", piece)
+		}
+		if n := dba.QueryScalarInt(t, `SELECT COUNT(*) FROM sources WHERE node_id = ? AND url = 'https://example.org/report' AND domain = 'example.org'`, pieceID); n != 1 {
+			t.Errorf("piece has %d sources, want the report", n)
+		}
+		if n := childCount(t, questionID); n != 1 {
+			t.Errorf("question has %d children, want the claim", n)
+		}
+		if n := dba.QueryScalarInt(t, `SELECT COUNT(*) FROM workflow_nodes WHERE run_id = ? AND step_id = ? AND node_id IN (?, ?)`, runID, publishID, claimID, pieceID); n != 2 {
+			t.Errorf("%d of the 2 nodes linked to the run in workflow_nodes", n)
+		}
+		var plan strings.Builder
+		conn, err := dba.nodes()
+		if err != nil {
+			t.Fatalf("nodes db: %v", err)
+		}
+		rows, err := conn.Query(`EXPLAIN QUERY PLAN SELECT node_id, item FROM workflow_nodes WHERE run_id = ? AND step_id = ?`, runID, publishID)
+		if err != nil {
+			t.Fatalf("query plan: %v", err)
+		}
+		for rows.Next() {
+			var id, parent, notused int
+			var detail string
+			_ = rows.Scan(&id, &parent, &notused, &detail)
+			plan.WriteString(detail + "\n")
+		}
+		rows.Close()
+		if !strings.Contains(plan.String(), "idx_workflow_nodes_run") {
+			t.Errorf("written nodes are looked up with\n%s, want idx_workflow_nodes_run", plan.String())
+		}
+		n, _ := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log WHERE run_id = ? AND event_type = 'nodes_written'`, runID).(int64)
+		if n != 1 {
+			t.Errorf("%d nodes_written audit entries, want 1", n)
+		}
+	})
+
+	t.Run("RetryDoesNotWriteTwice", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+		if runID == "" {
+			t.Skip("no run")
+		}
+
+		resp, err := h.Do("POST", "/api/workflows/runs/"+runID+"/retry-from/"+publishID, nil, opToken)
+		if err != nil {
+			t.Fatalf("retry: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusAccepted)
+		status := waitWorkflowRun(t, h, opToken, runID, func(r workflowRunStatus) bool {
+			n, _ := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_step_runs WHERE run_id = ? AND step_id = ? AND status IN ('skipped','completed')`, runID, publishID).(int64)
+			return finished(r) && n == 2
+		})
+		if status.Status != "completed" {
+			t.Fatalf("retried run = %s (%v), want completed", status.Status, status.Error)
+		}
+		out := publishOutput(t, runID)
+		if len(out.Nodes) != 2 || !out.Nodes[0].Existing || !out.Nodes[1].Existing {
+			t.Errorf("publish output = %+v, want both nodes reported as existing", out)
+		}
+		if n := childCount(t, questionID); n != 1 {
+			t.Errorf("question has %d children after the retry, want 1", n)
+		}
+	})
+
+	// Written nodes fire node_created triggers, but the nodes a triggered
+	// run writes do not: the workflow triggering itself stops there.
+	t.Run("NodeCreatedTriggers", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var created struct {
+			Trigger struct {
+				TriggerID string `json:"trigger_id"`
+			} `json:"trigger"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/triggers", map[string]interface{}{
+			"trigger_type": "node_created",
+			"tag":          "workflow_review",
+		}, opToken, &created)
+		if err != nil {
+			t.Fatalf("create trigger: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		triggerID := created.Trigger.TriggerID
+		defer func() {
+			if resp, err := h.Do("DELETE", "/api/workflow-triggers/"+triggerID, nil, opToken); err == nil {
+				resp.Body.Close()
+			}
+		}()
+
+		watched := h.AskQuestion(t, userToken, "Was last year's margin restated?", nil)
+		_, status := run(t, wfID, opToken, map[string]interface{}{"node_id": watched})
+		if status.Status != "completed" {
+			t.Fatalf("run = %s (%v), want completed", status.Status, status.Error)
+		}
+
+		// One firing per written node; each triggered run writes two more.
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			fired := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_trigger_fires WHERE trigger_id = ? AND status = 'fired'`, triggerID)
+			done := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_runs WHERE status = 'completed' AND run_id IN (SELECT run_id FROM workflow_trigger_fires WHERE trigger_id = ?)`, triggerID)
+			if n, _ := fired.(int64); n >= 2 {
+				if m, _ := done.(int64); m >= 2 {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%v firings, %v triggered runs completed, want 2 of each", fired, done)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		time.Sleep(500 * time.Millisecond) // for firings the triggered runs' nodes would cause
+		if n := dba.QueryScalarInt(t, `SELECT COUNT(*) FROM nodes WHERE root_id = ? AND id != ? AND id NOT IN (SELECT clone_id FROM node_clones)`, watched, watched); n != 6 {
+			t.Errorf("%d nodes under the question, want 2 written by the run and 2 by each triggered run", n)
+		}
+		fires := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_trigger_fires WHERE trigger_id = ?`, triggerID)
+		if n, _ := fires.(int64); n != 2 {
+			t.Errorf("%v firings, want 2: the triggered runs' nodes must not fire again", fires)
+		}
+	})
+
+	t.Run("RoleGatesWrites", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		userQuestion := h.AskQuestion(t, userToken, "Is the revenue figure audited?", nil)
+		_, status := run(t, wfID, userToken, map[string]interface{}{"node_id": userQuestion})
+		if status.Status != "failed" || status.Error == nil || !strings.Contains(*status.Error, "cannot write nodes") {
+			t.Errorf("user run = %s (%v), want failed: the user role cannot write nodes", status.Status, status.Error)
+		}
+		dryID, status := run(t, wfID, userToken, map[string]interface{}{"node_id": userQuestion, "dry_run": true})
+		if status.Status != "completed" || len(publishOutput(t, dryID).Nodes) != 2 {
+			t.Errorf("user dry run = %s (%v), want completed with 2 nodes", status.Status, status.Error)
+		}
+		if n := childCount(t, userQuestion); n != 0 {
+			t.Errorf("question has %d children, want none written for %s", n, userID)
+		}
+	})
+
+	t.Run("Abuse_Steps", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		provWf := newWorkflow(t, providerToken, "nodewrite_provider")
+		addStep(t, provWf, providerToken, map[string]interface{}{
+			"step_order": 1, "step_name": "publish", "step_type": "node_write",
+		}, http.StatusForbidden)
+
+		draft := newWorkflow(t, opToken, "nodewrite_invalid")
+		for _, step := range []map[string]interface{}{
+			{"model": "nodewrite-1"},
+			{"config_json": `{"max_nodes": 500}`},
+			{"config_json": `{"node_type": "question"}`},
+			{"config_json": `{"tags": [""]}`},
+		} {
+			step["step_order"], step["step_name"], step["step_type"] = 1, "publish", "node_write"
+			addStep(t, draft, opToken, step, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Abuse_Output", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		badWf := newWorkflow(t, opToken, "nodewrite_bad_output")
+		addStep(t, badWf, opToken, map[string]interface{}{
+			"step_order": 1, "step_name": "publish", "step_type": "node_write", "retry_max": 1,
+			"prompt_template": `{"nodes": [{"body": "First"}, {"body": "Loops onto itself", "parent": 1}]}`,
+		}, http.StatusCreated)
+		activate(t, badWf)
+
+		badQuestion := h.AskQuestion(t, userToken, "Can a malformed output write half its nodes?", nil)
+		_, status := run(t, badWf, opToken, map[string]interface{}{"node_id": badQuestion})
+		if status.Status != "failed" || status.Error == nil || !strings.Contains(*status.Error, "not an earlier node") {
+			t.Errorf("run = %s (%v), want failed on the parent reference", status.Status, status.Error)
+		}
+		if n := childCount(t, badQuestion); n != 0 {
+			t.Errorf("question has %d children, want none", n)
+		}
+
+		_, status = run(t, badWf, opToken, map[string]interface{}{"body": "No node to write under"})
+		if status.Status != "failed" || status.Error == nil || !strings.Contains(*status.Error, "needs a run on a node") {
+			t.Errorf("run without node = %s (%v), want failed", status.Status, status.Error)
+		}
+	})
+}
@@ -0,0 +1,296 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWorkflowResume crashes an instance in the middle of a workflow run and
+// checks that the restarted instance finishes it without calling the
+// completed steps' model again, then repairs runs through resume and
+// retry-from.
+func TestWorkflowResume(t *testing.T) {
+	ensureHarness(t)
+	if offlineLLM() {
+		t.Skip("the steps' model is a fake endpoint")
+	}
+
+	// Prompts are "STEP_<x> <body> ...": calls are counted per step and
+	// body. The first STEP_B call hangs until the instance is killed; STEP_C
+	// fails while failC is set.
+	var (
+		mu       sync.Mutex
+		calls    = map[string]int{}
+		prompts  = map[string]string{}
+		failC    bool
+		blocking = make(chan struct{})
+		blocked  bool
+	)
+	count := func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls[key]
+	}
+	endpoint := fakeOpenAIWith(t, func(r *http.Request, prompt string) (string, int) {
+		fields := strings.Fields(prompt)
+		if len(fields) < 2 {
+			return "unexpected prompt", http.StatusBadRequest
+		}
+		key := fields[0] + " " + fields[1]
+
+		mu.Lock()
+		calls[key]++
+		prompts[key] = strings.Join(fields, " ")
+		hang := fields[0] == "STEP_B" && !blocked
+		blocked = blocked || hang
+		fail := fields[0] == "STEP_C" && failC
+		mu.Unlock()
+		if hang {
+			close(blocking)
+			<-r.Context().Done()
+			return "instance gone", http.StatusServiceUnavailable
+		}
+		if fail {
+			return "bad request", http.StatusBadRequest
+		}
+		return strings.ToLower(fields[0]) + "-of-" + fields[1], http.StatusOK
+	})
+
+	// This test kills its instance: it gets one of its own.
+	h := NewHarnessConfig(t, registeredConfig)
+	defer h.Stop()
+	dba := NewDBAssert(h.NodesDB, h.FlowsDB, h.MetricsDB)
+	defer dba.Close()
+
+	userToken, _ := registerRole(t, h, dba, "resume_user", "user")
+	opToken, _ := registerRole(t, h, dba, "resume_operator", "operator")
+
+	registerLocalProvider(t, h, opToken, "resume_local", endpoint.URL+"/v1", "resume-1")
+
+	var created struct {
+		Workflow struct {
+			WorkflowID string `json:"workflow_id"`
+		} `json:"workflow"`
+	}
+	resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+		"name":          "resume_chain",
+		"workflow_type": "critique",
+		"description":   "Three chained steps",
+	}, opToken, &created)
+	if err != nil {
+		t.Fatalf("create workflow: %v", err)
+	}
+	RequireStatus(t, resp, http.StatusCreated)
+	wfID := created.Workflow.WorkflowID
+
+	stepIDs := map[string]string{}
+	for i, step := range []map[string]interface{}{
+		{"step_name": "a", "prompt_template": "STEP_A {{.Body}}"},
+		{"step_name": "b", "prompt_template": "STEP_B {{.Body}} after {{.Step.a}}"},
+		{"step_name": "c", "prompt_template": "STEP_C {{.Body}} after {{.Step.b}} and {{.Step.a}}"},
+	} {
+		step["step_order"] = i + 1
+		step["step_type"] = "llm"
+		step["provider"] = "resume_local"
+		step["model"] = "resume-1"
+		var out struct {
+			StepID string `json:"step_id"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/steps", step, opToken, &out)
+		if err != nil {
+			t.Fatalf("add step: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		stepIDs[step["step_name"].(string)] = out.StepID
+	}
+	for _, action := range []string{"submit", "activate"} {
+		resp, err := h.Do("POST", "/api/workflows/"+wfID+"/"+action, nil, opToken)
+		if err != nil {
+			t.Fatalf("%s: %v", action, err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+	}
+
+	startRun := func(t *testing.T, body string) string {
+		t.Helper()
+		var started struct {
+			RunID string `json:"run_id"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/run", map[string]interface{}{"body": body}, opToken, &started)
+		if err != nil {
+			t.Fatalf("run workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusAccepted)
+		return started.RunID
+	}
+	finished := func(r workflowRunStatus) bool {
+		return r.Status != "pending" && r.Status != "running"
+	}
+	post := func(t *testing.T, path, token string) *http.Response {
+		t.Helper()
+		resp, err := h.Do("POST", path, nil, token)
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	var crashRunID string
+
+	t.Run("ResumedAfterCrash", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		crashRunID = startRun(t, "crashrun")
+		select {
+		case <-blocking:
+		case <-time.After(10 * time.Second):
+			t.Fatal("step b was never called")
+		}
+		h.Crash()
+		h.Restart(t)
+
+		run := waitWorkflowRun(t, h, opToken, crashRunID, finished)
+		if run.Status != "completed" || run.CompletedSteps != 3 {
+			t.Fatalf("run = %s with %d steps (error %v), want completed with 3", run.Status, run.CompletedSteps, run.Error)
+		}
+		if n := count("STEP_A crashrun"); n != 1 {
+			t.Errorf("step a called %d times, want once: its output survives the crash", n)
+		}
+		mu.Lock()
+		p := prompts["STEP_C crashrun"]
+		mu.Unlock()
+		if p != "STEP_C crashrun after step_b-of-crashrun and step_a-of-crashrun" {
+			t.Errorf("step c prompt = %q, want the outputs from both sides of the crash", p)
+		}
+		interrupted := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_step_runs WHERE run_id = ? AND status = 'failed' AND error = 'interrupted'`, crashRunID)
+		if n, _ := interrupted.(int64); n != 1 {
+			t.Errorf("%v interrupted step runs, want step b's", interrupted)
+		}
+		resumed := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log WHERE run_id = ? AND event_type = 'run_resumed'`, crashRunID)
+		if n, _ := resumed.(int64); n != 1 {
+			t.Errorf("%v run_resumed audit entries, want 1", resumed)
+		}
+	})
+
+	t.Run("Abuse_ResumeCompleted", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		if crashRunID == "" {
+			t.Skip("no run")
+		}
+		RequireStatus(t, post(t, "/api/workflows/runs/"+crashRunID+"/resume", opToken), http.StatusConflict)
+		RequireStatus(t, post(t, "/api/workflows/runs/no-such-run/resume", opToken), http.StatusNotFound)
+		RequireStatus(t, post(t, "/api/workflows/runs/"+crashRunID+"/retry-from/no-such-step", opToken), http.StatusBadRequest)
+	})
+
+	t.Run("Abuse_NonOperator", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		if crashRunID == "" {
+			t.Skip("no run")
+		}
+		RequireStatus(t, post(t, "/api/workflows/runs/"+crashRunID+"/retry-from/"+stepIDs["b"], userToken), http.StatusForbidden)
+	})
+
+	t.Run("RetryFromStep", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		if crashRunID == "" {
+			t.Skip("no run")
+		}
+		// b and c get the same prompts again, which the response cache
+		// answers: the step runs tell what was executed.
+		stepRuns := func(status string) int64 {
+			n, _ := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_step_runs WHERE run_id = ? AND status = ?`, crashRunID, status).(int64)
+			return n
+		}
+		RequireStatus(t, post(t, "/api/workflows/runs/"+crashRunID+"/retry-from/"+stepIDs["b"], opToken), http.StatusAccepted)
+
+		run := waitWorkflowRun(t, h, opToken, crashRunID, func(r workflowRunStatus) bool {
+			return finished(r) && stepRuns("skipped") == 2 && stepRuns("completed") == 3
+		})
+		if run.Status != "completed" || run.CompletedSteps != 3 {
+			t.Fatalf("run = %s with %d steps (error %v), want completed with 3", run.Status, run.CompletedSteps, run.Error)
+		}
+		kept := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_step_runs WHERE run_id = ? AND step_id = ?`, crashRunID, stepIDs["a"])
+		if n, _ := kept.(int64); n != 1 {
+			t.Errorf("%v step runs for a, want its first one kept", kept)
+		}
+		if n := count("STEP_A crashrun"); n != 1 {
+			t.Errorf("step a called %d times, want once", n)
+		}
+	})
+
+	t.Run("ResumeFailedRun", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		mu.Lock()
+		failC = true
+		mu.Unlock()
+		runID := startRun(t, "failrun")
+		run := waitWorkflowRun(t, h, opToken, runID, finished)
+		mu.Lock()
+		failC = false
+		mu.Unlock()
+		if run.Status != "failed" || run.CompletedSteps != 2 {
+			t.Fatalf("run = %s with %d steps, want failed after 2", run.Status, run.CompletedSteps)
+		}
+
+		RequireStatus(t, post(t, "/api/workflows/runs/"+runID+"/resume", opToken), http.StatusAccepted)
+		run = waitWorkflowRun(t, h, opToken, runID, func(r workflowRunStatus) bool {
+			return r.Status == "completed"
+		})
+		if run.CompletedSteps != 3 {
+			t.Errorf("%d completed steps, want 3", run.CompletedSteps)
+		}
+		if a, b := count("STEP_A failrun"), count("STEP_B failrun"); a != 1 || b != 1 {
+			t.Errorf("calls a=%d b=%d, want the completed steps not called again", a, b)
+		}
+	})
+
+	// A run standing in for a challenge is failed at restart rather than
+	// resumed, and its challenge must not be left running.
+	t.Run("ChallengeRunFailedOnRestart", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		h.Crash()
+		flows, err := dba.flows()
+		if err != nil {
+			t.Fatalf("opening flows.db: %v", err)
+		}
+		if _, err := flows.Exec(`INSERT INTO workflow_runs (run_id, workflow_id, initiated_by, status, input_json, total_steps, started_at)
+			VALUES ('resume-challenge-run', ?, 'resume_operator', 'running', '{"body":"x","user_role":"operator","flow_id":"resume-challenge-flow"}', 3, datetime('now'))`, wfID); err != nil {
+			t.Fatalf("inserting run: %v", err)
+		}
+		nodes, err := dba.nodes()
+		if err != nil {
+			t.Fatalf("opening nodes.db: %v", err)
+		}
+		if _, err := nodes.Exec(`INSERT INTO challenges (id, node_id, flow_name, status, requested_by, flow_id, workflow_run_id, started_at)
+			VALUES ('resume-challenge', 'resume-node', 'resume_chain', 'running', 'resume_operator', 'resume-challenge-flow', 'resume-challenge-run', datetime('now'))`); err != nil {
+			t.Fatalf("inserting challenge: %v", err)
+		}
+		h.Restart(t)
+
+		if status := dba.QueryFlowScalar(t, `SELECT status FROM workflow_runs WHERE run_id = 'resume-challenge-run'`); status != "failed" {
+			t.Errorf("challenge run status = %v, want failed", status)
+		}
+		if status := dba.QueryScalar(t, `SELECT status FROM challenges WHERE id = 'resume-challenge'`); status != "failed" {
+			t.Errorf("challenge status = %v, want failed with its run", status)
+		}
+		if msg := dba.QueryScalar(t, `SELECT error FROM challenges WHERE id = 'resume-challenge'`); msg != "interrupted by restart" {
+			t.Errorf("challenge error = %v, want the run's", msg)
+		}
+	})
+}
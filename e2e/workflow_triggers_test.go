@@ -0,0 +1,357 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestWorkflowTriggers attaches triggers to an active workflow and checks
+// that new nodes and sources with the trigger's tag start runs on them,
+// that manual firings respect the rate limit, that cron triggers are
+// scheduled, and that invalid or unauthorized triggers are refused.
+func TestWorkflowTriggers(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("the steps' model is a fake endpoint")
+	}
+
+	endpoint := fakeOpenAI(t, func(prompt string) string { return "Reviewed: " + prompt })
+
+	userToken, _ := registerRole(t, h, dba, "triggers_user", "user")
+	opToken, opID := registerRole(t, h, dba, "triggers_operator", "operator")
+	providerToken, _ := registerRole(t, h, dba, "triggers_provider", "provider")
+
+	registerLocalProvider(t, h, opToken, "triggers_local", endpoint.URL+"/v1", "triggers-1")
+
+	// newWorkflow creates a one-step workflow, active unless draft is set.
+	newWorkflow := func(t *testing.T, token, name string, step map[string]interface{}, draft bool) string {
+		t.Helper()
+		var created struct {
+			Workflow struct {
+				WorkflowID string `json:"workflow_id"`
+			} `json:"workflow"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+			"name":          name,
+			"workflow_type": "critique",
+			"description":   "Started by triggers",
+		}, token, &created)
+		if err != nil {
+			t.Fatalf("create workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		wfID := created.Workflow.WorkflowID
+		resp, err = h.Do("POST", "/api/workflows/"+wfID+"/steps", step, token)
+		if err != nil {
+			t.Fatalf("add step: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusCreated)
+		if draft {
+			return wfID
+		}
+		for _, a := range []struct{ action, token string }{{"submit", token}, {"activate", opToken}} {
+			resp, err := h.Do("POST", "/api/workflows/"+wfID+"/"+a.action, nil, a.token)
+			if err != nil {
+				t.Fatalf("%s: %v", a.action, err)
+			}
+			resp.Body.Close()
+			RequireStatus(t, resp, http.StatusOK)
+		}
+		return wfID
+	}
+	llmStep := map[string]interface{}{
+		"step_order": 1, "step_name": "review", "step_type": "llm",
+		"provider": "triggers_local", "model": "triggers-1", "prompt_template": "Review {{.Body}}",
+	}
+	wfID := newWorkflow(t, opToken, "triggers_review", llmStep, false)
+
+	type trigger struct {
+		TriggerID   string     `json:"trigger_id"`
+		TriggerType string     `json:"trigger_type"`
+		RunAs       string     `json:"run_as"`
+		RateLimit   int        `json:"rate_limit"`
+		Enabled     bool       `json:"enabled"`
+		NextRunAt   *time.Time `json:"next_run_at"`
+	}
+	type fire struct {
+		EventType string `json:"event_type"`
+		NodeID    string `json:"node_id"`
+		RunID     string `json:"run_id"`
+		Status    string `json:"status"`
+		Reason    string `json:"reason"`
+	}
+	createTrigger := func(t *testing.T, wfID, token string, body map[string]interface{}, want int) trigger {
+		t.Helper()
+		var created struct {
+			Trigger trigger `json:"trigger"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/triggers", body, token, &created)
+		if err != nil {
+			t.Fatalf("create trigger: %v", err)
+		}
+		RequireStatus(t, resp, want)
+		return created.Trigger
+	}
+	// waitFires polls a trigger's history until it holds n firings.
+	waitFires := func(t *testing.T, triggerID string, n int) []fire {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			var out struct {
+				Fires []fire `json:"fires"`
+			}
+			resp, err := h.JSON("GET", "/api/workflow-triggers/"+triggerID+"/fires", nil, opToken, &out)
+			if err != nil {
+				t.Fatalf("list fires: %v", err)
+			}
+			RequireStatus(t, resp, http.StatusOK)
+			if len(out.Fires) >= n || time.Now().After(deadline) {
+				return out.Fires
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	completed := func(r workflowRunStatus) bool { return r.Status != "pending" && r.Status != "running" }
+
+	t.Run("NodeCreated", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		tr := createTrigger(t, wfID, opToken, map[string]interface{}{
+			"trigger_type": "node_created",
+			"tag":          "triggers_watch",
+		}, http.StatusCreated)
+		if tr.RunAs != opID || tr.RateLimit != 10 || !tr.Enabled {
+			t.Errorf("trigger = %+v, want run as its creator, 10 runs per window, enabled", tr)
+		}
+
+		h.AskQuestion(t, userToken, "Does an untagged claim start a run?", nil)
+		questionID := h.AskQuestion(t, userToken, "Do tagged claims start runs of triggered workflows?", []string{"triggers_watch"})
+		fires := waitFires(t, tr.TriggerID, 1)
+		if len(fires) != 1 {
+			t.Fatalf("%d firings, want 1 for the tagged question", len(fires))
+		}
+		f := fires[0]
+		if f.Status != "fired" || f.EventType != "node_created" || f.NodeID != questionID || f.RunID == "" {
+			t.Fatalf("firing = %+v, want a run on the question %s", f, questionID)
+		}
+		run := waitWorkflowRun(t, h, opToken, f.RunID, completed)
+		if run.Status != "completed" {
+			t.Errorf("run status = %s (%v), want completed", run.Status, run.Error)
+		}
+
+		// Answers in the tagged tree match through their root.
+		answerID := h.AnswerNode(t, userToken, questionID, "They do, through the trigger runner.", "claim")
+		fires = waitFires(t, tr.TriggerID, 2)
+		if len(fires) != 2 || fires[0].NodeID != answerID {
+			t.Errorf("firings = %+v, want the answer %s fired on last", fires, answerID)
+		}
+
+		n, _ := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log WHERE event_type = 'trigger_fired' AND run_id = ?`, f.RunID).(int64)
+		if n != 1 {
+			t.Errorf("%d trigger_fired audit entries for the run, want 1", n)
+		}
+	})
+
+	t.Run("SourceAdded", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		tr := createTrigger(t, wfID, opToken, map[string]interface{}{
+			"name":         "review sources",
+			"trigger_type": "source_added",
+			"tag":          "triggers_sourced",
+		}, http.StatusCreated)
+
+		nodeID := h.AskQuestion(t, userToken, "Is this sourced claim reviewed when a source arrives?", []string{"triggers_sourced"})
+		resp, err := h.Do("POST", "/api/node/"+nodeID+"/source", map[string]interface{}{
+			"content_text": "A report confirming the claim.",
+			"title":        "Report",
+		}, userToken)
+		if err != nil {
+			t.Fatalf("add source: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusCreated)
+
+		fires := waitFires(t, tr.TriggerID, 1)
+		if len(fires) != 1 || fires[0].Status != "fired" || fires[0].EventType != "source_added" || fires[0].NodeID != nodeID {
+			t.Errorf("firings = %+v, want one run on %s", fires, nodeID)
+		}
+	})
+
+	t.Run("ManualFireRateLimited", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		tr := createTrigger(t, wfID, opToken, map[string]interface{}{
+			"trigger_type": "cron",
+			"schedule":     "0 0 1 1 *",
+			"body":         "Yearly review of the triggers workflow",
+			"rate_limit":   1,
+		}, http.StatusCreated)
+
+		var f fire
+		resp, err := h.JSON("POST", "/api/workflow-triggers/"+tr.TriggerID+"/fire", nil, opToken, &f)
+		if err != nil {
+			t.Fatalf("fire: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusAccepted)
+		if f.Status != "fired" || f.EventType != "manual" || f.RunID == "" {
+			t.Errorf("firing = %+v, want a manual run", f)
+		}
+		if f.RunID != "" {
+			if run := waitWorkflowRun(t, h, opToken, f.RunID, completed); run.Status != "completed" {
+				t.Errorf("run status = %s (%v), want completed", run.Status, run.Error)
+			}
+		}
+
+		var limited fire
+		resp, err = h.JSON("POST", "/api/workflow-triggers/"+tr.TriggerID+"/fire", nil, opToken, &limited)
+		if err != nil {
+			t.Fatalf("fire: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusTooManyRequests)
+		if limited.Status != "rate_limited" || limited.RunID != "" {
+			t.Errorf("second firing = %+v, want rate_limited without a run", limited)
+		}
+		fires := waitFires(t, tr.TriggerID, 2)
+		if len(fires) != 2 || fires[0].Status != "rate_limited" || fires[1].Status != "fired" {
+			t.Errorf("firings = %+v, want fired then rate_limited", fires)
+		}
+
+		// Raising the limit lets it fire again.
+		var updated trigger
+		resp, err = h.JSON("PUT", "/api/workflow-triggers/"+tr.TriggerID, map[string]interface{}{"rate_limit": 2}, opToken, &updated)
+		if err != nil {
+			t.Fatalf("update trigger: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if updated.RateLimit != 2 || updated.TriggerType != "cron" || updated.NextRunAt == nil {
+			t.Errorf("updated = %+v, want rate_limit 2 on the same cron trigger", updated)
+		}
+		resp, err = h.Do("POST", "/api/workflow-triggers/"+tr.TriggerID+"/fire", nil, opToken)
+		if err != nil {
+			t.Fatalf("fire: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusAccepted)
+	})
+
+	t.Run("CronSchedule", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		tr := createTrigger(t, wfID, opToken, map[string]interface{}{
+			"trigger_type": "cron",
+			"schedule":     "* * * * *",
+			"enabled":      false,
+		}, http.StatusCreated)
+		if tr.Enabled || tr.NextRunAt == nil || time.Until(*tr.NextRunAt) > 61*time.Second {
+			t.Errorf("trigger = %+v, want disabled and due within a minute", tr)
+		}
+
+		var list struct {
+			Triggers []trigger `json:"triggers"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows/"+wfID+"/triggers", nil, opToken, &list)
+		if err != nil {
+			t.Fatalf("list triggers: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		found := false
+		for _, l := range list.Triggers {
+			found = found || l.TriggerID == tr.TriggerID
+		}
+		if !found {
+			t.Errorf("trigger %s missing from the workflow's %d triggers", tr.TriggerID, len(list.Triggers))
+		}
+
+		resp, err = h.Do("DELETE", "/api/workflow-triggers/"+tr.TriggerID, nil, opToken)
+		if err != nil {
+			t.Fatalf("delete trigger: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+		resp, err = h.Do("GET", "/api/workflow-triggers/"+tr.TriggerID, nil, opToken)
+		if err != nil {
+			t.Fatalf("get trigger: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("Abuse_Invalid", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		for _, body := range []map[string]interface{}{
+			{"trigger_type": "cron", "schedule": "61 * * * *"},
+			{"trigger_type": "cron"},
+			{"trigger_type": "cron", "schedule": "0 0 30 2 *"},
+			{"trigger_type": "node_deleted"},
+			{"trigger_type": "node_created", "schedule": "* * * * *"},
+			{"trigger_type": "node_created", "rate_limit": 5000},
+			{"trigger_type": "node_created", "run_as": "no-such-user"},
+		} {
+			createTrigger(t, wfID, opToken, body, http.StatusBadRequest)
+		}
+
+		draftID := newWorkflow(t, opToken, "triggers_draft", llmStep, true)
+		createTrigger(t, draftID, opToken, map[string]interface{}{"trigger_type": "node_created"}, http.StatusBadRequest)
+
+		tr := createTrigger(t, wfID, opToken, map[string]interface{}{"trigger_type": "challenge_completed"}, http.StatusCreated)
+		resp, err := h.Do("PUT", "/api/workflow-triggers/"+tr.TriggerID, map[string]interface{}{"trigger_type": "cron"}, opToken)
+		if err != nil {
+			t.Fatalf("update trigger: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("Abuse_Unauthorized", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		createTrigger(t, wfID, userToken, map[string]interface{}{"trigger_type": "node_created"}, http.StatusForbidden)
+		createTrigger(t, wfID, "", map[string]interface{}{"trigger_type": "node_created"}, http.StatusUnauthorized)
+
+		// A provider owns its workflow's triggers but runs them as itself.
+		provWf := newWorkflow(t, providerToken, "triggers_provider_sql", map[string]interface{}{
+			"step_order": 1, "step_name": "count", "step_type": "sql",
+			"prompt_template": "SELECT COUNT(*) FROM workflows",
+		}, false)
+		createTrigger(t, provWf, providerToken, map[string]interface{}{
+			"trigger_type": "node_created",
+			"tag":          "triggers_never",
+			"run_as":       opID,
+		}, http.StatusForbidden)
+		tr := createTrigger(t, provWf, providerToken, map[string]interface{}{
+			"trigger_type": "node_created",
+			"tag":          "triggers_never",
+		}, http.StatusCreated)
+		resp, err := h.Do("PUT", "/api/workflow-triggers/"+tr.TriggerID, map[string]interface{}{"run_as": opID}, providerToken)
+		if err != nil {
+			t.Fatalf("update trigger: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+
+		for _, path := range []string{"/api/workflow-triggers/" + tr.TriggerID, "/api/workflow-triggers/" + tr.TriggerID + "/fires", "/api/workflow-triggers"} {
+			resp, err := h.Do("GET", path, nil, userToken)
+			if err != nil {
+				t.Fatalf("GET %s: %v", path, err)
+			}
+			resp.Body.Close()
+			RequireStatus(t, resp, http.StatusForbidden)
+		}
+		resp, err = h.Do("POST", "/api/workflow-triggers/"+tr.TriggerID+"/fire", nil, userToken)
+		if err != nil {
+			t.Fatalf("fire: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusForbidden)
+	})
+}
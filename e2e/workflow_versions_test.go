@@ -0,0 +1,392 @@
+package e2e
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWorkflowVersions edits an active workflow and checks that the edit
+// lands in a new draft version while runs keep executing the active one,
+// that each run records the version it executed, and that two versions can
+// be diffed step by step.
+func TestWorkflowVersions(t *testing.T) {
+	h, dba := ensureRegisteredHarness(t)
+	if offlineLLM() {
+		t.Skip("the steps' model is a fake endpoint")
+	}
+
+	// Prompts are "<tag> <body>": the tag tells which version's step ran.
+	var (
+		mu   sync.Mutex
+		tags = map[string][]string{}
+	)
+	endpoint := fakeOpenAIWith(t, func(_ *http.Request, prompt string) (string, int) {
+		fields := strings.Fields(prompt)
+		if len(fields) < 2 {
+			return "unexpected prompt", http.StatusBadRequest
+		}
+		mu.Lock()
+		tags[fields[1]] = append(tags[fields[1]], fields[0])
+		mu.Unlock()
+		return fields[1] + " " + strings.ToLower(fields[0]), http.StatusOK
+	})
+
+	opToken, _ := registerRole(t, h, dba, "versions_operator", "operator")
+
+	registerLocalProvider(t, h, opToken, "versions_local", endpoint.URL+"/v1", "versions-1")
+
+	var created struct {
+		Workflow struct {
+			WorkflowID string `json:"workflow_id"`
+		} `json:"workflow"`
+	}
+	resp, err := h.JSON("POST", "/api/workflows", map[string]interface{}{
+		"name":          "versions_chain",
+		"workflow_type": "critique",
+		"description":   "Two chained steps",
+	}, opToken, &created)
+	if err != nil {
+		t.Fatalf("create workflow: %v", err)
+	}
+	RequireStatus(t, resp, http.StatusCreated)
+	wfID := created.Workflow.WorkflowID
+
+	step := func(name, prompt string) map[string]interface{} {
+		return map[string]interface{}{
+			"step_order": map[string]int{"a": 1, "b": 2}[name], "step_name": name, "step_type": "llm",
+			"provider": "versions_local", "model": "versions-1", "prompt_template": prompt,
+		}
+	}
+	stepIDs := map[string]string{}
+	for _, s := range []map[string]interface{}{
+		step("a", "V1A {{.Body}}"),
+		step("b", "V1B {{.Step.a}}"),
+	} {
+		var out struct {
+			StepID string `json:"step_id"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/steps", s, opToken, &out)
+		if err != nil {
+			t.Fatalf("add step: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusCreated)
+		stepIDs[s["step_name"].(string)] = out.StepID
+	}
+	lifecycle := func(t *testing.T) {
+		t.Helper()
+		for _, action := range []string{"submit", "activate"} {
+			resp, err := h.Do("POST", "/api/workflows/"+wfID+"/"+action, nil, opToken)
+			if err != nil {
+				t.Fatalf("%s: %v", action, err)
+			}
+			resp.Body.Close()
+			RequireStatus(t, resp, http.StatusOK)
+		}
+	}
+	lifecycle(t)
+
+	// run starts a run and waits for it, returning the version it executed.
+	run := func(t *testing.T, body string) int {
+		t.Helper()
+		var started struct {
+			RunID           string `json:"run_id"`
+			WorkflowVersion int    `json:"workflow_version"`
+		}
+		resp, err := h.JSON("POST", "/api/workflows/"+wfID+"/run", map[string]interface{}{"body": body}, opToken, &started)
+		if err != nil {
+			t.Fatalf("run workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusAccepted)
+		status := waitWorkflowRun(t, h, opToken, started.RunID, func(r workflowRunStatus) bool {
+			return r.Status != "pending" && r.Status != "running"
+		})
+		if status.Status != "completed" {
+			t.Fatalf("run = %s (error %v), want completed", status.Status, status.Error)
+		}
+		var recorded struct {
+			WorkflowVersion int `json:"workflow_version"`
+		}
+		resp, err = h.JSON("GET", "/api/workflows/runs/"+started.RunID, nil, opToken, &recorded)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if recorded.WorkflowVersion != started.WorkflowVersion {
+			t.Errorf("run records version %d, started on %d", recorded.WorkflowVersion, started.WorkflowVersion)
+		}
+		return recorded.WorkflowVersion
+	}
+	ranTags := func(body string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(tags[body], ",")
+	}
+	type versionEntry struct {
+		Version   int    `json:"version"`
+		Status    string `json:"status"`
+		StepCount int    `json:"step_count"`
+		RunCount  int    `json:"run_count"`
+	}
+	versions := func(t *testing.T) map[int]versionEntry {
+		t.Helper()
+		var out struct {
+			ActiveVersion *int           `json:"active_version"`
+			Versions      []versionEntry `json:"versions"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows/"+wfID+"/versions", nil, opToken, &out)
+		if err != nil {
+			t.Fatalf("list versions: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		byVersion := map[int]versionEntry{}
+		for _, v := range out.Versions {
+			byVersion[v.Version] = v
+		}
+		return byVersion
+	}
+
+	var draftB string
+
+	t.Run("EditStartsDraftVersion", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		if v := run(t, "first"); v != 1 {
+			t.Errorf("first run executed version %d, want 1", v)
+		}
+
+		var edited struct {
+			StepID  string `json:"step_id"`
+			Version int    `json:"version"`
+		}
+		resp, err := h.JSON("PUT", "/api/workflows/"+wfID+"/steps/"+stepIDs["b"], step("b", "V2B {{.Step.a}}"), opToken, &edited)
+		if err != nil {
+			t.Fatalf("update step: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if edited.Version != 2 || edited.StepID == "" || edited.StepID == stepIDs["b"] {
+			t.Fatalf("edited step = %+v, want a new step in version 2", edited)
+		}
+		draftB = edited.StepID
+
+		var wf struct {
+			Version       int    `json:"version"`
+			Status        string `json:"status"`
+			ActiveVersion *int   `json:"active_version"`
+		}
+		resp, err = h.JSON("GET", "/api/workflows/"+wfID, nil, opToken, &wf)
+		if err != nil {
+			t.Fatalf("get workflow: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if wf.Version != 2 || wf.Status != "draft" || wf.ActiveVersion == nil || *wf.ActiveVersion != 1 {
+			t.Errorf("workflow = version %d %s active %v, want draft 2 with 1 active", wf.Version, wf.Status, wf.ActiveVersion)
+		}
+		vs := versions(t)
+		if vs[1].Status != "active" || vs[2].Status != "draft" || vs[2].StepCount != 2 || vs[1].RunCount != 1 {
+			t.Errorf("versions = %+v, want 1 active with its run and 2 a draft of 2 steps", vs)
+		}
+		frozen := dba.QueryFlowScalar(t, `SELECT prompt_template FROM workflow_steps WHERE step_id = ?`, stepIDs["b"])
+		if frozen != "V1B {{.Step.a}}" {
+			t.Errorf("version 1 step b = %v, want it unchanged", frozen)
+		}
+
+		// Further edits go to the same draft.
+		resp, err = h.Do("PUT", "/api/workflows/"+wfID, map[string]interface{}{
+			"description": "Two chained steps, reworded",
+		}, opToken)
+		if err != nil {
+			t.Fatalf("update workflow: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusOK)
+		if n := len(versions(t)); n != 2 {
+			t.Errorf("%d versions after a second edit, want 2", n)
+		}
+	})
+
+	t.Run("RunsKeepActiveVersion", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		if v := run(t, "second"); v != 1 {
+			t.Errorf("run during the draft executed version %d, want 1", v)
+		}
+		if got := ranTags("second"); got != "V1A,V1B" {
+			t.Errorf("steps run = %s, want version 1's", got)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		var diff struct {
+			From   int `json:"from"`
+			To     int `json:"to"`
+			Fields map[string]struct {
+				From interface{} `json:"from"`
+				To   interface{} `json:"to"`
+			} `json:"fields"`
+			Steps []struct {
+				StepName string `json:"step_name"`
+				Change   string `json:"change"`
+				Fields   map[string]struct {
+					From interface{} `json:"from"`
+					To   interface{} `json:"to"`
+				} `json:"fields"`
+			} `json:"steps"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows/"+wfID+"/versions/diff", nil, opToken, &diff)
+		if err != nil {
+			t.Fatalf("diff: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if diff.From != 1 || diff.To != 2 {
+			t.Errorf("diff %d -> %d, want the active version against the draft", diff.From, diff.To)
+		}
+		if _, ok := diff.Fields["description"]; !ok || len(diff.Fields) != 1 {
+			t.Errorf("field changes = %v, want the description only", diff.Fields)
+		}
+		changes := map[string]string{}
+		for _, s := range diff.Steps {
+			changes[s.StepName] = s.Change
+			if s.StepName == "b" {
+				pt := s.Fields["prompt_template"]
+				if pt.From != "V1B {{.Step.a}}" || pt.To != "V2B {{.Step.a}}" || len(s.Fields) != 1 {
+					t.Errorf("step b changes = %v, want its prompt only", s.Fields)
+				}
+			}
+		}
+		if changes["a"] != "unchanged" || changes["b"] != "changed" || len(changes) != 2 {
+			t.Errorf("step changes = %v, want a unchanged and b changed", changes)
+		}
+	})
+
+	t.Run("Abuse_EditFrozenStep", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		// The version 1 ID is not a step of the draft.
+		resp, err := h.Do("DELETE", "/api/workflows/"+wfID+"/steps/"+stepIDs["b"], nil, opToken)
+		if err != nil {
+			t.Fatalf("delete step: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusNotFound)
+
+		for path, want := range map[string]int{
+			"/api/workflows/" + wfID + "/versions/9":              http.StatusNotFound,
+			"/api/workflows/" + wfID + "/versions/diff?to=9":      http.StatusNotFound,
+			"/api/workflows/" + wfID + "/versions/diff?from=zero": http.StatusBadRequest,
+			"/api/workflows/no-such-workflow/versions":            http.StatusNotFound,
+		} {
+			resp, err := h.Do("GET", path, nil, opToken)
+			if err != nil {
+				t.Fatalf("GET %s: %v", path, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != want {
+				t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+			}
+		}
+	})
+
+	t.Run("ActivateNewVersion", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		lifecycle(t)
+		vs := versions(t)
+		if vs[1].Status != "superseded" || vs[2].Status != "active" {
+			t.Errorf("versions = %+v, want 1 superseded by 2", vs)
+		}
+		if v := run(t, "third"); v != 2 {
+			t.Errorf("run after activation executed version %d, want 2", v)
+		}
+		if got := ranTags("third"); got != "V1A,V2B" {
+			t.Errorf("steps run = %s, want version 2's", got)
+		}
+		ran := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_step_runs WHERE step_id = ?`, draftB)
+		if n, _ := ran.(int64); n != 1 {
+			t.Errorf("%v step runs of version 2's step b, want 1", ran)
+		}
+
+		var v1 struct {
+			Status string `json:"status"`
+			Steps  []struct {
+				StepID         string `json:"step_id"`
+				PromptTemplate string `json:"prompt_template"`
+			} `json:"steps"`
+		}
+		resp, err := h.JSON("GET", "/api/workflows/"+wfID+"/versions/1", nil, opToken, &v1)
+		if err != nil {
+			t.Fatalf("get version: %v", err)
+		}
+		RequireStatus(t, resp, http.StatusOK)
+		if v1.Status != "superseded" || len(v1.Steps) != 2 || v1.Steps[1].StepID != stepIDs["b"] || v1.Steps[1].PromptTemplate != "V1B {{.Step.a}}" {
+			t.Errorf("version 1 = %+v, want its own steps", v1)
+		}
+	})
+	// Starting the draft and applying the edit are one transaction: an edit
+	// that fails leaves the active version alone, with no empty draft.
+	t.Run("Abuse_FailedEditNoDraft", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		resp, err := h.Do("POST", "/api/workflows/"+wfID+"/steps", step("a", "DUPLICATE {{.Body}}"), opToken)
+		if err != nil {
+			t.Fatalf("add step: %v", err)
+		}
+		resp.Body.Close()
+		RequireStatus(t, resp, http.StatusConflict)
+		vs := versions(t)
+		if len(vs) != 2 || vs[2].Status != "active" {
+			t.Errorf("versions = %+v, want version 2 still active and no draft", vs)
+		}
+	})
+
+	// Edits racing to start the next draft: one starts it, the others
+	// apply to it or are told to retry, and none fails the server.
+	t.Run("ConcurrentEdits", func(t *testing.T) {
+		start := time.Now()
+		defer func() { Record(t, start, nil, nil) }()
+
+		const editors = 8
+		statuses := make(chan int, editors)
+		var wg sync.WaitGroup
+		for i := 0; i < editors; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := h.Do("PUT", "/api/workflows/"+wfID, map[string]interface{}{
+					"description": "Two chained steps, edited concurrently",
+				}, opToken)
+				if err != nil {
+					statuses <- 0
+					return
+				}
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+		for status := range statuses {
+			if status != http.StatusOK && status != http.StatusConflict {
+				t.Errorf("concurrent edit = %d, want 200 or 409", status)
+			}
+		}
+		vs := versions(t)
+		if len(vs) != 3 || vs[3].Status != "draft" || vs[3].StepCount != 2 {
+			t.Errorf("versions = %+v, want a single draft 3 with both steps", vs)
+		}
+		created := dba.QueryFlowScalar(t, `SELECT COUNT(*) FROM workflow_audit_log WHERE event_type = 'version_created' AND json_extract(event_data_json, '$.workflow_id') = ? AND json_extract(event_data_json, '$.version') = 3`, wfID)
+		if n, _ := created.(int64); n != 1 {
+			t.Errorf("%v version_created entries for version 3, want 1", created)
+		}
+	})
+}
@@ -43,13 +43,18 @@ type API struct {
 	auth            *auth.Auth
 	resEngine       *llm.ResolutionEngine
 	challengeRunner *llm.ChallengeRunner
+	flowRegistry    *llm.FlowRegistry
 	replayEngine    *llm.ReplayEngine
 	workflowEngine  *llm.WorkflowEngine
 	modelDiscovery  *llm.ModelDiscovery
 	llmClient       *llm.Client
+	registered      *llm.RegisteredProviders
+	indexer         *llm.Indexer
 	botUserID       string
 	fedConfig       *config.FederationConfig
 	instConfig      *config.InstanceConfig
+	bundleKeys      *llm.BundleKeys
+	triggers        *llm.TriggerRunner
 }
 
 // SetBotUserID sets the bot user ID for auto-answer endpoints.
@@ -79,6 +84,12 @@ func (a *API) SetLLMClient(c *llm.Client) {
 	a.llmClient = c
 }
 
+// SetRegisteredProviders sets the sync that turns self-registered providers
+// into LLM backends; registration and heartbeats then take effect at once.
+func (a *API) SetRegisteredProviders(r *llm.RegisteredProviders) {
+	a.registered = r
+}
+
 func New(database *db.DB, a *auth.Auth) *API {
 	return &API{db: database, auth: a}
 }
@@ -140,9 +151,17 @@ func (a *API) RegisterRoutes(mux *http.ServeMux) {
 	// Forensic
 	a.RegisterForensicRoutes(mux)
 
+	// Metrics (latency percentiles, error rates, daily rollups)
+	a.RegisterMetricsRoutes(mux)
+
 	// Dispatch (multi-model parallel)
 	a.RegisterDispatchRoutes(mux)
 
+	// LLM client state (provider health)
+	a.RegisterLLMRoutes(mux)
+	a.RegisterCostRoutes(mux)
+	a.RegisterCacheRoutes(mux)
+
 	// Provider self-registration
 	a.RegisterProviderRoutes(mux)
 
@@ -152,8 +171,9 @@ func (a *API) RegisterRoutes(mux *http.ServeMux) {
 	// Benchmarks
 	a.RegisterBenchmarkRoutes(mux)
 
-	// Deduplication
+	// Deduplication + vector index
 	a.RegisterDedupRoutes(mux)
+	a.RegisterEmbeddingRoutes(mux)
 
 	// Dynamic workflows (VACF)
 	a.RegisterWorkflowRoutes(mux)
@@ -330,6 +350,8 @@ func (a *API) handleAsk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.enqueueEmbedding("node", node.ID)
+
 	// Search for similar claims
 	similar, _ := a.db.SearchNodes(req.Body, 5)
 
@@ -340,7 +362,7 @@ func (a *API) handleAsk(w http.ResponseWriter, r *http.Request) {
 	// If LLM is available, decompose via ALL providers in parallel for benchmarking
 	var decompositions []map[string]interface{}
 	if a.llmClient != nil && len(a.llmClient.Providers()) > 0 {
-		decompositions = DecomposeAllProviders(r.Context(), a.llmClient, req.Body)
+		decompositions = DecomposeAllProviders(a.llmContext(r), a.llmClient, req.Body)
 	}
 
 	jsonResp(w, http.StatusCreated, map[string]interface{}{
@@ -405,6 +427,7 @@ func (a *API) handleAnswer(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	a.enqueueEmbedding("node", node.ID)
 
 	// Safety scoring
 	safetyResult := a.db.ScoreContent(req.Body)
@@ -996,7 +1019,7 @@ func (a *API) handleDecompose(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	assertions, err := DecomposeQuestion(r.Context(), a.llmClient, node.Body)
+	assertions, err := DecomposeQuestion(a.llmContext(r), a.llmClient, node.Body)
 	if err != nil {
 		slog.Error("decomposing question", "error", err)
 		jsonError(w, "decomposition failed: "+err.Error(), http.StatusInternalServerError)
@@ -1140,6 +1163,8 @@ func (a *API) handleAddSource(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	a.enqueueEmbedding("source", source.ID)
+	a.fireTrigger("source_added", nodeID)
 
 	// Async 5W1H extraction if LLM client is configured with providers
 	if a.llmClient != nil && len(a.llmClient.Providers()) > 0 {
@@ -1278,17 +1303,30 @@ func DecomposeAllProviders(ctx context.Context, client *llm.Client, questionText
 
 // decomposeWith calls a specific provider to decompose a question.
 func decomposeWith(ctx context.Context, client *llm.Client, providerName string, questionText string) ([]string, *llm.Response, error) {
-	resp, err := client.CompleteWith(ctx, providerName, decomposeRequest(questionText))
+	resp, err := client.CompleteWith(llm.WithStepName(ctx, "decompose"), providerName, decomposeRequest(questionText))
 	if err != nil {
 		return nil, nil, err
 	}
-	claims, err := parseJSONArray(resp.Content)
-	if err != nil {
+	var out decomposition
+	if err := resp.Decode(&out); err != nil {
 		return nil, nil, err
 	}
-	return claims, resp, nil
+	return out.Assertions, resp, nil
+}
+
+// decomposition is the structured output of decomposeRequest.
+type decomposition struct {
+	Assertions []string `json:"assertions"`
 }
 
+var decompositionSchema = llm.MustSchema("decomposition", `{
+	"type": "object",
+	"properties": {
+		"assertions": {"type": "array", "minItems": 1, "items": {"type": "string", "minLength": 1}}
+	},
+	"required": ["assertions"]
+}`)
+
 func decomposeRequest(questionText string) llm.Request {
 	return llm.Request{
 		Messages: []llm.Message{
@@ -1299,87 +1337,30 @@ Règles :
 - Pas d'opinions, pas de questions ouvertes
 - Formulation affirmative (pas interrogative)
 - Une assertion = une seule idée vérifiable
-- Retourne UNIQUEMENT un tableau JSON de strings, sans commentaire
+- Retourne UNIQUEMENT un objet JSON {"assertions": [...]}, sans commentaire
 
 Example :
 Question : "Mon bailleur peut-il augmenter le loyer de 40% en zone tendue alors que le bail est encore en cours ?"
-Réponse : ["Le bailleur est soumis à l'encadrement des loyers en zone tendue", "Une augmentation de loyer en cours de bail est limitée à l'IRL", "Une augmentation de 40% dépasse le plafond légal de révision annuelle", "Le bail en cours protège le locataire contre les augmentations hors clause de révision"]`},
+Réponse : {"assertions": ["Le bailleur est soumis à l'encadrement des loyers en zone tendue", "Une augmentation de loyer en cours de bail est limitée à l'IRL", "Une augmentation de 40% dépasse le plafond légal de révision annuelle", "Le bail en cours protège le locataire contre les augmentations hors clause de révision"]}`},
 			{Role: "user", Content: questionText},
 		},
 		Temperature: 0.3,
 		MaxTokens:   2048,
+		Schema:      decompositionSchema,
 	}
 }
 
-// parseJSONArray extracts a JSON string array from LLM output, stripping markdown fences.
-func parseJSONArray(content string) ([]string, error) {
-	content = strings.TrimSpace(content)
-	if strings.HasPrefix(content, "```") {
-		lines := strings.Split(content, "\n")
-		var cleaned []string
-		for _, l := range lines {
-			if strings.HasPrefix(l, "```") {
-				continue
-			}
-			cleaned = append(cleaned, l)
-		}
-		content = strings.Join(cleaned, "\n")
-	}
-	var arr []string
-	if err := json.Unmarshal([]byte(content), &arr); err != nil {
-		return nil, err
-	}
-	return arr, nil
-}
-
 // DecomposeQuestion calls the LLM to split a question into atomic assertions (single provider, fallback chain).
 func DecomposeQuestion(ctx context.Context, client *llm.Client, questionText string) ([]string, error) {
-	resp, err := client.Complete(ctx, llm.Request{
-		Messages: []llm.Message{
-			{Role: "system", Content: `Tu es un décomposeur d'assertions. Tu reçois une question complexe et tu la découpes en assertions atomiques — des affirmations vérifiables, indépendantes, formulées de manière factuelle.
-
-Règles :
-- Chaque assertion doit pouvoir être soutenue ou démolie par des sources
-- Pas d'opinions, pas de questions ouvertes
-- Formulation affirmative (pas interrogative)
-- Une assertion = une seule idée vérifiable
-- Retourne UNIQUEMENT un tableau JSON de strings, sans commentaire
-
-Example :
-Question : "Mon bailleur peut-il augmenter le loyer de 40% en zone tendue alors que le bail est encore en cours ?"
-Réponse : ["Le bailleur est soumis à l'encadrement des loyers en zone tendue", "Une augmentation de loyer en cours de bail est limitée à l'IRL", "Une augmentation de 40% dépasse le plafond légal de révision annuelle", "Le bail en cours protège le locataire contre les augmentations hors clause de révision"]`},
-			{Role: "user", Content: questionText},
-		},
-		Temperature: 0.3,
-		MaxTokens:   2048,
-	})
+	resp, err := client.Complete(llm.WithStepName(ctx, "decompose"), decomposeRequest(questionText))
 	if err != nil {
 		return nil, err
 	}
-	if resp == nil {
-		return nil, fmt.Errorf("no LLM provider available")
-	}
-
-	// Parse JSON array from LLM response
-	content := strings.TrimSpace(resp.Content)
-	// Strip markdown code block if present
-	if strings.HasPrefix(content, "```") {
-		lines := strings.Split(content, "\n")
-		var cleaned []string
-		for _, l := range lines {
-			if strings.HasPrefix(l, "```") {
-				continue
-			}
-			cleaned = append(cleaned, l)
-		}
-		content = strings.Join(cleaned, "\n")
-	}
-
-	var assertions []string
-	if err := json.Unmarshal([]byte(content), &assertions); err != nil {
+	var out decomposition
+	if err := resp.Decode(&out); err != nil {
 		return nil, err
 	}
-	return assertions, nil
+	return out.Assertions, nil
 }
 
 // Extract5W1H calls the LLM to extract 5W1H dimensions from source text.
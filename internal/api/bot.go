@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/hazyhaar/horostracker/internal/db"
+	"github.com/hazyhaar/horostracker/internal/llm"
 )
 
 // RegisterBotRoutes adds bot-related API endpoints.
@@ -65,7 +66,7 @@ func (a *API) handleBotAnswer(w http.ResponseWriter, r *http.Request) {
 			jsonError(w, "internal error", http.StatusInternalServerError)
 			return
 		}
-		result, challengeErr := a.challengeRunner.RunChallenge(r.Context(), challenge)
+		result, challengeErr := a.challengeRunner.RunChallenge(a.llmContext(r), challenge)
 		if challengeErr != nil {
 			slog.Error("bot challenge failed", "error", challengeErr)
 			jsonError(w, "challenge failed: "+challengeErr.Error(), http.StatusInternalServerError)
@@ -78,11 +79,21 @@ func (a *API) handleBotAnswer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Default: generate a synthesis answer
-	result, err := a.resEngine.GenerateResolution(r.Context(), tree, req.Provider, req.Model)
+	// Default: generate a synthesis answer (SSE token stream if requested)
+	var sse *sseWriter
+	var result *llm.ResolutionResult
+	if wantsStream(r) {
+		if sse = newSSEWriter(w); sse == nil {
+			jsonError(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		result, err = a.resEngine.StreamResolution(a.llmContext(r), tree, req.Provider, req.Model, sse.Delta)
+	} else {
+		result, err = a.resEngine.GenerateResolution(a.llmContext(r), tree, req.Provider, req.Model)
+	}
 	if err != nil {
 		slog.Error("bot answer failed", "error", err)
-		jsonError(w, "generation failed: "+err.Error(), http.StatusInternalServerError)
+		streamError(w, sse, "generation failed: "+err.Error(), llmErrorStatus(err))
 		return
 	}
 
@@ -99,16 +110,18 @@ func (a *API) handleBotAnswer(w http.ResponseWriter, r *http.Request) {
 			"tokens_in":  result.TokensIn,
 			"tokens_out": result.TokensOut,
 			"latency_ms": result.LatencyMs,
+			"strategy":   result.Strategy,
+			"flow_id":    result.FlowID,
 			"bot":        true,
 		}),
 	})
 	if err != nil {
 		slog.Error("bot node creation failed", "error", err)
-		jsonError(w, "internal error", http.StatusInternalServerError)
+		streamError(w, sse, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	jsonResp(w, http.StatusCreated, map[string]interface{}{
+	streamResp(w, sse, http.StatusCreated, map[string]interface{}{
 		"type":       "claim",
 		"node":       node,
 		"generation": result,
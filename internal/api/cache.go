@@ -0,0 +1,68 @@
+// CLAUDE:SUMMARY LLM response cache API — cache status (entries, hits, USD saved) and operator purge of expired or all entries
+package api
+
+import (
+	"net/http"
+)
+
+// RegisterCacheRoutes adds the response cache endpoints.
+func (a *API) RegisterCacheRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/llm/cache", a.handleCacheStats)
+	mux.HandleFunc("DELETE /api/llm/cache", a.handlePurgeCache)
+}
+
+// handleCacheStats reports whether the cache is on, its default TTL and
+// its hit totals.
+func (a *API) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if a.flowsDB == nil {
+		jsonError(w, "flows database not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	stats, err := a.flowsDB.LLMCacheStats()
+	if err != nil {
+		jsonError(w, "reading cache stats", http.StatusInternalServerError)
+		return
+	}
+
+	enabled, ttlHours := false, 0.0
+	if a.llmClient != nil && a.llmClient.Cache() != nil {
+		enabled = true
+		ttlHours = a.llmClient.Cache().TTL().Hours()
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"enabled":   enabled,
+		"ttl_hours": ttlHours,
+		"entries":   stats.Entries,
+		"expired":   stats.Expired,
+		"hits":      stats.Hits,
+		"saved_usd": stats.SavedUSD,
+	})
+}
+
+// handlePurgeCache deletes expired entries, or every entry with ?all=1
+// (operator only).
+func (a *API) handlePurgeCache(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+	if a.flowsDB == nil {
+		jsonError(w, "flows database not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	all := r.URL.Query().Get("all") == "1"
+	n, err := a.flowsDB.PurgeLLMCache(all)
+	if err != nil {
+		jsonError(w, "purging cache: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = a.flowsDB.InsertAuditLog("", "", "llm_cache_purged", map[string]interface{}{
+		"all":       all,
+		"deleted":   n,
+		"purged_by": claims.UserID,
+	})
+	jsonResp(w, http.StatusOK, map[string]interface{}{"status": "purged", "deleted": n})
+}
@@ -20,6 +20,12 @@ func (a *API) RegisterChallengeRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/moderation/{nodeID}", a.handleGetModeration)
 	mux.HandleFunc("GET /api/leaderboard/adversarial", a.handleChallengeLeaderboard)
 	mux.HandleFunc("GET /api/flows", a.handleListFlows)
+	mux.HandleFunc("GET /api/flows/status", a.handleFlowsStatus)
+}
+
+// SetFlowRegistry sets the registry of flow files reported by /api/flows/status.
+func (a *API) SetFlowRegistry(r *llm.FlowRegistry) {
+	a.flowRegistry = r
 }
 
 func (a *API) handleCreateChallenge(w http.ResponseWriter, r *http.Request) {
@@ -112,7 +118,7 @@ func (a *API) handleRunChallenge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := a.challengeRunner.RunChallenge(r.Context(), challenge)
+	result, err := a.challengeRunner.RunChallenge(a.llmContext(r), challenge)
 	if err != nil {
 		slog.Error("running challenge", "error", err)
 		jsonError(w, "challenge execution failed: "+err.Error(), http.StatusInternalServerError)
@@ -210,23 +216,50 @@ func (a *API) handleChallengeLeaderboard(w http.ResponseWriter, r *http.Request)
 }
 
 func (a *API) handleListFlows(w http.ResponseWriter, r *http.Request) {
-	flows := llm.CoreFlows()
+	flows := llm.Flows()
 	type flowInfo struct {
 		Name        string `json:"name"`
 		Description string `json:"description"`
 		StepCount   int    `json:"step_count"`
+		Source      string `json:"source"` // "builtin" or the flow file
 	}
 	result := make([]flowInfo, 0, len(flows))
 	for _, f := range flows {
+		source := f.Source
+		if source == "" {
+			source = "builtin"
+		}
 		result = append(result, flowInfo{
 			Name:        f.Name,
 			Description: f.Description,
 			StepCount:   len(f.Steps),
+			Source:      source,
 		})
 	}
 	jsonResp(w, http.StatusOK, result)
 }
 
+// handleFlowsStatus reports the flow files and why any was rejected, so
+// that whoever edits them can see their mistakes without server logs.
+func (a *API) handleFlowsStatus(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+	if a.flowRegistry == nil {
+		jsonError(w, "flow files not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	dir, loadedAt, files := a.flowRegistry.Status()
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"dir":       dir,
+		"loaded_at": loadedAt,
+		"files":     files,
+	})
+}
+
 func joinFlows() string {
 	flows := llm.ValidFlows()
 	result := ""
@@ -0,0 +1,345 @@
+// CLAUDE:SUMMARY LLM cost API — model price table, spend budgets (user/run/instance), spend reports and cost estimates for benchmarks and bulk replays
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+	"github.com/hazyhaar/horostracker/internal/llm"
+)
+
+// RegisterCostRoutes adds the pricing, budget and spend endpoints.
+func (a *API) RegisterCostRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/llm/prices", a.handleListPrices)
+	mux.HandleFunc("POST /api/llm/prices", a.handleSetPrice)
+	mux.HandleFunc("DELETE /api/llm/prices/{provider}/{model...}", a.handleDeletePrice)
+	mux.HandleFunc("GET /api/llm/budgets", a.handleListBudgets)
+	mux.HandleFunc("POST /api/llm/budgets", a.handleSetBudget)
+	mux.HandleFunc("DELETE /api/llm/budgets/{id}", a.handleDeleteBudget)
+	mux.HandleFunc("GET /api/llm/spend", a.handleSpend)
+	mux.HandleFunc("POST /api/llm/estimate", a.handleEstimateCost)
+}
+
+// llmContext attributes the LLM calls made while serving r to the
+// authenticated user, so that their budget applies, and carries the
+// response cache mode requested with ?cache=use|refresh|bypass.
+func (a *API) llmContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if mode, err := llm.ParseCacheMode(r.URL.Query().Get("cache")); err == nil && mode != llm.CacheDefault {
+		ctx = llm.WithCacheMode(ctx, mode)
+	}
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		return ctx
+	}
+	return llm.WithSpender(ctx, llm.Spender{UserID: claims.UserID})
+}
+
+// llmErrorStatus maps an LLM failure to an HTTP status: a refused budget is
+// 402 so that clients can tell it apart from a provider failure.
+func llmErrorStatus(err error) int {
+	if errors.Is(err, llm.ErrBudgetExceeded) {
+		return http.StatusPaymentRequired
+	}
+	return http.StatusInternalServerError
+}
+
+func (a *API) handleListPrices(w http.ResponseWriter, r *http.Request) {
+	prices, err := a.flowsDB.ListModelPrices(r.URL.Query().Get("provider"))
+	if err != nil {
+		jsonError(w, "listing prices: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if prices == nil {
+		prices = []db.ModelPrice{}
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"prices":   prices,
+		"count":    len(prices),
+		"currency": "USD",
+		"unit":     "per_million_tokens",
+	})
+}
+
+func (a *API) handleSetPrice(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+
+	var req db.ModelPrice
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Provider == "" || req.ModelName == "" {
+		jsonError(w, "provider and model_name are required", http.StatusBadRequest)
+		return
+	}
+	if req.InputPerMTok < 0 || req.OutputPerMTok < 0 {
+		jsonError(w, "prices must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.flowsDB.UpsertModelPrice(&req, claims.UserID); err != nil {
+		jsonError(w, "saving price: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = a.flowsDB.InsertAuditLog("", "", "price_updated", map[string]interface{}{
+		"provider":        req.Provider,
+		"model_name":      req.ModelName,
+		"input_per_mtok":  req.InputPerMTok,
+		"output_per_mtok": req.OutputPerMTok,
+		"updated_by":      claims.UserID,
+	})
+
+	req.Source = "operator"
+	req.UpdatedBy = &claims.UserID
+	jsonResp(w, http.StatusOK, req)
+}
+
+func (a *API) handleDeletePrice(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+
+	provider, model := r.PathValue("provider"), r.PathValue("model")
+	found, err := a.flowsDB.DeleteModelPrice(provider, model)
+	if err != nil {
+		jsonError(w, "deleting price: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		jsonError(w, "price not found", http.StatusNotFound)
+		return
+	}
+	_ = a.flowsDB.InsertAuditLog("", "", "price_deleted", map[string]string{
+		"provider":   provider,
+		"model_name": model,
+		"deleted_by": claims.UserID,
+	})
+	jsonResp(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (a *API) handleListBudgets(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+
+	budgets, err := a.flowsDB.ListBudgets()
+	if err != nil {
+		jsonError(w, "listing budgets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if budgets == nil {
+		budgets = []db.Budget{}
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{"budgets": budgets, "count": len(budgets)})
+}
+
+func (a *API) handleSetBudget(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Scope    string  `json:"scope"`
+		ScopeID  string  `json:"scope_id"`
+		LimitUSD float64 `json:"limit_usd"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	switch req.Scope {
+	case db.BudgetScopeUser, db.BudgetScopeRun:
+	case db.BudgetScopeInstance:
+		req.ScopeID = ""
+	default:
+		jsonError(w, "scope must be user, run or instance", http.StatusBadRequest)
+		return
+	}
+	if req.LimitUSD < 0 {
+		jsonError(w, "limit_usd must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	budget, err := a.flowsDB.SetBudget(req.Scope, req.ScopeID, req.LimitUSD, claims.UserID)
+	if err != nil {
+		jsonError(w, "saving budget: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = a.flowsDB.InsertAuditLog("", "", "budget_set", map[string]interface{}{
+		"scope":     req.Scope,
+		"scope_id":  req.ScopeID,
+		"limit_usd": req.LimitUSD,
+		"set_by":    claims.UserID,
+	})
+	jsonResp(w, http.StatusOK, budget)
+}
+
+func (a *API) handleDeleteBudget(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+
+	id := r.PathValue("id")
+	found, err := a.flowsDB.DeleteBudget(id)
+	if err != nil {
+		jsonError(w, "deleting budget: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		jsonError(w, "budget not found", http.StatusNotFound)
+		return
+	}
+	_ = a.flowsDB.InsertAuditLog("", "", "budget_deleted", map[string]string{
+		"budget_id":  id,
+		"deleted_by": claims.UserID,
+	})
+	jsonResp(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// handleSpend reports spend per provider/model over ?days= (default 1 = today)
+// with the budgets that apply. Users see their own spend; operators see the
+// whole instance, or one user with ?user_id=.
+func (a *API) handleSpend(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	userID := claims.UserID
+	if a.isOperator(claims.UserID) {
+		userID = r.URL.Query().Get("user_id")
+	}
+	days := 1
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+		days = min(d, 366)
+	}
+
+	rows, err := a.flowsDB.SpendByModel(userID, days)
+	if err != nil {
+		jsonError(w, "querying spend: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rows == nil {
+		rows = []db.SpendRow{}
+	}
+	var total float64
+	for _, s := range rows {
+		total += s.CostUSD
+	}
+
+	budgets := []llm.BudgetStatus{}
+	if a.llmClient != nil && a.llmClient.Ledger() != nil {
+		budgets = a.llmClient.Ledger().Status(llm.Spender{UserID: userID})
+	}
+
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"user_id":   userID,
+		"days":      days,
+		"by_model":  rows,
+		"total_usd": total,
+		"budgets":   budgets,
+	})
+}
+
+// handleEstimateCost prices planned work before it runs. Either a bulk
+// replay (same filter as POST /api/replay/bulk, using the recorded token
+// counts of the steps it would replay) or an explicit per-call token volume
+// across "provider/model" strings, e.g. a benchmark's model list.
+func (a *API) handleEstimateCost(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if a.llmClient == nil || a.llmClient.Ledger() == nil {
+		jsonError(w, "cost accounting not configured", http.StatusServiceUnavailable)
+		return
+	}
+	ledger := a.llmClient.Ledger()
+
+	var req struct {
+		Models       []string `json:"models"`
+		Calls        int      `json:"calls"`
+		PromptTokens int      `json:"prompt_tokens"`
+		OutputTokens int      `json:"output_tokens"`
+		Replay       *struct {
+			FilterModel string `json:"filter_model"`
+			Provider    string `json:"provider"`
+			ReplayModel string `json:"replay_model"`
+		} `json:"replay"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	type estimate struct {
+		Model     string  `json:"model"`
+		Calls     int     `json:"calls"`
+		TokensIn  int     `json:"tokens_in"`
+		TokensOut int     `json:"tokens_out"`
+		CostUSD   float64 `json:"cost_usd"`
+		Priced    bool    `json:"priced"`
+	}
+	var estimates []estimate
+
+	switch {
+	case req.Replay != nil:
+		if req.Replay.ReplayModel == "" {
+			jsonError(w, "replay.replay_model is required", http.StatusBadRequest)
+			return
+		}
+		steps, in, out, err := a.flowsDB.ReplayCandidateUsage(req.Replay.FilterModel)
+		if err != nil {
+			jsonError(w, "querying steps: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		model := req.Replay.ReplayModel
+		if req.Replay.Provider != "" {
+			model = req.Replay.Provider + "/" + model
+		}
+		cost, priced := ledger.CostOf(model, in, out)
+		estimates = append(estimates, estimate{model, steps, in, out, cost, priced})
+	case len(req.Models) > 0:
+		if req.Calls <= 0 || req.PromptTokens < 0 || req.OutputTokens < 0 {
+			jsonError(w, "calls must be positive and token counts not negative", http.StatusBadRequest)
+			return
+		}
+		for _, m := range req.Models {
+			in, out := req.Calls*req.PromptTokens, req.Calls*req.OutputTokens
+			cost, priced := ledger.CostOf(m, in, out)
+			estimates = append(estimates, estimate{m, req.Calls, in, out, cost, priced})
+		}
+	default:
+		jsonError(w, "either replay or models is required", http.StatusBadRequest)
+		return
+	}
+
+	var total float64
+	for _, e := range estimates {
+		total += e.CostUSD
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"estimates": estimates,
+		"total_usd": total,
+		"budgets":   ledger.Status(llm.Spender{UserID: claims.UserID}),
+	})
+}
@@ -1,4 +1,4 @@
-// CLAUDE:SUMMARY Deduplication API — check content similarity (hash + fuzzy), list and inspect duplicate clusters
+// CLAUDE:SUMMARY Deduplication API — check content similarity (hash + fuzzy + embedding), list and inspect duplicate clusters
 package api
 
 import (
@@ -10,6 +10,7 @@ import (
 	"unicode"
 
 	"github.com/hazyhaar/horostracker/internal/db"
+	"github.com/hazyhaar/horostracker/internal/llm"
 )
 
 func (a *API) RegisterDedupRoutes(mux *http.ServeMux) {
@@ -23,6 +24,7 @@ func (a *API) handleDedupCheck(w http.ResponseWriter, r *http.Request) {
 		Body       string  `json:"body"`
 		Threshold  float64 `json:"threshold"`
 		Method     string  `json:"method"`
+		Model      string  `json:"model"` // embedding method: "provider/model" (default: configured embedder)
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -54,6 +56,7 @@ func (a *API) handleDedupCheck(w http.ResponseWriter, r *http.Request) {
 		NodeID     string  `json:"node_id"`
 		Similarity float64 `json:"similarity"`
 		Method     string  `json:"method"`
+		Model      string  `json:"model"` // embedding method: "provider/model" (default: configured embedder)
 	}
 
 	var matches []match
@@ -81,6 +84,33 @@ func (a *API) handleDedupCheck(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Level 3: Embedding (cosine similarity to root nodes)
+	if req.Method == "embedding" || req.Method == "all" {
+		if a.indexer == nil {
+			if req.Method == "embedding" {
+				jsonError(w, "embeddings not enabled", http.StatusServiceUnavailable)
+				return
+			}
+		} else {
+			_, neighbors, err := a.indexer.Nearest(a.llmContext(r), req.Model, llm.NearestQuery{
+				Text: req.Body, K: 10, MinScore: req.Threshold, RootsOnly: true,
+			})
+			if err != nil && req.Method == "embedding" {
+				jsonError(w, "embedding lookup failed: "+err.Error(), embeddingErrorStatus(err))
+				return
+			}
+			seen := make(map[string]bool, len(matches))
+			for _, m := range matches {
+				seen[m.NodeID] = true
+			}
+			for _, n := range neighbors {
+				if !seen[n.OwnerID] {
+					matches = append(matches, match{NodeID: n.OwnerID, Similarity: n.Score, Method: "embedding"})
+				}
+			}
+		}
+	}
+
 	// Check if there's an existing cluster for exact matches
 	var clusterID string
 	if len(matches) > 0 {
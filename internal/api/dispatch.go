@@ -53,17 +53,29 @@ func (a *API) handleDispatch(w http.ResponseWriter, r *http.Request) {
 			dispatchID, hashPrompt(req.Prompt), string(modelsJSON))
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	// SSE mode: per-model deltas tagged with their index, a "result" event as
+	// each model finishes, then "done" with the same payload as the JSON response.
+	var sse *sseWriter
+	if wantsStream(r) {
+		if sse = newSSEWriter(w); sse == nil {
+			jsonError(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(a.llmContext(r), timeout)
 	defer cancel()
 
 	type modelResult struct {
-		Model     string `json:"model"`
-		Provider  string `json:"provider"`
-		Content   string `json:"content"`
-		TokensIn  int    `json:"tokens_in"`
-		TokensOut int    `json:"tokens_out"`
-		LatencyMs int    `json:"latency_ms"`
-		Error     string `json:"error,omitempty"`
+		Model     string  `json:"model"`
+		Provider  string  `json:"provider"`
+		Content   string  `json:"content"`
+		TokensIn  int     `json:"tokens_in"`
+		TokensOut int     `json:"tokens_out"`
+		LatencyMs int     `json:"latency_ms"`
+		CostUSD   float64 `json:"cost_usd"`
+		Cached    bool    `json:"cached"`
+		Error     string  `json:"error,omitempty"`
 	}
 
 	results := make([]modelResult, len(req.Models))
@@ -86,7 +98,19 @@ func (a *API) handleDispatch(w http.ResponseWriter, r *http.Request) {
 			}
 
 			start := time.Now()
-			resp, err := a.llmClient.Complete(ctx, llmReq)
+			var resp *llm.Response
+			var err error
+			if sse != nil {
+				resp, err = a.llmClient.Stream(ctx, llmReq, func(delta string) error {
+					return sse.Send("delta", map[string]interface{}{
+						"index":   i,
+						"model":   model,
+						"content": delta,
+					})
+				})
+			} else {
+				resp, err = a.llmClient.Complete(ctx, llmReq)
+			}
 			latency := time.Since(start)
 
 			mr := modelResult{
@@ -101,10 +125,18 @@ func (a *API) handleDispatch(w http.ResponseWriter, r *http.Request) {
 				mr.Provider = resp.Provider
 				mr.TokensIn = resp.TokensIn
 				mr.TokensOut = resp.TokensOut
+				mr.CostUSD = resp.CostUSD
+				mr.Cached = resp.Cached
 				mr.Model = resp.Model
 			}
 
 			results[i] = mr
+			if sse != nil {
+				_ = sse.Send("result", map[string]interface{}{
+					"index":  i,
+					"result": mr,
+				})
+			}
 
 			// Persist step if requested
 			if req.Persist && a.flowsDB != nil {
@@ -119,11 +151,11 @@ func (a *API) handleDispatch(w http.ResponseWriter, r *http.Request) {
 				}
 				_, _ = a.flowsDB.Exec(`INSERT INTO flow_steps (id, flow_id, step_index, model_id, provider,
 					prompt, system_prompt, response_raw, response_parsed,
-					tokens_in, tokens_out, latency_ms, dispatch_id, error)
-					VALUES (?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+					tokens_in, tokens_out, latency_ms, cost_usd, cached, dispatch_id, error)
+					VALUES (?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 					stepID, dispatchID, model, mr.Provider,
 					req.Prompt, req.System, content, content,
-					mr.TokensIn, mr.TokensOut, mr.LatencyMs,
+					mr.TokensIn, mr.TokensOut, mr.LatencyMs, mr.CostUSD, mr.Cached,
 					dispatchID, nilStr(errStr))
 			}
 		}(i, model)
@@ -136,7 +168,7 @@ func (a *API) handleDispatch(w http.ResponseWriter, r *http.Request) {
 		_, _ = a.flowsDB.Exec(`UPDATE dispatches SET status = 'completed', completed_at = datetime('now') WHERE id = ?`, dispatchID)
 	}
 
-	jsonResp(w, http.StatusOK, map[string]interface{}{
+	streamResp(w, sse, http.StatusOK, map[string]interface{}{
 		"dispatch_id": dispatchID,
 		"results":     results,
 	})
@@ -162,7 +194,7 @@ func (a *API) handleDispatchResults(w http.ResponseWriter, r *http.Request) {
 	// Get steps
 	rows, err := a.flowsDB.Query(`
 		SELECT id, model_id, provider, COALESCE(response_raw,''), COALESCE(tokens_in,0),
-			COALESCE(tokens_out,0), COALESCE(latency_ms,0), COALESCE(error,'')
+			COALESCE(tokens_out,0), COALESCE(latency_ms,0), COALESCE(cost_usd,0), cached, COALESCE(error,'')
 		FROM flow_steps WHERE dispatch_id = ? ORDER BY model_id`, dispatchID)
 	if err != nil {
 		jsonError(w, "query failed", http.StatusInternalServerError)
@@ -171,21 +203,25 @@ func (a *API) handleDispatchResults(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	type stepResult struct {
-		ID        string `json:"id"`
-		ModelID   string `json:"model_id"`
-		Provider  string `json:"provider"`
-		Content   string `json:"content"`
-		TokensIn  int    `json:"tokens_in"`
-		TokensOut int    `json:"tokens_out"`
-		LatencyMs int    `json:"latency_ms"`
-		Error     string `json:"error,omitempty"`
+		ID        string  `json:"id"`
+		ModelID   string  `json:"model_id"`
+		Provider  string  `json:"provider"`
+		Content   string  `json:"content"`
+		TokensIn  int     `json:"tokens_in"`
+		TokensOut int     `json:"tokens_out"`
+		LatencyMs int     `json:"latency_ms"`
+		CostUSD   float64 `json:"cost_usd"`
+		Cached    bool    `json:"cached"`
+		Error     string  `json:"error,omitempty"`
 	}
 
 	var steps []stepResult
+	var totalCost float64
 	for rows.Next() {
 		var s stepResult
-		if rows.Scan(&s.ID, &s.ModelID, &s.Provider, &s.Content, &s.TokensIn, &s.TokensOut, &s.LatencyMs, &s.Error) == nil {
+		if rows.Scan(&s.ID, &s.ModelID, &s.Provider, &s.Content, &s.TokensIn, &s.TokensOut, &s.LatencyMs, &s.CostUSD, &s.Cached, &s.Error) == nil {
 			steps = append(steps, s)
+			totalCost += s.CostUSD
 		}
 	}
 
@@ -196,6 +232,7 @@ func (a *API) handleDispatchResults(w http.ResponseWriter, r *http.Request) {
 		"status":       status,
 		"completed_at": completedAt,
 		"results":      steps,
+		"cost_usd":     totalCost,
 	})
 }
 
@@ -0,0 +1,184 @@
+// CLAUDE:SUMMARY Embeddings API — vector index status, operator backfill, and nearest-neighbour search over public nodes and sources
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hazyhaar/horostracker/internal/llm"
+)
+
+// EmbeddingSearchRateLimiter limits POST /api/embeddings/search (30 req/60s):
+// every text query costs an embedding call.
+var EmbeddingSearchRateLimiter = NewRateLimiter(30, 60*time.Second)
+
+// SetIndexer sets the vector index used by semantic endpoints.
+func (a *API) SetIndexer(ix *llm.Indexer) {
+	a.indexer = ix
+}
+
+// RegisterEmbeddingRoutes adds the vector index endpoints.
+func (a *API) RegisterEmbeddingRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/embeddings/status", a.handleEmbeddingStatus)
+	mux.HandleFunc("POST /api/embeddings/backfill", a.handleEmbeddingBackfill)
+	mux.HandleFunc("POST /api/embeddings/search", RateLimitMiddleware(EmbeddingSearchRateLimiter, a.handleEmbeddingSearch))
+}
+
+// enqueueEmbedding schedules a new node or source for indexing.
+func (a *API) enqueueEmbedding(ownerType, ownerID string) {
+	if a.indexer != nil {
+		a.indexer.Enqueue(ownerType, ownerID)
+	}
+}
+
+// handleEmbeddingStatus reports the embedder in use, the providers able to
+// embed and the number of indexed nodes and sources.
+func (a *API) handleEmbeddingStatus(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{"enabled": a.indexer != nil}
+	if a.llmClient != nil {
+		resp["providers"] = a.llmClient.Embedders()
+	}
+	if a.indexer == nil {
+		jsonResp(w, http.StatusOK, resp)
+		return
+	}
+	embedder, err := a.indexer.Embedder(r.URL.Query().Get("model"))
+	if err != nil {
+		resp["error"] = err.Error()
+		jsonResp(w, http.StatusOK, resp)
+		return
+	}
+	counts, err := a.db.CountEmbeddings(embedder)
+	if err != nil {
+		jsonError(w, "counting embeddings", http.StatusInternalServerError)
+		return
+	}
+	resp["embedder"] = embedder
+	resp["counts"] = counts
+	jsonResp(w, http.StatusOK, resp)
+}
+
+// handleEmbeddingBackfill embeds public nodes and sources missing a vector
+// (operator only). model may name another embedder ("provider/model").
+func (a *API) handleEmbeddingBackfill(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+	if a.indexer == nil {
+		jsonError(w, "embeddings not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Model string `json:"model"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	res, err := a.indexer.Backfill(a.llmContext(r), req.Model, req.Limit)
+	if err != nil {
+		jsonError(w, "backfill failed: "+err.Error(), embeddingErrorStatus(err))
+		return
+	}
+	if a.flowsDB != nil {
+		_ = a.flowsDB.InsertAuditLog("", "", "embeddings_backfilled", map[string]interface{}{
+			"embedder":     res.Embedder,
+			"nodes":        res.Nodes,
+			"sources":      res.Sources,
+			"requested_by": claims.UserID,
+		})
+	}
+	jsonResp(w, http.StatusOK, res)
+}
+
+// handleEmbeddingSearch returns the public nodes (or sources) nearest to a
+// text, or to an indexed node or source.
+func (a *API) handleEmbeddingSearch(w http.ResponseWriter, r *http.Request) {
+	if a.indexer == nil {
+		jsonError(w, "embeddings not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	var req struct {
+		Text      string  `json:"text"`
+		Kind      string  `json:"kind"`     // node (default) or source
+		OwnerID   string  `json:"owner_id"` // search around this node or source instead of text
+		K         int     `json:"k"`
+		MinScore  float64 `json:"min_score"`
+		RootsOnly bool    `json:"roots_only"`
+		Model     string  `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" && req.OwnerID == "" {
+		jsonError(w, "text or owner_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = "node"
+	}
+	if req.Kind != "node" && req.Kind != "source" {
+		jsonError(w, "kind must be 'node' or 'source'", http.StatusBadRequest)
+		return
+	}
+	if req.K <= 0 || req.K > 50 {
+		req.K = 10
+	}
+	if req.Text == "" {
+		if item, err := a.db.EmbeddingText(req.Kind, req.OwnerID); err != nil || item == nil {
+			jsonError(w, req.Kind+" not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	embedder, neighbors, err := a.indexer.Nearest(a.llmContext(r), req.Model, llm.NearestQuery{
+		Text:      req.Text,
+		OwnerType: req.Kind,
+		OwnerID:   req.OwnerID,
+		K:         req.K,
+		MinScore:  req.MinScore,
+		RootsOnly: req.RootsOnly,
+	})
+	if err != nil {
+		jsonError(w, "semantic search failed: "+err.Error(), embeddingErrorStatus(err))
+		return
+	}
+
+	results := make([]map[string]interface{}, 0, len(neighbors))
+	for _, n := range neighbors {
+		hit := map[string]interface{}{"owner_type": n.OwnerType, "owner_id": n.OwnerID, "score": n.Score}
+		if n.OwnerType == "node" {
+			if node, err := a.db.GetNode(n.OwnerID); err == nil {
+				hit["node"] = node
+			}
+		} else if src, err := a.db.GetSource(n.OwnerID); err == nil {
+			hit["source"] = src
+		}
+		results = append(results, hit)
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"embedder": embedder,
+		"results":  results,
+		"count":    len(results),
+	})
+}
+
+// embeddingErrorStatus is llmErrorStatus, with 503 when no provider can
+// embed (human-only instances with the local embedder disabled).
+func embeddingErrorStatus(err error) int {
+	if errors.Is(err, llm.ErrNoEmbedder) {
+		return http.StatusServiceUnavailable
+	}
+	return llmErrorStatus(err)
+}
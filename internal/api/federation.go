@@ -42,6 +42,9 @@ func (a *API) handleFederationIdentity(w http.ResponseWriter, r *http.Request) {
 		resp["public_key_id"] = a.fedConfig.PublicKeyID
 		resp["verify_signatures"] = a.fedConfig.VerifySignatures
 	}
+	if a.bundleKeys != nil {
+		resp["bundle_public_key"] = a.bundleKeys.PublicKey()
+	}
 
 	jsonResp(w, http.StatusOK, resp)
 }
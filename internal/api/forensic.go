@@ -108,13 +108,14 @@ func (a *API) handleForensicModel(w http.ResponseWriter, r *http.Request) {
 			COUNT(*) as total_calls,
 			COALESCE(SUM(tokens_in), 0) as total_tokens_in,
 			COALESCE(SUM(tokens_out), 0) as total_tokens_out,
+			COALESCE(SUM(cost_usd), 0) as total_cost_usd,
 			COALESCE(AVG(latency_ms), 0) as avg_latency,
 			COUNT(CASE WHEN error IS NOT NULL AND error != '' THEN 1 END) as error_count
 		FROM flow_steps WHERE model_id = ?`, modelID)
 
 	var totalCalls, totalTokensIn, totalTokensOut, errorCount int
-	var avgLatency float64
-	if err := row.Scan(&totalCalls, &totalTokensIn, &totalTokensOut, &avgLatency, &errorCount); err != nil {
+	var totalCost, avgLatency float64
+	if err := row.Scan(&totalCalls, &totalTokensIn, &totalTokensOut, &totalCost, &avgLatency, &errorCount); err != nil {
 		jsonError(w, "query failed", http.StatusInternalServerError)
 		return
 	}
@@ -165,6 +166,7 @@ func (a *API) handleForensicModel(w http.ResponseWriter, r *http.Request) {
 		"total_calls":     totalCalls,
 		"total_tokens_in": totalTokensIn,
 		"total_tokens_out": totalTokensOut,
+		"total_cost_usd":  totalCost,
 		"avg_latency_ms":  int(avgLatency),
 		"latency_percentiles": latencies,
 		"error_count":     errorCount,
@@ -0,0 +1,81 @@
+// CLAUDE:SUMMARY LLM client API — provider circuit-breaker health snapshot, operator breaker reset, quota/queue status
+package api
+
+import (
+	"net/http"
+
+	"github.com/hazyhaar/horostracker/internal/llm"
+)
+
+// RegisterLLMRoutes adds endpoints exposing the LLM client's runtime state.
+func (a *API) RegisterLLMRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/llm/health", a.handleLLMHealth)
+	mux.HandleFunc("POST /api/llm/health/{provider}/reset", a.handleResetBreaker)
+	mux.HandleFunc("GET /api/llm/limits", a.handleLLMLimits)
+}
+
+// handleLLMHealth returns the circuit-breaker state of every configured provider.
+func (a *API) handleLLMHealth(w http.ResponseWriter, r *http.Request) {
+	if a.llmClient == nil {
+		jsonResp(w, http.StatusOK, map[string]interface{}{
+			"mode":      llm.ModeLive,
+			"providers": []interface{}{},
+			"healthy":   0,
+			"total":     0,
+		})
+		return
+	}
+
+	health := a.llmClient.Health()
+	healthy := 0
+	for _, h := range health {
+		if h.State != llm.BreakerOpen {
+			healthy++
+		}
+	}
+
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"mode":      a.llmClient.Mode(),
+		"providers": health,
+		"healthy":   healthy,
+		"total":     len(health),
+	})
+}
+
+// handleResetBreaker forces a provider's circuit closed (operator only).
+func (a *API) handleResetBreaker(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+	if a.llmClient == nil {
+		jsonError(w, "LLM client not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	provider := r.PathValue("provider")
+	if !a.llmClient.ResetBreaker(provider) {
+		jsonError(w, "provider not found", http.StatusNotFound)
+		return
+	}
+
+	_ = a.flowsDB.InsertAuditLog("", "", "breaker_reset", map[string]string{
+		"provider": provider,
+		"reset_by": claims.UserID,
+	})
+
+	jsonResp(w, http.StatusOK, map[string]string{"provider": provider, "state": "closed"})
+}
+
+// handleLLMLimits returns configured quotas with current bucket levels and queue depth.
+func (a *API) handleLLMLimits(w http.ResponseWriter, r *http.Request) {
+	limits := []llm.LimitStatus{}
+	if a.llmClient != nil {
+		limits = a.llmClient.Limits()
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"limits": limits,
+		"count":  len(limits),
+	})
+}
@@ -0,0 +1,103 @@
+// CLAUDE:SUMMARY Metrics query API — p50/p95 latency, error rate and token volume per LLM provider/model, HTTP route and MCP tool, plus daily rollups
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RegisterMetricsRoutes adds read-only endpoints over metrics.db.
+func (a *API) RegisterMetricsRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/metrics/llm", a.handleMetricsLLM)
+	mux.HandleFunc("GET /api/metrics/http", a.handleMetricsHTTP)
+	mux.HandleFunc("GET /api/metrics/mcp", a.handleMetricsMCP)
+	mux.HandleFunc("GET /api/metrics/daily", a.handleMetricsDaily)
+}
+
+// metricsWindow reads ?hours= (default 24, max 30 days) and returns the
+// window start along with the hour count actually used.
+func metricsWindow(r *http.Request) (time.Time, int) {
+	hours := 24
+	if h, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && h > 0 {
+		hours = min(h, 720)
+	}
+	return time.Now().Add(-time.Duration(hours) * time.Hour), hours
+}
+
+func (a *API) handleMetricsLLM(w http.ResponseWriter, r *http.Request) {
+	if a.metricsDB == nil {
+		jsonError(w, "metrics database not available", http.StatusServiceUnavailable)
+		return
+	}
+	since, hours := metricsWindow(r)
+	stats, err := a.metricsDB.LLMStats(since)
+	if err != nil {
+		jsonError(w, "querying LLM metrics", http.StatusInternalServerError)
+		return
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"hours":  hours,
+		"models": stats,
+	})
+}
+
+func (a *API) handleMetricsHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.metricsDB == nil {
+		jsonError(w, "metrics database not available", http.StatusServiceUnavailable)
+		return
+	}
+	since, hours := metricsWindow(r)
+	stats, err := a.metricsDB.RouteStatsSince(since)
+	if err != nil {
+		jsonError(w, "querying HTTP metrics", http.StatusInternalServerError)
+		return
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"hours":  hours,
+		"routes": stats,
+	})
+}
+
+func (a *API) handleMetricsMCP(w http.ResponseWriter, r *http.Request) {
+	if a.metricsDB == nil {
+		jsonError(w, "metrics database not available", http.StatusServiceUnavailable)
+		return
+	}
+	since, hours := metricsWindow(r)
+	stats, err := a.metricsDB.ToolStatsSince(since)
+	if err != nil {
+		jsonError(w, "querying MCP metrics", http.StatusInternalServerError)
+		return
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"hours": hours,
+		"tools": stats,
+	})
+}
+
+// handleMetricsDaily returns the daily_stats rollup. The current day is
+// refreshed first so the answer does not lag behind the background job.
+func (a *API) handleMetricsDaily(w http.ResponseWriter, r *http.Request) {
+	if a.metricsDB == nil {
+		jsonError(w, "metrics database not available", http.StatusServiceUnavailable)
+		return
+	}
+	days := 7
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+		days = min(d, 365)
+	}
+	if err := a.metricsDB.RollupDailyStats(time.Now().UTC().Format("2006-01-02")); err != nil {
+		jsonError(w, "rolling up daily metrics", http.StatusInternalServerError)
+		return
+	}
+	stats, err := a.metricsDB.DailyStats(days, r.URL.Query().Get("prefix"))
+	if err != nil {
+		jsonError(w, "querying daily metrics", http.StatusInternalServerError)
+		return
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"days":  days,
+		"stats": stats,
+	})
+}
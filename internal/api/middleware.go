@@ -1,9 +1,10 @@
-// CLAUDE:SUMMARY HTTP middleware — security headers, no-cache static, IP-based rate limiter, CORS configuration
+// CLAUDE:SUMMARY HTTP middleware — security headers, no-cache static, IP-based rate limiter, CORS configuration, request metrics
 package api
 
 import (
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -83,3 +84,60 @@ func RateLimitMiddleware(rl *RateLimiter, next http.HandlerFunc) http.HandlerFun
 		next(w, r)
 	}
 }
+
+// RequestMetrics records every request to metrics.db http_requests. The
+// route pattern matched by the mux (e.g. "/api/node/{id}") is stored rather
+// than the raw path so that per-route aggregates stay bounded.
+func (a *API) RequestMetrics(next http.Handler) http.Handler {
+	if a.metricsDB == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sr, r)
+
+		route := "unmatched"
+		if r.Pattern != "" {
+			// Patterns may carry a method prefix ("GET /api/..."); the method is stored separately.
+			route = r.Pattern
+			if i := strings.IndexByte(route, ' '); i >= 0 {
+				route = route[i+1:]
+			}
+		}
+		userID := ""
+		if claims := a.auth.ExtractClaims(r); claims != nil {
+			userID = claims.UserID
+		}
+		a.metricsDB.RecordHTTPRequest(r.Method, route, sr.status, int(time.Since(start).Milliseconds()), userID)
+	})
+}
+
+// statusRecorder captures the response status. It forwards Flush so that
+// SSE handlers keep streaming through the metrics middleware.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if !sr.wroteHeader {
+		sr.status = code
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	sr.wroteHeader = true
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter { return sr.ResponseWriter }
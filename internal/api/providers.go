@@ -1,11 +1,13 @@
-// CLAUDE:SUMMARY Provider registry API — register, list, get, and heartbeat external LLM/resolution providers
+// CLAUDE:SUMMARY Provider registry API — register (providers and operators, endpoint address policy), operator approval, list, get, heartbeat and (de)activate external LLM/resolution providers
 package api
 
 import (
 	"encoding/json"
+	"log/slog"
 	"net/http"
 
 	"github.com/hazyhaar/horostracker/internal/db"
+	"github.com/hazyhaar/horostracker/internal/llm"
 )
 
 func (a *API) RegisterProviderRoutes(mux *http.ServeMux) {
@@ -13,9 +15,37 @@ func (a *API) RegisterProviderRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/providers", a.handleProviderList)
 	mux.HandleFunc("GET /api/providers/{id}", a.handleProviderGet)
 	mux.HandleFunc("POST /api/providers/{id}/heartbeat", a.handleProviderHeartbeat)
+	mux.HandleFunc("POST /api/providers/{id}/approve", a.handleProviderApprove)
+	mux.HandleFunc("PATCH /api/providers/{id}", a.handleProviderUpdate)
 }
 
+// syncRegistered brings the LLM client in line with the providers table
+// right away instead of waiting for the next periodic sync.
+func (a *API) syncRegistered() {
+	if a.registered == nil {
+		return
+	}
+	if err := a.registered.Sync(); err != nil {
+		slog.Warn("syncing registered providers", "error", err)
+	}
+}
+
+// handleProviderRegister stores a provider registered by a provider or an
+// operator. Its endpoint must pass the address policy; only an operator can
+// allow a loopback or private one. A provider's registration waits for an
+// operator's approval before it serves; an operator's is approved at once.
 func (a *API) handleProviderRegister(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	operator := a.isOperator(claims.UserID)
+	if !operator && a.getUserRole(claims.UserID) != "provider" {
+		jsonError(w, "only providers and operators can register providers", http.StatusForbidden)
+		return
+	}
+
 	var req struct {
 		Name              string   `json:"name"`
 		Endpoint          string   `json:"endpoint"`
@@ -23,6 +53,7 @@ func (a *API) handleProviderRegister(w http.ResponseWriter, r *http.Request) {
 		Models            []string `json:"models"`
 		ResolutionSpace   bool     `json:"resolution_space"`
 		ResolutionCriteria json.RawMessage `json:"resolution_criteria"`
+		AllowPrivate      bool     `json:"allow_private"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -32,6 +63,18 @@ func (a *API) handleProviderRegister(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "name is required", http.StatusBadRequest)
 		return
 	}
+	if req.Name == llm.LocalProvider {
+		jsonError(w, "name is reserved for the built-in embedder", http.StatusBadRequest)
+		return
+	}
+	if req.AllowPrivate && !operator {
+		jsonError(w, "only an operator can allow a private endpoint", http.StatusForbidden)
+		return
+	}
+	if err := llm.CheckEndpoint(r.Context(), req.Endpoint, req.AllowPrivate); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if req.APIStyle == "" {
 		req.APIStyle = "openai"
 	}
@@ -48,22 +91,72 @@ func (a *API) handleProviderRegister(w http.ResponseWriter, r *http.Request) {
 		resSpace = 1
 	}
 
-	_, err := a.db.Exec(`INSERT INTO providers (id, name, endpoint, api_style, models, resolution_space, resolution_criteria)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		id, req.Name, req.Endpoint, req.APIStyle, string(modelsJSON), resSpace, criteriaStr)
+	_, err := a.db.Exec(`INSERT INTO providers (id, name, endpoint, api_style, models, resolution_space, resolution_criteria, registered_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, req.Name, req.Endpoint, req.APIStyle, string(modelsJSON), resSpace, criteriaStr, claims.UserID)
 	if err != nil {
 		jsonError(w, "registration failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if operator {
+		if _, err := a.db.ApproveProvider(id, claims.UserID, req.AllowPrivate); err != nil {
+			jsonError(w, "approval failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	a.syncRegistered()
 
 	jsonResp(w, http.StatusCreated, map[string]interface{}{
 		"id":       id,
 		"name":     req.Name,
 		"endpoint": req.Endpoint,
 		"models":   req.Models,
+		"approved": operator,
 	})
 }
 
+// handleProviderApprove lets an operator approve a registered provider,
+// which then becomes an LLM backend. allow_private admits an endpoint on a
+// loopback, private or link-local address.
+func (a *API) handleProviderApprove(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if !a.isOperator(claims.UserID) {
+		jsonError(w, "operator role required", http.StatusForbidden)
+		return
+	}
+	id := r.PathValue("id")
+
+	var req struct {
+		AllowPrivate bool `json:"allow_private"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	var endpoint string
+	if err := a.db.QueryRow(`SELECT COALESCE(endpoint,'') FROM providers WHERE id = ?`, id).Scan(&endpoint); err != nil {
+		jsonError(w, "provider not found", http.StatusNotFound)
+		return
+	}
+	if err := llm.CheckEndpoint(r.Context(), endpoint, req.AllowPrivate); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := a.db.ApproveProvider(id, claims.UserID, req.AllowPrivate); err != nil {
+		jsonError(w, "approval failed", http.StatusInternalServerError)
+		return
+	}
+	a.syncRegistered()
+
+	jsonResp(w, http.StatusOK, map[string]interface{}{"id": id, "approved": true, "allow_private": req.AllowPrivate})
+}
+
 func (a *API) handleProviderList(w http.ResponseWriter, r *http.Request) {
 	result := make(map[string][]string)
 
@@ -101,13 +194,14 @@ func (a *API) handleProviderGet(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
 	row := a.db.QueryRow(`SELECT id, name, endpoint, api_style, models, capabilities, is_active,
-		resolution_space, resolution_criteria, COALESCE(last_seen_at,''), created_at
+		resolution_space, resolution_criteria, approved_at IS NOT NULL, COALESCE(allow_private,0),
+		COALESCE(last_seen_at,''), created_at
 		FROM providers WHERE id = ?`, id)
 
 	var name, endpoint, apiStyle, models, capabilities, criteria, lastSeen, createdAt string
-	var active, resSpace int
+	var active, resSpace, approved, allowPrivate int
 	if err := row.Scan(&name, &name, &endpoint, &apiStyle, &models, &capabilities, &active,
-		&resSpace, &criteria, &lastSeen, &createdAt); err != nil {
+		&resSpace, &criteria, &approved, &allowPrivate, &lastSeen, &createdAt); err != nil {
 		jsonError(w, "provider not found", http.StatusNotFound)
 		return
 	}
@@ -120,10 +214,13 @@ func (a *API) handleProviderGet(w http.ResponseWriter, r *http.Request) {
 		"models":             models,
 		"capabilities":       capabilities,
 		"is_active":          active == 1,
+		"approved":           approved == 1,
+		"allow_private":      allowPrivate == 1,
 		"resolution_space":   resSpace == 1,
 		"resolution_criteria": criteria,
 		"last_seen_at":       lastSeen,
 		"created_at":         createdAt,
+		"live":               a.llmClient != nil && a.llmClient.RegisteredID(name) == id,
 	})
 }
 
@@ -140,6 +237,43 @@ func (a *API) handleProviderHeartbeat(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "provider not found", http.StatusNotFound)
 		return
 	}
+	a.syncRegistered()
 
 	jsonResp(w, http.StatusOK, map[string]string{"status": "ok"})
 }
+
+// handleProviderUpdate activates or deactivates a provider (operator or
+// the user who registered it). A deactivated provider stops serving at once.
+func (a *API) handleProviderUpdate(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	id := r.PathValue("id")
+
+	var registeredBy string
+	if err := a.db.QueryRow(`SELECT COALESCE(registered_by,'') FROM providers WHERE id = ?`, id).Scan(&registeredBy); err != nil {
+		jsonError(w, "provider not found", http.StatusNotFound)
+		return
+	}
+	if registeredBy != claims.UserID && !a.isOperator(claims.UserID) {
+		jsonError(w, "only the registrant or an operator can update this provider", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		IsActive *bool `json:"is_active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IsActive == nil {
+		jsonError(w, "is_active is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := a.db.SetProviderActive(id, *req.IsActive); err != nil {
+		jsonError(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	a.syncRegistered()
+
+	jsonResp(w, http.StatusOK, map[string]interface{}{"id": id, "is_active": *req.IsActive})
+}
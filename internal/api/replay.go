@@ -2,6 +2,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
@@ -39,9 +40,9 @@ func (a *API) handleReplayStep(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := a.replayEngine.ReplayStep(r.Context(), stepID, req.Provider, req.ModelID)
+	result, err := a.replayEngine.ReplayStep(a.llmContext(r), stepID, req.Provider, req.ModelID)
 	if err != nil {
-		jsonError(w, "replay failed: "+err.Error(), http.StatusInternalServerError)
+		jsonError(w, "replay failed: "+err.Error(), llmErrorStatus(err))
 		return
 	}
 
@@ -71,9 +72,10 @@ func (a *API) handleReplayBulk(w http.ResponseWriter, r *http.Request) {
 
 	batchID := db.NewID()
 
-	// Run async
+	// Run async, detached from the request but still billed to its caller
+	ctx := context.WithoutCancel(a.llmContext(r))
 	go func() {
-		_, _ = a.replayEngine.ReplayBulk(r.Context(), batchID, req.FilterModel, req.Provider, req.ReplayModel, req.FilterTag)
+		_, _ = a.replayEngine.ReplayBulk(ctx, batchID, req.FilterModel, req.Provider, req.ReplayModel, req.FilterTag)
 	}()
 
 	jsonResp(w, http.StatusAccepted, map[string]string{
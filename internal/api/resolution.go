@@ -73,11 +73,21 @@ func (a *API) handleGenerateResolution(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate resolution
-	result, err := a.resEngine.GenerateResolution(r.Context(), tree, req.Provider, req.Model)
+	// Generate resolution (SSE token stream if requested)
+	var sse *sseWriter
+	var result *llm.ResolutionResult
+	if wantsStream(r) {
+		if sse = newSSEWriter(w); sse == nil {
+			jsonError(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		result, err = a.resEngine.StreamResolution(a.llmContext(r), tree, req.Provider, req.Model, sse.Delta)
+	} else {
+		result, err = a.resEngine.GenerateResolution(a.llmContext(r), tree, req.Provider, req.Model)
+	}
 	if err != nil {
 		slog.Error("generating resolution", "error", err)
-		jsonError(w, "resolution generation failed: "+err.Error(), http.StatusInternalServerError)
+		streamError(w, sse, "resolution generation failed: "+err.Error(), llmErrorStatus(err))
 		return
 	}
 
@@ -94,11 +104,13 @@ func (a *API) handleGenerateResolution(w http.ResponseWriter, r *http.Request) {
 			"tokens_in":     result.TokensIn,
 			"tokens_out":    result.TokensOut,
 			"latency_ms":    result.LatencyMs,
+			"strategy":      result.Strategy,
+			"flow_id":       result.FlowID,
 		}),
 	})
 	if err != nil {
 		slog.Error("storing resolution", "error", err)
-		jsonError(w, "internal error", http.StatusInternalServerError)
+		streamError(w, sse, "internal error", http.StatusInternalServerError)
 		return
 	}
 
@@ -109,7 +121,7 @@ func (a *API) handleGenerateResolution(w http.ResponseWriter, r *http.Request) {
 		DO UPDATE SET content=excluded.content, tokens_in=excluded.tokens_in, tokens_out=excluded.tokens_out, latency_ms=excluded.latency_ms, updated_at=datetime('now')`,
 		db.NewID(), nodeID, result.Provider, result.Model, result.Content, result.TokensIn, result.TokensOut, result.LatencyMs)
 
-	jsonResp(w, http.StatusCreated, map[string]interface{}{
+	streamResp(w, sse, http.StatusCreated, map[string]interface{}{
 		"resolution": resNode,
 		"generation": result,
 	})
@@ -184,11 +196,21 @@ func (a *API) handleRenderResolution(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Render
-	result, err := a.resEngine.RenderResolution(r.Context(), resNode.Body, req.Format, req.Provider, req.Model)
+	// Render (SSE token stream if requested)
+	var sse *sseWriter
+	var result *llm.RenderResult
+	if wantsStream(r) {
+		if sse = newSSEWriter(w); sse == nil {
+			jsonError(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		result, err = a.resEngine.StreamRender(a.llmContext(r), resNode.Body, req.Format, req.Provider, req.Model, sse.Delta)
+	} else {
+		result, err = a.resEngine.RenderResolution(a.llmContext(r), resNode.Body, req.Format, req.Provider, req.Model)
+	}
 	if err != nil {
 		slog.Error("rendering resolution", "error", err)
-		jsonError(w, "render failed: "+err.Error(), http.StatusInternalServerError)
+		streamError(w, sse, "render failed: "+err.Error(), llmErrorStatus(err))
 		return
 	}
 
@@ -197,7 +219,7 @@ func (a *API) handleRenderResolution(w http.ResponseWriter, r *http.Request) {
 	_, _ = a.db.Exec(`INSERT INTO renders (id, resolution_id, format, model_id, content, fidelity_score)
 		VALUES (?, ?, ?, ?, ?, NULL)`, renderID, resolutionID, req.Format, result.Model, result.Content)
 
-	jsonResp(w, http.StatusCreated, map[string]interface{}{
+	streamResp(w, sse, http.StatusCreated, map[string]interface{}{
 		"render_id": renderID,
 		"render":    result,
 	})
@@ -394,7 +416,7 @@ func (a *API) handleBatchResolution(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		result, err := a.resEngine.GenerateResolution(r.Context(), tree, req.Provider, req.Model)
+		result, err := a.resEngine.GenerateResolution(a.llmContext(r), tree, req.Provider, req.Model)
 		if err != nil {
 			failed++
 			results = append(results, map[string]interface{}{
@@ -418,6 +440,8 @@ func (a *API) handleBatchResolution(w http.ResponseWriter, r *http.Request) {
 				"tokens_in":     result.TokensIn,
 				"tokens_out":    result.TokensOut,
 				"latency_ms":    result.LatencyMs,
+				"strategy":      result.Strategy,
+				"flow_id":       result.FlowID,
 			}),
 		})
 		if storeErr != nil {
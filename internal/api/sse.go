@@ -0,0 +1,96 @@
+// CLAUDE:SUMMARY Server-Sent Events helpers — stream opt-in detection, lazy SSE writer for LLM token deltas and final payloads
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// wantsStream reports whether the client asked for an SSE response,
+// either via ?stream=1|true or an Accept: text/event-stream header.
+func wantsStream(r *http.Request) bool {
+	switch r.URL.Query().Get("stream") {
+	case "1", "true":
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// sseWriter writes text/event-stream events. Headers are only sent with the
+// first event, so handlers can still fall back to jsonError before that.
+// Safe for concurrent use (dispatch fans out to several goroutines).
+type sseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+// newSSEWriter returns nil if the ResponseWriter cannot flush.
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+	return &sseWriter{w: w, flusher: f}
+}
+
+// Send writes one event with a JSON-encoded data payload and flushes it.
+func (s *sseWriter) Send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		h := s.w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Connection", "keep-alive")
+		h.Set("X-Accel-Buffering", "no")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Delta is an llm.StreamFunc that forwards a content delta as a "delta" event.
+func (s *sseWriter) Delta(delta string) error {
+	return s.Send("delta", map[string]string{"content": delta})
+}
+
+// Started reports whether the SSE response headers have been sent.
+func (s *sseWriter) Started() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started
+}
+
+// streamError reports an error as an "error" event once the stream has
+// started, or as a regular JSON error otherwise.
+func streamError(w http.ResponseWriter, sse *sseWriter, msg string, status int) {
+	if sse != nil && sse.Started() {
+		_ = sse.Send("error", map[string]string{"error": msg})
+		return
+	}
+	jsonError(w, msg, status)
+}
+
+// streamResp writes the final payload as a "done" event when streaming,
+// or as a regular JSON response otherwise.
+func streamResp(w http.ResponseWriter, sse *sseWriter, status int, data interface{}) {
+	if sse != nil {
+		_ = sse.Send("done", data)
+		return
+	}
+	jsonResp(w, status, data)
+}
@@ -0,0 +1,164 @@
+// CLAUDE:SUMMARY Workflow bundle API — signed JSON/TOML export of a workflow version and verified import as a new workflow pending validation
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+	"github.com/hazyhaar/horostracker/internal/llm"
+)
+
+// SetBundleKeys sets the keys workflow bundles are signed and verified with.
+func (a *API) SetBundleKeys(k *llm.BundleKeys) {
+	a.bundleKeys = k
+}
+
+// handleWorkflowResource serves GET /api/workflows/{id}/{resource} and
+// /api/workflows/{id}/{resource}/{sub}: bundle, triggers, versions,
+// versions/diff and versions/{version}. A pattern per resource would
+// overlap /api/workflows/runs/{runId} and /api/workflows/runs/{runId}/steps;
+// these are less specific, so runs and batches keep their routes.
+func (a *API) handleWorkflowResource(w http.ResponseWriter, r *http.Request) {
+	sub := r.PathValue("sub")
+	switch r.PathValue("resource") {
+	case "bundle":
+		if sub == "" {
+			a.handleExportBundle(w, r)
+			return
+		}
+	case "triggers":
+		if sub == "" {
+			a.handleListTriggers(w, r)
+			return
+		}
+	case "versions":
+		switch sub {
+		case "":
+			a.handleListVersions(w, r)
+		case "diff":
+			a.handleDiffVersions(w, r)
+		default:
+			r.SetPathValue("version", sub)
+			a.handleGetVersion(w, r)
+		}
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// handleExportBundle returns a workflow version as a signed bundle: the
+// runnable version, or ?version=N. ?format=toml returns it as TOML.
+func (a *API) handleExportBundle(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if a.bundleKeys == nil {
+		jsonError(w, "workflow bundles not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	wfID := r.PathValue("id")
+	var wf *db.Workflow
+	var err error
+	if s := r.URL.Query().Get("version"); s != "" {
+		version, convErr := strconv.Atoi(s)
+		if convErr != nil || version < 1 {
+			jsonError(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		wf, err = a.flowsDB.GetWorkflowVersion(wfID, version)
+	} else {
+		wf, err = a.flowsDB.GetRunnableWorkflow(wfID)
+	}
+	if err != nil {
+		jsonError(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	if wf.OwnerID != claims.UserID && !a.isOperator(claims.UserID) {
+		jsonError(w, "not the workflow owner", http.StatusForbidden)
+		return
+	}
+
+	var source string
+	if a.instConfig != nil {
+		source = a.instConfig.ID
+	}
+	bundle, err := llm.ExportBundle(a.flowsDB, wf, source)
+	if err != nil {
+		jsonError(w, "exporting workflow: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := a.bundleKeys.Sign(bundle); err != nil {
+		jsonError(w, "signing bundle: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	asTOML := r.URL.Query().Get("format") == "toml"
+	out, err := bundle.Encode(asTOML)
+	if err != nil {
+		jsonError(w, "encoding bundle: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if asTOML {
+		w.Header().Set("Content-Type", "application/toml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// handleImportBundle recreates the bundle in the request body, JSON or
+// TOML, as a new workflow owned by the caller and pending validation.
+// ?name= imports it under another name.
+func (a *API) handleImportBundle(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	role := a.getUserRole(claims.UserID)
+	if role == "user" || role == "anon" {
+		jsonError(w, "insufficient permissions to create workflows", http.StatusForbidden)
+		return
+	}
+	if a.bundleKeys == nil {
+		jsonError(w, "workflow bundles not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		jsonError(w, "bundle too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	bundle, err := llm.ParseBundle(data)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	imported, err := llm.ImportBundle(a.flowsDB, a.bundleKeys, bundle, claims.UserID, role, r.URL.Query().Get("name"))
+	if err != nil {
+		jsonError(w, err.Error(), bundleStatus(err))
+		return
+	}
+	jsonResp(w, http.StatusCreated, imported)
+}
+
+func bundleStatus(err error) int {
+	switch {
+	case errors.Is(err, llm.ErrInvalidBundle):
+		return http.StatusBadRequest
+	case errors.Is(err, llm.ErrUntrustedBundle), errors.Is(err, llm.ErrBundleDenied):
+		return http.StatusForbidden
+	case errors.Is(err, llm.ErrBundleConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
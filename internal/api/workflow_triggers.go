@@ -0,0 +1,378 @@
+// CLAUDE:SUMMARY Workflow trigger API — cron and domain-event triggers on active workflows (create, list, update, delete, fire by hand) and their firing history
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+	"github.com/hazyhaar/horostracker/internal/llm"
+)
+
+// SetTriggerRunner sets the runner that fires workflow triggers.
+func (a *API) SetTriggerRunner(tr *llm.TriggerRunner) {
+	a.triggers = tr
+}
+
+// fireTrigger reports a domain event to the workflow triggers.
+func (a *API) fireTrigger(eventType, nodeID string) {
+	if a.triggers != nil {
+		a.triggers.Fire(llm.TriggerEvent{Type: eventType, NodeID: nodeID})
+	}
+}
+
+// triggerRequest is the body of trigger creation and update. An update
+// decodes it over the trigger's current values, so absent fields keep them.
+type triggerRequest struct {
+	Name          string `json:"name"`
+	TriggerType   string `json:"trigger_type"`
+	Schedule      string `json:"schedule"`
+	Tag           string `json:"tag"`
+	NodeID        string `json:"node_id"`
+	Body          string `json:"body"`
+	PrePrompt     string `json:"pre_prompt"`
+	RunAs         string `json:"run_as"`
+	RateLimit     int    `json:"rate_limit"`
+	RateWindowSec int    `json:"rate_window_sec"`
+	Enabled       *bool  `json:"enabled"`
+}
+
+// applyTrigger validates req for a trigger created or updated by userID
+// and copies it into t. It returns the HTTP status and message of a
+// rejected request, or 0.
+func (a *API) applyTrigger(t *db.WorkflowTrigger, req triggerRequest, userID string) (int, string) {
+	if !db.TriggerTypes[req.TriggerType] {
+		return http.StatusBadRequest, "trigger_type must be cron, node_created, temperature_hot, source_added or challenge_completed"
+	}
+	if req.Name == "" {
+		req.Name = req.TriggerType
+	}
+	t.NextRunAt = nil
+	if req.TriggerType == "cron" {
+		if req.Tag != "" {
+			return http.StatusBadRequest, "tag only applies to event triggers"
+		}
+		sched, err := llm.ParseCron(req.Schedule)
+		if err != nil {
+			return http.StatusBadRequest, "schedule: " + err.Error()
+		}
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			return http.StatusBadRequest, "schedule is never due"
+		}
+		t.NextRunAt = &next
+		if req.NodeID != "" {
+			if _, err := a.db.GetNode(req.NodeID); err != nil {
+				return http.StatusBadRequest, "node_id: node not found"
+			}
+		}
+	} else if req.Schedule != "" || req.NodeID != "" || req.Body != "" {
+		return http.StatusBadRequest, "schedule, node_id and body only apply to cron triggers"
+	}
+
+	if req.RateLimit == 0 {
+		req.RateLimit = 10
+	}
+	if req.RateWindowSec == 0 {
+		req.RateWindowSec = 3600
+	}
+	if req.RateLimit < 1 || req.RateLimit > 1000 || req.RateWindowSec < 1 {
+		return http.StatusBadRequest, "rate_limit must be 1-1000 runs per rate_window_sec seconds"
+	}
+
+	if req.RunAs == "" {
+		req.RunAs = userID
+	}
+	if req.RunAs != userID && !a.isOperator(userID) {
+		return http.StatusForbidden, "only operators can run triggers as another user"
+	}
+	if _, err := a.db.GetUserByID(req.RunAs); err != nil {
+		return http.StatusBadRequest, "run_as: user not found"
+	}
+
+	t.Name = req.Name
+	t.TriggerType = req.TriggerType
+	t.Schedule = req.Schedule
+	t.Tag = req.Tag
+	t.NodeID = req.NodeID
+	t.Body = req.Body
+	t.PrePrompt = req.PrePrompt
+	t.RunAs = req.RunAs
+	t.RateLimit = req.RateLimit
+	t.RateWindowSec = req.RateWindowSec
+	t.Enabled = req.Enabled == nil || *req.Enabled
+	return 0, ""
+}
+
+// triggerAccess returns the trigger of the request's {id} if userID owns
+// its workflow or is an operator, or writes the error.
+func (a *API) triggerAccess(w http.ResponseWriter, r *http.Request, userID string) *db.WorkflowTrigger {
+	t, err := a.flowsDB.GetTrigger(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "trigger not found", http.StatusNotFound)
+		return nil
+	}
+	wf, err := a.flowsDB.GetWorkflow(t.WorkflowID)
+	if err != nil {
+		jsonError(w, "workflow not found", http.StatusNotFound)
+		return nil
+	}
+	if wf.OwnerID != userID && !a.isOperator(userID) {
+		jsonError(w, "not the workflow owner", http.StatusForbidden)
+		return nil
+	}
+	return t
+}
+
+func (a *API) handleCreateTrigger(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	wfID := r.PathValue("id")
+	wf, err := a.flowsDB.GetWorkflow(wfID)
+	if err != nil {
+		jsonError(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	if wf.OwnerID != claims.UserID && !a.isOperator(claims.UserID) {
+		jsonError(w, "not the workflow owner", http.StatusForbidden)
+		return
+	}
+	if wf.ActiveVersion == nil {
+		jsonError(w, "triggers can only be attached to active workflows", http.StatusBadRequest)
+		return
+	}
+
+	var req triggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	t := &db.WorkflowTrigger{
+		TriggerID:  db.NewID(),
+		WorkflowID: wfID,
+		CreatedBy:  claims.UserID,
+	}
+	if status, msg := a.applyTrigger(t, req, claims.UserID); status != 0 {
+		jsonError(w, msg, status)
+		return
+	}
+	if err := a.flowsDB.CreateTrigger(t); err != nil {
+		jsonError(w, "creating trigger: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = a.flowsDB.InsertAuditLog("", "", "trigger_created", map[string]string{
+		"workflow_id":  wfID,
+		"trigger_id":   t.TriggerID,
+		"trigger_type": t.TriggerType,
+		"run_as":       t.RunAs,
+		"created_by":   claims.UserID,
+	})
+
+	created, _ := a.flowsDB.GetTrigger(t.TriggerID)
+	jsonResp(w, http.StatusCreated, map[string]interface{}{"trigger": created})
+}
+
+// handleListTriggers serves GET /api/workflows/{id}/triggers.
+func (a *API) handleListTriggers(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	wf, err := a.flowsDB.GetWorkflow(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	if wf.OwnerID != claims.UserID && !a.isOperator(claims.UserID) {
+		jsonError(w, "not the workflow owner", http.StatusForbidden)
+		return
+	}
+	triggers, err := a.flowsDB.ListTriggers(wf.WorkflowID)
+	if err != nil {
+		jsonError(w, "listing triggers: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if triggers == nil {
+		triggers = []db.WorkflowTrigger{}
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{"triggers": triggers})
+}
+
+func (a *API) handleListAllTriggers(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+	triggers, err := a.flowsDB.ListTriggers("")
+	if err != nil {
+		jsonError(w, "listing triggers: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if triggers == nil {
+		triggers = []db.WorkflowTrigger{}
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{"triggers": triggers})
+}
+
+func (a *API) handleGetTrigger(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if t := a.triggerAccess(w, r, claims.UserID); t != nil {
+		jsonResp(w, http.StatusOK, t)
+	}
+}
+
+func (a *API) handleUpdateTrigger(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	t := a.triggerAccess(w, r, claims.UserID)
+	if t == nil {
+		return
+	}
+
+	enabled := t.Enabled
+	req := triggerRequest{
+		Name:          t.Name,
+		TriggerType:   t.TriggerType,
+		Schedule:      t.Schedule,
+		Tag:           t.Tag,
+		NodeID:        t.NodeID,
+		Body:          t.Body,
+		PrePrompt:     t.PrePrompt,
+		RunAs:         t.RunAs,
+		RateLimit:     t.RateLimit,
+		RateWindowSec: t.RateWindowSec,
+		Enabled:       &enabled,
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.TriggerType != t.TriggerType {
+		jsonError(w, "trigger_type cannot be changed", http.StatusBadRequest)
+		return
+	}
+	// Keeping another user as run-as takes the same rights as choosing them.
+	if status, msg := a.applyTrigger(t, req, claims.UserID); status != 0 {
+		jsonError(w, msg, status)
+		return
+	}
+	if err := a.flowsDB.UpdateTrigger(t); err != nil {
+		jsonError(w, "updating trigger: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	updated, _ := a.flowsDB.GetTrigger(t.TriggerID)
+	jsonResp(w, http.StatusOK, updated)
+}
+
+func (a *API) handleDeleteTrigger(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	t := a.triggerAccess(w, r, claims.UserID)
+	if t == nil {
+		return
+	}
+	if err := a.flowsDB.DeleteTrigger(t.TriggerID); err != nil {
+		jsonError(w, "deleting trigger: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = a.flowsDB.InsertAuditLog("", "", "trigger_deleted", map[string]string{
+		"workflow_id": t.WorkflowID,
+		"trigger_id":  t.TriggerID,
+		"deleted_by":  claims.UserID,
+	})
+	jsonResp(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// handleFireTrigger fires a trigger by hand, on the node_id and body of the
+// request (a cron trigger's own by default), under its rate limit. It
+// answers 202 with the run started, or with the firing's status: 429 when
+// rate limited, 409 when the workflow is not active.
+func (a *API) handleFireTrigger(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	t := a.triggerAccess(w, r, claims.UserID)
+	if t == nil {
+		return
+	}
+	if a.triggers == nil {
+		jsonError(w, "workflow triggers not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	req := struct {
+		NodeID string `json:"node_id"`
+		Body   string `json:"body"`
+	}{NodeID: t.NodeID, Body: t.Body}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.NodeID != "" {
+		if _, err := a.db.GetNode(req.NodeID); err != nil {
+			jsonError(w, "node not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	// The run outlives the request.
+	f := a.triggers.FireTrigger(context.WithoutCancel(a.llmContext(r)), *t, "manual", req.NodeID, req.Body)
+	status := http.StatusAccepted
+	switch f.Status {
+	case "rate_limited":
+		status = http.StatusTooManyRequests
+	case "skipped":
+		status = http.StatusConflict
+	case "failed":
+		status = http.StatusInternalServerError
+	}
+	jsonResp(w, status, f)
+}
+
+func (a *API) handleListTriggerFires(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	t := a.triggerAccess(w, r, claims.UserID)
+	if t == nil {
+		return
+	}
+	fires, err := a.flowsDB.ListTriggerFires(t.TriggerID, 100)
+	if err != nil {
+		jsonError(w, "listing trigger fires: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fires == nil {
+		fires = []db.TriggerFire{}
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{"trigger_id": t.TriggerID, "fires": fires})
+}
@@ -1,9 +1,13 @@
-// CLAUDE:SUMMARY Workflow API — VACF workflow CRUD, step management, submission/activation lifecycle, batch runs, model discovery, criteria lists
+// CLAUDE:SUMMARY Workflow API — VACF workflow CRUD, step management, submission/activation lifecycle, version history and diffs, bundle export/import, triggers, batch runs, model discovery, criteria lists
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/hazyhaar/horostracker/internal/db"
@@ -30,20 +34,39 @@ func (a *API) RegisterWorkflowRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("POST /api/workflows/{id}/steps", a.handleCreateStep)
 	mux.HandleFunc("PUT /api/workflows/{id}/steps/{stepId}", a.handleUpdateStep)
 	mux.HandleFunc("DELETE /api/workflows/{id}/steps/{stepId}", a.handleDeleteStep)
+	mux.HandleFunc("GET /api/workflows/{id}/steps/plan", a.handleStepPlan)
 
 	mux.HandleFunc("POST /api/workflows/{id}/submit", a.handleSubmitWorkflow)
 	mux.HandleFunc("POST /api/workflows/{id}/activate", a.handleActivateWorkflow)
 	mux.HandleFunc("POST /api/workflows/{id}/archive", a.handleArchiveWorkflow)
 
+	// Versions, bundle, triggers: see handleWorkflowResource.
+	mux.HandleFunc("GET /api/workflows/{id}/{resource}", a.handleWorkflowResource)
+	mux.HandleFunc("GET /api/workflows/{id}/{resource}/{sub}", a.handleWorkflowResource)
+	mux.HandleFunc("POST /api/workflows/import", a.handleImportBundle)
+
+	mux.HandleFunc("POST /api/workflows/{id}/triggers", a.handleCreateTrigger)
+	mux.HandleFunc("GET /api/workflow-triggers", a.handleListAllTriggers)
+	mux.HandleFunc("GET /api/workflow-triggers/{id}", a.handleGetTrigger)
+	mux.HandleFunc("PUT /api/workflow-triggers/{id}", a.handleUpdateTrigger)
+	mux.HandleFunc("DELETE /api/workflow-triggers/{id}", a.handleDeleteTrigger)
+	mux.HandleFunc("POST /api/workflow-triggers/{id}/fire", a.handleFireTrigger)
+	mux.HandleFunc("GET /api/workflow-triggers/{id}/fires", a.handleListTriggerFires)
+
 	mux.HandleFunc("POST /api/workflows/{id}/run", a.handleRunWorkflow)
 	mux.HandleFunc("GET /api/workflows/runs/{runId}", a.handleGetWorkflowRun)
 	mux.HandleFunc("GET /api/workflows/runs/{runId}/steps", a.handleGetStepRuns)
+	mux.HandleFunc("POST /api/workflows/runs/{runId}/resume", a.handleResumeRun)
+	mux.HandleFunc("POST /api/workflows/runs/{runId}/retry-from/{stepId}", a.handleRetryFrom)
+	mux.HandleFunc("POST /api/workflows/runs/{runId}/cancel", a.handleCancelRun)
 
 	mux.HandleFunc("POST /api/workflows/batch", a.handleBatchRun)
 	mux.HandleFunc("GET /api/workflows/batch/{batchId}", a.handleGetBatch)
+	mux.HandleFunc("POST /api/workflows/batch/{batchId}/cancel", a.handleCancelBatch)
 
 	mux.HandleFunc("GET /api/models", a.handleListModels)
 	mux.HandleFunc("POST /api/models/discover", a.handleDiscoverModels)
+	mux.HandleFunc("POST /api/models/refresh", a.handleRefreshModels)
 
 	mux.HandleFunc("GET /api/criteria-lists", a.handleListCriteriaLists)
 	mux.HandleFunc("POST /api/criteria-lists", a.handleCreateCriteriaList)
@@ -179,8 +202,8 @@ func (a *API) handleUpdateWorkflow(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "workflow not found", http.StatusNotFound)
 		return
 	}
-	if wf.Status != "draft" {
-		jsonError(w, "only draft workflows can be modified", http.StatusBadRequest)
+	if wf.Status == "archived" {
+		jsonError(w, "archived workflows cannot be modified", http.StatusBadRequest)
 		return
 	}
 	if wf.OwnerID != claims.UserID && !a.isOperator(claims.UserID) {
@@ -201,8 +224,10 @@ func (a *API) handleUpdateWorkflow(w http.ResponseWriter, r *http.Request) {
 	if req.Name == "" {
 		req.Name = wf.Name
 	}
-	if err := a.flowsDB.UpdateWorkflow(wfID, req.Name, req.Description, req.PrePromptTemplate); err != nil {
-		jsonError(w, "updating workflow: "+err.Error(), http.StatusInternalServerError)
+	if err := a.editDraft(wf, claims.UserID, func(d *db.Draft) error {
+		return d.Update(req.Name, req.Description, req.PrePromptTemplate)
+	}); err != nil {
+		draftEditError(w, "updating workflow", err)
 		return
 	}
 
@@ -225,8 +250,8 @@ func (a *API) handleCreateStep(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "workflow not found", http.StatusNotFound)
 		return
 	}
-	if wf.Status != "draft" {
-		jsonError(w, "only draft workflows can be modified", http.StatusBadRequest)
+	if wf.Status == "archived" {
+		jsonError(w, "archived workflows cannot be modified", http.StatusBadRequest)
 		return
 	}
 	role := a.getUserRole(claims.UserID)
@@ -236,18 +261,19 @@ func (a *API) handleCreateStep(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		StepOrder      int     `json:"step_order"`
-		StepName       string  `json:"step_name"`
-		StepType       string  `json:"step_type"`
-		Provider       string  `json:"provider"`
-		Model          string  `json:"model"`
-		PromptTemplate string  `json:"prompt_template"`
-		SystemPrompt   string  `json:"system_prompt"`
-		ConfigJSON     string  `json:"config_json"`
-		CriteriaListID *string `json:"criteria_list_id"`
-		TimeoutMs      int     `json:"timeout_ms"`
-		RetryMax       int     `json:"retry_max"`
-		FanGroup       *string `json:"fan_group"`
+		StepOrder      int      `json:"step_order"`
+		StepName       string   `json:"step_name"`
+		StepType       string   `json:"step_type"`
+		Provider       string   `json:"provider"`
+		Model          string   `json:"model"`
+		PromptTemplate string   `json:"prompt_template"`
+		SystemPrompt   string   `json:"system_prompt"`
+		ConfigJSON     string   `json:"config_json"`
+		CriteriaListID *string  `json:"criteria_list_id"`
+		TimeoutMs      int      `json:"timeout_ms"`
+		RetryMax       int      `json:"retry_max"`
+		FanGroup       *string  `json:"fan_group"`
+		DependsOn      []string `json:"depends_on"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -302,10 +328,34 @@ func (a *API) handleCreateStep(w http.ResponseWriter, r *http.Request) {
 		TimeoutMs:      req.TimeoutMs,
 		RetryMax:       req.RetryMax,
 		FanGroup:       req.FanGroup,
+		DependsOn:      req.DependsOn,
+	}
+
+	if err := llm.CheckStepModel(a.flowsDB, *step); err != nil {
+		jsonError(w, err.Error(), stepModelStatus(err))
+		return
+	}
+	if err := llm.CheckStepDependencies(*step, wf.Steps); err != nil {
+		jsonError(w, "depends_on: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := llm.CheckStepTemplates(*step, wf.Steps); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := llm.StepSchema(*step); err != nil {
+		jsonError(w, "config_json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := llm.CheckNodeWriteStep(*step); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if err := a.flowsDB.CreateStep(step); err != nil {
-		jsonError(w, "creating step: "+err.Error(), http.StatusInternalServerError)
+	if err := a.editDraft(wf, claims.UserID, func(d *db.Draft) error {
+		return d.CreateStep(step)
+	}); err != nil {
+		draftEditError(w, "creating step", err)
 		return
 	}
 
@@ -327,8 +377,8 @@ func (a *API) handleUpdateStep(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "workflow not found", http.StatusNotFound)
 		return
 	}
-	if wf.Status != "draft" {
-		jsonError(w, "only draft workflows can be modified", http.StatusBadRequest)
+	if wf.Status == "archived" {
+		jsonError(w, "archived workflows cannot be modified", http.StatusBadRequest)
 		return
 	}
 	role := a.getUserRole(claims.UserID)
@@ -336,20 +386,25 @@ func (a *API) handleUpdateStep(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "not the workflow owner", http.StatusForbidden)
 		return
 	}
+	if !hasStep(wf, stepID) {
+		jsonError(w, "step not found", http.StatusNotFound)
+		return
+	}
 
 	var req struct {
-		StepOrder      int     `json:"step_order"`
-		StepName       string  `json:"step_name"`
-		StepType       string  `json:"step_type"`
-		Provider       string  `json:"provider"`
-		Model          string  `json:"model"`
-		PromptTemplate string  `json:"prompt_template"`
-		SystemPrompt   string  `json:"system_prompt"`
-		ConfigJSON     string  `json:"config_json"`
-		CriteriaListID *string `json:"criteria_list_id"`
-		TimeoutMs      int     `json:"timeout_ms"`
-		RetryMax       int     `json:"retry_max"`
-		FanGroup       *string `json:"fan_group"`
+		StepOrder      int      `json:"step_order"`
+		StepName       string   `json:"step_name"`
+		StepType       string   `json:"step_type"`
+		Provider       string   `json:"provider"`
+		Model          string   `json:"model"`
+		PromptTemplate string   `json:"prompt_template"`
+		SystemPrompt   string   `json:"system_prompt"`
+		ConfigJSON     string   `json:"config_json"`
+		CriteriaListID *string  `json:"criteria_list_id"`
+		TimeoutMs      int      `json:"timeout_ms"`
+		RetryMax       int      `json:"retry_max"`
+		FanGroup       *string  `json:"fan_group"`
+		DependsOn      []string `json:"depends_on"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -404,16 +459,49 @@ func (a *API) handleUpdateStep(w http.ResponseWriter, r *http.Request) {
 		TimeoutMs:      req.TimeoutMs,
 		RetryMax:       req.RetryMax,
 		FanGroup:       req.FanGroup,
+		DependsOn:      req.DependsOn,
+	}
+
+	if err := llm.CheckStepModel(a.flowsDB, *step); err != nil {
+		jsonError(w, err.Error(), stepModelStatus(err))
+		return
+	}
+	if err := llm.CheckStepDependencies(*step, wf.Steps); err != nil {
+		jsonError(w, "depends_on: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := llm.CheckStepTemplates(*step, wf.Steps); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := llm.StepSchema(*step); err != nil {
+		jsonError(w, "config_json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := llm.CheckNodeWriteStep(*step); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if err := a.flowsDB.UpdateStep(step); err != nil {
-		jsonError(w, "updating step: "+err.Error(), http.StatusInternalServerError)
+	if err := a.editDraft(wf, claims.UserID, func(d *db.Draft) error {
+		return d.UpdateStep(step)
+	}); err != nil {
+		draftEditError(w, "updating step", err)
 		return
 	}
 
 	jsonResp(w, http.StatusOK, step)
 }
 
+// stepModelStatus maps a CheckStepModel error: a model lacking a
+// capability is the request's fault, a catalogue read error is ours.
+func stepModelStatus(err error) int {
+	if errors.Is(err, db.ErrModelLacksCapability) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func (a *API) handleDeleteStep(w http.ResponseWriter, r *http.Request) {
 	claims := a.auth.ExtractClaims(r)
 	if claims == nil {
@@ -429,21 +517,107 @@ func (a *API) handleDeleteStep(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "workflow not found", http.StatusNotFound)
 		return
 	}
-	if wf.Status != "draft" {
-		jsonError(w, "only draft workflows can be modified", http.StatusBadRequest)
+	if wf.Status == "archived" {
+		jsonError(w, "archived workflows cannot be modified", http.StatusBadRequest)
 		return
 	}
 	if wf.OwnerID != claims.UserID && !a.isOperator(claims.UserID) {
 		jsonError(w, "not the workflow owner", http.StatusForbidden)
 		return
 	}
+	if !hasStep(wf, stepID) {
+		jsonError(w, "step not found", http.StatusNotFound)
+		return
+	}
 
-	if err := a.flowsDB.DeleteStep(stepID); err != nil {
-		jsonError(w, "deleting step: "+err.Error(), http.StatusInternalServerError)
+	// The steps left must still form a DAG: none may depend on this one.
+	var remaining []db.WorkflowStep
+	for _, s := range wf.Steps {
+		if s.StepID != stepID {
+			remaining = append(remaining, s)
+		}
+	}
+	if _, err := llm.PlanWorkflow(remaining); err != nil {
+		jsonError(w, "cannot delete step: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	jsonResp(w, http.StatusOK, map[string]string{"status": "deleted"})
+	if err := a.editDraft(wf, claims.UserID, func(d *db.Draft) error {
+		return d.DeleteStep(stepID)
+	}); err != nil {
+		draftEditError(w, "deleting step", err)
+		return
+	}
+
+	jsonResp(w, http.StatusOK, map[string]interface{}{"status": "deleted", "version": wf.Version})
+}
+
+// editDraft applies edit to the draft version of wf, which the first edit
+// after a version left draft starts from it (see db.EditDraft). wf then
+// describes the draft.
+func (a *API) editDraft(wf *db.Workflow, userID string, edit func(*db.Draft) error) error {
+	d, err := a.flowsDB.EditDraft(wf.WorkflowID, wf.Version, userID, edit)
+	if err != nil {
+		return err
+	}
+	if d.Forked {
+		_ = a.flowsDB.InsertAuditLog("", "", "version_created", map[string]interface{}{
+			"workflow_id":  wf.WorkflowID,
+			"version":      d.Version,
+			"from_version": wf.Version,
+			"created_by":   userID,
+		})
+	}
+	wf.Version, wf.Status = d.Version, "draft"
+	return nil
+}
+
+// draftEditError reports an editDraft error: an edit racing another one,
+// which changed the version or the step, is a conflict the client can
+// retry after reloading the workflow.
+func draftEditError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, db.ErrVersionChanged) || errors.Is(err, db.ErrStepNotDraft):
+		jsonError(w, "workflow changed since it was read, reload and retry", http.StatusConflict)
+	case strings.Contains(err.Error(), "UNIQUE"):
+		jsonError(w, "a step of that name already has that step_order", http.StatusConflict)
+	default:
+		jsonError(w, action+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func hasStep(wf *db.Workflow, stepID string) bool {
+	for _, s := range wf.Steps {
+		if s.StepID == stepID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleStepPlan previews the order a workflow's steps run in: each step
+// with the steps it waits for and its level, steps of a level running in
+// parallel. A workflow whose steps cannot be planned gets the reason.
+func (a *API) handleStepPlan(w http.ResponseWriter, r *http.Request) {
+	wf, err := a.flowsDB.GetWorkflow(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	plan, err := llm.PlanWorkflow(wf.Steps)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	levels := 0
+	for _, s := range plan {
+		levels = max(levels, s.Level+1)
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"workflow_id": wf.WorkflowID,
+		"steps":       plan,
+		"levels":      levels,
+	})
 }
 
 // --- Lifecycle ---
@@ -531,6 +705,86 @@ func (a *API) handleArchiveWorkflow(w http.ResponseWriter, r *http.Request) {
 	jsonResp(w, http.StatusOK, map[string]string{"status": "archived"})
 }
 
+// --- Versions ---
+
+func (a *API) handleListVersions(w http.ResponseWriter, r *http.Request) {
+	wf, err := a.flowsDB.GetWorkflow(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	versions, err := a.flowsDB.ListWorkflowVersions(wf.WorkflowID)
+	if err != nil {
+		jsonError(w, "listing versions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if versions == nil {
+		versions = []db.WorkflowVersion{}
+	}
+	jsonResp(w, http.StatusOK, map[string]interface{}{
+		"workflow_id":    wf.WorkflowID,
+		"latest_version": wf.Version,
+		"active_version": wf.ActiveVersion,
+		"versions":       versions,
+	})
+}
+
+// handleGetVersion returns a workflow as of the version in the path.
+func (a *API) handleGetVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || version < 1 {
+		jsonError(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+	wf, err := a.flowsDB.GetWorkflowVersion(r.PathValue("id"), version)
+	if err != nil {
+		jsonError(w, "workflow version not found", http.StatusNotFound)
+		return
+	}
+	jsonResp(w, http.StatusOK, wf)
+}
+
+// handleDiffVersions compares two versions of a workflow step by step.
+// Without from and to it compares the active version with the latest one.
+func (a *API) handleDiffVersions(w http.ResponseWriter, r *http.Request) {
+	wf, err := a.flowsDB.GetWorkflow(r.PathValue("id"))
+	if err != nil {
+		jsonError(w, "workflow not found", http.StatusNotFound)
+		return
+	}
+	to := wf.Version
+	from := to - 1
+	if wf.ActiveVersion != nil && *wf.ActiveVersion != to {
+		from = *wf.ActiveVersion
+	}
+	for param, v := range map[string]*int{"from": &from, "to": &to} {
+		if s := r.URL.Query().Get(param); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				jsonError(w, "invalid "+param+" version", http.StatusBadRequest)
+				return
+			}
+			*v = n
+		}
+	}
+	if from < 1 {
+		jsonError(w, "the workflow has a single version", http.StatusBadRequest)
+		return
+	}
+
+	fromWf, err := a.flowsDB.GetWorkflowVersion(wf.WorkflowID, from)
+	if err != nil {
+		jsonError(w, "workflow version not found: "+strconv.Itoa(from), http.StatusNotFound)
+		return
+	}
+	toWf, err := a.flowsDB.GetWorkflowVersion(wf.WorkflowID, to)
+	if err != nil {
+		jsonError(w, "workflow version not found: "+strconv.Itoa(to), http.StatusNotFound)
+		return
+	}
+	jsonResp(w, http.StatusOK, db.DiffWorkflowVersions(fromWf, toWf))
+}
+
 // --- Execution ---
 
 func (a *API) handleRunWorkflow(w http.ResponseWriter, r *http.Request) {
@@ -541,12 +795,12 @@ func (a *API) handleRunWorkflow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	wfID := r.PathValue("id")
-	wf, err := a.flowsDB.GetWorkflow(wfID)
+	wf, err := a.flowsDB.GetRunnableWorkflow(wfID)
 	if err != nil {
 		jsonError(w, "workflow not found", http.StatusNotFound)
 		return
 	}
-	if wf.Status != "active" {
+	if wf.ActiveVersion == nil {
 		jsonError(w, "workflow is not active", http.StatusBadRequest)
 		return
 	}
@@ -555,6 +809,7 @@ func (a *API) handleRunWorkflow(w http.ResponseWriter, r *http.Request) {
 		NodeID    string `json:"node_id"`
 		PrePrompt string `json:"pre_prompt"`
 		Body      string `json:"body"`
+		DryRun    bool   `json:"dry_run"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request body", http.StatusBadRequest)
@@ -567,18 +822,31 @@ func (a *API) handleRunWorkflow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := claims.UserID
-	role := a.getUserRole(userID)
-	go func() {
-		if req.Body != "" {
-			_, _ = a.workflowEngine.ExecuteWorkflowWithBody(r.Context(), wfID, req.NodeID, userID, role, req.PrePrompt, req.Body)
-		} else {
-			_, _ = a.workflowEngine.ExecuteWorkflow(r.Context(), wfID, req.NodeID, userID, role, req.PrePrompt)
-		}
-	}()
+	body := req.Body
+	if body == "" {
+		body = req.NodeID // placeholder, as in ExecuteWorkflow
+	}
+	in := llm.WorkflowInput{
+		NodeID:    req.NodeID,
+		Body:      body,
+		PrePrompt: req.PrePrompt,
+		UserID:    userID,
+		UserRole:  a.getUserRole(userID),
+		DryRun:    req.DryRun,
+	}
+	// The run outlives the request; its ID lets the caller follow it.
+	runID, err := a.workflowEngine.Start(context.WithoutCancel(a.llmContext(r)), wf, in)
+	if err != nil {
+		jsonError(w, "starting run: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	jsonResp(w, http.StatusAccepted, map[string]string{
-		"status":      "accepted",
-		"workflow_id": wfID,
+	jsonResp(w, http.StatusAccepted, map[string]interface{}{
+		"status":           "accepted",
+		"workflow_id":      wfID,
+		"workflow_version": wf.Version,
+		"run_id":           runID,
+		"dry_run":          req.DryRun,
 	})
 }
 
@@ -605,6 +873,112 @@ func (a *API) handleGetStepRuns(w http.ResponseWriter, r *http.Request) {
 	jsonResp(w, http.StatusOK, steps)
 }
 
+// --- Run control ---
+
+// handleResumeRun goes on with a failed, cancelled or interrupted run
+// from its completed steps.
+func (a *API) handleResumeRun(w http.ResponseWriter, r *http.Request) {
+	a.resumeRun(w, r, "")
+}
+
+// handleRetryFrom runs a step of a run again, with every step depending
+// on it, keeping the other completed steps.
+func (a *API) handleRetryFrom(w http.ResponseWriter, r *http.Request) {
+	a.resumeRun(w, r, r.PathValue("stepId"))
+}
+
+// resumeRun resumes the run in the background, retrying from fromStepID
+// unless it is "", once the engine has checked that it can.
+func (a *API) resumeRun(w http.ResponseWriter, r *http.Request, fromStepID string) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+	if a.workflowEngine == nil {
+		jsonError(w, "workflow engine not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	runID := r.PathValue("runId")
+	if err := a.workflowEngine.CheckResumable(runID, fromStepID); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, llm.ErrRunNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, llm.ErrRunActive), errors.Is(err, llm.ErrRunCompleted):
+			status = http.StatusConflict
+		case errors.Is(err, llm.ErrStepNotInRun):
+			status = http.StatusBadRequest
+		}
+		jsonError(w, err.Error(), status)
+		return
+	}
+
+	ctx := context.WithoutCancel(a.llmContext(r))
+	go func() {
+		var err error
+		if fromStepID == "" {
+			_, err = a.workflowEngine.ResumeRun(ctx, runID)
+		} else {
+			_, err = a.workflowEngine.RetryFrom(ctx, runID, fromStepID)
+		}
+		if err != nil {
+			slog.Warn("resuming workflow run", "run_id", runID, "error", err)
+		}
+	}()
+
+	jsonResp(w, http.StatusAccepted, map[string]string{
+		"status": "accepted",
+		"run_id": runID,
+	})
+}
+
+// handleCancelRun stops a run: its provider calls in flight are aborted
+// and it ends as cancelled. Its initiator or an operator may cancel it.
+func (a *API) handleCancelRun(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if a.workflowEngine == nil {
+		jsonError(w, "workflow engine not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	runID := r.PathValue("runId")
+	run, err := a.flowsDB.GetWorkflowRun(runID)
+	if err != nil {
+		jsonError(w, "run not found", http.StatusNotFound)
+		return
+	}
+	if run.InitiatedBy != claims.UserID && !a.isOperator(claims.UserID) {
+		jsonError(w, "only the run's initiator or an operator can cancel it", http.StatusForbidden)
+		return
+	}
+
+	if err := a.workflowEngine.CancelRun(runID, claims.UserID); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, llm.ErrRunNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, llm.ErrRunFinished):
+			status = http.StatusConflict
+		}
+		jsonError(w, err.Error(), status)
+		return
+	}
+	jsonResp(w, http.StatusAccepted, map[string]string{
+		"status": "cancelling",
+		"run_id": runID,
+	})
+}
+
 // --- Batch ---
 
 func (a *API) handleBatchRun(w http.ResponseWriter, r *http.Request) {
@@ -629,23 +1003,57 @@ func (a *API) handleBatchRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	batchID := db.NewID()
-	userID := claims.UserID
-	role := a.getUserRole(userID)
+	if a.workflowEngine == nil {
+		jsonError(w, "workflow engine not configured", http.StatusServiceUnavailable)
+		return
+	}
 
+	// Every workflow is checked before any run starts.
+	workflows := make([]*db.Workflow, 0, len(req.WorkflowIDs))
 	for _, wfID := range req.WorkflowIDs {
-		go func(id string) {
-			if req.Body != "" {
-				_, _ = a.workflowEngine.ExecuteWorkflowWithBody(r.Context(), id, req.NodeID, userID, role, req.PrePrompt, req.Body)
-			} else {
-				_, _ = a.workflowEngine.ExecuteWorkflow(r.Context(), id, req.NodeID, userID, role, req.PrePrompt)
-			}
-		}(wfID)
+		wf, err := a.flowsDB.GetRunnableWorkflow(wfID)
+		if err != nil {
+			jsonError(w, "workflow not found: "+wfID, http.StatusNotFound)
+			return
+		}
+		if wf.ActiveVersion == nil {
+			jsonError(w, "workflow is not active: "+wfID, http.StatusBadRequest)
+			return
+		}
+		workflows = append(workflows, wf)
+	}
+
+	batchID := db.NewID()
+	userID := claims.UserID
+	body := req.Body
+	if body == "" {
+		body = req.NodeID // placeholder, as in ExecuteWorkflow
+	}
+	in := llm.WorkflowInput{
+		NodeID:    req.NodeID,
+		Body:      body,
+		PrePrompt: req.PrePrompt,
+		UserID:    userID,
+		UserRole:  a.getUserRole(userID),
+		BatchID:   batchID,
+	}
+	// Like single runs, the batch outlives the request; it is cancelled
+	// through POST /api/workflows/batch/{batchId}/cancel.
+	ctx := context.WithoutCancel(a.llmContext(r))
+	runIDs := make([]string, 0, len(workflows))
+	for _, wf := range workflows {
+		runID, err := a.workflowEngine.Start(ctx, wf, in)
+		if err != nil {
+			jsonError(w, "starting run: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		runIDs = append(runIDs, runID)
 	}
 
 	jsonResp(w, http.StatusAccepted, map[string]interface{}{
 		"batch_id":       batchID,
 		"workflow_count": len(req.WorkflowIDs),
+		"run_ids":        runIDs,
 		"status":         "accepted",
 	})
 }
@@ -666,6 +1074,45 @@ func (a *API) handleGetBatch(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleCancelBatch cancels the runs of a batch still pending or running.
+// The batch's initiator or an operator may cancel it.
+func (a *API) handleCancelBatch(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil {
+		jsonError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if a.workflowEngine == nil {
+		jsonError(w, "workflow engine not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	batchID := r.PathValue("batchId")
+	runs, err := a.flowsDB.ListRunsByBatch(batchID)
+	if err != nil {
+		jsonError(w, "batch error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(runs) == 0 {
+		jsonError(w, "batch not found", http.StatusNotFound)
+		return
+	}
+	if runs[0].InitiatedBy != claims.UserID && !a.isOperator(claims.UserID) {
+		jsonError(w, "only the batch's initiator or an operator can cancel it", http.StatusForbidden)
+		return
+	}
+
+	n, err := a.workflowEngine.CancelBatch(batchID, claims.UserID)
+	if err != nil {
+		jsonError(w, "cancelling batch: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResp(w, http.StatusAccepted, map[string]interface{}{
+		"batch_id":  batchID,
+		"cancelled": n,
+	})
+}
+
 // --- Models ---
 
 func (a *API) handleListModels(w http.ResponseWriter, r *http.Request) {
@@ -700,6 +1147,23 @@ func (a *API) handleDiscoverModels(w http.ResponseWriter, r *http.Request) {
 	jsonResp(w, http.StatusAccepted, map[string]string{"status": "discovery_started"})
 }
 
+// handleRefreshModels runs a full refresh round — discovery, then the
+// liveness probe if enabled — and waits for it, unlike discover.
+func (a *API) handleRefreshModels(w http.ResponseWriter, r *http.Request) {
+	claims := a.auth.ExtractClaims(r)
+	if claims == nil || !a.isOperator(claims.UserID) {
+		jsonError(w, "operator access required", http.StatusForbidden)
+		return
+	}
+
+	if a.modelDiscovery == nil {
+		jsonError(w, "model discovery not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	jsonResp(w, http.StatusOK, a.modelDiscovery.Refresh(r.Context()))
+}
+
 // --- Criteria Lists ---
 
 func (a *API) handleListCriteriaLists(w http.ResponseWriter, r *http.Request) {
@@ -893,6 +1357,7 @@ func (a *API) handleCreateModel(w http.ResponseWriter, r *http.Request) {
 		Provider         string  `json:"provider"`
 		ModelName        string  `json:"model_name"`
 		DisplayName      *string `json:"display_name"`
+		ContextWindow    *int    `json:"context_window"`
 		CapabilitiesJSON string  `json:"capabilities_json"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -913,6 +1378,7 @@ func (a *API) handleCreateModel(w http.ResponseWriter, r *http.Request) {
 		Provider:         req.Provider,
 		ModelName:        req.ModelName,
 		DisplayName:      req.DisplayName,
+		ContextWindow:    req.ContextWindow,
 		CapabilitiesJSON: req.CapabilitiesJSON,
 		OwnerID:          &ownerID,
 	}
@@ -36,12 +36,89 @@ type AuthConfig struct {
 }
 
 type LLMConfig struct {
+	Mode     string `toml:"mode"`     // live (default), record, replay or scripted
+	Cassette string `toml:"cassette"` // JSONL cassette written in record mode, read in replay mode
+	Script   string `toml:"script"`   // TOML canned responses per step name (scripted mode)
+
 	GeminiAPIKey    string `toml:"gemini_api_key"`
 	MistralAPIKey   string `toml:"mistral_api_key"`
 	OpenRouterKey   string `toml:"openrouter_api_key"`
 	GroqAPIKey      string `toml:"groq_api_key"`
 	AnthropicAPIKey string `toml:"anthropic_api_key"`
 	HuggingFaceKey  string `toml:"huggingface_api_key"`
+
+	Limits     []LLMLimit          `toml:"limits"`     // per-provider / per-model quotas ([[llm.limits]])
+	Cache      LLMCacheConfig      `toml:"cache"`      // response cache ([llm.cache])
+	Registered LLMRegisteredConfig `toml:"registered"` // self-registered providers ([llm.registered])
+	Embeddings LLMEmbeddingsConfig `toml:"embeddings"` // vector index ([llm.embeddings])
+	Discovery  LLMDiscoveryConfig  `toml:"discovery"`  // model catalogue refresh ([llm.discovery])
+	Flows      LLMFlowsConfig      `toml:"flows"`      // flow files ([llm.flows])
+	Workflows  LLMWorkflowsConfig  `toml:"workflows"`  // interrupted runs, triggers ([llm.workflows])
+}
+
+// LLMWorkflowsConfig decides what becomes of the workflow runs a previous
+// process left unfinished: OnRestart "resume" goes on from their completed
+// steps, "fail" marks them failed. Cron triggers that fell due are fired
+// every TriggerSeconds.
+type LLMWorkflowsConfig struct {
+	OnRestart      string `toml:"on_restart"`
+	TriggerSeconds int    `toml:"trigger_seconds"`
+}
+
+// LLMFlowsConfig points at a directory of TOML flow files, one flow per
+// file, merged with the built-in flows. The directory is checked for
+// changes every ReloadSeconds (0 = read at startup only).
+type LLMFlowsConfig struct {
+	Dir           string `toml:"dir"`
+	ReloadSeconds int    `toml:"reload_seconds"`
+}
+
+// LLMDiscoveryConfig schedules the model catalogue refresh: listings are
+// re-read every IntervalMinutes (0 = at startup only) and, when Probe is
+// set, up to MaxProbes models in use get a one-token liveness request.
+type LLMDiscoveryConfig struct {
+	IntervalMinutes int  `toml:"interval_minutes"`
+	Probe           bool `toml:"probe"`
+	MaxProbes       int  `toml:"max_probes"` // 0 = no limit
+}
+
+// LLMEmbeddingsConfig enables the vector index of public nodes and sources.
+// Model is "provider/model"; "" uses the first configured provider with a
+// default embedding model (gemini, mistral), else the local embedder.
+// "local" always uses the local embedder, which Local enables.
+type LLMEmbeddingsConfig struct {
+	Enabled         bool   `toml:"enabled"`
+	Model           string `toml:"model"`
+	Local           bool   `toml:"local"` // built-in n-gram embedder, no provider needed
+	BatchSize       int    `toml:"batch_size"`
+	IntervalSeconds int    `toml:"interval_seconds"` // how often missing vectors are backfilled
+}
+
+// LLMRegisteredConfig controls how providers registered through
+// POST /api/providers/register become LLM backends. Off by default: a
+// registered endpoint is called by the server. A provider whose last
+// heartbeat is older than StaleMinutes is retired (0 = heartbeats optional).
+type LLMRegisteredConfig struct {
+	Enabled      bool `toml:"enabled"`
+	StaleMinutes int  `toml:"stale_minutes"`
+	SyncSeconds  int  `toml:"sync_seconds"` // how often the providers table is re-read
+}
+
+// LLMCacheConfig enables the content-addressed response cache in flows.db.
+// TTLHours = 0 keeps entries until purged.
+type LLMCacheConfig struct {
+	Enabled  bool `toml:"enabled"`
+	TTLHours int  `toml:"ttl_hours"`
+}
+
+// LLMLimit caps request rate, token rate and concurrency for a provider,
+// or for a single model of that provider when Model is set. 0 = unlimited.
+type LLMLimit struct {
+	Provider          string `toml:"provider"`
+	Model             string `toml:"model"`
+	RequestsPerMinute int    `toml:"requests_per_minute"`
+	TokensPerMinute   int    `toml:"tokens_per_minute"`
+	MaxInFlight       int    `toml:"max_in_flight"`
 }
 
 type BotConfig struct {
@@ -60,6 +137,7 @@ type FederationConfig struct {
 	PublicKeyID      string   `toml:"public_key_id"`      // key identifier
 	VerifySignatures bool     `toml:"verify_signatures"`  // reject unsigned foreign nodes
 	PeerInstances    []string `toml:"peer_instances"`     // known peer URLs
+	TrustedKeys      []string `toml:"trusted_keys"`       // base64 Ed25519 keys whose workflow bundles are imported
 }
 
 type InstanceConfig struct {
@@ -81,6 +159,30 @@ func DefaultConfig() *Config {
 			JWTSecret:      "change-me-in-production",
 			TokenExpiryMin: 1440, // 24h
 		},
+		LLM: LLMConfig{
+			Registered: LLMRegisteredConfig{
+				Enabled:      false,
+				StaleMinutes: 10,
+				SyncSeconds:  60,
+			},
+			Embeddings: LLMEmbeddingsConfig{
+				Enabled: true,
+				Local:   true,
+			},
+			Discovery: LLMDiscoveryConfig{
+				IntervalMinutes: 360,
+				Probe:           true,
+				MaxProbes:       20,
+			},
+			Flows: LLMFlowsConfig{
+				Dir:           "flows",
+				ReloadSeconds: 5,
+			},
+			Workflows: LLMWorkflowsConfig{
+				OnRestart:      "resume",
+				TriggerSeconds: 30,
+			},
+		},
 		Bot: BotConfig{
 			Handle:       "horostracker",
 			Enabled:      true,
@@ -118,5 +220,8 @@ func Load(path string) (*Config, error) {
 	if err := toml.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("parsing config: %w", err)
 	}
+	if p := cfg.LLM.Workflows.OnRestart; p != "resume" && p != "fail" {
+		return nil, fmt.Errorf("[llm.workflows] on_restart = %q: must be \"resume\" or \"fail\"", p)
+	}
 	return cfg, nil
 }
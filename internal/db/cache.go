@@ -0,0 +1,115 @@
+// CLAUDE:SUMMARY LLM response cache DB — content-addressed llm_cache rows with TTL, hit counting, stats and purge
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CachedResponse is one llm_cache row: a provider response stored under
+// the hash of the request that produced it.
+type CachedResponse struct {
+	CacheKey     string     `json:"cache_key"`
+	Provider     string     `json:"provider"`
+	Model        string     `json:"model"`
+	Content      string     `json:"content"`
+	TokensIn     int        `json:"tokens_in"`
+	TokensOut    int        `json:"tokens_out"`
+	FinishReason string     `json:"finish_reason"`
+	CostUSD      float64    `json:"cost_usd"`
+	Hits         int        `json:"hits"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// GetCachedResponse returns the live entry for key and counts the hit.
+// Expired or missing entries return (nil, nil).
+func (db *FlowsDB) GetCachedResponse(key string) (*CachedResponse, error) {
+	var c CachedResponse
+	var finish sql.NullString
+	var cost sql.NullFloat64
+	var expires sql.NullTime
+	err := db.QueryRow(`
+		SELECT cache_key, provider, model, content, COALESCE(tokens_in,0), COALESCE(tokens_out,0),
+			finish_reason, cost_usd, hits, expires_at, created_at
+		FROM llm_cache
+		WHERE cache_key = ? AND (expires_at IS NULL OR expires_at > datetime('now'))`, key).Scan(
+		&c.CacheKey, &c.Provider, &c.Model, &c.Content, &c.TokensIn, &c.TokensOut,
+		&finish, &cost, &c.Hits, &expires, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.FinishReason = finish.String
+	c.CostUSD = cost.Float64
+	if expires.Valid {
+		c.ExpiresAt = &expires.Time
+	}
+
+	_, _ = db.Exec(`UPDATE llm_cache SET hits = hits + 1, last_hit_at = datetime('now') WHERE cache_key = ?`, key)
+	c.Hits++
+	return &c, nil
+}
+
+// PutCachedResponse stores c under c.CacheKey, replacing the response of
+// any previous entry (its hit count is kept). ttl <= 0 keeps the entry
+// until it is purged.
+func (db *FlowsDB) PutCachedResponse(c *CachedResponse, ttl time.Duration) error {
+	var expires interface{}
+	if ttl > 0 {
+		expires = time.Now().UTC().Add(ttl).Format("2006-01-02 15:04:05")
+	}
+	_, err := db.Exec(`
+		INSERT INTO llm_cache (cache_key, provider, model, content, tokens_in, tokens_out,
+			finish_reason, cost_usd, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(cache_key) DO UPDATE SET
+			provider = excluded.provider, model = excluded.model, content = excluded.content,
+			tokens_in = excluded.tokens_in, tokens_out = excluded.tokens_out,
+			finish_reason = excluded.finish_reason, cost_usd = excluded.cost_usd,
+			expires_at = excluded.expires_at, created_at = datetime('now')`,
+		c.CacheKey, c.Provider, c.Model, c.Content, c.TokensIn, c.TokensOut,
+		nilIfEmpty(c.FinishReason), c.CostUSD, expires)
+	return err
+}
+
+// CacheStats summarises llm_cache. SavedUSD is the provider cost that cache
+// hits avoided (hits × the cost of the original call).
+type CacheStats struct {
+	Entries  int     `json:"entries"`
+	Expired  int     `json:"expired"`
+	Hits     int     `json:"hits"`
+	SavedUSD float64 `json:"saved_usd"`
+}
+
+// LLMCacheStats returns entry, hit and savings totals for llm_cache.
+func (db *FlowsDB) LLMCacheStats() (*CacheStats, error) {
+	var s CacheStats
+	err := db.QueryRow(`
+		SELECT COUNT(*),
+			COALESCE(SUM(CASE WHEN expires_at IS NOT NULL AND expires_at <= datetime('now') THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(hits), 0),
+			COALESCE(SUM(hits * COALESCE(cost_usd, 0)), 0)
+		FROM llm_cache`).Scan(&s.Entries, &s.Expired, &s.Hits, &s.SavedUSD)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// PurgeLLMCache deletes expired entries, or every entry when all is true.
+// Returns the number of rows removed.
+func (db *FlowsDB) PurgeLLMCache(all bool) (int64, error) {
+	q := `DELETE FROM llm_cache WHERE expires_at IS NOT NULL AND expires_at <= datetime('now')`
+	if all {
+		q = `DELETE FROM llm_cache`
+	}
+	res, err := db.Exec(q)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -18,6 +18,7 @@ type Challenge struct {
 	Score          *float64   `json:"score,omitempty"`
 	Summary        *string    `json:"summary,omitempty"`
 	FlowID         *string    `json:"flow_id,omitempty"`
+	WorkflowRunID  *string    `json:"workflow_run_id,omitempty"`
 	Error          *string    `json:"error,omitempty"`
 	StartedAt      *time.Time `json:"started_at,omitempty"`
 	CompletedAt    *time.Time `json:"completed_at,omitempty"`
@@ -58,7 +59,7 @@ func (db *DB) CreateChallenge(nodeID, flowName, requestedBy string, targetProvid
 func (db *DB) GetChallenge(id string) (*Challenge, error) {
 	return scanChallenge(db.QueryRow(`
 		SELECT id, node_id, flow_name, status, requested_by, target_provider, target_model,
-			score, summary, flow_id, error, started_at, completed_at, created_at
+			score, summary, flow_id, workflow_run_id, error, started_at, completed_at, created_at
 		FROM challenges WHERE id = ?`, id))
 }
 
@@ -69,7 +70,7 @@ func (db *DB) GetChallengesForNode(nodeID string, limit int) ([]*Challenge, erro
 	}
 	rows, err := db.Query(`
 		SELECT id, node_id, flow_name, status, requested_by, target_provider, target_model,
-			score, summary, flow_id, error, started_at, completed_at, created_at
+			score, summary, flow_id, workflow_run_id, error, started_at, completed_at, created_at
 		FROM challenges WHERE node_id = ?
 		ORDER BY created_at DESC
 		LIMIT ?`, nodeID, limit)
@@ -80,11 +81,16 @@ func (db *DB) GetChallengesForNode(nodeID string, limit int) ([]*Challenge, erro
 	return scanChallengeRows(rows)
 }
 
-// UpdateChallengeRunning marks a challenge as running with a flow ID.
-func (db *DB) UpdateChallengeRunning(id, flowID string) error {
+// UpdateChallengeRunning marks a challenge as running with a flow ID and,
+// when it runs as a workflow, the workflow_runs row ("" for none).
+func (db *DB) UpdateChallengeRunning(id, flowID, workflowRunID string) error {
+	var runID *string
+	if workflowRunID != "" {
+		runID = &workflowRunID
+	}
 	_, err := db.Exec(`
-		UPDATE challenges SET status = 'running', flow_id = ?, started_at = datetime('now')
-		WHERE id = ?`, flowID, id)
+		UPDATE challenges SET status = 'running', flow_id = ?, workflow_run_id = ?, started_at = datetime('now')
+		WHERE id = ?`, flowID, runID, id)
 	return err
 }
 
@@ -104,6 +110,15 @@ func (db *DB) UpdateChallengeFailed(id, errMsg string) error {
 	return err
 }
 
+// FailChallengeOfRun marks the running challenge that runs as the given
+// workflow run as failed, if there is one.
+func (db *DB) FailChallengeOfRun(workflowRunID, errMsg string) error {
+	_, err := db.Exec(`
+		UPDATE challenges SET status = 'failed', error = ?, completed_at = datetime('now')
+		WHERE workflow_run_id = ? AND status = 'running'`, errMsg, workflowRunID)
+	return err
+}
+
 // CountChallengesForNode returns completed challenge count for a node.
 func (db *DB) CountChallengesForNode(nodeID string) (int, error) {
 	var count int
@@ -178,18 +193,31 @@ func (db *DB) GetModerationScores(nodeID string) ([]*ModerationScore, error) {
 	return results, nil
 }
 
+// TemperatureChange is a node's temperature before and after a recalculation.
+type TemperatureChange struct {
+	NodeID string
+	From   string
+	To     string
+}
+
+// BecameHot reports whether the node rose from cold or warm to hot or above.
+func (c TemperatureChange) BecameHot() bool {
+	return (c.From == "cold" || c.From == "warm") && (c.To == "hot" || c.To == "critical")
+}
+
 // RecalculateTemperature recalculates a node's temperature based on activity.
 // Rules:
 //   - cold: < 3 children, < 5 votes, no challenges
 //   - warm: 3+ children OR 5+ votes
 //   - hot:  5+ children AND 10+ votes OR any completed challenge
 //   - critical: 10+ children AND 20+ votes AND score divergence OR 3+ challenges
-func (db *DB) RecalculateTemperature(nodeID string) (string, error) {
+func (db *DB) RecalculateTemperature(nodeID string) (TemperatureChange, error) {
 	var childCount, score, viewCount int
-	err := db.QueryRow(`SELECT child_count, score, view_count FROM nodes WHERE id = ?`, nodeID).
-		Scan(&childCount, &score, &viewCount)
+	change := TemperatureChange{NodeID: nodeID}
+	err := db.QueryRow(`SELECT child_count, score, view_count, COALESCE(temperature,'cold') FROM nodes WHERE id = ?`, nodeID).
+		Scan(&childCount, &score, &viewCount, &change.From)
 	if err != nil {
-		return "", err
+		return change, err
 	}
 
 	// Count total votes (abs values)
@@ -215,18 +243,19 @@ func (db *DB) RecalculateTemperature(nodeID string) (string, error) {
 
 	_, err = db.Exec(`UPDATE nodes SET temperature = ?, updated_at = datetime('now') WHERE id = ?`, temp, nodeID)
 	if err != nil {
-		return "", err
+		return change, err
 	}
-	return temp, nil
+	change.To = temp
+	return change, nil
 }
 
 // RecalculateRootTemperature recalculates the root node's temperature.
 // Called after tree-level events (new child, challenge, etc.).
-func (db *DB) RecalculateRootTemperature(nodeID string) (string, error) {
+func (db *DB) RecalculateRootTemperature(nodeID string) (TemperatureChange, error) {
 	var rootID string
 	err := db.QueryRow(`SELECT root_id FROM nodes WHERE id = ?`, nodeID).Scan(&rootID)
 	if err != nil {
-		return "", err
+		return TemperatureChange{NodeID: nodeID}, err
 	}
 	return db.RecalculateTemperature(rootID)
 }
@@ -272,12 +301,12 @@ func (db *DB) GetChallengeLeaderboard(limit int) ([]ChallengeLeaderEntry, error)
 
 func scanChallenge(s interface{ Scan(...any) error }) (*Challenge, error) {
 	c := &Challenge{}
-	var targetProvider, targetModel, summary, flowID, errMsg sql.NullString
+	var targetProvider, targetModel, summary, flowID, workflowRunID, errMsg sql.NullString
 	var score sql.NullFloat64
 	var startedAt, completedAt sql.NullTime
 	err := s.Scan(
 		&c.ID, &c.NodeID, &c.FlowName, &c.Status, &c.RequestedBy,
-		&targetProvider, &targetModel, &score, &summary, &flowID, &errMsg,
+		&targetProvider, &targetModel, &score, &summary, &flowID, &workflowRunID, &errMsg,
 		&startedAt, &completedAt, &c.CreatedAt,
 	)
 	if err != nil {
@@ -298,6 +327,9 @@ func scanChallenge(s interface{ Scan(...any) error }) (*Challenge, error) {
 	if flowID.Valid {
 		c.FlowID = &flowID.String
 	}
+	if workflowRunID.Valid {
+		c.WorkflowRunID = &workflowRunID.String
+	}
 	if errMsg.Valid {
 		c.Error = &errMsg.String
 	}
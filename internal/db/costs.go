@@ -0,0 +1,342 @@
+// CLAUDE:SUMMARY LLM cost accounting DB — per-model price table (seeded, discovered, operator-editable), spend budgets and the llm_spend ledger
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ModelPrice is the USD price per million tokens of a provider model.
+// ModelName matches by prefix, so "claude-sonnet-4-5" also prices
+// dated snapshots such as "claude-sonnet-4-5-20250929".
+type ModelPrice struct {
+	Provider      string    `json:"provider"`
+	ModelName     string    `json:"model_name"`
+	InputPerMTok  float64   `json:"input_per_mtok"`
+	OutputPerMTok float64   `json:"output_per_mtok"`
+	Source        string    `json:"source"`
+	UpdatedBy     *string   `json:"updated_by,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Cost returns the USD cost of a call with the given token counts.
+func (p *ModelPrice) Cost(tokensIn, tokensOut int) float64 {
+	return (float64(tokensIn)*p.InputPerMTok + float64(tokensOut)*p.OutputPerMTok) / 1e6
+}
+
+// defaultModelPrices seeds model_prices with the public list prices of the
+// models the built-in providers expose. Operators override them through the
+// API; an operator-edited row is never overwritten by a later seed.
+var defaultModelPrices = []ModelPrice{
+	{Provider: "gemini", ModelName: "gemini-2.0-flash", InputPerMTok: 0.10, OutputPerMTok: 0.40},
+	{Provider: "gemini", ModelName: "gemini-2.0-flash-lite", InputPerMTok: 0.075, OutputPerMTok: 0.30},
+	{Provider: "gemini", ModelName: "gemini-1.5-pro", InputPerMTok: 1.25, OutputPerMTok: 5.00},
+	{Provider: "anthropic", ModelName: "claude-sonnet-4-5", InputPerMTok: 3.00, OutputPerMTok: 15.00},
+	{Provider: "anthropic", ModelName: "claude-haiku-4-5", InputPerMTok: 1.00, OutputPerMTok: 5.00},
+	{Provider: "mistral", ModelName: "mistral-large-latest", InputPerMTok: 2.00, OutputPerMTok: 6.00},
+	{Provider: "mistral", ModelName: "mistral-small-latest", InputPerMTok: 0.10, OutputPerMTok: 0.30},
+	{Provider: "mistral", ModelName: "codestral-latest", InputPerMTok: 0.30, OutputPerMTok: 0.90},
+	{Provider: "groq", ModelName: "llama-3.3-70b-versatile", InputPerMTok: 0.59, OutputPerMTok: 0.79},
+	{Provider: "groq", ModelName: "llama-3.1-8b-instant", InputPerMTok: 0.05, OutputPerMTok: 0.08},
+	{Provider: "groq", ModelName: "mixtral-8x7b-32768", InputPerMTok: 0.24, OutputPerMTok: 0.24},
+	{Provider: "openrouter", ModelName: "deepseek/deepseek-chat", InputPerMTok: 0.27, OutputPerMTok: 1.10},
+	{Provider: "openrouter", ModelName: "qwen/qwen-2.5-72b-instruct", InputPerMTok: 0.35, OutputPerMTok: 0.40},
+	{Provider: "openrouter", ModelName: "meta-llama/llama-3.3-70b-instruct", InputPerMTok: 0.12, OutputPerMTok: 0.30},
+	{Provider: "deepseek", ModelName: "deepseek-chat", InputPerMTok: 0.27, OutputPerMTok: 1.10},
+	{Provider: "deepseek", ModelName: "deepseek-reasoner", InputPerMTok: 0.55, OutputPerMTok: 2.19},
+}
+
+// seedModelPrices inserts the default prices, refreshing rows that still
+// carry a seed price so that updated defaults reach existing instances.
+// Operator and discovered prices are left alone.
+func (db *FlowsDB) seedModelPrices() error {
+	for _, p := range defaultModelPrices {
+		if _, err := db.Exec(`
+			INSERT INTO model_prices (provider, model_name, input_per_mtok, output_per_mtok, source)
+			VALUES (?, ?, ?, ?, 'seed')
+			ON CONFLICT(provider, model_name) DO UPDATE SET
+				input_per_mtok = excluded.input_per_mtok,
+				output_per_mtok = excluded.output_per_mtok
+			WHERE model_prices.source = 'seed'`,
+			p.Provider, p.ModelName, p.InputPerMTok, p.OutputPerMTok); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDiscoveredPrice records the price a provider's model listing
+// advertises, with source 'discovered'. It replaces a seed price, is
+// refreshed by the next discovery and kept by the next seeding, and never
+// overrides an operator's price.
+func (db *FlowsDB) SetDiscoveredPrice(provider, modelName string, inputPerMTok, outputPerMTok float64) error {
+	_, err := db.Exec(`
+		INSERT INTO model_prices (provider, model_name, input_per_mtok, output_per_mtok, source)
+		VALUES (?, ?, ?, ?, 'discovered')
+		ON CONFLICT(provider, model_name) DO UPDATE SET
+			input_per_mtok = excluded.input_per_mtok,
+			output_per_mtok = excluded.output_per_mtok,
+			source = 'discovered',
+			updated_at = datetime('now')
+		WHERE model_prices.source IN ('seed','discovered')`,
+		provider, modelName, inputPerMTok, outputPerMTok)
+	return err
+}
+
+// UpsertModelPrice sets the price of a model on behalf of an operator.
+func (db *FlowsDB) UpsertModelPrice(p *ModelPrice, updatedBy string) error {
+	_, err := db.Exec(`
+		INSERT INTO model_prices (provider, model_name, input_per_mtok, output_per_mtok, source, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, 'operator', ?, datetime('now'))
+		ON CONFLICT(provider, model_name) DO UPDATE SET
+			input_per_mtok = excluded.input_per_mtok,
+			output_per_mtok = excluded.output_per_mtok,
+			source = 'operator',
+			updated_by = excluded.updated_by,
+			updated_at = datetime('now')`,
+		p.Provider, p.ModelName, p.InputPerMTok, p.OutputPerMTok, updatedBy)
+	return err
+}
+
+// DeleteModelPrice removes a price row. Returns false if none matched.
+func (db *FlowsDB) DeleteModelPrice(provider, modelName string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM model_prices WHERE provider = ? AND model_name = ?`, provider, modelName)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ListModelPrices returns the price table, optionally filtered by provider.
+func (db *FlowsDB) ListModelPrices(provider string) ([]ModelPrice, error) {
+	query := `SELECT provider, model_name, input_per_mtok, output_per_mtok, source, updated_by, updated_at
+		FROM model_prices`
+	var args []interface{}
+	if provider != "" {
+		query += ` WHERE provider = ?`
+		args = append(args, provider)
+	}
+	query += ` ORDER BY provider, model_name`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []ModelPrice
+	for rows.Next() {
+		var p ModelPrice
+		if err := rows.Scan(&p.Provider, &p.ModelName, &p.InputPerMTok, &p.OutputPerMTok,
+			&p.Source, &p.UpdatedBy, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		prices = append(prices, p)
+	}
+	return prices, rows.Err()
+}
+
+// LookupModelPrice returns the price for a provider model: an exact match,
+// else the longest model_name that prefixes it. Returns nil if unpriced.
+func (db *FlowsDB) LookupModelPrice(provider, model string) (*ModelPrice, error) {
+	var p ModelPrice
+	err := db.QueryRow(`
+		SELECT provider, model_name, input_per_mtok, output_per_mtok, source, updated_by, updated_at
+		FROM model_prices
+		WHERE provider = ? AND (model_name = ? OR substr(?, 1, length(model_name)) = model_name)
+		ORDER BY length(model_name) DESC LIMIT 1`, provider, model, model).
+		Scan(&p.Provider, &p.ModelName, &p.InputPerMTok, &p.OutputPerMTok, &p.Source, &p.UpdatedBy, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// --- Budgets ---
+
+// Budget scopes. User and instance budgets reset every UTC day; a run
+// budget covers the whole lifetime of one workflow run.
+const (
+	BudgetScopeUser     = "user"
+	BudgetScopeRun      = "run"
+	BudgetScopeInstance = "instance"
+)
+
+// Budget caps LLM spend for a scope. An empty ScopeID is the default for
+// every user (or run) that has no budget of its own.
+type Budget struct {
+	BudgetID  string    `json:"budget_id"`
+	Scope     string    `json:"scope"`
+	ScopeID   string    `json:"scope_id"`
+	LimitUSD  float64   `json:"limit_usd"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SetBudget creates or replaces the budget of a scope.
+func (db *FlowsDB) SetBudget(scope, scopeID string, limitUSD float64, createdBy string) (*Budget, error) {
+	_, err := db.Exec(`
+		INSERT INTO llm_budgets (budget_id, scope, scope_id, limit_usd, created_by)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(scope, scope_id) DO UPDATE SET
+			limit_usd = excluded.limit_usd,
+			updated_at = datetime('now')`,
+		NewID(), scope, scopeID, limitUSD, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	return db.getBudget(scope, scopeID)
+}
+
+// DeleteBudget removes a budget by ID. Returns false if none matched.
+func (db *FlowsDB) DeleteBudget(budgetID string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM llm_budgets WHERE budget_id = ?`, budgetID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ListBudgets returns every configured budget.
+func (db *FlowsDB) ListBudgets() ([]Budget, error) {
+	rows, err := db.Query(`
+		SELECT budget_id, scope, scope_id, limit_usd, created_by, created_at, updated_at
+		FROM llm_budgets ORDER BY scope, scope_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var budgets []Budget
+	for rows.Next() {
+		var b Budget
+		if err := rows.Scan(&b.BudgetID, &b.Scope, &b.ScopeID, &b.LimitUSD,
+			&b.CreatedBy, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
+
+// EffectiveBudget returns the budget that applies to scopeID: its own row
+// if any, else the scope default. Returns nil if the scope is uncapped.
+func (db *FlowsDB) EffectiveBudget(scope, scopeID string) (*Budget, error) {
+	var b Budget
+	err := db.QueryRow(`
+		SELECT budget_id, scope, scope_id, limit_usd, created_by, created_at, updated_at
+		FROM llm_budgets WHERE scope = ? AND scope_id IN (?, '')
+		ORDER BY scope_id = '' LIMIT 1`, scope, scopeID).
+		Scan(&b.BudgetID, &b.Scope, &b.ScopeID, &b.LimitUSD, &b.CreatedBy, &b.CreatedAt, &b.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (db *FlowsDB) getBudget(scope, scopeID string) (*Budget, error) {
+	var b Budget
+	err := db.QueryRow(`
+		SELECT budget_id, scope, scope_id, limit_usd, created_by, created_at, updated_at
+		FROM llm_budgets WHERE scope = ? AND scope_id = ?`, scope, scopeID).
+		Scan(&b.BudgetID, &b.Scope, &b.ScopeID, &b.LimitUSD, &b.CreatedBy, &b.CreatedAt, &b.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// --- Spend ledger ---
+
+// RecordSpend appends a priced LLM call to the ledger.
+func (db *FlowsDB) RecordSpend(userID, runID, provider, model string, tokensIn, tokensOut int, costUSD float64) error {
+	_, err := db.Exec(`
+		INSERT INTO llm_spend (user_id, run_id, provider, model, tokens_in, tokens_out, cost_usd)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		nilIfEmpty(userID), nilIfEmpty(runID), provider, model, tokensIn, tokensOut, costUSD)
+	return err
+}
+
+// SpentUSD returns the spend counted against a budget scope: today's spend
+// for a user or the instance, the total spend of a run.
+func (db *FlowsDB) SpentUSD(scope, scopeID string) (float64, error) {
+	var query string
+	var args []interface{}
+	switch scope {
+	case BudgetScopeUser:
+		query = `SELECT COALESCE(SUM(cost_usd), 0) FROM llm_spend WHERE user_id = ? AND day = date('now')`
+		args = append(args, scopeID)
+	case BudgetScopeRun:
+		query = `SELECT COALESCE(SUM(cost_usd), 0) FROM llm_spend WHERE run_id = ?`
+		args = append(args, scopeID)
+	default:
+		query = `SELECT COALESCE(SUM(cost_usd), 0) FROM llm_spend WHERE day = date('now')`
+	}
+	var spent float64
+	err := db.QueryRow(query, args...).Scan(&spent)
+	return spent, err
+}
+
+// SpendRow aggregates ledger entries for one provider/model.
+type SpendRow struct {
+	Provider  string  `json:"provider"`
+	Model     string  `json:"model"`
+	Calls     int     `json:"calls"`
+	TokensIn  int     `json:"tokens_in"`
+	TokensOut int     `json:"tokens_out"`
+	CostUSD   float64 `json:"cost_usd"`
+}
+
+// SpendByModel aggregates the ledger over the last `days` UTC days
+// (today included), optionally restricted to one user.
+func (db *FlowsDB) SpendByModel(userID string, days int) ([]SpendRow, error) {
+	query := `SELECT provider, model, COUNT(*), COALESCE(SUM(tokens_in),0), COALESCE(SUM(tokens_out),0), SUM(cost_usd)
+		FROM llm_spend WHERE day > date('now', ?)`
+	args := []interface{}{fmt.Sprintf("-%d days", days)}
+	if userID != "" {
+		query += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` GROUP BY provider, model ORDER BY SUM(cost_usd) DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SpendRow
+	for rows.Next() {
+		var s SpendRow
+		if err := rows.Scan(&s.Provider, &s.Model, &s.Calls, &s.TokensIn, &s.TokensOut, &s.CostUSD); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// ReplayCandidateUsage sums the recorded token usage of the original (non
+// replay) flow steps a bulk replay would pick up, optionally filtered by model.
+func (db *FlowsDB) ReplayCandidateUsage(filterModel string) (steps, tokensIn, tokensOut int, err error) {
+	query := `SELECT COUNT(*), COALESCE(SUM(tokens_in),0), COALESCE(SUM(tokens_out),0)
+		FROM flow_steps WHERE replay_of_id IS NULL`
+	var args []interface{}
+	if filterModel != "" {
+		query += ` AND model_id = ?`
+		args = append(args, filterModel)
+	}
+	err = db.QueryRow(query, args...).Scan(&steps, &tokensIn, &tokensOut)
+	return steps, tokensIn, tokensOut, err
+}
@@ -14,6 +14,7 @@ import (
 
 type DB struct {
 	*sql.DB
+	nodeCreated func(*Node) // see OnNodeCreated
 }
 
 func Open(path string) (*DB, error) {
@@ -34,7 +35,7 @@ func Open(path string) (*DB, error) {
 		return nil, fmt.Errorf("pinging database: %w", err)
 	}
 
-	db := &DB{sqlDB}
+	db := &DB{DB: sqlDB}
 	if err := db.migrate(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("migrating database: %w", err)
@@ -59,6 +60,10 @@ func (db *DB) safeAlter() error {
 		`ALTER TABLE nodes ADD COLUMN deleted_at DATETIME`,
 		`ALTER TABLE nodes ADD COLUMN decomposed_from TEXT REFERENCES nodes(id)`,
 		`ALTER TABLE sources ADD COLUMN content_text TEXT`,
+		`ALTER TABLE challenges ADD COLUMN workflow_run_id TEXT`,
+		`ALTER TABLE providers ADD COLUMN approved_by TEXT`,
+		`ALTER TABLE providers ADD COLUMN approved_at DATETIME`,
+		`ALTER TABLE providers ADD COLUMN allow_private INTEGER DEFAULT 0`,
 	}
 	for _, stmt := range alters {
 		if _, err := db.Exec(stmt); err != nil {
@@ -0,0 +1,332 @@
+// CLAUDE:SUMMARY Vector store — node and source embeddings as float32 BLOBs keyed by embedder, pending-item scans and brute-force cosine nearest neighbours
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Embedding is one stored vector. Embedder names what produced it
+// ("provider/model"); vectors of different embedders are never compared.
+type Embedding struct {
+	OwnerType   string    `json:"owner_type"` // node or source
+	OwnerID     string    `json:"owner_id"`
+	Embedder    string    `json:"embedder"`
+	Vector      []float32 `json:"-"`
+	ContentHash string    `json:"content_hash"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// EmbeddingItem is the text of a node or source to embed.
+type EmbeddingItem struct {
+	OwnerType   string
+	OwnerID     string
+	Text        string
+	ContentHash string
+}
+
+// Neighbor is a nearest-neighbour hit; Score is the cosine similarity.
+type Neighbor struct {
+	OwnerType string  `json:"owner_type"`
+	OwnerID   string  `json:"owner_id"`
+	Score     float64 `json:"score"`
+}
+
+// NeighborQuery selects the candidates of NearestEmbeddings. Only vectors
+// of public, non-deleted nodes (or sources attached to one) are searched.
+type NeighborQuery struct {
+	OwnerType string
+	Embedder  string
+	Vector    []float32
+	K         int
+	MinScore  float64
+	RootsOnly bool     // nodes only: root nodes, one per tree
+	Exclude   []string // owner ids to skip
+}
+
+// ContentHash is the hash stored with a vector to detect stale embeddings.
+func ContentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// EncodeVector packs a vector as little-endian float32s.
+func EncodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+// DecodeVector unpacks a vector written by EncodeVector.
+func DecodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+// PutEmbedding stores e, replacing any vector of the same owner and embedder.
+func (db *DB) PutEmbedding(e Embedding) error {
+	if e.OwnerType != "node" && e.OwnerType != "source" {
+		return fmt.Errorf("invalid owner type %q", e.OwnerType)
+	}
+	_, err := db.Exec(`
+		INSERT INTO embeddings (owner_type, owner_id, embedder, dims, vector, content_hash)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(owner_type, owner_id, embedder) DO UPDATE SET
+			dims = excluded.dims, vector = excluded.vector,
+			content_hash = excluded.content_hash, created_at = datetime('now')`,
+		e.OwnerType, e.OwnerID, e.Embedder, len(e.Vector), EncodeVector(e.Vector), e.ContentHash)
+	return err
+}
+
+// GetEmbedding returns the vector of an owner for embedder, or (nil, nil).
+func (db *DB) GetEmbedding(ownerType, ownerID, embedder string) (*Embedding, error) {
+	e := Embedding{OwnerType: ownerType, OwnerID: ownerID, Embedder: embedder}
+	var blob []byte
+	err := db.QueryRow(`SELECT vector, content_hash, created_at FROM embeddings
+		WHERE owner_type = ? AND owner_id = ? AND embedder = ?`, ownerType, ownerID, embedder).
+		Scan(&blob, &e.ContentHash, &e.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.Vector = DecodeVector(blob)
+	return &e, nil
+}
+
+// Only public content is embedded: vectors may be computed by a remote
+// provider and are served by the nearest-neighbour API.
+const (
+	embeddableNodeText = `SELECT n.id, n.body FROM nodes n
+		WHERE n.visibility = 'public' AND n.deleted_at IS NULL`
+	embeddableSourceText = `SELECT s.id, TRIM(COALESCE(s.title,'') || char(10) || COALESCE(s.content_text, s.url, ''))
+		FROM sources s JOIN nodes n ON n.id = s.node_id
+		WHERE n.visibility = 'public' AND n.deleted_at IS NULL`
+)
+
+// EmbeddingText returns the text to embed for a node or source, or
+// (nil, nil) if it does not exist or is not public.
+func (db *DB) EmbeddingText(ownerType, ownerID string) (*EmbeddingItem, error) {
+	var query string
+	switch ownerType {
+	case "node":
+		query = embeddableNodeText + ` AND n.id = ?`
+	case "source":
+		query = embeddableSourceText + ` AND s.id = ?`
+	default:
+		return nil, fmt.Errorf("invalid owner type %q", ownerType)
+	}
+	item := EmbeddingItem{OwnerType: ownerType}
+	err := db.QueryRow(query, ownerID).Scan(&item.OwnerID, &item.Text)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	item.ContentHash = ContentHash(item.Text)
+	return &item, nil
+}
+
+// PendingEmbeddings returns up to limit public nodes or sources that have
+// no vector for embedder yet, oldest first.
+func (db *DB) PendingEmbeddings(ownerType, embedder string, limit int) ([]EmbeddingItem, error) {
+	var query string
+	switch ownerType {
+	case "node":
+		query = embeddableNodeText + ` AND NOT EXISTS (SELECT 1 FROM embeddings e
+			WHERE e.owner_type = 'node' AND e.owner_id = n.id AND e.embedder = ?)
+			ORDER BY n.created_at LIMIT ?`
+	case "source":
+		query = embeddableSourceText + ` AND NOT EXISTS (SELECT 1 FROM embeddings e
+			WHERE e.owner_type = 'source' AND e.owner_id = s.id AND e.embedder = ?)
+			ORDER BY s.created_at LIMIT ?`
+	default:
+		return nil, fmt.Errorf("invalid owner type %q", ownerType)
+	}
+	rows, err := db.Query(query, embedder, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []EmbeddingItem
+	for rows.Next() {
+		item := EmbeddingItem{OwnerType: ownerType}
+		if err := rows.Scan(&item.OwnerID, &item.Text); err != nil {
+			return nil, err
+		}
+		item.ContentHash = ContentHash(item.Text)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// CountEmbeddings returns the number of vectors per owner type for embedder.
+func (db *DB) CountEmbeddings(embedder string) (map[string]int, error) {
+	rows, err := db.Query(`SELECT owner_type, COUNT(*) FROM embeddings WHERE embedder = ? GROUP BY owner_type`, embedder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{"node": 0, "source": 0}
+	for rows.Next() {
+		var ownerType string
+		var n int
+		if err := rows.Scan(&ownerType, &n); err != nil {
+			return nil, err
+		}
+		counts[ownerType] = n
+	}
+	return counts, rows.Err()
+}
+
+// NearestEmbeddings scans every candidate vector of q.Embedder and returns
+// the q.K most similar to q.Vector (cosine, vectors being unit length),
+// best first. Vectors of another dimension are skipped.
+func (db *DB) NearestEmbeddings(q NeighborQuery) ([]Neighbor, error) {
+	if q.K <= 0 {
+		q.K = 10
+	}
+	query := `SELECT e.owner_id, e.vector FROM embeddings e `
+	switch q.OwnerType {
+	case "node":
+		query += `JOIN nodes n ON n.id = e.owner_id WHERE e.owner_type = 'node'`
+		if q.RootsOnly {
+			query += ` AND n.parent_id IS NULL`
+		}
+	case "source":
+		query += `JOIN sources s ON s.id = e.owner_id JOIN nodes n ON n.id = s.node_id WHERE e.owner_type = 'source'`
+	default:
+		return nil, fmt.Errorf("invalid owner type %q", q.OwnerType)
+	}
+	query += ` AND e.embedder = ? AND e.dims = ? AND n.visibility = 'public' AND n.deleted_at IS NULL`
+	args := []interface{}{q.Embedder, len(q.Vector)}
+	if len(q.Exclude) > 0 {
+		query += ` AND e.owner_id NOT IN (?` + strings.Repeat(",?", len(q.Exclude)-1) + `)`
+		for _, id := range q.Exclude {
+			args = append(args, id)
+		}
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var best []Neighbor // kept sorted, at most q.K long
+	for rows.Next() {
+		var id string
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
+			return nil, err
+		}
+		score := dot(q.Vector, DecodeVector(blob))
+		if score < q.MinScore || (len(best) == q.K && score <= best[len(best)-1].Score) {
+			continue
+		}
+		i := sort.Search(len(best), func(i int) bool { return best[i].Score < score })
+		best = append(best, Neighbor{})
+		copy(best[i+1:], best[i:])
+		best[i] = Neighbor{OwnerType: q.OwnerType, OwnerID: id, Score: score}
+		if len(best) > q.K {
+			best = best[:q.K]
+		}
+	}
+	return best, rows.Err()
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// EmbeddableTexts returns the texts of up to limit public nodes and up to
+// limit sources, newest first: the corpus the local embedder is fitted on.
+func (db *DB) EmbeddableTexts(limit int) ([]string, error) {
+	var texts []string
+	for _, query := range []string{
+		embeddableNodeText + ` ORDER BY n.created_at DESC LIMIT ?`,
+		embeddableSourceText + ` ORDER BY s.created_at DESC LIMIT ?`,
+	} {
+		rows, err := db.Query(query, limit)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, text string
+			if err := rows.Scan(&id, &text); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			texts = append(texts, text)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return texts, nil
+}
+
+// LocalEmbedderFit is a stored IDF table of the local embedder.
+type LocalEmbedderFit struct {
+	Model     string
+	Docs      int
+	IDF       []float32
+	CreatedAt time.Time
+}
+
+// LatestLocalEmbedderFit returns the most recent fit, or (nil, nil).
+func (db *DB) LatestLocalEmbedderFit() (*LocalEmbedderFit, error) {
+	var f LocalEmbedderFit
+	var blob []byte
+	err := db.QueryRow(`SELECT model, docs, idf, created_at FROM local_embedder_fits
+		ORDER BY created_at DESC, rowid DESC LIMIT 1`).Scan(&f.Model, &f.Docs, &blob, &f.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.IDF = DecodeVector(blob)
+	return &f, nil
+}
+
+// PutLocalEmbedderFit stores a new fit, making it the latest.
+func (db *DB) PutLocalEmbedderFit(f LocalEmbedderFit) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO local_embedder_fits (model, docs, idf) VALUES (?, ?, ?)`,
+		f.Model, f.Docs, EncodeVector(f.IDF))
+	return err
+}
+
+// DeleteEmbeddingsExcept removes the vectors of every embedder starting
+// with prefix other than keep, and returns how many were removed.
+func (db *DB) DeleteEmbeddingsExcept(prefix, keep string) (int64, error) {
+	res, err := db.Exec(`DELETE FROM embeddings WHERE substr(embedder, 1, ?) = ? AND embedder != ?`,
+		len(prefix), prefix, keep)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -2,10 +2,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
@@ -54,9 +56,217 @@ func (db *FlowsDB) migrate() error {
 
 	// v2: owner_id on available_models (NULL = auto-discovered, non-NULL = provider-registered)
 	_, _ = db.Exec(`ALTER TABLE available_models ADD COLUMN owner_id TEXT`)
+
+	// v3: cost accounting (USD, computed from model_prices at call time)
+	_, _ = db.Exec(`ALTER TABLE flow_steps ADD COLUMN cost_usd REAL`)
+	_, _ = db.Exec(`ALTER TABLE workflow_step_runs ADD COLUMN cost_usd REAL`)
+
+	// v4: response cache — 1 when the response was served from llm_cache
+	_, _ = db.Exec(`ALTER TABLE flow_steps ADD COLUMN cached INTEGER NOT NULL DEFAULT 0`)
+	_, _ = db.Exec(`ALTER TABLE workflow_step_runs ADD COLUMN cached INTEGER NOT NULL DEFAULT 0`)
+
+	// v5: tool calling — JSON array of the step's tool round-trips
+	_, _ = db.Exec(`ALTER TABLE flow_steps ADD COLUMN tool_calls TEXT`)
+	_, _ = db.Exec(`ALTER TABLE workflow_step_runs ADD COLUMN tool_calls TEXT`)
+
+	// v6: flow loops — a step may run once per round, step_index is the run order
+	_, _ = db.Exec(`ALTER TABLE flow_steps ADD COLUMN step_name TEXT`)
+	_, _ = db.Exec(`ALTER TABLE flow_steps ADD COLUMN round INTEGER`)
+
+	// v7: challenge flows run as workflows of type 'challenge'
+	if err := db.migrateWorkflowTypes(); err != nil {
+		return fmt.Errorf("adding challenge workflow type: %w", err)
+	}
+
+	// v8: step DAG — JSON array of the step names a step waits for (NULL = the previous step_order)
+	_, _ = db.Exec(`ALTER TABLE workflow_steps ADD COLUMN depends_on TEXT`)
+
+	// v9: resumable runs — the run's input beyond its own columns (body, role, target, flow)
+	_, _ = db.Exec(`ALTER TABLE workflow_runs ADD COLUMN input_json TEXT`)
+
+	// v10: immutable versions — steps belong to a version, runs record the one they executed
+	_, _ = db.Exec(`ALTER TABLE workflows ADD COLUMN active_version INTEGER`)
+	_, _ = db.Exec(`ALTER TABLE workflow_runs ADD COLUMN workflow_version INTEGER`)
+	if err := db.migrateStepVersions(); err != nil {
+		return fmt.Errorf("versioning workflow steps: %w", err)
+	}
+
+	// v11: node_write steps write their output into the node tree
+	if err := db.migrateStepTypes(); err != nil {
+		return fmt.Errorf("adding node_write step type: %w", err)
+	}
+
+	// v12: prices read from provider listings are 'discovered', not 'seed'
+	if err := db.migratePriceSources(); err != nil {
+		return fmt.Errorf("adding discovered price source: %w", err)
+	}
+	return db.seedModelPrices()
+}
+
+// migrateStepVersions rebuilds the workflow_steps table of a database
+// created before versions, whose unique key would keep two versions of a
+// workflow from holding the same step, and records the existing workflows
+// as their first version.
+func (db *FlowsDB) migrateStepVersions() error {
+	var ddl string
+	_ = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='workflow_steps'`).Scan(&ddl)
+	if ddl != "" && !strings.Contains(ddl, "version") {
+		ddl = strings.Replace(ddl, "workflow_steps", "workflow_steps_new", 1)
+		ddl = strings.Replace(ddl, "UNIQUE(workflow_id, step_order, step_name)", "UNIQUE(workflow_id, version, step_order, step_name)", 1)
+		ddl = strings.Replace(ddl, "step_order    INTEGER NOT NULL,", "version       INTEGER NOT NULL DEFAULT 1,\n    step_order    INTEGER NOT NULL,", 1)
+		if !strings.Contains(ddl, "version       INTEGER") {
+			return fmt.Errorf("unexpected workflow_steps definition")
+		}
+
+		ctx := context.Background()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON") //nolint:errcheck
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() //nolint:errcheck
+		const cols = `step_id, workflow_id, step_order, step_name, step_type, provider, model,
+			prompt_template, system_prompt, config_json, criteria_list_id, timeout_ms, retry_max,
+			fan_group, depends_on, created_at`
+		for _, stmt := range []string{
+			ddl,
+			`INSERT INTO workflow_steps_new (` + cols + `, version)
+				SELECT ` + cols + `, COALESCE((SELECT w.version FROM workflows w WHERE w.workflow_id = workflow_steps.workflow_id), 1)
+				FROM workflow_steps`,
+			`DROP TABLE workflow_steps`,
+			`ALTER TABLE workflow_steps_new RENAME TO workflow_steps`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		// Dropping the old table dropped its indexes.
+		if _, err := db.Exec(flowsSchema); err != nil {
+			return err
+		}
+	}
+
+	// Workflows from before versions are their own first version.
+	_, _ = db.Exec(`UPDATE workflows SET active_version = version WHERE status = 'active' AND active_version IS NULL`)
+	_, _ = db.Exec(`
+		UPDATE workflow_runs SET workflow_version = (SELECT w.version FROM workflows w WHERE w.workflow_id = workflow_runs.workflow_id)
+		WHERE workflow_version IS NULL`)
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO workflow_versions (workflow_id, version, status, name, description, pre_prompt_template,
+			created_by, created_at, validated_by, validated_at, rejection_reason)
+		SELECT workflow_id, version, status, name, description, pre_prompt_template,
+			owner_id, created_at, validated_by, validated_at, rejection_reason
+		FROM workflows`)
+	return err
+}
+
+// migrateWorkflowTypes rebuilds the workflows table of a database created
+// before the 'challenge' type, since SQLite cannot alter a CHECK constraint.
+func (db *FlowsDB) migrateWorkflowTypes() error {
+	var ddl string
+	_ = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='workflows'`).Scan(&ddl)
+	if ddl == "" || strings.Contains(ddl, "'challenge'") {
+		return nil
+	}
+	return db.rebuildTable("workflows", strings.Replace(ddl, "'model_discovery'", "'model_discovery','challenge'", 1))
+}
+
+// migrateStepTypes rebuilds the workflow_steps table of a database created
+// before the 'node_write' step type.
+func (db *FlowsDB) migrateStepTypes() error {
+	var ddl string
+	_ = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='workflow_steps'`).Scan(&ddl)
+	if ddl == "" || strings.Contains(ddl, "'node_write'") {
+		return nil
+	}
+	ddl = strings.Replace(ddl, "'check')", "'check','node_write')", 1)
+	if !strings.Contains(ddl, "'node_write'") {
+		return fmt.Errorf("unexpected workflow_steps definition")
+	}
+	return db.rebuildTable("workflow_steps", ddl)
+}
+
+// migratePriceSources rebuilds the model_prices table of a database created
+// before the 'discovered' source. Discovery stored its prices as 'seed'
+// then; those of models without a default price are relabelled.
+func (db *FlowsDB) migratePriceSources() error {
+	var ddl string
+	_ = db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='model_prices'`).Scan(&ddl)
+	if ddl == "" || strings.Contains(ddl, "'discovered'") {
+		return nil
+	}
+	ddl = strings.Replace(ddl, "'seed','operator')", "'seed','operator','discovered')", 1)
+	if !strings.Contains(ddl, "'discovered'") {
+		return fmt.Errorf("unexpected model_prices definition")
+	}
+	if err := db.rebuildTable("model_prices", ddl); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE model_prices SET source = 'discovered' WHERE source = 'seed'`); err != nil {
+		return err
+	}
+	for _, p := range defaultModelPrices {
+		if _, err := db.Exec(`UPDATE model_prices SET source = 'seed' WHERE provider = ? AND model_name = ? AND source = 'discovered'`,
+			p.Provider, p.ModelName); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// rebuildTable replaces table with one created by ddl, its new definition
+// with the same columns, and copies its rows over. The new table is renamed
+// into place so that the tables referencing this one keep doing so.
+func (db *FlowsDB) rebuildTable(table, ddl string) error {
+	ddl = strings.Replace(ddl, table, table+"_new", 1)
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Foreign keys can only be switched off outside a transaction.
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON") //nolint:errcheck
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	for _, stmt := range []string{
+		ddl,
+		`INSERT INTO ` + table + `_new SELECT * FROM ` + table,
+		`DROP TABLE ` + table,
+		`ALTER TABLE ` + table + `_new RENAME TO ` + table,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Dropping the old table dropped its indexes.
+	_, err = db.Exec(flowsSchema)
+	return err
+}
+
 const flowsSchema = `
 -- flow_steps: forensic trace for every LLM call
 CREATE TABLE IF NOT EXISTS flow_steps (
@@ -159,7 +369,8 @@ CREATE TABLE IF NOT EXISTS workflows (
     workflow_type TEXT NOT NULL CHECK(workflow_type IN (
         'decompose','critique','source','factcheck','analyse','synthese',
         'reformulation','media_export','contradiction_detection','completude',
-        'traduction','classification_epistemique','workflow_validation','model_discovery'
+        'traduction','classification_epistemique','workflow_validation','model_discovery',
+        'challenge'
     )),
     owner_id      TEXT NOT NULL,
     owner_role    TEXT NOT NULL,
@@ -172,7 +383,8 @@ CREATE TABLE IF NOT EXISTS workflows (
     updated_at    DATETIME DEFAULT (datetime('now')),
     validated_by  TEXT,
     validated_at  DATETIME,
-    rejection_reason TEXT
+    rejection_reason TEXT,
+    active_version INTEGER
 );
 CREATE INDEX IF NOT EXISTS idx_workflows_status ON workflows(status);
 CREATE INDEX IF NOT EXISTS idx_workflows_owner ON workflows(owner_id);
@@ -182,9 +394,10 @@ CREATE INDEX IF NOT EXISTS idx_workflows_type ON workflows(workflow_type);
 CREATE TABLE IF NOT EXISTS workflow_steps (
     step_id       TEXT PRIMARY KEY,
     workflow_id   TEXT NOT NULL REFERENCES workflows(workflow_id),
+    version       INTEGER NOT NULL DEFAULT 1,
     step_order    INTEGER NOT NULL,
     step_name     TEXT NOT NULL,
-    step_type     TEXT NOT NULL CHECK(step_type IN ('llm','sql','http','check')),
+    step_type     TEXT NOT NULL CHECK(step_type IN ('llm','sql','http','check','node_write')),
     provider      TEXT,
     model         TEXT,
     prompt_template  TEXT,
@@ -194,12 +407,32 @@ CREATE TABLE IF NOT EXISTS workflow_steps (
     timeout_ms       INTEGER DEFAULT 30000,
     retry_max        INTEGER DEFAULT 2,
     fan_group        TEXT,
+    depends_on       TEXT,
     created_at       DATETIME DEFAULT (datetime('now')),
-    UNIQUE(workflow_id, step_order, step_name)
+    UNIQUE(workflow_id, version, step_order, step_name)
 );
 CREATE INDEX IF NOT EXISTS idx_wf_steps_workflow ON workflow_steps(workflow_id);
 CREATE INDEX IF NOT EXISTS idx_wf_steps_order ON workflow_steps(workflow_id, step_order);
 
+-- workflow_versions: every version of a workflow, frozen once it leaves draft.
+-- workflows holds the latest version; active_version there is the one runs use.
+CREATE TABLE IF NOT EXISTS workflow_versions (
+    workflow_id   TEXT NOT NULL REFERENCES workflows(workflow_id),
+    version       INTEGER NOT NULL,
+    status        TEXT NOT NULL DEFAULT 'draft' CHECK(status IN (
+        'draft','pending_validation','validated','rejected','active','superseded','archived'
+    )),
+    name          TEXT NOT NULL,
+    description   TEXT,
+    pre_prompt_template TEXT,
+    created_by    TEXT,
+    created_at    DATETIME DEFAULT (datetime('now')),
+    validated_by  TEXT,
+    validated_at  DATETIME,
+    rejection_reason TEXT,
+    PRIMARY KEY (workflow_id, version)
+);
+
 -- criteria_lists: reusable criteria referenced by check steps and prompt templates
 CREATE TABLE IF NOT EXISTS criteria_lists (
     list_id     TEXT PRIMARY KEY,
@@ -227,6 +460,65 @@ CREATE TABLE IF NOT EXISTS available_models (
 CREATE INDEX IF NOT EXISTS idx_avail_models_provider ON available_models(provider);
 CREATE INDEX IF NOT EXISTS idx_avail_models_available ON available_models(is_available);
 
+-- model_prices: USD per million tokens, matched on provider + model name prefix
+CREATE TABLE IF NOT EXISTS model_prices (
+    provider        TEXT NOT NULL,
+    model_name      TEXT NOT NULL,
+    input_per_mtok  REAL NOT NULL DEFAULT 0,
+    output_per_mtok REAL NOT NULL DEFAULT 0,
+    source          TEXT NOT NULL DEFAULT 'seed' CHECK(source IN ('seed','operator','discovered')),
+    updated_by      TEXT,
+    updated_at      DATETIME DEFAULT (datetime('now')),
+    PRIMARY KEY(provider, model_name)
+);
+
+-- llm_budgets: spend caps per user (per UTC day), per workflow run, per instance (per UTC day)
+-- scope_id '' is the default applied to every user / run without a row of its own
+CREATE TABLE IF NOT EXISTS llm_budgets (
+    budget_id   TEXT PRIMARY KEY,
+    scope       TEXT NOT NULL CHECK(scope IN ('user','run','instance')),
+    scope_id    TEXT NOT NULL DEFAULT '',
+    limit_usd   REAL NOT NULL,
+    created_by  TEXT NOT NULL,
+    created_at  DATETIME DEFAULT (datetime('now')),
+    updated_at  DATETIME DEFAULT (datetime('now')),
+    UNIQUE(scope, scope_id)
+);
+
+-- llm_spend: one row per priced LLM call — the ledger budgets are checked against
+CREATE TABLE IF NOT EXISTS llm_spend (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id     TEXT,
+    run_id      TEXT,
+    provider    TEXT NOT NULL,
+    model       TEXT NOT NULL,
+    tokens_in   INTEGER,
+    tokens_out  INTEGER,
+    cost_usd    REAL NOT NULL,
+    day         TEXT NOT NULL DEFAULT (date('now')),
+    created_at  DATETIME DEFAULT (datetime('now'))
+);
+CREATE INDEX IF NOT EXISTS idx_llm_spend_day ON llm_spend(day);
+CREATE INDEX IF NOT EXISTS idx_llm_spend_user ON llm_spend(user_id, day) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_llm_spend_run ON llm_spend(run_id) WHERE run_id IS NOT NULL;
+
+-- llm_cache: content-addressed LLM responses (opt-in, keyed by a hash of provider, model, messages, sampling params)
+CREATE TABLE IF NOT EXISTS llm_cache (
+    cache_key     TEXT PRIMARY KEY,
+    provider      TEXT NOT NULL,
+    model         TEXT NOT NULL,
+    content       TEXT NOT NULL,
+    tokens_in     INTEGER,
+    tokens_out    INTEGER,
+    finish_reason TEXT,
+    cost_usd      REAL,
+    hits          INTEGER NOT NULL DEFAULT 0,
+    expires_at    DATETIME,
+    last_hit_at   DATETIME,
+    created_at    DATETIME DEFAULT (datetime('now'))
+);
+CREATE INDEX IF NOT EXISTS idx_llm_cache_expires ON llm_cache(expires_at) WHERE expires_at IS NOT NULL;
+
 -- workflow_runs: each execution of a workflow
 CREATE TABLE IF NOT EXISTS workflow_runs (
     run_id          TEXT PRIMARY KEY,
@@ -237,6 +529,8 @@ CREATE TABLE IF NOT EXISTS workflow_runs (
         'pending','running','completed','failed','cancelled'
     )),
     pre_prompt      TEXT,
+    input_json      TEXT,
+    workflow_version INTEGER,
     batch_id        TEXT,
     total_steps     INTEGER,
     completed_steps INTEGER DEFAULT 0,
@@ -287,6 +581,52 @@ CREATE TABLE IF NOT EXISTS workflow_audit_log (
 CREATE INDEX IF NOT EXISTS idx_wf_audit_run ON workflow_audit_log(run_id);
 CREATE INDEX IF NOT EXISTS idx_wf_audit_type ON workflow_audit_log(event_type);
 
+-- workflow_triggers: start runs of an active workflow on a cron schedule or a domain event
+CREATE TABLE IF NOT EXISTS workflow_triggers (
+    trigger_id      TEXT PRIMARY KEY,
+    workflow_id     TEXT NOT NULL REFERENCES workflows(workflow_id),
+    name            TEXT NOT NULL,
+    trigger_type    TEXT NOT NULL CHECK(trigger_type IN (
+        'cron','node_created','temperature_hot','source_added','challenge_completed'
+    )),
+    schedule        TEXT,
+    tag             TEXT,
+    node_id         TEXT,
+    body            TEXT,
+    pre_prompt      TEXT,
+    run_as          TEXT NOT NULL,
+    rate_limit      INTEGER NOT NULL DEFAULT 10,
+    rate_window_sec INTEGER NOT NULL DEFAULT 3600,
+    enabled         INTEGER NOT NULL DEFAULT 1,
+    created_by      TEXT NOT NULL,
+    next_run_at     DATETIME,
+    last_fired_at   DATETIME,
+    created_at      DATETIME DEFAULT (datetime('now')),
+    updated_at      DATETIME DEFAULT (datetime('now'))
+);
+CREATE INDEX IF NOT EXISTS idx_wf_triggers_workflow ON workflow_triggers(workflow_id);
+CREATE INDEX IF NOT EXISTS idx_wf_triggers_type ON workflow_triggers(trigger_type, enabled);
+
+-- workflow_trigger_fires: history of every trigger firing, including the ones held back
+CREATE TABLE IF NOT EXISTS workflow_trigger_fires (
+    fire_id         TEXT PRIMARY KEY,
+    trigger_id      TEXT NOT NULL REFERENCES workflow_triggers(trigger_id),
+    event_type      TEXT NOT NULL,
+    node_id         TEXT,
+    run_id          TEXT,
+    status          TEXT NOT NULL CHECK(status IN ('fired','rate_limited','skipped','failed')),
+    reason          TEXT,
+    fired_at        DATETIME DEFAULT (datetime('now'))
+);
+CREATE INDEX IF NOT EXISTS idx_wf_trigger_fires ON workflow_trigger_fires(trigger_id, fired_at);
+
+-- instance_keys: this instance's private keys (workflow bundle signing), generated on first use
+CREATE TABLE IF NOT EXISTS instance_keys (
+    name        TEXT PRIMARY KEY,
+    private_key BLOB NOT NULL,
+    created_at  DATETIME DEFAULT (datetime('now'))
+);
+
 -- model_grants: granular access control for provider/model per user or role
 CREATE TABLE IF NOT EXISTS model_grants (
     grant_id     TEXT PRIMARY KEY,
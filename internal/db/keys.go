@@ -0,0 +1,25 @@
+// CLAUDE:SUMMARY Instance keys — private keys generated on first use and kept in flows.db, shared by the server and the CLI
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// InstanceKey returns the private key stored under name, storing the one
+// generate makes on first use. Concurrent first uses end up with the same key.
+func (db *FlowsDB) InstanceKey(name string, generate func() ([]byte, error)) ([]byte, error) {
+	var key []byte
+	err := db.QueryRow(`SELECT private_key FROM instance_keys WHERE name = ?`, name).Scan(&key)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return key, err
+	}
+	if key, err = generate(); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`INSERT OR IGNORE INTO instance_keys (name, private_key) VALUES (?, ?)`, name, key); err != nil {
+		return nil, err
+	}
+	err = db.QueryRow(`SELECT private_key FROM instance_keys WHERE name = ?`, name).Scan(&key)
+	return key, err
+}
@@ -1,11 +1,15 @@
-// CLAUDE:SUMMARY MetricsDB — separate SQLite database for Go native metrics (HTTP requests, runtime stats)
+// CLAUDE:SUMMARY MetricsDB — separate SQLite database for Go native metrics (HTTP requests, MCP tool calls, LLM calls), latency percentiles and daily rollups
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -43,8 +47,12 @@ func OpenMetrics(path string) (*MetricsDB, error) {
 }
 
 func (db *MetricsDB) migrate() error {
-	_, err := db.Exec(metricsSchema)
-	return err
+	if _, err := db.Exec(metricsSchema); err != nil {
+		return err
+	}
+	// Safe column additions (ignore errors if columns already exist)
+	_, _ = db.Exec(`ALTER TABLE llm_calls ADD COLUMN cost_usd REAL`)
+	return nil
 }
 
 const metricsSchema = `
@@ -104,12 +112,246 @@ func (db *MetricsDB) RecordHTTPRequest(method, path string, statusCode, duration
 		VALUES (?, ?, ?, ?, ?)`, method, path, statusCode, durationMs, userID)
 }
 
-// RecordLLMCall logs an LLM provider call metric.
-func (db *MetricsDB) RecordLLMCall(provider, model string, tokensIn, tokensOut, latencyMs int, success bool, errMsg string) {
+// RecordLLMCall logs an LLM provider call metric, with its cost in USD.
+func (db *MetricsDB) RecordLLMCall(provider, model string, tokensIn, tokensOut, latencyMs int, costUSD float64, success bool, errMsg string) {
 	s := 1
 	if !success {
 		s = 0
 	}
-	_, _ = db.Exec(`INSERT INTO llm_calls (provider, model, tokens_in, tokens_out, latency_ms, success, error)
-		VALUES (?, ?, ?, ?, ?, ?, ?)`, provider, model, tokensIn, tokensOut, latencyMs, s, errMsg)
+	_, _ = db.Exec(`INSERT INTO llm_calls (provider, model, tokens_in, tokens_out, latency_ms, cost_usd, success, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, provider, model, tokensIn, tokensOut, latencyMs, costUSD, s, errMsg)
+}
+
+// RecordMCPCall logs an MCP tool call metric.
+func (db *MetricsDB) RecordMCPCall(toolName string, durationMs int, success bool, userID, transport string) {
+	_, _ = db.Exec(`INSERT INTO mcp_calls (tool_name, duration_ms, success, user_id, transport)
+		VALUES (?, ?, ?, ?, ?)`, toolName, durationMs, boolToInt(success), userID, transport)
+}
+
+// LatencyStats summarises a group of timed calls. Percentiles use the
+// nearest-rank method.
+type LatencyStats struct {
+	Calls     int     `json:"calls"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P50Ms     int     `json:"p50_ms"`
+	P95Ms     int     `json:"p95_ms"`
+}
+
+// LLMCallStats aggregates llm_calls for one provider/model.
+type LLMCallStats struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	LatencyStats
+	TokensIn  int     `json:"tokens_in"`
+	TokensOut int     `json:"tokens_out"`
+	CostUSD   float64 `json:"cost_usd"`
+}
+
+// RouteStats aggregates http_requests for one method + route pattern.
+// Errors counts 5xx responses; ClientErrors counts 4xx.
+type RouteStats struct {
+	Method string `json:"method"`
+	Route  string `json:"route"`
+	LatencyStats
+	ClientErrors int `json:"client_errors"`
+}
+
+// ToolStats aggregates mcp_calls for one tool.
+type ToolStats struct {
+	Tool string `json:"tool"`
+	LatencyStats
+}
+
+// latencyQuery builds a grouped aggregate over table with p50/p95 of the
+// latency column computed by window functions. okExpr is the SQL
+// expression that is true for a successful row; extra adds select columns.
+func latencyQuery(table, latency, okExpr string, group []string, extra string) string {
+	g := strings.Join(group, ", ")
+	return `WITH w AS (
+		SELECT *,
+			ROW_NUMBER() OVER (PARTITION BY ` + g + ` ORDER BY ` + latency + `) AS rn,
+			COUNT(*) OVER (PARTITION BY ` + g + `) AS n
+		FROM ` + table + ` WHERE timestamp >= ?
+	)
+	SELECT ` + g + `, COUNT(*), SUM(CASE WHEN ` + okExpr + ` THEN 0 ELSE 1 END),
+		COALESCE(MAX(CASE WHEN rn = (n * 50 + 99) / 100 THEN ` + latency + ` END), 0),
+		COALESCE(MAX(CASE WHEN rn = (n * 95 + 99) / 100 THEN ` + latency + ` END), 0)` + extra + `
+	FROM w GROUP BY ` + g + ` ORDER BY COUNT(*) DESC`
+}
+
+func (s *LatencyStats) finish() {
+	if s.Calls > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Calls)
+	}
+}
+
+// LLMStats returns per provider/model latency, error rate, token volume
+// and cost of LLM calls since the given time.
+func (db *MetricsDB) LLMStats(since time.Time) ([]LLMCallStats, error) {
+	rows, err := db.Query(latencyQuery("llm_calls", "latency_ms", "success = 1", []string{"provider", "model"},
+		`, COALESCE(SUM(tokens_in),0), COALESCE(SUM(tokens_out),0), COALESCE(SUM(cost_usd),0)`), since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []LLMCallStats{}
+	for rows.Next() {
+		var s LLMCallStats
+		if err := rows.Scan(&s.Provider, &s.Model, &s.Calls, &s.Errors, &s.P50Ms, &s.P95Ms,
+			&s.TokensIn, &s.TokensOut, &s.CostUSD); err != nil {
+			return nil, err
+		}
+		s.finish()
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// RouteStatsSince returns per route latency and error rate of HTTP requests.
+func (db *MetricsDB) RouteStatsSince(since time.Time) ([]RouteStats, error) {
+	rows, err := db.Query(latencyQuery("http_requests", "duration_ms", "status_code < 500", []string{"method", "path"},
+		`, SUM(CASE WHEN status_code BETWEEN 400 AND 499 THEN 1 ELSE 0 END)`), since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []RouteStats{}
+	for rows.Next() {
+		var s RouteStats
+		if err := rows.Scan(&s.Method, &s.Route, &s.Calls, &s.Errors, &s.P50Ms, &s.P95Ms, &s.ClientErrors); err != nil {
+			return nil, err
+		}
+		s.finish()
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// ToolStatsSince returns per tool latency and error rate of MCP calls.
+func (db *MetricsDB) ToolStatsSince(since time.Time) ([]ToolStats, error) {
+	rows, err := db.Query(latencyQuery("mcp_calls", "duration_ms", "success = 1", []string{"tool_name"}, ""), since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []ToolStats{}
+	for rows.Next() {
+		var s ToolStats
+		if err := rows.Scan(&s.Tool, &s.Calls, &s.Errors, &s.P50Ms, &s.P95Ms); err != nil {
+			return nil, err
+		}
+		s.finish()
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// DailyStat is one rolled-up metric value for a UTC day.
+type DailyStat struct {
+	Date   string  `json:"date"`
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+}
+
+// RollupDailyStats recomputes the daily_stats rows of one UTC day
+// (YYYY-MM-DD) from the raw tables. Re-running it for the same day
+// overwrites the previous values, so a partial day can be refreshed.
+func (db *MetricsDB) RollupDailyStats(date string) error {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("parsing rollup date: %w", err)
+	}
+	from, to := day.Unix(), day.AddDate(0, 0, 1).Unix()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// Each query yields (metric, value) rows; the WHERE true keeps SQLite's
+	// parser from reading ON CONFLICT as part of the SELECT.
+	queries := []string{
+		`SELECT 'http.requests', COUNT(*) FROM http_requests WHERE timestamp >= ? AND timestamp < ?`,
+		`SELECT 'http.errors', COUNT(*) FROM http_requests WHERE status_code >= 500 AND timestamp >= ? AND timestamp < ?`,
+		`SELECT 'http.avg_ms', COALESCE(AVG(duration_ms),0) FROM http_requests WHERE timestamp >= ? AND timestamp < ?`,
+		`SELECT 'mcp.calls', COUNT(*) FROM mcp_calls WHERE timestamp >= ? AND timestamp < ?`,
+		`SELECT 'mcp.errors', COUNT(*) FROM mcp_calls WHERE success = 0 AND timestamp >= ? AND timestamp < ?`,
+		`SELECT 'llm.calls', COUNT(*) FROM llm_calls WHERE timestamp >= ? AND timestamp < ?`,
+		`SELECT 'llm.errors', COUNT(*) FROM llm_calls WHERE success = 0 AND timestamp >= ? AND timestamp < ?`,
+		`SELECT 'llm.avg_ms', COALESCE(AVG(latency_ms),0) FROM llm_calls WHERE timestamp >= ? AND timestamp < ?`,
+		`SELECT 'llm.tokens_in', COALESCE(SUM(tokens_in),0) FROM llm_calls WHERE timestamp >= ? AND timestamp < ?`,
+		`SELECT 'llm.tokens_out', COALESCE(SUM(tokens_out),0) FROM llm_calls WHERE timestamp >= ? AND timestamp < ?`,
+		`SELECT 'llm.cost_usd', COALESCE(SUM(cost_usd),0) FROM llm_calls WHERE timestamp >= ? AND timestamp < ?`,
+		// Per-provider breakdown, e.g. "llm.calls:gemini"
+		`SELECT 'llm.calls:' || provider, COUNT(*) FROM llm_calls WHERE timestamp >= ? AND timestamp < ? GROUP BY provider`,
+		`SELECT 'llm.errors:' || provider, SUM(1 - success) FROM llm_calls WHERE timestamp >= ? AND timestamp < ? GROUP BY provider`,
+		`SELECT 'llm.tokens:' || provider, COALESCE(SUM(tokens_in),0) + COALESCE(SUM(tokens_out),0) FROM llm_calls WHERE timestamp >= ? AND timestamp < ? GROUP BY provider`,
+		`SELECT 'llm.cost_usd:' || provider, COALESCE(SUM(cost_usd),0) FROM llm_calls WHERE timestamp >= ? AND timestamp < ? GROUP BY provider`,
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(`INSERT INTO daily_stats (date, metric, value)
+			SELECT ?, * FROM (`+q+`) WHERE true
+			ON CONFLICT(date, metric) DO UPDATE SET value = excluded.value`, date, from, to); err != nil {
+			return fmt.Errorf("rolling up %s: %w", date, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// DailyStats returns rolled-up metrics for the last `days` UTC days,
+// optionally restricted to metrics starting with prefix.
+func (db *MetricsDB) DailyStats(days int, prefix string) ([]DailyStat, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+	rows, err := db.Query(`SELECT date, metric, value FROM daily_stats
+		WHERE date >= ? AND metric LIKE ? ORDER BY date, metric`, since, prefix+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []DailyStat{}
+	for rows.Next() {
+		var d DailyStat
+		if err := rows.Scan(&d.Date, &d.Metric, &d.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// RunDailyRollup refreshes today's daily_stats every interval until ctx is
+// done. Yesterday is rolled up once more on the first tick after midnight
+// so that its last hours are not lost.
+func (db *MetricsDB) RunDailyRollup(ctx context.Context, interval time.Duration) {
+	last := ""
+	rollup := func() {
+		today := time.Now().UTC().Format("2006-01-02")
+		if last != "" && last != today {
+			if err := db.RollupDailyStats(last); err != nil {
+				slog.Warn("daily stats rollup", "date", last, "error", err)
+			}
+		}
+		if err := db.RollupDailyStats(today); err != nil {
+			slog.Warn("daily stats rollup", "date", today, "error", err)
+		}
+		last = today
+	}
+
+	rollup()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			rollup()
+		}
+	}
 }
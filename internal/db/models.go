@@ -3,6 +3,10 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
@@ -22,21 +26,74 @@ type AvailableModel struct {
 	OwnerID          *string    `json:"owner_id,omitempty"`
 }
 
-// UpsertModel inserts or updates a model in the catalogue.
+// ModelCapabilities is what discovery learned about a model, stored in
+// available_models.capabilities_json. A nil flag or an empty list means the
+// provider does not say: only known limitations reject a workflow step.
+type ModelCapabilities struct {
+	MaxOutputTokens int      `json:"max_output_tokens,omitempty"`
+	Modalities      []string `json:"modalities,omitempty"` // accepted inputs: text, image, audio, video, file
+	JSON            *bool    `json:"json,omitempty"`       // structured output (JSON mode or schema)
+	Tools           *bool    `json:"tools,omitempty"`      // function calling
+	InputPerMTok    *float64 `json:"input_per_mtok,omitempty"`
+	OutputPerMTok   *float64 `json:"output_per_mtok,omitempty"`
+}
+
+// ParseCapabilities decodes capabilities_json; invalid JSON reads as
+// nothing known.
+func ParseCapabilities(s string) ModelCapabilities {
+	var c ModelCapabilities
+	_ = json.Unmarshal([]byte(s), &c)
+	return c
+}
+
+// String encodes c for capabilities_json.
+func (c ModelCapabilities) String() string {
+	b, _ := json.Marshal(c)
+	return string(b)
+}
+
+// Lacks returns the required capabilities the model is known not to have.
+// Capabilities are "json", "tools" or an input modality ("image", ...).
+func (c ModelCapabilities) Lacks(required []string) []string {
+	var missing []string
+	for _, r := range required {
+		switch r {
+		case "json":
+			if c.JSON != nil && !*c.JSON {
+				missing = append(missing, r)
+			}
+		case "tools":
+			if c.Tools != nil && !*c.Tools {
+				missing = append(missing, r)
+			}
+		default:
+			if len(c.Modalities) > 0 && !slices.Contains(c.Modalities, r) {
+				missing = append(missing, r)
+			}
+		}
+	}
+	return missing
+}
+
+// UpsertModel inserts or updates a model in the catalogue. A nil OwnerID
+// keeps the owner of an existing row, and empty capabilities ("{}") keep
+// those already discovered.
 func (db *FlowsDB) UpsertModel(m *AvailableModel) error {
 	_, err := db.Exec(`
 		INSERT INTO available_models (model_id, provider, model_name, display_name, context_window,
-			is_available, last_check_at, last_error, capabilities_json)
-		VALUES (?, ?, ?, ?, ?, ?, datetime('now'), ?, ?)
+			is_available, last_check_at, last_error, capabilities_json, owner_id)
+		VALUES (?, ?, ?, ?, ?, ?, datetime('now'), ?, ?, ?)
 		ON CONFLICT(model_id) DO UPDATE SET
 			is_available = excluded.is_available,
 			last_check_at = datetime('now'),
 			last_error = excluded.last_error,
 			display_name = COALESCE(excluded.display_name, available_models.display_name),
 			context_window = COALESCE(excluded.context_window, available_models.context_window),
-			capabilities_json = excluded.capabilities_json`,
+			capabilities_json = CASE WHEN excluded.capabilities_json IN ('', '{}')
+				THEN available_models.capabilities_json ELSE excluded.capabilities_json END,
+			owner_id = COALESCE(excluded.owner_id, available_models.owner_id)`,
 		m.ModelID, m.Provider, m.ModelName, m.DisplayName, m.ContextWindow,
-		boolToInt(m.IsAvailable), m.LastError, m.CapabilitiesJSON)
+		boolToInt(m.IsAvailable), m.LastError, m.CapabilitiesJSON, m.OwnerID)
 	return err
 }
 
@@ -99,6 +156,78 @@ func scanModels(rows *sql.Rows) ([]AvailableModel, error) {
 	return models, rows.Err()
 }
 
+// ContextWindow returns the context window recorded for a model, matched on
+// model_id ("provider/model") first, then on the bare model name. ok is
+// false when no catalogue row carries one.
+func (db *FlowsDB) ContextWindow(modelID, modelName string) (window int, ok bool) {
+	err := db.QueryRow(`SELECT context_window FROM available_models
+		WHERE context_window > 0 AND (model_id = ? OR model_name = ?)
+		ORDER BY model_id = ? DESC, context_window ASC LIMIT 1`,
+		modelID, modelName, modelID).Scan(&window)
+	return window, err == nil
+}
+
+// GetModel returns a catalogue row, or (nil, nil) if there is none.
+func (db *FlowsDB) GetModel(modelID string) (*AvailableModel, error) {
+	rows, err := db.Query(`SELECT model_id, provider, model_name, display_name, context_window,
+		is_available, last_check_at, last_error, capabilities_json, discovered_at, owner_id
+		FROM available_models WHERE model_id = ?`, modelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	models, err := scanModels(rows)
+	if err != nil || len(models) == 0 {
+		return nil, err
+	}
+	return &models[0], nil
+}
+
+// MarkModelAvailable records a successful check of a model.
+func (db *FlowsDB) MarkModelAvailable(modelID string) error {
+	_, err := db.Exec(`
+		UPDATE available_models SET is_available = 1, last_error = NULL, last_check_at = datetime('now')
+		WHERE model_id = ?`, modelID)
+	return err
+}
+
+// StepModels returns the distinct models named by workflow steps.
+func (db *FlowsDB) StepModels() ([]string, error) {
+	rows, err := db.Query(`SELECT DISTINCT model FROM workflow_steps WHERE model IS NOT NULL AND model != ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var models []string
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return nil, err
+		}
+		models = append(models, m)
+	}
+	return models, rows.Err()
+}
+
+// ErrModelLacksCapability is returned by CheckModelCapabilities.
+var ErrModelLacksCapability = errors.New("model lacks a required capability")
+
+// CheckModelCapabilities fails when the catalogue knows modelID lacks one
+// of required. Models missing from the catalogue pass.
+func (db *FlowsDB) CheckModelCapabilities(modelID string, required []string) error {
+	if len(required) == 0 {
+		return nil
+	}
+	m, err := db.GetModel(modelID)
+	if err != nil || m == nil {
+		return err
+	}
+	if missing := ParseCapabilities(m.CapabilitiesJSON).Lacks(required); len(missing) > 0 {
+		return fmt.Errorf("%w: %s does not support %s", ErrModelLacksCapability, modelID, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 // MarkModelUnavailable sets a model as unavailable with an error message.
 func (db *FlowsDB) MarkModelUnavailable(modelID, errorMsg string) error {
 	_, err := db.Exec(`
@@ -147,6 +147,23 @@ type CreateNodeInput struct {
 	ModelID  *string  `json:"model_id"`
 	Metadata string   `json:"metadata"`
 	Tags     []string `json:"tags"`
+
+	// Sources and Workflow are written in the node's transaction.
+	Sources  []SourceInput `json:"-"`
+	Workflow *WorkflowNode `json:"-"`
+}
+
+// SourceInput is a source created with its node, see CreateSource.
+type SourceInput struct {
+	URL, ContentText, Title, Domain, ContentHash *string
+}
+
+// WorkflowNode links a node to the workflow run step that wrote it, as
+// item Item of the step's output.
+type WorkflowNode struct {
+	RunID  string
+	StepID string
+	Item   int
 }
 
 func (db *DB) CreateNode(input CreateNodeInput) (*Node, error) {
@@ -208,6 +225,24 @@ func (db *DB) CreateNode(input CreateNodeInput) (*Node, error) {
 		}
 	}
 
+	for _, src := range input.Sources {
+		_, err = tx.Exec(`
+			INSERT INTO sources (id, node_id, url, content_text, title, domain, content_hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			NewID(), id, src.URL, src.ContentText, src.Title, src.Domain, src.ContentHash)
+		if err != nil {
+			return nil, fmt.Errorf("creating source: %w", err)
+		}
+	}
+
+	if w := input.Workflow; w != nil {
+		_, err = tx.Exec("INSERT INTO workflow_nodes (node_id, run_id, step_id, item) VALUES (?, ?, ?, ?)",
+			id, w.RunID, w.StepID, w.Item)
+		if err != nil {
+			return nil, fmt.Errorf("linking workflow node: %w", err)
+		}
+	}
+
 	// Systematic clone: create a provider-visibility copy for dataset export
 	cloneID := NewID()
 	var cloneParentID *string
@@ -235,7 +270,18 @@ func (db *DB) CreateNode(input CreateNodeInput) (*Node, error) {
 		return nil, err
 	}
 
-	return db.GetNode(id)
+	node, err := db.GetNode(id)
+	if err == nil && db.nodeCreated != nil {
+		db.nodeCreated(node)
+	}
+	return node, err
+}
+
+// OnNodeCreated sets a function CreateNode calls with every node it
+// creates, whatever created it: the API, the bot, resolutions, MCP tools
+// or workflow steps. Set it before the database is shared.
+func (db *DB) OnNodeCreated(fn func(*Node)) {
+	db.nodeCreated = fn
 }
 
 func (db *DB) GetNode(id string) (*Node, error) {
@@ -440,6 +486,28 @@ func (db *DB) GetTagsForNode(nodeID string) ([]string, error) {
 	return tags, nil
 }
 
+// WorkflowNodes returns the nodes a workflow run's node_write step has
+// written, by their index in the step's output (see WorkflowNode).
+func (db *DB) WorkflowNodes(runID, stepID string) (map[int]string, error) {
+	rows, err := db.Query(`
+		SELECT node_id, item FROM workflow_nodes WHERE run_id = ? AND step_id = ?`, runID, stepID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	written := make(map[int]string)
+	for rows.Next() {
+		var id string
+		var item int
+		if err := rows.Scan(&id, &item); err != nil {
+			return nil, err
+		}
+		written[item] = id
+	}
+	return written, rows.Err()
+}
+
 func (db *DB) GetPopularTags(limit int) ([]TagCount, error) {
 	if limit <= 0 {
 		limit = 30
@@ -0,0 +1,90 @@
+// CLAUDE:SUMMARY Provider registry DB — self-registered providers (endpoint, api_style, models, operator approval, activity and heartbeat state)
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// RegisteredProvider is one row of the providers table: an external
+// endpoint that registered itself through the API.
+type RegisteredProvider struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Endpoint     string     `json:"endpoint"`
+	APIStyle     string     `json:"api_style"`
+	Models       []string   `json:"models"`
+	IsActive     bool       `json:"is_active"`
+	RegisteredBy string     `json:"registered_by,omitempty"`
+	Approved     bool       `json:"approved"`
+	AllowPrivate bool       `json:"allow_private"` // operator allowed a loopback/private endpoint
+	LastSeenAt   *time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// LastAlive returns the time of the last heartbeat, or the registration
+// time if the provider never sent one.
+func (p *RegisteredProvider) LastAlive() time.Time {
+	if p.LastSeenAt != nil {
+		return *p.LastSeenAt
+	}
+	return p.CreatedAt
+}
+
+// ListRegisteredProviders returns every self-registered provider, active or not.
+func (db *DB) ListRegisteredProviders() ([]RegisteredProvider, error) {
+	rows, err := db.Query(`
+		SELECT id, name, COALESCE(endpoint,''), COALESCE(api_style,'openai'), COALESCE(models,'[]'),
+			COALESCE(is_active,1), COALESCE(registered_by,''), approved_at IS NOT NULL,
+			COALESCE(allow_private,0), last_seen_at, created_at
+		FROM providers ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var providers []RegisteredProvider
+	for rows.Next() {
+		var p RegisteredProvider
+		var modelsJSON string
+		var active, approved, allowPrivate int
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Name, &p.Endpoint, &p.APIStyle, &modelsJSON,
+			&active, &p.RegisteredBy, &approved, &allowPrivate, &lastSeen, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(modelsJSON), &p.Models)
+		p.IsActive = active == 1
+		p.Approved = approved == 1
+		p.AllowPrivate = allowPrivate == 1
+		if lastSeen.Valid {
+			p.LastSeenAt = &lastSeen.Time
+		}
+		providers = append(providers, p)
+	}
+	return providers, rows.Err()
+}
+
+// SetProviderActive flips is_active. Returns false if the provider does not exist.
+func (db *DB) SetProviderActive(id string, active bool) (bool, error) {
+	res, err := db.Exec(`UPDATE providers SET is_active = ? WHERE id = ?`, boolToInt(active), id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ApproveProvider records an operator's approval of a provider, after which
+// it can become an LLM backend. allowPrivate lets its endpoint use loopback,
+// private or link-local addresses. Returns false if the provider does not exist.
+func (db *DB) ApproveProvider(id, operatorID string, allowPrivate bool) (bool, error) {
+	res, err := db.Exec(`UPDATE providers SET approved_by = ?, approved_at = datetime('now'), allow_private = ? WHERE id = ?`,
+		operatorID, boolToInt(allowPrivate), id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
@@ -186,6 +186,7 @@ CREATE TABLE IF NOT EXISTS challenges (
     score           REAL,
     summary         TEXT,
     flow_id         TEXT,
+    workflow_run_id TEXT,
     error           TEXT,
     started_at      DATETIME,
     completed_at    DATETIME,
@@ -343,6 +344,9 @@ CREATE TABLE IF NOT EXISTS providers (
     api_key_hash    TEXT,
     resolution_space INTEGER DEFAULT 0,
     resolution_criteria TEXT DEFAULT '{}',
+    approved_by     TEXT,
+    approved_at     DATETIME,
+    allow_private   INTEGER DEFAULT 0,
     created_at      DATETIME DEFAULT (datetime('now')),
     last_seen_at    DATETIME
 );
@@ -484,4 +488,35 @@ CREATE TABLE IF NOT EXISTS resolutions (
     updated_at      DATETIME DEFAULT (datetime('now'))
 );
 CREATE UNIQUE INDEX IF NOT EXISTS idx_resolutions_triplet ON resolutions(node_id, provider, model);
+
+-- Embeddings: one vector per node or source and embedder ("provider/model")
+CREATE TABLE IF NOT EXISTS embeddings (
+    owner_type   TEXT NOT NULL CHECK(owner_type IN ('node','source')),
+    owner_id     TEXT NOT NULL,
+    embedder     TEXT NOT NULL,
+    dims         INTEGER NOT NULL,
+    vector       BLOB NOT NULL,
+    content_hash TEXT NOT NULL,
+    created_at   DATETIME DEFAULT (datetime('now')),
+    PRIMARY KEY (owner_type, owner_id, embedder)
+);
+CREATE INDEX IF NOT EXISTS idx_embeddings_embedder ON embeddings(embedder, owner_type);
+
+-- IDF tables of the built-in embedder, one per fit; the latest is in use
+CREATE TABLE IF NOT EXISTS local_embedder_fits (
+    model      TEXT PRIMARY KEY,
+    docs       INTEGER NOT NULL,
+    idf        BLOB NOT NULL,
+    created_at DATETIME DEFAULT (datetime('now'))
+);
+
+-- Workflow nodes: the nodes a workflow run's node_write step wrote, by
+-- their index in the step's output (also in their metadata, unindexed)
+CREATE TABLE IF NOT EXISTS workflow_nodes (
+    node_id TEXT PRIMARY KEY,
+    run_id  TEXT NOT NULL,
+    step_id TEXT NOT NULL,
+    item    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_workflow_nodes_run ON workflow_nodes(run_id, step_id);
 `
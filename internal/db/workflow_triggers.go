@@ -0,0 +1,234 @@
+// CLAUDE:SUMMARY Workflow triggers — cron and domain-event trigger definitions on workflows, their run-as user and rate limit, and the history of their firings
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TriggerTypes are the kinds of trigger: a cron schedule, or a domain event.
+var TriggerTypes = map[string]bool{
+	"cron":                true,
+	"node_created":        true,
+	"temperature_hot":     true,
+	"source_added":        true,
+	"challenge_completed": true,
+}
+
+// WorkflowTrigger starts runs of a workflow's active version as RunAs, on
+// its cron Schedule or on each event of its TriggerType about a node
+// tagged Tag (or whose tree's root is). At most RateLimit runs start per
+// RateWindowSec; the firings beyond are recorded as rate_limited.
+type WorkflowTrigger struct {
+	TriggerID     string     `json:"trigger_id"`
+	WorkflowID    string     `json:"workflow_id"`
+	Name          string     `json:"name"`
+	TriggerType   string     `json:"trigger_type"`
+	Schedule      string     `json:"schedule,omitempty"` // cron: 5 fields, UTC
+	Tag           string     `json:"tag,omitempty"`      // events: "" = any node
+	NodeID        string     `json:"node_id,omitempty"`  // cron: the runs' node
+	Body          string     `json:"body,omitempty"`     // cron: the runs' body
+	PrePrompt     string     `json:"pre_prompt,omitempty"`
+	RunAs         string     `json:"run_as"`
+	RateLimit     int        `json:"rate_limit"`
+	RateWindowSec int        `json:"rate_window_sec"`
+	Enabled       bool       `json:"enabled"`
+	CreatedBy     string     `json:"created_by"`
+	NextRunAt     *time.Time `json:"next_run_at,omitempty"`
+	LastFiredAt   *time.Time `json:"last_fired_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// TriggerFire is one firing of a trigger: the run it started, or why none
+// was started (rate_limited, skipped, failed).
+type TriggerFire struct {
+	FireID    string    `json:"fire_id"`
+	TriggerID string    `json:"trigger_id"`
+	EventType string    `json:"event_type"`
+	NodeID    string    `json:"node_id,omitempty"`
+	RunID     string    `json:"run_id,omitempty"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	FiredAt   time.Time `json:"fired_at"`
+}
+
+const triggerColumns = `trigger_id, workflow_id, name, trigger_type, COALESCE(schedule,''), COALESCE(tag,''),
+	COALESCE(node_id,''), COALESCE(body,''), COALESCE(pre_prompt,''), run_as, rate_limit, rate_window_sec,
+	enabled, created_by, next_run_at, last_fired_at, created_at, updated_at`
+
+// sqlTime stores t as SQLite's datetime('now') does, so that the two compare.
+func sqlTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func scanTrigger(row interface{ Scan(...interface{}) error }) (*WorkflowTrigger, error) {
+	t := &WorkflowTrigger{}
+	var nextRun, lastFired sql.NullTime
+	if err := row.Scan(&t.TriggerID, &t.WorkflowID, &t.Name, &t.TriggerType, &t.Schedule, &t.Tag,
+		&t.NodeID, &t.Body, &t.PrePrompt, &t.RunAs, &t.RateLimit, &t.RateWindowSec,
+		&t.Enabled, &t.CreatedBy, &nextRun, &lastFired, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if nextRun.Valid {
+		t.NextRunAt = &nextRun.Time
+	}
+	if lastFired.Valid {
+		t.LastFiredAt = &lastFired.Time
+	}
+	return t, nil
+}
+
+func (db *FlowsDB) queryTriggers(query string, args ...interface{}) ([]WorkflowTrigger, error) {
+	rows, err := db.Query(`SELECT `+triggerColumns+` FROM workflow_triggers `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WorkflowTrigger
+	for rows.Next() {
+		t, err := scanTrigger(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *t)
+	}
+	return out, rows.Err()
+}
+
+// CreateTrigger inserts a trigger.
+func (db *FlowsDB) CreateTrigger(t *WorkflowTrigger) error {
+	_, err := db.Exec(`
+		INSERT INTO workflow_triggers (trigger_id, workflow_id, name, trigger_type, schedule, tag,
+			node_id, body, pre_prompt, run_as, rate_limit, rate_window_sec, enabled, created_by, next_run_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.TriggerID, t.WorkflowID, t.Name, t.TriggerType, nilIfEmpty(t.Schedule), nilIfEmpty(t.Tag),
+		nilIfEmpty(t.NodeID), nilIfEmpty(t.Body), nilIfEmpty(t.PrePrompt), t.RunAs, t.RateLimit, t.RateWindowSec,
+		t.Enabled, t.CreatedBy, sqlTime(t.NextRunAt))
+	return err
+}
+
+// GetTrigger retrieves a trigger by ID.
+func (db *FlowsDB) GetTrigger(triggerID string) (*WorkflowTrigger, error) {
+	return scanTrigger(db.QueryRow(`SELECT `+triggerColumns+` FROM workflow_triggers WHERE trigger_id = ?`, triggerID))
+}
+
+// ListTriggers returns the triggers of a workflow, or of every workflow
+// when workflowID is empty.
+func (db *FlowsDB) ListTriggers(workflowID string) ([]WorkflowTrigger, error) {
+	if workflowID == "" {
+		return db.queryTriggers(`ORDER BY created_at`)
+	}
+	return db.queryTriggers(`WHERE workflow_id = ? ORDER BY created_at`, workflowID)
+}
+
+// ListEventTriggers returns the enabled triggers of an event type.
+func (db *FlowsDB) ListEventTriggers(eventType string) ([]WorkflowTrigger, error) {
+	return db.queryTriggers(`WHERE trigger_type = ? AND enabled = 1 ORDER BY created_at`, eventType)
+}
+
+// DueCronTriggers returns the enabled cron triggers whose next run is due
+// at now.
+func (db *FlowsDB) DueCronTriggers(now time.Time) ([]WorkflowTrigger, error) {
+	return db.queryTriggers(`WHERE trigger_type = 'cron' AND enabled = 1 AND next_run_at IS NOT NULL AND next_run_at <= ?
+		ORDER BY next_run_at`, sqlTime(&now))
+}
+
+// UpdateTrigger saves a trigger's editable fields.
+func (db *FlowsDB) UpdateTrigger(t *WorkflowTrigger) error {
+	_, err := db.Exec(`
+		UPDATE workflow_triggers SET name = ?, schedule = ?, tag = ?, node_id = ?, body = ?, pre_prompt = ?,
+			run_as = ?, rate_limit = ?, rate_window_sec = ?, enabled = ?, next_run_at = ?, updated_at = datetime('now')
+		WHERE trigger_id = ?`,
+		t.Name, nilIfEmpty(t.Schedule), nilIfEmpty(t.Tag), nilIfEmpty(t.NodeID), nilIfEmpty(t.Body), nilIfEmpty(t.PrePrompt),
+		t.RunAs, t.RateLimit, t.RateWindowSec, t.Enabled, sqlTime(t.NextRunAt), t.TriggerID)
+	return err
+}
+
+// SetTriggerNextRun records when a cron trigger is next due.
+func (db *FlowsDB) SetTriggerNextRun(triggerID string, next *time.Time) error {
+	_, err := db.Exec(`UPDATE workflow_triggers SET next_run_at = ? WHERE trigger_id = ?`, sqlTime(next), triggerID)
+	return err
+}
+
+// DeleteTrigger removes a trigger and its history.
+func (db *FlowsDB) DeleteTrigger(triggerID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if _, err := tx.Exec(`DELETE FROM workflow_trigger_fires WHERE trigger_id = ?`, triggerID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM workflow_triggers WHERE trigger_id = ?`, triggerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CountTriggerRuns counts the runs a trigger started in the last windowSec
+// seconds, which its rate limit applies to.
+func (db *FlowsDB) CountTriggerRuns(triggerID string, windowSec int) (int, error) {
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM workflow_trigger_fires
+		WHERE trigger_id = ? AND status = 'fired' AND fired_at > datetime('now', ?)`,
+		triggerID, fmt.Sprintf("-%d seconds", windowSec)).Scan(&n)
+	return n, err
+}
+
+// RecordTriggerFire adds a firing to a trigger's history.
+func (db *FlowsDB) RecordTriggerFire(f *TriggerFire) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if _, err := tx.Exec(`
+		INSERT INTO workflow_trigger_fires (fire_id, trigger_id, event_type, node_id, run_id, status, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		f.FireID, f.TriggerID, f.EventType, nilIfEmpty(f.NodeID), nilIfEmpty(f.RunID), f.Status, nilIfEmpty(f.Reason)); err != nil {
+		return err
+	}
+	if f.Status == "fired" {
+		if _, err := tx.Exec(`UPDATE workflow_triggers SET last_fired_at = datetime('now') WHERE trigger_id = ?`, f.TriggerID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	f.FiredAt = time.Now().UTC()
+	return nil
+}
+
+// ListTriggerFires returns a trigger's latest firings, newest first.
+func (db *FlowsDB) ListTriggerFires(triggerID string, limit int) ([]TriggerFire, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := db.Query(`
+		SELECT fire_id, trigger_id, event_type, COALESCE(node_id,''), COALESCE(run_id,''), status,
+			COALESCE(reason,''), fired_at
+		FROM workflow_trigger_fires WHERE trigger_id = ?
+		ORDER BY fired_at DESC, rowid DESC LIMIT ?`, triggerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TriggerFire
+	for rows.Next() {
+		var f TriggerFire
+		if err := rows.Scan(&f.FireID, &f.TriggerID, &f.EventType, &f.NodeID, &f.RunID, &f.Status,
+			&f.Reason, &f.FiredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
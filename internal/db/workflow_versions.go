@@ -0,0 +1,418 @@
+// CLAUDE:SUMMARY Workflow versions — frozen per-version step sets, forking a draft off the latest version, version listing and step-by-step diffs
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ErrStepNotDraft is returned when changing a step of a version that left
+// draft, or a step that does not exist.
+var ErrStepNotDraft = errors.New("step is not in a draft version")
+
+// draftStep restricts a workflow_steps statement to the steps of a
+// workflow's latest version while it is a draft: the others are frozen,
+// since runs point at them.
+const draftStep = ` AND (workflow_id, version) IN (SELECT workflow_id, version FROM workflows WHERE status = 'draft')`
+
+func stepChanged(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStepNotDraft
+	}
+	return nil
+}
+
+// WorkflowVersion is one version of a workflow as listed by
+// ListWorkflowVersions.
+type WorkflowVersion struct {
+	WorkflowID        string     `json:"workflow_id"`
+	Version           int        `json:"version"`
+	Status            string     `json:"status"`
+	Name              string     `json:"name"`
+	Description       string     `json:"description"`
+	PrePromptTemplate string     `json:"pre_prompt_template,omitempty"`
+	CreatedBy         string     `json:"created_by,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ValidatedBy       *string    `json:"validated_by,omitempty"`
+	ValidatedAt       *time.Time `json:"validated_at,omitempty"`
+	RejectionReason   *string    `json:"rejection_reason,omitempty"`
+	StepCount         int        `json:"step_count"`
+	RunCount          int        `json:"run_count"`
+}
+
+// ListWorkflowVersions returns every version of a workflow, latest first.
+func (db *FlowsDB) ListWorkflowVersions(workflowID string) ([]WorkflowVersion, error) {
+	rows, err := db.Query(`
+		SELECT v.workflow_id, v.version, v.status, v.name, COALESCE(v.description,''),
+			COALESCE(v.pre_prompt_template,''), COALESCE(v.created_by,''), v.created_at,
+			v.validated_by, v.validated_at, v.rejection_reason,
+			(SELECT COUNT(*) FROM workflow_steps s WHERE s.workflow_id = v.workflow_id AND s.version = v.version),
+			(SELECT COUNT(*) FROM workflow_runs r WHERE r.workflow_id = v.workflow_id AND r.workflow_version = v.version)
+		FROM workflow_versions v WHERE v.workflow_id = ? ORDER BY v.version DESC`, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []WorkflowVersion
+	for rows.Next() {
+		var v WorkflowVersion
+		var validatedBy, rejectionReason sql.NullString
+		var validatedAt sql.NullTime
+		if err := rows.Scan(&v.WorkflowID, &v.Version, &v.Status, &v.Name, &v.Description,
+			&v.PrePromptTemplate, &v.CreatedBy, &v.CreatedAt,
+			&validatedBy, &validatedAt, &rejectionReason,
+			&v.StepCount, &v.RunCount); err != nil {
+			return nil, err
+		}
+		if validatedBy.Valid {
+			v.ValidatedBy = &validatedBy.String
+		}
+		if validatedAt.Valid {
+			v.ValidatedAt = &validatedAt.Time
+		}
+		if rejectionReason.Valid {
+			v.RejectionReason = &rejectionReason.String
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetWorkflowVersion retrieves a workflow as it was in one of its versions,
+// with that version's status and steps.
+func (db *FlowsDB) GetWorkflowVersion(workflowID string, version int) (*Workflow, error) {
+	w, err := db.GetWorkflow(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if version == w.Version {
+		return w, nil
+	}
+	var validatedBy, rejectionReason sql.NullString
+	var validatedAt sql.NullTime
+	err = db.QueryRow(`
+		SELECT status, name, COALESCE(description,''), COALESCE(pre_prompt_template,''),
+			validated_by, validated_at, rejection_reason
+		FROM workflow_versions WHERE workflow_id = ? AND version = ?`, workflowID, version).Scan(
+		&w.Status, &w.Name, &w.Description, &w.PrePromptTemplate,
+		&validatedBy, &validatedAt, &rejectionReason)
+	if err != nil {
+		return nil, err
+	}
+	w.Version = version
+	w.ValidatedBy, w.ValidatedAt, w.RejectionReason = nil, nil, nil
+	if validatedBy.Valid {
+		w.ValidatedBy = &validatedBy.String
+	}
+	if validatedAt.Valid {
+		w.ValidatedAt = &validatedAt.Time
+	}
+	if rejectionReason.Valid {
+		w.RejectionReason = &rejectionReason.String
+	}
+	if w.Steps, err = db.GetVersionSteps(workflowID, version); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// GetRunnableWorkflow retrieves the version of a workflow runs execute: its
+// active version, or its latest one while none is active. Callers that
+// require an active workflow check ActiveVersion.
+func (db *FlowsDB) GetRunnableWorkflow(workflowID string) (*Workflow, error) {
+	w, err := db.GetWorkflow(workflowID)
+	if err != nil || w.ActiveVersion == nil || *w.ActiveVersion == w.Version {
+		return w, err
+	}
+	return db.GetWorkflowVersion(workflowID, *w.ActiveVersion)
+}
+
+// ErrVersionChanged is returned by EditDraft when the workflow is no longer
+// at the version the caller read, another edit having forked it, or was
+// archived since.
+var ErrVersionChanged = errors.New("workflow version changed")
+
+// Draft is a workflow version being written in a transaction: the draft
+// of EditDraft, or the first version of CreateWorkflowWith. When the draft
+// was forked for the edit, IDs maps the step IDs of the frozen version to
+// the draft's.
+type Draft struct {
+	tx         *sql.Tx
+	WorkflowID string
+	Version    int
+	Forked     bool
+	IDs        map[string]string
+}
+
+// StepID returns the draft's ID of a step the caller knows by its ID in
+// the version it read.
+func (d *Draft) StepID(stepID string) string {
+	if d.IDs != nil {
+		return d.IDs[stepID]
+	}
+	return stepID
+}
+
+// Update changes the draft's name, description and pre-prompt template.
+func (d *Draft) Update(name, description, prePromptTemplate string) error {
+	return updateWorkflow(d.tx, d.WorkflowID, name, description, prePromptTemplate)
+}
+
+// CreateStep adds a step to the draft.
+func (d *Draft) CreateStep(s *WorkflowStep) error {
+	s.Version = d.Version
+	return createStep(d.tx, s)
+}
+
+// UpdateStep updates a step of the draft, s.StepID being its ID in the
+// version the caller read.
+func (d *Draft) UpdateStep(s *WorkflowStep) error {
+	s.StepID, s.Version = d.StepID(s.StepID), d.Version
+	return updateStep(d.tx, s)
+}
+
+// DeleteStep removes a step of the draft, known by its ID in the version
+// the caller read.
+func (d *Draft) DeleteStep(stepID string) error {
+	return deleteStep(d.tx, d.StepID(stepID))
+}
+
+// CriteriaListByName retrieves a criteria list by name, as seen by the
+// transaction.
+func (d *Draft) CriteriaListByName(name string) (*CriteriaList, error) {
+	return getCriteriaListByName(d.tx, name)
+}
+
+// CreateCriteriaList inserts a criteria list for the draft's steps.
+func (d *Draft) CreateCriteriaList(cl *CriteriaList) error {
+	return createCriteriaList(d.tx, cl)
+}
+
+// EditDraft runs edit on the draft version of a workflow. A version that
+// left draft is frozen, runs pointing at its steps: it is first forked
+// into the next version, as a draft that goes through submit and activate
+// again. Fork and edit share a transaction, so a failed edit leaves no
+// draft behind. version is the version the caller read the workflow at.
+func (db *FlowsDB) EditDraft(workflowID string, version int, createdBy string, edit func(*Draft) error) (*Draft, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// Writing first takes the write lock, so concurrent edits wait for
+	// each other instead of failing to upgrade a read.
+	if _, err := tx.Exec(`UPDATE workflows SET updated_at = datetime('now') WHERE workflow_id = ?`, workflowID); err != nil {
+		return nil, err
+	}
+	var current int
+	var status string
+	if err := tx.QueryRow(`SELECT version, status FROM workflows WHERE workflow_id = ?`, workflowID).Scan(&current, &status); err != nil {
+		return nil, err
+	}
+	if current != version || status == "archived" {
+		return nil, ErrVersionChanged
+	}
+	d := &Draft{tx: tx, WorkflowID: workflowID, Version: current}
+	if status != "draft" {
+		if d.Version, d.IDs, err = forkVersion(tx, workflowID, createdBy); err != nil {
+			return nil, err
+		}
+		d.Forked = true
+	}
+	if err := edit(d); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// forkVersion starts a new draft version of a workflow from its latest
+// one, whose steps are copied under new IDs. The version being replaced is
+// superseded unless runs execute it or it was rejected. It returns the new
+// version and the new ID of each copied step by old ID.
+func forkVersion(tx *sql.Tx, workflowID, createdBy string) (int, map[string]string, error) {
+	var version int
+	var status string
+	if err := tx.QueryRow(`SELECT version, status FROM workflows WHERE workflow_id = ?`, workflowID).Scan(&version, &status); err != nil {
+		return 0, nil, err
+	}
+	if status == "draft" || status == "archived" {
+		return 0, nil, fmt.Errorf("cannot fork a %s workflow", status)
+	}
+	next := version + 1
+
+	if _, err := tx.Exec(`
+		INSERT INTO workflow_versions (workflow_id, version, status, name, description, pre_prompt_template, created_by)
+		SELECT workflow_id, ?, 'draft', name, description, pre_prompt_template, ?
+		FROM workflows WHERE workflow_id = ?`, next, createdBy, workflowID); err != nil {
+		return 0, nil, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE workflow_versions SET status = 'superseded'
+		WHERE workflow_id = ? AND version = ? AND status NOT IN ('active','rejected')`, workflowID, version); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := tx.Query(`SELECT step_id FROM workflow_steps WHERE workflow_id = ? AND version = ?`, workflowID, version)
+	if err != nil {
+		return 0, nil, err
+	}
+	var oldIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		oldIDs = append(oldIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	ids := make(map[string]string, len(oldIDs))
+	for _, old := range oldIDs {
+		ids[old] = NewID()
+		if _, err := tx.Exec(`
+			INSERT INTO workflow_steps (step_id, workflow_id, version, step_order, step_name, step_type,
+				provider, model, prompt_template, system_prompt, config_json,
+				criteria_list_id, timeout_ms, retry_max, fan_group, depends_on)
+			SELECT ?, workflow_id, ?, step_order, step_name, step_type,
+				provider, model, prompt_template, system_prompt, config_json,
+				criteria_list_id, timeout_ms, retry_max, fan_group, depends_on
+			FROM workflow_steps WHERE step_id = ?`, ids[old], next, old); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE workflows SET version = ?, status = 'draft', validated_by = NULL, validated_at = NULL,
+			rejection_reason = NULL, updated_at = datetime('now')
+		WHERE workflow_id = ?`, next, workflowID); err != nil {
+		return 0, nil, err
+	}
+	return next, ids, nil
+}
+
+// FieldChange is a value that differs between two versions.
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// StepDiff is what happened to a step between two versions: "added",
+// "removed", "changed" or "unchanged".
+type StepDiff struct {
+	StepName   string                 `json:"step_name"`
+	Change     string                 `json:"change"`
+	FromStepID string                 `json:"from_step_id,omitempty"`
+	ToStepID   string                 `json:"to_step_id,omitempty"`
+	Fields     map[string]FieldChange `json:"fields,omitempty"`
+}
+
+// VersionDiff compares two versions of a workflow.
+type VersionDiff struct {
+	WorkflowID string                 `json:"workflow_id"`
+	From       int                    `json:"from"`
+	To         int                    `json:"to"`
+	Fields     map[string]FieldChange `json:"fields"`
+	Steps      []StepDiff             `json:"steps"`
+}
+
+// DiffWorkflowVersions compares two versions of a workflow field by field
+// and step by step. Steps are matched by name, steps sharing a name by the
+// order they run in.
+func DiffWorkflowVersions(from, to *Workflow) VersionDiff {
+	d := VersionDiff{
+		WorkflowID: to.WorkflowID,
+		From:       from.Version,
+		To:         to.Version,
+		Fields: diffFields(
+			map[string]interface{}{"name": from.Name, "description": from.Description, "pre_prompt_template": from.PrePromptTemplate},
+			map[string]interface{}{"name": to.Name, "description": to.Description, "pre_prompt_template": to.PrePromptTemplate}),
+		Steps: []StepDiff{},
+	}
+
+	key := func(steps []WorkflowStep) []string {
+		seen := map[string]int{}
+		keys := make([]string, len(steps))
+		for i, s := range steps {
+			keys[i] = fmt.Sprintf("%s#%d", s.StepName, seen[s.StepName])
+			seen[s.StepName]++
+		}
+		return keys
+	}
+	fromKeys, toKeys := key(from.Steps), key(to.Steps)
+	old := make(map[string]WorkflowStep, len(from.Steps))
+	for i, s := range from.Steps {
+		old[fromKeys[i]] = s
+	}
+
+	matched := map[string]bool{}
+	for i, s := range to.Steps {
+		prev, ok := old[toKeys[i]]
+		if !ok {
+			d.Steps = append(d.Steps, StepDiff{StepName: s.StepName, Change: "added", ToStepID: s.StepID})
+			continue
+		}
+		matched[toKeys[i]] = true
+		sd := StepDiff{StepName: s.StepName, Change: "unchanged", FromStepID: prev.StepID, ToStepID: s.StepID,
+			Fields: diffFields(stepFields(prev), stepFields(s))}
+		if len(sd.Fields) > 0 {
+			sd.Change = "changed"
+		} else {
+			sd.Fields = nil
+		}
+		d.Steps = append(d.Steps, sd)
+	}
+	for i, s := range from.Steps {
+		if !matched[fromKeys[i]] {
+			d.Steps = append(d.Steps, StepDiff{StepName: s.StepName, Change: "removed", FromStepID: s.StepID})
+		}
+	}
+	return d
+}
+
+// stepFields is what a step diff compares: its definition, not its identity.
+func stepFields(s WorkflowStep) map[string]interface{} {
+	deref := func(p *string) interface{} {
+		if p == nil {
+			return nil
+		}
+		return *p
+	}
+	return map[string]interface{}{
+		"step_order":       s.StepOrder,
+		"step_type":        s.StepType,
+		"provider":         s.Provider,
+		"model":            s.Model,
+		"prompt_template":  s.PromptTemplate,
+		"system_prompt":    s.SystemPrompt,
+		"config_json":      s.ConfigJSON,
+		"criteria_list_id": deref(s.CriteriaListID),
+		"timeout_ms":       s.TimeoutMs,
+		"retry_max":        s.RetryMax,
+		"fan_group":        deref(s.FanGroup),
+		"depends_on":       s.DependsOn,
+	}
+}
+
+func diffFields(from, to map[string]interface{}) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	for k, v := range to {
+		if !reflect.DeepEqual(from[k], v) {
+			changes[k] = FieldChange{From: from[k], To: v}
+		}
+	}
+	return changes
+}
@@ -24,6 +24,9 @@ type Workflow struct {
 	ValidatedBy      *string    `json:"validated_by,omitempty"`
 	ValidatedAt      *time.Time `json:"validated_at,omitempty"`
 	RejectionReason  *string    `json:"rejection_reason,omitempty"`
+	// ActiveVersion is the version runs execute; Version is the latest,
+	// which may be a draft in the making. nil = not runnable.
+	ActiveVersion    *int       `json:"active_version,omitempty"`
 	Steps            []WorkflowStep `json:"steps,omitempty"`
 }
 
@@ -31,6 +34,7 @@ type Workflow struct {
 type WorkflowStep struct {
 	StepID         string `json:"step_id"`
 	WorkflowID     string `json:"workflow_id"`
+	Version        int    `json:"version"` // 0 = the workflow's latest version
 	StepOrder      int    `json:"step_order"`
 	StepName       string `json:"step_name"`
 	StepType       string `json:"step_type"`
@@ -43,6 +47,9 @@ type WorkflowStep struct {
 	TimeoutMs      int    `json:"timeout_ms"`
 	RetryMax       int    `json:"retry_max"`
 	FanGroup       *string `json:"fan_group,omitempty"`
+	// DependsOn names the steps this one waits for; nil waits for the
+	// steps of the nearest lower step_order, empty for none.
+	DependsOn      []string `json:"depends_on"`
 	CreatedAt      time.Time `json:"created_at"`
 }
 
@@ -59,21 +66,23 @@ type CriteriaList struct {
 
 // WorkflowRun represents one execution of a workflow.
 type WorkflowRun struct {
-	RunID          string     `json:"run_id"`
-	WorkflowID     string     `json:"workflow_id"`
-	NodeID         *string    `json:"node_id,omitempty"`
-	InitiatedBy    string     `json:"initiated_by"`
-	Status         string     `json:"status"`
-	PrePrompt      *string    `json:"pre_prompt,omitempty"`
-	BatchID        *string    `json:"batch_id,omitempty"`
-	TotalSteps     int        `json:"total_steps"`
-	CompletedSteps int        `json:"completed_steps"`
-	ResultJSON     *string    `json:"result_json,omitempty"`
-	Error          *string    `json:"error,omitempty"`
-	StartedAt      *time.Time `json:"started_at,omitempty"`
-	CompletedAt    *time.Time `json:"completed_at,omitempty"`
-	CreatedAt      time.Time  `json:"created_at"`
-	WorkflowName   string     `json:"workflow_name,omitempty"`
+	RunID           string     `json:"run_id"`
+	WorkflowID      string     `json:"workflow_id"`
+	NodeID          *string    `json:"node_id,omitempty"`
+	InitiatedBy     string     `json:"initiated_by"`
+	Status          string     `json:"status"`
+	PrePrompt       *string    `json:"pre_prompt,omitempty"`
+	InputJSON       *string    `json:"input_json,omitempty"`
+	WorkflowVersion *int       `json:"workflow_version,omitempty"`
+	BatchID         *string    `json:"batch_id,omitempty"`
+	TotalSteps      int        `json:"total_steps"`
+	CompletedSteps  int        `json:"completed_steps"`
+	ResultJSON      *string    `json:"result_json,omitempty"`
+	Error           *string    `json:"error,omitempty"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	WorkflowName    string     `json:"workflow_name,omitempty"`
 }
 
 // WorkflowStepRun represents one execution of a single step.
@@ -100,13 +109,54 @@ type WorkflowStepRun struct {
 
 // --- Workflow CRUD ---
 
-// CreateWorkflow inserts a new workflow definition.
+// CreateWorkflow inserts a new workflow definition as its version
+// w.Version, active right away when w.Status is "active".
 func (db *FlowsDB) CreateWorkflow(w *Workflow) error {
-	_, err := db.Exec(`
-		INSERT INTO workflows (workflow_id, name, description, workflow_type, owner_id, owner_role, status, version, pre_prompt_template)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if err := createWorkflow(tx, w); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateWorkflowWith creates a workflow as CreateWorkflow does and runs
+// fill on its version in the same transaction, to add its steps: if fill
+// fails, no part of the workflow is left.
+func (db *FlowsDB) CreateWorkflowWith(w *Workflow, fill func(*Draft) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if err := createWorkflow(tx, w); err != nil {
+		return err
+	}
+	if err := fill(&Draft{tx: tx, WorkflowID: w.WorkflowID, Version: w.Version}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createWorkflow(tx *sql.Tx, w *Workflow) error {
+	if w.Status == "active" {
+		v := w.Version
+		w.ActiveVersion = &v
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO workflows (workflow_id, name, description, workflow_type, owner_id, owner_role, status, version, pre_prompt_template, active_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		w.WorkflowID, w.Name, w.Description, w.WorkflowType,
-		w.OwnerID, w.OwnerRole, w.Status, w.Version, nilIfEmpty(w.PrePromptTemplate))
+		w.OwnerID, w.OwnerRole, w.Status, w.Version, nilIfEmpty(w.PrePromptTemplate), w.ActiveVersion); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO workflow_versions (workflow_id, version, status, name, description, pre_prompt_template, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		w.WorkflowID, w.Version, w.Status, w.Name, w.Description, nilIfEmpty(w.PrePromptTemplate), w.OwnerID)
 	return err
 }
 
@@ -115,18 +165,23 @@ func (db *FlowsDB) GetWorkflow(workflowID string) (*Workflow, error) {
 	w := &Workflow{}
 	var validatedBy, rejectionReason sql.NullString
 	var validatedAt sql.NullTime
+	var activeVersion sql.NullInt64
 	err := db.QueryRow(`
 		SELECT workflow_id, name, description, workflow_type, owner_id, owner_role,
 			status, version, COALESCE(pre_prompt_template,''), created_at, updated_at,
-			validated_by, validated_at, rejection_reason
+			validated_by, validated_at, rejection_reason, active_version
 		FROM workflows WHERE workflow_id = ?`, workflowID).Scan(
 		&w.WorkflowID, &w.Name, &w.Description, &w.WorkflowType,
 		&w.OwnerID, &w.OwnerRole, &w.Status, &w.Version,
 		&w.PrePromptTemplate, &w.CreatedAt, &w.UpdatedAt,
-		&validatedBy, &validatedAt, &rejectionReason)
+		&validatedBy, &validatedAt, &rejectionReason, &activeVersion)
 	if err != nil {
 		return nil, err
 	}
+	if activeVersion.Valid {
+		v := int(activeVersion.Int64)
+		w.ActiveVersion = &v
+	}
 	if validatedBy.Valid {
 		w.ValidatedBy = &validatedBy.String
 	}
@@ -137,7 +192,7 @@ func (db *FlowsDB) GetWorkflow(workflowID string) (*Workflow, error) {
 		w.RejectionReason = &rejectionReason.String
 	}
 
-	steps, err := db.GetWorkflowSteps(workflowID)
+	steps, err := db.GetVersionSteps(workflowID, w.Version)
 	if err != nil {
 		return nil, err
 	}
@@ -158,14 +213,16 @@ func (db *FlowsDB) GetWorkflowByName(name string) (*Workflow, error) {
 // ListWorkflows returns workflows filtered by role and optional status.
 func (db *FlowsDB) ListWorkflows(role, status string) ([]Workflow, error) {
 	query := `SELECT workflow_id, name, description, workflow_type, owner_id, owner_role,
-		status, version, COALESCE(pre_prompt_template,''), created_at, updated_at
+		status, version, COALESCE(pre_prompt_template,''), created_at, updated_at, active_version
 		FROM workflows WHERE 1=1`
 	var args []interface{}
 
+	// A workflow with an active version stays listed while its next
+	// version is drafted.
 	if role == "user" {
-		query += ` AND status = 'active'`
+		query += ` AND active_version IS NOT NULL`
 	} else if role == "operator" || role == "provider" {
-		query += ` AND (status = 'active' OR owner_role = ?)`
+		query += ` AND (active_version IS NOT NULL OR owner_role = ?)`
 		args = append(args, role)
 	}
 	// admin sees everything
@@ -186,40 +243,90 @@ func (db *FlowsDB) ListWorkflows(role, status string) ([]Workflow, error) {
 	var workflows []Workflow
 	for rows.Next() {
 		var w Workflow
+		var activeVersion sql.NullInt64
 		if err := rows.Scan(&w.WorkflowID, &w.Name, &w.Description, &w.WorkflowType,
 			&w.OwnerID, &w.OwnerRole, &w.Status, &w.Version,
-			&w.PrePromptTemplate, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			&w.PrePromptTemplate, &w.CreatedAt, &w.UpdatedAt, &activeVersion); err != nil {
 			return nil, err
 		}
+		if activeVersion.Valid {
+			v := int(activeVersion.Int64)
+			w.ActiveVersion = &v
+		}
 		workflows = append(workflows, w)
 	}
 	return workflows, rows.Err()
 }
 
-// UpdateWorkflowStatus transitions a workflow to a new status.
+// UpdateWorkflowStatus transitions a workflow's latest version to a new
+// status. Activating it makes it the version runs execute and supersedes
+// the one they executed so far; archiving takes the workflow out of service.
 func (db *FlowsDB) UpdateWorkflowStatus(workflowID, status string, validatedBy *string, rejectionReason *string) error {
 	query := `UPDATE workflows SET status = ?, updated_at = datetime('now')`
+	vquery := `UPDATE workflow_versions SET status = ?`
 	args := []interface{}{status}
 
 	if validatedBy != nil {
 		query += `, validated_by = ?, validated_at = datetime('now')`
+		vquery += `, validated_by = ?, validated_at = datetime('now')`
 		args = append(args, *validatedBy)
 	}
 	if rejectionReason != nil {
 		query += `, rejection_reason = ?`
+		vquery += `, rejection_reason = ?`
 		args = append(args, *rejectionReason)
 	}
+	switch status {
+	case "active":
+		query += `, active_version = version`
+	case "archived":
+		query += `, active_version = NULL`
+	}
 
 	query += ` WHERE workflow_id = ?`
-	args = append(args, workflowID)
+	vquery += ` WHERE workflow_id = ? AND version = (SELECT version FROM workflows WHERE workflow_id = ?)`
 
-	_, err := db.Exec(query, args...)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	switch status {
+	case "active":
+		if _, err := tx.Exec(`UPDATE workflow_versions SET status = 'superseded' WHERE workflow_id = ? AND status = 'active'`, workflowID); err != nil {
+			return err
+		}
+	case "archived":
+		if _, err := tx.Exec(`UPDATE workflow_versions SET status = 'archived' WHERE workflow_id = ? AND status NOT IN ('superseded','rejected')`, workflowID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(vquery, append(args, workflowID, workflowID)...); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query, append(args, workflowID)...); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// UpdateWorkflow updates mutable fields of a draft workflow.
-func (db *FlowsDB) UpdateWorkflow(workflowID, name, description, prePromptTemplate string) error {
-	_, err := db.Exec(`
+// execer runs the statements shared by FlowsDB methods and the ones of a
+// transaction, such as a Draft's.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// updateWorkflow updates mutable fields of a draft workflow.
+func updateWorkflow(ex execer, workflowID, name, description, prePromptTemplate string) error {
+	if _, err := ex.Exec(`
+		UPDATE workflow_versions SET name = ?, description = ?, pre_prompt_template = ?
+		WHERE workflow_id = ? AND status = 'draft'
+			AND version = (SELECT version FROM workflows WHERE workflow_id = ?)`,
+		name, description, nilIfEmpty(prePromptTemplate), workflowID, workflowID); err != nil {
+		return err
+	}
+	_, err := ex.Exec(`
 		UPDATE workflows SET name = ?, description = ?, pre_prompt_template = ?, updated_at = datetime('now')
 		WHERE workflow_id = ? AND status = 'draft'`,
 		name, description, nilIfEmpty(prePromptTemplate), workflowID)
@@ -228,49 +335,79 @@ func (db *FlowsDB) UpdateWorkflow(workflowID, name, description, prePromptTempla
 
 // --- Step CRUD ---
 
-// CreateStep inserts a new workflow step.
+// CreateStep inserts a new workflow step, in the workflow's latest version
+// unless s.Version says otherwise.
 func (db *FlowsDB) CreateStep(s *WorkflowStep) error {
-	_, err := db.Exec(`
-		INSERT INTO workflow_steps (step_id, workflow_id, step_order, step_name, step_type,
+	return createStep(db, s)
+}
+
+func createStep(ex execer, s *WorkflowStep) error {
+	if s.Version == 0 {
+		if err := ex.QueryRow(`SELECT version FROM workflows WHERE workflow_id = ?`, s.WorkflowID).Scan(&s.Version); err != nil {
+			return err
+		}
+	}
+	_, err := ex.Exec(`
+		INSERT INTO workflow_steps (step_id, workflow_id, version, step_order, step_name, step_type,
 			provider, model, prompt_template, system_prompt, config_json,
-			criteria_list_id, timeout_ms, retry_max, fan_group)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		s.StepID, s.WorkflowID, s.StepOrder, s.StepName, s.StepType,
+			criteria_list_id, timeout_ms, retry_max, fan_group, depends_on)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.StepID, s.WorkflowID, s.Version, s.StepOrder, s.StepName, s.StepType,
 		nilIfEmpty(s.Provider), nilIfEmpty(s.Model),
 		nilIfEmpty(s.PromptTemplate), nilIfEmpty(s.SystemPrompt),
-		s.ConfigJSON, s.CriteriaListID, s.TimeoutMs, s.RetryMax, s.FanGroup)
+		s.ConfigJSON, s.CriteriaListID, s.TimeoutMs, s.RetryMax, s.FanGroup, dependsOnJSON(s.DependsOn))
 	return err
 }
 
-// UpdateStep updates an existing step.
-func (db *FlowsDB) UpdateStep(s *WorkflowStep) error {
-	_, err := db.Exec(`
+// updateStep updates an existing step of a draft version.
+func updateStep(ex execer, s *WorkflowStep) error {
+	res, err := ex.Exec(`
 		UPDATE workflow_steps SET step_order = ?, step_name = ?, step_type = ?,
 			provider = ?, model = ?, prompt_template = ?, system_prompt = ?,
-			config_json = ?, criteria_list_id = ?, timeout_ms = ?, retry_max = ?, fan_group = ?
-		WHERE step_id = ?`,
+			config_json = ?, criteria_list_id = ?, timeout_ms = ?, retry_max = ?, fan_group = ?,
+			depends_on = ?
+		WHERE step_id = ?`+draftStep,
 		s.StepOrder, s.StepName, s.StepType,
 		nilIfEmpty(s.Provider), nilIfEmpty(s.Model),
 		nilIfEmpty(s.PromptTemplate), nilIfEmpty(s.SystemPrompt),
 		s.ConfigJSON, s.CriteriaListID, s.TimeoutMs, s.RetryMax, s.FanGroup,
-		s.StepID)
-	return err
+		dependsOnJSON(s.DependsOn), s.StepID)
+	return stepChanged(res, err)
 }
 
-// DeleteStep removes a step.
-func (db *FlowsDB) DeleteStep(stepID string) error {
-	_, err := db.Exec(`DELETE FROM workflow_steps WHERE step_id = ?`, stepID)
-	return err
+// dependsOnJSON stores a step's dependencies as a JSON array, keeping nil
+// (no depends_on) apart from an empty list.
+func dependsOnJSON(names []string) interface{} {
+	if names == nil {
+		return nil
+	}
+	b, _ := json.Marshal(names)
+	return string(b)
 }
 
-// GetWorkflowSteps retrieves all steps for a workflow, ordered.
+// deleteStep removes a step of a draft version.
+func deleteStep(ex execer, stepID string) error {
+	res, err := ex.Exec(`DELETE FROM workflow_steps WHERE step_id = ?`+draftStep, stepID)
+	return stepChanged(res, err)
+}
+
+// GetWorkflowSteps retrieves all steps of a workflow's latest version, ordered.
 func (db *FlowsDB) GetWorkflowSteps(workflowID string) ([]WorkflowStep, error) {
+	var version int
+	if err := db.QueryRow(`SELECT version FROM workflows WHERE workflow_id = ?`, workflowID).Scan(&version); err != nil {
+		return nil, err
+	}
+	return db.GetVersionSteps(workflowID, version)
+}
+
+// GetVersionSteps retrieves the steps of one version of a workflow, ordered.
+func (db *FlowsDB) GetVersionSteps(workflowID string, version int) ([]WorkflowStep, error) {
 	rows, err := db.Query(`
-		SELECT step_id, workflow_id, step_order, step_name, step_type,
+		SELECT step_id, workflow_id, version, step_order, step_name, step_type,
 			COALESCE(provider,''), COALESCE(model,''),
 			COALESCE(prompt_template,''), COALESCE(system_prompt,''),
-			COALESCE(config_json,'{}'), criteria_list_id, timeout_ms, retry_max, fan_group, created_at
-		FROM workflow_steps WHERE workflow_id = ? ORDER BY step_order, step_name`, workflowID)
+			COALESCE(config_json,'{}'), criteria_list_id, timeout_ms, retry_max, fan_group, depends_on, created_at
+		FROM workflow_steps WHERE workflow_id = ? AND version = ? ORDER BY step_order, step_name`, workflowID, version)
 	if err != nil {
 		return nil, err
 	}
@@ -279,10 +416,10 @@ func (db *FlowsDB) GetWorkflowSteps(workflowID string) ([]WorkflowStep, error) {
 	var steps []WorkflowStep
 	for rows.Next() {
 		var s WorkflowStep
-		var criteriaID, fanGroup sql.NullString
-		if err := rows.Scan(&s.StepID, &s.WorkflowID, &s.StepOrder, &s.StepName, &s.StepType,
+		var criteriaID, fanGroup, dependsOn sql.NullString
+		if err := rows.Scan(&s.StepID, &s.WorkflowID, &s.Version, &s.StepOrder, &s.StepName, &s.StepType,
 			&s.Provider, &s.Model, &s.PromptTemplate, &s.SystemPrompt,
-			&s.ConfigJSON, &criteriaID, &s.TimeoutMs, &s.RetryMax, &fanGroup, &s.CreatedAt); err != nil {
+			&s.ConfigJSON, &criteriaID, &s.TimeoutMs, &s.RetryMax, &fanGroup, &dependsOn, &s.CreatedAt); err != nil {
 			return nil, err
 		}
 		if criteriaID.Valid {
@@ -291,19 +428,25 @@ func (db *FlowsDB) GetWorkflowSteps(workflowID string) ([]WorkflowStep, error) {
 		if fanGroup.Valid {
 			s.FanGroup = &fanGroup.String
 		}
+		if dependsOn.Valid {
+			if err := json.Unmarshal([]byte(dependsOn.String), &s.DependsOn); err != nil {
+				return nil, fmt.Errorf("step %s depends_on: %w", s.StepID, err)
+			}
+		}
 		steps = append(steps, s)
 	}
 	return steps, rows.Err()
 }
 
 // ReorderSteps updates step_order for a list of step IDs in the given order.
+// Only the steps of a draft version move.
 func (db *FlowsDB) ReorderSteps(workflowID string, stepIDs []string) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	for i, sid := range stepIDs {
-		if _, err := tx.Exec(`UPDATE workflow_steps SET step_order = ? WHERE step_id = ? AND workflow_id = ?`,
+		if _, err := tx.Exec(`UPDATE workflow_steps SET step_order = ? WHERE step_id = ? AND workflow_id = ?`+draftStep,
 			i+1, sid, workflowID); err != nil {
 			_ = tx.Rollback()
 			return err
@@ -316,7 +459,11 @@ func (db *FlowsDB) ReorderSteps(workflowID string, stepIDs []string) error {
 
 // CreateCriteriaList inserts a new criteria list.
 func (db *FlowsDB) CreateCriteriaList(cl *CriteriaList) error {
-	_, err := db.Exec(`
+	return createCriteriaList(db, cl)
+}
+
+func createCriteriaList(ex execer, cl *CriteriaList) error {
+	_, err := ex.Exec(`
 		INSERT INTO criteria_lists (list_id, name, description, items_json, owner_id)
 		VALUES (?, ?, ?, ?, ?)`,
 		cl.ListID, cl.Name, cl.Description, cl.ItemsJSON, cl.OwnerID)
@@ -339,8 +486,12 @@ func (db *FlowsDB) GetCriteriaList(listID string) (*CriteriaList, error) {
 
 // GetCriteriaListByName retrieves a criteria list by name.
 func (db *FlowsDB) GetCriteriaListByName(name string) (*CriteriaList, error) {
+	return getCriteriaListByName(db, name)
+}
+
+func getCriteriaListByName(ex execer, name string) (*CriteriaList, error) {
 	cl := &CriteriaList{}
-	err := db.QueryRow(`
+	err := ex.QueryRow(`
 		SELECT list_id, name, description, items_json, owner_id, created_at, updated_at
 		FROM criteria_lists WHERE name = ?`, name).Scan(
 		&cl.ListID, &cl.Name, &cl.Description, &cl.ItemsJSON, &cl.OwnerID,
@@ -387,37 +538,45 @@ func (db *FlowsDB) UpdateCriteriaList(listID, name, description, itemsJSON strin
 // CreateWorkflowRun inserts a new run.
 func (db *FlowsDB) CreateWorkflowRun(r *WorkflowRun) error {
 	_, err := db.Exec(`
-		INSERT INTO workflow_runs (run_id, workflow_id, node_id, initiated_by, status, pre_prompt, batch_id, total_steps)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		r.RunID, r.WorkflowID, r.NodeID, r.InitiatedBy, r.Status, r.PrePrompt, r.BatchID, r.TotalSteps)
+		INSERT INTO workflow_runs (run_id, workflow_id, node_id, initiated_by, status, pre_prompt, input_json, workflow_version, batch_id, total_steps)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.RunID, r.WorkflowID, r.NodeID, r.InitiatedBy, r.Status, r.PrePrompt, r.InputJSON, r.WorkflowVersion, r.BatchID, r.TotalSteps)
 	return err
 }
 
 // GetWorkflowRun retrieves a run by ID.
 func (db *FlowsDB) GetWorkflowRun(runID string) (*WorkflowRun, error) {
 	r := &WorkflowRun{}
-	var nodeID, prePrompt, batchID, resultJSON, errStr sql.NullString
+	var nodeID, prePrompt, inputJSON, batchID, resultJSON, errStr sql.NullString
 	var startedAt, completedAt sql.NullTime
+	var version sql.NullInt64
 	err := db.QueryRow(`
 		SELECT r.run_id, r.workflow_id, r.node_id, r.initiated_by, r.status,
-			r.pre_prompt, r.batch_id, r.total_steps, r.completed_steps,
+			r.pre_prompt, r.input_json, r.workflow_version, r.batch_id, r.total_steps, r.completed_steps,
 			r.result_json, r.error, r.started_at, r.completed_at, r.created_at,
 			w.name
 		FROM workflow_runs r JOIN workflows w ON r.workflow_id = w.workflow_id
 		WHERE r.run_id = ?`, runID).Scan(
 		&r.RunID, &r.WorkflowID, &nodeID, &r.InitiatedBy, &r.Status,
-		&prePrompt, &batchID, &r.TotalSteps, &r.CompletedSteps,
+		&prePrompt, &inputJSON, &version, &batchID, &r.TotalSteps, &r.CompletedSteps,
 		&resultJSON, &errStr, &startedAt, &completedAt, &r.CreatedAt,
 		&r.WorkflowName)
 	if err != nil {
 		return nil, err
 	}
+	if version.Valid {
+		v := int(version.Int64)
+		r.WorkflowVersion = &v
+	}
 	if nodeID.Valid {
 		r.NodeID = &nodeID.String
 	}
 	if prePrompt.Valid {
 		r.PrePrompt = &prePrompt.String
 	}
+	if inputJSON.Valid {
+		r.InputJSON = &inputJSON.String
+	}
 	if batchID.Valid {
 		r.BatchID = &batchID.String
 	}
@@ -463,12 +622,102 @@ func (db *FlowsDB) UpdateRunStatus(runID, status string, resultJSON, errMsg *str
 	return err
 }
 
+// ListInterruptedRuns returns the IDs of the runs left pending or running,
+// oldest first. At startup these are the runs a previous process did not
+// get to finish.
+func (db *FlowsDB) ListInterruptedRuns() ([]string, error) {
+	rows, err := db.Query(`SELECT run_id FROM workflow_runs WHERE status IN ('pending','running') ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RestartRun puts a run back to running to go on from its completed steps:
+// the outcome of its previous attempt is cleared and completed_steps
+// recounted.
+func (db *FlowsDB) RestartRun(runID string) error {
+	_, err := db.Exec(`
+		UPDATE workflow_runs SET status = 'running', result_json = NULL, error = NULL, completed_at = NULL,
+			started_at = COALESCE(started_at, datetime('now')),
+			completed_steps = (SELECT COUNT(*) FROM workflow_step_runs WHERE run_id = ? AND status = 'completed')
+		WHERE run_id = ?`, runID, runID)
+	return err
+}
+
+// InterruptStepRuns fails the step runs of a run still pending or running,
+// whose execution was lost.
+func (db *FlowsDB) InterruptStepRuns(runID string) error {
+	_, err := db.Exec(`
+		UPDATE workflow_step_runs SET status = 'failed', error = 'interrupted', completed_at = datetime('now')
+		WHERE run_id = ? AND status IN ('pending','running')`, runID)
+	return err
+}
+
+// SupersedeStepRuns marks the completed runs of steps as skipped, so that
+// their outputs are kept for the record but no longer count for the run.
+func (db *FlowsDB) SupersedeStepRuns(runID string, stepIDs []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, id := range stepIDs {
+		if _, err := tx.Exec(`UPDATE workflow_step_runs SET status = 'skipped' WHERE run_id = ? AND step_id = ? AND status = 'completed'`,
+			runID, id); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CompletedStepOutputs returns the output of each completed step of a run,
+// by step ID.
+func (db *FlowsDB) CompletedStepOutputs(runID string) (map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT step_id, COALESCE(output_json,'') FROM workflow_step_runs
+		WHERE run_id = ? AND status = 'completed'`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	outputs := make(map[string]string)
+	for rows.Next() {
+		var stepID, output string
+		if err := rows.Scan(&stepID, &output); err != nil {
+			return nil, err
+		}
+		outputs[stepID] = output
+	}
+	return outputs, rows.Err()
+}
+
 // IncrementCompletedSteps atomically increments the completed step counter.
 func (db *FlowsDB) IncrementCompletedSteps(runID string) error {
 	_, err := db.Exec(`UPDATE workflow_runs SET completed_steps = completed_steps + 1 WHERE run_id = ?`, runID)
 	return err
 }
 
+// StepRunModel returns the model that answered a run's completed step,
+// by step name, or "" when it called none.
+func (db *FlowsDB) StepRunModel(runID, stepName string) string {
+	var model sql.NullString
+	_ = db.QueryRow(`
+		SELECT sr.model_used FROM workflow_step_runs sr JOIN workflow_steps s ON s.step_id = sr.step_id
+		WHERE sr.run_id = ? AND s.step_name = ? AND sr.status = 'completed'
+		ORDER BY sr.completed_at DESC LIMIT 1`, runID, stepName).Scan(&model)
+	return model.String
+}
+
 // GetStepRuns retrieves all step runs for a workflow run.
 func (db *FlowsDB) GetStepRuns(runID string) ([]WorkflowStepRun, error) {
 	rows, err := db.Query(`
@@ -527,7 +776,7 @@ func (db *FlowsDB) GetStepRuns(runID string) ([]WorkflowStepRun, error) {
 func (db *FlowsDB) ListRunsByBatch(batchID string) ([]WorkflowRun, error) {
 	rows, err := db.Query(`
 		SELECT r.run_id, r.workflow_id, r.node_id, r.initiated_by, r.status,
-			r.total_steps, r.completed_steps, r.created_at, w.name
+			r.workflow_version, r.total_steps, r.completed_steps, r.created_at, w.name
 		FROM workflow_runs r JOIN workflows w ON r.workflow_id = w.workflow_id
 		WHERE r.batch_id = ? ORDER BY r.created_at`, batchID)
 	if err != nil {
@@ -539,13 +788,18 @@ func (db *FlowsDB) ListRunsByBatch(batchID string) ([]WorkflowRun, error) {
 	for rows.Next() {
 		var r WorkflowRun
 		var nodeID sql.NullString
+		var version sql.NullInt64
 		if err := rows.Scan(&r.RunID, &r.WorkflowID, &nodeID, &r.InitiatedBy, &r.Status,
-			&r.TotalSteps, &r.CompletedSteps, &r.CreatedAt, &r.WorkflowName); err != nil {
+			&version, &r.TotalSteps, &r.CompletedSteps, &r.CreatedAt, &r.WorkflowName); err != nil {
 			return nil, err
 		}
 		if nodeID.Valid {
 			r.NodeID = &nodeID.String
 		}
+		if version.Valid {
+			v := int(version.Int64)
+			r.WorkflowVersion = &v
+		}
 		runs = append(runs, r)
 	}
 	return runs, rows.Err()
@@ -618,11 +872,11 @@ func (db *FlowsDB) CountWorkflows() int {
 func AllowedStepTypes(role string) map[string]bool {
 	switch role {
 	case "operator":
-		return map[string]bool{"llm": true, "check": true}
+		return map[string]bool{"llm": true, "check": true, "node_write": true}
 	case "provider":
 		return map[string]bool{"llm": true, "check": true, "sql": true}
 	case "admin", "operator_admin":
-		return map[string]bool{"llm": true, "check": true, "sql": true, "http": true}
+		return map[string]bool{"llm": true, "check": true, "sql": true, "http": true, "node_write": true}
 	default:
 		return map[string]bool{}
 	}
@@ -8,42 +8,138 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
 // AnthropicProvider implements the Provider interface for Anthropic's Messages API.
 type AnthropicProvider struct {
-	apiKey string
-	models []string
-	client *http.Client
+	name    string
+	baseURL string
+	apiKey  string
+	models  []string
+	client  *http.Client
 }
 
 func NewAnthropicProvider(apiKey string) *AnthropicProvider {
+	return NewAnthropicEndpoint("anthropic", "https://api.anthropic.com/v1", apiKey,
+		[]string{"claude-sonnet-4-5-20250929", "claude-haiku-4-5-20251001"})
+}
+
+// NewAnthropicEndpoint creates a provider for a Messages-compatible API
+// served at baseURL (the part before "/messages"), under its own name.
+func NewAnthropicEndpoint(name, baseURL, apiKey string, models []string) *AnthropicProvider {
 	return &AnthropicProvider{
-		apiKey: apiKey,
-		models: []string{"claude-sonnet-4-5-20250929", "claude-haiku-4-5-20251001"},
-		client: &http.Client{Timeout: 120 * time.Second},
+		name:    name,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		models:  models,
+		client:  &http.Client{Timeout: 120 * time.Second},
 	}
 }
 
-func (p *AnthropicProvider) Name() string     { return "anthropic" }
+func (p *AnthropicProvider) Name() string     { return p.name }
 func (p *AnthropicProvider) Models() []string  { return p.models }
 
 func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	body := p.buildRequest(req)
+	model := body.Model
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/messages", bytes.NewReader(payload))
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+
+	start := time.Now()
+	httpResp, err := p.client.Do(httpReq)
+	latency := time.Since(start)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+
+	if httpResp.StatusCode != 200 {
+		return nil, httpError(p.name, model, httpResp, respBody)
+	}
+
+	var anthResp anthropicResponse
+	if err := json.Unmarshal(respBody, &anthResp); err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("decoding response: %w", err)}
+	}
+
+	var content string
+	var calls []ToolCall
+	for _, block := range anthResp.Content {
+		switch block.Type {
+		case "text":
+			content += block.Text
+		case "tool_use":
+			calls = append(calls, ToolCall{ID: block.ID, Name: block.Name, Arguments: block.Input})
+		}
+	}
+
+	return &Response{
+		Provider:     p.name,
+		Model:        anthResp.Model,
+		Content:      content,
+		TokensIn:     anthResp.Usage.InputTokens,
+		TokensOut:    anthResp.Usage.OutputTokens,
+		FinishReason: anthResp.StopReason,
+		Latency:      latency,
+		ToolCalls:    calls,
+	}, nil
+}
+
+// buildRequest converts a provider-agnostic Request into the Messages API format.
+// The system message is lifted out of the conversation into the system field.
+// Tool calls become tool_use blocks of the assistant turn, and consecutive
+// tool results are grouped as tool_result blocks of one user turn.
+func (p *AnthropicProvider) buildRequest(req Request) anthropicRequest {
 	model := req.Model
 	if model == "" {
 		model = p.models[0]
 	}
 
-	// Extract system message if present
 	var system string
 	messages := make([]anthropicMessage, 0, len(req.Messages))
 	for _, m := range req.Messages {
-		if m.Role == "system" {
+		switch {
+		case m.Role == "system":
 			system = m.Content
-			continue
+		case m.Role == "tool":
+			result := anthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content}
+			if n := len(messages); n > 0 && messages[n-1].Role == "user" {
+				if blocks, ok := messages[n-1].Content.([]anthropicBlock); ok {
+					messages[n-1].Content = append(blocks, result)
+					continue
+				}
+			}
+			messages = append(messages, anthropicMessage{Role: "user", Content: []anthropicBlock{result}})
+		case len(m.ToolCalls) > 0:
+			var blocks []anthropicBlock
+			if m.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Name, Input: tc.Arguments})
+			}
+			messages = append(messages, anthropicMessage{Role: m.Role, Content: blocks})
+		default:
+			messages = append(messages, anthropicMessage{Role: m.Role, Content: m.Content})
 		}
-		messages = append(messages, anthropicMessage(m))
 	}
 
 	body := anthropicRequest{
@@ -63,62 +159,92 @@ func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Respons
 	if req.TopP > 0 {
 		body.TopP = &req.TopP
 	}
+	for _, t := range req.Tools {
+		body.Tools = append(body.Tools, anthropicTool{Name: t.Name, Description: t.Description, InputSchema: t.Parameters})
+	}
+	return body
+}
+
+// Stream sends a Messages request with stream=true. Text arrives in
+// content_block_delta events; input tokens in message_start and output
+// tokens plus stop reason in message_delta.
+func (p *AnthropicProvider) Stream(ctx context.Context, req Request, fn StreamFunc) (*Response, error) {
+	body := p.buildRequest(req)
+	model := body.Model
+	body.Stream = true
 
 	payload, err := json.Marshal(body)
 	if err != nil {
-		return nil, &ProviderError{Provider: "anthropic", Model: model, Err: err}
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewReader(payload))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/messages", bytes.NewReader(payload))
 	if err != nil {
-		return nil, &ProviderError{Provider: "anthropic", Model: model, Err: err}
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
 	httpReq.Header.Set("x-api-key", p.apiKey)
 	httpReq.Header.Set("anthropic-version", "2023-06-01")
 
 	start := time.Now()
 	httpResp, err := p.client.Do(httpReq)
-	latency := time.Since(start)
 	if err != nil {
-		return nil, &ProviderError{Provider: "anthropic", Model: model, Err: err}
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 	defer httpResp.Body.Close()
 
-	respBody, err := io.ReadAll(httpResp.Body)
-	if err != nil {
-		return nil, &ProviderError{Provider: "anthropic", Model: model, Err: err}
-	}
-
-	if httpResp.StatusCode == 429 {
-		return nil, &ProviderError{Provider: "anthropic", Model: model, Err: ErrRateLimited}
-	}
 	if httpResp.StatusCode != 200 {
-		return nil, &ProviderError{Provider: "anthropic", Model: model,
-			Err: fmt.Errorf("HTTP %d: %s", httpResp.StatusCode, truncate(string(respBody), 200))}
-	}
-
-	var anthResp anthropicResponse
-	if err := json.Unmarshal(respBody, &anthResp); err != nil {
-		return nil, &ProviderError{Provider: "anthropic", Model: model, Err: fmt.Errorf("decoding response: %w", err)}
+		respBody, _ := io.ReadAll(httpResp.Body)
+		return nil, httpError(p.name, model, httpResp, respBody)
 	}
 
-	var content string
-	for _, block := range anthResp.Content {
-		if block.Type == "text" {
-			content += block.Text
+	resp := &Response{Provider: p.name, Model: model}
+	var content strings.Builder
+	err = readSSE(httpResp.Body, func(event, data string) error {
+		var ev anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("decoding stream event: %w", err)
+		}
+		if event == "" {
+			event = ev.Type
+		}
+		switch event {
+		case "message_start":
+			if ev.Message != nil {
+				if ev.Message.Model != "" {
+					resp.Model = ev.Message.Model
+				}
+				resp.TokensIn = ev.Message.Usage.InputTokens
+			}
+		case "content_block_delta":
+			if ev.Delta == nil || ev.Delta.Text == "" {
+				return nil
+			}
+			content.WriteString(ev.Delta.Text)
+			return fn(ev.Delta.Text)
+		case "message_delta":
+			if ev.Delta != nil && ev.Delta.StopReason != "" {
+				resp.FinishReason = ev.Delta.StopReason
+			}
+			if ev.Usage != nil {
+				resp.TokensOut = ev.Usage.OutputTokens
+			}
+		case "error":
+			if ev.Error != nil {
+				return fmt.Errorf("%s: %s", ev.Error.Type, ev.Error.Message)
+			}
+			return fmt.Errorf("stream error")
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 
-	return &Response{
-		Provider:     "anthropic",
-		Model:        anthResp.Model,
-		Content:      content,
-		TokensIn:     anthResp.Usage.InputTokens,
-		TokensOut:    anthResp.Usage.OutputTokens,
-		FinishReason: anthResp.StopReason,
-		Latency:      latency,
-	}, nil
+	resp.Content = content.String()
+	resp.Latency = time.Since(start)
+	return resp, nil
 }
 
 // Anthropic API types
@@ -129,22 +255,59 @@ type anthropicRequest struct {
 	MaxTokens   int                `json:"max_tokens"`
 	Temperature *float64           `json:"temperature,omitempty"`
 	TopP        *float64           `json:"top_p,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
 }
 
 type anthropicMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"` // string, or []anthropicBlock for tool turns
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"` // "text", "tool_use" or "tool_result"
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
 }
 
 type anthropicResponse struct {
-	Model      string `json:"model"`
-	Content    []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-	} `json:"content"`
-	StopReason string `json:"stop_reason"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
 	Usage      struct {
 		InputTokens  int `json:"input_tokens"`
 		OutputTokens int `json:"output_tokens"`
 	} `json:"usage"`
 }
+
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message *struct {
+		Model string `json:"model"`
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta *struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
@@ -0,0 +1,204 @@
+// CLAUDE:SUMMARY Per-provider circuit breaker (closed/open/half-open) driven by classified ProviderErrors, with health snapshots
+package llm
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a provider circuit breaker.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// Breaker tuning. Server errors and timeouts open the circuit after
+// breakerFailureThreshold consecutive failures; a rate limit opens it
+// immediately for Retry-After; an auth failure opens it for a long time
+// since a bad key does not heal by itself. Each failed half-open probe
+// doubles the cooldown up to breakerMaxCooldown.
+const (
+	breakerFailureThreshold = 3
+	breakerBaseCooldown     = 30 * time.Second
+	breakerMaxCooldown      = 5 * time.Minute
+	breakerRateLimitDefault = 30 * time.Second
+	breakerAuthCooldown     = 10 * time.Minute
+)
+
+// ProviderHealth is a point-in-time snapshot of a provider's breaker.
+// LastError is deliberately not exposed: transport errors can embed
+// request URLs, and some providers (Gemini) carry the API key in the URL.
+type ProviderHealth struct {
+	Provider            string       `json:"provider"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastErrorClass      ErrorClass   `json:"last_error_class,omitempty"`
+	LastStatusCode      int          `json:"last_status_code,omitempty"`
+	LastFailureAt       *time.Time   `json:"last_failure_at,omitempty"`
+	LastSuccessAt       *time.Time   `json:"last_success_at,omitempty"`
+	OpenUntil           *time.Time   `json:"open_until,omitempty"`
+	TotalSuccesses      int64        `json:"total_successes"`
+	TotalFailures       int64        `json:"total_failures"`
+	TotalRejected       int64        `json:"total_rejected"`
+}
+
+// breaker tracks one provider's health.
+type breaker struct {
+	mu          sync.Mutex
+	state       BreakerState
+	failures    int // consecutive counted failures
+	cooldown    time.Duration
+	openUntil   time.Time
+	probing     bool // a half-open probe is in flight
+	lastClass   ErrorClass
+	lastStatus  int
+	lastFailure time.Time
+	lastSuccess time.Time
+	successes   int64
+	total       int64
+	rejected    int64
+}
+
+func newBreaker() *breaker {
+	return &breaker{state: BreakerClosed, cooldown: breakerBaseCooldown}
+}
+
+// allow reports whether a call may go through now. When the cooldown of an
+// open breaker has elapsed, exactly one caller is let through as a probe.
+// The returned duration is the remaining wait when the call is refused.
+func (b *breaker) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Before(b.openUntil) {
+			b.rejected++
+			return false, b.openUntil.Sub(now)
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true, 0
+	case BreakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return false, 0
+		}
+		b.probing = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// record updates the breaker with the outcome of a call that allow let through.
+func (b *breaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.state == BreakerHalfOpen
+	b.probing = false
+
+	class := Classify(err)
+	switch class {
+	case ErrClassNone:
+		b.successes++
+		b.lastSuccess = now
+		b.failures = 0
+		b.cooldown = breakerBaseCooldown
+		b.state = BreakerClosed
+		return
+	case ErrClassCanceled, ErrClassClient:
+		// Not the provider's fault — leave the state untouched.
+		return
+	}
+
+	b.total++
+	b.failures++
+	b.lastClass = class
+	b.lastFailure = now
+	b.lastStatus = 0
+	var retryAfter time.Duration
+	if pe, ok := asProviderError(err); ok {
+		b.lastStatus = pe.StatusCode
+		retryAfter = pe.RetryAfter
+	}
+
+	switch {
+	case class == ErrClassRateLimit:
+		if retryAfter <= 0 {
+			retryAfter = breakerRateLimitDefault
+		}
+		b.trip(now, retryAfter)
+	case class == ErrClassAuth:
+		b.trip(now, breakerAuthCooldown)
+	case wasProbe:
+		b.cooldown *= 2
+		if b.cooldown > breakerMaxCooldown {
+			b.cooldown = breakerMaxCooldown
+		}
+		b.trip(now, b.cooldown)
+	case b.failures >= breakerFailureThreshold:
+		b.trip(now, b.cooldown)
+	}
+}
+
+// abandon ends a call that allow let through without a verdict on the
+// provider — it never reached it, or its caller gave up first — freeing the
+// half-open probe slot and leaving the state untouched.
+func (b *breaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) trip(now time.Time, d time.Duration) {
+	b.state = BreakerOpen
+	b.openUntil = now.Add(d)
+}
+
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+	b.cooldown = breakerBaseCooldown
+	b.openUntil = time.Time{}
+}
+
+func (b *breaker) snapshot(name string, now time.Time) ProviderHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := ProviderHealth{
+		Provider:            name,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastErrorClass:      b.lastClass,
+		LastStatusCode:      b.lastStatus,
+		TotalSuccesses:      b.successes,
+		TotalFailures:       b.total,
+		TotalRejected:       b.rejected,
+	}
+	// An open breaker whose cooldown elapsed is effectively half-open:
+	// the next call will be let through as a probe.
+	if b.state == BreakerOpen && !now.Before(b.openUntil) {
+		h.State = BreakerHalfOpen
+	}
+	if b.state == BreakerOpen && now.Before(b.openUntil) {
+		t := b.openUntil
+		h.OpenUntil = &t
+	}
+	if !b.lastFailure.IsZero() {
+		t := b.lastFailure
+		h.LastFailureAt = &t
+	}
+	if !b.lastSuccess.IsZero() {
+		t := b.lastSuccess
+		h.LastSuccessAt = &t
+	}
+	return h
+}
@@ -0,0 +1,167 @@
+// CLAUDE:SUMMARY Content-addressed LLM response cache — request hashing, per-request cache modes (use/refresh/bypass) and TTLs, backed by flows.db llm_cache
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// CacheMode selects how a request uses the response cache.
+type CacheMode string
+
+const (
+	CacheDefault CacheMode = ""        // the mode from the context, else CacheUse
+	CacheUse     CacheMode = "use"     // serve a live entry, otherwise call and store
+	CacheRefresh CacheMode = "refresh" // always call the provider and overwrite the entry
+	CacheBypass  CacheMode = "bypass"  // neither read nor write the cache
+)
+
+// ParseCacheMode validates a cache mode name ("" is CacheDefault).
+func ParseCacheMode(s string) (CacheMode, error) {
+	switch m := CacheMode(s); m {
+	case CacheDefault, CacheUse, CacheRefresh, CacheBypass:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid cache mode %q (use, refresh or bypass)", s)
+}
+
+type cacheModeKey struct{}
+
+// WithCacheMode sets the cache mode of every request made with ctx that
+// does not set Request.Cache itself.
+func WithCacheMode(ctx context.Context, mode CacheMode) context.Context {
+	return context.WithValue(ctx, cacheModeKey{}, mode)
+}
+
+// Cache stores provider responses in flows.db under a hash of the request,
+// so that re-running an identical prompt costs nothing.
+type Cache struct {
+	flowsDB *db.FlowsDB
+	ttl     time.Duration // default TTL; 0 = entries never expire
+}
+
+// NewCache creates a response cache backed by flows.db. ttl is the default
+// lifetime of an entry (0 = until purged); Request.CacheTTL overrides it.
+func NewCache(flowsDB *db.FlowsDB, ttl time.Duration) *Cache {
+	return &Cache{flowsDB: flowsDB, ttl: ttl}
+}
+
+// TTL returns the default lifetime of a cache entry (0 = until purged).
+func (c *Cache) TTL() time.Duration {
+	return c.ttl
+}
+
+// CacheKey is the hex SHA-256 of everything that determines a response:
+// provider, model, messages, temperature, top_p, seed, max_tokens and output
+// schema. max_tokens is part of it since a reply cut off at a small budget
+// (finish_reason "length") does not answer a request with a larger one.
+func CacheKey(provider string, req Request) string {
+	var schema json.RawMessage
+	if req.Schema != nil {
+		schema = req.Schema.Doc
+	}
+	b, _ := json.Marshal(struct {
+		Provider    string          `json:"provider"`
+		Model       string          `json:"model"`
+		Messages    []Message       `json:"messages"`
+		Temperature float64         `json:"temperature"`
+		TopP        float64         `json:"top_p"`
+		Seed        *int            `json:"seed"`
+		MaxTokens   int             `json:"max_tokens"`
+		Schema      json.RawMessage `json:"schema,omitempty"`
+	}{provider, req.Model, req.Messages, req.Temperature, req.TopP, req.Seed, req.MaxTokens, schema})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetCache enables the response cache. Without it every request goes to
+// a provider whatever its cache mode.
+func (c *Client) SetCache(cache *Cache) {
+	c.cache = cache
+}
+
+// Cache returns the client's response cache, or nil when caching is off.
+func (c *Client) Cache() *Cache {
+	return c.cache
+}
+
+func (c *Client) cacheMode(ctx context.Context, req Request) CacheMode {
+	if req.Cache != CacheDefault {
+		return req.Cache
+	}
+	if m, ok := ctx.Value(cacheModeKey{}).(CacheMode); ok && m != CacheDefault {
+		return m
+	}
+	return CacheUse
+}
+
+// fromCache looks req up for the named provider. It returns the cached
+// response on a hit; otherwise a store func that saves a successful
+// response under the request's key.
+func (c *Client) fromCache(ctx context.Context, name string, req Request) (*Response, func(*Response)) {
+	noop := func(*Response) {}
+	if c.cache == nil {
+		return nil, noop
+	}
+	mode := c.cacheMode(ctx, req)
+	if mode == CacheBypass || len(req.Tools) > 0 {
+		// Tool-calling turns are not cached: the cache does not keep the
+		// calls, and tool results depend on the live database.
+		return nil, noop
+	}
+
+	key := CacheKey(name, req)
+	if mode == CacheUse {
+		start := time.Now()
+		entry, err := c.cache.flowsDB.GetCachedResponse(key)
+		if err != nil {
+			slog.Warn("llm cache lookup", "provider", name, "error", err)
+		}
+		if entry != nil {
+			return &Response{
+				Provider:     name,
+				Model:        entry.Model,
+				Content:      entry.Content,
+				TokensIn:     entry.TokensIn,
+				TokensOut:    entry.TokensOut,
+				FinishReason: entry.FinishReason,
+				Latency:      time.Since(start),
+				Cached:       true,
+			}, noop
+		}
+	}
+
+	ttl := c.cache.ttl
+	if req.CacheTTL > 0 {
+		ttl = req.CacheTTL
+	}
+	return nil, func(resp *Response) {
+		if resp == nil {
+			return
+		}
+		model := resp.Model
+		if model == "" {
+			model = req.Model
+		}
+		err := c.cache.flowsDB.PutCachedResponse(&db.CachedResponse{
+			CacheKey:     key,
+			Provider:     name,
+			Model:        model,
+			Content:      resp.Content,
+			TokensIn:     resp.TokensIn,
+			TokensOut:    resp.TokensOut,
+			FinishReason: resp.FinishReason,
+			CostUSD:      resp.CostUSD,
+		}, ttl)
+		if err != nil {
+			slog.Warn("llm cache store", "provider", name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+// CLAUDE:SUMMARY Cassette providers for offline deterministic runs — record live responses to a JSONL cassette, replay them by request fingerprint (or from flow_steps), or answer from a per-step script
+package llm
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// Provider modes, selected with [llm] mode in config.toml.
+const (
+	ModeLive     = "live"     // real providers (default)
+	ModeRecord   = "record"   // real providers, every response appended to the cassette
+	ModeReplay   = "replay"   // no network: responses come from the cassette or flow_steps
+	ModeScripted = "scripted" // no network: canned responses per step name
+)
+
+type stepNameKey struct{}
+
+// WithStepName labels the LLM calls made with ctx with the flow or workflow
+// step that issued them. Cassettes record it and scripts answer by it.
+func WithStepName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, stepNameKey{}, name)
+}
+
+// StepNameFrom returns the step name attached to ctx ("" if none).
+func StepNameFrom(ctx context.Context) string {
+	s, _ := ctx.Value(stepNameKey{}).(string)
+	return s
+}
+
+// Fingerprint identifies a request independently of the provider it is
+// sent to: model, messages, temperature, top_p and seed.
+func Fingerprint(req Request) string {
+	b, _ := json.Marshal(struct {
+		Model       string    `json:"model"`
+		Messages    []Message `json:"messages"`
+		Temperature float64   `json:"temperature"`
+		TopP        float64   `json:"top_p"`
+		Seed        *int      `json:"seed"`
+	}{req.Model, req.Messages, req.Temperature, req.TopP, req.Seed})
+	return hashHex(b)
+}
+
+// promptFingerprint identifies a request by its messages only, so that a
+// replay still matches when the model was resolved differently.
+func promptFingerprint(messages []Message) string {
+	b, _ := json.Marshal(messages)
+	return hashHex(b)
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// CassetteEntry is one recorded exchange — one JSON line of a cassette file.
+type CassetteEntry struct {
+	Fingerprint       string     `json:"fingerprint"`
+	PromptFingerprint string     `json:"prompt_fingerprint"`
+	Provider          string     `json:"provider"`
+	Model             string     `json:"model"`
+	Step              string     `json:"step,omitempty"`
+	Messages          []Message  `json:"messages"`
+	Content           string     `json:"content"`
+	ToolCalls         []ToolCall `json:"tool_calls,omitempty"`
+	TokensIn          int        `json:"tokens_in"`
+	TokensOut         int        `json:"tokens_out"`
+	FinishReason      string     `json:"finish_reason,omitempty"`
+	LatencyMs         int        `json:"latency_ms"`
+	RecordedAt        time.Time  `json:"recorded_at"`
+}
+
+// Cassette is an append-only JSONL file of recorded exchanges, indexed by
+// fingerprint. The most recent recording of a fingerprint wins.
+type Cassette struct {
+	path    string
+	flowsDB *db.FlowsDB // optional second replay source
+
+	mu       sync.RWMutex
+	byFP     map[string]*CassetteEntry // provider + "\x00" + fingerprint
+	byAnyFP  map[string]*CassetteEntry // fingerprint
+	byPrompt map[string]*CassetteEntry // prompt fingerprint
+}
+
+// OpenCassette loads the cassette at path. A missing file is an empty
+// cassette; it is created on the first Record.
+func OpenCassette(path string) (*Cassette, error) {
+	c := &Cassette{
+		path:     path,
+		byFP:     make(map[string]*CassetteEntry),
+		byAnyFP:  make(map[string]*CassetteEntry),
+		byPrompt: make(map[string]*CassetteEntry),
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening cassette: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e CassetteEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("cassette %s line %d: %w", path, line, err)
+		}
+		c.index(&e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading cassette: %w", err)
+	}
+	return c, nil
+}
+
+func (c *Cassette) index(e *CassetteEntry) {
+	if e.Fingerprint != "" {
+		c.byFP[e.Provider+"\x00"+e.Fingerprint] = e
+		c.byAnyFP[e.Fingerprint] = e
+	}
+	if e.PromptFingerprint == "" {
+		e.PromptFingerprint = promptFingerprint(e.Messages)
+	}
+	c.byPrompt[e.PromptFingerprint] = e
+}
+
+// Len returns the number of distinct recorded requests.
+func (c *Cassette) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.byPrompt)
+}
+
+// UseFlowSteps makes replay fall back to past flow_steps rows with the
+// same system and user prompt. Every flow, resolution and dispatch already
+// persists its steps there, so a live run doubles as a recording.
+func (c *Cassette) UseFlowSteps(flowsDB *db.FlowsDB) {
+	c.flowsDB = flowsDB
+}
+
+// Record appends an exchange to the cassette file and indexes it.
+func (c *Cassette) Record(provider, step string, req Request, resp *Response) error {
+	model := resp.Model
+	if model == "" {
+		model = req.Model
+	}
+	e := &CassetteEntry{
+		Fingerprint:       Fingerprint(req),
+		PromptFingerprint: promptFingerprint(req.Messages),
+		Provider:          provider,
+		Model:             model,
+		Step:              step,
+		Messages:          req.Messages,
+		Content:           resp.Content,
+		ToolCalls:         resp.ToolCalls,
+		TokensIn:          resp.TokensIn,
+		TokensOut:         resp.TokensOut,
+		FinishReason:      resp.FinishReason,
+		LatencyMs:         int(resp.Latency.Milliseconds()),
+		RecordedAt:        time.Now().UTC(),
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("creating cassette dir: %w", err)
+	}
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening cassette: %w", err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing cassette: %w", err)
+	}
+	c.index(e)
+	return f.Close()
+}
+
+// Lookup finds the recorded response for req sent to provider. Matches are
+// tried from most to least specific: same provider and fingerprint, any
+// provider with that fingerprint, same messages, then flow_steps.
+func (c *Cassette) Lookup(provider string, req Request) (*CassetteEntry, bool) {
+	fp := Fingerprint(req)
+	pfp := promptFingerprint(req.Messages)
+
+	c.mu.RLock()
+	e, ok := c.byFP[provider+"\x00"+fp]
+	if !ok {
+		e, ok = c.byAnyFP[fp]
+	}
+	if !ok {
+		e, ok = c.byPrompt[pfp]
+	}
+	c.mu.RUnlock()
+	if ok {
+		return e, true
+	}
+	return c.lookupFlowSteps(provider, req)
+}
+
+// lookupFlowSteps matches single-turn requests (optional system + user
+// message) against successful flow_steps rows, preferring the provider.
+func (c *Cassette) lookupFlowSteps(provider string, req Request) (*CassetteEntry, bool) {
+	if c.flowsDB == nil {
+		return nil, false
+	}
+	var system, prompt string
+	switch {
+	case len(req.Messages) == 1 && req.Messages[0].Role == "user":
+		prompt = req.Messages[0].Content
+	case len(req.Messages) == 2 && req.Messages[0].Role == "system" && req.Messages[1].Role == "user":
+		system, prompt = req.Messages[0].Content, req.Messages[1].Content
+	default:
+		return nil, false
+	}
+
+	e := CassetteEntry{Messages: req.Messages}
+	var finish *string
+	err := c.flowsDB.QueryRow(`
+		SELECT provider, model_id, response_raw, COALESCE(tokens_in,0), COALESCE(tokens_out,0),
+			finish_reason, COALESCE(latency_ms,0)
+		FROM flow_steps
+		WHERE prompt = ? AND COALESCE(system_prompt,'') = ? AND error IS NULL AND response_raw IS NOT NULL
+		ORDER BY provider = ? DESC, created_at DESC LIMIT 1`,
+		prompt, system, provider).Scan(&e.Provider, &e.Model, &e.Content, &e.TokensIn, &e.TokensOut, &finish, &e.LatencyMs)
+	if err != nil {
+		return nil, false
+	}
+	if finish != nil {
+		e.FinishReason = *finish
+	}
+	return &e, true
+}
+
+// Script holds canned responses for scripted mode, keyed by step name.
+//
+//	default = "canned answer for any other step"
+//	latency_ms = 50
+//	[steps]
+//	judge = '{"score": 7, "verdict": "holds"}'
+type Script struct {
+	Default   string            `toml:"default"`
+	LatencyMs int               `toml:"latency_ms"` // latency reported (not waited) per response, default 1
+	Steps     map[string]string `toml:"steps"`
+}
+
+// LoadScript reads a TOML script file. An empty path yields a script that
+// answers every step with a placeholder naming the step.
+func LoadScript(path string) (*Script, error) {
+	s := &Script{Steps: map[string]string{}, LatencyMs: 1}
+	if path == "" {
+		return s, nil
+	}
+	if _, err := toml.DecodeFile(path, s); err != nil {
+		return nil, fmt.Errorf("loading script %s: %w", path, err)
+	}
+	if s.Steps == nil {
+		s.Steps = map[string]string{}
+	}
+	if s.LatencyMs <= 0 {
+		s.LatencyMs = 1
+	}
+	return s, nil
+}
+
+// Response returns the canned response for a step.
+func (s *Script) Response(step string) string {
+	if r, ok := s.Steps[step]; ok {
+		return r
+	}
+	if s.Default != "" {
+		return s.Default
+	}
+	if step == "" {
+		return "[scripted response]"
+	}
+	return fmt.Sprintf("[scripted response for step %q]", step)
+}
+
+// CassetteProvider stands in for a provider in record, replay or scripted
+// mode. It keeps the name and model list of the provider it replaces so
+// that flows pinned to a provider route the same way offline.
+type CassetteProvider struct {
+	name     string
+	models   []string
+	mode     string
+	live     Provider // record mode only
+	cassette *Cassette
+	script   *Script
+}
+
+// NewRecordingProvider wraps a live provider and appends every successful
+// response to the cassette.
+func NewRecordingProvider(live Provider, c *Cassette) *CassetteProvider {
+	return &CassetteProvider{name: live.Name(), models: live.Models(), mode: ModeRecord, live: live, cassette: c}
+}
+
+// NewReplayProvider answers as provider name from the cassette. A request
+// with no recording fails with ErrCassetteMiss.
+func NewReplayProvider(name string, models []string, c *Cassette) *CassetteProvider {
+	return &CassetteProvider{name: name, models: models, mode: ModeReplay, cassette: c}
+}
+
+// NewScriptedProvider answers as provider name from a script.
+func NewScriptedProvider(name string, models []string, s *Script) *CassetteProvider {
+	return &CassetteProvider{name: name, models: models, mode: ModeScripted, script: s}
+}
+
+func (p *CassetteProvider) Name() string     { return p.name }
+func (p *CassetteProvider) Models() []string { return p.models }
+
+// Unwrap returns the live provider being recorded, nil in replay and
+// scripted mode (there is nothing behind the stand-in).
+func (p *CassetteProvider) Unwrap() Provider { return p.live }
+
+func (p *CassetteProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	start := time.Now()
+	model := req.Model
+	if model == "" && len(p.models) > 0 {
+		model = p.models[0]
+	}
+
+	switch p.mode {
+	case ModeRecord:
+		resp, err := p.live.Complete(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if recErr := p.cassette.Record(p.name, StepNameFrom(ctx), req, resp); recErr != nil {
+			return nil, &ProviderError{Provider: p.name, Model: model, Err: recErr}
+		}
+		return resp, nil
+
+	case ModeReplay:
+		// Recorded latency is reported as-is so replayed forensics match the original run.
+		e, ok := p.cassette.Lookup(p.name, req)
+		if !ok {
+			return nil, &ProviderError{Provider: p.name, Model: model,
+				Err: fmt.Errorf("%w (fingerprint %s)", ErrCassetteMiss, Fingerprint(req)[:16])}
+		}
+		finish := e.FinishReason
+		if finish == "" {
+			finish = "stop"
+		}
+		return &Response{
+			Provider:     p.name,
+			Model:        e.Model,
+			Content:      e.Content,
+			ToolCalls:    e.ToolCalls,
+			TokensIn:     e.TokensIn,
+			TokensOut:    e.TokensOut,
+			FinishReason: finish,
+			Latency:      max(time.Duration(e.LatencyMs)*time.Millisecond, time.Since(start)),
+		}, nil
+
+	default:
+		content := p.script.Response(StepNameFrom(ctx))
+		if req.Schema != nil {
+			// Unscripted structured steps get a minimal conforming value so
+			// offline runs never spend repair rounds.
+			if _, problems := req.Schema.Check(content); len(problems) > 0 {
+				content = string(req.Schema.Sample(content))
+			}
+		}
+		in, _ := estimateUsage(req)
+		return &Response{
+			Provider:     p.name,
+			Model:        model,
+			Content:      content,
+			TokensIn:     in,
+			TokensOut:    (len(content) + 3) / 4,
+			FinishReason: "stop",
+			Latency:      time.Duration(p.script.LatencyMs) * time.Millisecond,
+		}, nil
+	}
+}
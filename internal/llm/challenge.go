@@ -1,8 +1,9 @@
-// CLAUDE:SUMMARY Adversarial challenge runner — executes confrontation/red-team/fidelity flows against nodes and scores results
+// CLAUDE:SUMMARY Adversarial challenge runner — executes confrontation/red-team/fidelity flows against nodes (built-ins as their challenge workflows) and scores results
 package llm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"regexp"
@@ -14,9 +15,11 @@ import (
 
 // ChallengeRunner executes adversarial flows against nodes and stores results.
 type ChallengeRunner struct {
-	flowEngine *FlowEngine
-	database   *db.DB
-	logger     *slog.Logger
+	flowEngine     *FlowEngine
+	workflowEngine *WorkflowEngine // nil = every flow runs on the FlowEngine
+	triggers       *TriggerRunner  // nil = no workflow triggers
+	database       *db.DB
+	logger         *slog.Logger
 }
 
 // NewChallengeRunner creates a challenge execution runner.
@@ -24,9 +27,27 @@ func NewChallengeRunner(flowEngine *FlowEngine, database *db.DB, logger *slog.Lo
 	return &ChallengeRunner{flowEngine: flowEngine, database: database, logger: logger}
 }
 
-// ValidFlows returns the names of available adversarial flows.
+// SetWorkflowEngine runs the built-in flows as their seeded challenge
+// workflows, with the workflow engine's retries, model grants and audit log.
+func (cr *ChallengeRunner) SetWorkflowEngine(we *WorkflowEngine) {
+	cr.workflowEngine = we
+}
+
+// SetTriggers reports completed challenges, and the trees they turn hot,
+// to the workflow triggers.
+func (cr *ChallengeRunner) SetTriggers(tr *TriggerRunner) {
+	cr.triggers = tr
+}
+
+// ValidFlows returns the names of available adversarial flows: the
+// built-ins and those loaded from flow files.
 func ValidFlows() []string {
-	return []string{"confrontation", "red_team", "fidelity_benchmark", "adversarial_detection", "deep_dive", "safety_scoring"}
+	flows := Flows()
+	names := make([]string, len(flows))
+	for i, f := range flows {
+		names[i] = f.Name
+	}
+	return names
 }
 
 // IsValidFlow checks if a flow name exists.
@@ -56,6 +77,11 @@ func (cr *ChallengeRunner) RunChallenge(ctx context.Context, challenge *db.Chall
 		_ = cr.database.UpdateChallengeFailed(challenge.ID, err.Error())
 		return nil, err
 	}
+	wf, err := cr.challengeWorkflow(flow)
+	if err != nil {
+		_ = cr.database.UpdateChallengeFailed(challenge.ID, err.Error())
+		return nil, err
+	}
 
 	// Get the node
 	node, err := cr.database.GetNode(challenge.NodeID)
@@ -85,25 +111,43 @@ func (cr *ChallengeRunner) RunChallenge(ctx context.Context, challenge *db.Chall
 
 	// Mark as running
 	flowID := db.NewID()
-	_ = cr.database.UpdateChallengeRunning(challenge.ID, flowID)
+	runID := ""
+	if wf != nil {
+		runID = db.NewID()
+	}
+	_ = cr.database.UpdateChallengeRunning(challenge.ID, flowID, runID)
 
 	cr.logger.Info("running challenge",
 		"challenge_id", challenge.ID,
 		"flow", challenge.FlowName,
 		"node_id", challenge.NodeID,
 		"target_provider", targetProvider,
+		"workflow_run_id", runID,
 	)
 
-	// Execute flow
-	fctx := FlowContext{
-		FlowID:         flowID,
-		NodeID:         challenge.NodeID,
-		Body:           body,
-		TargetProvider: targetProvider,
-		TargetModel:    targetModel,
+	// Execute flow. A workflow run is also traced in flow_steps under
+	// flowID, so challenges read the same either way.
+	var flowResult *FlowResult
+	if wf != nil {
+		flowResult, err = cr.workflowEngine.Run(ctx, wf, WorkflowInput{
+			RunID:          runID,
+			NodeID:         challenge.NodeID,
+			Body:           body,
+			UserID:         challenge.RequestedBy,
+			UserRole:       cr.userRole(challenge.RequestedBy),
+			TargetProvider: targetProvider,
+			TargetModel:    targetModel,
+			FlowID:         flowID,
+		})
+	} else {
+		flowResult, err = cr.flowEngine.Execute(ctx, flow, FlowContext{
+			FlowID:         flowID,
+			NodeID:         challenge.NodeID,
+			Body:           body,
+			TargetProvider: targetProvider,
+			TargetModel:    targetModel,
+		})
 	}
-
-	flowResult, err := cr.flowEngine.Execute(ctx, flow, fctx)
 	if err != nil {
 		_ = cr.database.UpdateChallengeFailed(challenge.ID, err.Error())
 		return nil, fmt.Errorf("executing flow: %w", err)
@@ -122,7 +166,13 @@ func (cr *ChallengeRunner) RunChallenge(ctx context.Context, challenge *db.Chall
 	}
 
 	// Recalculate temperature
-	_, _ = cr.database.RecalculateRootTemperature(challenge.NodeID)
+	change, tempErr := cr.database.RecalculateRootTemperature(challenge.NodeID)
+	if cr.triggers != nil {
+		cr.triggers.Fire(TriggerEvent{Type: "challenge_completed", NodeID: challenge.NodeID})
+		if tempErr == nil && change.BecameHot() {
+			cr.triggers.Fire(TriggerEvent{Type: "temperature_hot", NodeID: change.NodeID})
+		}
+	}
 
 	return &ChallengeResult{
 		ChallengeID: challenge.ID,
@@ -135,22 +185,77 @@ func (cr *ChallengeRunner) RunChallenge(ctx context.Context, challenge *db.Chall
 
 // resolveFlow finds a flow config by name.
 func (cr *ChallengeRunner) resolveFlow(name string) (FlowConfig, error) {
-	for _, f := range CoreFlows() {
-		if f.Name == name {
-			return f, nil
-		}
+	if f, ok := LookupFlow(name); ok {
+		return f, nil
 	}
 	return FlowConfig{}, fmt.Errorf("unknown flow: %s", name)
 }
 
+// challengeWorkflow returns the workflow a built-in flow runs as, or nil
+// to run the flow itself: flow files, and built-ins whose workflow is
+// missing. A workflow an operator took out of service disables the flow.
+func (cr *ChallengeRunner) challengeWorkflow(flow FlowConfig) (*db.Workflow, error) {
+	if cr.workflowEngine == nil || flow.Source != "" {
+		return nil, nil
+	}
+	wf, err := cr.workflowEngine.flowsDB.GetWorkflowByName(flow.Name)
+	if err != nil || wf.WorkflowType != "challenge" {
+		return nil, nil //nolint:nilerr // no workflow: the flow runs as before
+	}
+	if wf.ActiveVersion == nil {
+		return nil, fmt.Errorf("workflow %s is %s", wf.Name, wf.Status)
+	}
+	// A new version in the making does not change what challenges run.
+	return cr.workflowEngine.flowsDB.GetRunnableWorkflow(wf.WorkflowID)
+}
+
+// userRole is the role model grants are checked against for the user who
+// requested a challenge.
+func (cr *ChallengeRunner) userRole(userID string) string {
+	if u, err := cr.database.GetUserByID(userID); err == nil {
+		return u.Role
+	}
+	return "user"
+}
+
 // scorePattern matches patterns like "score: 75", "Score: 85/100", "resistance score: 60"
 var scorePattern = regexp.MustCompile(`(?i)(?:overall|resistance|fidelity|detection|confidence|deceptiveness)?\s*score[:\s]+(\d+)`)
 
-// extractScoring pulls a numeric score and summary from flow results.
+// Verdict is the structured output of a judge step (judgeSchema).
+type Verdict struct {
+	Score         float64  `json:"score"`
+	Verdict       string   `json:"verdict"`
+	FactualScore  *float64 `json:"factual_score,omitempty"`
+	SourceScore   *float64 `json:"source_score,omitempty"`
+	ArgumentScore *float64 `json:"argument_score,omitempty"`
+	Flags         []string `json:"flags,omitempty"`
+}
+
+// judgeVerdict returns the last successful step that produced a verdict,
+// or nil for flows without a judge step.
+func judgeVerdict(result *FlowResult) (*StepResult, *Verdict) {
+	for i := len(result.Steps) - 1; i >= 0; i-- {
+		sr := &result.Steps[i]
+		if sr.Error != nil || sr.Response == nil || len(sr.Response.JSON) == 0 {
+			continue
+		}
+		var v Verdict
+		if err := sr.Response.Decode(&v); err == nil && v.Verdict != "" {
+			return sr, &v
+		}
+	}
+	return nil, nil
+}
+
+// extractScoring pulls a numeric score and summary from flow results: the
+// judge's verdict when the flow has one, otherwise the last step's text.
 func (cr *ChallengeRunner) extractScoring(result *FlowResult) (float64, string) {
 	if len(result.Steps) == 0 {
 		return 0, "no steps completed"
 	}
+	if _, v := judgeVerdict(result); v != nil {
+		return v.Score, truncateSummary(v.Verdict)
+	}
 
 	// Use the last step's response for scoring
 	lastStep := result.Steps[len(result.Steps)-1]
@@ -174,18 +279,19 @@ func (cr *ChallengeRunner) extractScoring(result *FlowResult) (float64, string)
 		}
 	}
 
-	// Build summary: first 500 chars of last response
-	summary := content
+	return score, truncateSummary(content)
+}
+
+// truncateSummary keeps the first 500 chars, cut at a sentence boundary.
+func truncateSummary(summary string) string {
 	if len(summary) > 500 {
-		// Cut at sentence boundary
 		if idx := strings.LastIndex(summary[:500], "."); idx > 200 {
 			summary = summary[:idx+1]
 		} else {
 			summary = summary[:500] + "..."
 		}
 	}
-
-	return score, summary
+	return summary
 }
 
 // buildModerationScore creates a moderation assessment from challenge results.
@@ -194,14 +300,17 @@ func (cr *ChallengeRunner) buildModerationScore(challenge *db.Challenge, result
 		return nil
 	}
 
-	lastStep := result.Steps[len(result.Steps)-1]
-	if lastStep.Response == nil {
+	step, verdict := judgeVerdict(result)
+	if step == nil {
+		step = &result.Steps[len(result.Steps)-1]
+	}
+	if step.Response == nil {
 		return nil
 	}
 
 	evaluator := "flow:" + challenge.FlowName
-	if lastStep.Provider != "" {
-		evaluator += "/" + lastStep.Provider
+	if step.Provider != "" {
+		evaluator += "/" + step.Provider
 	}
 
 	ms := &db.ModerationScore{
@@ -214,8 +323,19 @@ func (cr *ChallengeRunner) buildModerationScore(challenge *db.Challenge, result
 		ChallengeID:  &challenge.ID,
 	}
 
+	if verdict != nil {
+		ms.FactualScore = verdict.FactualScore
+		ms.SourceScore = verdict.SourceScore
+		ms.ArgumentScore = verdict.ArgumentScore
+		if len(verdict.Flags) > 0 {
+			flags, _ := json.Marshal(verdict.Flags)
+			ms.Flags = string(flags)
+		}
+		return ms
+	}
+
 	// Extract dimension-specific scores from content
-	content := lastStep.Response.Content
+	content := step.Response.Content
 	if v := extractDimensionScore(content, "completeness", "factual"); v >= 0 {
 		ms.FactualScore = &v
 	}
@@ -229,7 +349,8 @@ func (cr *ChallengeRunner) buildModerationScore(challenge *db.Challenge, result
 	return ms
 }
 
-// extractDimensionScore finds a score for a named dimension in LLM output.
+// extractDimensionScore finds a score for a named dimension in free-text
+// LLM output (flows without a judge verdict).
 func extractDimensionScore(content string, keywords ...string) float64 {
 	lower := strings.ToLower(content)
 	for _, kw := range keywords {
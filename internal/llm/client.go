@@ -1,17 +1,26 @@
-// CLAUDE:SUMMARY Multi-provider LLM client with fallback chain, response logging, and cost tracking
+// CLAUDE:SUMMARY Multi-provider LLM client with health-aware fallback chain (circuit breakers), response logging, and cost tracking
 // Package llm provides a multi-provider LLM client with fallback chain,
 // structured response logging to flows.db, and cost tracking to metrics.db.
 package llm
 
 import (
 	"context"
+	"encoding/json"
+	"sort"
+	"sync"
 	"time"
 )
 
-// Message represents a chat message (system/user/assistant).
+// Message represents a chat message (system/user/assistant/tool). An
+// assistant message may carry the tool calls the model made; a "tool"
+// message carries the result of one of them.
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // assistant only
+	ToolCallID string     `json:"tool_call_id,omitempty"` // tool only: the call answered
+	Name       string     `json:"name,omitempty"`         // tool only: the tool that ran
 }
 
 // Request is a provider-agnostic LLM completion request.
@@ -22,6 +31,16 @@ type Request struct {
 	MaxTokens   int       `json:"max_tokens,omitempty"`
 	TopP        float64   `json:"top_p,omitempty"`
 	Seed        *int      `json:"seed,omitempty"`
+	Tools       []Tool    `json:"tools,omitempty"` // functions the model may call
+
+	// Structured output: the reply must be JSON matching Schema; after a
+	// mismatch up to Repairs rounds (0 = 2) ask the model to fix it.
+	Schema  *Schema `json:"-"`
+	Repairs int     `json:"-"`
+
+	// Response cache directives (see SetCache); not sent to providers.
+	Cache    CacheMode     `json:"-"`
+	CacheTTL time.Duration `json:"-"` // overrides the cache's default TTL
 }
 
 // Response is a provider-agnostic LLM completion response.
@@ -33,6 +52,18 @@ type Response struct {
 	TokensOut    int           `json:"tokens_out"`
 	FinishReason string        `json:"finish_reason"`
 	Latency      time.Duration `json:"latency_ms"`
+	CostUSD      float64       `json:"cost_usd"`
+	Cached       bool          `json:"cached"` // served from the response cache, CostUSD is 0
+
+	// Tool calling: the calls the model asks for (a reply to Request.Tools),
+	// and after CompleteTools every round-trip made on the way to Content.
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
+	ToolRounds []ToolRound `json:"tool_rounds,omitempty"`
+
+	// Structured output (Request.Schema only): the validated JSON value and
+	// the number of repair rounds it took.
+	JSON    json.RawMessage `json:"json,omitempty"`
+	Repairs int             `json:"repairs,omitempty"`
 }
 
 // Provider is a single LLM API backend.
@@ -46,39 +77,68 @@ type Provider interface {
 }
 
 // Client sends LLM requests with fallback across multiple providers.
+// Each provider sits behind a circuit breaker so that a provider that is
+// down is skipped instead of paying its timeout on every call, and behind
+// optional quotas (see SetLimit) that make callers queue instead of
+// collecting 429s. With a Ledger (see SetLedger), calls are priced and
+// checked against spend budgets. With a Cache (see SetCache), identical
+// requests are answered from flows.db without reaching a provider.
+// Self-registered providers (see Register) can be added and removed while
+// the client is in use; they are only reached by explicit routing.
 type Client struct {
-	providers map[string]Provider // keyed by provider name
-	fallback  []string            // provider names in priority order
+	mu         sync.RWMutex              // guards providers, fallback, breakers, registered
+	providers  map[string]Provider       // keyed by provider name
+	fallback   []string                  // configured provider names in priority order
+	breakers   map[string]*breaker       // keyed by provider name
+	registered map[string]string         // self-registered provider name → providers.id
+	decorators []func(Provider) Provider // applied by Register (offline mode, instrumentation)
+
+	ledger   *Ledger        // nil = costs not tracked
+	cache    *Cache         // nil = responses not cached
+	mode     string         // ModeLive, ModeRecord, ModeReplay or ModeScripted
+	cassette *Cassette      // record / replay mode only
+	local    *LocalEmbedder // nil = no built-in embedding fallback
+
+	limitsMu sync.RWMutex
+	limits   map[string]*limiter // keyed by "provider" or "provider/model"
 }
 
 // New creates a multi-provider LLM client.
 func New(providers []Provider) *Client {
 	m := make(map[string]Provider, len(providers))
 	order := make([]string, 0, len(providers))
+	breakers := make(map[string]*breaker, len(providers))
 	for _, p := range providers {
 		m[p.Name()] = p
 		order = append(order, p.Name())
+		breakers[p.Name()] = newBreaker()
+	}
+	return &Client{
+		providers:  m,
+		fallback:   order,
+		breakers:   breakers,
+		registered: make(map[string]string),
+		limits:     make(map[string]*limiter),
 	}
-	return &Client{providers: m, fallback: order}
 }
 
 // Complete sends a request to the specified provider, or falls back through
-// the chain if the provider fails or is unspecified.
+// the chain if the provider fails or is unspecified. Providers whose circuit
+// is open are skipped without a network call.
 func (c *Client) Complete(ctx context.Context, req Request) (*Response, error) {
 	// If model contains a provider prefix (e.g. "groq/llama-3-70b"), route directly
 	provider, model := splitModel(req.Model)
 	if provider != "" {
 		req.Model = model
-		if p, ok := c.providers[provider]; ok {
-			return p.Complete(ctx, req)
+		if c.HasProvider(provider) {
+			return c.complete(ctx, provider, req)
 		}
 	}
 
 	// Try each provider in fallback order
-	var lastErr error
-	for _, name := range c.fallback {
-		p := c.providers[name]
-		resp, err := p.Complete(ctx, req)
+	lastErr := noProvider(provider)
+	for _, name := range c.fallbackOrder() {
+		resp, err := c.complete(ctx, name, req)
 		if err != nil {
 			lastErr = err
 			continue
@@ -90,26 +150,136 @@ func (c *Client) Complete(ctx context.Context, req Request) (*Response, error) {
 
 // CompleteWith sends a request to a specific named provider.
 func (c *Client) CompleteWith(ctx context.Context, providerName string, req Request) (*Response, error) {
-	p, ok := c.providers[providerName]
-	if !ok {
+	if !c.HasProvider(providerName) {
 		return nil, &ProviderError{Provider: providerName, Err: ErrProviderNotFound}
 	}
-	return p.Complete(ctx, req)
+	return c.complete(ctx, providerName, req)
 }
 
-// Providers returns the names of all configured providers.
+func (c *Client) complete(ctx context.Context, name string, req Request) (*Response, error) {
+	hit, store := c.fromCache(ctx, name, req)
+	if hit != nil && req.Schema.accept(hit) {
+		return hit, nil
+	}
+	resp, err := c.generate(ctx, name, req)
+	if err == nil {
+		store(resp)
+	}
+	return resp, err
+}
+
+// call runs fn against the named provider: it first goes through the
+// circuit breaker, then reserves the estimated cost against the spender's
+// budgets, then waits for quota (queueing until ctx is done). An open
+// breaker short-circuits with ErrCircuitOpen and the remaining wait, before
+// the call takes a place in the queue; a budget that would be exceeded
+// refuses with ErrBudgetExceeded. A call whose ctx ended before the provider
+// answered is not held against the provider.
+func (c *Client) call(ctx context.Context, name string, req Request, fn func(Provider) (*Response, error)) (*Response, error) {
+	p, b := c.lookup(name)
+	if p == nil {
+		return nil, &ProviderError{Provider: name, Model: req.Model, Err: ErrProviderNotFound}
+	}
+	if ok, wait := b.allow(time.Now()); !ok {
+		return nil, &ProviderError{Provider: name, RetryAfter: wait, Err: ErrCircuitOpen}
+	}
+
+	settle, err := c.budget(ctx, name, req)
+	if err != nil {
+		b.abandon()
+		return nil, &ProviderError{Provider: name, Model: req.Model, Err: err}
+	}
+
+	release, err := c.admit(ctx, name, req)
+	if err != nil {
+		b.abandon()
+		settle(nil)
+		return nil, &ProviderError{Provider: name, Model: req.Model, Err: err}
+	}
+
+	resp, err := fn(p)
+	if err != nil && ctx.Err() != nil {
+		b.abandon() // the caller's deadline or cancellation, not the provider's failure
+	} else {
+		b.record(err, time.Now())
+	}
+
+	used := 0
+	if resp != nil {
+		used = resp.TokensIn + resp.TokensOut
+	}
+	release(used, false)
+	settle(resp)
+	return resp, err
+}
+
+// Health returns a breaker snapshot for every provider, in fallback order
+// followed by self-registered providers.
+func (c *Client) Health() []ProviderHealth {
+	now := time.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]ProviderHealth, 0, len(c.breakers))
+	for _, name := range c.names() {
+		out = append(out, c.breakers[name].snapshot(name, now))
+	}
+	return out
+}
+
+// ResetBreaker forces a provider's circuit back to closed (e.g. after an
+// operator rotated an API key). Returns false if the provider is unknown.
+func (c *Client) ResetBreaker(name string) bool {
+	c.mu.RLock()
+	b, ok := c.breakers[name]
+	c.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	b.reset()
+	return true
+}
+
+// Mode returns the provider mode the client was built with (see NewFromConfig).
+func (c *Client) Mode() string {
+	if c.mode == "" {
+		return ModeLive
+	}
+	return c.mode
+}
+
+// Cassette returns the cassette used in record or replay mode, or nil.
+func (c *Client) Cassette() *Cassette {
+	return c.cassette
+}
+
+// Providers returns the configured provider names in fallback order.
+// Self-registered providers are not part of it: see AllProviders.
 func (c *Client) Providers() []string {
-	return c.fallback
+	return c.fallbackOrder()
 }
 
-// HasProvider checks if a named provider is configured.
+// AllProviders returns the names of all providers: configured ones in
+// fallback order, then self-registered ones by name. For listings, not for
+// fanning a request out: registered endpoints are only reached by explicit
+// routing.
+func (c *Client) AllProviders() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.names()
+}
+
+// HasProvider checks if a named provider is configured or registered.
 func (c *Client) HasProvider(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	_, ok := c.providers[name]
 	return ok
 }
 
 // ProviderModels returns a map of provider name → model list for all configured providers.
 func (c *Client) ProviderModels() map[string][]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	result := make(map[string][]string)
 	for name, p := range c.providers {
 		result[name] = p.Models()
@@ -117,6 +287,54 @@ func (c *Client) ProviderModels() map[string][]string {
 	return result
 }
 
+// lookup returns the named provider and its breaker, or nil if the
+// provider is unknown (e.g. deregistered since the caller looked).
+func (c *Client) lookup(name string) (Provider, *breaker) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.providers[name], c.breakers[name]
+}
+
+// fallbackOrder returns a copy of the fallback chain.
+func (c *Client) fallbackOrder() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.fallback...)
+}
+
+// names lists the fallback chain then the registered providers sorted by
+// name. Callers hold c.mu.
+func (c *Client) names() []string {
+	reg := make([]string, 0, len(c.registered))
+	for name := range c.registered {
+		reg = append(reg, name)
+	}
+	sort.Strings(reg)
+	return append(append(make([]string, 0, len(c.fallback)+len(reg)), c.fallback...), reg...)
+}
+
+// baseProvider strips decorators (instrumentation, cassette recording) to
+// reach the concrete provider. Offline stand-ins unwrap to nil.
+func baseProvider(p Provider) Provider {
+	for p != nil {
+		u, ok := p.(interface{ Unwrap() Provider })
+		if !ok {
+			break
+		}
+		p = u.Unwrap()
+	}
+	return p
+}
+
+// noProvider is the error returned when nothing could serve a request
+// (no configured provider, and the named one, if any, is unknown).
+func noProvider(provider string) error {
+	if provider == "" {
+		return ErrProviderNotFound
+	}
+	return &ProviderError{Provider: provider, Err: ErrProviderNotFound}
+}
+
 func splitModel(model string) (provider, name string) {
 	for i, c := range model {
 		if c == '/' {
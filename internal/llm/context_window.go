@@ -0,0 +1,63 @@
+// CLAUDE:SUMMARY Context windows — per-model limits from the available_models catalogue and a rough token estimate used to size prompts
+package llm
+
+import (
+	"unicode/utf8"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// DefaultContextWindow is assumed for models the catalogue has no size for.
+// It is deliberately modest: overestimating makes calls fail, while
+// underestimating only costs an extra summarization round.
+const DefaultContextWindow = 32000
+
+// EstimateTokens approximates the token count of text at four characters
+// per token. Tokenizers differ per model; callers keep a margin.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// estimateMessages is EstimateTokens over a conversation, with a few tokens
+// per message for role markers.
+func estimateMessages(messages []Message) int {
+	n := 0
+	for _, m := range messages {
+		n += EstimateTokens(m.Content) + 4
+	}
+	return n
+}
+
+// ContextWindow returns the context size of the model a request for
+// provider and model would use: the catalogue value when discovery or the
+// model's owner recorded one, DefaultContextWindow otherwise. An empty
+// model resolves to the first model listed by provider, or by the first
+// provider of the fallback chain (usually the default model).
+func ContextWindow(flowsDB *db.FlowsDB, client *Client, provider, model string) int {
+	if p, m := splitModel(model); p != "" {
+		provider, model = p, m
+	}
+	if model == "" && client != nil {
+		if provider == "" {
+			if order := client.fallbackOrder(); len(order) > 0 {
+				provider = order[0]
+			}
+		}
+		if p, _ := client.lookup(provider); p != nil {
+			if models := p.Models(); len(models) > 0 {
+				model = models[0]
+			}
+		}
+	}
+	if flowsDB == nil || model == "" {
+		return DefaultContextWindow
+	}
+	modelID := model
+	if provider != "" {
+		modelID = provider + "/" + model
+	}
+	if window, ok := flowsDB.ContextWindow(modelID, model); ok {
+		return window
+	}
+	return DefaultContextWindow
+}
@@ -0,0 +1,238 @@
+// CLAUDE:SUMMARY LLM cost accounting — prices calls from model_prices, refuses calls that would exceed a user/run/instance budget, records spend
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// Spender identifies who a call is billed to. It travels in the request
+// context (see WithSpender) so that budgets apply without every caller
+// having to pass IDs through the Client API.
+type Spender struct {
+	UserID string
+	RunID  string
+}
+
+type spenderKey struct{}
+
+// WithSpender attributes every LLM call made with ctx to s.
+func WithSpender(ctx context.Context, s Spender) context.Context {
+	return context.WithValue(ctx, spenderKey{}, s)
+}
+
+// SpenderFrom returns the spender attached to ctx (zero if none).
+func SpenderFrom(ctx context.Context) Spender {
+	s, _ := ctx.Value(spenderKey{}).(Spender)
+	return s
+}
+
+// BudgetError reports which budget refused a call.
+type BudgetError struct {
+	Scope       string  `json:"scope"`
+	ScopeID     string  `json:"scope_id,omitempty"`
+	LimitUSD    float64 `json:"limit_usd"`
+	SpentUSD    float64 `json:"spent_usd"`
+	EstimateUSD float64 `json:"estimate_usd"`
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s budget exceeded: spent $%.4f, call estimated $%.4f, limit $%.4f",
+		e.Scope, e.SpentUSD, e.EstimateUSD, e.LimitUSD)
+}
+
+func (e *BudgetError) Is(target error) bool { return target == ErrBudgetExceeded }
+
+// BudgetStatus is the state of one budget for a spender.
+type BudgetStatus struct {
+	Scope        string  `json:"scope"`
+	ScopeID      string  `json:"scope_id,omitempty"`
+	BudgetID     string  `json:"budget_id"`
+	LimitUSD     float64 `json:"limit_usd"`
+	SpentUSD     float64 `json:"spent_usd"`
+	ReservedUSD  float64 `json:"reserved_usd"`
+	RemainingUSD float64 `json:"remaining_usd"`
+}
+
+// Ledger prices LLM calls from the model_prices table and enforces the
+// budgets of llm_budgets against the llm_spend ledger in flows.db.
+type Ledger struct {
+	flowsDB *db.FlowsDB
+
+	mu       sync.Mutex
+	reserved map[string]float64 // budget scope key → USD held by in-flight calls
+}
+
+// NewLedger creates a ledger backed by flows.db.
+func NewLedger(flowsDB *db.FlowsDB) *Ledger {
+	return &Ledger{flowsDB: flowsDB, reserved: make(map[string]float64)}
+}
+
+// Cost returns the USD cost of a call and whether the model has a price.
+// Unpriced models cost 0 and are never refused by a budget estimate.
+func (l *Ledger) Cost(provider, model string, tokensIn, tokensOut int) (float64, bool) {
+	p, err := l.flowsDB.LookupModelPrice(provider, model)
+	if err != nil || p == nil {
+		return 0, false
+	}
+	return p.Cost(tokensIn, tokensOut), true
+}
+
+// CostOf prices a token volume on a "provider/model" string, the form
+// accepted by Complete. A model without a provider prefix is unpriced.
+func (l *Ledger) CostOf(providerModel string, tokensIn, tokensOut int) (float64, bool) {
+	provider, model := splitModel(providerModel)
+	if provider == "" {
+		return 0, false
+	}
+	return l.Cost(provider, model, tokensIn, tokensOut)
+}
+
+type budgetScope struct {
+	scope   string
+	scopeID string
+}
+
+func (s budgetScope) key() string { return s.scope + ":" + s.scopeID }
+
+// scopesFor lists the budgets a spender's calls count against.
+func scopesFor(sp Spender) []budgetScope {
+	scopes := []budgetScope{{scope: db.BudgetScopeInstance}}
+	if sp.UserID != "" {
+		scopes = append(scopes, budgetScope{db.BudgetScopeUser, sp.UserID})
+	}
+	if sp.RunID != "" {
+		scopes = append(scopes, budgetScope{db.BudgetScopeRun, sp.RunID})
+	}
+	return scopes
+}
+
+// reserve refuses the call if est would take any applicable budget past its
+// limit, counting what concurrent in-flight calls already hold. Otherwise
+// est stays held until the returned release func is called.
+func (l *Ledger) reserve(sp Spender, est float64) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var held []string
+	for _, s := range scopesFor(sp) {
+		b, err := l.flowsDB.EffectiveBudget(s.scope, s.scopeID)
+		if err != nil || b == nil {
+			continue
+		}
+		spent, err := l.flowsDB.SpentUSD(s.scope, s.scopeID)
+		if err != nil {
+			continue
+		}
+		committed := spent + l.reserved[s.key()]
+		if committed >= b.LimitUSD || committed+est > b.LimitUSD {
+			return nil, &BudgetError{
+				Scope:       s.scope,
+				ScopeID:     s.scopeID,
+				LimitUSD:    b.LimitUSD,
+				SpentUSD:    committed,
+				EstimateUSD: est,
+			}
+		}
+		held = append(held, s.key())
+	}
+
+	for _, k := range held {
+		l.reserved[k] += est
+	}
+	return func() {
+		l.mu.Lock()
+		for _, k := range held {
+			l.reserved[k] -= est
+			if l.reserved[k] <= 0 {
+				delete(l.reserved, k)
+			}
+		}
+		l.mu.Unlock()
+	}, nil
+}
+
+// Status returns every budget that applies to sp, with today's spend.
+func (l *Ledger) Status(sp Spender) []BudgetStatus {
+	out := []BudgetStatus{}
+	for _, s := range scopesFor(sp) {
+		b, err := l.flowsDB.EffectiveBudget(s.scope, s.scopeID)
+		if err != nil || b == nil {
+			continue
+		}
+		spent, _ := l.flowsDB.SpentUSD(s.scope, s.scopeID)
+		l.mu.Lock()
+		reserved := l.reserved[s.key()]
+		l.mu.Unlock()
+		out = append(out, BudgetStatus{
+			Scope:        s.scope,
+			ScopeID:      s.scopeID,
+			BudgetID:     b.BudgetID,
+			LimitUSD:     b.LimitUSD,
+			SpentUSD:     spent,
+			ReservedUSD:  reserved,
+			RemainingUSD: max(b.LimitUSD-spent-reserved, 0),
+		})
+	}
+	return out
+}
+
+// SetLedger enables cost accounting: every response gets a CostUSD and
+// calls that would exceed a budget are refused with ErrBudgetExceeded
+// before anything is sent to the provider.
+func (c *Client) SetLedger(l *Ledger) {
+	c.ledger = l
+}
+
+// Ledger returns the client's ledger, or nil when costs are not tracked.
+func (c *Client) Ledger() *Ledger {
+	return c.ledger
+}
+
+// EstimateCost prices req on a provider before it is sent: the prompt at
+// ~4 chars/token plus the full completion budget. A request without a
+// model is priced at the provider's first listed model.
+func (c *Client) EstimateCost(provider string, req Request) float64 {
+	if c.ledger == nil {
+		return 0
+	}
+	model := req.Model
+	if model == "" {
+		if p, _ := c.lookup(provider); p != nil && len(p.Models()) > 0 {
+			model = p.Models()[0]
+		}
+	}
+	in, out := estimateUsage(req)
+	cost, _ := c.ledger.Cost(provider, model, in, out)
+	return cost
+}
+
+// budget reserves the estimated cost of req against the spender's budgets.
+// The returned settle func prices the actual response (nil on failure),
+// records it in the ledger and releases the reservation.
+func (c *Client) budget(ctx context.Context, provider string, req Request) (func(*Response), error) {
+	if c.ledger == nil {
+		return func(*Response) {}, nil
+	}
+	sp := SpenderFrom(ctx)
+	release, err := c.ledger.reserve(sp, c.EstimateCost(provider, req))
+	if err != nil {
+		return nil, err
+	}
+	return func(resp *Response) {
+		defer release()
+		if resp == nil {
+			return
+		}
+		model := resp.Model
+		if model == "" {
+			model = req.Model
+		}
+		resp.CostUSD, _ = c.ledger.Cost(provider, model, resp.TokensIn, resp.TokensOut)
+		_ = c.ledger.flowsDB.RecordSpend(sp.UserID, sp.RunID, provider, model,
+			resp.TokensIn, resp.TokensOut, resp.CostUSD)
+	}, nil
+}
@@ -1,13 +1,16 @@
-// CLAUDE:SUMMARY Dynamic discovery of available LLM models across all configured providers
+// CLAUDE:SUMMARY Dynamic discovery of available LLM models across all configured providers — context sizes, capabilities and prices from each listing, then a periodic liveness probe per model; registered providers only when approved and within the endpoint address policy
 package llm
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,10 +19,13 @@ import (
 
 // ModelDiscovery handles dynamic discovery of available LLM models.
 type ModelDiscovery struct {
-	flowsDB *db.FlowsDB
-	client  *Client
-	httpCl  *http.Client
-	logger  *slog.Logger
+	flowsDB   *db.FlowsDB
+	registry  *db.DB // providers table; nil = registered providers are skipped
+	client    *Client
+	httpCl    *http.Client
+	logger    *slog.Logger
+	probe     bool
+	maxProbes int
 }
 
 // NewModelDiscovery creates a model discovery service.
@@ -32,26 +38,112 @@ func NewModelDiscovery(flowsDB *db.FlowsDB, client *Client, logger *slog.Logger)
 	}
 }
 
+// SetProbing enables the liveness probe of Refresh, sending at most
+// maxProbes requests per round (0 = no limit).
+func (md *ModelDiscovery) SetProbing(enabled bool, maxProbes int) {
+	md.probe = enabled
+	md.maxProbes = maxProbes
+}
+
+// SetRegistry gives discovery the providers table, which it re-reads each
+// round so that a registered provider is listed or probed only while its
+// registration is approved and its endpoint within the address policy.
+func (md *ModelDiscovery) SetRegistry(database *db.DB) {
+	md.registry = database
+}
+
+// callableRegistered returns, by name, the rows of the registered
+// providers held by the client that may be called: an authenticated and
+// approved registration whose endpoint passes the address policy, and
+// which is the one the client holds.
+func (md *ModelDiscovery) callableRegistered() map[string]*db.RegisteredProvider {
+	callable := make(map[string]*db.RegisteredProvider)
+	if md.registry == nil {
+		return callable
+	}
+	rows, err := md.registry.ListRegisteredProviders()
+	if err != nil {
+		md.logger.Warn("reading registered providers for discovery", "error", err)
+		return callable
+	}
+	for i := range rows {
+		p := &rows[i]
+		if p.IsActive && untrusted(p) == "" && md.client.RegisteredID(p.Name) == p.ID {
+			callable[p.Name] = p
+		}
+	}
+	return callable
+}
+
 // providerEndpoint maps provider names to their models listing endpoint and API style.
 type providerEndpoint struct {
 	name    string
 	baseURL string
 	apiKey  string
 	style   string // "openai", "gemini", "anthropic"
+
+	// registered marks a self-registered provider: its listing only
+	// enriches the models of its registration (models), and a failed
+	// listing does not retire them — many local servers have none.
+	registered bool
+	models     []string
+
+	httpCl *http.Client // registered endpoints: one enforcing the address policy
 }
 
-// DiscoverAll runs discovery for all configured providers.
-func (md *ModelDiscovery) DiscoverAll(ctx context.Context) {
+// RefreshReport sums up a Refresh round.
+type RefreshReport struct {
+	Discovered int      `json:"discovered"`
+	Probed     int      `json:"probed"`
+	Failed     []string `json:"failed"` // model ids that failed their probe
+}
+
+// Run refreshes the catalogue immediately, then every interval until ctx
+// is done.
+func (md *ModelDiscovery) Run(ctx context.Context, interval time.Duration) {
+	md.Refresh(ctx)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			md.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh runs discovery, then probes the models in use when probing is
+// enabled.
+func (md *ModelDiscovery) Refresh(ctx context.Context) RefreshReport {
+	report := RefreshReport{Discovered: md.DiscoverAll(ctx), Failed: []string{}}
+	if md.probe {
+		report.Probed, report.Failed = md.Probe(ctx)
+	}
+	return report
+}
+
+// DiscoverAll runs discovery for all configured providers and returns the
+// number of models listed.
+func (md *ModelDiscovery) DiscoverAll(ctx context.Context) int {
 	endpoints := md.buildEndpoints()
 	if len(endpoints) == 0 {
 		md.logger.Info("no providers configured for model discovery")
-		return
+		return 0
 	}
 
 	totalDiscovered := 0
 	for _, ep := range endpoints {
 		models, err := md.discoverProvider(ctx, ep)
 		if err != nil {
+			if ep.registered {
+				md.logger.Debug("registered provider has no model listing", "provider", ep.name, "error", err)
+				continue
+			}
 			md.logger.Warn("model discovery failed",
 				"provider", ep.name,
 				"error", err,
@@ -59,9 +151,18 @@ func (md *ModelDiscovery) DiscoverAll(ctx context.Context) {
 			_ = md.flowsDB.MarkAllUnavailableForProvider(ep.name)
 			continue
 		}
+		if ep.registered {
+			models = slices.DeleteFunc(models, func(m *db.AvailableModel) bool {
+				return !slices.Contains(ep.models, m.ModelName)
+			})
+		}
 
 		for _, m := range models {
 			_ = md.flowsDB.UpsertModel(m)
+			caps := db.ParseCapabilities(m.CapabilitiesJSON)
+			if caps.InputPerMTok != nil && caps.OutputPerMTok != nil {
+				_ = md.flowsDB.SetDiscoveredPrice(m.Provider, m.ModelName, *caps.InputPerMTok, *caps.OutputPerMTok)
+			}
 		}
 		totalDiscovered += len(models)
 
@@ -74,30 +175,52 @@ func (md *ModelDiscovery) DiscoverAll(ctx context.Context) {
 	_ = md.flowsDB.InsertAuditLog("", "", "model_discovered", map[string]interface{}{
 		"total_models": totalDiscovered,
 	})
+	return totalDiscovered
 }
 
-// buildEndpoints creates the list of provider endpoints from the configured LLM client.
+// buildEndpoints creates the list of provider endpoints from the configured
+// LLM client. Registered providers that may not be called are left out.
 func (md *ModelDiscovery) buildEndpoints() []providerEndpoint {
 	var endpoints []providerEndpoint
+	callable := md.callableRegistered()
 
-	for _, name := range md.client.Providers() {
-		p := md.client.providers[name]
-		switch prov := p.(type) {
+	for _, name := range md.client.AllProviders() {
+		p, _ := md.client.lookup(name)
+		registered := md.client.RegisteredID(name) != ""
+		httpCl := md.httpCl
+		if registered {
+			row := callable[name]
+			if row == nil {
+				md.logger.Debug("registered provider not callable, skipped by discovery", "provider", name)
+				continue
+			}
+			httpCl = endpointClient(md.httpCl.Timeout, row.AllowPrivate)
+		}
+		switch prov := baseProvider(p).(type) {
 		case *OpenAIProvider:
 			endpoints = append(endpoints, providerEndpoint{
-				name:    name,
-				baseURL: prov.baseURL,
-				apiKey:  prov.apiKey,
-				style:   "openai",
+				name:       name,
+				baseURL:    prov.baseURL,
+				apiKey:     prov.apiKey,
+				style:      "openai",
+				registered: registered,
+				models:     p.Models(),
+				httpCl:     httpCl,
 			})
 		case *GeminiProvider:
 			endpoints = append(endpoints, providerEndpoint{
-				name:    name,
-				baseURL: "https://generativelanguage.googleapis.com/v1beta",
-				apiKey:  prov.apiKey,
-				style:   "gemini",
+				name:       name,
+				baseURL:    prov.baseURL,
+				apiKey:     prov.apiKey,
+				style:      "gemini",
+				registered: registered,
+				models:     p.Models(),
+				httpCl:     httpCl,
 			})
 		case *AnthropicProvider:
+			if registered {
+				continue // the hardcoded list is Anthropic's own
+			}
 			// Anthropic has no models listing endpoint; use hardcoded list
 			endpoints = append(endpoints, providerEndpoint{
 				name:    name,
@@ -134,7 +257,7 @@ func (md *ModelDiscovery) discoverOpenAI(ctx context.Context, ep providerEndpoin
 	}
 	req.Header.Set("Authorization", "Bearer "+ep.apiKey)
 
-	resp, err := md.httpCl.Do(req)
+	resp, err := ep.httpCl.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -146,10 +269,7 @@ func (md *ModelDiscovery) discoverOpenAI(ctx context.Context, ep providerEndpoin
 	}
 
 	var result struct {
-		Data []struct {
-			ID      string `json:"id"`
-			Created int64  `json:"created"`
-		} `json:"data"`
+		Data []openAIModel `json:"data"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("decoding response: %w", err)
@@ -162,13 +282,69 @@ func (md *ModelDiscovery) discoverOpenAI(ctx context.Context, ep providerEndpoin
 			ModelID:          modelID,
 			Provider:         ep.name,
 			ModelName:        m.ID,
+			ContextWindow:    positive(m.ContextWindow, m.ContextLength, m.MaxContextLength, m.MaxModelLen, m.TopProvider.ContextLength),
 			IsAvailable:      true,
-			CapabilitiesJSON: "{}",
+			CapabilitiesJSON: m.capabilities().String(),
 		})
 	}
 	return models, nil
 }
 
+// openAIModel is an entry of an OpenAI-compatible model listing. OpenAI's
+// own listing has only the id; the other fields are the extensions each
+// vendor adds (groq, openrouter, mistral, vLLM).
+type openAIModel struct {
+	ID                  string `json:"id"`
+	ContextWindow       int    `json:"context_window"`        // groq
+	ContextLength       int    `json:"context_length"`        // openrouter
+	MaxContextLength    int    `json:"max_context_length"`    // mistral
+	MaxModelLen         int    `json:"max_model_len"`         // vLLM
+	MaxCompletionTokens int    `json:"max_completion_tokens"` // groq
+	TopProvider         struct {
+		ContextLength       int `json:"context_length"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	} `json:"top_provider"` // openrouter
+	Architecture struct {
+		InputModalities []string `json:"input_modalities"`
+	} `json:"architecture"` // openrouter
+	SupportedParameters []string `json:"supported_parameters"` // openrouter
+	Capabilities        struct {
+		FunctionCalling *bool `json:"function_calling"`
+		Vision          *bool `json:"vision"`
+	} `json:"capabilities"` // mistral
+	Pricing struct {
+		Prompt     string `json:"prompt"`
+		Completion string `json:"completion"`
+	} `json:"pricing"` // openrouter, USD per token
+}
+
+// capabilities maps whatever the vendor listed onto db.ModelCapabilities.
+func (m openAIModel) capabilities() db.ModelCapabilities {
+	caps := db.ModelCapabilities{
+		MaxOutputTokens: max(m.MaxCompletionTokens, m.TopProvider.MaxCompletionTokens),
+		Modalities:      m.Architecture.InputModalities,
+		Tools:           m.Capabilities.FunctionCalling,
+	}
+	if v := m.Capabilities.Vision; v != nil && len(caps.Modalities) == 0 {
+		caps.Modalities = []string{"text"}
+		if *v {
+			caps.Modalities = append(caps.Modalities, "image")
+		}
+	}
+	if params := m.SupportedParameters; len(params) > 0 {
+		tools := slices.Contains(params, "tools")
+		structured := slices.Contains(params, "response_format") || slices.Contains(params, "structured_outputs")
+		caps.Tools, caps.JSON = &tools, &structured
+	}
+	in, errIn := strconv.ParseFloat(m.Pricing.Prompt, 64)
+	out, errOut := strconv.ParseFloat(m.Pricing.Completion, 64)
+	if errIn == nil && errOut == nil && in >= 0 && out >= 0 { // -1 = variable pricing
+		in, out = in*1e6, out*1e6
+		caps.InputPerMTok, caps.OutputPerMTok = &in, &out
+	}
+	return caps
+}
+
 // discoverGemini queries the Gemini API for available models.
 func (md *ModelDiscovery) discoverGemini(ctx context.Context, ep providerEndpoint) ([]*db.AvailableModel, error) {
 	url := ep.baseURL + "/models?key=" + ep.apiKey
@@ -177,7 +353,7 @@ func (md *ModelDiscovery) discoverGemini(ctx context.Context, ep providerEndpoin
 		return nil, err
 	}
 
-	resp, err := md.httpCl.Do(req)
+	resp, err := ep.httpCl.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -190,8 +366,11 @@ func (md *ModelDiscovery) discoverGemini(ctx context.Context, ep providerEndpoin
 
 	var result struct {
 		Models []struct {
-			Name        string `json:"name"`
-			DisplayName string `json:"displayName"`
+			Name                       string   `json:"name"`
+			DisplayName                string   `json:"displayName"`
+			InputTokenLimit            int      `json:"inputTokenLimit"`
+			OutputTokenLimit           int      `json:"outputTokenLimit"`
+			SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
 		} `json:"models"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -200,22 +379,37 @@ func (md *ModelDiscovery) discoverGemini(ctx context.Context, ep providerEndpoin
 
 	models := make([]*db.AvailableModel, 0, len(result.Models))
 	for _, m := range result.Models {
+		// Embedding and other non-chat models cannot serve completions.
+		if len(m.SupportedGenerationMethods) > 0 && !slices.Contains(m.SupportedGenerationMethods, "generateContent") {
+			continue
+		}
 		// Gemini model names look like "models/gemini-2.0-flash"
 		modelName := strings.TrimPrefix(m.Name, "models/")
-		modelID := "gemini/" + modelName
+		modelID := ep.name + "/" + modelName
 		displayName := m.DisplayName
 		models = append(models, &db.AvailableModel{
 			ModelID:          modelID,
-			Provider:         "gemini",
+			Provider:         ep.name,
 			ModelName:        modelName,
 			DisplayName:      &displayName,
+			ContextWindow:    positive(m.InputTokenLimit),
 			IsAvailable:      true,
-			CapabilitiesJSON: "{}",
+			CapabilitiesJSON: db.ModelCapabilities{MaxOutputTokens: m.OutputTokenLimit}.String(),
 		})
 	}
 	return models, nil
 }
 
+// positive returns a pointer to the first positive value, nil if none.
+func positive(values ...int) *int {
+	for _, v := range values {
+		if v > 0 {
+			return &v
+		}
+	}
+	return nil
+}
+
 // discoverAnthropic returns a hardcoded list (no API endpoint for listing).
 //
 //nolint:unparam // ep kept for interface consistency with discoverOpenAI/discoverGemini
@@ -224,17 +418,25 @@ func (md *ModelDiscovery) discoverAnthropic(ep providerEndpoint) ([]*db.Availabl
 		id      string
 		display string
 		ctx     int
+		output  int
 	}{
-		{"claude-sonnet-4-5-20250929", "Claude Sonnet 4.5", 200000},
-		{"claude-haiku-4-5-20251001", "Claude Haiku 4.5", 200000},
-		{"claude-opus-4-6", "Claude Opus 4.6", 200000},
+		{"claude-sonnet-4-5-20250929", "Claude Sonnet 4.5", 200000, 64000},
+		{"claude-haiku-4-5-20251001", "Claude Haiku 4.5", 200000, 64000},
+		{"claude-opus-4-6", "Claude Opus 4.6", 200000, 128000},
 	}
+	yes := true
 
 	models := make([]*db.AvailableModel, 0, len(knownModels))
 	for _, m := range knownModels {
 		modelID := "anthropic/" + m.id
 		display := m.display
 		ctx := m.ctx
+		caps := db.ModelCapabilities{
+			MaxOutputTokens: m.output,
+			Modalities:      []string{"text", "image"},
+			JSON:            &yes,
+			Tools:           &yes,
+		}
 		models = append(models, &db.AvailableModel{
 			ModelID:          modelID,
 			Provider:         "anthropic",
@@ -242,8 +444,81 @@ func (md *ModelDiscovery) discoverAnthropic(ep providerEndpoint) ([]*db.Availabl
 			DisplayName:      &display,
 			ContextWindow:    &ctx,
 			IsAvailable:      true,
-			CapabilitiesJSON: "{}",
+			CapabilitiesJSON: caps.String(),
 		})
 	}
 	return models, nil
 }
+
+// Probe sends a one-token request to each model worth checking — those
+// listed by their provider's configuration or registration, and those
+// named by workflow steps — and records the outcome in the catalogue. It
+// returns the number of probes sent and the ids of the models that failed.
+// Failures that say nothing about the model (open circuit, rate limit,
+// exhausted budget) leave its row untouched.
+func (md *ModelDiscovery) Probe(ctx context.Context) (int, []string) {
+	probed, failed := 0, []string{}
+	for _, m := range md.probeTargets() {
+		if ctx.Err() != nil {
+			break
+		}
+		pctx, cancel := context.WithTimeout(WithStepName(ctx, "probe"), 30*time.Second)
+		_, err := md.client.CompleteWith(pctx, m.Provider, Request{
+			Model:     m.ModelName,
+			Messages:  []Message{{Role: "user", Content: "ping"}},
+			MaxTokens: 1,
+			Cache:     CacheBypass,
+		})
+		cancel()
+		probed++
+
+		switch class := Classify(err); {
+		case err == nil:
+			_ = md.flowsDB.MarkModelAvailable(m.ModelID)
+		case errors.Is(err, ErrCircuitOpen), class == ErrClassRateLimit, class == ErrClassBudget, class == ErrClassCanceled:
+			md.logger.Debug("model probe skipped", "model", m.ModelID, "error", err)
+		default:
+			md.logger.Warn("model probe failed", "model", m.ModelID, "error", err)
+			_ = md.flowsDB.MarkModelUnavailable(m.ModelID, err.Error())
+			failed = append(failed, m.ModelID)
+		}
+	}
+
+	_ = md.flowsDB.InsertAuditLog("", "", "model_probed", map[string]interface{}{
+		"probed": probed,
+		"failed": failed,
+	})
+	return probed, failed
+}
+
+// probeTargets picks the catalogue models served by a live provider that
+// are configured or used by a workflow step, at most maxProbes of them,
+// step models first. Registered providers that may not be called are
+// never probed.
+func (md *ModelDiscovery) probeTargets() []db.AvailableModel {
+	catalogue, err := md.flowsDB.ListModels("", false)
+	if err != nil {
+		md.logger.Warn("listing models to probe", "error", err)
+		return nil
+	}
+	stepModels, _ := md.flowsDB.StepModels()
+	callable := md.callableRegistered()
+
+	var used, configured []db.AvailableModel
+	for _, m := range catalogue {
+		p, _ := md.client.lookup(m.Provider)
+		switch {
+		case p == nil:
+		case md.client.RegisteredID(m.Provider) != "" && callable[m.Provider] == nil:
+		case slices.Contains(stepModels, m.ModelID):
+			used = append(used, m)
+		case slices.Contains(p.Models(), m.ModelName):
+			configured = append(configured, m)
+		}
+	}
+	targets := append(used, configured...)
+	if md.maxProbes > 0 && len(targets) > md.maxProbes {
+		targets = targets[:md.maxProbes]
+	}
+	return targets
+}
@@ -0,0 +1,196 @@
+// CLAUDE:SUMMARY Embeddings — optional Embedder provider capability, Client.Embed routing through circuit breakers, vector normalisation
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// EmbedRequest asks for one vector per input text. Model may carry a
+// provider prefix ("mistral/mistral-embed") like Request.Model.
+type EmbedRequest struct {
+	Model  string   `json:"model"`
+	Inputs []string `json:"inputs"`
+}
+
+// EmbedResponse holds the vectors in input order, L2-normalised so that
+// cosine similarity is a dot product.
+type EmbedResponse struct {
+	Provider string        `json:"provider"`
+	Model    string        `json:"model"`
+	Vectors  [][]float32   `json:"vectors"`
+	TokensIn int           `json:"tokens_in"`
+	Latency  time.Duration `json:"latency_ms"`
+}
+
+// Embedder is implemented by providers that can produce embeddings.
+type Embedder interface {
+	// EmbeddingModel returns the model used when a request names none,
+	// "" if the provider has no default (the model must then be explicit).
+	EmbeddingModel() string
+	Embed(ctx context.Context, req EmbedRequest) (*EmbedResponse, error)
+}
+
+// ErrNoEmbedder means no provider can serve an embedding request.
+var ErrNoEmbedder = errors.New("no provider supports embeddings")
+
+// SetLocalEmbedder installs the built-in embedder, reachable as "local" and
+// used when no provider can embed. Call it before the client is used.
+func (c *Client) SetLocalEmbedder(e *LocalEmbedder) {
+	c.local = e
+}
+
+// Embed routes an embedding request: "provider/model" goes to that
+// provider (registered providers included, "local" for the built-in
+// embedder), otherwise the first provider of the fallback chain with a
+// default embedding model serves it, then the local embedder if one is set.
+// Remote calls go through the provider's circuit breaker. Vectors from
+// different providers or models are not comparable, so there is no
+// fallback once a provider is chosen.
+func (c *Client) Embed(ctx context.Context, req EmbedRequest) (*EmbedResponse, error) {
+	name, model, err := c.EmbedRoute(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	req.Model = model
+	if len(req.Inputs) == 0 {
+		return &EmbedResponse{Provider: name, Model: model}, nil
+	}
+
+	if name == LocalProvider {
+		resp, err := c.local.Embed(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Provider = LocalProvider
+		return resp, nil
+	}
+
+	p, b := c.lookup(name)
+	e := embedderOf(p)
+	if e == nil {
+		return nil, &ProviderError{Provider: name, Model: model, Err: ErrNoEmbedder}
+	}
+	if ok, wait := b.allow(time.Now()); !ok {
+		return nil, &ProviderError{Provider: name, RetryAfter: wait, Err: ErrCircuitOpen}
+	}
+	resp, err := e.Embed(ctx, req)
+	if err != nil && ctx.Err() != nil {
+		b.abandon()
+	} else {
+		b.record(err, time.Now())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Vectors) != len(req.Inputs) {
+		return nil, &ProviderError{Provider: name, Model: model,
+			Err: fmt.Errorf("got %d embeddings for %d inputs", len(resp.Vectors), len(req.Inputs))}
+	}
+	for _, v := range resp.Vectors {
+		Normalize(v)
+	}
+	// Report the requested model so that vectors are keyed consistently
+	// even when the provider answers with a dated model name.
+	resp.Provider, resp.Model = name, model
+	return resp, nil
+}
+
+// EmbedRoute resolves the provider and model an embedding request for
+// model would use, without calling it. model "" picks the first provider
+// of the fallback chain with a default embedding model, else the local
+// embedder; "local" alone names the local embedder's current model.
+func (c *Client) EmbedRoute(model string) (provider, name string, err error) {
+	provider, name = splitModel(model)
+	if provider == "" && name == LocalProvider {
+		provider, name = LocalProvider, ""
+	}
+	if provider == LocalProvider {
+		if c.local == nil {
+			return "", "", &ProviderError{Provider: provider, Err: ErrNoEmbedder}
+		}
+		if name != "" && name != c.local.EmbeddingModel() {
+			return "", "", &ProviderError{Provider: provider, Model: name,
+				Err: fmt.Errorf("unknown local embedding model (current: %s)", c.local.EmbeddingModel())}
+		}
+		return LocalProvider, c.local.EmbeddingModel(), nil
+	}
+	if provider != "" {
+		p, _ := c.lookup(provider)
+		e := embedderOf(p)
+		if e == nil {
+			return "", "", &ProviderError{Provider: provider, Err: ErrNoEmbedder}
+		}
+		if name == "" {
+			name = e.EmbeddingModel()
+		}
+		if name == "" {
+			return "", "", &ProviderError{Provider: provider, Err: fmt.Errorf("no embedding model specified")}
+		}
+		return provider, name, nil
+	}
+	for _, candidate := range c.fallbackOrder() {
+		p, _ := c.lookup(candidate)
+		if e := embedderOf(p); e != nil && e.EmbeddingModel() != "" {
+			if name == "" {
+				name = e.EmbeddingModel()
+			}
+			return candidate, name, nil
+		}
+	}
+	if c.local != nil && name == "" {
+		return LocalProvider, c.local.EmbeddingModel(), nil
+	}
+	return "", "", ErrNoEmbedder
+}
+
+// Embedders maps each provider that can serve embeddings, the local
+// embedder included, to its default embedding model ("" = the model must
+// be named).
+func (c *Client) Embedders() map[string]string {
+	out := make(map[string]string)
+	if c.local != nil {
+		out[LocalProvider] = c.local.EmbeddingModel()
+	}
+	for _, name := range c.AllProviders() {
+		p, _ := c.lookup(name)
+		if e := embedderOf(p); e != nil {
+			out[name] = e.EmbeddingModel()
+		}
+	}
+	return out
+}
+
+// embedderOf finds the Embedder capability through decorators.
+// Offline stand-ins have nothing behind them and cannot embed.
+func embedderOf(p Provider) Embedder {
+	for p != nil {
+		if e, ok := p.(Embedder); ok {
+			return e
+		}
+		u, ok := p.(interface{ Unwrap() Provider })
+		if !ok {
+			return nil
+		}
+		p = u.Unwrap()
+	}
+	return nil
+}
+
+// Normalize scales v to unit length in place (a zero vector is left as is).
+func Normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	inv := float32(1 / math.Sqrt(sum))
+	for i := range v {
+		v[i] *= inv
+	}
+}
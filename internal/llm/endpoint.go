@@ -0,0 +1,113 @@
+// CLAUDE:SUMMARY Endpoint address policy for self-registered providers — http(s) only, no loopback, link-local, private or unspecified addresses unless an operator allowed them, checked at registration and again on every dial
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrEndpointNotAllowed is returned for an endpoint outside the address
+// policy: not http(s), or resolving to a restricted address.
+var ErrEndpointNotAllowed = errors.New("endpoint not allowed")
+
+// cgnat is the shared address space of carrier-grade NAT (RFC 6598),
+// private in practice though netip does not classify it so.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// restrictedAddr reports whether ip is an address a registered endpoint
+// may only use with an operator's permission: loopback, private,
+// link-local, unspecified or multicast.
+func restrictedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
+
+// endpointURL parses endpoint and checks its scheme and, when the host is
+// an IP literal, its address. Host names are left to the dial-time check.
+func endpointURL(endpoint string, allowPrivate bool) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q is not an absolute URL", ErrEndpointNotAllowed, endpoint)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: scheme %q (http or https only)", ErrEndpointNotAllowed, u.Scheme)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("%w: credentials in the URL", ErrEndpointNotAllowed)
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil && !allowPrivate && restrictedAddr(ip) {
+		return nil, fmt.Errorf("%w: %s is a loopback, private or link-local address", ErrEndpointNotAllowed, ip)
+	}
+	return u, nil
+}
+
+// CheckEndpoint validates a provider endpoint against the address policy,
+// resolving its host: every address it resolves to must be allowed.
+// allowPrivate lifts the address restriction (operator's decision), not
+// the scheme one.
+func CheckEndpoint(ctx context.Context, endpoint string, allowPrivate bool) error {
+	u, err := endpointURL(endpoint, allowPrivate)
+	if err != nil || allowPrivate {
+		return err
+	}
+	host := u.Hostname()
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("%w: resolving %s: %v", ErrEndpointNotAllowed, host, err)
+	}
+	for _, ip := range addrs {
+		if restrictedAddr(ip) {
+			return fmt.Errorf("%w: %s resolves to %s, a loopback, private or link-local address", ErrEndpointNotAllowed, host, ip.Unmap())
+		}
+	}
+	return nil
+}
+
+// endpointClient returns an HTTP client for a registered endpoint. Unless
+// allowPrivate, each connection is checked after DNS resolution, so a host
+// name re-pointed at an internal address after registration is refused.
+// Proxies from the environment are not used: they would hide the address.
+func endpointClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrEndpointNotAllowed, address)
+			}
+			if restrictedAddr(ap.Addr()) {
+				return fmt.Errorf("%w: %s is a loopback, private or link-local address", ErrEndpointNotAllowed, ap.Addr().Unmap())
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			_, err := endpointURL(req.URL.String(), allowPrivate)
+			return err
+		},
+	}
+}
@@ -1,9 +1,14 @@
-// CLAUDE:SUMMARY Sentinel errors and ProviderError type for LLM provider failures
+// CLAUDE:SUMMARY Sentinel errors, ProviderError type and error classification (auth/rate_limit/server/timeout) for LLM provider failures
 package llm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
 )
 
 var (
@@ -11,13 +16,26 @@ var (
 	ErrNoAPIKey         = errors.New("no API key configured")
 	ErrRateLimited      = errors.New("rate limited")
 	ErrContextTooLong   = errors.New("context too long")
+	ErrCircuitOpen      = errors.New("circuit open")
+	ErrBudgetExceeded   = errors.New("budget exceeded")
+	ErrCassetteMiss     = errors.New("no recorded response")
+	ErrSchemaMismatch   = errors.New("response does not match schema")
+	ErrToolLimit        = errors.New("tool call limit reached")
+	ErrStepTimeout      = errors.New("step timed out")
+
+	// errStreamAborted marks a stream stopped by the caller's StreamFunc
+	// (e.g. the SSE client disconnected) rather than by the provider.
+	errStreamAborted = errors.New("stream aborted by caller")
 )
 
 // ProviderError wraps an error with provider context.
+// StatusCode and RetryAfter are set when the failure came from an HTTP response.
 type ProviderError struct {
-	Provider string
-	Model    string
-	Err      error
+	Provider   string
+	Model      string
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
 }
 
 func (e *ProviderError) Error() string {
@@ -28,3 +46,105 @@ func (e *ProviderError) Error() string {
 }
 
 func (e *ProviderError) Unwrap() error { return e.Err }
+
+// httpError builds a ProviderError from a non-200 provider response.
+// 429 maps to ErrRateLimited; Retry-After is captured for the circuit breaker.
+func httpError(provider, model string, resp *http.Response, body []byte) *ProviderError {
+	pe := &ProviderError{
+		Provider:   provider,
+		Model:      model,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		pe.Err = ErrRateLimited
+	} else {
+		pe.Err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, truncate(string(body), 200))
+	}
+	return pe
+}
+
+// parseRetryAfter accepts both forms of the header: delay-seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func asProviderError(err error) (*ProviderError, bool) {
+	var pe *ProviderError
+	ok := errors.As(err, &pe)
+	return pe, ok
+}
+
+// ErrorClass groups provider failures by how the fallback chain should react.
+type ErrorClass string
+
+const (
+	ErrClassNone      ErrorClass = ""
+	ErrClassAuth      ErrorClass = "auth"       // bad/missing key — won't heal on its own
+	ErrClassRateLimit ErrorClass = "rate_limit" // 429 — wait for Retry-After
+	ErrClassServer    ErrorClass = "server"     // 5xx, malformed response
+	ErrClassTimeout   ErrorClass = "timeout"    // deadline exceeded, network timeout
+	ErrClassClient    ErrorClass = "client"     // other 4xx — the request's fault, not the provider's
+	ErrClassCanceled  ErrorClass = "canceled"   // caller went away
+	ErrClassBudget    ErrorClass = "budget"     // refused locally — a spend budget is exhausted
+)
+
+// Classify maps an error returned by a Provider to an ErrorClass.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ErrClassNone
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, errStreamAborted) {
+		return ErrClassCanceled
+	}
+	if errors.Is(err, ErrBudgetExceeded) {
+		return ErrClassBudget
+	}
+	if errors.Is(err, ErrCassetteMiss) || errors.Is(err, ErrSchemaMismatch) || errors.Is(err, ErrToolLimit) {
+		// A replay miss, an unrepairable reply or a runaway tool loop is
+		// the request's fault; the provider is not "down".
+		return ErrClassClient
+	}
+	if errors.Is(err, ErrRateLimited) {
+		return ErrClassRateLimit
+	}
+	if errors.Is(err, ErrNoAPIKey) {
+		return ErrClassAuth
+	}
+
+	if pe, ok := asProviderError(err); ok && pe.StatusCode != 0 {
+		switch {
+		case pe.StatusCode == http.StatusUnauthorized || pe.StatusCode == http.StatusForbidden:
+			return ErrClassAuth
+		case pe.StatusCode == http.StatusTooManyRequests:
+			return ErrClassRateLimit
+		case pe.StatusCode == http.StatusRequestTimeout || pe.StatusCode == http.StatusGatewayTimeout:
+			return ErrClassTimeout
+		case pe.StatusCode >= 500:
+			return ErrClassServer
+		case pe.StatusCode >= 400:
+			return ErrClassClient
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrClassTimeout
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return ErrClassTimeout
+	}
+	return ErrClassServer
+}
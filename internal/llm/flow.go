@@ -1,11 +1,11 @@
-// CLAUDE:SUMMARY Flow engine — executes multi-step thinking flows (attacker/defender/judge) with template-based prompts
+// CLAUDE:SUMMARY Flow engine — executes multi-step thinking flows (attacker/defender/judge) with template-based prompts, conditional edges and bounded debate rounds
 package llm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
-	"strings"
 	"time"
 
 	"github.com/hazyhaar/horostracker/internal/db"
@@ -13,19 +13,59 @@ import (
 
 // FlowStep defines a single step in a thinking flow.
 type FlowStep struct {
-	Name     string `toml:"name"`
-	Provider string `toml:"provider"` // provider name or "$TARGET"
-	Model    string `toml:"model"`    // model ID or "$TARGET"
-	Role     string `toml:"role"`     // "attacker", "defender", "judge", "synthesizer"
-	Prompt   string `toml:"prompt"`   // prompt template with {{.Body}}, {{.PreviousResponse}}, etc.
-	System   string `toml:"system"`   // system prompt template
+	Name     string  `toml:"name"`
+	Provider string  `toml:"provider"` // provider name or "$TARGET"
+	Model    string  `toml:"model"`    // model ID or "$TARGET"
+	Role     string  `toml:"role"`     // "attacker", "defender", "judge", "synthesizer"
+	Prompt   string  `toml:"prompt"`   // prompt template with {{.Body}}, {{.PreviousResponse}}, etc.
+	System   string  `toml:"system"`   // system prompt template
+	Schema   *Schema `toml:"-"`        // structured output the step must return, nil for free text
+
+	// SchemaSpec is how flow files set Schema: "judge" for the verdict of
+	// the built-in judges, or an inline JSON Schema document.
+	SchemaSpec string `toml:"schema"`
+
+	Tools             []string `toml:"tools"`               // built-in tools the model may call (see NewDBToolset)
+	MaxToolIterations int      `toml:"max_tool_iterations"` // rounds of tool calls allowed, 0 = 5
+
+	// History sends the flow's earlier turns as a conversation before the
+	// prompt: the step's own prompts and responses as user and assistant
+	// turns, the other steps' responses as user turns.
+	History bool `toml:"history"`
+
+	// Next is tried in order once the step succeeds; the first matching
+	// edge picks the step that runs next. With no match the flow goes on
+	// with the following step.
+	Next []FlowEdge `toml:"next"`
 }
 
+// FlowEnd is the Goto of an edge that ends the flow.
+const FlowEnd = "end"
+
+// FlowEdge is a conditional jump on a step's structured output. An edge
+// with no Field always matches; otherwise Field, a top-level key of the
+// step's JSON output, must equal Equals and lie above Above and below Below,
+// for those that are set. Going back to the same or an earlier step starts
+// a new round.
+type FlowEdge struct {
+	Field  string      `toml:"field"`
+	Equals interface{} `toml:"equals"`
+	Above  *float64    `toml:"above"`
+	Below  *float64    `toml:"below"`
+	Goto   string      `toml:"goto"` // step name, or FlowEnd
+}
+
+// DefaultFlowRounds is how many rounds a flow with loops runs at most when
+// it does not set max_rounds.
+const DefaultFlowRounds = 3
+
 // FlowConfig defines a complete thinking flow.
 type FlowConfig struct {
 	Name        string     `toml:"name"`
 	Description string     `toml:"description"`
 	Steps       []FlowStep `toml:"steps"`
+	MaxRounds   int        `toml:"max_rounds"` // rounds a loop may run, 0 = DefaultFlowRounds
+	Source      string     `toml:"-"`          // flow file it was read from, "" for built-ins
 }
 
 // FlowEngine executes thinking flows and persists results to flows.db.
@@ -33,6 +73,7 @@ type FlowEngine struct {
 	client   *Client
 	flowsDB  *db.FlowsDB
 	logger   *slog.Logger
+	tools    *Toolset // nil = steps run without tools
 }
 
 // NewFlowEngine creates a flow execution engine.
@@ -40,6 +81,11 @@ func NewFlowEngine(client *Client, flowsDB *db.FlowsDB, logger *slog.Logger) *Fl
 	return &FlowEngine{client: client, flowsDB: flowsDB, logger: logger}
 }
 
+// SetTools sets the toolset steps pick their Tools from.
+func (e *FlowEngine) SetTools(tools *Toolset) {
+	e.tools = tools
+}
+
 // FlowContext carries data through flow steps.
 type FlowContext struct {
 	FlowID           string
@@ -49,11 +95,22 @@ type FlowContext struct {
 	AllResponses     map[string]string // keyed by step name
 	TargetProvider   string // provider to use for $TARGET
 	TargetModel      string // model to use for $TARGET
+	Round            int // 1 for the first pass, +1 each time an edge loops back
+	Transcript       []FlowTurn // successful steps so far, in order
+}
+
+// FlowTurn is one successful step of a running flow, as replayed to steps
+// with History.
+type FlowTurn struct {
+	Step    string
+	Prompt  string
+	Content string
 }
 
 // FlowResult holds the complete result of a flow execution.
 type FlowResult struct {
 	FlowID    string
+	RunID     string `json:",omitempty"` // workflow_runs row, for flows run as workflows
 	Steps     []StepResult
 	Duration  time.Duration
 }
@@ -65,6 +122,9 @@ type StepResult struct {
 	Model    string
 	Response *Response
 	Error    error
+	Round    int
+
+	prompt, system string // as sent, for persistence
 }
 
 // Execute runs a thinking flow with the given context.
@@ -76,37 +136,161 @@ func (e *FlowEngine) Execute(ctx context.Context, flow FlowConfig, fctx FlowCont
 		fctx.AllResponses = make(map[string]string)
 	}
 
+	fctx.Round = 1
+
 	result := &FlowResult{FlowID: fctx.FlowID}
 	start := time.Now()
 
-	for i, step := range flow.Steps {
+	// seq numbers the steps as they run, so that a step run again in a
+	// later round is persisted under its own step_index.
+	for i, seq := 0, 0; i < len(flow.Steps); seq++ {
+		step := flow.Steps[i]
 		e.logger.Info("flow step",
 			"flow", flow.Name,
 			"step", step.Name,
-			"index", i,
+			"index", seq,
+			"round", fctx.Round,
 			"provider", step.Provider,
 		)
 
-		sr, err := e.executeStep(ctx, step, &fctx, i)
-		if err != nil {
-			sr = StepResult{Name: step.Name, Error: err}
-		}
+		// A failed step keeps its partial response (tool rounds made
+		// before the failure) for persistence, but feeds nothing forward.
+		sr, _ := e.executeStep(ctx, step, &fctx, seq)
+		sr.Round = fctx.Round
 		result.Steps = append(result.Steps, sr)
 
 		// Update context for next step
-		if sr.Response != nil {
+		ok := sr.Response != nil && sr.Error == nil
+		if ok {
 			fctx.PreviousResponse = sr.Response.Content
 			fctx.AllResponses[step.Name] = sr.Response.Content
+			fctx.Transcript = append(fctx.Transcript, FlowTurn{Step: step.Name, Prompt: sr.prompt, Content: sr.Response.Content})
 		}
 
 		// Persist to flows.db
-		e.persistStep(fctx, step, sr, i)
+		e.persistStep(fctx, step, sr, seq)
+
+		if ok {
+			i = e.nextStep(flow, i, sr.Response, &fctx)
+		} else {
+			i++
+		}
 	}
 
 	result.Duration = time.Since(start)
 	return result, nil
 }
 
+// nextStep follows the first matching edge of the step at i and returns
+// the index of the step to run next (len(flow.Steps) to end the flow).
+// Edges looping back are skipped once the flow is at its last round.
+func (e *FlowEngine) nextStep(flow FlowConfig, i int, resp *Response, fctx *FlowContext) int {
+	maxRounds := flow.MaxRounds
+	if maxRounds <= 0 {
+		maxRounds = DefaultFlowRounds
+	}
+	var output map[string]interface{}
+	if len(resp.JSON) > 0 {
+		_ = json.Unmarshal(resp.JSON, &output)
+	}
+	for _, edge := range flow.Steps[i].Next {
+		if !edge.matches(output) {
+			continue
+		}
+		if edge.Goto == FlowEnd {
+			return len(flow.Steps)
+		}
+		target := flow.stepIndex(edge.Goto)
+		if target < 0 {
+			continue
+		}
+		if target <= i {
+			if fctx.Round >= maxRounds {
+				e.logger.Info("flow round limit reached", "flow", flow.Name, "step", flow.Steps[i].Name, "rounds", maxRounds)
+				continue
+			}
+			fctx.Round++
+		}
+		return target
+	}
+	return i + 1
+}
+
+// stepIndex returns the index of the named step, -1 if there is none.
+func (f FlowConfig) stepIndex(name string) int {
+	for i, s := range f.Steps {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// matches reports whether a step's JSON output satisfies the edge.
+func (edge FlowEdge) matches(output map[string]interface{}) bool {
+	if edge.Field == "" {
+		return true
+	}
+	v, ok := output[edge.Field]
+	if !ok {
+		return false
+	}
+	if edge.Equals != nil && !sameValue(edge.Equals, v) {
+		return false
+	}
+	if edge.Above != nil || edge.Below != nil {
+		n, isNum := v.(float64)
+		if !isNum || (edge.Above != nil && n <= *edge.Above) || (edge.Below != nil && n >= *edge.Below) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValue compares a TOML value with a JSON one: numbers by value
+// whatever their type, everything else by type and value.
+func sameValue(want, got interface{}) bool {
+	switch w := want.(type) {
+	case int64:
+		return got == float64(w)
+	case float64:
+		return got == w
+	case bool, string:
+		return got == w
+	default:
+		return false
+	}
+}
+
+// flowMessages builds the messages of a step: the system prompt, the
+// transcript when the step has History, then the prompt. Consecutive user
+// turns are merged so that providers requiring alternation accept them.
+func flowMessages(step FlowStep, fctx *FlowContext, prompt, system string) []Message {
+	var messages []Message
+	if system != "" {
+		messages = append(messages, Message{Role: "system", Content: system})
+	}
+	add := func(role, content string) {
+		if n := len(messages); n > 0 && role == "user" && messages[n-1].Role == "user" {
+			messages[n-1].Content += "\n\n" + content
+			return
+		}
+		messages = append(messages, Message{Role: role, Content: content})
+	}
+	if step.History {
+		for _, turn := range fctx.Transcript {
+			if turn.Step == step.Name {
+				add("user", turn.Prompt)
+				add("assistant", turn.Content)
+				continue
+			}
+			add("user", fmt.Sprintf("[%s]\n%s", turn.Step, turn.Content))
+		}
+	}
+	add("user", prompt)
+	return messages
+}
+
 //nolint:unparam // index reserved for step-level persistence
 func (e *FlowEngine) executeStep(ctx context.Context, step FlowStep, fctx *FlowContext, index int) (StepResult, error) {
 	// Resolve provider and model
@@ -119,26 +303,36 @@ func (e *FlowEngine) executeStep(ctx context.Context, step FlowStep, fctx *FlowC
 		model = fctx.TargetModel
 	}
 
-	// Render prompt template
-	prompt := renderTemplate(step.Prompt, fctx)
-	system := renderTemplate(step.System, fctx)
-
-	var messages []Message
-	if system != "" {
-		messages = append(messages, Message{Role: "system", Content: system})
+	// Render prompt template. A step that reads a failed step's response
+	// fails in turn instead of sending the placeholder to the model.
+	prompt, err := renderTemplate(step.Prompt, fctx)
+	if err != nil {
+		err = fmt.Errorf("prompt: %w", err)
+		return StepResult{Name: step.Name, Provider: provider, Model: model, Error: err}, err
+	}
+	system, err := renderTemplate(step.System, fctx)
+	if err != nil {
+		err = fmt.Errorf("system: %w", err)
+		return StepResult{Name: step.Name, Provider: provider, Model: model, Error: err}, err
 	}
-	messages = append(messages, Message{Role: "user", Content: prompt})
 
 	req := Request{
 		Model:    model,
-		Messages: messages,
+		Messages: flowMessages(step, fctx, prompt, system),
+		Schema:   step.Schema,
 	}
 
+	ctx = WithStepName(ctx, step.Name)
 	var resp *Response
-	var err error
-	if provider != "" {
+	switch {
+	case len(step.Tools) > 0 && e.tools != nil:
+		var tools *Toolset
+		if tools, err = e.tools.Select(step.Tools); err == nil {
+			resp, err = e.client.CompleteTools(ctx, provider, req, tools, step.MaxToolIterations)
+		}
+	case provider != "":
 		resp, err = e.client.CompleteWith(ctx, provider, req)
-	} else {
+	default:
 		resp, err = e.client.Complete(ctx, req)
 	}
 
@@ -148,6 +342,8 @@ func (e *FlowEngine) executeStep(ctx context.Context, step FlowStep, fctx *FlowC
 		Model:    model,
 		Response: resp,
 		Error:    err,
+		prompt:   prompt,
+		system:   system,
 	}, err
 }
 
@@ -155,22 +351,42 @@ func (e *FlowEngine) persistStep(fctx FlowContext, step FlowStep, sr StepResult,
 	if e.flowsDB == nil {
 		return
 	}
+	if sr.prompt == "" {
+		sr.prompt = step.Prompt // the template did not render
+	}
+	insertFlowStep(e.flowsDB, fctx.FlowID, fctx.NodeID, index, sr)
+}
 
+// insertFlowStep writes the forensic trace of one step of a flow, or of a
+// workflow run traced as one, to flow_steps.
+func insertFlowStep(flowsDB *db.FlowsDB, flowID, nodeID string, index int, sr StepResult) {
 	id := db.NewID()
 	var responseRaw, responseParsed, errMsg string
 	var tokensIn, tokensOut, latencyMs int
+	var costUSD float64
+	var cached bool
 	var finishReason string
 	var provider, model string
+	var toolCalls interface{}
 
 	if sr.Response != nil {
 		responseRaw = sr.Response.Content
 		responseParsed = sr.Response.Content
+		if len(sr.Response.JSON) > 0 {
+			responseParsed = string(sr.Response.JSON)
+		}
 		tokensIn = sr.Response.TokensIn
 		tokensOut = sr.Response.TokensOut
 		latencyMs = int(sr.Response.Latency.Milliseconds())
+		costUSD = sr.Response.CostUSD
+		cached = sr.Response.Cached
 		finishReason = sr.Response.FinishReason
 		provider = sr.Response.Provider
 		model = sr.Response.Model
+		if len(sr.Response.ToolRounds) > 0 {
+			b, _ := json.Marshal(sr.Response.ToolRounds)
+			toolCalls = string(b)
+		}
 	} else {
 		provider = sr.Provider
 		model = sr.Model
@@ -179,32 +395,37 @@ func (e *FlowEngine) persistStep(fctx FlowContext, step FlowStep, sr StepResult,
 		errMsg = sr.Error.Error()
 	}
 
-	prompt := renderTemplate(step.Prompt, &fctx)
-	systemPrompt := renderTemplate(step.System, &fctx)
-
-	_, _ = e.flowsDB.Exec(`
-		INSERT INTO flow_steps (id, flow_id, step_index, node_id, model_id, provider,
+	_, _ = flowsDB.Exec(`
+		INSERT INTO flow_steps (id, flow_id, step_index, step_name, round, node_id, model_id, provider,
 			prompt, system_prompt, response_raw, response_parsed,
-			tokens_in, tokens_out, latency_ms, finish_reason, error)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		id, fctx.FlowID, index, nilIfEmpty(fctx.NodeID),
-		model, provider, prompt, systemPrompt,
+			tokens_in, tokens_out, latency_ms, cost_usd, cached, finish_reason, error, tool_calls)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, flowID, index, sr.Name, sr.Round, nilIfEmpty(nodeID),
+		model, provider, sr.prompt, sr.system,
 		responseRaw, responseParsed,
-		tokensIn, tokensOut, latencyMs, finishReason, nilIfEmpty(errMsg))
+		tokensIn, tokensOut, latencyMs, costUSD, cached, finishReason, nilIfEmpty(errMsg), toolCalls)
 }
 
-// renderTemplate replaces {{.Body}}, {{.PreviousResponse}}, {{.Step.<name>}} in template.
-func renderTemplate(tmpl string, fctx *FlowContext) string {
-	if tmpl == "" {
-		return ""
-	}
-	s := tmpl
-	s = strings.ReplaceAll(s, "{{.Body}}", fctx.Body)
-	s = strings.ReplaceAll(s, "{{.PreviousResponse}}", fctx.PreviousResponse)
-	for name, resp := range fctx.AllResponses {
-		s = strings.ReplaceAll(s, fmt.Sprintf("{{.Step.%s}}", name), resp)
-	}
-	return s
+// flowTemplateSample stands for the fields flow step templates can use
+// besides .Step, with their types, when templates are checked.
+var flowTemplateSample = map[string]interface{}{
+	"Body":             "sample",
+	"NodeID":           "sample",
+	"PreviousResponse": "sample",
+	"Round":            1,
+}
+
+// renderTemplate renders a flow step template against the flow context.
+// .Step holds the latest response of each step that succeeded so far;
+// .Round is the current round of a looping flow.
+func renderTemplate(tmpl string, fctx *FlowContext) (string, error) {
+	return executeTemplate("prompt", tmpl, map[string]interface{}{
+		"Body":             fctx.Body,
+		"NodeID":           fctx.NodeID,
+		"PreviousResponse": fctx.PreviousResponse,
+		"Step":             copyMap(fctx.AllResponses),
+		"Round":            fctx.Round,
+	})
 }
 
 func nilIfEmpty(s string) interface{} {
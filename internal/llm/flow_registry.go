@@ -0,0 +1,381 @@
+// CLAUDE:SUMMARY Flow registry — built-in thinking flows overlaid by TOML flow files from a directory, validated at load and reloaded when the files change
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FlowRegistry holds the thinking flows challenges run: the built-ins
+// (CoreFlows) overlaid by one TOML file per flow in a directory. A file
+// flow named like a built-in replaces it. Files are validated when read; a
+// file that fails validation is reported and the last valid version read
+// from it, if any, stays in use, so a half-edited file never takes a flow
+// down.
+type FlowRegistry struct {
+	dir    string
+	tools  *Toolset // nil = tool names are not checked
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	flows    []FlowConfig
+	files    map[string]flowFile // path → last read of the file
+	loadedAt time.Time
+}
+
+// flowFile is what a reload remembers of a file: the stamp of the content
+// last read, the last valid flow read from it, why the current content was
+// rejected, if it was, and the file its flow lost a name conflict to.
+type flowFile struct {
+	stamp    string
+	flow     *FlowConfig
+	err      string
+	conflict string
+}
+
+// FlowFileStatus reports one flow file for GET /api/flows/status.
+type FlowFileStatus struct {
+	File  string `json:"file"`
+	Flow  string `json:"flow,omitempty"`  // name of the flow in use from this file
+	Error string `json:"error,omitempty"` // why the current content was rejected
+}
+
+// NewFlowRegistry creates a registry over dir. An empty dir, or one that
+// does not exist, serves the built-ins only. Call Reload to read the files.
+func NewFlowRegistry(dir string, logger *slog.Logger) *FlowRegistry {
+	return &FlowRegistry{
+		dir:    dir,
+		logger: logger,
+		flows:  CoreFlows(),
+		files:  make(map[string]flowFile),
+	}
+}
+
+// SetTools sets the toolset step tool names are checked against.
+func (r *FlowRegistry) SetTools(tools *Toolset) {
+	r.tools = tools
+}
+
+// activeFlows is the registry read by Flows, LookupFlow and ValidFlows.
+var activeFlows atomic.Pointer[FlowRegistry]
+
+// UseFlowRegistry makes r the flow set of the process.
+func UseFlowRegistry(r *FlowRegistry) {
+	activeFlows.Store(r)
+}
+
+// Flows returns the flows in use: the active registry's, or the built-ins
+// when none was set.
+func Flows() []FlowConfig {
+	if r := activeFlows.Load(); r != nil {
+		return r.Flows()
+	}
+	return CoreFlows()
+}
+
+// LookupFlow returns the flow in use under name.
+func LookupFlow(name string) (FlowConfig, bool) {
+	for _, f := range Flows() {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return FlowConfig{}, false
+}
+
+// Flows returns a copy of the registry's flows, built-ins first in their
+// usual order, then flows that exist only as files, by name.
+func (r *FlowRegistry) Flows() []FlowConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.flows)
+}
+
+// Status lists the flow files seen by the last reload.
+func (r *FlowRegistry) Status() (string, time.Time, []FlowFileStatus) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	status := make([]FlowFileStatus, 0, len(r.files))
+	for path, f := range r.files {
+		s := FlowFileStatus{File: filepath.Base(path), Error: f.err}
+		switch {
+		case f.conflict != "":
+			s.Error = fmt.Sprintf("flow %q is already defined in %s", f.flow.Name, f.conflict)
+		case f.flow != nil:
+			s.Flow = f.flow.Name
+		}
+		status = append(status, s)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].File < status[j].File })
+	return r.dir, r.loadedAt, status
+}
+
+// Watch reloads the directory every interval until ctx is done. Files are
+// only re-read when their size or modification time changed.
+func (r *FlowRegistry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				r.logger.Warn("reloading flows", "dir", r.dir, "error", err)
+			}
+		}
+	}
+}
+
+// Reload re-reads the changed *.toml files of the directory and rebuilds
+// the flow set. It returns an error only when the directory cannot be
+// listed; rejected files are logged and reported by Status.
+func (r *FlowRegistry) Reload() error {
+	var paths []string
+	if r.dir != "" {
+		entries, err := os.ReadDir(r.dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), ".toml") {
+				paths = append(paths, filepath.Join(r.dir, e.Name()))
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := make(map[string]flowFile, len(paths))
+	changed := len(paths) != len(r.files)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue // removed since listed
+		}
+		stamp := fmt.Sprintf("%d|%d", info.Size(), info.ModTime().UnixNano())
+		prev, seen := r.files[path]
+		if seen && prev.stamp == stamp {
+			files[path] = prev
+			continue
+		}
+		changed = true
+
+		f := flowFile{stamp: stamp, flow: prev.flow}
+		flow, err := r.readFlowFile(path)
+		if err != nil {
+			f.err = err.Error()
+			r.logger.Warn("flow file rejected", "file", path, "error", err)
+		} else {
+			f.flow = &flow
+			r.logger.Info("flow file loaded", "file", path, "flow", flow.Name, "steps", len(flow.Steps))
+		}
+		files[path] = f
+	}
+
+	r.files = files
+	r.loadedAt = time.Now()
+	if changed {
+		r.flows = r.merge()
+	}
+	return nil
+}
+
+// merge overlays the file flows on the built-ins. Two files defining the
+// same flow are a conflict: the first file by name keeps it and the other
+// is reported.
+func (r *FlowRegistry) merge() []FlowConfig {
+	paths := make([]string, 0, len(r.files))
+	for path := range r.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	byName := make(map[string]FlowConfig)
+	owner := make(map[string]string)
+	for _, path := range paths {
+		f := r.files[path]
+		f.conflict = ""
+		if f.flow != nil {
+			if other, dup := owner[f.flow.Name]; dup {
+				f.conflict = filepath.Base(other)
+			}
+		}
+		r.files[path] = f
+		if f.flow == nil || f.conflict != "" {
+			continue
+		}
+		owner[f.flow.Name] = path
+		byName[f.flow.Name] = *f.flow
+	}
+
+	var flows []FlowConfig
+	for _, builtin := range CoreFlows() {
+		if f, ok := byName[builtin.Name]; ok {
+			flows = append(flows, f)
+			delete(byName, builtin.Name)
+			continue
+		}
+		flows = append(flows, builtin)
+	}
+	extra := make([]string, 0, len(byName))
+	for name := range byName {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		flows = append(flows, byName[name])
+	}
+	return flows
+}
+
+// readFlowFile decodes and validates one flow file. The flow name defaults
+// to the file name without its extension.
+func (r *FlowRegistry) readFlowFile(path string) (FlowConfig, error) {
+	var flow FlowConfig
+	md, err := toml.DecodeFile(path, &flow)
+	if err != nil {
+		return FlowConfig{}, err
+	}
+	var problems []string
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		problems = append(problems, "unknown keys: "+strings.Join(keys, ", "))
+	}
+	if flow.Name == "" {
+		flow.Name = strings.TrimSuffix(filepath.Base(path), ".toml")
+	}
+	flow.Source = filepath.Base(path)
+	for i := range flow.Steps {
+		s := &flow.Steps[i]
+		if s.SchemaSpec == "" {
+			continue
+		}
+		if s.Schema, err = flowStepSchema(flow.Name, s.Name, s.SchemaSpec); err != nil {
+			problems = append(problems, fmt.Sprintf("step %q: schema: %v", s.Name, err))
+		}
+	}
+	problems = append(problems, r.validate(flow)...)
+	if len(problems) > 0 {
+		return FlowConfig{}, errors.New(strings.Join(problems, "; "))
+	}
+	return flow, nil
+}
+
+// flowStepSchema resolves a step's schema setting: "judge" is the verdict
+// schema of the built-in judges, anything else an inline JSON Schema.
+func flowStepSchema(flow, step, spec string) (*Schema, error) {
+	if spec == "judge" {
+		return judgeSchema, nil
+	}
+	return ParseSchema(flow+"_"+step, spec)
+}
+
+var (
+	flowNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	flowStepRoles   = []string{"attacker", "defender", "judge", "synthesizer"}
+)
+
+// validate returns every problem of a flow, so that one read of the
+// status shows all there is to fix.
+func (r *FlowRegistry) validate(flow FlowConfig) []string {
+	var problems []string
+	if !flowNamePattern.MatchString(flow.Name) {
+		problems = append(problems, fmt.Sprintf("flow name %q must be lowercase letters, digits and underscores", flow.Name))
+	}
+	if len(flow.Steps) == 0 {
+		problems = append(problems, "no steps")
+	}
+	if flow.MaxRounds < 0 {
+		problems = append(problems, "max_rounds must not be negative")
+	}
+
+	seen := make(map[string]bool)
+	for i, s := range flow.Steps {
+		at := fmt.Sprintf("step %d", i+1)
+		if s.Name != "" {
+			at = fmt.Sprintf("step %q", s.Name)
+		}
+		switch {
+		case !flowNamePattern.MatchString(s.Name):
+			problems = append(problems, fmt.Sprintf("%s: name must be lowercase letters, digits and underscores", at))
+		case s.Name == FlowEnd:
+			problems = append(problems, fmt.Sprintf("%s: %q is reserved for edges ending the flow", at, FlowEnd))
+		case seen[s.Name]:
+			problems = append(problems, fmt.Sprintf("%s: duplicate step name", at))
+		}
+		if !slices.Contains(flowStepRoles, s.Role) {
+			problems = append(problems, fmt.Sprintf("%s: role must be one of %s", at, strings.Join(flowStepRoles, ", ")))
+		}
+		if strings.TrimSpace(s.Prompt) == "" {
+			problems = append(problems, at+": empty prompt")
+		}
+		if (s.Model == "$TARGET") != (s.Provider == "$TARGET") && s.Provider != "" {
+			problems = append(problems, at+": $TARGET must be used for both provider and model")
+		}
+		earlier := make([]string, 0, len(seen))
+		for name := range seen {
+			earlier = append(earlier, name)
+		}
+		if err := checkTemplate("system", s.System, flowTemplateSample, earlier); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", at, err))
+		}
+		if err := checkTemplate("prompt", s.Prompt, flowTemplateSample, earlier); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", at, err))
+		}
+		if len(s.Tools) > 0 && r.tools != nil {
+			if _, err := r.tools.Select(s.Tools); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", at, err))
+			}
+		}
+		if s.MaxToolIterations < 0 {
+			problems = append(problems, at+": max_tool_iterations must not be negative")
+		}
+		for j, edge := range s.Next {
+			problems = append(problems, validateEdge(flow, s, fmt.Sprintf("%s: next %d", at, j+1), edge)...)
+		}
+		seen[s.Name] = true
+	}
+	return problems
+}
+
+// validateEdge checks that an edge goes to a step of the flow and that its
+// condition reads structured output of a type it can compare.
+func validateEdge(flow FlowConfig, s FlowStep, at string, edge FlowEdge) []string {
+	var problems []string
+	if edge.Goto != FlowEnd && flow.stepIndex(edge.Goto) < 0 {
+		problems = append(problems, fmt.Sprintf("%s: goto %q is not a step of the flow (or %q)", at, edge.Goto, FlowEnd))
+	}
+	conditioned := edge.Equals != nil || edge.Above != nil || edge.Below != nil
+	switch {
+	case edge.Field == "" && conditioned:
+		problems = append(problems, at+": equals, above and below need a field")
+	case edge.Field != "" && !conditioned:
+		problems = append(problems, at+": field needs equals, above or below")
+	case edge.Field != "" && s.Schema == nil:
+		problems = append(problems, at+": conditions read structured output, the step needs a schema")
+	}
+	switch edge.Equals.(type) {
+	case nil, int64, float64, bool, string:
+	default:
+		problems = append(problems, fmt.Sprintf("%s: equals must be a string, number or boolean", at))
+	}
+	return problems
+}
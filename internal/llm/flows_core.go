@@ -1,14 +1,15 @@
-// CLAUDE:SUMMARY Built-in flow definitions (6 legacy flows) and seeding of 14 VACF reference workflows
+// CLAUDE:SUMMARY Built-in flow definitions (6 challenge flows) and seeding of 14 VACF reference workflows plus the challenge flows as workflows
 package llm
 
 import (
+	"encoding/json"
 	"log/slog"
 
 	"github.com/hazyhaar/horostracker/internal/db"
 )
 
 // CoreFlows returns the 6 built-in thinking flow configurations per spec.
-// Kept for backward compatibility with the legacy ChallengeRunner.
+// They are seeded as challenge workflows, which ChallengeRunner runs.
 func CoreFlows() []FlowConfig {
 	return []FlowConfig{
 		flowConfrontation(),
@@ -20,8 +21,9 @@ func CoreFlows() []FlowConfig {
 	}
 }
 
-// SeedCoreWorkflows inserts the 14 VACF reference workflows into the dynamic workflow
-// system. This is idempotent — existing workflows are skipped via INSERT OR IGNORE on name.
+// SeedCoreWorkflows inserts the 14 VACF reference workflows and the 6 challenge flows
+// into the dynamic workflow system. This is idempotent — existing workflows are skipped
+// via INSERT OR IGNORE on name.
 func SeedCoreWorkflows(flowsDB *db.FlowsDB, botUserID string, logger *slog.Logger) {
 	seeds := coreWorkflowSeeds(botUserID)
 	seeded := 0
@@ -69,7 +71,7 @@ type workflowSeed struct {
 }
 
 func coreWorkflowSeeds(botUserID string) []workflowSeed {
-	return []workflowSeed{
+	return append([]workflowSeed{
 		seedDecompose(botUserID),
 		seedCritique(botUserID),
 		seedSource(botUserID),
@@ -84,7 +86,41 @@ func coreWorkflowSeeds(botUserID string) []workflowSeed {
 		seedClassificationEpistemique(botUserID),
 		seedWorkflowValidation(botUserID),
 		seedModelDiscovery(botUserID),
+	}, challengeWorkflowSeeds(botUserID)...)
+}
+
+// challengeWorkflowSeeds expresses each built-in flow as a challenge
+// workflow with one llm step per flow step, in order. Tools, the tool
+// iteration limit and the judge schema go to config_json.
+func challengeWorkflowSeeds(bot string) []workflowSeed {
+	var seeds []workflowSeed
+	for _, f := range CoreFlows() {
+		wfID := db.NewID()
+		seed := workflowSeed{wf: mkWF(wfID, f.Name, f.Description, "challenge", bot)}
+		for i, s := range f.Steps {
+			step := mkStep(wfID, i+1, s.Name, "llm", s.Provider, s.Model, s.Prompt, s.System)
+			// A step may loop through several tool calls; 30s is for one call.
+			step.TimeoutMs = 120000
+			cfg := map[string]interface{}{}
+			if len(s.Tools) > 0 {
+				cfg["tools"] = s.Tools
+			}
+			if s.MaxToolIterations > 0 {
+				cfg["max_tool_iterations"] = s.MaxToolIterations
+			}
+			switch {
+			case s.Schema == judgeSchema:
+				cfg["schema"] = "judge"
+			case s.Schema != nil:
+				cfg["schema"] = s.Schema.Doc
+			}
+			b, _ := json.Marshal(cfg)
+			step.ConfigJSON = string(b)
+			seed.steps = append(seed.steps, step)
+		}
+		seeds = append(seeds, seed)
 	}
+	return seeds
 }
 
 func mkWF(id, name, desc, wfType, ownerID string) *db.Workflow {
@@ -303,7 +339,7 @@ func seedWorkflowValidation(bot string) workflowSeed {
 		wf: mkWF(wfID, "workflow_validation", "Automated audit of submitted workflows", "workflow_validation", bot),
 		steps: []db.WorkflowStep{
 			mkStep(wfID, 1, "load_definition", "sql", "", "",
-				"SELECT w.name, w.workflow_type, w.description, ws.step_order, ws.step_name, ws.step_type, ws.provider, ws.model, ws.prompt_template FROM workflows w JOIN workflow_steps ws ON w.workflow_id = ws.workflow_id WHERE w.workflow_id = '{{.Body}}' ORDER BY ws.step_order", ""),
+				"SELECT w.name, w.workflow_type, w.description, ws.step_order, ws.step_name, ws.step_type, ws.provider, ws.model, ws.prompt_template FROM workflows w JOIN workflow_steps ws ON w.workflow_id = ws.workflow_id AND ws.version = w.version WHERE w.workflow_id = '{{.Body}}' ORDER BY ws.step_order", ""),
 			mkStep(wfID, 2, "audit", "llm", "mistral", "mistral-large-latest",
 				"Audit this workflow definition for viability:\n\n{{.PreviousResponse}}\n\nCheck for:\n1. Estimated token consumption (flag if > 500k per run)\n2. Circular prompt references\n3. Prompt injection risks in templates\n4. Consistency of step types with workflow type\n5. Overall viability score (0-100)",
 				"You are a workflow security auditor. Be thorough about injection risks and resource abuse."),
@@ -323,6 +359,23 @@ func seedModelDiscovery(bot string) workflowSeed {
 	}
 }
 
+// judgeSchema is the structured output of every judge step: an overall
+// 0-100 score and a short verdict, plus optional per-dimension scores and
+// flags that feed the moderation score (see Verdict).
+var judgeSchema = MustSchema("judge_verdict", `{
+	"type": "object",
+	"properties": {
+		"score": {"type": "number", "minimum": 0, "maximum": 100},
+		"verdict": {"type": "string", "minLength": 1},
+		"factual_score": {"type": "number", "minimum": 0, "maximum": 100},
+		"source_score": {"type": "number", "minimum": 0, "maximum": 100},
+		"argument_score": {"type": "number", "minimum": 0, "maximum": 100},
+		"flags": {"type": "array", "items": {"type": "string"}}
+	},
+	"required": ["score", "verdict"],
+	"additionalProperties": false
+}`)
+
 // Flow 1: Confrontation multi-modèle
 // A répond → B objecte avec sources → C synthétise → D tranche
 func flowConfrontation() FlowConfig {
@@ -335,8 +388,9 @@ func flowConfrontation() FlowConfig {
 				Provider: "$TARGET",
 				Model:    "$TARGET",
 				Role:     "defender",
-				System:   "You are an expert analyst. Provide a well-sourced, detailed answer.",
-				Prompt:   "Answer the following question with sources and evidence:\n\n{{.Body}}",
+				System:   "You are an expert analyst. Provide a well-sourced, detailed answer. Use the tools to read the node's proof tree, its sources and related nodes before answering.",
+				Prompt:   "Answer the following question (node {{.NodeID}}) with sources and evidence:\n\n{{.Body}}",
+				Tools:    []string{"get_tree", "get_sources", "get_5w1h", "search_nodes"},
 			},
 			{
 				Name:     "object",
@@ -360,7 +414,8 @@ func flowConfrontation() FlowConfig {
 				Model:    "mistral-large-latest",
 				Role:     "judge",
 				System:   "You are an impartial judge. Evaluate the quality of arguments and deliver a verdict.",
-				Prompt:   "Question:\n{{.Body}}\n\nSynthesis of arguments:\n{{.PreviousResponse}}\n\nDeliver a verdict: which position is better supported? Rate confidence 0-100. Identify remaining open questions.",
+				Prompt:   "Question:\n{{.Body}}\n\nSynthesis of arguments:\n{{.PreviousResponse}}\n\nDeliver a verdict: which position is better supported? Rate confidence 0-100 as the score, and state the verdict with the remaining open questions. Rate factual accuracy, source quality and argument quality (0-100) when the synthesis allows it.",
+				Schema:   judgeSchema,
 			},
 		},
 	}
@@ -395,7 +450,8 @@ func flowRedTeam() FlowConfig {
 				Model:    "gemini-2.0-flash",
 				Role:     "judge",
 				System:   "You are a deception classification expert. Categorize the techniques used.",
-				Prompt:   "Original claim: {{.Body}}\n\nArgument for the claim:\n{{.Step.build_case}}\n\nDemolition:\n{{.Step.demolish}}\n\nClassify the deception mechanisms used in the original argument. Categories: citation_hors_contexte, extrapolation_abusive, appel_autorite, donnees_truquees, confusion_terminologique, accroche_emotionnelle, grain_de_verite_exploite. Rate overall deceptiveness 0-100.",
+				Prompt:   "Original claim: {{.Body}}\n\nArgument for the claim:\n{{.Step.build_case}}\n\nDemolition:\n{{.Step.demolish}}\n\nClassify the deception mechanisms used in the original argument. Categories: citation_hors_contexte, extrapolation_abusive, appel_autorite, donnees_truquees, confusion_terminologique, accroche_emotionnelle, grain_de_verite_exploite. Rate overall deceptiveness 0-100 as the score and list the mechanisms found as flags.",
+				Schema:   judgeSchema,
 			},
 		},
 	}
@@ -422,7 +478,8 @@ func flowFidelityBenchmark() FlowConfig {
 				Model:    "gemini-2.0-flash",
 				Role:     "judge",
 				System:   "You are a fidelity evaluator. Compare a generated Resolution against the source material.",
-				Prompt:   "Source material (proof tree):\n{{.Body}}\n\nGenerated Resolution:\n{{.PreviousResponse}}\n\nEvaluate fidelity on these axes (0-100 each):\n1. Completeness — are all key arguments represented?\n2. Accuracy — are claims faithfully reproduced?\n3. Balance — are opposing views fairly weighted?\n4. Source attribution — are sources correctly cited?\n5. Nuance — are uncertainties and caveats preserved?\n\nProvide an overall fidelity score and specific issues found.",
+				Prompt:   "Source material (proof tree):\n{{.Body}}\n\nGenerated Resolution:\n{{.PreviousResponse}}\n\nEvaluate fidelity on these axes (0-100 each):\n1. Completeness — are all key arguments represented?\n2. Accuracy — are claims faithfully reproduced?\n3. Balance — are opposing views fairly weighted?\n4. Source attribution — are sources correctly cited?\n5. Nuance — are uncertainties and caveats preserved?\n\nProvide an overall fidelity score as the score, completeness as factual_score, source attribution as source_score and balance as argument_score, and list specific issues found as flags.",
+				Schema:   judgeSchema,
 			},
 		},
 	}
@@ -457,7 +514,8 @@ func flowAdversarialDetection() FlowConfig {
 				Model:    "gemini-2.0-flash",
 				Role:     "judge",
 				System:   "You are evaluating detection performance.",
-				Prompt:   "A fabricated source was created with these embedded red flags:\n{{.Step.fabricate}}\n\nThe detector's analysis was:\n{{.Step.detect}}\n\nScore the detection (0-100):\n1. Red flags found / total red flags\n2. False positives (things flagged that weren't red flags)\n3. Reasoning quality\n4. Overall resistance score",
+				Prompt:   "A fabricated source was created with these embedded red flags:\n{{.Step.fabricate}}\n\nThe detector's analysis was:\n{{.Step.detect}}\n\nScore the detection (0-100):\n1. Red flags found / total red flags\n2. False positives (things flagged that weren't red flags)\n3. Reasoning quality\n4. Overall resistance score\n\nReport the overall resistance score as the score and each missed red flag as a flag.",
+				Schema:   judgeSchema,
 			},
 		},
 	}
@@ -484,7 +542,8 @@ func flowSafetyScoring() FlowConfig {
 				Model:    "llama-3.3-70b-versatile",
 				Role:     "judge",
 				System:   "You are a safety scoring expert. Evaluate the analysis and provide structured safety scores.",
-				Prompt:   "Based on the following safety analysis:\n\n{{.PreviousResponse}}\n\nOriginal content:\n{{.Body}}\n\nProvide safety scores (0-100, where 100 is perfectly safe):\n1. Factual accuracy score\n2. Manipulation resistance score\n3. Source credibility score\n4. Overall safety score\n\nList any specific safety flags found.",
+				Prompt:   "Based on the following safety analysis:\n\n{{.PreviousResponse}}\n\nOriginal content:\n{{.Body}}\n\nProvide safety scores (0-100, where 100 is perfectly safe):\n1. Factual accuracy score\n2. Manipulation resistance score\n3. Source credibility score\n4. Overall safety score\n\nReport the overall safety score as the score, factual accuracy as factual_score and source credibility as source_score, and list any specific safety flags found as flags.",
+				Schema:   judgeSchema,
 			},
 			{
 				Name:     "mitigate",
@@ -510,8 +569,9 @@ func flowDeepDive() FlowConfig {
 				Provider: "$TARGET",
 				Model:    "$TARGET",
 				Role:     "defender",
-				System:   "You are a thorough analyst. Provide a complete answer and explicitly flag areas of uncertainty or weak evidence.",
-				Prompt:   "Analyze the following question thoroughly. At the end, list the TOP 3 weakest points in your analysis that need deeper investigation:\n\n{{.Body}}",
+				System:   "You are a thorough analyst. Provide a complete answer and explicitly flag areas of uncertainty or weak evidence. Search related nodes and check their sources with the tools where it helps.",
+				Prompt:   "Analyze the following question (node {{.NodeID}}) thoroughly. At the end, list the TOP 3 weakest points in your analysis that need deeper investigation:\n\n{{.Body}}",
+				Tools:    []string{"search_nodes", "get_sources", "get_5w1h"},
 			},
 			{
 				Name:     "deepen",
@@ -8,25 +8,40 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
 // GeminiProvider implements the Provider interface for Google's Gemini API.
 type GeminiProvider struct {
-	apiKey string
-	models []string
-	client *http.Client
+	name     string
+	baseURL  string
+	apiKey   string
+	models   []string
+	embModel string // default embedding model, "" = must be named
+	client   *http.Client
 }
 
 func NewGeminiProvider(apiKey string) *GeminiProvider {
+	p := NewGeminiEndpoint("gemini", "https://generativelanguage.googleapis.com/v1beta", apiKey,
+		[]string{"gemini-2.0-flash", "gemini-2.0-flash-lite", "gemini-1.5-pro"})
+	p.embModel = "text-embedding-004"
+	return p
+}
+
+// NewGeminiEndpoint creates a provider for a Gemini-compatible API served
+// at baseURL (the part before "/models/..."), under its own name.
+func NewGeminiEndpoint(name, baseURL, apiKey string, models []string) *GeminiProvider {
 	return &GeminiProvider{
-		apiKey: apiKey,
-		models: []string{"gemini-2.0-flash", "gemini-2.0-flash-lite", "gemini-1.5-pro"},
-		client: &http.Client{Timeout: 120 * time.Second},
+		name:    name,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		models:  models,
+		client:  &http.Client{Timeout: 120 * time.Second},
 	}
 }
 
-func (p *GeminiProvider) Name() string     { return "gemini" }
+func (p *GeminiProvider) Name() string     { return p.name }
 func (p *GeminiProvider) Models() []string  { return p.models }
 
 func (p *GeminiProvider) Complete(ctx context.Context, req Request) (*Response, error) {
@@ -34,8 +49,80 @@ func (p *GeminiProvider) Complete(ctx context.Context, req Request) (*Response,
 	if model == "" {
 		model = p.models[0]
 	}
+	body := buildGeminiRequest(req)
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+
+	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s",
+		p.baseURL, model, p.apiKey)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	httpResp, err := p.client.Do(httpReq)
+	latency := time.Since(start)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+
+	if httpResp.StatusCode != 200 {
+		return nil, httpError(p.name, model, httpResp, respBody)
+	}
+
+	var gemResp geminiResponse
+	if err := json.Unmarshal(respBody, &gemResp); err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("decoding response: %w", err)}
+	}
+
+	if len(gemResp.Candidates) == 0 {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("no candidates in response")}
+	}
+
+	var content string
+	var calls []ToolCall
+	for _, part := range gemResp.Candidates[0].Content.Parts {
+		content += part.Text
+		if fc := part.FunctionCall; fc != nil {
+			// Gemini has no call IDs; results are matched by name.
+			calls = append(calls, ToolCall{ID: fmt.Sprintf("call_%d", len(calls)), Name: fc.Name, Arguments: toolArguments(string(fc.Args))})
+		}
+	}
 
-	// Build Gemini request
+	var tokensIn, tokensOut int
+	if gemResp.UsageMetadata != nil {
+		tokensIn = gemResp.UsageMetadata.PromptTokenCount
+		tokensOut = gemResp.UsageMetadata.CandidatesTokenCount
+	}
+
+	return &Response{
+		Provider:     p.name,
+		Model:        model,
+		Content:      content,
+		TokensIn:     tokensIn,
+		TokensOut:    tokensOut,
+		FinishReason: gemResp.Candidates[0].FinishReason,
+		Latency:      latency,
+		ToolCalls:    calls,
+	}, nil
+}
+
+// buildGeminiRequest converts a provider-agnostic Request into the Gemini format.
+// Assistant turns are renamed to "model"; the system message becomes systemInstruction.
+// Tool calls become functionCall parts, and consecutive tool results are
+// grouped as functionResponse parts of one user turn.
+func buildGeminiRequest(req Request) geminiRequest {
 	var systemInstruction *geminiContent
 	contents := make([]geminiContent, 0, len(req.Messages))
 	for _, m := range req.Messages {
@@ -45,13 +132,32 @@ func (p *GeminiProvider) Complete(ctx context.Context, req Request) (*Response,
 			}
 			continue
 		}
+		if m.Role == "tool" {
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     m.Name,
+				Response: map[string]string{"content": m.Content},
+			}}
+			if n := len(contents); n > 0 && contents[n-1].Role == "user" && contents[n-1].Parts[0].FunctionResponse != nil {
+				contents[n-1].Parts = append(contents[n-1].Parts, part)
+				continue
+			}
+			contents = append(contents, geminiContent{Role: "user", Parts: []geminiPart{part}})
+			continue
+		}
 		role := m.Role
 		if role == "assistant" {
 			role = "model"
 		}
+		var parts []geminiPart
+		if m.Content != "" || len(m.ToolCalls) == 0 {
+			parts = append(parts, geminiPart{Text: m.Content})
+		}
+		for _, tc := range m.ToolCalls {
+			parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: tc.Name, Args: tc.Arguments}})
+		}
 		contents = append(contents, geminiContent{
 			Role:  role,
-			Parts: []geminiPart{{Text: m.Content}},
+			Parts: parts,
 		})
 	}
 
@@ -61,8 +167,18 @@ func (p *GeminiProvider) Complete(ctx context.Context, req Request) (*Response,
 	if systemInstruction != nil {
 		body.SystemInstruction = systemInstruction
 	}
-	if req.Temperature > 0 || req.MaxTokens > 0 || req.TopP > 0 {
+	if len(req.Tools) > 0 {
+		body.Tools = []geminiTools{{FunctionDeclarations: req.Tools}}
+	}
+	// JSON mode cannot be combined with function calling.
+	jsonMode := req.Schema != nil && len(req.Tools) == 0
+	if req.Temperature > 0 || req.MaxTokens > 0 || req.TopP > 0 || jsonMode {
 		gc := &geminiGenerationConfig{}
+		if jsonMode {
+			// JSON mode only: responseSchema takes an OpenAPI subset, so the
+			// schema itself travels in the system instruction.
+			gc.ResponseMimeType = "application/json"
+		}
 		if req.Temperature > 0 {
 			gc.Temperature = &req.Temperature
 		}
@@ -74,17 +190,38 @@ func (p *GeminiProvider) Complete(ctx context.Context, req Request) (*Response,
 		}
 		body.GenerationConfig = gc
 	}
+	return body
+}
 
+func (p *GeminiProvider) EmbeddingModel() string { return p.embModel }
+
+// Embed sends one embedContent request per input through batchEmbedContents.
+func (p *GeminiProvider) Embed(ctx context.Context, req EmbedRequest) (*EmbedResponse, error) {
+	model := req.Model
+	if model == "" {
+		model = p.embModel
+	}
+	if model == "" {
+		return nil, &ProviderError{Provider: p.name, Err: fmt.Errorf("no embedding model specified")}
+	}
+
+	body := geminiBatchEmbedRequest{Requests: make([]geminiEmbedContentRequest, len(req.Inputs))}
+	for i, text := range req.Inputs {
+		body.Requests[i] = geminiEmbedContentRequest{
+			Model:   "models/" + model,
+			Content: geminiContent{Parts: []geminiPart{{Text: text}}},
+		}
+	}
 	payload, err := json.Marshal(body)
 	if err != nil {
-		return nil, &ProviderError{Provider: "gemini", Model: model, Err: err}
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent?key=%s",
-		model, p.apiKey)
+	url := fmt.Sprintf("%s/models/%s:batchEmbedContents?key=%s",
+		p.baseURL, model, p.apiKey)
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
 	if err != nil {
-		return nil, &ProviderError{Provider: "gemini", Model: model, Err: err}
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 
@@ -92,52 +229,104 @@ func (p *GeminiProvider) Complete(ctx context.Context, req Request) (*Response,
 	httpResp, err := p.client.Do(httpReq)
 	latency := time.Since(start)
 	if err != nil {
-		return nil, &ProviderError{Provider: "gemini", Model: model, Err: err}
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 	defer httpResp.Body.Close()
 
 	respBody, err := io.ReadAll(httpResp.Body)
 	if err != nil {
-		return nil, &ProviderError{Provider: "gemini", Model: model, Err: err}
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	if httpResp.StatusCode != 200 {
+		return nil, httpError(p.name, model, httpResp, respBody)
 	}
 
-	if httpResp.StatusCode == 429 {
-		return nil, &ProviderError{Provider: "gemini", Model: model, Err: ErrRateLimited}
+	var embResp geminiBatchEmbedResponse
+	if err := json.Unmarshal(respBody, &embResp); err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("decoding response: %w", err)}
 	}
-	if httpResp.StatusCode != 200 {
-		return nil, &ProviderError{Provider: "gemini", Model: model,
-			Err: fmt.Errorf("HTTP %d: %s", httpResp.StatusCode, truncate(string(respBody), 200))}
+	vectors := make([][]float32, len(embResp.Embeddings))
+	for i, e := range embResp.Embeddings {
+		vectors[i] = e.Values
 	}
+	return &EmbedResponse{
+		Provider: p.name,
+		Model:    model,
+		Vectors:  vectors,
+		Latency:  latency,
+	}, nil
+}
 
-	var gemResp geminiResponse
-	if err := json.Unmarshal(respBody, &gemResp); err != nil {
-		return nil, &ProviderError{Provider: "gemini", Model: model, Err: fmt.Errorf("decoding response: %w", err)}
+// Stream calls streamGenerateContent with alt=sse. Each event carries a
+// partial candidate; usageMetadata on the last event holds the totals.
+func (p *GeminiProvider) Stream(ctx context.Context, req Request, fn StreamFunc) (*Response, error) {
+	model := req.Model
+	if model == "" {
+		model = p.models[0]
 	}
+	body := buildGeminiRequest(req)
 
-	if len(gemResp.Candidates) == 0 {
-		return nil, &ProviderError{Provider: "gemini", Model: model, Err: fmt.Errorf("no candidates in response")}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 
-	var content string
-	for _, part := range gemResp.Candidates[0].Content.Parts {
-		content += part.Text
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse&key=%s",
+		p.baseURL, model, p.apiKey)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
 
-	var tokensIn, tokensOut int
-	if gemResp.UsageMetadata != nil {
-		tokensIn = gemResp.UsageMetadata.PromptTokenCount
-		tokensOut = gemResp.UsageMetadata.CandidatesTokenCount
+	start := time.Now()
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
+	defer httpResp.Body.Close()
 
-	return &Response{
-		Provider:     "gemini",
-		Model:        model,
-		Content:      content,
-		TokensIn:     tokensIn,
-		TokensOut:    tokensOut,
-		FinishReason: gemResp.Candidates[0].FinishReason,
-		Latency:      latency,
-	}, nil
+	if httpResp.StatusCode != 200 {
+		respBody, _ := io.ReadAll(httpResp.Body)
+		return nil, httpError(p.name, model, httpResp, respBody)
+	}
+
+	resp := &Response{Provider: p.name, Model: model}
+	var content strings.Builder
+	err = readSSE(httpResp.Body, func(_, data string) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("decoding stream chunk: %w", err)
+		}
+		if chunk.UsageMetadata != nil {
+			resp.TokensIn = chunk.UsageMetadata.PromptTokenCount
+			resp.TokensOut = chunk.UsageMetadata.CandidatesTokenCount
+		}
+		if len(chunk.Candidates) == 0 {
+			return nil
+		}
+		if chunk.Candidates[0].FinishReason != "" {
+			resp.FinishReason = chunk.Candidates[0].FinishReason
+		}
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			if part.Text == "" {
+				continue
+			}
+			content.WriteString(part.Text)
+			if err := fn(part.Text); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+
+	resp.Content = content.String()
+	resp.Latency = time.Since(start)
+	return resp, nil
 }
 
 // Gemini API types
@@ -145,6 +334,13 @@ type geminiRequest struct {
 	Contents          []geminiContent         `json:"contents"`
 	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
 	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []geminiTools           `json:"tools,omitempty"`
+}
+
+// geminiTools holds function declarations; a Tool's name, description and
+// parameters fields match the declaration format.
+type geminiTools struct {
+	FunctionDeclarations []Tool `json:"functionDeclarations"`
 }
 
 type geminiContent struct {
@@ -153,13 +349,41 @@ type geminiContent struct {
 }
 
 type geminiPart struct {
-	Text string `json:"text"`
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string            `json:"name"`
+	Response map[string]string `json:"response"`
 }
 
 type geminiGenerationConfig struct {
-	Temperature    *float64 `json:"temperature,omitempty"`
-	MaxOutputTokens *int    `json:"maxOutputTokens,omitempty"`
-	TopP           *float64 `json:"topP,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	MaxOutputTokens  *int     `json:"maxOutputTokens,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+}
+
+type geminiBatchEmbedRequest struct {
+	Requests []geminiEmbedContentRequest `json:"requests"`
+}
+
+type geminiEmbedContentRequest struct {
+	Model   string        `json:"model"` // "models/<id>"
+	Content geminiContent `json:"content"`
+}
+
+type geminiBatchEmbedResponse struct {
+	Embeddings []struct {
+		Values []float32 `json:"values"`
+	} `json:"embeddings"`
 }
 
 type geminiResponse struct {
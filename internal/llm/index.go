@@ -0,0 +1,267 @@
+// CLAUDE:SUMMARY Vector index — embeds public nodes and sources into the nodes.db vector store (batch backfill, incremental queue) and answers nearest-neighbour queries
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// maxEmbedChars caps the text sent per input; embedding models take a few
+// thousand tokens at most and the head of a node carries its meaning.
+const maxEmbedChars = 8000
+
+// Indexer keeps the vector store in step with the tree. Nodes and sources
+// are queued as they are created (see Enqueue) and a periodic backfill
+// catches everything else (bot answers, MCP writes, content that predates
+// the embedder). Vectors are keyed by the embedder that produced them, so
+// switching model starts a fresh index instead of mixing vector spaces.
+type Indexer struct {
+	client   *Client
+	database *db.DB
+	model    string // embedding route, "provider/model" or "" (see Client.EmbedRoute)
+	batch    int
+	logger   *slog.Logger
+	queue    chan ownerRef
+
+	mu     sync.Mutex
+	warned string // last routing error logged by Run, to log it once
+}
+
+type ownerRef struct{ ownerType, ownerID string }
+
+// BackfillResult counts the vectors written by one backfill.
+type BackfillResult struct {
+	Embedder string `json:"embedder"`
+	Nodes    int    `json:"nodes"`
+	Sources  int    `json:"sources"`
+}
+
+// NearestQuery asks for the neighbours of Text, or of the stored vector of
+// OwnerID (embedded on the fly if missing) when Text is empty.
+type NearestQuery struct {
+	Text      string
+	OwnerType string // node (default) or source: what is searched
+	OwnerID   string // node or source of the same type
+	K         int
+	MinScore  float64
+	RootsOnly bool
+}
+
+// NewIndexer creates an indexer embedding with model (see EmbedRoute) in
+// batches of batch inputs (0 = 32).
+func NewIndexer(client *Client, database *db.DB, model string, batch int, logger *slog.Logger) *Indexer {
+	if batch <= 0 {
+		batch = 32
+	}
+	return &Indexer{
+		client:   client,
+		database: database,
+		model:    model,
+		batch:    batch,
+		logger:   logger,
+		queue:    make(chan ownerRef, 256),
+	}
+}
+
+// Embedder returns the embedder key ("provider/model") vectors for model
+// are stored under; model "" uses the indexer's configured route.
+func (ix *Indexer) Embedder(model string) (string, error) {
+	if model == "" {
+		model = ix.model
+	}
+	provider, name, err := ix.client.EmbedRoute(model)
+	if err != nil {
+		return "", err
+	}
+	return provider + "/" + name, nil
+}
+
+// Enqueue schedules a node or source for indexing. It never blocks: when
+// the queue is full the item is left to the next backfill.
+func (ix *Indexer) Enqueue(ownerType, ownerID string) {
+	select {
+	case ix.queue <- ownerRef{ownerType, ownerID}:
+	default:
+	}
+}
+
+// Run indexes queued items as they arrive and backfills every interval
+// until ctx is done. Without an embedding provider it idles.
+func (ix *Indexer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	ix.sweep(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ref := <-ix.queue:
+			item, err := ix.database.EmbeddingText(ref.ownerType, ref.ownerID)
+			if err != nil || item == nil {
+				continue
+			}
+			if _, err := ix.Index(ctx, "", []db.EmbeddingItem{*item}); err != nil {
+				ix.warn("indexing "+ref.ownerType, err)
+			}
+		case <-ticker.C:
+			ix.sweep(ctx)
+		}
+	}
+}
+
+func (ix *Indexer) sweep(ctx context.Context) {
+	res, err := ix.Backfill(ctx, "", 0)
+	if err != nil {
+		ix.warn("embedding backfill", err)
+		return
+	}
+	if res.Nodes+res.Sources > 0 {
+		ix.logger.Info("embedding backfill", "embedder", res.Embedder, "nodes", res.Nodes, "sources", res.Sources)
+	}
+}
+
+// warn logs err once until a different error comes up.
+func (ix *Indexer) warn(msg string, err error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.warned == err.Error() {
+		return
+	}
+	ix.warned = err.Error()
+	ix.logger.Warn(msg, "error", err)
+}
+
+// Backfill embeds public nodes and sources that have no vector for model
+// yet, up to limit items of each kind (0 = 10 batches).
+func (ix *Indexer) Backfill(ctx context.Context, model string, limit int) (*BackfillResult, error) {
+	embedder, err := ix.Embedder(model)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = 10 * ix.batch
+	}
+	res := &BackfillResult{Embedder: embedder}
+	for _, ownerType := range []string{"node", "source"} {
+		items, err := ix.database.PendingEmbeddings(ownerType, embedder, limit)
+		if err != nil {
+			return res, err
+		}
+		n, err := ix.Index(ctx, model, items)
+		if ownerType == "node" {
+			res.Nodes = n
+		} else {
+			res.Sources = n
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// Index embeds items in batches and stores their vectors. Items whose
+// stored vector is already for the same content are skipped. It returns
+// the number of vectors written.
+func (ix *Indexer) Index(ctx context.Context, model string, items []db.EmbeddingItem) (int, error) {
+	embedder, err := ix.Embedder(model)
+	if err != nil {
+		return 0, err
+	}
+	var todo []db.EmbeddingItem
+	for _, item := range items {
+		if stored, _ := ix.database.GetEmbedding(item.OwnerType, item.OwnerID, embedder); stored != nil && stored.ContentHash == item.ContentHash {
+			continue
+		}
+		todo = append(todo, item)
+	}
+
+	written := 0
+	for start := 0; start < len(todo); start += ix.batch {
+		chunk := todo[start:min(start+ix.batch, len(todo))]
+		inputs := make([]string, len(chunk))
+		for i, item := range chunk {
+			inputs[i] = truncate(item.Text, maxEmbedChars)
+		}
+		resp, err := ix.client.Embed(ctx, EmbedRequest{Model: embedder, Inputs: inputs})
+		if err != nil {
+			return written, err
+		}
+		for i, item := range chunk {
+			if err := ix.database.PutEmbedding(db.Embedding{
+				OwnerType:   item.OwnerType,
+				OwnerID:     item.OwnerID,
+				Embedder:    embedder,
+				Vector:      resp.Vectors[i],
+				ContentHash: item.ContentHash,
+			}); err != nil {
+				return written, err
+			}
+			written++
+		}
+	}
+	return written, nil
+}
+
+// Nearest returns the embedder used and the nearest public nodes or
+// sources to the query, best first.
+func (ix *Indexer) Nearest(ctx context.Context, model string, q NearestQuery) (string, []db.Neighbor, error) {
+	embedder, err := ix.Embedder(model)
+	if err != nil {
+		return "", nil, err
+	}
+	if q.OwnerType == "" {
+		q.OwnerType = "node"
+	}
+
+	var vector []float32
+	var exclude []string
+	switch {
+	case q.Text != "":
+		resp, err := ix.client.Embed(ctx, EmbedRequest{Model: embedder, Inputs: []string{truncate(q.Text, maxEmbedChars)}})
+		if err != nil {
+			return embedder, nil, err
+		}
+		vector = resp.Vectors[0]
+	case q.OwnerID != "":
+		stored, err := ix.database.GetEmbedding(q.OwnerType, q.OwnerID, embedder)
+		if err != nil {
+			return embedder, nil, err
+		}
+		if stored == nil {
+			item, err := ix.database.EmbeddingText(q.OwnerType, q.OwnerID)
+			if err != nil {
+				return embedder, nil, err
+			}
+			if item == nil {
+				return embedder, nil, fmt.Errorf("%s %q not found", q.OwnerType, q.OwnerID)
+			}
+			if _, err := ix.Index(ctx, embedder, []db.EmbeddingItem{*item}); err != nil {
+				return embedder, nil, err
+			}
+			if stored, err = ix.database.GetEmbedding(q.OwnerType, q.OwnerID, embedder); err != nil || stored == nil {
+				return embedder, nil, fmt.Errorf("storing embedding of %s %q: %v", q.OwnerType, q.OwnerID, err)
+			}
+		}
+		vector = stored.Vector
+		exclude = []string{q.OwnerID}
+	default:
+		return embedder, nil, fmt.Errorf("text or owner id is required")
+	}
+
+	neighbors, err := ix.database.NearestEmbeddings(db.NeighborQuery{
+		OwnerType: q.OwnerType,
+		Embedder:  embedder,
+		Vector:    vector,
+		K:         q.K,
+		MinScore:  q.MinScore,
+		RootsOnly: q.RootsOnly,
+		Exclude:   exclude,
+	})
+	return embedder, neighbors, err
+}
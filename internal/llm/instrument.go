@@ -0,0 +1,113 @@
+// CLAUDE:SUMMARY Instrumented provider decorator — records latency, tokens, cost and outcome of every provider call (metrics.db llm_calls)
+package llm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CallRecorder receives one record per provider call. *db.MetricsDB
+// implements it.
+type CallRecorder interface {
+	RecordLLMCall(provider, model string, tokensIn, tokensOut, latencyMs int, costUSD float64, success bool, errMsg string)
+}
+
+// InstrumentedProvider decorates a Provider and records every call,
+// failures included, to a CallRecorder.
+type InstrumentedProvider struct {
+	Provider
+	rec   CallRecorder
+	price func(provider, model string, tokensIn, tokensOut int) float64
+}
+
+// instrumentedStreamProvider keeps the streaming capability of the
+// decorated provider visible to streamProvider.
+type instrumentedStreamProvider struct {
+	*InstrumentedProvider
+}
+
+// Instrument wraps p so that its calls are recorded to rec. price may be
+// nil (calls are recorded with a zero cost). The returned Provider
+// implements StreamProvider if p does.
+func Instrument(p Provider, rec CallRecorder, price func(provider, model string, tokensIn, tokensOut int) float64) Provider {
+	ip := &InstrumentedProvider{Provider: p, rec: rec, price: price}
+	if _, ok := p.(StreamProvider); ok {
+		return instrumentedStreamProvider{ip}
+	}
+	return ip
+}
+
+// Unwrap returns the decorated provider.
+func (p *InstrumentedProvider) Unwrap() Provider { return p.Provider }
+
+func (p *InstrumentedProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	start := time.Now()
+	resp, err := p.Provider.Complete(ctx, req)
+	p.record(req, resp, err, time.Since(start))
+	return resp, err
+}
+
+func (p instrumentedStreamProvider) Stream(ctx context.Context, req Request, fn StreamFunc) (*Response, error) {
+	start := time.Now()
+	resp, err := p.Provider.(StreamProvider).Stream(ctx, req, fn)
+	p.record(req, resp, err, time.Since(start))
+	return resp, err
+}
+
+func (p *InstrumentedProvider) record(req Request, resp *Response, err error, latency time.Duration) {
+	name := p.Name()
+	model := req.Model
+	var in, out int
+	var cost float64
+	if resp != nil {
+		if resp.Model != "" {
+			model = resp.Model
+		}
+		in, out = resp.TokensIn, resp.TokensOut
+		if p.price != nil {
+			cost = p.price(name, model, in, out)
+		}
+	}
+	if model == "" {
+		if models := p.Models(); len(models) > 0 {
+			model = models[0]
+		}
+	}
+	p.rec.RecordLLMCall(name, model, in, out, int(latency.Milliseconds()), cost, err == nil, metricsError(err))
+}
+
+// metricsError reduces an error to its class and HTTP status. Raw error
+// text is not stored: metrics.db is downloadable and transport errors can
+// embed request URLs carrying API keys.
+func metricsError(err error) string {
+	if err == nil {
+		return ""
+	}
+	class := Classify(err)
+	if pe, ok := asProviderError(err); ok && pe.StatusCode != 0 {
+		return fmt.Sprintf("%s (HTTP %d)", class, pe.StatusCode)
+	}
+	return string(class)
+}
+
+// Instrument wraps every configured provider with an InstrumentedProvider
+// recording to rec, and providers registered later as well. Costs come from
+// the client's ledger, if any. Call it once, before the client is used.
+func (c *Client) Instrument(rec CallRecorder) {
+	price := func(provider, model string, tokensIn, tokensOut int) float64 {
+		if c.ledger == nil {
+			return 0
+		}
+		cost, _ := c.ledger.Cost(provider, model, tokensIn, tokensOut)
+		return cost
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, p := range c.providers {
+		c.providers[name] = Instrument(p, rec, price)
+	}
+	c.decorators = append(c.decorators, func(p Provider) Provider {
+		return Instrument(p, rec, price)
+	})
+}
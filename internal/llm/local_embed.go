@@ -0,0 +1,176 @@
+// CLAUDE:SUMMARY Local embedder — dependency-free hashed character n-gram TF-IDF vectors fitted on the public corpus, serving embeddings when no provider can
+package llm
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// LocalProvider is the provider name of the built-in embedder; vectors it
+// produces are stored under "local/<model>".
+const LocalProvider = "local"
+
+const (
+	localDims       = 512
+	localMinFitDocs = 20    // below this the corpus says little: plain term frequencies
+	localFitCorpus  = 20000 // texts of each kind sampled when fitting
+)
+
+// LocalEmbedder turns text into a fixed-size vector without any model or
+// network call: the character 3- to 5-grams of each word (and the word
+// itself) are hashed into localDims buckets, weighted by log term
+// frequency times the bucket's inverse document frequency over the
+// corpus. The same text and IDF table always give the same vector; the
+// model name carries a digest of the table, so a refit is a new embedder
+// and its vectors never meet those of the previous one.
+type LocalEmbedder struct {
+	model string
+	idf   []float32 // per bucket; nil = unweighted
+}
+
+// NewLocalEmbedder returns an embedder using idf (from FitIDF), or plain
+// term frequencies when idf is nil.
+func NewLocalEmbedder(idf []float32) *LocalEmbedder {
+	if idf == nil {
+		return &LocalEmbedder{model: fmt.Sprintf("ngram-tf-%d", localDims)}
+	}
+	digest := db.ContentHash(string(db.EncodeVector(idf)))[:8]
+	return &LocalEmbedder{model: fmt.Sprintf("ngram-tfidf-%d-%s", localDims, digest), idf: idf}
+}
+
+// LoadLocalEmbedder returns the local embedder of the instance. The IDF
+// table is refitted on the public corpus when there is none yet or the
+// corpus has grown by half since the last fit; vectors of previous local
+// embedders are then dropped so the indexer rebuilds them.
+func LoadLocalEmbedder(database *db.DB, logger *slog.Logger) (*LocalEmbedder, error) {
+	fit, err := database.LatestLocalEmbedderFit()
+	if err != nil {
+		return nil, fmt.Errorf("loading local embedder fit: %w", err)
+	}
+	if fit != nil && len(fit.IDF) != localDims {
+		fit = nil
+	}
+	texts, err := database.EmbeddableTexts(localFitCorpus)
+	if err != nil {
+		return nil, fmt.Errorf("reading corpus: %w", err)
+	}
+
+	var e *LocalEmbedder
+	switch {
+	case len(texts) >= localMinFitDocs && (fit == nil || len(texts) >= fit.Docs*3/2):
+		e = NewLocalEmbedder(FitIDF(texts))
+		if err := database.PutLocalEmbedderFit(db.LocalEmbedderFit{Model: e.model, Docs: len(texts), IDF: e.idf}); err != nil {
+			return nil, fmt.Errorf("storing local embedder fit: %w", err)
+		}
+		logger.Info("local embedder fitted", "model", e.model, "docs", len(texts))
+	case fit != nil:
+		e = NewLocalEmbedder(fit.IDF)
+	default:
+		e = NewLocalEmbedder(nil)
+	}
+
+	removed, err := database.DeleteEmbeddingsExcept(LocalProvider+"/", LocalProvider+"/"+e.model)
+	if err != nil {
+		return nil, fmt.Errorf("dropping stale local vectors: %w", err)
+	}
+	if removed > 0 {
+		logger.Info("dropped vectors of previous local embedder", "count", removed)
+	}
+	return e, nil
+}
+
+// FitIDF computes the smoothed inverse document frequency of each bucket
+// over texts.
+func FitIDF(texts []string) []float32 {
+	df := make([]int, localDims)
+	for _, text := range texts {
+		for b, n := range localCounts(text) {
+			if n > 0 {
+				df[b]++
+			}
+		}
+	}
+	idf := make([]float32, localDims)
+	for b := range idf {
+		idf[b] = float32(math.Log(float64(1+len(texts))/float64(1+df[b])) + 1)
+	}
+	return idf
+}
+
+// EmbeddingModel implements Embedder.
+func (e *LocalEmbedder) EmbeddingModel() string {
+	return e.model
+}
+
+// Embed implements Embedder. req.Model must be "" or the current model.
+func (e *LocalEmbedder) Embed(ctx context.Context, req EmbedRequest) (*EmbedResponse, error) {
+	if req.Model != "" && req.Model != e.model {
+		return nil, &ProviderError{Provider: LocalProvider, Model: req.Model,
+			Err: fmt.Errorf("unknown local embedding model (current: %s)", e.model)}
+	}
+	start := time.Now()
+	resp := &EmbedResponse{Provider: LocalProvider, Model: e.model, Vectors: make([][]float32, len(req.Inputs))}
+	for i, text := range req.Inputs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp.Vectors[i] = e.Vector(text)
+	}
+	resp.Latency = time.Since(start)
+	return resp, nil
+}
+
+// Vector returns the unit-length embedding of text (all zeros for text
+// without letters or digits).
+func (e *LocalEmbedder) Vector(text string) []float32 {
+	v := localCounts(text)
+	for b, n := range v {
+		if n == 0 {
+			continue
+		}
+		w := 1 + float32(math.Log(float64(n)))
+		if e.idf != nil {
+			w *= e.idf[b]
+		}
+		v[b] = w
+	}
+	Normalize(v)
+	return v
+}
+
+// localCounts returns the number of features of text falling in each
+// bucket. Words are lower-cased and padded with boundary marks, so "<cat>"
+// (the whole word) and "<ca" differ from the "cat" inside "concatenate".
+func localCounts(text string) []float32 {
+	counts := make([]float32, localDims)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	h := fnv.New32a()
+	add := func(feature string) {
+		h.Reset()
+		h.Write([]byte(feature))
+		counts[h.Sum32()%localDims]++
+	}
+	for _, w := range words {
+		runes := []rune("<" + w + ">")
+		add(string(runes))
+		for n := 3; n <= 5; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				if n == len(runes) {
+					break // the whole word, already added
+				}
+				add(string(runes[i : i+n]))
+			}
+		}
+	}
+	return counts
+}
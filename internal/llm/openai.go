@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -20,6 +21,8 @@ type OpenAIProvider struct {
 	apiKey   string
 	models   []string
 	defModel string
+	jsonMode string
+	embModel string
 	client   *http.Client
 }
 
@@ -30,6 +33,12 @@ type OpenAIConfig struct {
 	APIKey       string
 	Models       []string // available model IDs
 	DefaultModel string   // fallback model if none specified
+	// JSONMode is the native structured-output mode used for requests with
+	// a Schema: "json_schema", "json_object", or "" (prompt instructions only).
+	JSONMode string
+	// EmbeddingModel is the default model for Embed; "" means embedding
+	// requests must name a model.
+	EmbeddingModel string
 }
 
 func NewOpenAIProvider(cfg OpenAIConfig) *OpenAIProvider {
@@ -43,6 +52,8 @@ func NewOpenAIProvider(cfg OpenAIConfig) *OpenAIProvider {
 		apiKey:   cfg.APIKey,
 		models:   cfg.Models,
 		defModel: defModel,
+		jsonMode: cfg.JSONMode,
+		embModel: cfg.EmbeddingModel,
 		client:   &http.Client{Timeout: 120 * time.Second},
 	}
 }
@@ -51,21 +62,95 @@ func (p *OpenAIProvider) Name() string     { return p.name }
 func (p *OpenAIProvider) Models() []string  { return p.models }
 
 func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	body, err := p.buildRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	model := body.Model
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	start := time.Now()
+	httpResp, err := p.client.Do(httpReq)
+	latency := time.Since(start)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+
+	if httpResp.StatusCode != 200 {
+		return nil, httpError(p.name, model, httpResp, respBody)
+	}
+
+	var oaiResp openAIResponse
+	if err := json.Unmarshal(respBody, &oaiResp); err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("decoding response: %w", err)}
+	}
+
+	if len(oaiResp.Choices) == 0 {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("no choices in response")}
+	}
+
+	choice := oaiResp.Choices[0]
+	var calls []ToolCall
+	for _, tc := range choice.Message.ToolCalls {
+		calls = append(calls, ToolCall{ID: tc.ID, Name: tc.Function.Name, Arguments: toolArguments(tc.Function.Arguments)})
+	}
+	return &Response{
+		Provider:     p.name,
+		Model:        oaiResp.Model,
+		Content:      choice.Message.Content,
+		TokensIn:     oaiResp.Usage.PromptTokens,
+		TokensOut:    oaiResp.Usage.CompletionTokens,
+		FinishReason: choice.FinishReason,
+		Latency:      latency,
+		ToolCalls:    calls,
+	}, nil
+}
+
+// buildRequest converts a provider-agnostic Request into the OpenAI wire format.
+func (p *OpenAIProvider) buildRequest(req Request) (openAIRequest, error) {
 	model := req.Model
 	if model == "" {
 		model = p.defModel
 	}
 	if model == "" {
-		return nil, &ProviderError{Provider: p.name, Err: fmt.Errorf("no model specified")}
+		return openAIRequest{}, &ProviderError{Provider: p.name, Err: fmt.Errorf("no model specified")}
 	}
 
-	// Build OpenAI-format request body
 	body := openAIRequest{
 		Model:    model,
 		Messages: make([]openAIMessage, len(req.Messages)),
 	}
 	for i, m := range req.Messages {
-		body.Messages[i] = openAIMessage(m)
+		msg := openAIMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, tc := range m.ToolCalls {
+			call := openAIToolCall{ID: tc.ID, Type: "function"}
+			call.Function.Name = tc.Name
+			call.Function.Arguments = string(tc.Arguments)
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		body.Messages[i] = msg
+	}
+	for _, t := range req.Tools {
+		body.Tools = append(body.Tools, openAITool{Type: "function", Function: t})
 	}
 	if req.Temperature > 0 {
 		body.Temperature = &req.Temperature
@@ -79,6 +164,30 @@ func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response,
 	if req.Seed != nil {
 		body.Seed = req.Seed
 	}
+	if req.Schema != nil {
+		switch p.jsonMode {
+		case "json_schema":
+			body.ResponseFormat = &openAIResponseFormat{Type: "json_schema", JSONSchema: &openAIJSONSchema{
+				Name:   req.Schema.Name,
+				Schema: req.Schema.Doc,
+			}}
+		case "json_object":
+			body.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+		}
+	}
+	return body, nil
+}
+
+// Stream sends a chat completion with stream=true and forwards each content
+// delta to fn. Usage comes from the final chunk (stream_options.include_usage).
+func (p *OpenAIProvider) Stream(ctx context.Context, req Request, fn StreamFunc) (*Response, error) {
+	body, err := p.buildRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	model := body.Model
+	body.Stream = true
+	body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
 
 	payload, err := json.Marshal(body)
 	if err != nil {
@@ -90,49 +199,125 @@ func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (*Response,
 		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
 	if p.apiKey != "" {
 		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
 	}
 
 	start := time.Now()
 	httpResp, err := p.client.Do(httpReq)
-	latency := time.Since(start)
 	if err != nil {
 		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 	defer httpResp.Body.Close()
 
-	respBody, err := io.ReadAll(httpResp.Body)
+	if httpResp.StatusCode != 200 {
+		respBody, _ := io.ReadAll(httpResp.Body)
+		return nil, httpError(p.name, model, httpResp, respBody)
+	}
+
+	resp := &Response{Provider: p.name, Model: model}
+	var content strings.Builder
+	err = readSSE(httpResp.Body, func(_, data string) error {
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("decoding stream chunk: %w", err)
+		}
+		if chunk.Model != "" {
+			resp.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			resp.TokensIn = chunk.Usage.PromptTokens
+			resp.TokensOut = chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+		choice := chunk.Choices[0]
+		if choice.FinishReason != nil {
+			resp.FinishReason = *choice.FinishReason
+		}
+		if choice.Delta.Content == "" {
+			return nil
+		}
+		content.WriteString(choice.Delta.Content)
+		return fn(choice.Delta.Content)
+	})
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+
+	resp.Content = content.String()
+	resp.Latency = time.Since(start)
+	return resp, nil
+}
+
+func (p *OpenAIProvider) EmbeddingModel() string { return p.embModel }
+
+// Embed calls the /embeddings endpoint with every input in one batch.
+func (p *OpenAIProvider) Embed(ctx context.Context, req EmbedRequest) (*EmbedResponse, error) {
+	model := req.Model
+	if model == "" {
+		model = p.embModel
+	}
+	if model == "" {
+		return nil, &ProviderError{Provider: p.name, Err: fmt.Errorf("no embedding model specified")}
+	}
+
+	payload, err := json.Marshal(openAIEmbeddingRequest{Model: model, Input: req.Inputs})
 	if err != nil {
 		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 
-	if httpResp.StatusCode == 429 {
-		return nil, &ProviderError{Provider: p.name, Model: model, Err: ErrRateLimited}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	start := time.Now()
+	httpResp, err := p.client.Do(httpReq)
+	latency := time.Since(start)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, &ProviderError{Provider: p.name, Model: model, Err: err}
 	}
 	if httpResp.StatusCode != 200 {
-		return nil, &ProviderError{Provider: p.name, Model: model,
-			Err: fmt.Errorf("HTTP %d: %s", httpResp.StatusCode, truncate(string(respBody), 200))}
+		return nil, httpError(p.name, model, httpResp, respBody)
 	}
 
-	var oaiResp openAIResponse
-	if err := json.Unmarshal(respBody, &oaiResp); err != nil {
+	var embResp openAIEmbeddingResponse
+	if err := json.Unmarshal(respBody, &embResp); err != nil {
 		return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("decoding response: %w", err)}
 	}
 
-	if len(oaiResp.Choices) == 0 {
-		return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("no choices in response")}
+	// Entries carry their input index; the order they arrive in is not guaranteed.
+	vectors := make([][]float32, len(req.Inputs))
+	for _, d := range embResp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("embedding index %d out of range", d.Index)}
+		}
+		vectors[d.Index] = d.Embedding
 	}
-
-	choice := oaiResp.Choices[0]
-	return &Response{
-		Provider:     p.name,
-		Model:        oaiResp.Model,
-		Content:      choice.Message.Content,
-		TokensIn:     oaiResp.Usage.PromptTokens,
-		TokensOut:    oaiResp.Usage.CompletionTokens,
-		FinishReason: choice.FinishReason,
-		Latency:      latency,
+	for i, v := range vectors {
+		if len(v) == 0 {
+			return nil, &ProviderError{Provider: p.name, Model: model, Err: fmt.Errorf("no embedding for input %d", i)}
+		}
+	}
+	return &EmbedResponse{
+		Provider: p.name,
+		Model:    model,
+		Vectors:  vectors,
+		TokensIn: embResp.Usage.PromptTokens,
+		Latency:  latency,
 	}, nil
 }
 
@@ -144,11 +329,49 @@ type openAIRequest struct {
 	MaxTokens   *int            `json:"max_tokens,omitempty"`
 	TopP        *float64        `json:"top_p,omitempty"`
 	Seed        *int            `json:"seed,omitempty"`
+
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+	Tools          []openAITool          `json:"tools,omitempty"`
+
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type       string            `json:"type"` // "json_object" or "json_schema"
+	JSONSchema *openAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type openAIJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type openAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// openAITool wraps a Tool; its name/description/parameters fields match
+// the "function" object of the wire format.
+type openAITool struct {
+	Type     string `json:"type"` // always "function"
+	Function Tool   `json:"function"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON-encoded object
+	} `json:"function"`
 }
 
 type openAIResponse struct {
@@ -163,6 +386,33 @@ type openAIResponse struct {
 	} `json:"usage"`
 }
 
+type openAIEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+	} `json:"usage"`
+}
+
+type openAIStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta        openAIMessage `json:"delta"`
+		FinishReason *string       `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
 func truncate(s string, n int) string {
 	if len(s) <= n {
 		return s
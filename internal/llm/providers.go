@@ -1,52 +1,129 @@
-// CLAUDE:SUMMARY Factory that builds a multi-provider LLM Client from config (activates only providers with API keys)
+// CLAUDE:SUMMARY Factory that builds a multi-provider LLM Client from config (activates only providers with API keys, or cassette stand-ins in record/replay/scripted mode)
 package llm
 
-import "github.com/hazyhaar/horostracker/internal/config"
+import (
+	"fmt"
+
+	"github.com/hazyhaar/horostracker/internal/config"
+)
 
 // NewFromConfig creates a multi-provider LLM client from the application config.
-// Only providers with configured API keys are activated.
-func NewFromConfig(cfg config.LLMConfig) *Client {
+// In live mode only providers with configured API keys are activated; in
+// record mode they are wrapped to append to the cassette; in replay and
+// scripted mode every built-in provider is stood in for offline. Providers
+// registered later (see Register) get the same treatment.
+// [[llm.limits]] entries are installed as quotas on the resulting client.
+func NewFromConfig(cfg config.LLMConfig) (*Client, error) {
 	var providers []Provider
+	var cassette *Cassette
+	var offline func(Provider) Provider // stands in for (or wraps) every provider
+
+	switch cfg.Mode {
+	case "", ModeLive:
+		providers = builtinProviders(cfg, false)
+
+	case ModeRecord, ModeReplay:
+		if cfg.Cassette == "" {
+			return nil, fmt.Errorf("[llm] mode %q requires a cassette path", cfg.Mode)
+		}
+		var err error
+		if cassette, err = OpenCassette(cfg.Cassette); err != nil {
+			return nil, err
+		}
+		if cfg.Mode == ModeRecord {
+			offline = func(p Provider) Provider { return NewRecordingProvider(p, cassette) }
+			providers = builtinProviders(cfg, false)
+		} else {
+			offline = func(p Provider) Provider { return NewReplayProvider(p.Name(), p.Models(), cassette) }
+			providers = builtinProviders(cfg, true)
+		}
+
+	case ModeScripted:
+		script, err := LoadScript(cfg.Script)
+		if err != nil {
+			return nil, err
+		}
+		offline = func(p Provider) Provider { return NewScriptedProvider(p.Name(), p.Models(), script) }
+		providers = builtinProviders(cfg, true)
 
-	if cfg.GeminiAPIKey != "" {
+	default:
+		return nil, fmt.Errorf("unknown [llm] mode %q (live, record, replay or scripted)", cfg.Mode)
+	}
+
+	if offline != nil {
+		for i, p := range providers {
+			providers[i] = offline(p)
+		}
+	}
+	client := New(providers)
+	client.mode = cfg.Mode
+	if client.mode == "" {
+		client.mode = ModeLive
+	}
+	client.cassette = cassette
+	if offline != nil {
+		client.decorators = append(client.decorators, offline)
+	}
+	for _, l := range cfg.Limits {
+		client.SetLimit(l.Provider, l.Model, Limit{
+			RequestsPerMinute: l.RequestsPerMinute,
+			TokensPerMinute:   l.TokensPerMinute,
+			MaxInFlight:       l.MaxInFlight,
+		})
+	}
+	return client, nil
+}
+
+// builtinProviders builds the providers that have an API key configured, or
+// all of them when all is true (their names and model lists are then used
+// by offline stand-ins; they are never called).
+func builtinProviders(cfg config.LLMConfig, all bool) []Provider {
+	var providers []Provider
+
+	if all || cfg.GeminiAPIKey != "" {
 		providers = append(providers, NewGeminiProvider(cfg.GeminiAPIKey))
 	}
 
-	if cfg.MistralAPIKey != "" {
+	if all || cfg.MistralAPIKey != "" {
 		providers = append(providers, NewOpenAIProvider(OpenAIConfig{
 			Name:         "mistral",
 			BaseURL:      "https://api.mistral.ai/v1",
 			APIKey:       cfg.MistralAPIKey,
 			Models:       []string{"mistral-large-latest", "mistral-small-latest", "codestral-latest"},
 			DefaultModel: "mistral-small-latest",
+			JSONMode:     "json_schema",
+
+			EmbeddingModel: "mistral-embed",
 		}))
 	}
 
-	if cfg.GroqAPIKey != "" {
+	if all || cfg.GroqAPIKey != "" {
 		providers = append(providers, NewOpenAIProvider(OpenAIConfig{
 			Name:         "groq",
 			BaseURL:      "https://api.groq.com/openai/v1",
 			APIKey:       cfg.GroqAPIKey,
 			Models:       []string{"llama-3.3-70b-versatile", "llama-3.1-8b-instant", "mixtral-8x7b-32768"},
 			DefaultModel: "llama-3.3-70b-versatile",
+			JSONMode:     "json_object",
 		}))
 	}
 
-	if cfg.OpenRouterKey != "" {
+	if all || cfg.OpenRouterKey != "" {
 		providers = append(providers, NewOpenAIProvider(OpenAIConfig{
 			Name:         "openrouter",
 			BaseURL:      "https://openrouter.ai/api/v1",
 			APIKey:       cfg.OpenRouterKey,
 			Models:       []string{"deepseek/deepseek-chat", "qwen/qwen-2.5-72b-instruct", "meta-llama/llama-3.3-70b-instruct"},
 			DefaultModel: "deepseek/deepseek-chat",
+			JSONMode:     "json_object",
 		}))
 	}
 
-	if cfg.AnthropicAPIKey != "" {
+	if all || cfg.AnthropicAPIKey != "" {
 		providers = append(providers, NewAnthropicProvider(cfg.AnthropicAPIKey))
 	}
 
-	if cfg.HuggingFaceKey != "" {
+	if all || cfg.HuggingFaceKey != "" {
 		providers = append(providers, NewOpenAIProvider(OpenAIConfig{
 			Name:         "huggingface",
 			BaseURL:      "https://api-inference.huggingface.co/models",
@@ -57,17 +134,18 @@ func NewFromConfig(cfg config.LLMConfig) *Client {
 	}
 
 	// DeepSeek direct (free tier available)
-	if key := lookupDeepSeekKey(cfg); key != "" {
+	if key := lookupDeepSeekKey(cfg); all || key != "" {
 		providers = append(providers, NewOpenAIProvider(OpenAIConfig{
 			Name:         "deepseek",
 			BaseURL:      "https://api.deepseek.com/v1",
 			APIKey:       key,
 			Models:       []string{"deepseek-chat", "deepseek-reasoner"},
 			DefaultModel: "deepseek-chat",
+			JSONMode:     "json_object",
 		}))
 	}
 
-	return New(providers)
+	return providers
 }
 
 // lookupDeepSeekKey checks for a DeepSeek API key in the config.
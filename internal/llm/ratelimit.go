@@ -0,0 +1,337 @@
+// CLAUDE:SUMMARY Per-provider/per-model quotas — token buckets on requests/min and tokens/min plus max-in-flight; callers queue until capacity frees up
+package llm
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limit caps the throughput of a provider (or one of its models).
+// A zero field means "unlimited" for that dimension.
+type Limit struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	TokensPerMinute   int `json:"tokens_per_minute"`
+	MaxInFlight       int `json:"max_in_flight"`
+}
+
+// IsZero reports whether the limit imposes nothing.
+func (l Limit) IsZero() bool {
+	return l.RequestsPerMinute <= 0 && l.TokensPerMinute <= 0 && l.MaxInFlight <= 0
+}
+
+// LimitStatus is a snapshot of one limiter for the API.
+type LimitStatus struct {
+	Provider          string   `json:"provider"`
+	Model             string   `json:"model,omitempty"`
+	Limit             Limit    `json:"limit"`
+	InFlight          int      `json:"in_flight"`
+	Waiting           int      `json:"waiting"`
+	RequestsAvailable *float64 `json:"requests_available,omitempty"`
+	TokensAvailable   *float64 `json:"tokens_available,omitempty"`
+	TotalQueued       int64    `json:"total_queued"`
+	TotalWaitMs       int64    `json:"total_wait_ms"`
+}
+
+// bucket is a token bucket refilled continuously at perMinute/60 per second.
+// The level may go negative when actual usage exceeded the estimate; that
+// debt is paid back by refill before the next caller is admitted.
+type bucket struct {
+	capacity float64
+	level    float64
+	rate     float64 // units per second
+	last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	c := float64(perMinute)
+	return &bucket{capacity: c, level: c, rate: c / 60, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.level += now.Sub(b.last).Seconds() * b.rate
+	if b.level > b.capacity {
+		b.level = b.capacity
+	}
+	b.last = now
+}
+
+// wait returns how long until n units are available (0 if available now).
+func (b *bucket) wait(n float64) time.Duration {
+	if b == nil || b.level >= n {
+		return 0
+	}
+	return time.Duration((n - b.level) / b.rate * float64(time.Second))
+}
+
+func (b *bucket) add(n float64) {
+	if b == nil {
+		return
+	}
+	b.level += n
+	if b.level > b.capacity {
+		b.level = b.capacity
+	}
+}
+
+// limiter enforces one Limit.
+type limiter struct {
+	provider string
+	model    string
+
+	mu       sync.Mutex
+	limit    Limit
+	requests *bucket
+	tokens   *bucket
+	slots    chan struct{}
+	inFlight int
+	waiting  int
+	queued   int64
+	waited   time.Duration
+}
+
+func newLimiter(provider, model string, l Limit) *limiter {
+	now := time.Now()
+	lim := &limiter{
+		provider: provider,
+		model:    model,
+		limit:    l,
+		requests: newBucket(l.RequestsPerMinute, now),
+		tokens:   newBucket(l.TokensPerMinute, now),
+	}
+	if l.MaxInFlight > 0 {
+		lim.slots = make(chan struct{}, l.MaxInFlight)
+	}
+	return lim
+}
+
+// acquire blocks until a slot, one request and est tokens are available,
+// or ctx is done. The caller must call release on the returned permit.
+func (l *limiter) acquire(ctx context.Context, est int) (*permit, error) {
+	start := time.Now()
+	queued := false
+	defer func() {
+		if queued {
+			l.mu.Lock()
+			l.waiting--
+			l.waited += time.Since(start)
+			l.mu.Unlock()
+		}
+	}()
+	markQueued := func() {
+		if !queued {
+			queued = true
+			l.mu.Lock()
+			l.waiting++
+			l.queued++
+			l.mu.Unlock()
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			markQueued()
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	// A request larger than the whole bucket would never fit; let it
+	// through once the bucket is full rather than deadlocking.
+	need := float64(est)
+	if l.tokens != nil && need > l.tokens.capacity {
+		need = l.tokens.capacity
+	}
+
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.requests.refill(now)
+		l.tokens.refill(now)
+		d := max(l.requests.wait(1), l.tokens.wait(need))
+		if d == 0 {
+			if l.requests != nil {
+				l.requests.level--
+			}
+			if l.tokens != nil {
+				l.tokens.level -= need
+			}
+			l.inFlight++
+			l.mu.Unlock()
+			return &permit{l: l, est: need}, nil
+		}
+		l.mu.Unlock()
+
+		markQueued()
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			if l.slots != nil {
+				<-l.slots
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *limiter) status() LimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	s := LimitStatus{
+		Provider:    l.provider,
+		Model:       l.model,
+		Limit:       l.limit,
+		InFlight:    l.inFlight,
+		Waiting:     l.waiting,
+		TotalQueued: l.queued,
+		TotalWaitMs: l.waited.Milliseconds(),
+	}
+	if l.requests != nil {
+		l.requests.refill(now)
+		v := l.requests.level
+		s.RequestsAvailable = &v
+	}
+	if l.tokens != nil {
+		l.tokens.refill(now)
+		v := l.tokens.level
+		s.TokensAvailable = &v
+	}
+	return s
+}
+
+// permit is a granted admission through a limiter.
+type permit struct {
+	l   *limiter
+	est float64
+}
+
+// release frees the in-flight slot and reconciles the token estimate with
+// actual usage. With refund, the request never reached the provider
+// (e.g. circuit open) so its request and tokens are given back.
+func (p *permit) release(actualTokens int, refund bool) {
+	l := p.l
+	l.mu.Lock()
+	l.inFlight--
+	switch {
+	case refund:
+		l.requests.add(1)
+		l.tokens.add(p.est)
+	case actualTokens > 0:
+		l.tokens.add(p.est - float64(actualTokens))
+	}
+	l.mu.Unlock()
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// estimateRequestTokens approximates the tokens a request will consume
+// for the TPM bucket. The estimate is reconciled with the provider's
+// reported usage afterwards.
+func estimateRequestTokens(req Request) int {
+	in, out := estimateUsage(req)
+	return in + out
+}
+
+// estimateUsage approximates prompt tokens at ~4 chars/token and assumes
+// the whole completion budget (1024 when MaxTokens is unset) is used.
+func estimateUsage(req Request) (tokensIn, tokensOut int) {
+	chars := 0
+	for _, m := range req.Messages {
+		chars += len(m.Content)
+	}
+	tokensOut = req.MaxTokens
+	if tokensOut <= 0 {
+		tokensOut = 1024
+	}
+	return chars / 4, tokensOut
+}
+
+// SetLimit installs (or replaces) a quota for a provider, or for a single
+// model of that provider when model is non-empty. A zero Limit removes it.
+// Replacing a limiter does not affect callers already admitted by the old one.
+func (c *Client) SetLimit(provider, model string, l Limit) {
+	key := limitKey(provider, model)
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	if l.IsZero() {
+		delete(c.limits, key)
+		return
+	}
+	c.limits[key] = newLimiter(provider, model, l)
+}
+
+// Limits returns a snapshot of every configured quota, sorted by provider/model.
+func (c *Client) Limits() []LimitStatus {
+	c.limitsMu.RLock()
+	keys := make([]string, 0, len(c.limits))
+	for key := range c.limits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]LimitStatus, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, c.limits[key].status())
+	}
+	c.limitsMu.RUnlock()
+	return out
+}
+
+// admit acquires the provider-level and then the model-level limiter.
+// The returned release func must be called exactly once.
+func (c *Client) admit(ctx context.Context, provider string, req Request) (func(actualTokens int, refund bool), error) {
+	c.limitsMu.RLock()
+	var chain []*limiter
+	if l, ok := c.limits[limitKey(provider, "")]; ok {
+		chain = append(chain, l)
+	}
+	if req.Model != "" {
+		if l, ok := c.limits[limitKey(provider, req.Model)]; ok {
+			chain = append(chain, l)
+		}
+	}
+	c.limitsMu.RUnlock()
+
+	if len(chain) == 0 {
+		return func(int, bool) {}, nil
+	}
+
+	est := estimateRequestTokens(req)
+	permits := make([]*permit, 0, len(chain))
+	releaseAll := func(actual int, refund bool) {
+		for _, p := range permits {
+			p.release(actual, refund)
+		}
+	}
+	for _, l := range chain {
+		p, err := l.acquire(ctx, est)
+		if err != nil {
+			releaseAll(0, true)
+			return nil, err
+		}
+		permits = append(permits, p)
+	}
+	return releaseAll, nil
+}
+
+func limitKey(provider, model string) string {
+	if model == "" {
+		return provider
+	}
+	return provider + "/" + model
+}
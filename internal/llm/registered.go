@@ -0,0 +1,264 @@
+// CLAUDE:SUMMARY Self-registered providers — turns approved providers-table rows (api_style openai/anthropic/gemini) into live Client backends behind the endpoint address policy and retires them when deactivated or silent
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// Register adds a self-registered provider under p.Name(), replacing any
+// previous registration of that name. id is its providers-table id. The
+// client's decorators (offline mode, instrumentation) are applied. A
+// registered provider is not part of the fallback chain: it only serves
+// requests routed to it by name ("name/model" or CompleteWith).
+func (c *Client) Register(id string, p Provider) error {
+	name := p.Name()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.providers[name]; ok && c.registered[name] == "" {
+		return fmt.Errorf("provider %q is already configured", name)
+	}
+	if name == LocalProvider {
+		return fmt.Errorf("provider name %q is reserved for the built-in embedder", name)
+	}
+	for _, decorate := range c.decorators {
+		p = decorate(p)
+	}
+	c.providers[name] = p
+	c.breakers[name] = newBreaker()
+	c.registered[name] = id
+	return nil
+}
+
+// Deregister removes a self-registered provider. Configured providers are
+// never removed. Returns false if name is not a registered provider.
+func (c *Client) Deregister(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.registered[name]; !ok {
+		return false
+	}
+	delete(c.providers, name)
+	delete(c.breakers, name)
+	delete(c.registered, name)
+	return true
+}
+
+// RegisteredID returns the providers-table id of a self-registered
+// provider, or "" for configured and unknown providers.
+func (c *Client) RegisteredID(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.registered[name]
+}
+
+// RegisteredProviders keeps the client in step with the providers table:
+// active, operator-approved rows with a supported api_style and an endpoint
+// within the address policy become backends, and are removed
+// again when is_active flips to 0, when their heartbeat goes stale, or when
+// the row disappears. Their models are published in available_models with
+// owner_id = the provider's id.
+type RegisteredProviders struct {
+	database   *db.DB
+	flowsDB    *db.FlowsDB
+	client     *Client
+	staleAfter time.Duration // 0 = heartbeats are not required
+	logger     *slog.Logger
+
+	mu       sync.Mutex
+	live     map[string]string // provider name → signature of the registered backend
+	rejected map[string]string // provider name → signature already logged as unusable
+}
+
+// NewRegisteredProviders creates the sync between the providers table and
+// client. A provider whose last heartbeat (or registration, if it never
+// sent one) is older than staleAfter is retired until it checks in again.
+func NewRegisteredProviders(database *db.DB, flowsDB *db.FlowsDB, client *Client, staleAfter time.Duration, logger *slog.Logger) *RegisteredProviders {
+	return &RegisteredProviders{
+		database:   database,
+		flowsDB:    flowsDB,
+		client:     client,
+		staleAfter: staleAfter,
+		logger:     logger,
+		live:       make(map[string]string),
+		rejected:   make(map[string]string),
+	}
+}
+
+// Run syncs immediately, then every interval until ctx is done.
+func (r *RegisteredProviders) Run(ctx context.Context, interval time.Duration) {
+	if err := r.Sync(); err != nil {
+		r.logger.Warn("syncing registered providers", "error", err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Sync(); err != nil {
+				r.logger.Warn("syncing registered providers", "error", err)
+			}
+		}
+	}
+}
+
+// Sync reads the providers table once and registers, re-registers (after
+// an endpoint, api_style or model change) or retires backends accordingly.
+func (r *RegisteredProviders) Sync() error {
+	rows, err := r.database.ListRegisteredProviders()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	retire := make(map[string]string) // provider name → reason
+	for name := range r.live {
+		retire[name] = "removed"
+	}
+
+	for i := range rows {
+		p := &rows[i]
+		if reason := r.unusable(p, now); reason != "" {
+			if _, ok := r.live[p.Name]; ok {
+				retire[p.Name] = reason
+			}
+			continue
+		}
+		delete(retire, p.Name)
+
+		sig := registrationSignature(p)
+		if r.live[p.Name] == sig {
+			continue
+		}
+		if err := r.client.Register(p.ID, registeredBackend(p)); err != nil {
+			if r.rejected[p.Name] != sig {
+				r.rejected[p.Name] = sig
+				r.logger.Warn("registered provider not activated", "provider", p.Name, "error", err)
+			}
+			continue
+		}
+		delete(r.rejected, p.Name)
+		r.live[p.Name] = sig
+		r.publishModels(p)
+
+		r.logger.Info("registered provider activated",
+			"provider", p.Name, "api_style", p.APIStyle, "models", len(p.Models))
+		_ = r.flowsDB.InsertAuditLog("", "", "provider_activated", map[string]interface{}{
+			"provider":    p.Name,
+			"provider_id": p.ID,
+			"api_style":   p.APIStyle,
+			"endpoint":    p.Endpoint,
+			"models":      p.Models,
+		})
+	}
+
+	for name, reason := range retire {
+		r.client.Deregister(name)
+		delete(r.live, name)
+		_ = r.flowsDB.MarkAllUnavailableForProvider(name)
+
+		r.logger.Info("registered provider retired", "provider", name, "reason", reason)
+		_ = r.flowsDB.InsertAuditLog("", "", "provider_deactivated", map[string]interface{}{
+			"provider": name,
+			"reason":   reason,
+		})
+	}
+	return nil
+}
+
+// untrusted returns why a registration may not be called at all, or "":
+// it has no authenticated registrant or no operator approval, or its
+// endpoint is outside the address policy.
+func untrusted(p *db.RegisteredProvider) string {
+	switch {
+	case p.RegisteredBy == "":
+		return "no registrant"
+	case !p.Approved:
+		return "not approved"
+	}
+	if _, err := endpointURL(p.Endpoint, p.AllowPrivate); err != nil {
+		return "endpoint not allowed"
+	}
+	return ""
+}
+
+// unusable returns why a row cannot be served right now, or "".
+func (r *RegisteredProviders) unusable(p *db.RegisteredProvider, now time.Time) string {
+	if reason := untrusted(p); reason != "" {
+		return reason
+	}
+	switch {
+	case !p.IsActive:
+		return "inactive"
+	case r.staleAfter > 0 && now.Sub(p.LastAlive()) > r.staleAfter:
+		return "heartbeat stale"
+	case p.APIStyle != "openai" && p.APIStyle != "anthropic" && p.APIStyle != "gemini":
+		return "unsupported api_style"
+	case p.Endpoint == "":
+		return "no endpoint"
+	case len(p.Models) == 0:
+		return "no models"
+	}
+	return ""
+}
+
+// publishModels marks the provider's previous models unavailable, then
+// upserts the current list owned by the provider.
+func (r *RegisteredProviders) publishModels(p *db.RegisteredProvider) {
+	_ = r.flowsDB.MarkAllUnavailableForProvider(p.Name)
+	owner := p.ID
+	for _, m := range p.Models {
+		if err := r.flowsDB.UpsertModel(&db.AvailableModel{
+			ModelID:          p.Name + "/" + m,
+			Provider:         p.Name,
+			ModelName:        m,
+			IsAvailable:      true,
+			CapabilitiesJSON: "{}",
+			OwnerID:          &owner,
+		}); err != nil {
+			r.logger.Warn("publishing registered model", "provider", p.Name, "model", m, "error", err)
+		}
+	}
+}
+
+// registeredBackend builds the provider for a row. Registered endpoints are
+// called without an API key, through a client that enforces the address
+// policy on every connection.
+func registeredBackend(p *db.RegisteredProvider) Provider {
+	client := endpointClient(120*time.Second, p.AllowPrivate)
+	switch p.APIStyle {
+	case "anthropic":
+		prov := NewAnthropicEndpoint(p.Name, p.Endpoint, "", p.Models)
+		prov.client = client
+		return prov
+	case "gemini":
+		prov := NewGeminiEndpoint(p.Name, p.Endpoint, "", p.Models)
+		prov.client = client
+		return prov
+	default:
+		prov := NewOpenAIProvider(OpenAIConfig{
+			Name:    p.Name,
+			BaseURL: strings.TrimSuffix(p.Endpoint, "/"),
+			Models:  p.Models,
+		})
+		prov.client = client
+		return prov
+	}
+}
+
+func registrationSignature(p *db.RegisteredProvider) string {
+	return p.ID + "|" + p.APIStyle + "|" + p.Endpoint + "|" + strings.Join(p.Models, ",") +
+		"|" + strconv.FormatBool(p.AllowPrivate)
+}
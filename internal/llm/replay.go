@@ -3,6 +3,7 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -32,6 +33,8 @@ type ReplayResult struct {
 	TokensIn       int           `json:"tokens_in"`
 	TokensOut      int           `json:"tokens_out"`
 	LatencyMs      int           `json:"latency_ms"`
+	CostUSD        float64       `json:"cost_usd"`
+	Cached         bool          `json:"cached"`
 	Error          string        `json:"error,omitempty"`
 }
 
@@ -72,6 +75,10 @@ func (re *ReplayEngine) ReplayStep(ctx context.Context, stepID, provider, model
 		LatencyMs:      int(latency.Milliseconds()),
 	}
 
+	if errors.Is(err, ErrBudgetExceeded) {
+		// Refused before anything was sent: there is no replay to persist.
+		return nil, err
+	}
 	if err != nil {
 		result.Error = err.Error()
 		// Still persist the failed replay — LLM failure is not a system error
@@ -83,6 +90,8 @@ func (re *ReplayEngine) ReplayStep(ctx context.Context, stepID, provider, model
 	result.Content = resp.Content
 	result.TokensIn = resp.TokensIn
 	result.TokensOut = resp.TokensOut
+	result.CostUSD = resp.CostUSD
+	result.Cached = resp.Cached
 	result.Provider = resp.Provider
 	result.Model = resp.Model
 
@@ -158,6 +167,13 @@ func (re *ReplayEngine) ReplayBulk(ctx context.Context, batchID, filterModel, re
 		}
 
 		_, err := re.ReplayStep(ctx, sid, replayProvider, replayModel)
+		if errors.Is(err, ErrBudgetExceeded) {
+			// Every remaining step would be refused the same way.
+			result.Status = "failed"
+			re.updateBatch(batchID, result)
+			re.logger.Warn("replay batch stopped", "batch_id", batchID, "error", err)
+			return result, err
+		}
 		if err != nil {
 			result.Failed++
 		} else {
@@ -204,12 +220,12 @@ func (re *ReplayEngine) persistReplay(orig *originalStep, result *ReplayResult,
 	_, _ = re.flowsDB.Exec(`
 		INSERT INTO flow_steps (id, flow_id, step_index, node_id, model_id, provider,
 			prompt, system_prompt, response_raw, response_parsed,
-			tokens_in, tokens_out, latency_ms, replay_of_id, error)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			tokens_in, tokens_out, latency_ms, cost_usd, cached, replay_of_id, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		id, orig.FlowID, orig.StepIndex, nilIfEmpty(orig.NodeID),
 		model, provider, orig.Prompt, nilIfEmpty(orig.SystemPrompt),
 		result.Content, result.Content,
-		result.TokensIn, result.TokensOut, result.LatencyMs,
+		result.TokensIn, result.TokensOut, result.LatencyMs, result.CostUSD, result.Cached,
 		orig.ID, nilIfEmpty(errStr))
 
 	return id
@@ -24,18 +24,25 @@ func NewResolutionEngine(client *Client, flowsDB *db.FlowsDB, logger *slog.Logge
 
 // GenerateResolution produces a structured dialogue from a proof tree.
 // The tree is serialized to text, then an LLM synthesizes it into a Resolution.
+// A tree larger than the model's context window is first summarized part by
+// part (see resolutionRun.summarize).
 //
 //nolint:misspell // French-language LLM prompts
 func (e *ResolutionEngine) GenerateResolution(ctx context.Context, tree *db.Node, provider, model string) (*ResolutionResult, error) {
-	treeText := serializeTree(tree, 0)
-	if treeText == "" {
-		return nil, fmt.Errorf("empty tree")
-	}
+	return e.generateResolution(ctx, tree, provider, model, nil)
+}
 
-	messages := []Message{
-		{
-			Role: "system",
-			Content: `Tu es un générateur de Résolution pour une raffinerie de connaissances.
+// StreamResolution is GenerateResolution with token deltas forwarded to fn.
+// The flow_steps row is written once the stream completes, with the full content.
+func (e *ResolutionEngine) StreamResolution(ctx context.Context, tree *db.Node, provider, model string, fn StreamFunc) (*ResolutionResult, error) {
+	return e.generateResolution(ctx, tree, provider, model, fn)
+}
+
+// resolutionSystemPrompt frames the final synthesis, whether it reads the
+// tree itself or the summaries of its parts.
+//
+//nolint:misspell // French-language LLM prompts
+const resolutionSystemPrompt = `Tu es un générateur de Résolution pour une raffinerie de connaissances.
 
 Une Résolution est un dialogue structuré entre LIGNES ARGUMENTATIVES (pas entre personnes).
 Chaque ligne argumentative représente une position défendue dans l'arbre de preuves.
@@ -51,76 +58,108 @@ Format de sortie :
 
 Règles :
 - Fidélité absolue à l'arbre source — ne rien inventer
-- Chaque affirmation doit être traçable à un nœud de l'arbre
+- Chaque affirmation doit être traçable à un nœud de l'arbre : cite ses identifiants [id:…]
 - Les sources sont citées inline [source: URL]
 - Les votes et scores reflètent le poids communautaire
-- La température indique le niveau de controverse`,
-		},
-		{
-			Role: "user",
-			Content: fmt.Sprintf("Génère une Résolution pour cet arbre de preuves :\n\n%s", treeText),
+- La température indique le niveau de controverse`
+
+// resolutionMaxTokens caps the length of a Resolution.
+const resolutionMaxTokens = 4096
+
+//nolint:misspell // French-language LLM prompts
+func (e *ResolutionEngine) generateResolution(ctx context.Context, tree *db.Node, provider, model string, fn StreamFunc) (*ResolutionResult, error) {
+	treeText := serializeTree(tree, 0)
+	if treeText == "" {
+		return nil, fmt.Errorf("empty tree")
+	}
+
+	window := ContextWindow(e.flowsDB, e.client, provider, model)
+	run := &resolutionRun{
+		engine:   e,
+		tree:     tree,
+		provider: provider,
+		model:    model,
+		window:   window,
+		result: &ResolutionResult{
+			Strategy:      "single",
+			ContextWindow: window,
+			FlowID:        "res_" + db.NewID(),
+			Cached:        true,
 		},
 	}
+	maxTokens := min(resolutionMaxTokens, window/4)
+	user := fmt.Sprintf("Génère une Résolution pour cet arbre de preuves :\n\n%s", treeText)
+	covered := treeIDs(tree)
 
-	req := Request{
-		Model:       model,
-		Messages:    messages,
-		Temperature: 0.3,
-		MaxTokens:   4096,
+	// A tree too large for the model is summarized part by part first.
+	if EstimateTokens(user) > promptBudget(window, maxTokens, resolutionSystemPrompt) {
+		frame := fmt.Sprintf(mapReduceFrame, 0, tree.ID, rootExcerpt(tree))
+		summaries, err := run.summarize(ctx, promptBudget(window, maxTokens, resolutionSystemPrompt, frame))
+		if err != nil {
+			return nil, fmt.Errorf("generating resolution: %w", err)
+		}
+		texts := make([]string, len(summaries))
+		for i, s := range summaries {
+			texts[i] = s.text
+		}
+		user = fmt.Sprintf(mapReduceFrame, len(run.result.Plan), tree.ID, rootExcerpt(tree)) + strings.Join(texts, "\n\n---\n\n")
+		run.result.Strategy = "map_reduce"
 	}
 
-	var resp *Response
-	var err error
-	if provider != "" {
-		resp, err = e.client.CompleteWith(ctx, provider, req)
-	} else {
-		resp, err = e.client.Complete(ctx, req)
+	messages := []Message{
+		{Role: "system", Content: resolutionSystemPrompt},
+		{Role: "user", Content: user},
 	}
+	resp, err := run.call(WithStepName(ctx, "resolution"), tree.ID, covered, Request{
+		Model:       model,
+		Messages:    messages,
+		Temperature: 0.3,
+		MaxTokens:   maxTokens,
+	}, fn)
 	if err != nil {
 		return nil, fmt.Errorf("generating resolution: %w", err)
 	}
 
-	// Persist to flows.db
-	if e.flowsDB != nil {
-		stepID := db.NewID()
-		flowID := "res_" + db.NewID()
-		_, _ = e.flowsDB.Exec(`
-			INSERT INTO flow_steps (id, flow_id, step_index, node_id, model_id, provider,
-				prompt, system_prompt, response_raw, response_parsed,
-				tokens_in, tokens_out, latency_ms, finish_reason)
-			VALUES (?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			stepID, flowID, tree.ID,
-			resp.Model, resp.Provider,
-			messages[1].Content, messages[0].Content,
-			resp.Content, resp.Content,
-			resp.TokensIn, resp.TokensOut,
-			int(resp.Latency.Milliseconds()), resp.FinishReason)
-	}
-
-	return &ResolutionResult{
-		Content:  resp.Content,
-		Provider: resp.Provider,
-		Model:    resp.Model,
-		TokensIn: resp.TokensIn,
-		TokensOut: resp.TokensOut,
-		LatencyMs: int(resp.Latency.Milliseconds()),
-	}, nil
+	res := run.result
+	res.Content = resp.Content
+	res.Provider = resp.Provider
+	res.Model = resp.Model
+	return res, nil
 }
 
-// ResolutionResult holds the output of a Resolution generation.
+// ResolutionResult holds the output of a Resolution generation. Token,
+// latency and cost figures add up every call of a map-reduce.
 type ResolutionResult struct {
-	Content   string `json:"content"`
-	Provider  string `json:"provider"`
-	Model     string `json:"model"`
-	TokensIn  int    `json:"tokens_in"`
-	TokensOut int    `json:"tokens_out"`
-	LatencyMs int    `json:"latency_ms"`
+	Content   string  `json:"content"`
+	Provider  string  `json:"provider"`
+	Model     string  `json:"model"`
+	TokensIn  int     `json:"tokens_in"`
+	TokensOut int     `json:"tokens_out"`
+	LatencyMs int     `json:"latency_ms"`
+	CostUSD   float64 `json:"cost_usd"`
+	Cached    bool    `json:"cached"`
+
+	Strategy      string            `json:"strategy"` // single, or map_reduce when the tree exceeds the context window
+	ContextWindow int               `json:"context_window"`
+	Plan          []ResolutionChunk `json:"plan,omitempty"` // map_reduce: the parts summarized, in tree order
+	FlowID        string            `json:"flow_id"`        // flow_steps.flow_id of every call
 }
 
 // RenderResolution transforms a Resolution into a specific format.
 //
 //nolint:misspell // French-language LLM prompts
 func (e *ResolutionEngine) RenderResolution(ctx context.Context, resolution string, format string, provider, model string) (*RenderResult, error) {
+	return e.renderResolution(ctx, resolution, format, provider, model, nil)
+}
+
+// StreamRender is RenderResolution with token deltas forwarded to fn.
+func (e *ResolutionEngine) StreamRender(ctx context.Context, resolution string, format string, provider, model string, fn StreamFunc) (*RenderResult, error) {
+	return e.renderResolution(ctx, resolution, format, provider, model, fn)
+}
+
+//nolint:misspell // French-language LLM prompts
+func (e *ResolutionEngine) renderResolution(ctx context.Context, resolution string, format string, provider, model string, fn StreamFunc) (*RenderResult, error) {
+	ctx = WithStepName(ctx, "render_"+format)
 	prompts := map[string]string{
 		"article": "Transforme cette Résolution en un article clair et lisible, avec titre, chapô, et paragraphes structurés. Conserve toutes les sources et nuances.",
 		"faq":     "Transforme cette Résolution en une FAQ (questions-réponses). Chaque question couvre un aspect clé du débat. Les réponses citent les sources.",
@@ -145,13 +184,7 @@ func (e *ResolutionEngine) RenderResolution(ctx context.Context, resolution stri
 		MaxTokens:   4096,
 	}
 
-	var resp *Response
-	var err error
-	if provider != "" {
-		resp, err = e.client.CompleteWith(ctx, provider, req)
-	} else {
-		resp, err = e.client.Complete(ctx, req)
-	}
+	resp, err := e.send(ctx, provider, req, fn)
 	if err != nil {
 		return nil, fmt.Errorf("rendering resolution: %w", err)
 	}
@@ -172,31 +205,62 @@ type RenderResult struct {
 	Model    string `json:"model"`
 }
 
+// send routes a request to the named provider (or the fallback chain),
+// streaming when fn is non-nil.
+func (e *ResolutionEngine) send(ctx context.Context, provider string, req Request, fn StreamFunc) (*Response, error) {
+	switch {
+	case fn != nil && provider != "":
+		return e.client.StreamWith(ctx, provider, req, fn)
+	case fn != nil:
+		return e.client.Stream(ctx, req, fn)
+	case provider != "":
+		return e.client.CompleteWith(ctx, provider, req)
+	default:
+		return e.client.Complete(ctx, req)
+	}
+}
+
 // serializeTree converts a node tree to a readable text representation.
+// Each node is introduced by its id ([id:…]) so that claims drawn from the
+// text can be traced back to it.
 func serializeTree(node *db.Node, depth int) string {
 	if node == nil {
 		return ""
 	}
 	var b strings.Builder
+	writeNode(&b, node, depth)
+
+	// Children
+	for _, child := range node.Children {
+		b.WriteString(serializeTree(child, depth+1))
+	}
+
+	return b.String()
+}
+
+// writeNode writes the header and body of one node, without its children.
+func writeNode(b *strings.Builder, node *db.Node, depth int) {
 	indent := strings.Repeat("  ", depth)
 
 	// Node header
-	fmt.Fprintf(&b, "%s[%s] (score:%d, temp:%s", indent, node.NodeType, node.Score, node.Temperature)
+	fmt.Fprintf(b, "%s[%s] [id:%s] (score:%d, temp:%s", indent, node.NodeType, node.ID, node.Score, node.Temperature)
 	if node.ModelID != nil {
-		fmt.Fprintf(&b, ", model:%s", *node.ModelID)
+		fmt.Fprintf(b, ", model:%s", *node.ModelID)
 	}
 	b.WriteString(")\n")
 
 	// Body
 	lines := strings.Split(node.Body, "\n")
 	for _, line := range lines {
-		fmt.Fprintf(&b, "%s  %s\n", indent, line)
+		fmt.Fprintf(b, "%s  %s\n", indent, line)
 	}
+}
 
-	// Children
+// treeIDs lists the ids of node and its descendants, depth first.
+func treeIDs(node *db.Node) []string {
+	ids := []string{node.ID}
 	for _, child := range node.Children {
-		b.WriteString(serializeTree(child, depth+1))
+		ids = append(ids, treeIDs(child)...)
 	}
-
-	return b.String()
+	return ids
 }
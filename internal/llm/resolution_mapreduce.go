@@ -0,0 +1,288 @@
+// CLAUDE:SUMMARY Map-reduce resolutions — splits trees larger than the model's context window into parts, summarizes each keeping node ids, merges summaries, and traces every call in flow_steps
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+const (
+	summaryMaxTokens = 1024 // completion cap of a part summary or a merge
+	maxMergeRounds   = 4    // merge rounds before summaries are clipped to fit
+	minPromptBudget  = 256  // floor of promptBudget on very small windows
+)
+
+//nolint:misspell // French-language LLM prompts
+const (
+	treeSummaryPrompt = `Tu résumes une partie d'un arbre de preuves ; une Résolution sera ensuite rédigée à partir des résumés de toutes les parties.
+
+Règles :
+- Une ligne par affirmation, objection, preuve ou source distincte
+- Chaque ligne se termine par les identifiants des nœuds dont elle provient : [id:…]
+- Conserve les URL des sources, et les scores ou températures notables
+- Fidélité absolue — ne rien inventer ; si tu regroupes des nœuds, garde tous leurs identifiants`
+
+	mergeSummaryPrompt = `Tu fusionnes des résumés partiels d'un arbre de preuves en un seul résumé plus court.
+
+Règles :
+- Regroupe les affirmations équivalentes, sépare les positions opposées
+- Chaque ligne se termine par les identifiants de TOUS les nœuds dont elle provient : [id:…]
+- Conserve les URL des sources
+- Fidélité absolue — ne rien inventer`
+
+	// mapReduceFrame introduces the summaries to the final synthesis:
+	// number of parts, root id, root excerpt.
+	mapReduceFrame = "Génère une Résolution pour cet arbre de preuves. Trop grand pour être lu d'un bloc, " +
+		"il a été découpé en %d parties résumées ci-dessous ; chaque ligne porte les identifiants [id:…] " +
+		"des nœuds dont elle provient.\n\nQuestion racine [id:%s] : %s\n\n"
+)
+
+// ResolutionChunk is one part of a map-reduce plan: the nodes it covers
+// and its estimated size in tokens.
+type ResolutionChunk struct {
+	Index   int      `json:"index"`
+	NodeIDs []string `json:"node_ids"`
+	Tokens  int      `json:"tokens"`
+}
+
+// textPart is a piece of prompt text and the nodes it was drawn from.
+type textPart struct {
+	ids    []string
+	text   string
+	tokens int
+	parts  int // pieces packed into it
+}
+
+// resolutionRun holds the state of one Resolution generation: every call
+// is recorded under the same flow and added to the result totals.
+type resolutionRun struct {
+	engine   *ResolutionEngine
+	tree     *db.Node
+	provider string
+	model    string
+	window   int
+	steps    int
+	result   *ResolutionResult
+}
+
+// call sends req and records it as the next step of the flow. nodeID is
+// the node the step is about, ids every node its prompt covers.
+func (r *resolutionRun) call(ctx context.Context, nodeID string, ids []string, req Request, fn StreamFunc) (*Response, error) {
+	resp, err := r.engine.send(ctx, r.provider, req, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.engine.flowsDB != nil {
+		var system, prompt string
+		for _, m := range req.Messages {
+			if m.Role == "system" {
+				system = m.Content
+			} else {
+				prompt = m.Content
+			}
+		}
+		contextIDs, _ := json.Marshal(ids)
+		_, _ = r.engine.flowsDB.Exec(`
+			INSERT INTO flow_steps (id, flow_id, step_index, node_id, model_id, provider,
+				prompt, system_prompt, context_ids, response_raw, response_parsed,
+				tokens_in, tokens_out, latency_ms, cost_usd, cached, finish_reason)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			db.NewID(), r.result.FlowID, r.steps, nodeID,
+			resp.Model, resp.Provider,
+			prompt, system, string(contextIDs),
+			resp.Content, resp.Content,
+			resp.TokensIn, resp.TokensOut,
+			int(resp.Latency.Milliseconds()), resp.CostUSD, resp.Cached, resp.FinishReason)
+	}
+	r.steps++
+
+	res := r.result
+	res.TokensIn += resp.TokensIn
+	res.TokensOut += resp.TokensOut
+	res.LatencyMs += int(resp.Latency.Milliseconds())
+	res.CostUSD += resp.CostUSD
+	res.Cached = res.Cached && resp.Cached
+	return resp, nil
+}
+
+// summarize splits the tree into parts that fit the model, summarizes each
+// (map), then merges neighbouring summaries until together they fit in
+// budget tokens (reduce). The plan is recorded in the result.
+//
+//nolint:misspell // French-language LLM prompts
+func (r *resolutionRun) summarize(ctx context.Context, budget int) ([]textPart, error) {
+	maxTokens := min(summaryMaxTokens, r.window/4)
+	root := fmt.Sprintf("Question racine [id:%s] : %s\n\n", r.tree.ID, rootExcerpt(r.tree))
+	partBudget := promptBudget(r.window, maxTokens, treeSummaryPrompt, root+"Partie 00/00 de l'arbre :\n\n")
+
+	chunks := packParts(splitTree(r.tree, 0, partBudget), partBudget, "")
+	for i, c := range chunks {
+		r.result.Plan = append(r.result.Plan, ResolutionChunk{Index: i, NodeIDs: c.ids, Tokens: c.tokens})
+	}
+
+	mapCtx := WithStepName(ctx, "resolution_map")
+	summaries := make([]textPart, len(chunks))
+	for i, c := range chunks {
+		resp, err := r.call(mapCtx, c.ids[0], c.ids, Request{
+			Model: r.model,
+			Messages: []Message{
+				{Role: "system", Content: treeSummaryPrompt},
+				{Role: "user", Content: fmt.Sprintf("%sPartie %d/%d de l'arbre :\n\n%s", root, i+1, len(chunks), c.text)},
+			},
+			Temperature: 0.2,
+			MaxTokens:   maxTokens,
+		}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("summarizing part %d/%d: %w", i+1, len(chunks), err)
+		}
+		summaries[i] = textPart{ids: c.ids, text: resp.Content, tokens: EstimateTokens(resp.Content), parts: 1}
+	}
+
+	reduceCtx := WithStepName(ctx, "resolution_reduce")
+	mergeBudget := promptBudget(r.window, maxTokens, mergeSummaryPrompt, root)
+	for round := 0; round < maxMergeRounds && partsTokens(summaries) > budget; round++ {
+		groups := packParts(summaries, mergeBudget, "\n\n---\n\n")
+		if len(groups) == len(summaries) {
+			break // no two summaries fit together
+		}
+		merged := make([]textPart, 0, len(groups))
+		for _, g := range groups {
+			if g.parts == 1 {
+				merged = append(merged, g)
+				continue
+			}
+			resp, err := r.call(reduceCtx, r.tree.ID, g.ids, Request{
+				Model: r.model,
+				Messages: []Message{
+					{Role: "system", Content: mergeSummaryPrompt},
+					{Role: "user", Content: root + "Résumés partiels :\n\n" + g.text},
+				},
+				Temperature: 0.2,
+				MaxTokens:   maxTokens,
+			}, nil)
+			if err != nil {
+				return nil, fmt.Errorf("merging summaries: %w", err)
+			}
+			merged = append(merged, textPart{ids: g.ids, text: resp.Content, tokens: EstimateTokens(resp.Content), parts: 1})
+		}
+		summaries = merged
+	}
+
+	// Still too long (a model ignoring its length cap): clip evenly.
+	if total := partsTokens(summaries); total > budget {
+		for i := range summaries {
+			summaries[i].text = clipTokens(summaries[i].text, budget/len(summaries))
+		}
+	}
+	return summaries, nil
+}
+
+// splitTree cuts the serialization of node's subtree into pieces of at
+// most budget tokens, in tree order: a subtree that fits is one piece,
+// otherwise the node comes alone (its body split if needed, each piece
+// keeping the id) followed by the pieces of its children.
+//
+//nolint:misspell // French-language LLM prompts
+func splitTree(node *db.Node, depth, budget int) []textPart {
+	text := serializeTree(node, depth)
+	if tokens := EstimateTokens(text); tokens <= budget {
+		return []textPart{{ids: treeIDs(node), text: text, tokens: tokens, parts: 1}}
+	}
+
+	var b strings.Builder
+	writeNode(&b, node, depth)
+	var parts []textPart
+	continued := fmt.Sprintf("%s[id:%s] (suite)\n", strings.Repeat("  ", depth), node.ID)
+	for i, piece := range splitTokens(b.String(), budget-EstimateTokens(continued)) {
+		if i > 0 {
+			piece = continued + piece
+		}
+		parts = append(parts, textPart{ids: []string{node.ID}, text: piece, tokens: EstimateTokens(piece), parts: 1})
+	}
+	for _, child := range node.Children {
+		parts = append(parts, splitTree(child, depth+1, budget)...)
+	}
+	return parts
+}
+
+// packParts greedily joins consecutive parts while they fit in budget.
+func packParts(parts []textPart, budget int, sep string) []textPart {
+	var out []textPart
+	for _, p := range parts {
+		if n := len(out); n > 0 && out[n-1].tokens+p.tokens+EstimateTokens(sep) <= budget {
+			last := &out[n-1]
+			for _, id := range p.ids {
+				if last.ids[len(last.ids)-1] != id {
+					last.ids = append(last.ids, id)
+				}
+			}
+			last.text += sep + p.text
+			last.tokens += p.tokens + EstimateTokens(sep)
+			last.parts += p.parts
+			continue
+		}
+		p.ids = append([]string(nil), p.ids...)
+		out = append(out, p)
+	}
+	return out
+}
+
+func partsTokens(parts []textPart) int {
+	n := 0
+	for _, p := range parts {
+		n += p.tokens
+	}
+	return n
+}
+
+// promptBudget is the room left for content in a prompt to a model with
+// window tokens, once the completion (maxTokens) and the fixed texts
+// (system prompt, framing) are accounted for, keeping a tenth of the
+// window for estimation error.
+func promptBudget(window, maxTokens int, fixed ...string) int {
+	budget := window*9/10 - maxTokens
+	for _, s := range fixed {
+		budget -= EstimateTokens(s) + 4
+	}
+	return max(budget, minPromptBudget)
+}
+
+// rootExcerpt is the start of the root question, repeated in every part
+// so that each summary knows what the tree is about.
+func rootExcerpt(tree *db.Node) string {
+	return clipTokens(strings.TrimSpace(tree.Body), 150)
+}
+
+// splitTokens cuts text into pieces of at most tokens tokens (by the
+// EstimateTokens measure), on rune boundaries, preferring line breaks.
+func splitTokens(text string, tokens int) []string {
+	limit := max(tokens, 1) * 4
+	var pieces []string
+	runes := []rune(text)
+	for len(runes) > limit {
+		cut := limit
+		for i := limit; i > limit/2; i-- {
+			if runes[i-1] == '\n' {
+				cut = i
+				break
+			}
+		}
+		pieces = append(pieces, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	return append(pieces, string(runes))
+}
+
+// clipTokens shortens text to about tokens tokens, on a rune boundary.
+func clipTokens(text string, tokens int) string {
+	runes := []rune(text)
+	if limit := max(tokens, 1) * 4; len(runes) > limit {
+		return string(runes[:limit-1]) + "…"
+	}
+	return text
+}
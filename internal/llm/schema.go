@@ -0,0 +1,321 @@
+// CLAUDE:SUMMARY JSON Schema for structured output — schema parsing, validation of the subset providers honour, JSON extraction from replies, minimal conforming instances
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Schema asks for structured output: the response must be one JSON value
+// that matches Doc, a JSON Schema document. Validation covers the subset
+// that provider structured-output modes accept: type, properties, required,
+// additionalProperties (false), items, enum, minimum/maximum,
+// minItems/maxItems and minLength/maxLength.
+type Schema struct {
+	Name string          `json:"name"` // identifier sent to providers that require one
+	Doc  json.RawMessage `json:"doc"`
+
+	once sync.Once
+	root *schemaNode
+	err  error
+}
+
+// ParseSchema checks that doc is a usable JSON Schema document.
+func ParseSchema(name, doc string) (*Schema, error) {
+	s := &Schema{Name: name, Doc: json.RawMessage(doc)}
+	if _, err := s.node(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustSchema is ParseSchema for package-level schemas; it panics on error.
+func MustSchema(name, doc string) *Schema {
+	s, err := ParseSchema(name, doc)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Schema) node() (*schemaNode, error) {
+	s.once.Do(func() {
+		var n schemaNode
+		if err := json.Unmarshal(s.Doc, &n); err != nil {
+			s.err = fmt.Errorf("schema %s: %w", s.Name, err)
+			return
+		}
+		s.root = &n
+	})
+	return s.root, s.err
+}
+
+// Check extracts the JSON value from a model reply (tolerating code fences
+// and surrounding prose) and validates it. It returns the JSON and the list
+// of problems, empty when the reply conforms.
+func (s *Schema) Check(content string) (json.RawMessage, []string) {
+	root, err := s.node()
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	raw, ok := extractJSON(content)
+	if !ok {
+		return nil, []string{"reply is not valid JSON"}
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, []string{"reply is not valid JSON: " + err.Error()}
+	}
+	var problems []string
+	root.validate("$", v, &problems)
+	return raw, problems
+}
+
+// Sample returns a minimal instance that satisfies the schema; text fills
+// string values. Offline stand-ins use it to answer structured requests.
+func (s *Schema) Sample(text string) json.RawMessage {
+	root, err := s.node()
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	b, _ := json.Marshal(root.sample(text))
+	return b
+}
+
+// schemaTypes accepts "type": "string" as well as "type": ["string", "null"].
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = many
+	return nil
+}
+
+type schemaNode struct {
+	Type                 schemaTypes            `json:"type"`
+	Properties           map[string]*schemaNode `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *schemaNode            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+}
+
+func (n *schemaNode) validate(path string, v interface{}, problems *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(n.Type) > 0 && !n.typeMatches(v) {
+		fail("expected %s, got %s", strings.Join(n.Type, " or "), jsonType(v))
+		return
+	}
+	if len(n.Enum) > 0 && !enumContains(n.Enum, v) {
+		fail("must be one of %s", enumList(n.Enum))
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for _, name := range n.Required {
+			if _, ok := x[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := n.Properties[name]; ok {
+				prop.validate(path+"."+name, x[name], problems)
+			} else if string(n.AdditionalProperties) == "false" {
+				fail("unexpected property %q", name)
+			}
+		}
+	case []interface{}:
+		if n.MinItems != nil && len(x) < *n.MinItems {
+			fail("must have at least %d items", *n.MinItems)
+		}
+		if n.MaxItems != nil && len(x) > *n.MaxItems {
+			fail("must have at most %d items", *n.MaxItems)
+		}
+		if n.Items != nil {
+			for i, item := range x {
+				n.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+	case string:
+		if n.MinLength != nil && len([]rune(x)) < *n.MinLength {
+			fail("must be at least %d characters", *n.MinLength)
+		}
+		if n.MaxLength != nil && len([]rune(x)) > *n.MaxLength {
+			fail("must be at most %d characters", *n.MaxLength)
+		}
+	case float64:
+		if n.Minimum != nil && x < *n.Minimum {
+			fail("must be >= %g", *n.Minimum)
+		}
+		if n.Maximum != nil && x > *n.Maximum {
+			fail("must be <= %g", *n.Maximum)
+		}
+	}
+}
+
+func (n *schemaNode) typeMatches(v interface{}) bool {
+	actual := jsonType(v)
+	for _, t := range n.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// sample builds the smallest value the node accepts.
+func (n *schemaNode) sample(text string) interface{} {
+	if len(n.Enum) > 0 {
+		return n.Enum[0]
+	}
+	t := ""
+	if len(n.Type) > 0 {
+		t = n.Type[0]
+	} else if n.Properties != nil {
+		t = "object"
+	}
+	switch t {
+	case "object":
+		obj := make(map[string]interface{}, len(n.Required))
+		for _, name := range n.Required {
+			if prop, ok := n.Properties[name]; ok {
+				obj[name] = prop.sample(text)
+			} else {
+				obj[name] = nil
+			}
+		}
+		return obj
+	case "array":
+		arr := []interface{}{}
+		if n.Items != nil && n.MinItems != nil {
+			for i := 0; i < *n.MinItems; i++ {
+				arr = append(arr, n.Items.sample(text))
+			}
+		}
+		return arr
+	case "string":
+		s := text
+		if n.MinLength != nil && len([]rune(s)) < *n.MinLength {
+			s += strings.Repeat(".", *n.MinLength-len([]rune(s)))
+		}
+		if n.MaxLength != nil && len([]rune(s)) > *n.MaxLength {
+			s = string([]rune(s)[:*n.MaxLength])
+		}
+		return s
+	case "number", "integer":
+		switch {
+		case n.Minimum != nil:
+			return math.Ceil(*n.Minimum)
+		case n.Maximum != nil && *n.Maximum < 0:
+			return math.Floor(*n.Maximum)
+		}
+		return 0
+	case "boolean":
+		return false
+	}
+	return nil
+}
+
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == math.Trunc(x) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func enumContains(enum []interface{}, v interface{}) bool {
+	b, _ := json.Marshal(v)
+	for _, e := range enum {
+		eb, _ := json.Marshal(e)
+		if string(eb) == string(b) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	parts := make([]string, len(enum))
+	for i, e := range enum {
+		b, _ := json.Marshal(e)
+		parts[i] = string(b)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// extractJSON pulls the JSON value out of a model reply: as-is, inside a
+// ``` fence, or between the first opening and last closing bracket.
+func extractJSON(content string) (json.RawMessage, bool) {
+	s := strings.TrimSpace(content)
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s), true
+	}
+	if i := strings.Index(s, "```"); i >= 0 {
+		inner := s[i+3:]
+		if nl := strings.IndexByte(inner, '\n'); nl >= 0 {
+			inner = inner[nl+1:] // drop the ```json language tag
+		}
+		if j := strings.Index(inner, "```"); j >= 0 {
+			inner = strings.TrimSpace(inner[:j])
+			if json.Valid([]byte(inner)) {
+				return json.RawMessage(inner), true
+			}
+		}
+	}
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return nil, false
+	}
+	closer := "}"
+	if s[start] == '[' {
+		closer = "]"
+	}
+	end := strings.LastIndex(s, closer)
+	if end <= start {
+		return nil, false
+	}
+	if inner := s[start : end+1]; json.Valid([]byte(inner)) {
+		return json.RawMessage(inner), true
+	}
+	return nil, false
+}
@@ -0,0 +1,178 @@
+// CLAUDE:SUMMARY Streaming completions — StreamProvider capability, Client.Stream/StreamWith with fallback, SSE line reader
+package llm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StreamFunc receives each content delta as it arrives from the provider.
+// Returning an error aborts the stream (e.g. the HTTP client went away).
+type StreamFunc func(delta string) error
+
+// StreamProvider is implemented by providers that can stream token deltas.
+// Stream calls fn for each delta and returns the final Response (full content
+// plus usage) once the provider signals the end of the stream.
+type StreamProvider interface {
+	Provider
+	Stream(ctx context.Context, req Request, fn StreamFunc) (*Response, error)
+}
+
+// Stream sends a request like Complete but delivers content deltas to fn.
+// Fallback to the next provider only happens while nothing has been emitted;
+// once a delta reached the caller, an error is returned as-is.
+func (c *Client) Stream(ctx context.Context, req Request, fn StreamFunc) (*Response, error) {
+	provider, model := splitModel(req.Model)
+	if provider != "" {
+		req.Model = model
+		if c.HasProvider(provider) {
+			resp, _, err := c.stream(ctx, provider, req, fn)
+			return resp, err
+		}
+	}
+
+	lastErr := noProvider(provider)
+	for _, name := range c.fallbackOrder() {
+		resp, emitted, err := c.stream(ctx, name, req, fn)
+		if err != nil {
+			if emitted {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// StreamWith streams a request from a specific named provider.
+func (c *Client) StreamWith(ctx context.Context, providerName string, req Request, fn StreamFunc) (*Response, error) {
+	if !c.HasProvider(providerName) {
+		return nil, &ProviderError{Provider: providerName, Err: ErrProviderNotFound}
+	}
+	resp, _, err := c.stream(ctx, providerName, req, fn)
+	return resp, err
+}
+
+// stream runs streamProvider through the provider's circuit breaker. A
+// cache hit, like structured output (validated as a whole) and tool-calling
+// turns, is delivered as a single delta.
+func (c *Client) stream(ctx context.Context, name string, req Request, fn StreamFunc) (*Response, bool, error) {
+	if req.Schema != nil || len(req.Tools) > 0 {
+		resp, err := c.complete(ctx, name, req)
+		if err != nil || resp.Content == "" {
+			return resp, false, err
+		}
+		if err := fn(resp.Content); err != nil {
+			return nil, true, fmt.Errorf("%w: %v", errStreamAborted, err)
+		}
+		return resp, true, nil
+	}
+
+	hit, store := c.fromCache(ctx, name, req)
+	if hit != nil {
+		if hit.Content == "" {
+			return hit, false, nil
+		}
+		if err := fn(hit.Content); err != nil {
+			return nil, true, fmt.Errorf("%w: %v", errStreamAborted, err)
+		}
+		return hit, true, nil
+	}
+
+	var emitted bool
+	resp, err := c.call(ctx, name, req, func(p Provider) (*Response, error) {
+		var r *Response
+		var err error
+		r, emitted, err = streamProvider(ctx, p, req, fn)
+		return r, err
+	})
+	if err == nil {
+		store(resp)
+	}
+	return resp, emitted, err
+}
+
+// streamProvider streams from p if it supports it, otherwise completes the
+// request and emits the whole content as a single delta. The emitted flag
+// reports whether fn was called at least once.
+func streamProvider(ctx context.Context, p Provider, req Request, fn StreamFunc) (*Response, bool, error) {
+	emitted := false
+	track := func(delta string) error {
+		if delta == "" {
+			return nil
+		}
+		emitted = true
+		if err := fn(delta); err != nil {
+			return fmt.Errorf("%w: %v", errStreamAborted, err)
+		}
+		return nil
+	}
+
+	sp, ok := p.(StreamProvider)
+	if !ok {
+		resp, err := p.Complete(ctx, req)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := track(resp.Content); err != nil {
+			return nil, emitted, err
+		}
+		return resp, emitted, nil
+	}
+	resp, err := sp.Stream(ctx, req, track)
+	return resp, emitted, err
+}
+
+// readSSE parses a text/event-stream body and calls fn with the event name
+// and data payload of each event. A "data: [DONE]" sentinel ends the stream.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var event string
+	var data []string
+	dispatch := func() error {
+		if len(data) == 0 {
+			event = ""
+			return nil
+		}
+		payload := strings.Join(data, "\n")
+		ev := event
+		event, data = "", nil
+		if payload == "[DONE]" {
+			return io.EOF
+		}
+		return fn(ev, payload)
+	}
+
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// comment / keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if err := dispatch(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// CLAUDE:SUMMARY Structured output — schema instructions, validation of provider replies and bounded repair rounds for Request.Schema
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// defaultRepairs is the number of repair rounds after a reply that does not
+// match its schema when Request.Repairs is 0.
+const defaultRepairs = 2
+
+// Decode unmarshals the validated structured output into v. It fails when
+// the request had no Schema.
+func (r *Response) Decode(v interface{}) error {
+	if len(r.JSON) == 0 {
+		return fmt.Errorf("response has no structured output")
+	}
+	return json.Unmarshal(r.JSON, v)
+}
+
+// accept validates a cached response against the schema, filling in its
+// JSON. A nil schema accepts anything.
+func (s *Schema) accept(resp *Response) bool {
+	if s == nil {
+		return true
+	}
+	raw, problems := s.Check(resp.Content)
+	if len(problems) > 0 {
+		return false
+	}
+	resp.JSON = raw
+	return true
+}
+
+// generate calls the named provider. With a Schema, the request carries
+// the schema in its system prompt (on top of any native JSON mode of the
+// provider), and a reply that does not conform is sent back with the list
+// of problems for up to Repairs rounds. Tokens, cost and latency of every
+// round are summed into the returned response.
+func (c *Client) generate(ctx context.Context, name string, req Request) (*Response, error) {
+	if req.Schema == nil {
+		return c.call(ctx, name, req, func(p Provider) (*Response, error) {
+			return p.Complete(ctx, req)
+		})
+	}
+
+	repairs := req.Repairs
+	if repairs <= 0 {
+		repairs = defaultRepairs
+	}
+	sreq := withSchemaInstructions(req)
+
+	var spent Response
+	for round := 0; ; round++ {
+		resp, err := c.call(ctx, name, sreq, func(p Provider) (*Response, error) {
+			return p.Complete(ctx, sreq)
+		})
+		if err != nil {
+			return nil, err
+		}
+		spent.TokensIn += resp.TokensIn
+		spent.TokensOut += resp.TokensOut
+		spent.CostUSD += resp.CostUSD
+		spent.Latency += resp.Latency
+
+		// A tool-calling turn is not the answer yet: CompleteTools sends
+		// the results back and the final turn is the one validated.
+		raw, problems := req.Schema.Check(resp.Content)
+		if len(problems) == 0 || len(resp.ToolCalls) > 0 {
+			if len(problems) == 0 {
+				resp.JSON = raw
+			}
+			resp.Repairs = round
+			resp.TokensIn, resp.TokensOut = spent.TokensIn, spent.TokensOut
+			resp.CostUSD, resp.Latency = spent.CostUSD, spent.Latency
+			return resp, nil
+		}
+		if round >= repairs {
+			return nil, &ProviderError{Provider: name, Model: req.Model,
+				Err: fmt.Errorf("%w after %d repair round(s): %s", ErrSchemaMismatch, round, strings.Join(problems, "; "))}
+		}
+		sreq.Messages = append(sreq.Messages,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: repairPrompt(problems)})
+	}
+}
+
+// withSchemaInstructions returns a copy of req whose system prompt asks for
+// a single JSON value matching the schema.
+func withSchemaInstructions(req Request) Request {
+	instr := "Respond with a single JSON value that conforms to the following JSON Schema. " +
+		"Output only the JSON: no prose, no markdown code fences.\n\n" + string(req.Schema.Doc)
+
+	messages := make([]Message, 0, len(req.Messages)+1)
+	merged := false
+	for _, m := range req.Messages {
+		if m.Role == "system" && !merged {
+			m.Content = strings.TrimSpace(m.Content) + "\n\n" + instr
+			merged = true
+		}
+		messages = append(messages, m)
+	}
+	if !merged {
+		messages = append([]Message{{Role: "system", Content: instr}}, messages...)
+	}
+	req.Messages = messages
+	return req
+}
+
+func repairPrompt(problems []string) string {
+	const maxListed = 10
+	if len(problems) > maxListed {
+		problems = append(problems[:maxListed:maxListed], fmt.Sprintf("... and %d more", len(problems)-maxListed))
+	}
+	return "Your reply does not match the required JSON Schema:\n- " + strings.Join(problems, "\n- ") +
+		"\n\nReply again with the corrected JSON only."
+}
@@ -0,0 +1,121 @@
+// CLAUDE:SUMMARY Prompt templates — text/template with a curated FuncMap and strict missing keys, shared by thinking flows and workflow steps, with save-time validation
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// ErrTemplate marks a prompt template that does not parse or does not
+// render against its data. Retrying cannot help.
+var ErrTemplate = errors.New("template error")
+
+// templateFuncs is everything a template can call besides the text/template
+// builtins. Templates only ever see plain maps and strings, so there are no
+// methods to reach through the data either.
+var templateFuncs = template.FuncMap{
+	// truncate keeps the first n characters: {{.Body | truncate 2000}}
+	"truncate": func(n int, s string) string {
+		if n < 0 || utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		return string([]rune(s)[:n]) + "…"
+	},
+	// json encodes a value: {{json .Step}}
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// join concatenates a list: {{join ", " .Tags}}
+	"join": func(sep string, v interface{}) (string, error) {
+		switch list := v.(type) {
+		case []string:
+			return strings.Join(list, sep), nil
+		case []interface{}:
+			parts := make([]string, len(list))
+			for i, item := range list {
+				parts[i] = fmt.Sprint(item)
+			}
+			return strings.Join(parts, sep), nil
+		default:
+			return "", fmt.Errorf("join: %T is not a list", v)
+		}
+	},
+	// default replaces an empty value: {{.PrePrompt | default "No instructions."}}
+	"default": func(def string, v interface{}) string {
+		if v == nil || fmt.Sprint(v) == "" {
+			return def
+		}
+		return fmt.Sprint(v)
+	},
+	// indent prefixes every line with n spaces: {{.Step.draft | indent 4}}
+	"indent": func(n int, s string) string {
+		pad := strings.Repeat(" ", max(n, 0))
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+}
+
+// parseTemplate parses a prompt template. Referencing a key the data does
+// not have is an error when rendering, not an empty string.
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTemplate, strings.TrimPrefix(err.Error(), "template: "))
+	}
+	return t, nil
+}
+
+// executeTemplate renders text against data. The empty template renders
+// to the empty string.
+func executeTemplate(name, text string, data map[string]interface{}) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	t, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrTemplate, strings.TrimPrefix(err.Error(), "template: "))
+	}
+	return b.String(), nil
+}
+
+// checkTemplate renders text against sample data, with steps as the steps
+// whose responses exist by then. It catches syntax errors, unknown
+// functions, misspelled keys and references to steps that have not run yet.
+func checkTemplate(name, text string, sample map[string]interface{}, steps []string) error {
+	data := make(map[string]interface{}, len(sample)+1)
+	for k, v := range sample {
+		data[k] = v
+	}
+	stepData := make(map[string]string, len(steps))
+	for _, s := range steps {
+		stepData[s] = "sample"
+	}
+	data["Step"] = stepData
+	if _, err := executeTemplate(name, text, data); err != nil {
+		return templateKeyHint(err, steps)
+	}
+	return nil
+}
+
+// templateKeyHint adds the step names that can be referenced to a missing
+// key error, which otherwise only names the key.
+func templateKeyHint(err error, steps []string) error {
+	if !strings.Contains(err.Error(), "map has no entry for key") {
+		return err
+	}
+	if len(steps) == 0 {
+		return fmt.Errorf("%w (no earlier step to reference)", err)
+	}
+	sorted := append([]string(nil), steps...)
+	sort.Strings(sorted)
+	return fmt.Errorf("%w (earlier steps: %s)", err, strings.Join(sorted, ", "))
+}
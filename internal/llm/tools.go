@@ -0,0 +1,191 @@
+// CLAUDE:SUMMARY Tool calling — tool definitions, toolsets, and the CompleteTools loop that runs model tool calls and feeds results back under an iteration guard
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// defaultToolIterations bounds the rounds of tool calls CompleteTools
+// allows before the model must answer.
+const defaultToolIterations = 5
+
+// maxToolResult caps what a single tool call hands back to the model.
+const maxToolResult = 16000
+
+// Tool is a function the model may call. Parameters is the JSON Schema of
+// its arguments object.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolCall is the model's request to run a tool. ID ties the result
+// message back to the call (providers without call IDs get synthetic ones).
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolRound is one tool round-trip, as persisted with the step.
+type ToolRound struct {
+	Iteration int             `json:"iteration"`
+	ID        string          `json:"id"`
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments"`
+	Result    string          `json:"result"`
+	Error     string          `json:"error,omitempty"`
+	LatencyMs int64           `json:"latency_ms"`
+}
+
+// ToolFunc runs a tool with the model's arguments and returns the text
+// handed back to the model.
+type ToolFunc func(ctx context.Context, args json.RawMessage) (string, error)
+
+// Toolset is a named collection of tools and their implementations.
+type Toolset struct {
+	tools map[string]Tool
+	funcs map[string]ToolFunc
+}
+
+// NewToolset creates an empty toolset.
+func NewToolset() *Toolset {
+	return &Toolset{tools: make(map[string]Tool), funcs: make(map[string]ToolFunc)}
+}
+
+// Add registers a tool, replacing any tool of the same name.
+func (t *Toolset) Add(tool Tool, fn ToolFunc) {
+	t.tools[tool.Name] = tool
+	t.funcs[tool.Name] = fn
+}
+
+// Tools returns the tool definitions sorted by name.
+func (t *Toolset) Tools() []Tool {
+	out := make([]Tool, 0, len(t.tools))
+	for _, tool := range t.tools {
+		out = append(out, tool)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Select returns the subset of tools a step opted into. An unknown name is
+// an error so that a typo in a step definition does not silently drop a tool.
+func (t *Toolset) Select(names []string) (*Toolset, error) {
+	sub := NewToolset()
+	for _, name := range names {
+		tool, ok := t.tools[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		sub.Add(tool, t.funcs[name])
+	}
+	return sub, nil
+}
+
+// Call runs one tool call. The result is truncated to maxToolResult.
+func (t *Toolset) Call(ctx context.Context, call ToolCall) (string, error) {
+	fn, ok := t.funcs[call.Name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", call.Name)
+	}
+	out, err := fn(ctx, call.Arguments)
+	if err != nil {
+		return "", err
+	}
+	if len(out) > maxToolResult {
+		out = out[:maxToolResult] + "\n[truncated]"
+	}
+	return out, nil
+}
+
+// CompleteTools sends req with the toolset offered to the model, runs the
+// tool calls it makes and sends the results back, until the model answers
+// without calling a tool. provider "" uses the fallback chain for the first
+// turn; later turns stay on the provider that answered it. Tokens, cost and
+// latency of every turn are summed, and ToolRounds lists every round-trip.
+//
+// After maxIterations rounds of calls (0 = 5) it fails with ErrToolLimit.
+// On that error, and on a provider error after the first round, the partial
+// response is returned alongside the error so its rounds can be persisted.
+func (c *Client) CompleteTools(ctx context.Context, provider string, req Request, tools *Toolset, maxIterations int) (*Response, error) {
+	if maxIterations <= 0 {
+		maxIterations = defaultToolIterations
+	}
+	req.Tools = tools.Tools()
+	req.Messages = append([]Message(nil), req.Messages...)
+
+	var spent Response
+	for iteration := 1; ; iteration++ {
+		var resp *Response
+		var err error
+		if provider != "" {
+			resp, err = c.CompleteWith(ctx, provider, req)
+		} else {
+			resp, err = c.Complete(ctx, req)
+		}
+		if err != nil {
+			if len(spent.ToolRounds) == 0 {
+				return nil, err
+			}
+			return &spent, err
+		}
+		provider = resp.Provider
+		spent.Provider, spent.Model = resp.Provider, resp.Model
+		spent.TokensIn += resp.TokensIn
+		spent.TokensOut += resp.TokensOut
+		spent.CostUSD += resp.CostUSD
+		spent.Latency += resp.Latency
+
+		if len(resp.ToolCalls) == 0 {
+			resp.TokensIn, resp.TokensOut = spent.TokensIn, spent.TokensOut
+			resp.CostUSD, resp.Latency = spent.CostUSD, spent.Latency
+			resp.ToolRounds = spent.ToolRounds
+			return resp, nil
+		}
+		if iteration > maxIterations {
+			spent.FinishReason = resp.FinishReason
+			return &spent, &ProviderError{Provider: resp.Provider, Model: resp.Model,
+				Err: fmt.Errorf("%w: still calling tools after %d round(s)", ErrToolLimit, maxIterations)}
+		}
+
+		req.Messages = append(req.Messages, Message{Role: "assistant", Content: resp.Content, ToolCalls: resp.ToolCalls})
+		for _, call := range resp.ToolCalls {
+			start := time.Now()
+			result, callErr := tools.Call(ctx, call)
+			round := ToolRound{
+				Iteration: iteration,
+				ID:        call.ID,
+				Tool:      call.Name,
+				Arguments: call.Arguments,
+				Result:    result,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			if callErr != nil {
+				round.Error = callErr.Error()
+				result = "error: " + callErr.Error()
+			}
+			spent.ToolRounds = append(spent.ToolRounds, round)
+			req.Messages = append(req.Messages, Message{Role: "tool", Content: result, ToolCallID: call.ID, Name: call.Name})
+		}
+	}
+}
+
+// toolArguments normalises the arguments a provider returned: empty means
+// no arguments, and text that is not JSON is kept as a JSON string so the
+// tool can report it.
+func toolArguments(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("{}")
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	b, _ := json.Marshal(s)
+	return b
+}
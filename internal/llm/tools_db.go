@@ -0,0 +1,226 @@
+// CLAUDE:SUMMARY Built-in read-only toolset backed by db.DB — search_nodes, get_tree, get_sources, get_5w1h over public nodes, for flow and workflow steps
+package llm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// NewDBToolset returns the built-in tools steps can opt into by name. They
+// are read-only and only see public nodes; results are compact JSON.
+func NewDBToolset(database *db.DB) *Toolset {
+	t := NewToolset()
+	t.Add(Tool{
+		Name:        "search_nodes",
+		Description: "Full-text search across public nodes (questions, claims, pieces). Returns id, type, body excerpt and score.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"query": {"type": "string", "description": "Words to search for"},
+				"limit": {"type": "integer", "description": "Max results (default 10, max 20)"}
+			},
+			"required": ["query"]
+		}`),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args struct {
+			Query string `json:"query"`
+			Limit int    `json:"limit"`
+		}
+		if err := decodeToolArgs(raw, &args); err != nil {
+			return "", err
+		}
+		if args.Limit <= 0 || args.Limit > 20 {
+			args.Limit = 10
+		}
+		query := ftsQuery(args.Query)
+		if query == "" {
+			return "", fmt.Errorf("query is required")
+		}
+		nodes, err := database.SearchNodes(query, args.Limit)
+		if err != nil {
+			return "", err
+		}
+		results := make([]toolNode, 0, len(nodes))
+		for _, n := range nodes {
+			results = append(results, compactNode(n, 500))
+		}
+		return toolJSON(map[string]interface{}{"results": results, "count": len(results)})
+	})
+
+	t.Add(Tool{
+		Name:        "get_tree",
+		Description: "Retrieve a public proof tree (a node and its descendants) by node id.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"node_id": {"type": "string", "description": "Node id"},
+				"max_depth": {"type": "integer", "description": "Levels below the node (default 3, max 6)"}
+			},
+			"required": ["node_id"]
+		}`),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args struct {
+			NodeID   string `json:"node_id"`
+			MaxDepth int    `json:"max_depth"`
+		}
+		if err := decodeToolArgs(raw, &args); err != nil {
+			return "", err
+		}
+		if args.MaxDepth <= 0 || args.MaxDepth > 6 {
+			args.MaxDepth = 3
+		}
+		tree, err := database.GetTree(args.NodeID, args.MaxDepth)
+		if err != nil || tree.Visibility != "public" {
+			return "", notFound("node", args.NodeID, err)
+		}
+		return toolJSON(compactTree(tree))
+	})
+
+	t.Add(Tool{
+		Name:        "get_sources",
+		Description: "List the sources attached to a public node: URL, title, domain, trust score and an excerpt.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"node_id": {"type": "string", "description": "Node id"}
+			},
+			"required": ["node_id"]
+		}`),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args struct {
+			NodeID string `json:"node_id"`
+		}
+		if err := decodeToolArgs(raw, &args); err != nil {
+			return "", err
+		}
+		node, err := database.GetNode(args.NodeID)
+		if err != nil || node.Visibility != "public" {
+			return "", notFound("node", args.NodeID, err)
+		}
+		sources, err := database.GetSourcesByNode(node.ID)
+		if err != nil {
+			return "", err
+		}
+		results := make([]map[string]interface{}, 0, len(sources))
+		for _, s := range sources {
+			entry := map[string]interface{}{"id": s.ID, "trust_score": s.TrustScore}
+			if s.URL != nil {
+				entry["url"] = *s.URL
+			}
+			if s.Title != nil {
+				entry["title"] = *s.Title
+			}
+			if s.Domain != nil {
+				entry["domain"] = *s.Domain
+			}
+			if s.ContentText != nil {
+				entry["excerpt"] = truncate(*s.ContentText, 1000)
+			}
+			results = append(results, entry)
+		}
+		return toolJSON(map[string]interface{}{"sources": results, "count": len(results)})
+	})
+
+	t.Add(Tool{
+		Name:        "get_5w1h",
+		Description: "Get the 5W1H breakdown (who, what, when, where, why, how) extracted from a source.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"source_id": {"type": "string", "description": "Source id, as returned by get_sources"}
+			},
+			"required": ["source_id"]
+		}`),
+	}, func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args struct {
+			SourceID string `json:"source_id"`
+		}
+		if err := decodeToolArgs(raw, &args); err != nil {
+			return "", err
+		}
+		source, err := database.GetSource(args.SourceID)
+		if err != nil {
+			return "", notFound("source", args.SourceID, err)
+		}
+		if node, nodeErr := database.GetNode(source.NodeID); nodeErr != nil || node.Visibility != "public" {
+			return "", notFound("source", args.SourceID, nil)
+		}
+		entries, err := database.GetSource5W1H(source.ID)
+		if err != nil {
+			return "", err
+		}
+		dims := make([]map[string]interface{}, 0, len(entries))
+		for _, e := range entries {
+			dims = append(dims, map[string]interface{}{
+				"dimension":  e.Dimension,
+				"content":    e.Content,
+				"confidence": e.Confidence,
+			})
+		}
+		return toolJSON(map[string]interface{}{"source_id": source.ID, "dimensions": dims})
+	})
+	return t
+}
+
+// toolNode is the view of a node handed to the model.
+type toolNode struct {
+	ID       string     `json:"id"`
+	ParentID *string    `json:"parent_id,omitempty"`
+	Type     string     `json:"node_type"`
+	Body     string     `json:"body"`
+	Score    int        `json:"score"`
+	Children []toolNode `json:"children,omitempty"`
+}
+
+func compactNode(n *db.Node, maxBody int) toolNode {
+	return toolNode{ID: n.ID, ParentID: n.ParentID, Type: n.NodeType, Body: truncate(n.Body, maxBody), Score: n.Score}
+}
+
+// compactTree keeps public descendants only.
+func compactTree(n *db.Node) toolNode {
+	out := compactNode(n, 2000)
+	for _, child := range n.Children {
+		if child.Visibility == "public" {
+			out.Children = append(out.Children, compactTree(child))
+		}
+	}
+	return out
+}
+
+// ftsQuery turns free text into an FTS5 query matching any of its words,
+// so punctuation in a model's query cannot break the MATCH syntax.
+func ftsQuery(text string) string {
+	var terms []string
+	for _, w := range strings.Fields(text) {
+		terms = append(terms, `"`+strings.ReplaceAll(w, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+func decodeToolArgs(raw json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func notFound(kind, id string, err error) error {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return fmt.Errorf("%s %q not found", kind, id)
+}
+
+func toolJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,600 @@
+// CLAUDE:SUMMARY Workflow bundles — signed, self-contained JSON/TOML export of a workflow version with its criteria lists and model requirements, and its verified import as a new workflow pending validation
+package llm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// BundleFormat identifies a workflow bundle document.
+const BundleFormat = "horostracker.workflow-bundle"
+
+const (
+	bundleVersion = 1
+	bundleKeyName = "workflow_bundle"
+)
+
+var (
+	// ErrInvalidBundle is returned for a bundle that cannot be imported as
+	// it is: malformed, unsigned, tampered with or failing the step checks.
+	ErrInvalidBundle = errors.New("invalid bundle")
+	// ErrUntrustedBundle is returned for a bundle signed by a key this
+	// instance does not trust.
+	ErrUntrustedBundle = errors.New("bundle signed by an untrusted key")
+	// ErrBundleDenied is returned when the importer's role or model grants
+	// do not allow one of the bundle's steps.
+	ErrBundleDenied = errors.New("bundle not allowed")
+	// ErrBundleConflict is returned when the workflow's name is taken.
+	ErrBundleConflict = errors.New("workflow name already exists")
+)
+
+// WorkflowBundle is a workflow version with everything it needs to be
+// recreated on another instance. Steps reference criteria lists by name;
+// Models lists what the steps need from their models. Its fields carry
+// both JSON and TOML tags: the signature covers the JSON encoding of the
+// bundle without it, whichever form the bundle travelled in.
+type WorkflowBundle struct {
+	Format        string               `json:"format" toml:"format"`
+	BundleVersion int                  `json:"bundle_version" toml:"bundle_version"`
+	ExportedAt    string               `json:"exported_at" toml:"exported_at"`
+	Source        string               `json:"source,omitempty" toml:"source,omitempty"`
+	Workflow      BundleWorkflow       `json:"workflow" toml:"workflow"`
+	Steps         []BundleStep         `json:"steps,omitempty" toml:"steps,omitempty"`
+	CriteriaLists []BundleCriteriaList `json:"criteria_lists,omitempty" toml:"criteria_lists,omitempty"`
+	Models        []BundleModel        `json:"models,omitempty" toml:"models,omitempty"`
+	Signature     *BundleSignature     `json:"signature,omitempty" toml:"signature,omitempty"`
+}
+
+// BundleWorkflow is the workflow's own definition; Version is the version
+// it was exported from.
+type BundleWorkflow struct {
+	Name              string `json:"name" toml:"name"`
+	Description       string `json:"description,omitempty" toml:"description,omitempty"`
+	WorkflowType      string `json:"workflow_type" toml:"workflow_type"`
+	PrePromptTemplate string `json:"pre_prompt_template,omitempty" toml:"pre_prompt_template,omitempty"`
+	Version           int    `json:"version" toml:"version"`
+}
+
+// BundleStep is a step without its IDs. DependsOn keeps nil (the previous
+// step_order) apart from an empty list (no dependency).
+type BundleStep struct {
+	StepOrder      int       `json:"step_order" toml:"step_order"`
+	StepName       string    `json:"step_name" toml:"step_name"`
+	StepType       string    `json:"step_type" toml:"step_type"`
+	Provider       string    `json:"provider,omitempty" toml:"provider,omitempty"`
+	Model          string    `json:"model,omitempty" toml:"model,omitempty"`
+	PromptTemplate string    `json:"prompt_template,omitempty" toml:"prompt_template,omitempty"`
+	SystemPrompt   string    `json:"system_prompt,omitempty" toml:"system_prompt,omitempty"`
+	ConfigJSON     string    `json:"config_json,omitempty" toml:"config_json,omitempty"`
+	CriteriaList   string    `json:"criteria_list,omitempty" toml:"criteria_list,omitempty"`
+	TimeoutMs      int       `json:"timeout_ms" toml:"timeout_ms"`
+	RetryMax       int       `json:"retry_max" toml:"retry_max"`
+	FanGroup       string    `json:"fan_group,omitempty" toml:"fan_group,omitempty"`
+	DependsOn      *[]string `json:"depends_on,omitempty" toml:"depends_on,omitempty"`
+}
+
+// BundleCriteriaList is a criteria list the steps reference.
+type BundleCriteriaList struct {
+	Name        string   `json:"name" toml:"name"`
+	Description string   `json:"description,omitempty" toml:"description,omitempty"`
+	Items       []string `json:"items" toml:"items"`
+}
+
+// BundleModel is a model the steps call, with the step types using it and
+// the capabilities they need from it.
+type BundleModel struct {
+	Provider     string   `json:"provider,omitempty" toml:"provider,omitempty"`
+	Model        string   `json:"model" toml:"model"`
+	StepTypes    []string `json:"step_types" toml:"step_types"`
+	Capabilities []string `json:"capabilities,omitempty" toml:"capabilities,omitempty"`
+}
+
+// BundleSignature is the exporting instance's signature of the bundle.
+type BundleSignature struct {
+	Algorithm string `json:"algorithm" toml:"algorithm"`
+	PublicKey string `json:"public_key" toml:"public_key"`
+	Value     string `json:"value" toml:"value"`
+}
+
+// ParseBundle reads a bundle in either form: JSON when it starts with "{",
+// TOML otherwise.
+func ParseBundle(data []byte) (*WorkflowBundle, error) {
+	b := &WorkflowBundle{}
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(trimmed, b)
+	} else {
+		_, err = toml.Decode(string(data), b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if b.Format != BundleFormat {
+		return nil, fmt.Errorf("%w: format %q, want %q", ErrInvalidBundle, b.Format, BundleFormat)
+	}
+	if b.BundleVersion != bundleVersion {
+		return nil, fmt.Errorf("%w: bundle_version %d is not supported", ErrInvalidBundle, b.BundleVersion)
+	}
+	return b, nil
+}
+
+// Encode renders the bundle as JSON, or as TOML when asTOML is set.
+func (b *WorkflowBundle) Encode(asTOML bool) ([]byte, error) {
+	if !asTOML {
+		return json.MarshalIndent(b, "", "  ")
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// signedPayload is what the signature covers.
+func (b *WorkflowBundle) signedPayload() ([]byte, error) {
+	unsigned := *b
+	unsigned.Signature = nil
+	return json.Marshal(unsigned)
+}
+
+// BundleKeys signs the bundles this instance exports and decides whose
+// bundles it imports: its own and those of the configured trusted keys.
+type BundleKeys struct {
+	private ed25519.PrivateKey
+	trusted map[string]bool
+}
+
+// LoadBundleKeys loads the signing key from keyPath, a PEM PKCS#8 Ed25519
+// key, or when it is empty from flows.db, generating it on first use.
+// trusted holds the base64 public keys of the instances whose bundles are
+// accepted besides this one's.
+func LoadBundleKeys(flowsDB *db.FlowsDB, keyPath string, trusted []string) (*BundleKeys, error) {
+	k := &BundleKeys{trusted: make(map[string]bool, len(trusted)+1)}
+	if keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading signing key: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("signing key %s: no PEM block", keyPath)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", keyPath, err)
+		}
+		priv, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s: not an Ed25519 key", keyPath)
+		}
+		k.private = priv
+	} else {
+		seed, err := flowsDB.InstanceKey(bundleKeyName, func() ([]byte, error) {
+			_, priv, err := ed25519.GenerateKey(rand.Reader)
+			return priv.Seed(), err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("loading signing key: %w", err)
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("stored signing key has %d bytes, want %d", len(seed), ed25519.SeedSize)
+		}
+		k.private = ed25519.NewKeyFromSeed(seed)
+	}
+	k.trusted[k.PublicKey()] = true
+	for _, t := range trusted {
+		pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(t))
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("trusted key %q is not a base64 Ed25519 public key", t)
+		}
+		k.trusted[base64.StdEncoding.EncodeToString(pub)] = true
+	}
+	return k, nil
+}
+
+// PublicKey returns the base64 public key other instances trust this one by.
+func (k *BundleKeys) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(k.private.Public().(ed25519.PublicKey))
+}
+
+// Sign signs b with this instance's key.
+func (k *BundleKeys) Sign(b *WorkflowBundle) error {
+	payload, err := b.signedPayload()
+	if err != nil {
+		return err
+	}
+	b.Signature = &BundleSignature{
+		Algorithm: "ed25519",
+		PublicKey: k.PublicKey(),
+		Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(k.private, payload)),
+	}
+	return nil
+}
+
+// Verify checks b's signature and returns the public key that made it.
+// A bundle signed by a key that is not trusted is an ErrUntrustedBundle,
+// whatever its signature; a missing or broken one an ErrInvalidBundle.
+func (k *BundleKeys) Verify(b *WorkflowBundle) (string, error) {
+	sig := b.Signature
+	if sig == nil {
+		return "", fmt.Errorf("%w: unsigned", ErrInvalidBundle)
+	}
+	if sig.Algorithm != "ed25519" {
+		return "", fmt.Errorf("%w: signature algorithm %q is not supported", ErrInvalidBundle, sig.Algorithm)
+	}
+	pub, err := base64.StdEncoding.DecodeString(sig.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("%w: malformed signing key", ErrInvalidBundle)
+	}
+	signer := base64.StdEncoding.EncodeToString(pub)
+	if !k.trusted[signer] {
+		return "", fmt.Errorf("%w: %s", ErrUntrustedBundle, signer)
+	}
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed signature", ErrInvalidBundle)
+	}
+	payload, err := b.signedPayload()
+	if err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), payload, value) {
+		return "", fmt.Errorf("%w: signature does not match its content", ErrInvalidBundle)
+	}
+	return signer, nil
+}
+
+// ExportBundle builds the unsigned bundle of wf, a workflow version as
+// returned by GetWorkflowVersion or GetRunnableWorkflow. source names the
+// exporting instance.
+func ExportBundle(flowsDB *db.FlowsDB, wf *db.Workflow, source string) (*WorkflowBundle, error) {
+	b := &WorkflowBundle{
+		Format:        BundleFormat,
+		BundleVersion: bundleVersion,
+		ExportedAt:    time.Now().UTC().Format(time.RFC3339),
+		Source:        source,
+		Workflow: BundleWorkflow{
+			Name:              wf.Name,
+			Description:       wf.Description,
+			WorkflowType:      wf.WorkflowType,
+			PrePromptTemplate: wf.PrePromptTemplate,
+			Version:           wf.Version,
+		},
+	}
+
+	lists := make(map[string]string) // list_id → name
+	models := make(map[string]*BundleModel)
+	for _, s := range wf.Steps {
+		bs := BundleStep{
+			StepOrder:      s.StepOrder,
+			StepName:       s.StepName,
+			StepType:       s.StepType,
+			Provider:       s.Provider,
+			Model:          s.Model,
+			PromptTemplate: s.PromptTemplate,
+			SystemPrompt:   s.SystemPrompt,
+			TimeoutMs:      s.TimeoutMs,
+			RetryMax:       s.RetryMax,
+		}
+		if s.ConfigJSON != "{}" {
+			bs.ConfigJSON = s.ConfigJSON
+		}
+		if s.FanGroup != nil {
+			bs.FanGroup = *s.FanGroup
+		}
+		if s.DependsOn != nil {
+			deps := slices.Clone(s.DependsOn)
+			bs.DependsOn = &deps
+		}
+		if s.CriteriaListID != nil && *s.CriteriaListID != "" {
+			name, ok := lists[*s.CriteriaListID]
+			if !ok {
+				cl, err := flowsDB.GetCriteriaList(*s.CriteriaListID)
+				if err != nil {
+					return nil, fmt.Errorf("step %s: criteria list %s: %w", s.StepName, *s.CriteriaListID, err)
+				}
+				var items []string
+				if err := json.Unmarshal([]byte(cl.ItemsJSON), &items); err != nil {
+					return nil, fmt.Errorf("criteria list %s: items_json: %w", cl.Name, err)
+				}
+				name = cl.Name
+				lists[cl.ListID] = name
+				b.CriteriaLists = append(b.CriteriaLists, BundleCriteriaList{
+					Name:        cl.Name,
+					Description: cl.Description,
+					Items:       items,
+				})
+			}
+			bs.CriteriaList = name
+		}
+		b.Steps = append(b.Steps, bs)
+
+		if s.Model == "" {
+			continue
+		}
+		key := s.Provider + "\x00" + s.Model
+		m, ok := models[key]
+		if !ok {
+			m = &BundleModel{Provider: s.Provider, Model: s.Model}
+			models[key] = m
+		}
+		if !slices.Contains(m.StepTypes, s.StepType) {
+			m.StepTypes = append(m.StepTypes, s.StepType)
+		}
+		for _, c := range StepCapabilities(s) {
+			if !slices.Contains(m.Capabilities, c) {
+				m.Capabilities = append(m.Capabilities, c)
+			}
+		}
+	}
+
+	for _, m := range models {
+		sort.Strings(m.StepTypes)
+		sort.Strings(m.Capabilities)
+		b.Models = append(b.Models, *m)
+	}
+	sort.Slice(b.Models, func(i, j int) bool {
+		if b.Models[i].Provider != b.Models[j].Provider {
+			return b.Models[i].Provider < b.Models[j].Provider
+		}
+		return b.Models[i].Model < b.Models[j].Model
+	})
+	sort.Slice(b.CriteriaLists, func(i, j int) bool { return b.CriteriaLists[i].Name < b.CriteriaLists[j].Name })
+	return b, nil
+}
+
+// BundleImport reports what an import created.
+type BundleImport struct {
+	Workflow      *db.Workflow           `json:"workflow"`
+	CriteriaLists []ImportedCriteriaList `json:"criteria_lists"`
+	Signer        string                 `json:"signer"`
+	Warnings      []string               `json:"warnings"`
+}
+
+// ImportedCriteriaList maps a criteria list of the bundle to the local
+// list its steps now reference: an identical list already here, or a new
+// one.
+type ImportedCriteriaList struct {
+	Name   string `json:"name"`
+	ListID string `json:"list_id"`
+	Reused bool   `json:"reused"`
+}
+
+// ImportBundle verifies b and recreates it as a new workflow owned by
+// userID, with new IDs, pending validation by an operator. The steps go
+// through the checks the step API applies for role: allowed step types,
+// model availability and grants, dependencies, templates and schemas.
+// Models the catalogue does not know are reported as warnings. name, when
+// set, replaces the bundle's workflow name.
+func ImportBundle(flowsDB *db.FlowsDB, keys *BundleKeys, b *WorkflowBundle, userID, role, name string) (*BundleImport, error) {
+	signer, err := keys.Verify(b)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = b.Workflow.Name
+	}
+	if name == "" || b.Workflow.WorkflowType == "" {
+		return nil, fmt.Errorf("%w: workflow name and workflow_type are required", ErrInvalidBundle)
+	}
+	if len(b.Steps) == 0 {
+		return nil, fmt.Errorf("%w: no steps", ErrInvalidBundle)
+	}
+
+	bundleLists := make(map[string]BundleCriteriaList, len(b.CriteriaLists))
+	for _, cl := range b.CriteriaLists {
+		bundleLists[cl.Name] = cl
+	}
+
+	imp := &BundleImport{Signer: signer, Warnings: []string{}, CriteriaLists: []ImportedCriteriaList{}}
+	allowed := db.AllowedStepTypes(role)
+	wfID := db.NewID()
+	steps := make([]db.WorkflowStep, 0, len(b.Steps))
+	seen := make(map[string]bool, len(b.Steps))
+	for _, bs := range b.Steps {
+		if bs.StepName == "" || bs.StepType == "" {
+			return nil, fmt.Errorf("%w: step_name and step_type are required", ErrInvalidBundle)
+		}
+		key := fmt.Sprintf("%d/%s", bs.StepOrder, bs.StepName)
+		if seen[key] {
+			return nil, fmt.Errorf("%w: step %s appears twice at step_order %d", ErrInvalidBundle, bs.StepName, bs.StepOrder)
+		}
+		seen[key] = true
+		if !allowed[bs.StepType] {
+			return nil, fmt.Errorf("%w: role %s cannot create %s steps", ErrBundleDenied, role, bs.StepType)
+		}
+		if bs.CriteriaList != "" {
+			if _, ok := bundleLists[bs.CriteriaList]; !ok {
+				return nil, fmt.Errorf("%w: step %s: criteria list %q is not in the bundle", ErrInvalidBundle, bs.StepName, bs.CriteriaList)
+			}
+		}
+		if bs.Model != "" {
+			switch {
+			case !flowsDB.ModelExists(bs.Model):
+				imp.Warnings = append(imp.Warnings, fmt.Sprintf("step %s: model %s is not in the catalogue", bs.StepName, bs.Model))
+			case !flowsDB.ModelIsAvailable(bs.Model):
+				return nil, fmt.Errorf("%w: step %s: model %s is not currently available", ErrInvalidBundle, bs.StepName, bs.Model)
+			}
+			if ok, explicit := flowsDB.CheckModelGrant(userID, role, bs.Model, bs.StepType); explicit && !ok {
+				return nil, fmt.Errorf("%w: model grant denied for %s on %s steps", ErrBundleDenied, bs.Model, bs.StepType)
+			}
+		}
+
+		step := db.WorkflowStep{
+			StepID:         db.NewID(),
+			WorkflowID:     wfID,
+			Version:        1,
+			StepOrder:      bs.StepOrder,
+			StepName:       bs.StepName,
+			StepType:       bs.StepType,
+			Provider:       bs.Provider,
+			Model:          bs.Model,
+			PromptTemplate: bs.PromptTemplate,
+			SystemPrompt:   bs.SystemPrompt,
+			ConfigJSON:     bs.ConfigJSON,
+			TimeoutMs:      bs.TimeoutMs,
+			RetryMax:       bs.RetryMax,
+		}
+		if step.ConfigJSON == "" {
+			step.ConfigJSON = "{}"
+		}
+		if step.TimeoutMs == 0 {
+			step.TimeoutMs = 30000
+		}
+		if step.RetryMax == 0 {
+			step.RetryMax = 2
+		}
+		if bs.FanGroup != "" {
+			fg := bs.FanGroup
+			step.FanGroup = &fg
+		}
+		if bs.DependsOn != nil {
+			step.DependsOn = slices.Clone(*bs.DependsOn)
+		}
+		if err := CheckStepModel(flowsDB, step); err != nil {
+			return nil, fmt.Errorf("%w: step %s: %v", ErrInvalidBundle, step.StepName, err)
+		}
+		if _, err := StepSchema(step); err != nil {
+			return nil, fmt.Errorf("%w: step %s: config_json: %v", ErrInvalidBundle, step.StepName, err)
+		}
+		if err := CheckNodeWriteStep(step); err != nil {
+			return nil, fmt.Errorf("%w: step %s: %v", ErrInvalidBundle, step.StepName, err)
+		}
+		steps = append(steps, step)
+	}
+	if _, err := PlanWorkflow(steps); err != nil {
+		return nil, fmt.Errorf("%w: depends_on: %v", ErrInvalidBundle, err)
+	}
+	for _, step := range steps {
+		if err := CheckStepTemplates(step, steps); err != nil {
+			return nil, fmt.Errorf("%w: step %s: %v", ErrInvalidBundle, step.StepName, err)
+		}
+	}
+
+	wf := &db.Workflow{
+		WorkflowID:        wfID,
+		Name:              name,
+		Description:       b.Workflow.Description,
+		WorkflowType:      b.Workflow.WorkflowType,
+		OwnerID:           userID,
+		OwnerRole:         role,
+		Status:            "pending_validation",
+		Version:           1,
+		PrePromptTemplate: b.Workflow.PrePromptTemplate,
+	}
+	// The workflow, its criteria lists and its steps are created together:
+	// an import that fails half way leaves nothing to clash with a retry.
+	filled := false
+	err = flowsDB.CreateWorkflowWith(wf, func(d *db.Draft) error {
+		filled = true
+		listIDs := make(map[string]string, len(b.CriteriaLists))
+		for _, cl := range b.CriteriaLists {
+			imported, err := importCriteriaList(d, cl, b.Source, userID)
+			if err != nil {
+				return fmt.Errorf("criteria list %s: %w", cl.Name, err)
+			}
+			listIDs[cl.Name] = imported.ListID
+			imp.CriteriaLists = append(imp.CriteriaLists, imported)
+		}
+		for i := range steps {
+			if list := b.Steps[i].CriteriaList; list != "" {
+				id := listIDs[list]
+				steps[i].CriteriaListID = &id
+			}
+			if err := d.CreateStep(&steps[i]); err != nil {
+				if strings.Contains(err.Error(), "UNIQUE") {
+					return fmt.Errorf("%w: step %s appears twice at step_order %d", ErrInvalidBundle, steps[i].StepName, steps[i].StepOrder)
+				}
+				return fmt.Errorf("creating step %s: %w", steps[i].StepName, err)
+			}
+		}
+		return nil
+	})
+	if err != nil && !filled {
+		switch {
+		case strings.Contains(err.Error(), "UNIQUE"):
+			return nil, fmt.Errorf("%w: %s", ErrBundleConflict, name)
+		case strings.Contains(err.Error(), "CHECK"):
+			return nil, fmt.Errorf("%w: workflow_type %q", ErrInvalidBundle, wf.WorkflowType)
+		}
+		return nil, fmt.Errorf("creating workflow: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	wf.Steps = steps
+
+	_ = flowsDB.InsertAuditLog("", "", "bundle_imported", map[string]interface{}{
+		"workflow_id":    wfID,
+		"source":         b.Source,
+		"source_version": b.Workflow.Version,
+		"signer":         signer,
+		"imported_by":    userID,
+	})
+	_ = flowsDB.InsertAuditLog("", "", "validation_requested", map[string]string{
+		"workflow_id":  wfID,
+		"submitted_by": userID,
+	})
+	imp.Workflow = wf
+	return imp, nil
+}
+
+// importCriteriaList reuses the local list of the same name when its items
+// are the same, and otherwise creates the bundle's list under a name
+// suffixed with its source.
+func importCriteriaList(d *db.Draft, cl BundleCriteriaList, source, userID string) (ImportedCriteriaList, error) {
+	items := cl.Items
+	if items == nil {
+		items = []string{}
+	}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return ImportedCriteriaList{}, err
+	}
+	suffix := source
+	if suffix == "" {
+		suffix = "imported"
+	}
+	name := cl.Name
+	for i := 1; ; i++ {
+		existing, err := d.CriteriaListByName(name)
+		if err != nil {
+			break
+		}
+		var existingItems []string
+		if json.Unmarshal([]byte(existing.ItemsJSON), &existingItems) == nil && slices.Equal(existingItems, items) {
+			return ImportedCriteriaList{Name: existing.Name, ListID: existing.ListID, Reused: true}, nil
+		}
+		name = fmt.Sprintf("%s (%s)", cl.Name, suffix)
+		if i > 1 {
+			name = fmt.Sprintf("%s (%s %d)", cl.Name, suffix, i)
+		}
+	}
+	created := &db.CriteriaList{
+		ListID:      db.NewID(),
+		Name:        name,
+		Description: cl.Description,
+		ItemsJSON:   string(itemsJSON),
+		OwnerID:     userID,
+	}
+	if err := d.CreateCriteriaList(created); err != nil {
+		return ImportedCriteriaList{}, err
+	}
+	return ImportedCriteriaList{Name: name, ListID: created.ListID}, nil
+}
@@ -0,0 +1,68 @@
+// CLAUDE:SUMMARY Workflow run cancellation — cancels the context of an executing run (aborting its provider calls) or closes a run no process executes
+package llm
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrRunFinished is returned when cancelling a run that already ended.
+var ErrRunFinished = errors.New("run already finished")
+
+// errRunCancelled is the cause a cancelled run's context carries, so that
+// its steps fail as cancelled rather than as provider errors.
+var errRunCancelled = errors.New("run cancelled")
+
+// CancelRun stops a pending or running run on behalf of userID. An executing
+// run has its context cancelled: provider calls in flight are aborted, no
+// new step starts and the run ends as cancelled once its steps return. A
+// run no process executes (left over by a restart) is marked cancelled
+// directly. It returns ErrRunNotFound or ErrRunFinished.
+func (we *WorkflowEngine) CancelRun(runID, userID string) error {
+	run, err := we.flowsDB.GetWorkflowRun(runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRunNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	we.mu.Lock()
+	cancel, active := we.active[runID]
+	we.mu.Unlock()
+	if !active && run.Status != "pending" && run.Status != "running" {
+		return ErrRunFinished
+	}
+
+	_ = we.flowsDB.InsertAuditLog(runID, "", "run_cancelled", map[string]interface{}{
+		"cancelled_by": userID,
+		"executing":    active,
+	})
+	we.logger.Info("workflow run cancelled", "run_id", runID, "by", userID)
+	if active {
+		cancel(errRunCancelled)
+		return nil
+	}
+	_ = we.flowsDB.InterruptStepRuns(runID)
+	errMsg := "cancelled"
+	return we.flowsDB.UpdateRunStatus(runID, "cancelled", nil, &errMsg)
+}
+
+// CancelBatch cancels the runs of a batch still pending or running and
+// returns how many were.
+func (we *WorkflowEngine) CancelBatch(batchID, userID string) (int, error) {
+	runs, err := we.flowsDB.ListRunsByBatch(batchID)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, r := range runs {
+		switch err := we.CancelRun(r.RunID, userID); {
+		case err == nil:
+			n++
+		case !errors.Is(err, ErrRunFinished):
+			return n, err
+		}
+	}
+	return n, nil
+}
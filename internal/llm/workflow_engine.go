@@ -1,14 +1,14 @@
-// CLAUDE:SUMMARY Dynamic VACF workflow engine with fan-in/out parallelism and ACID step persistence
+// CLAUDE:SUMMARY Dynamic VACF workflow engine — steps scheduled along their dependency DAG with named fan-in inputs, per-step timeouts, ACID step persistence, node_write steps writing into the tree
 package llm
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -16,12 +16,20 @@ import (
 	"github.com/hazyhaar/horostracker/internal/db"
 )
 
-// WorkflowEngine executes dynamic VACF workflows with fan-in/out and ACID per step.
+// defaultStepTimeout bounds each attempt of a step without timeout_ms.
+const defaultStepTimeout = 30 * time.Second
+
+// WorkflowEngine executes dynamic VACF workflows along their step DAG, with ACID per step.
 type WorkflowEngine struct {
 	client  *Client
 	flowsDB *db.FlowsDB
 	logger  *slog.Logger
 	httpCl  *http.Client
+	tools   *Toolset // nil = llm steps run without tools
+	nodes   *db.DB   // nil = node_write steps fail
+
+	mu     sync.Mutex
+	active map[string]context.CancelCauseFunc // runs executing in this process
 }
 
 // NewWorkflowEngine creates a workflow execution engine.
@@ -31,160 +39,301 @@ func NewWorkflowEngine(client *Client, flowsDB *db.FlowsDB, logger *slog.Logger)
 		flowsDB: flowsDB,
 		logger:  logger,
 		httpCl:  &http.Client{Timeout: 60 * time.Second},
+		active:  make(map[string]context.CancelCauseFunc),
 	}
 }
 
-// stepGroup holds steps sharing the same step_order.
-type stepGroup struct {
-	order int
-	steps []db.WorkflowStep
+// SetTools sets the toolset llm steps pick from with config_json "tools".
+func (we *WorkflowEngine) SetTools(tools *Toolset) {
+	we.tools = tools
+}
+
+// SetNodeStore sets the database node_write steps write their nodes to.
+func (we *WorkflowEngine) SetNodeStore(database *db.DB) {
+	we.nodes = database
+}
+
+// WorkflowInput is what a workflow run starts from.
+type WorkflowInput struct {
+	RunID     string // "" = a new ID
+	NodeID    string
+	Body      string
+	PrePrompt string
+	UserID    string
+	UserRole  string
+	BatchID   string // "" = not part of a batch
+	DryRun    bool   // node_write steps report the nodes they would write
+
+	// TargetProvider and TargetModel replace "$TARGET" in a step's
+	// provider and model, as in thinking flows.
+	TargetProvider string
+	TargetModel    string
+
+	// FlowID, when set, also traces every step in flow_steps under that
+	// flow ID, so that a run can stand in for a thinking flow.
+	FlowID string
+
+	// TriggerID is the trigger that started the run, "" for none.
+	TriggerID string
 }
 
 // ExecuteWorkflow runs a full workflow and persists each step with ACID guarantees.
 func (we *WorkflowEngine) ExecuteWorkflow(ctx context.Context, workflowID, nodeID, userID, userRole, prePrompt string) (string, error) {
-	wf, err := we.flowsDB.GetWorkflow(workflowID)
+	return we.execute(ctx, workflowID, WorkflowInput{
+		NodeID:    nodeID,
+		Body:      nodeID, // placeholder; caller should provide body via pre-prompt or node context
+		PrePrompt: prePrompt,
+		UserID:    userID,
+		UserRole:  userRole,
+	})
+}
+
+// ExecuteWorkflowWithBody runs a workflow with a provided body text.
+func (we *WorkflowEngine) ExecuteWorkflowWithBody(ctx context.Context, workflowID, nodeID, userID, userRole, prePrompt, body string) (string, error) {
+	return we.execute(ctx, workflowID, WorkflowInput{
+		NodeID:    nodeID,
+		Body:      body,
+		PrePrompt: prePrompt,
+		UserID:    userID,
+		UserRole:  userRole,
+	})
+}
+
+func (we *WorkflowEngine) execute(ctx context.Context, workflowID string, in WorkflowInput) (string, error) {
+	wf, err := we.flowsDB.GetRunnableWorkflow(workflowID)
 	if err != nil {
 		return "", fmt.Errorf("loading workflow: %w", err)
 	}
+	result, err := we.Run(ctx, wf, in)
+	if result == nil {
+		return "", err
+	}
+	return result.RunID, err
+}
+
+// Run executes wf's steps in dependency order, in parallel where the plan
+// allows (see planSteps). A failed step fails the run,
+// which is recorded in workflow_runs rather than returned: the error is for
+// runs that could not start or were cancelled. The result holds the steps
+// that ran, in the order they finished. The run records wf.Version as the
+// version it executed.
+func (we *WorkflowEngine) Run(ctx context.Context, wf *db.Workflow, in WorkflowInput) (*FlowResult, error) {
+	ctx, in, err := we.startRun(ctx, wf, in)
+	if err != nil {
+		return nil, err
+	}
+	defer we.release(in.RunID)
+	return we.runSteps(ctx, wf, in, nil)
+}
+
+// Start records the run and executes it in the background, so that its ID
+// can be followed as soon as Start returns.
+func (we *WorkflowEngine) Start(ctx context.Context, wf *db.Workflow, in WorkflowInput) (string, error) {
+	ctx, in, err := we.startRun(ctx, wf, in)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		defer we.release(in.RunID)
+		if _, err := we.runSteps(ctx, wf, in, nil); err != nil {
+			we.logger.Warn("workflow run", "run_id", in.RunID, "error", err)
+		}
+	}()
+	return in.RunID, nil
+}
 
-	runID := db.NewID()
+// startRun creates the run's row, claimed by this process and running, and
+// returns the context the run executes under.
+func (we *WorkflowEngine) startRun(ctx context.Context, wf *db.Workflow, in WorkflowInput) (context.Context, WorkflowInput, error) {
+	runID := in.RunID
+	if runID == "" {
+		runID = db.NewID()
+	}
 	run := &db.WorkflowRun{
-		RunID:      runID,
-		WorkflowID: workflowID,
-		InitiatedBy: userID,
-		Status:     "pending",
-		TotalSteps: len(wf.Steps),
+		RunID:       runID,
+		WorkflowID:  wf.WorkflowID,
+		InitiatedBy: in.UserID,
+		Status:      "pending",
+		TotalSteps:  len(wf.Steps),
+	}
+	version := wf.Version
+	run.WorkflowVersion = &version
+	if in.NodeID != "" {
+		run.NodeID = &in.NodeID
 	}
-	if nodeID != "" {
-		run.NodeID = &nodeID
+	if in.PrePrompt != "" {
+		run.PrePrompt = &in.PrePrompt
 	}
-	if prePrompt != "" {
-		run.PrePrompt = &prePrompt
+	if in.BatchID != "" {
+		run.BatchID = &in.BatchID
 	}
+	inputJSON := encodeRunInput(in)
+	run.InputJSON = &inputJSON
 
 	if err := we.flowsDB.CreateWorkflowRun(run); err != nil {
-		return "", fmt.Errorf("creating run: %w", err)
+		return ctx, in, fmt.Errorf("creating run: %w", err)
 	}
+	ctx, _ = we.claim(ctx, runID)
 
 	_ = we.flowsDB.UpdateRunStatus(runID, "running", nil, nil)
-	_ = we.flowsDB.InsertAuditLog(runID, "", "run_started", map[string]string{
-		"workflow_id": workflowID,
-		"workflow_name": wf.Name,
+	_ = we.flowsDB.InsertAuditLog(runID, "", "run_started", map[string]interface{}{
+		"workflow_id":      wf.WorkflowID,
+		"workflow_name":    wf.Name,
+		"workflow_version": wf.Version,
 	})
 
-	// Group steps by step_order
-	groups := groupSteps(wf.Steps)
+	in.RunID = runID
+	return ctx, in, nil
+}
+
+// runSteps executes the steps of wf for the run in.RunID, except those with
+// an output in done (by step ID), which count as completed with it. It
+// finishes the run: completed, failed or cancelled.
+func (we *WorkflowEngine) runSteps(ctx context.Context, wf *db.Workflow, in WorkflowInput, done map[string]string) (*FlowResult, error) {
+	runID := in.RunID
+
+	// Every LLM call of the run counts against the initiator's and the run's budgets.
+	ctx = WithSpender(ctx, Spender{UserID: in.UserID, RunID: runID})
 
-	// Execution context accumulates step outputs
+	// Execution context holds what every step of the run shares
 	execCtx := &workflowExecCtx{
-		body:      "",
-		prePrompt: prePrompt,
-		responses: make(map[string]string),
-		userID:    userID,
-		userRole:  userRole,
+		body:           in.Body,
+		prePrompt:      in.PrePrompt,
+		nodeID:         in.NodeID,
+		userID:         in.UserID,
+		userRole:       in.UserRole,
+		dryRun:         in.DryRun,
+		targetProvider: in.TargetProvider,
+		targetModel:    in.TargetModel,
+		trace:          &workflowTrace{flowID: in.FlowID, offset: we.traceOffset(in.FlowID, done)},
 	}
 
-	// If nodeID is set, load node body
-	if nodeID != "" {
-		execCtx.body = nodeID // placeholder; caller should provide body via pre-prompt or node context
+	start := time.Now()
+	result := func() *FlowResult {
+		return &FlowResult{
+			FlowID:   in.FlowID,
+			RunID:    runID,
+			Steps:    execCtx.trace.steps,
+			Duration: time.Since(start),
+		}
 	}
 
-	for _, g := range groups {
-		if ctx.Err() != nil {
-			errMsg := "cancelled"
-			_ = we.flowsDB.UpdateRunStatus(runID, "cancelled", nil, &errMsg)
-			return runID, ctx.Err()
-		}
+	plan, err := planSteps(wf.Steps)
+	if err != nil {
+		errMsg := err.Error()
+		_ = we.flowsDB.UpdateRunStatus(runID, "failed", nil, &errMsg)
+		return result(), nil //nolint:nilerr // recorded in the run like a step error
+	}
 
-		if len(g.steps) == 1 {
-			// Sequential execution
-			if err := we.executeStepACID(ctx, runID, g.steps[0], execCtx, nil); err != nil {
-				errMsg := err.Error()
-				_ = we.flowsDB.UpdateRunStatus(runID, "failed", nil, &errMsg)
-				return runID, nil //nolint:nilerr // step error captured in DB, not propagated
+	// Every step starts as soon as the steps it depends on have completed.
+	// After a failure no new step starts; those running are waited for.
+	type finished struct {
+		node   int
+		output string
+		err    error
+	}
+	var (
+		finish   = make(chan finished)
+		outputs  = make(map[int]string, len(plan))
+		started  = make([]bool, len(plan))
+		running  int
+		firstErr error
+	)
+	for i, n := range plan {
+		if out, ok := done[n.step.StepID]; ok {
+			outputs[i] = out
+			started[i] = true
+		}
+	}
+	ready := func(i int) bool {
+		for _, d := range plan[i].deps {
+			if _, ok := outputs[d]; !ok {
+				return false
 			}
-		} else {
-			// Fan-out: parallel execution via goroutines
-			_ = we.flowsDB.InsertAuditLog(runID, "", "fan_out_started", map[string]interface{}{
-				"step_order": g.order,
-				"count":      len(g.steps),
-			})
-
-			var wg sync.WaitGroup
-			var mu sync.Mutex
-			fanResults := make(map[string]string)
-			var firstErr error
-
-			for i := range g.steps {
-				wg.Add(1)
-				go func(s db.WorkflowStep) {
-					defer wg.Done()
-					localCtx := &workflowExecCtx{
-						body:      execCtx.body,
-						prePrompt: execCtx.prePrompt,
-						responses: copyMap(execCtx.responses),
-						userID:    execCtx.userID,
-						userRole:  execCtx.userRole,
-					}
-					if err := we.executeStepACID(ctx, runID, s, localCtx, nil); err != nil {
-						mu.Lock()
-						if firstErr == nil {
-							firstErr = err
-						}
-						mu.Unlock()
-						return
-					}
-					mu.Lock()
-					fanResults[s.StepName] = localCtx.responses[s.StepName]
-					mu.Unlock()
-				}(g.steps[i])
+		}
+		return true
+	}
+	for {
+		if firstErr == nil && ctx.Err() == nil {
+			var launched []string
+			for i := range plan {
+				if started[i] || !ready(i) {
+					continue
+				}
+				started[i] = true
+				running++
+				launched = append(launched, plan[i].step.StepName)
+				if len(plan[i].deps) > 1 {
+					_ = we.flowsDB.InsertAuditLog(runID, "", "fan_in_completed", map[string]interface{}{
+						"step_name": plan[i].step.StepName,
+						"inputs":    plan[i].depNames(plan),
+					})
+				}
+				stepCtx := execCtx.forStep(plan, i, outputs)
+				go func(i int, stepCtx *workflowExecCtx) {
+					err := we.executeStepACID(ctx, runID, plan[i].step, stepCtx)
+					finish <- finished{node: i, output: stepCtx.responses[plan[i].step.StepName], err: err}
+				}(i, stepCtx)
 			}
-
-			_ = we.flowsDB.InsertAuditLog(runID, "", "fan_in_waiting", map[string]interface{}{
-				"step_order": g.order,
-			})
-			wg.Wait()
-
-			_ = we.flowsDB.InsertAuditLog(runID, "", "fan_in_completed", map[string]interface{}{
-				"step_order": g.order,
-				"completed":  len(fanResults),
-			})
-
-			// Merge fan results into main context
-			fanResultsJSON, _ := json.Marshal(fanResults)
-			execCtx.responses["fan_results"] = string(fanResultsJSON)
-			for k, v := range fanResults {
-				execCtx.responses[k] = v
-				execCtx.previousResponse = v // last one wins for {{.PreviousResponse}}
+			if len(launched) > 1 {
+				_ = we.flowsDB.InsertAuditLog(runID, "", "fan_out_started", map[string]interface{}{
+					"steps": launched,
+					"count": len(launched),
+				})
+			}
+		}
+		if running == 0 {
+			break
+		}
+		f := <-finish
+		running--
+		if f.err != nil {
+			if firstErr == nil {
+				firstErr = f.err
 			}
+			continue
 		}
+		outputs[f.node] = f.output
+	}
+
+	if ctx.Err() != nil {
+		errMsg := "cancelled"
+		_ = we.flowsDB.UpdateRunStatus(runID, "cancelled", nil, &errMsg)
+		return result(), ctx.Err()
+	}
+	if firstErr != nil {
+		errMsg := firstErr.Error()
+		_ = we.flowsDB.UpdateRunStatus(runID, "failed", nil, &errMsg)
+		return result(), nil //nolint:nilerr // step error captured in DB, not propagated
 	}
 
 	// Build result
-	resultMap := make(map[string]string)
-	for k, v := range execCtx.responses {
-		resultMap[k] = v
+	resultMap := make(map[string]string, len(plan))
+	for i, n := range plan {
+		resultMap[n.step.StepName] = outputs[i]
 	}
 	resultJSON, _ := json.Marshal(resultMap)
 	resultStr := string(resultJSON)
 	_ = we.flowsDB.UpdateRunStatus(runID, "completed", &resultStr, nil)
 	_ = we.flowsDB.InsertAuditLog(runID, "", "run_completed", nil)
 
-	return runID, nil
+	return result(), nil
 }
 
 // executeStepACID runs a single step in its own transaction with retry logic.
-//
-//nolint:unparam // fanResults is nil for sequential steps, non-nil for fan-in (future)
-func (we *WorkflowEngine) executeStepACID(ctx context.Context, runID string, step db.WorkflowStep, execCtx *workflowExecCtx, fanResults map[string]string) error {
+func (we *WorkflowEngine) executeStepACID(ctx context.Context, runID string, step db.WorkflowStep, execCtx *workflowExecCtx) error {
 	stepRunID := db.NewID()
+	step = execCtx.resolveTarget(step)
 
 	// Prepare input context
-	inputMap := map[string]string{
+	inputMap := map[string]interface{}{
 		"body":              execCtx.body,
 		"pre_prompt":        execCtx.prePrompt,
 		"previous_response": execCtx.previousResponse,
 	}
+	if len(execCtx.inputs) > 0 {
+		inputMap["inputs"] = execCtx.inputs
+	}
 	inputJSON, _ := json.Marshal(inputMap)
 
 	_ = we.flowsDB.InsertAuditLog(runID, stepRunID, "step_started", map[string]string{
@@ -206,6 +355,8 @@ func (we *WorkflowEngine) executeStepACID(ctx context.Context, runID string, ste
 		VALUES (?, ?, ?, ?, 'running', ?, datetime('now'))`,
 		stepRunID, runID, step.StepID, step.StepOrder, inputStr)
 
+	sr := StepResult{Name: step.StepName, Provider: step.Provider, Model: step.Model, Round: 1}
+
 	// Run-time model grant enforcement
 	if step.Model != "" {
 		if !we.flowsDB.ModelIsAvailable(step.Model) && we.flowsDB.ModelExists(step.Model) {
@@ -216,7 +367,9 @@ func (we *WorkflowEngine) executeStepACID(ctx context.Context, runID string, ste
 			_ = we.flowsDB.InsertAuditLog(runID, stepRunID, "step_failed", map[string]string{
 				"error": errMsg, "reason": "model_unavailable",
 			})
-			return fmt.Errorf("%s", errMsg)
+			sr.Error = fmt.Errorf("%s", errMsg)
+			we.record(execCtx, step, sr)
+			return sr.Error
 		}
 		grantAllowed, explicit := we.flowsDB.CheckModelGrant(execCtx.userID, execCtx.userRole, step.Model, step.StepType)
 		if explicit && !grantAllowed {
@@ -227,36 +380,98 @@ func (we *WorkflowEngine) executeStepACID(ctx context.Context, runID string, ste
 			_ = we.flowsDB.InsertAuditLog(runID, stepRunID, "step_failed", map[string]string{
 				"error": errMsg, "reason": "model_grant_denied",
 			})
-			return fmt.Errorf("%s", errMsg)
+			sr.Error = fmt.Errorf("%s", errMsg)
+			we.record(execCtx, step, sr)
+			return sr.Error
+		}
+		// Discovery may have learned the model's limits since the step was saved.
+		if err := CheckStepModel(we.flowsDB, step); errors.Is(err, db.ErrModelLacksCapability) {
+			errMsg := err.Error()
+			_, _ = we.flowsDB.Exec(`
+				UPDATE workflow_step_runs SET status = 'failed', error = ?, completed_at = datetime('now')
+				WHERE step_run_id = ?`, errMsg, stepRunID)
+			_ = we.flowsDB.InsertAuditLog(runID, stepRunID, "step_failed", map[string]string{
+				"error": errMsg, "reason": "model_capability",
+			})
+			sr.Error = err
+			we.record(execCtx, step, sr)
+			return err
 		}
 	}
 
 	var output string
 	var provider, model string
 	var tokensIn, tokensOut, latencyMs int
+	var costUSD float64
+	var cached bool
+	var toolCalls interface{}
+	var messages []Message
 	var stepErr error
 
+	timeout := defaultStepTimeout
+	if step.TimeoutMs > 0 {
+		timeout = time.Duration(step.TimeoutMs) * time.Millisecond
+	}
+
 	for attempt := 1; attempt <= max(step.RetryMax, 1); attempt++ {
 		start := time.Now()
+		// The trace keeps the last attempt's request and response.
+		sr.Response, messages = nil, nil
 
+		// Each attempt gets the step's timeout; the provider call in flight
+		// is aborted when it expires or when the run is cancelled.
+		actx, cancel := context.WithTimeout(ctx, timeout)
 		switch step.StepType {
 		case "llm":
-			output, provider, model, tokensIn, tokensOut, stepErr = we.executeLLM(ctx, step, execCtx)
+			sr.Response, messages, stepErr = we.executeLLM(actx, step, execCtx)
+			if resp := sr.Response; resp != nil {
+				output, provider, model = resp.Content, resp.Provider, resp.Model
+				tokensIn, tokensOut, costUSD = resp.TokensIn, resp.TokensOut, resp.CostUSD
+				cached = resp.Cached
+				if len(resp.ToolRounds) > 0 {
+					b, _ := json.Marshal(resp.ToolRounds)
+					toolCalls = string(b)
+				}
+			}
 		case "sql":
-			output, stepErr = we.executeSQL(ctx, step, execCtx)
+			output, stepErr = we.executeSQL(actx, step, execCtx)
 		case "http":
-			output, stepErr = we.executeHTTP(ctx, step, execCtx)
+			output, stepErr = we.executeHTTP(actx, step, execCtx)
 		case "check":
-			output, stepErr = we.executeCheck(ctx, step, execCtx)
+			sr.Response, messages, stepErr = we.executeCheck(actx, step, execCtx)
+			if resp := sr.Response; resp != nil {
+				output, provider, model = resp.Content, resp.Provider, resp.Model
+				tokensIn, tokensOut, costUSD = resp.TokensIn, resp.TokensOut, resp.CostUSD
+				cached = resp.Cached
+			}
+		case "node_write":
+			output, stepErr = we.executeNodeWrite(actx, runID, stepRunID, step, execCtx)
 		default:
 			stepErr = fmt.Errorf("unknown step type: %s", step.StepType)
 		}
+		timedOut := stepErr != nil && ctx.Err() == nil && errors.Is(actx.Err(), context.DeadlineExceeded)
+		cancel()
 
 		latencyMs = int(time.Since(start).Milliseconds())
 
 		if stepErr == nil {
 			break
 		}
+		if ctx.Err() != nil {
+			// The run was cancelled: no point in another attempt.
+			stepErr = fmt.Errorf("%w: %v", context.Cause(ctx), stepErr)
+			break
+		}
+		if timedOut {
+			stepErr = fmt.Errorf("%w after %s: %v", ErrStepTimeout, timeout, stepErr)
+		}
+
+		// Retrying within milliseconds cannot help when the provider's
+		// circuit is open, its API key is rejected, the budget is spent or
+		// the step's template does not render.
+		if errors.Is(stepErr, ErrTemplate) || errors.Is(stepErr, ErrCircuitOpen) || Classify(stepErr) == ErrClassAuth || Classify(stepErr) == ErrClassBudget {
+			break
+		}
 
 		we.logger.Warn("step failed, retrying",
 			"run_id", runID,
@@ -270,17 +485,36 @@ func (we *WorkflowEngine) executeStepACID(ctx context.Context, runID string, ste
 				"attempt": attempt,
 				"error":   stepErr.Error(),
 			})
-			time.Sleep(time.Duration(100*attempt) * time.Millisecond)
+			select {
+			case <-time.After(time.Duration(100*attempt) * time.Millisecond):
+			case <-ctx.Done():
+			}
 		}
 	}
 
+	sr.Error = stepErr
+	sr.prompt, sr.system = sentPrompt(messages)
+	we.record(execCtx, step, sr)
+
 	if stepErr != nil {
 		errMsg := stepErr.Error()
 		_, _ = we.flowsDB.Exec(`
-			UPDATE workflow_step_runs SET status = 'failed', error = ?, latency_ms = ?, attempt = ?, completed_at = datetime('now')
+			UPDATE workflow_step_runs SET status = 'failed', error = ?, latency_ms = ?, attempt = ?, tool_calls = ?, completed_at = datetime('now')
 			WHERE step_run_id = ?`,
-			errMsg, latencyMs, step.RetryMax, stepRunID)
-		_ = we.flowsDB.InsertAuditLog(runID, stepRunID, "step_failed", map[string]string{"error": errMsg})
+			errMsg, latencyMs, step.RetryMax, toolCalls, stepRunID)
+		data := map[string]string{"error": errMsg}
+		switch {
+		case errors.Is(stepErr, errRunCancelled):
+			data["reason"] = "cancelled"
+		case errors.Is(stepErr, ErrStepTimeout):
+			data["reason"] = "timeout"
+			data["timeout_ms"] = fmt.Sprint(timeout.Milliseconds())
+		case Classify(stepErr) == ErrClassBudget:
+			data["reason"] = "budget_exceeded"
+		case errors.Is(stepErr, ErrTemplate):
+			data["reason"] = "template"
+		}
+		_ = we.flowsDB.InsertAuditLog(runID, stepRunID, "step_failed", data)
 		return stepErr
 	}
 
@@ -288,9 +522,9 @@ func (we *WorkflowEngine) executeStepACID(ctx context.Context, runID string, ste
 	_, _ = we.flowsDB.Exec(`
 		UPDATE workflow_step_runs SET status = 'completed', output_json = ?,
 			model_used = ?, provider_used = ?, tokens_in = ?, tokens_out = ?,
-			latency_ms = ?, completed_at = datetime('now')
+			cost_usd = ?, cached = ?, latency_ms = ?, tool_calls = ?, completed_at = datetime('now')
 		WHERE step_run_id = ?`,
-		output, nilIfEmpty(model), nilIfEmpty(provider), tokensIn, tokensOut, latencyMs, stepRunID)
+		output, nilIfEmpty(model), nilIfEmpty(provider), tokensIn, tokensOut, costUSD, cached, latencyMs, toolCalls, stepRunID)
 	_ = we.flowsDB.IncrementCompletedSteps(runID)
 	_ = we.flowsDB.InsertAuditLog(runID, stepRunID, "step_completed", map[string]interface{}{
 		"step_name":  step.StepName,
@@ -298,20 +532,32 @@ func (we *WorkflowEngine) executeStepACID(ctx context.Context, runID string, ste
 		"model":      model,
 		"tokens_in":  tokensIn,
 		"tokens_out": tokensOut,
+		"cost_usd":   costUSD,
+		"cached":     cached,
 		"latency_ms": latencyMs,
 	})
 
 	// Update execution context
 	execCtx.responses[step.StepName] = output
-	execCtx.previousResponse = output
 
 	return nil
 }
 
-// executeLLM builds a prompt, calls the LLM, and returns the response.
-func (we *WorkflowEngine) executeLLM(ctx context.Context, step db.WorkflowStep, execCtx *workflowExecCtx) (output, provider, model string, tokensIn, tokensOut int, err error) {
-	prompt := renderWorkflowTemplate(step.PromptTemplate, execCtx)
-	system := renderWorkflowTemplate(step.SystemPrompt, execCtx)
+// executeLLM builds a prompt, calls the LLM, and returns the response with
+// the messages sent.
+func (we *WorkflowEngine) executeLLM(ctx context.Context, step db.WorkflowStep, execCtx *workflowExecCtx) (*Response, []Message, error) {
+	prompt, err := renderWorkflowTemplate(step.PromptTemplate, execCtx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("prompt_template: %w", err)
+	}
+	system, err := renderWorkflowTemplate(step.SystemPrompt, execCtx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("system_prompt: %w", err)
+	}
+	schema, err := StepSchema(step)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var messages []Message
 	if system != "" {
@@ -322,24 +568,102 @@ func (we *WorkflowEngine) executeLLM(ctx context.Context, step db.WorkflowStep,
 	req := Request{
 		Model:    step.Model,
 		Messages: messages,
+		Schema:   schema,
 	}
 
+	ctx = WithStepName(ctx, step.StepName)
+	var cfg struct {
+		Tools             []string `json:"tools"`
+		MaxToolIterations int      `json:"max_tool_iterations"`
+	}
+	if step.ConfigJSON != "" {
+		_ = json.Unmarshal([]byte(step.ConfigJSON), &cfg)
+	}
 	var resp *Response
-	if step.Provider != "" {
+	switch {
+	case len(cfg.Tools) > 0 && we.tools != nil:
+		var tools *Toolset
+		if tools, err = we.tools.Select(cfg.Tools); err == nil {
+			resp, err = we.client.CompleteTools(ctx, step.Provider, req, tools, cfg.MaxToolIterations)
+		}
+	case step.Provider != "":
 		resp, err = we.client.CompleteWith(ctx, step.Provider, req)
-	} else {
+	default:
 		resp, err = we.client.Complete(ctx, req)
 	}
-	if err != nil {
-		return "", "", "", 0, 0, err
+	return resp, messages, err
+}
+
+// StepSchema returns the structured output an llm step asks for with
+// config_json "schema": "judge" for the verdict of the challenge judges,
+// "nodes" for the nodes a node_write step writes, or an inline JSON Schema
+// object. It is nil when the step has none.
+func StepSchema(step db.WorkflowStep) (*Schema, error) {
+	var cfg struct {
+		Schema json.RawMessage `json:"schema"`
+	}
+	if step.ConfigJSON != "" {
+		_ = json.Unmarshal([]byte(step.ConfigJSON), &cfg)
 	}
+	if len(cfg.Schema) == 0 || string(cfg.Schema) == "null" {
+		return nil, nil
+	}
+	var name string
+	if json.Unmarshal(cfg.Schema, &name) == nil {
+		switch name {
+		case "judge":
+			return judgeSchema, nil
+		case "nodes":
+			return nodeWriteSchema, nil
+		}
+		return nil, fmt.Errorf("schema %q: want \"judge\", \"nodes\" or a JSON Schema object", name)
+	}
+	return ParseSchema(step.StepName, string(cfg.Schema))
+}
+
+// StepCapabilities lists what a step needs from its model: "json" for
+// check steps (their verdict is schema-validated) and llm steps with a
+// schema, "tools" for llm steps given tools, plus whatever config_json
+// declares under "requires" (an input modality such as "image").
+func StepCapabilities(step db.WorkflowStep) []string {
+	var cfg struct {
+		Tools    []string        `json:"tools"`
+		Schema   json.RawMessage `json:"schema"`
+		Requires []string        `json:"requires"`
+	}
+	if step.ConfigJSON != "" {
+		_ = json.Unmarshal([]byte(step.ConfigJSON), &cfg)
+	}
+	var required []string
+	if step.StepType == "check" || step.StepType == "llm" && len(cfg.Schema) > 0 && string(cfg.Schema) != "null" {
+		required = append(required, "json")
+	}
+	if step.StepType == "llm" && len(cfg.Tools) > 0 {
+		required = append(required, "tools")
+	}
+	return append(required, cfg.Requires...)
+}
 
-	return resp.Content, resp.Provider, resp.Model, resp.TokensIn, resp.TokensOut, nil
+// CheckStepModel returns an error wrapping db.ErrModelLacksCapability when
+// the catalogue knows the step's model cannot do what the step needs.
+// Models with unknown capabilities pass.
+func CheckStepModel(flowsDB *db.FlowsDB, step db.WorkflowStep) error {
+	if step.Model == "" {
+		return nil
+	}
+	modelID := step.Model
+	if step.Provider != "" && !strings.HasPrefix(modelID, step.Provider+"/") {
+		modelID = step.Provider + "/" + modelID
+	}
+	return flowsDB.CheckModelCapabilities(modelID, StepCapabilities(step))
 }
 
 // executeSQL runs a read-only SQL query against flows.db.
 func (we *WorkflowEngine) executeSQL(ctx context.Context, step db.WorkflowStep, execCtx *workflowExecCtx) (string, error) {
-	query := renderWorkflowTemplate(step.PromptTemplate, execCtx)
+	query, err := renderWorkflowTemplate(step.PromptTemplate, execCtx)
+	if err != nil {
+		return "", fmt.Errorf("query: %w", err)
+	}
 	if query == "" {
 		return "", fmt.Errorf("sql step has empty query")
 	}
@@ -381,7 +705,10 @@ func (we *WorkflowEngine) executeSQL(ctx context.Context, step db.WorkflowStep,
 
 // executeHTTP makes an HTTP request and returns the response body.
 func (we *WorkflowEngine) executeHTTP(ctx context.Context, step db.WorkflowStep, execCtx *workflowExecCtx) (string, error) {
-	urlStr := renderWorkflowTemplate(step.PromptTemplate, execCtx)
+	urlStr, err := renderWorkflowTemplate(step.PromptTemplate, execCtx)
+	if err != nil {
+		return "", fmt.Errorf("url: %w", err)
+	}
 	if urlStr == "" {
 		return "", fmt.Errorf("http step has empty URL")
 	}
@@ -424,20 +751,63 @@ func (we *WorkflowEngine) executeHTTP(ctx context.Context, step db.WorkflowStep,
 	return string(body), nil
 }
 
-// executeCheck evaluates criteria from a criteria_list against the current context.
-func (we *WorkflowEngine) executeCheck(ctx context.Context, step db.WorkflowStep, execCtx *workflowExecCtx) (string, error) {
+// CheckVerdict is the output of a check step: one PASS/FAIL result per
+// criterion, and Passed when every criterion passed.
+type CheckVerdict struct {
+	Passed  bool          `json:"passed"`
+	Results []CheckResult `json:"results"`
+}
+
+// CheckResult is the evaluation of a single criterion.
+type CheckResult struct {
+	Criterion     string `json:"criterion"`
+	Result        string `json:"result"` // "PASS" or "FAIL"
+	Justification string `json:"justification"`
+}
+
+// checkSchema asks for exactly n criterion results.
+func checkSchema(n int) (*Schema, error) {
+	return ParseSchema("check_results", fmt.Sprintf(`{
+	"type": "object",
+	"properties": {
+		"results": {
+			"type": "array",
+			"minItems": %d,
+			"maxItems": %d,
+			"items": {
+				"type": "object",
+				"properties": {
+					"criterion": {"type": "string"},
+					"result": {"type": "string", "enum": ["PASS", "FAIL"]},
+					"justification": {"type": "string"}
+				},
+				"required": ["criterion", "result", "justification"]
+			}
+		}
+	},
+	"required": ["results"]
+}`, n, n))
+}
+
+// executeCheck evaluates criteria from a criteria_list against the current
+// context. The response content is replaced by the typed CheckVerdict.
+func (we *WorkflowEngine) executeCheck(ctx context.Context, step db.WorkflowStep, execCtx *workflowExecCtx) (*Response, []Message, error) {
 	if step.CriteriaListID == nil {
-		return "", fmt.Errorf("check step has no criteria_list_id")
+		return nil, nil, fmt.Errorf("check step has no criteria_list_id")
 	}
 
 	cl, err := we.flowsDB.GetCriteriaList(*step.CriteriaListID)
 	if err != nil {
-		return "", fmt.Errorf("loading criteria list: %w", err)
+		return nil, nil, fmt.Errorf("loading criteria list: %w", err)
 	}
 
 	var items []string
 	if unmarshalErr := json.Unmarshal([]byte(cl.ItemsJSON), &items); unmarshalErr != nil {
-		return "", fmt.Errorf("parsing criteria items: %w", unmarshalErr)
+		return nil, nil, fmt.Errorf("parsing criteria items: %w", unmarshalErr)
+	}
+	schema, err := checkSchema(len(items))
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Build evaluation prompt
@@ -451,19 +821,21 @@ func (we *WorkflowEngine) executeCheck(ctx context.Context, step db.WorkflowStep
 		criteriaStr += fmt.Sprintf("%d. %s\n", i+1, item)
 	}
 
-	evalPrompt := fmt.Sprintf("Evaluate the following content against each criterion. For each, respond PASS or FAIL with a brief justification.\n\nContent:\n%s\n\nCriteria:\n%s\nRespond in JSON format: [{\"criterion\": \"...\", \"result\": \"PASS\"|\"FAIL\", \"justification\": \"...\"}]",
+	evalPrompt := fmt.Sprintf("Evaluate the following content against each criterion. For each, respond PASS or FAIL with a brief justification.\n\nContent:\n%s\n\nCriteria:\n%s\nReturn one entry in results per criterion, in order.",
 		contextText, criteriaStr)
 
 	messages := []Message{
-		{Role: "system", Content: "You are a strict evaluator. Evaluate content against criteria and respond in valid JSON only."},
+		{Role: "system", Content: "You are a strict evaluator. Evaluate content against criteria."},
 		{Role: "user", Content: evalPrompt},
 	}
 
 	req := Request{
 		Model:    step.Model,
 		Messages: messages,
+		Schema:   schema,
 	}
 
+	ctx = WithStepName(ctx, step.StepName)
 	var resp *Response
 	if step.Provider != "" {
 		resp, err = we.client.CompleteWith(ctx, step.Provider, req)
@@ -471,182 +843,209 @@ func (we *WorkflowEngine) executeCheck(ctx context.Context, step db.WorkflowStep
 		resp, err = we.client.Complete(ctx, req)
 	}
 	if err != nil {
-		return "", fmt.Errorf("check evaluation: %w", err)
+		return resp, messages, fmt.Errorf("check evaluation: %w", err)
 	}
 
-	return resp.Content, nil
+	var verdict CheckVerdict
+	if err := resp.Decode(&verdict); err != nil {
+		return resp, messages, fmt.Errorf("check evaluation: %w", err)
+	}
+	verdict.Passed = true
+	for _, r := range verdict.Results {
+		if r.Result != "PASS" {
+			verdict.Passed = false
+		}
+	}
+	out, _ := json.Marshal(verdict)
+	resp.Content = string(out)
+	return resp, messages, nil
 }
 
-// workflowExecCtx carries accumulated state through workflow execution.
+// workflowExecCtx carries state through workflow execution. The run's
+// context holds the fields every step shares; each step runs in its own
+// copy, made by forStep, holding the outputs it can see.
 type workflowExecCtx struct {
 	body             string
 	prePrompt        string
+	nodeID           string
 	previousResponse string
-	responses        map[string]string
+	previousStep     string            // the step previousResponse is the output of
+	responses        map[string]string // upstream outputs, then the step's own
+	inputs           map[string]string // outputs of the direct dependencies
+	fanResults       string            // .FanResults, see fanResultsFor
 	userID           string
 	userRole         string
+	dryRun           bool
+	targetProvider   string
+	targetModel      string
+	trace            *workflowTrace // shared by the steps of the run
 }
 
-// renderWorkflowTemplate replaces template variables.
-func renderWorkflowTemplate(tmpl string, ctx *workflowExecCtx) string {
-	if tmpl == "" {
-		return ""
+// forStep returns the context plan[i] runs in: .Step holds the outputs of
+// every step it transitively depends on, .Inputs those of the steps it
+// waits for, and .PreviousResponse the output of the last of these in plan
+// order. Steps running in parallel do not see each other's outputs.
+// .FanResults, and .Step.fan_results unless a step has that name, are set
+// by fanResultsFor.
+func (c *workflowExecCtx) forStep(plan []stepNode, i int, outputs map[int]string) *workflowExecCtx {
+	cp := *c
+	cp.responses = make(map[string]string, len(plan[i].upstream)+1)
+	for _, u := range plan[i].upstream {
+		cp.responses[plan[u].step.StepName] = outputs[u]
 	}
-	s := tmpl
-	s = strings.ReplaceAll(s, "{{.Body}}", ctx.body)
-	s = strings.ReplaceAll(s, "{{.PrePrompt}}", ctx.prePrompt)
-	s = strings.ReplaceAll(s, "{{.PreviousResponse}}", ctx.previousResponse)
-
-	// {{.Step.step_name}} replacements
-	for name, resp := range ctx.responses {
-		s = strings.ReplaceAll(s, fmt.Sprintf("{{.Step.%s}}", name), resp)
+	cp.inputs = make(map[string]string, len(plan[i].deps))
+	cp.previousResponse, cp.previousStep = "", ""
+	for _, d := range plan[i].deps {
+		cp.inputs[plan[d].step.StepName] = outputs[d]
+		cp.previousResponse, cp.previousStep = outputs[d], plan[d].step.StepName
 	}
-
-	// {{.List.list_name}} — handled by caller injecting into responses
-	// {{.FanResults}} — the aggregated fan results
-	if fr, ok := ctx.responses["fan_results"]; ok {
-		s = strings.ReplaceAll(s, "{{.FanResults}}", fr)
+	cp.fanResults = fanResultsFor(plan, i, outputs)
+	if _, taken := cp.responses["fan_results"]; !taken && cp.fanResults != "" {
+		cp.responses["fan_results"] = cp.fanResults
 	}
-
-	return s
+	return &cp
 }
 
-// groupSteps organizes steps by step_order.
-func groupSteps(steps []db.WorkflowStep) []stepGroup {
-	orderMap := make(map[int][]db.WorkflowStep)
-	for i := range steps {
-		orderMap[steps[i].StepOrder] = append(orderMap[steps[i].StepOrder], steps[i])
+// fanResultsFor returns .FanResults for plan[i]: the outputs of the steps
+// it waits for as a JSON object when there are several, else those of the
+// latest fan-out group it depends on, as workflows written before
+// depends_on expect; "" if neither applies.
+func fanResultsFor(plan []stepNode, i int, outputs map[int]string) string {
+	group := plan[i].deps
+	if len(group) < 2 {
+		group = plan[i].fanGroup(plan)
 	}
-
-	groups := make([]stepGroup, 0, len(orderMap))
-	for order, ss := range orderMap {
-		groups = append(groups, stepGroup{order: order, steps: ss})
+	if len(group) < 2 {
+		return ""
 	}
-
-	sort.Slice(groups, func(i, j int) bool {
-		return groups[i].order < groups[j].order
-	})
-
-	return groups
+	m := make(map[string]string, len(group))
+	for _, g := range group {
+		m[plan[g].step.StepName] = outputs[g]
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
 }
 
-func copyMap(m map[string]string) map[string]string {
-	cp := make(map[string]string, len(m))
-	for k, v := range m {
-		cp[k] = v
+// resolveTarget replaces "$TARGET" in the step's provider and model with
+// the run's target, before grants and capabilities are checked.
+func (c *workflowExecCtx) resolveTarget(step db.WorkflowStep) db.WorkflowStep {
+	if step.Provider == "$TARGET" {
+		step.Provider = c.targetProvider
 	}
-	return cp
+	if step.Model == "$TARGET" {
+		step.Model = c.targetModel
+	}
+	return step
 }
 
-// ExecuteWorkflowWithBody runs a workflow with a provided body text.
-func (we *WorkflowEngine) ExecuteWorkflowWithBody(ctx context.Context, workflowID, nodeID, userID, userRole, prePrompt, body string) (string, error) {
-	wf, err := we.flowsDB.GetWorkflow(workflowID)
-	if err != nil {
-		return "", fmt.Errorf("loading workflow: %w", err)
-	}
+// workflowTrace collects the step results of a run, and also writes them to
+// flow_steps when the run stands in for a thinking flow.
+type workflowTrace struct {
+	mu     sync.Mutex
+	flowID string
+	offset int // flow_steps rows of the run's earlier attempts
+	steps  []StepResult
+}
 
-	runID := db.NewID()
-	run := &db.WorkflowRun{
-		RunID:       runID,
-		WorkflowID:  workflowID,
-		InitiatedBy: userID,
-		Status:      "pending",
-		TotalSteps:  len(wf.Steps),
-	}
-	if nodeID != "" {
-		run.NodeID = &nodeID
-	}
-	if prePrompt != "" {
-		run.PrePrompt = &prePrompt
+// record adds a finished step, successful or not, to the run's trace.
+func (we *WorkflowEngine) record(execCtx *workflowExecCtx, step db.WorkflowStep, sr StepResult) {
+	t := execCtx.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	index := t.offset + len(t.steps)
+	t.steps = append(t.steps, sr)
+	if t.flowID != "" {
+		if sr.prompt == "" {
+			sr.prompt = step.PromptTemplate // the template did not render
+		}
+		insertFlowStep(we.flowsDB, t.flowID, execCtx.nodeID, index, sr)
 	}
+}
 
-	if err := we.flowsDB.CreateWorkflowRun(run); err != nil {
-		return "", fmt.Errorf("creating run: %w", err)
+// sentPrompt returns the user prompt and the system prompt of messages.
+func sentPrompt(messages []Message) (prompt, system string) {
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			system = m.Content
+		case "user":
+			prompt = m.Content
+		}
 	}
+	return prompt, system
+}
 
-	_ = we.flowsDB.UpdateRunStatus(runID, "running", nil, nil)
-	_ = we.flowsDB.InsertAuditLog(runID, "", "run_started", map[string]string{
-		"workflow_id":   workflowID,
-		"workflow_name": wf.Name,
-	})
+// workflowTemplateSample stands for the fields workflow step templates can
+// use besides .Step and .Inputs when templates are checked. .FanResults is
+// kept for workflows written before depends_on (see fanResultsFor);
+// templates should prefer .Inputs.
+var workflowTemplateSample = map[string]interface{}{
+	"Body":             "sample",
+	"NodeID":           "sample",
+	"PrePrompt":        "sample",
+	"PreviousResponse": "sample",
+	"FanResults":       "sample",
+}
 
-	groups := groupSteps(wf.Steps)
+// renderWorkflowTemplate renders a step's prompt, system prompt, query or
+// URL. .Step only holds the steps the step depends on, so a reference to
+// any other step is an error rather than an empty string.
+func renderWorkflowTemplate(tmpl string, ctx *workflowExecCtx) (string, error) {
+	return executeTemplate("step", tmpl, map[string]interface{}{
+		"Body":             ctx.body,
+		"NodeID":           ctx.nodeID,
+		"PrePrompt":        ctx.prePrompt,
+		"PreviousResponse": ctx.previousResponse,
+		"Step":             copyMap(ctx.responses),
+		"Inputs":           copyMap(ctx.inputs),
+		"FanResults":       ctx.fanResults,
+	})
+}
 
-	execCtx := &workflowExecCtx{
-		body:      body,
-		prePrompt: prePrompt,
-		responses: make(map[string]string),
-		userID:    userID,
-		userRole:  userRole,
+// CheckStepTemplates validates a step's prompt_template and system_prompt
+// against the workflow's other steps, so that a typo or a reference to a
+// step that does not run before it is reported when the step is saved
+// rather than by a failed run. .Step can reference the steps it
+// transitively depends on, .Inputs the steps it waits for, and
+// .Step.fan_results is accepted where forStep sets it.
+func CheckStepTemplates(step db.WorkflowStep, steps []db.WorkflowStep) error {
+	plan, err := planSteps(withStep(step, steps))
+	if err != nil {
+		return err
 	}
-
-	for _, g := range groups {
-		if ctx.Err() != nil {
-			errMsg := "cancelled"
-			_ = we.flowsDB.UpdateRunStatus(runID, "cancelled", nil, &errMsg)
-			return runID, ctx.Err()
+	var earlier []string
+	sample := make(map[string]interface{}, len(workflowTemplateSample)+1)
+	for k, v := range workflowTemplateSample {
+		sample[k] = v
+	}
+	for _, n := range plan {
+		if n.step.StepID != step.StepID {
+			continue
 		}
-
-		if len(g.steps) == 1 {
-			if err := we.executeStepACID(ctx, runID, g.steps[0], execCtx, nil); err != nil {
-				errMsg := err.Error()
-				_ = we.flowsDB.UpdateRunStatus(runID, "failed", nil, &errMsg)
-				return runID, nil //nolint:nilerr // step error captured in DB, not propagated
-			}
-		} else {
-			_ = we.flowsDB.InsertAuditLog(runID, "", "fan_out_started", map[string]interface{}{
-				"step_order": g.order,
-				"count":      len(g.steps),
-			})
-
-			var wg sync.WaitGroup
-			var mu sync.Mutex
-			fanResults := make(map[string]string)
-
-			for i := range g.steps {
-				wg.Add(1)
-				go func(s db.WorkflowStep) {
-					defer wg.Done()
-					localCtx := &workflowExecCtx{
-						body:      execCtx.body,
-						prePrompt: execCtx.prePrompt,
-						responses: copyMap(execCtx.responses),
-						userID:    execCtx.userID,
-						userRole:  execCtx.userRole,
-					}
-					if err := we.executeStepACID(ctx, runID, s, localCtx, nil); err != nil {
-						return
-					}
-					mu.Lock()
-					fanResults[s.StepName] = localCtx.responses[s.StepName]
-					mu.Unlock()
-				}(g.steps[i])
-			}
-
-			wg.Wait()
-
-			_ = we.flowsDB.InsertAuditLog(runID, "", "fan_in_completed", map[string]interface{}{
-				"step_order": g.order,
-				"completed":  len(fanResults),
-			})
-
-			fanResultsJSON, _ := json.Marshal(fanResults)
-			execCtx.responses["fan_results"] = string(fanResultsJSON)
-			for k, v := range fanResults {
-				execCtx.responses[k] = v
-				execCtx.previousResponse = v
-			}
+		fanIn := len(n.deps) > 1 || n.fanGroup(plan) != nil
+		for _, u := range n.upstream {
+			earlier = append(earlier, plan[u].step.StepName)
+			fanIn = fanIn && plan[u].step.StepName != "fan_results"
+		}
+		if fanIn {
+			earlier = append(earlier, "fan_results")
+		}
+		inputs := make(map[string]string, len(n.deps))
+		for _, name := range n.depNames(plan) {
+			inputs[name] = "sample"
 		}
+		sample["Inputs"] = inputs
 	}
-
-	resultMap := make(map[string]string)
-	for k, v := range execCtx.responses {
-		resultMap[k] = v
+	if err := checkTemplate("prompt_template", step.PromptTemplate, sample, earlier); err != nil {
+		return err
 	}
-	resultJSON, _ := json.Marshal(resultMap)
-	resultStr := string(resultJSON)
-	_ = we.flowsDB.UpdateRunStatus(runID, "completed", &resultStr, nil)
-	_ = we.flowsDB.InsertAuditLog(runID, "", "run_completed", nil)
+	return checkTemplate("system_prompt", step.SystemPrompt, sample, earlier)
+}
 
-	return runID, nil
+func copyMap(m map[string]string) map[string]string {
+	cp := make(map[string]string, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
 }
@@ -0,0 +1,348 @@
+// CLAUDE:SUMMARY Tree write-back — node_write workflow steps turning structured step output into claim and piece nodes, with tags and sources, under the run's node; dry runs report them without writing
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// A node_write step writes at most max_nodes nodes (defaultNodeWrites
+// unless set, never more than maxNodeWrites).
+const (
+	defaultNodeWrites = 10
+	maxNodeWrites     = 50
+)
+
+// NodeWriteItem is a node a node_write step writes: under the run's node,
+// or under the item at index Parent of the same output.
+type NodeWriteItem struct {
+	NodeType string            `json:"node_type,omitempty"` // claim or piece
+	Body     string            `json:"body"`
+	Tags     []string          `json:"tags,omitempty"`
+	Sources  []NodeWriteSource `json:"sources,omitempty"`
+	Parent   *int              `json:"parent,omitempty"`
+}
+
+// NodeWriteSource is a source attached to a written node.
+type NodeWriteSource struct {
+	URL         string `json:"url,omitempty"`
+	Title       string `json:"title,omitempty"`
+	ContentText string `json:"content_text,omitempty"`
+}
+
+// WrittenNode is an item as the step wrote it, or would have in a dry run.
+type WrittenNode struct {
+	NodeWriteItem
+	Index    int    `json:"index"`
+	NodeID   string `json:"node_id,omitempty"`   // "" in a dry run
+	ParentID string `json:"parent_id,omitempty"` // "" in a dry run under an item
+	ModelID  string `json:"model_id,omitempty"`
+	Existing bool   `json:"existing,omitempty"` // written by an earlier attempt
+}
+
+// NodeWriteOutput is the output of a node_write step.
+type NodeWriteOutput struct {
+	DryRun bool          `json:"dry_run"`
+	Nodes  []WrittenNode `json:"nodes"`
+}
+
+// nodeWriteSchema is what an llm step feeding a node_write step asks for
+// with config_json "schema": "nodes".
+var nodeWriteSchema = MustSchema("node_writes", `{
+	"type": "object",
+	"properties": {
+		"nodes": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"node_type": {"type": "string", "enum": ["claim", "piece"]},
+					"body": {"type": "string"},
+					"tags": {"type": "array", "items": {"type": "string"}},
+					"sources": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"properties": {
+								"url": {"type": "string"},
+								"title": {"type": "string"},
+								"content_text": {"type": "string"}
+							}
+						}
+					},
+					"parent": {"type": "integer", "minimum": 0}
+				},
+				"required": ["body"]
+			}
+		}
+	},
+	"required": ["nodes"]
+}`)
+
+// nodeWriteConfig is the config_json of a node_write step: the node type of
+// items without one, tags added to every node, and the most nodes it writes.
+type nodeWriteConfig struct {
+	NodeType string   `json:"node_type"`
+	Tags     []string `json:"tags"`
+	MaxNodes int      `json:"max_nodes"`
+}
+
+func parseNodeWriteConfig(step db.WorkflowStep) (nodeWriteConfig, error) {
+	var cfg nodeWriteConfig
+	if step.ConfigJSON != "" {
+		if err := json.Unmarshal([]byte(step.ConfigJSON), &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.NodeType == "" {
+		cfg.NodeType = "claim"
+	}
+	if cfg.NodeType != "claim" && cfg.NodeType != "piece" {
+		return cfg, fmt.Errorf("node_type %q: want claim or piece", cfg.NodeType)
+	}
+	if cfg.MaxNodes == 0 {
+		cfg.MaxNodes = defaultNodeWrites
+	}
+	if cfg.MaxNodes < 1 || cfg.MaxNodes > maxNodeWrites {
+		return cfg, fmt.Errorf("max_nodes must be 1-%d", maxNodeWrites)
+	}
+	for _, tag := range cfg.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return cfg, fmt.Errorf("tags cannot be empty")
+		}
+	}
+	return cfg, nil
+}
+
+// CheckNodeWriteStep validates a node_write step when it is saved: it calls
+// no model and its config_json must parse. Other steps pass.
+func CheckNodeWriteStep(step db.WorkflowStep) error {
+	if step.StepType != "node_write" {
+		return nil
+	}
+	if step.Model != "" || step.Provider != "" {
+		return fmt.Errorf("node_write steps call no model")
+	}
+	if _, err := parseNodeWriteConfig(step); err != nil {
+		return fmt.Errorf("config_json: %w", err)
+	}
+	return nil
+}
+
+// parseNodeWrites reads the items of a step output: {"nodes": [...]} or a
+// bare array, within code fences or prose as models reply.
+func parseNodeWrites(output string, cfg nodeWriteConfig) ([]NodeWriteItem, error) {
+	raw, ok := extractJSON(output)
+	if !ok {
+		return nil, fmt.Errorf("output is not JSON")
+	}
+	var items []NodeWriteItem
+	if strings.HasPrefix(string(raw), "[") {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+	} else {
+		var obj struct {
+			Nodes *[]NodeWriteItem `json:"nodes"`
+		}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, err
+		}
+		if obj.Nodes == nil {
+			return nil, fmt.Errorf(`want {"nodes": [...]} or an array of nodes`)
+		}
+		items = *obj.Nodes
+	}
+	if len(items) > cfg.MaxNodes {
+		return nil, fmt.Errorf("%d nodes, at most %d allowed", len(items), cfg.MaxNodes)
+	}
+
+	for i := range items {
+		it := &items[i]
+		it.Body = strings.TrimSpace(it.Body)
+		if it.Body == "" {
+			return nil, fmt.Errorf("node %d: body is required", i)
+		}
+		if it.NodeType == "" {
+			it.NodeType = cfg.NodeType
+		}
+		if it.NodeType != "claim" && it.NodeType != "piece" {
+			return nil, fmt.Errorf("node %d: node_type %q: want claim or piece", i, it.NodeType)
+		}
+		if it.Parent != nil && (*it.Parent < 0 || *it.Parent >= i) {
+			return nil, fmt.Errorf("node %d: parent %d is not an earlier node", i, *it.Parent)
+		}
+		it.Tags = mergeTags(it.Tags, cfg.Tags)
+		for j, src := range it.Sources {
+			if src.URL == "" && src.ContentText == "" {
+				return nil, fmt.Errorf("node %d: source %d: url or content_text is required", i, j)
+			}
+			if src.URL != "" {
+				if u, err := url.Parse(src.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					return nil, fmt.Errorf("node %d: source %d: url %q is not an http(s) URL", i, j, src.URL)
+				}
+			}
+		}
+	}
+	return items, nil
+}
+
+// mergeTags returns tags followed by the extra ones it lacks, trimmed and
+// without duplicates.
+func mergeTags(tags, extra []string) []string {
+	var out []string
+	seen := make(map[string]bool, len(tags)+len(extra))
+	for _, t := range append(append([]string(nil), tags...), extra...) {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// executeNodeWrite writes the nodes of its input — its prompt_template, or
+// the output of the step it depends on — under the run's node, authored by
+// the run's initiator, whose role must allow node_write steps. Each node
+// records the model that produced the input and, in its metadata, the
+// workflow, run and step it comes from. Items an earlier attempt of the
+// step already wrote are not written again. In a dry run nothing is
+// written and the output lists the nodes that would be.
+func (we *WorkflowEngine) executeNodeWrite(ctx context.Context, runID, stepRunID string, step db.WorkflowStep, execCtx *workflowExecCtx) (string, error) {
+	if we.nodes == nil {
+		return "", fmt.Errorf("node_write steps are not available")
+	}
+	if execCtx.nodeID == "" {
+		return "", fmt.Errorf("node_write step needs a run on a node")
+	}
+	if _, err := we.nodes.GetNode(execCtx.nodeID); err != nil {
+		return "", fmt.Errorf("run node %s: %w", execCtx.nodeID, err)
+	}
+	if !execCtx.dryRun && !db.AllowedStepTypes(execCtx.userRole)["node_write"] {
+		return "", fmt.Errorf("role %q cannot write nodes", execCtx.userRole)
+	}
+	cfg, err := parseNodeWriteConfig(step)
+	if err != nil {
+		return "", fmt.Errorf("config_json: %w", err)
+	}
+
+	input := execCtx.previousResponse
+	if step.PromptTemplate != "" {
+		if input, err = renderWorkflowTemplate(step.PromptTemplate, execCtx); err != nil {
+			return "", fmt.Errorf("prompt_template: %w", err)
+		}
+	}
+	items, err := parseNodeWrites(input, cfg)
+	if err != nil {
+		return "", fmt.Errorf("node_write input: %w", err)
+	}
+
+	modelID := we.flowsDB.StepRunModel(runID, execCtx.previousStep)
+	written := map[int]string{}
+	if !execCtx.dryRun {
+		if written, err = we.nodes.WorkflowNodes(runID, step.StepID); err != nil {
+			return "", fmt.Errorf("nodes already written: %w", err)
+		}
+	}
+
+	out := NodeWriteOutput{DryRun: execCtx.dryRun, Nodes: make([]WrittenNode, 0, len(items))}
+	var nodeIDs []string
+	for i, it := range items {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		wn := WrittenNode{NodeWriteItem: it, Index: i, ModelID: modelID}
+		wn.ParentID = execCtx.nodeID
+		if it.Parent != nil {
+			wn.ParentID = out.Nodes[*it.Parent].NodeID
+		}
+		if id, ok := written[i]; ok {
+			wn.NodeID, wn.Existing = id, true
+		} else if !execCtx.dryRun {
+			if wn.NodeID, err = we.writeNode(runID, step, execCtx, wn); err != nil {
+				return "", fmt.Errorf("node %d: %w", i, err)
+			}
+			nodeIDs = append(nodeIDs, wn.NodeID)
+		}
+		out.Nodes = append(out.Nodes, wn)
+	}
+
+	_ = we.flowsDB.InsertAuditLog(runID, stepRunID, "nodes_written", map[string]interface{}{
+		"step_name": step.StepName,
+		"node_id":   execCtx.nodeID,
+		"dry_run":   execCtx.dryRun,
+		"count":     len(items),
+		"written":   nodeIDs,
+	})
+	b, _ := json.Marshal(out)
+	return string(b), nil
+}
+
+// writeNode creates wn's node with its tags and sources, in one
+// transaction so a failed write leaves nothing for the retry to skip, then
+// its safety score.
+func (we *WorkflowEngine) writeNode(runID string, step db.WorkflowStep, execCtx *workflowExecCtx, wn WrittenNode) (string, error) {
+	metadata, _ := json.Marshal(map[string]interface{}{
+		"workflow_id":        step.WorkflowID,
+		"workflow_version":   step.Version,
+		"workflow_run_id":    runID,
+		"workflow_step_id":   step.StepID,
+		"workflow_step_name": step.StepName,
+		"workflow_item":      wn.Index,
+	})
+	var modelID *string
+	if wn.ModelID != "" {
+		modelID = &wn.ModelID
+	}
+
+	sources := make([]db.SourceInput, 0, len(wn.Sources))
+	for _, src := range wn.Sources {
+		var in db.SourceInput
+		hashInput := src.ContentText
+		if src.URL != "" {
+			in.URL, hashInput = &src.URL, src.URL
+			if u, err := url.Parse(src.URL); err == nil {
+				host := u.Hostname()
+				in.Domain = &host
+			}
+		}
+		if src.ContentText != "" {
+			in.ContentText = &src.ContentText
+			hashInput = src.ContentText
+		}
+		if src.Title != "" {
+			in.Title = &src.Title
+		}
+		sum := sha256.Sum256([]byte(hashInput))
+		hash := hex.EncodeToString(sum[:])
+		in.ContentHash = &hash
+		sources = append(sources, in)
+	}
+
+	parentID := wn.ParentID
+	node, err := we.nodes.CreateNode(db.CreateNodeInput{
+		ParentID: &parentID,
+		NodeType: wn.NodeType,
+		Body:     wn.Body,
+		AuthorID: execCtx.userID,
+		ModelID:  modelID,
+		Metadata: string(metadata),
+		Tags:     wn.Tags,
+		Sources:  sources,
+		Workflow: &db.WorkflowNode{RunID: runID, StepID: step.StepID, Item: wn.Index},
+	})
+	if err != nil {
+		return "", err
+	}
+	_ = we.nodes.SaveSafetyScore(node.ID, we.nodes.ScoreContent(wn.Body))
+	return node.ID, nil
+}
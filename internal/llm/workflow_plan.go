@@ -0,0 +1,224 @@
+// CLAUDE:SUMMARY Workflow step DAG — resolves depends_on (or step_order) into dependency edges, rejects unknown steps and cycles, orders steps topologically
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// stepNode is a step of a workflow plan. deps and upstream are indexes into
+// the plan, in plan order: the steps it waits for, and every step it
+// transitively depends on.
+type stepNode struct {
+	step     db.WorkflowStep
+	deps     []int
+	upstream []int
+	level    int
+}
+
+// planSteps orders steps so that each comes after the steps it depends on.
+// A step with depends_on waits for every step of each name it lists; a step
+// without waits for the steps of the nearest lower step_order, so workflows
+// that predate depends_on still run group by group. Ties keep step_order.
+func planSteps(steps []db.WorkflowStep) ([]stepNode, error) {
+	sorted := append([]db.WorkflowStep(nil), steps...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StepOrder < sorted[j].StepOrder })
+
+	byName := make(map[string][]int, len(sorted))
+	for i, s := range sorted {
+		byName[s.StepName] = append(byName[s.StepName], i)
+	}
+
+	deps := make([][]int, len(sorted))
+	for i, s := range sorted {
+		if s.DependsOn == nil {
+			prev := -1
+			for j := i - 1; j >= 0; j-- {
+				if o := sorted[j].StepOrder; o < s.StepOrder {
+					if prev != -1 && o != prev {
+						break
+					}
+					prev = o
+					deps[i] = append(deps[i], j)
+				}
+			}
+			continue
+		}
+		seen := make(map[int]bool)
+		for _, name := range s.DependsOn {
+			idx, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("step %s depends on unknown step %q", s.StepName, name)
+			}
+			for _, j := range idx {
+				if j == i {
+					return nil, fmt.Errorf("step %s depends on itself", s.StepName)
+				}
+				if !seen[j] {
+					seen[j] = true
+					deps[i] = append(deps[i], j)
+				}
+			}
+		}
+	}
+
+	// Depth-first: a step is placed once everything it waits for is, and
+	// meeting a step that is still being visited closes a cycle.
+	const (
+		unvisited = iota
+		visiting
+		placed
+	)
+	state := make([]int, len(sorted))
+	position := make([]int, len(sorted))    // plan index of each sorted step
+	placedAt := make([]int, 0, len(sorted)) // and back
+	plan := make([]stepNode, 0, len(sorted))
+	var path []int
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case placed:
+			return nil
+		case visiting:
+			k := len(path) - 1
+			for path[k] != i {
+				k--
+			}
+			var names []string
+			for _, p := range path[k:] {
+				names = append(names, sorted[p].StepName)
+			}
+			return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(names, " -> "), sorted[i].StepName)
+		}
+		state[i] = visiting
+		path = append(path, i)
+		for _, j := range deps[i] {
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = placed
+		position[i] = len(plan)
+		placedAt = append(placedAt, i)
+		plan = append(plan, stepNode{step: sorted[i]})
+		return nil
+	}
+	for i := range sorted {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	// Dependencies come earlier in the plan, so their upstream sets are
+	// complete when a step's own is built.
+	for p := range plan {
+		n := &plan[p]
+		up := make(map[int]bool)
+		for _, j := range deps[placedAt[p]] {
+			d := position[j]
+			n.deps = append(n.deps, d)
+			n.level = max(n.level, plan[d].level+1)
+			up[d] = true
+			for _, u := range plan[d].upstream {
+				up[u] = true
+			}
+		}
+		sort.Ints(n.deps)
+		for u := range up {
+			n.upstream = append(n.upstream, u)
+		}
+		sort.Ints(n.upstream)
+	}
+	return plan, nil
+}
+
+// depNames returns the names of the steps n waits for.
+func (n stepNode) depNames(plan []stepNode) []string {
+	names := make([]string, 0, len(n.deps))
+	for _, d := range n.deps {
+		names = append(names, plan[d].step.StepName)
+	}
+	return names
+}
+
+// fanGroup returns the steps of the latest fan-out group n depends on:
+// the steps sharing the highest step_order held by two or more of its
+// upstream steps, nil if there is none. Before depends_on, .FanResults
+// held the outputs of that group.
+func (n stepNode) fanGroup(plan []stepNode) []int {
+	count := make(map[int]int)
+	for _, u := range n.upstream {
+		count[plan[u].step.StepOrder]++
+	}
+	order, found := 0, false
+	for o, c := range count {
+		if c >= 2 && (!found || o > order) {
+			order, found = o, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	var group []int
+	for _, u := range n.upstream {
+		if plan[u].step.StepOrder == order {
+			group = append(group, u)
+		}
+	}
+	return group
+}
+
+// PlannedStep is a step of a workflow's execution plan. DependsOn lists the
+// steps it waits for, implicit ones included. Steps run as soon as those
+// complete; steps of the same Level never depend on each other.
+type PlannedStep struct {
+	StepID    string   `json:"step_id"`
+	StepName  string   `json:"step_name"`
+	StepOrder int      `json:"step_order"`
+	DependsOn []string `json:"depends_on"`
+	Level     int      `json:"level"`
+}
+
+// PlanWorkflow returns the steps in an order they can run in, or why they
+// cannot: a depends_on naming no step of the workflow, or a cycle.
+func PlanWorkflow(steps []db.WorkflowStep) ([]PlannedStep, error) {
+	plan, err := planSteps(steps)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PlannedStep, len(plan))
+	for i, n := range plan {
+		out[i] = PlannedStep{
+			StepID:    n.step.StepID,
+			StepName:  n.step.StepName,
+			StepOrder: n.step.StepOrder,
+			DependsOn: n.depNames(plan),
+			Level:     n.level,
+		}
+	}
+	return out, nil
+}
+
+// CheckStepDependencies checks that the workflow can still be planned once
+// step is saved into steps, so that an unknown dependency or a cycle is
+// reported by the step API rather than by a run.
+func CheckStepDependencies(step db.WorkflowStep, steps []db.WorkflowStep) error {
+	_, err := planSteps(withStep(step, steps))
+	return err
+}
+
+// withStep returns steps with step added, or replacing the step of the
+// same ID.
+func withStep(step db.WorkflowStep, steps []db.WorkflowStep) []db.WorkflowStep {
+	out := make([]db.WorkflowStep, 0, len(steps)+1)
+	for _, s := range steps {
+		if s.StepID != step.StepID {
+			out = append(out, s)
+		}
+	}
+	return append(out, step)
+}
@@ -0,0 +1,284 @@
+// CLAUDE:SUMMARY Resumable workflow runs — persisted run input, startup recovery of interrupted runs, resume and retry-from rebuilt from completed step outputs
+package llm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+var (
+	// ErrRunNotFound is returned for an unknown run ID.
+	ErrRunNotFound = errors.New("run not found")
+	// ErrRunActive is returned when resuming a run that is executing.
+	ErrRunActive = errors.New("run is executing")
+	// ErrRunCompleted is returned when resuming a run with nothing left to do.
+	ErrRunCompleted = errors.New("run already completed")
+	// ErrStepNotInRun is returned for a retry from a step its workflow lacks.
+	ErrStepNotInRun = errors.New("step is not part of the run's workflow")
+)
+
+// runInput is what workflow_runs.input_json keeps of a WorkflowInput beyond
+// the run's own columns, so that the run can be resumed as it started.
+type runInput struct {
+	Body           string `json:"body"`
+	UserRole       string `json:"user_role"`
+	TargetProvider string `json:"target_provider,omitempty"`
+	TargetModel    string `json:"target_model,omitempty"`
+	FlowID         string `json:"flow_id,omitempty"`
+	DryRun         bool   `json:"dry_run,omitempty"`
+	TriggerID      string `json:"trigger_id,omitempty"`
+}
+
+func encodeRunInput(in WorkflowInput) string {
+	b, _ := json.Marshal(runInput{
+		Body:           in.Body,
+		UserRole:       in.UserRole,
+		TargetProvider: in.TargetProvider,
+		TargetModel:    in.TargetModel,
+		FlowID:         in.FlowID,
+		DryRun:         in.DryRun,
+		TriggerID:      in.TriggerID,
+	})
+	return string(b)
+}
+
+// runWorkflowInput rebuilds the input a run started from. Runs recorded
+// before input_json get the node ID as body, like ExecuteWorkflow, and the
+// "user" role.
+func runWorkflowInput(run *db.WorkflowRun) WorkflowInput {
+	in := WorkflowInput{RunID: run.RunID, UserID: run.InitiatedBy, UserRole: "user"}
+	if run.NodeID != nil {
+		in.NodeID = *run.NodeID
+		in.Body = *run.NodeID
+	}
+	if run.PrePrompt != nil {
+		in.PrePrompt = *run.PrePrompt
+	}
+	if run.InputJSON != nil {
+		var ri runInput
+		if json.Unmarshal([]byte(*run.InputJSON), &ri) == nil {
+			in.Body, in.UserRole = ri.Body, ri.UserRole
+			in.TargetProvider, in.TargetModel, in.FlowID = ri.TargetProvider, ri.TargetModel, ri.FlowID
+			in.DryRun, in.TriggerID = ri.DryRun, ri.TriggerID
+		}
+	}
+	return in
+}
+
+// claim marks a run as executing in this process and returns the context
+// it executes under, which CancelRun cancels. It is false when the run
+// already is executing.
+func (we *WorkflowEngine) claim(ctx context.Context, runID string) (context.Context, bool) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+	if _, ok := we.active[runID]; ok {
+		return ctx, false
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	we.active[runID] = cancel
+	return ctx, true
+}
+
+func (we *WorkflowEngine) release(runID string) {
+	we.mu.Lock()
+	defer we.mu.Unlock()
+	if cancel, ok := we.active[runID]; ok {
+		cancel(nil)
+		delete(we.active, runID)
+	}
+}
+
+// runWorkflow loads the version of the workflow a run executed: a resumed
+// run goes on with the steps it started with, whatever was activated since.
+func (we *WorkflowEngine) runWorkflow(run *db.WorkflowRun) (*db.Workflow, error) {
+	if run.WorkflowVersion == nil {
+		return we.flowsDB.GetWorkflow(run.WorkflowID)
+	}
+	return we.flowsDB.GetWorkflowVersion(run.WorkflowID, *run.WorkflowVersion)
+}
+
+// traceOffset is the number of flow_steps rows a resumed run already wrote
+// under its flow ID, so that its next steps continue the step_index.
+func (we *WorkflowEngine) traceOffset(flowID string, done map[string]string) int {
+	if flowID == "" || done == nil {
+		return 0
+	}
+	var n int
+	_ = we.flowsDB.QueryRow(`SELECT COUNT(*) FROM flow_steps WHERE flow_id = ?`, flowID).Scan(&n)
+	return n
+}
+
+// CheckResumable reports why runID cannot be resumed now, or retried from
+// fromStepID when it is not "": ErrRunNotFound, ErrRunActive,
+// ErrRunCompleted (a completed run can only be retried from a step) or
+// ErrStepNotInRun.
+func (we *WorkflowEngine) CheckResumable(runID, fromStepID string) error {
+	run, err := we.flowsDB.GetWorkflowRun(runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRunNotFound
+	}
+	if err != nil {
+		return err
+	}
+	we.mu.Lock()
+	_, active := we.active[runID]
+	we.mu.Unlock()
+	if active {
+		return ErrRunActive
+	}
+	if fromStepID == "" {
+		if run.Status == "completed" {
+			return ErrRunCompleted
+		}
+		return nil
+	}
+	wf, err := we.runWorkflow(run)
+	if err != nil {
+		return err
+	}
+	for _, s := range wf.Steps {
+		if s.StepID == fromStepID {
+			return nil
+		}
+	}
+	return ErrStepNotInRun
+}
+
+// ResumeRun goes on with a failed, cancelled or interrupted run: its
+// completed steps keep their outputs, which the other steps see as if they
+// had just run, and only the rest is executed. Paid calls are not repeated.
+func (we *WorkflowEngine) ResumeRun(ctx context.Context, runID string) (*FlowResult, error) {
+	if err := we.CheckResumable(runID, ""); err != nil {
+		return nil, err
+	}
+	return we.resume(ctx, runID, nil)
+}
+
+// RetryFrom resumes a run after discarding the outputs of stepID and of
+// every step depending on it, directly or not, which run again. The other
+// completed steps are kept. A completed run can be retried too.
+func (we *WorkflowEngine) RetryFrom(ctx context.Context, runID, stepID string) (*FlowResult, error) {
+	if err := we.CheckResumable(runID, stepID); err != nil {
+		return nil, err
+	}
+	return we.resume(ctx, runID, func(wf *db.Workflow) error {
+		plan, err := planSteps(wf.Steps)
+		if err != nil {
+			return err
+		}
+		from := -1
+		for i, n := range plan {
+			if n.step.StepID == stepID {
+				from = i
+			}
+		}
+		if from < 0 {
+			return ErrStepNotInRun
+		}
+		var ids, names []string
+		for i, n := range plan {
+			redo := i == from
+			for _, u := range n.upstream {
+				redo = redo || u == from
+			}
+			if redo {
+				ids = append(ids, n.step.StepID)
+				names = append(names, n.step.StepName)
+			}
+		}
+		if err := we.flowsDB.SupersedeStepRuns(runID, ids); err != nil {
+			return fmt.Errorf("discarding step outputs: %w", err)
+		}
+		_ = we.flowsDB.InsertAuditLog(runID, "", "run_retried_from", map[string]interface{}{
+			"step_name": plan[from].step.StepName,
+			"steps":     names,
+		})
+		return nil
+	})
+}
+
+// resume claims the run, lets prepare discard outputs, rebuilds the
+// completed steps' outputs and runs the remaining steps.
+func (we *WorkflowEngine) resume(ctx context.Context, runID string, prepare func(*db.Workflow) error) (*FlowResult, error) {
+	ctx, ok := we.claim(ctx, runID)
+	if !ok {
+		return nil, ErrRunActive
+	}
+	defer we.release(runID)
+
+	run, err := we.flowsDB.GetWorkflowRun(runID)
+	if err != nil {
+		return nil, fmt.Errorf("loading run: %w", err)
+	}
+	wf, err := we.runWorkflow(run)
+	if err != nil {
+		return nil, fmt.Errorf("loading workflow: %w", err)
+	}
+	if err := we.flowsDB.InterruptStepRuns(runID); err != nil {
+		return nil, fmt.Errorf("closing interrupted steps: %w", err)
+	}
+	if prepare != nil {
+		if err := prepare(wf); err != nil {
+			return nil, err
+		}
+	}
+	done, err := we.flowsDB.CompletedStepOutputs(runID)
+	if err != nil {
+		return nil, fmt.Errorf("loading step outputs: %w", err)
+	}
+	if err := we.flowsDB.RestartRun(runID); err != nil {
+		return nil, fmt.Errorf("restarting run: %w", err)
+	}
+	_ = we.flowsDB.InsertAuditLog(runID, "", "run_resumed", map[string]interface{}{
+		"previous_status": run.Status,
+		"completed_steps": len(done),
+	})
+	we.logger.Info("workflow run resumed", "run_id", runID, "workflow", wf.Name, "completed_steps", len(done))
+	return we.runSteps(ctx, wf, runWorkflowInput(run), done)
+}
+
+// RecoverRuns handles the runs a previous process left pending or running.
+// With policy "resume" they go on in the background from their completed
+// steps; with "fail" they are marked failed. Runs standing in for a
+// challenge are always failed, and their challenge with them: the
+// challenge runner waiting for them is gone.
+func (we *WorkflowEngine) RecoverRuns(policy string) (resumed, failed int, err error) {
+	ids, err := we.flowsDB.ListInterruptedRuns()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, id := range ids {
+		run, err := we.flowsDB.GetWorkflowRun(id)
+		if err != nil {
+			we.logger.Warn("loading interrupted run", "run_id", id, "error", err)
+			continue
+		}
+		if policy == "resume" && runWorkflowInput(run).FlowID == "" {
+			resumed++
+			// Like runs started from the API, a resumed run is not tied to
+			// the process' context: a shutdown leaves it to the next recovery.
+			go func(id string) {
+				if _, err := we.ResumeRun(context.Background(), id); err != nil {
+					we.logger.Warn("resuming workflow run", "run_id", id, "error", err)
+				}
+			}(id)
+			continue
+		}
+		failed++
+		_ = we.flowsDB.InterruptStepRuns(id)
+		errMsg := "interrupted by restart"
+		_ = we.flowsDB.UpdateRunStatus(id, "failed", nil, &errMsg)
+		if runWorkflowInput(run).FlowID != "" && we.nodes != nil {
+			if err := we.nodes.FailChallengeOfRun(id, errMsg); err != nil {
+				we.logger.Warn("failing interrupted challenge", "run_id", id, "error", err)
+			}
+		}
+		_ = we.flowsDB.InsertAuditLog(id, "", "run_interrupted", map[string]string{"policy": policy})
+	}
+	return resumed, failed, nil
+}
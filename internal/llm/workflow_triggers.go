@@ -0,0 +1,229 @@
+// CLAUDE:SUMMARY Workflow triggers — starts runs of active workflows on cron schedules and domain events (node created, temperature turning hot, source added, challenge completed) as their run-as user, within their rate limits, recording every firing
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// TriggerEvent is a domain event workflow triggers start runs on. Type is
+// a trigger type other than cron; NodeID is the node concerned: the new
+// node, the node turned hot, the node a source was added to or the node a
+// challenge ran on.
+type TriggerEvent struct {
+	Type   string
+	NodeID string
+}
+
+// TriggerRunner fires the workflow triggers: event triggers as events are
+// reported (see Fire), cron triggers as they fall due. Firings are
+// serialized so that each one sees the runs of the previous ones when its
+// rate limit is checked.
+type TriggerRunner struct {
+	engine   *WorkflowEngine
+	flowsDB  *db.FlowsDB
+	database *db.DB
+	logger   *slog.Logger
+	queue    chan TriggerEvent
+
+	mu sync.Mutex
+}
+
+// NewTriggerRunner creates a trigger runner starting its runs on engine.
+func NewTriggerRunner(engine *WorkflowEngine, database *db.DB, logger *slog.Logger) *TriggerRunner {
+	return &TriggerRunner{
+		engine:   engine,
+		flowsDB:  engine.flowsDB,
+		database: database,
+		logger:   logger,
+		queue:    make(chan TriggerEvent, 256),
+	}
+}
+
+// Fire reports an event. It never blocks: the event is dropped when the
+// queue is full.
+func (tr *TriggerRunner) Fire(ev TriggerEvent) {
+	select {
+	case tr.queue <- ev:
+	default:
+		tr.logger.Warn("trigger event dropped, queue full", "event", ev.Type, "node_id", ev.NodeID)
+	}
+}
+
+// NodeCreated reports a new node, for the node_created triggers, unless a
+// run started by a trigger wrote it: runs writing nodes would otherwise
+// start each other without end.
+func (tr *TriggerRunner) NodeCreated(node *db.Node) {
+	var meta struct {
+		RunID string `json:"workflow_run_id"`
+	}
+	if json.Unmarshal([]byte(node.Metadata), &meta) == nil && meta.RunID != "" {
+		if run, err := tr.flowsDB.GetWorkflowRun(meta.RunID); err == nil && runWorkflowInput(run).TriggerID != "" {
+			return
+		}
+	}
+	tr.Fire(TriggerEvent{Type: "node_created", NodeID: node.ID})
+}
+
+// Run handles reported events as they arrive and fires the cron triggers
+// that are due every interval, until ctx is done. The runs started live
+// under ctx. A cron trigger that fell due while the server was down fires
+// once at startup.
+func (tr *TriggerRunner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	tr.runDue(ctx, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-tr.queue:
+			tr.handle(ctx, ev)
+		case now := <-ticker.C:
+			tr.runDue(ctx, now)
+		}
+	}
+}
+
+func (tr *TriggerRunner) handle(ctx context.Context, ev TriggerEvent) {
+	triggers, err := tr.flowsDB.ListEventTriggers(ev.Type)
+	if err != nil {
+		tr.logger.Warn("listing triggers", "event", ev.Type, "error", err)
+		return
+	}
+	if len(triggers) == 0 {
+		return
+	}
+	node, err := tr.database.GetNode(ev.NodeID)
+	if err != nil {
+		tr.logger.Warn("trigger event on unknown node", "event", ev.Type, "node_id", ev.NodeID, "error", err)
+		return
+	}
+	tags, _ := tr.database.GetTagsForNode(node.ID)
+	if node.RootID != "" && node.RootID != node.ID {
+		rootTags, _ := tr.database.GetTagsForNode(node.RootID)
+		tags = append(tags, rootTags...)
+	}
+	for _, t := range triggers {
+		if t.Tag != "" && !containsFold(tags, t.Tag) {
+			continue
+		}
+		tr.FireTrigger(ctx, t, ev.Type, node.ID, node.Body)
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// runDue fires the cron triggers due at now, each once however many of
+// its times went by, and schedules their next time.
+func (tr *TriggerRunner) runDue(ctx context.Context, now time.Time) {
+	due, err := tr.flowsDB.DueCronTriggers(now)
+	if err != nil {
+		tr.logger.Warn("listing due cron triggers", "error", err)
+		return
+	}
+	for _, t := range due {
+		var next *time.Time
+		if sched, err := ParseCron(t.Schedule); err == nil {
+			if n := sched.Next(now); !n.IsZero() {
+				next = &n
+			}
+		}
+		// Rescheduled first: a trigger failing to fire is not retried
+		// every tick.
+		if err := tr.flowsDB.SetTriggerNextRun(t.TriggerID, next); err != nil {
+			tr.logger.Warn("scheduling cron trigger", "trigger_id", t.TriggerID, "error", err)
+			continue
+		}
+		tr.FireTrigger(ctx, t, "cron", t.NodeID, t.Body)
+	}
+}
+
+// FireTrigger starts a run of t's workflow for an event (or "cron", or
+// "manual") on nodeID with body, and records the firing in t's history:
+// fired with its run, rate_limited, skipped when the workflow is not
+// active, or failed.
+func (tr *TriggerRunner) FireTrigger(ctx context.Context, t db.WorkflowTrigger, eventType, nodeID, body string) *db.TriggerFire {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	f := &db.TriggerFire{
+		FireID:    db.NewID(),
+		TriggerID: t.TriggerID,
+		EventType: eventType,
+		NodeID:    nodeID,
+	}
+	f.RunID, f.Status, f.Reason = tr.start(ctx, t, nodeID, body)
+	if err := tr.flowsDB.RecordTriggerFire(f); err != nil {
+		tr.logger.Warn("recording trigger fire", "trigger_id", t.TriggerID, "error", err)
+	}
+	if f.Status == "fired" {
+		_ = tr.flowsDB.InsertAuditLog(f.RunID, "", "trigger_fired", map[string]interface{}{
+			"trigger_id":  t.TriggerID,
+			"workflow_id": t.WorkflowID,
+			"event_type":  eventType,
+			"node_id":     nodeID,
+			"run_as":      t.RunAs,
+		})
+	} else {
+		tr.logger.Info("trigger not fired", "trigger_id", t.TriggerID, "status", f.Status, "reason", f.Reason)
+	}
+	return f
+}
+
+// start starts the run and returns its ID, or the status and reason of a
+// firing that started none.
+func (tr *TriggerRunner) start(ctx context.Context, t db.WorkflowTrigger, nodeID, body string) (runID, status, reason string) {
+	wf, err := tr.flowsDB.GetRunnableWorkflow(t.WorkflowID)
+	if err != nil {
+		return "", "failed", "workflow: " + err.Error()
+	}
+	if wf.ActiveVersion == nil {
+		return "", "skipped", "workflow is " + wf.Status
+	}
+	n, err := tr.flowsDB.CountTriggerRuns(t.TriggerID, t.RateWindowSec)
+	if err != nil {
+		return "", "failed", "rate limit: " + err.Error()
+	}
+	if n >= t.RateLimit {
+		return "", "rate_limited", fmt.Sprintf("%d runs in the last %ds", n, t.RateWindowSec)
+	}
+	user, err := tr.database.GetUserByID(t.RunAs)
+	if err != nil {
+		return "", "failed", "run-as user " + t.RunAs + " not found"
+	}
+	if body == "" && nodeID != "" {
+		if node, err := tr.database.GetNode(nodeID); err == nil {
+			body = node.Body
+		}
+	}
+	if body == "" {
+		body = nodeID // placeholder, as in ExecuteWorkflow
+	}
+	runID, err = tr.engine.Start(ctx, wf, WorkflowInput{
+		NodeID:    nodeID,
+		Body:      body,
+		PrePrompt: t.PrePrompt,
+		UserID:    user.ID,
+		UserRole:  user.Role,
+		TriggerID: t.TriggerID,
+	})
+	if err != nil {
+		return "", "failed", "starting run: " + err.Error()
+	}
+	return runID, "fired", ""
+}
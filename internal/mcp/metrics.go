@@ -0,0 +1,46 @@
+// CLAUDE:SUMMARY MCP tool-call metrics — receiving middleware recording duration and outcome of every tools/call to metrics.db
+package mcp
+
+import (
+	"context"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+
+	"github.com/hazyhaar/horostracker/internal/db"
+)
+
+// RecordToolCalls installs a middleware on srv that records every tool
+// call (core and dynamic tools alike) to metrics.db mcp_calls. A call
+// fails if the handler errors or the tool reports IsError.
+func RecordToolCalls(srv *mcp.Server, metricsDB *db.MetricsDB, transport string) {
+	srv.AddReceivingMiddleware(func(next mcp.MethodHandler) mcp.MethodHandler {
+		return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+			call, ok := req.(*mcp.CallToolRequest)
+			if !ok || method != "tools/call" {
+				return next(ctx, method, req)
+			}
+
+			start := time.Now()
+			res, err := next(ctx, method, req)
+
+			success := err == nil
+			if tr, ok := res.(*mcp.CallToolResult); ok && tr != nil && tr.IsError {
+				success = false
+			}
+			metricsDB.RecordMCPCall(call.Params.Name, int(time.Since(start).Milliseconds()),
+				success, toolCallUserID(call), transport)
+			return res, err
+		}
+	})
+}
+
+// toolCallUserID picks the acting user from the tool arguments; core tools
+// take it as author_id (ask/answer) or user_id (vote).
+func toolCallUserID(req *mcp.CallToolRequest) string {
+	args := decodeArgs(req)
+	if id := stringArg(args, "author_id"); id != "" {
+		return id
+	}
+	return stringArg(args, "user_id")
+}
@@ -1,4 +1,4 @@
-// CLAUDE:SUMMARY Entry point for horostracker server — CLI dispatch (serve, version, help), HTTP server startup with graceful shutdown
+// CLAUDE:SUMMARY Entry point for horostracker server — CLI dispatch (serve, import-workflow, version, help), HTTP server startup with graceful shutdown
 package main
 
 import (
@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
@@ -37,6 +38,8 @@ func main() {
 	switch os.Args[1] {
 	case "serve":
 		cmdServe(os.Args[2:])
+	case "import-workflow":
+		cmdImportWorkflow(os.Args[2:])
 	case "version":
 		fmt.Printf("horostracker %s\n", version)
 	case "help", "--help", "-h":
@@ -53,13 +56,105 @@ func printUsage() {
 
 Usage:
   horostracker serve [--config config.toml] [--addr :8080]
+  horostracker import-workflow [--config config.toml] [--owner handle] [--name name] bundle.{json,toml}
   horostracker version
   horostracker help
 
 Commands:
-  serve     Start the server (TCP/HTTP2 + QUIC/HTTP3 + MCP)
-  version   Print version
-  help      Show this help`)
+  serve            Start the server (TCP/HTTP2 + QUIC/HTTP3 + MCP)
+  import-workflow  Import a signed workflow bundle, pending validation
+  version          Print version
+  help             Show this help`)
+}
+
+// cmdImportWorkflow imports a workflow bundle into the configured databases
+// as the server's import endpoint would, on behalf of --owner (the bot by
+// default).
+func cmdImportWorkflow(args []string) {
+	fs := flag.NewFlagSet("import-workflow", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to config.toml")
+	owner := fs.String("owner", "", "handle of the imported workflow's owner (default: the bot)")
+	name := fs.String("name", "", "import under this name instead of the bundle's")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: horostracker import-workflow [--config config.toml] [--owner handle] [--name name] bundle.{json,toml}")
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loading config: %v\n", err)
+		os.Exit(1)
+	}
+	if *owner == "" {
+		*owner = cfg.Bot.Handle
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	database, err := db.Open(cfg.Database.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+	flowsDB, err := db.OpenFlows(cfg.Database.FlowsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "opening flows database: %v\n", err)
+		os.Exit(1) //nolint:gocritic // exitAfterDefer acceptable in main()
+	}
+	defer flowsDB.Close()
+
+	user, _, err := database.GetUserByHandle(*owner)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "owner %s: %v\n", *owner, err)
+		os.Exit(1)
+	}
+	role := user.Role
+	if user.IsBot {
+		role = "operator" // as for the core workflows the bot owns
+	}
+
+	keys, err := llm.LoadBundleKeys(flowsDB, bundleKeyPath(cfg.Federation), cfg.Federation.TrustedKeys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loading bundle keys: %v\n", err)
+		os.Exit(1)
+	}
+	bundle, err := llm.ParseBundle(data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	imported, err := llm.ImportBundle(flowsDB, keys, bundle, user.ID, role, *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("imported %q as workflow %s (pending validation, %d steps, owner %s)\n",
+		imported.Workflow.Name, imported.Workflow.WorkflowID, len(imported.Workflow.Steps), *owner)
+	for _, cl := range imported.CriteriaLists {
+		if cl.Reused {
+			fmt.Printf("  criteria list %q: reused %s\n", cl.Name, cl.ListID)
+		} else {
+			fmt.Printf("  criteria list %q: created %s\n", cl.Name, cl.ListID)
+		}
+	}
+	for _, warning := range imported.Warnings {
+		fmt.Printf("  warning: %s\n", warning)
+	}
+}
+
+// bundleKeyPath is the federation signing key when it is an Ed25519 one;
+// workflow bundles otherwise use a key kept in flows.db.
+func bundleKeyPath(fed config.FederationConfig) string {
+	if strings.EqualFold(fed.SignatureAlgo, "Ed25519") {
+		return fed.PrivateKeyPath
+	}
+	return ""
 }
 
 func cmdServe(args []string) {
@@ -130,13 +225,39 @@ func cmdServe(args []string) {
 	go registry.RunWatcher(ctx)
 
 	// --- LLM client + flow engine + resolution + challenges + replay ---
-	llmClient := llm.NewFromConfig(cfg.LLM)
+	llmClient, err := llm.NewFromConfig(cfg.LLM)
+	if err != nil {
+		logger.Error("configuring LLM client", "error", err)
+		os.Exit(1)
+	}
+	if cas := llmClient.Cassette(); cas != nil && llmClient.Mode() == llm.ModeReplay {
+		cas.UseFlowSteps(flowsDB)
+		logger.Info("LLM replay mode", "cassette", cfg.LLM.Cassette, "recordings", cas.Len())
+	}
+	llmClient.SetLedger(llm.NewLedger(flowsDB))
+	llmClient.Instrument(metricsDB)
+	if cfg.LLM.Cache.Enabled {
+		llmClient.SetCache(llm.NewCache(flowsDB, time.Duration(cfg.LLM.Cache.TTLHours)*time.Hour))
+	}
+	tools := llm.NewDBToolset(database)
 	flowEngine := llm.NewFlowEngine(llmClient, flowsDB, logger)
+	flowEngine.SetTools(tools)
 	resEngine := llm.NewResolutionEngine(llmClient, flowsDB, logger)
 	challengeRunner := llm.NewChallengeRunner(flowEngine, database, logger)
+	flowRegistry := llm.NewFlowRegistry(cfg.LLM.Flows.Dir, logger)
+	flowRegistry.SetTools(tools)
+	if err := flowRegistry.Reload(); err != nil {
+		logger.Warn("reading flow files", "dir", cfg.LLM.Flows.Dir, "error", err)
+	}
+	llm.UseFlowRegistry(flowRegistry)
 	replayEngine := llm.NewReplayEngine(llmClient, flowsDB, logger)
 	workflowEngine := llm.NewWorkflowEngine(llmClient, flowsDB, logger)
+	workflowEngine.SetTools(tools)
+	workflowEngine.SetNodeStore(database)
+	challengeRunner.SetWorkflowEngine(workflowEngine)
 	modelDiscovery := llm.NewModelDiscovery(flowsDB, llmClient, logger)
+	modelDiscovery.SetProbing(cfg.LLM.Discovery.Probe, cfg.LLM.Discovery.MaxProbes)
+	modelDiscovery.SetRegistry(database)
 
 	providerCount := len(llmClient.Providers())
 	if providerCount > 0 {
@@ -148,6 +269,7 @@ func cmdServe(args []string) {
 	// --- MCP server (core tools + dynamic tools) ---
 	mcpServer := horosmcp.NewServer(database, auditLog)
 	mcprt.Bridge(mcpServer, registry)
+	horosmcp.RecordToolCalls(mcpServer, metricsDB, "quic")
 
 	// --- Bot user (auto-create @horostracker) ---
 	var botUserID string
@@ -168,21 +290,88 @@ func cmdServe(args []string) {
 	if botUserID != "" {
 		llm.SeedCoreWorkflows(flowsDB, botUserID, logger)
 	}
-	go modelDiscovery.DiscoverAll(ctx)
+	go modelDiscovery.Run(ctx, time.Duration(cfg.LLM.Discovery.IntervalMinutes)*time.Minute)
+	if cfg.LLM.Flows.ReloadSeconds > 0 {
+		go flowRegistry.Watch(ctx, time.Duration(cfg.LLM.Flows.ReloadSeconds)*time.Second)
+	}
+	go metricsDB.RunDailyRollup(ctx, 15*time.Minute)
+
+	// --- Self-registered providers → live LLM backends ---
+	var registeredProviders *llm.RegisteredProviders
+	if cfg.LLM.Registered.Enabled {
+		registeredProviders = llm.NewRegisteredProviders(database, flowsDB, llmClient,
+			time.Duration(cfg.LLM.Registered.StaleMinutes)*time.Minute, logger)
+		interval := time.Duration(cfg.LLM.Registered.SyncSeconds) * time.Second
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		// Synced once before Run so that the workflow runs resumed below
+		// find their registered providers.
+		if err := registeredProviders.Sync(); err != nil {
+			logger.Warn("syncing registered providers", "error", err)
+		}
+		go registeredProviders.Run(ctx, interval)
+	}
+
+	// --- Workflow runs left unfinished by the previous process ---
+	if resumed, failed, err := workflowEngine.RecoverRuns(cfg.LLM.Workflows.OnRestart); err != nil {
+		logger.Error("recovering workflow runs", "error", err)
+	} else if resumed+failed > 0 {
+		logger.Info("interrupted workflow runs", "resumed", resumed, "failed", failed, "policy", cfg.LLM.Workflows.OnRestart)
+	}
+
+	// --- Workflow triggers (cron schedules, domain events) ---
+	triggers := llm.NewTriggerRunner(workflowEngine, database, logger)
+	challengeRunner.SetTriggers(triggers)
+	database.OnNodeCreated(triggers.NodeCreated)
+	triggerInterval := time.Duration(cfg.LLM.Workflows.TriggerSeconds) * time.Second
+	if triggerInterval <= 0 {
+		triggerInterval = 30 * time.Second
+	}
+	go triggers.Run(ctx, triggerInterval)
+
+	// --- Vector index (embeddings of public nodes and sources) ---
+	var indexer *llm.Indexer
+	if cfg.LLM.Embeddings.Enabled {
+		if cfg.LLM.Embeddings.Local {
+			local, err := llm.LoadLocalEmbedder(database, logger)
+			if err != nil {
+				logger.Error("loading local embedder", "error", err)
+			} else {
+				llmClient.SetLocalEmbedder(local)
+				logger.Info("local embedder ready", "model", local.EmbeddingModel())
+			}
+		}
+		indexer = llm.NewIndexer(llmClient, database, cfg.LLM.Embeddings.Model, cfg.LLM.Embeddings.BatchSize, logger)
+		interval := time.Duration(cfg.LLM.Embeddings.IntervalSeconds) * time.Second
+		if interval <= 0 {
+			interval = 5 * time.Minute
+		}
+		go indexer.Run(ctx, interval)
+	}
 
 	// --- HTTP mux (API + static) ---
 	a := auth.New(cfg.Auth.JWTSecret, cfg.Auth.TokenExpiryMin)
 	apiHandler := api.New(database, a)
 	apiHandler.SetResolutionEngine(resEngine)
 	apiHandler.SetChallengeRunner(challengeRunner)
+	apiHandler.SetFlowRegistry(flowRegistry)
 	apiHandler.SetReplayEngine(replayEngine)
 	apiHandler.SetWorkflowEngine(workflowEngine)
 	apiHandler.SetModelDiscovery(modelDiscovery)
 	apiHandler.SetFlowsDB(flowsDB, cfg.Database.FlowsPath)
 	apiHandler.SetMetricsDB(metricsDB, cfg.Database.MetricsPath)
 	apiHandler.SetLLMClient(llmClient)
+	apiHandler.SetRegisteredProviders(registeredProviders)
+	apiHandler.SetIndexer(indexer)
+	apiHandler.SetTriggerRunner(triggers)
 	apiHandler.SetBotUserID(botUserID)
 	apiHandler.SetFederationConfig(cfg.Federation, cfg.Instance)
+	if bundleKeys, keyErr := llm.LoadBundleKeys(flowsDB, bundleKeyPath(cfg.Federation), cfg.Federation.TrustedKeys); keyErr != nil {
+		logger.Warn("workflow bundles disabled", "error", keyErr)
+	} else {
+		apiHandler.SetBundleKeys(bundleKeys)
+	}
 
 	mux := http.NewServeMux()
 	apiHandler.RegisterRoutes(mux)
@@ -217,7 +406,7 @@ func cmdServe(args []string) {
 	_ = flowsDB.QueryRow("SELECT COUNT(*) FROM flow_steps").Scan(&flowStepCount)
 	workflowCount := flowsDB.CountWorkflows()
 
-	handler := api.SecurityHeaders(mux)
+	handler := api.SecurityHeaders(apiHandler.RequestMetrics(mux))
 	errCh := make(chan error, 1)
 	var shutdownFn func()
 
@@ -1306,8 +1306,8 @@
 
   // ===== VACF Workflows =====
 
-  const STEP_TYPES = ['llm', 'sql', 'http', 'check'];
-  const STEP_TYPE_COLORS = { llm: 'var(--accent)', sql: 'var(--green)', http: 'var(--orange)', check: 'var(--purple)' };
+  const STEP_TYPES = ['llm', 'sql', 'http', 'check', 'node_write'];
+  const STEP_TYPE_COLORS = { llm: 'var(--accent)', sql: 'var(--green)', http: 'var(--orange)', check: 'var(--purple)', node_write: 'var(--red)' };
   const WF_TYPES = ['decompose','critique','source','factcheck','analyse','synthese','reformulation','media_export','contradiction_detection','completude','traduction','classification_epistemique','workflow_validation','model_discovery'];
 
   function workflowNav(role) {
@@ -1810,7 +1810,7 @@
 
   function showWorkflowEditor(wf, role) {
     const container = document.getElementById('wf-list');
-    const allowedTypes = role === 'operator' ? ['llm','check'] : role === 'provider' ? ['llm','check','sql'] : STEP_TYPES;
+    const allowedTypes = role === 'operator' ? ['llm','check','node_write'] : role === 'provider' ? ['llm','check','sql'] : STEP_TYPES;
     container.innerHTML = `
       <h3>${wf ? 'Edit' : 'New'} Workflow</h3>
       <div class="form-group"><label>Name</label><input id="wf-name" class="search-input" value="${esc((wf||{}).name||'')}" style="width:100%"></div>
@@ -1844,7 +1844,7 @@
 
   async function showStepEditor(wfId, step, role) {
     const container = document.getElementById('wf-list');
-    const allowedTypes = role === 'operator' ? ['llm','check'] : role === 'provider' ? ['llm','check','sql'] : STEP_TYPES;
+    const allowedTypes = role === 'operator' ? ['llm','check','node_write'] : role === 'provider' ? ['llm','check','sql'] : STEP_TYPES;
 
     // Load allowed models for dropdown
     let modelOptions = '<option value="">-- no model --</option>';